@@ -0,0 +1,200 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consrv
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gliderlabs/ssh"
+)
+
+// approvalTimeout is the time a session waits for approval before it exits.
+const approvalTimeout = 5 * time.Minute
+
+// An Approval is a session waiting for a second identity to approve its
+// attachment to a device configured by ServerConfig.RequireApproval.
+type Approval struct {
+	// ID identifies the request for approval of a session to Device.
+	ID int
+
+	// Device is the name of the device.
+	Device string
+
+	// Identity is the name of the identity which opened the session.
+	Identity string
+
+	// Address is the remote address of the SSH client.
+	Address string
+
+	// Reason is the reason entered for the session, if any.
+	Reason string
+
+	// Requested is the time the session began waiting for approval.
+	Requested time.Time
+}
+
+// approvals tracks the sessions waiting for approval.
+type approvals struct {
+	mu      sync.Mutex
+	next    int
+	pending map[int]*approval
+}
+
+// An approval is a pending Approval which receives the name of its approver.
+type approval struct {
+	Approval
+	done chan string
+}
+
+// request registers a session of identity id at addr waiting for approval to
+// attach to device for reason. The caller must call cancel once it stops
+// waiting.
+func (as *approvals) request(device, id, addr, reason string) *approval {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+
+	if as.pending == nil {
+		as.pending = make(map[int]*approval)
+	}
+
+	as.next++
+	a := &approval{
+		Approval: Approval{
+			ID:        as.next,
+			Device:    device,
+			Identity:  id,
+			Address:   addr,
+			Reason:    reason,
+			Requested: time.Now(),
+		},
+		done: make(chan string, 1),
+	}
+	as.pending[a.ID] = a
+
+	return a
+}
+
+// cancel removes a from the pending approvals.
+func (as *approvals) cancel(a *approval) {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+
+	delete(as.pending, a.ID)
+}
+
+// approve approves pending request n for device on behalf of identity id.
+func (as *approvals) approve(device string, n int, id string) error {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+
+	a, ok := as.pending[n]
+	if !ok || a.Device != device {
+		return fmt.Errorf("no pending approval %d for %q", n, device)
+	}
+	if a.Identity == id {
+		return errors.New("sessions must be approved by a second identity")
+	}
+
+	delete(as.pending, n)
+	a.done <- id
+	return nil
+}
+
+// list returns the pending approvals, oldest first.
+func (as *approvals) list() []Approval {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+
+	out := make([]Approval, 0, len(as.pending))
+	for _, a := range as.pending {
+		out = append(out, a.Approval)
+	}
+	slices.SortFunc(out, func(a, b Approval) int { return a.ID - b.ID })
+
+	return out
+}
+
+// Approvals returns the sessions waiting for approval, oldest first.
+func (s *Server) Approvals() []Approval { return s.approvals.list() }
+
+// Approve approves the pending request id for a session to attach to device,
+// on behalf of the named identity, which must be allowed to access device and
+// must not be the identity which opened the session. It is safe for
+// concurrent use with Serve.
+func (s *Server) Approve(device string, id int, identity string) error {
+	f, ok := s.identities().toFingerprint[identity]
+	if !ok || !s.identities().allowed(device, f) {
+		return fmt.Errorf("identity %q may not access %q", identity, device)
+	}
+
+	if err := s.approvals.approve(device, id, identity); err != nil {
+		return err
+	}
+
+	s.ll.Printf("%s approved session %d on device %q", identity, id, device)
+	return nil
+}
+
+// awaitApproval blocks the session of identity id with an optional reason
+// until a second identity approves its use of device, the session ends, or the
+// request times out.
+func (s *Server) awaitApproval(session ssh.Session, device, id, reason string) error {
+	a := s.approvals.request(device, id, addrString(session.RemoteAddr()), reason)
+	defer s.approvals.cancel(a)
+
+	s.logf(session, "%q requires approval by a second identity, waiting up to %s", device, approvalTimeout)
+	s.logf(session, "approve with: ssh consrv@<host> approve %s %d", device, a.ID)
+	msg := fmt.Sprintf("approve %s %d", device, a.ID)
+	if reason != "" {
+		msg = fmt.Sprintf("%s, reason: %q", msg, reason)
+	}
+
+	s.Publish(Event{
+		Type:     EventApprovalRequest,
+		Device:   device,
+		Identity: id,
+		Address:  a.Address,
+		Message:  msg,
+	})
+
+	timer := time.NewTimer(approvalTimeout)
+	defer timer.Stop()
+
+	select {
+	case approver := <-a.done:
+		s.logf(session, "approved by %s", approver)
+		return nil
+	case <-session.Context().Done():
+		return session.Context().Err()
+	case <-timer.C:
+		return errors.New("timed out waiting for approval")
+	}
+}
+
+// listApprovals prints the pending approvals for the devices which the session
+// may access.
+func (s *Server) listApprovals(session ssh.Session) {
+	for _, a := range s.approvals.list() {
+		if !s.allowed(session.Context(), a.Device) {
+			continue
+		}
+
+		fmt.Fprintf(session, "%d device=%q identity=%q address=%q requested=%q reason=%q\n",
+			a.ID, a.Device, a.Identity, a.Address, a.Requested.Format(time.RFC3339), a.Reason)
+	}
+}
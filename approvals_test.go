@@ -0,0 +1,144 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consrv
+
+import (
+	"bufio"
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSSHRequireApproval(t *testing.T) {
+	infoC := make(chan SessionInfo, 1)
+	srv, addr := testServe(t, ServerConfig{
+		HostKey: []byte(strings.TrimSpace(testHostPrivate)),
+		Devices: map[string]*MuxDevice{
+			"foo": NewMuxDevice(&testDevice{}),
+		},
+		RequireApproval: map[string]bool{"foo": true},
+		Identities: mustIdentities([]Identity{
+			{
+				Name:      "test",
+				PublicKey: mustKey(testClientPublic),
+			},
+			{
+				Name:      "other",
+				PublicKey: mustKey(testPublicA),
+			},
+		}, nil),
+		OnAttach: func(_ context.Context, info SessionInfo) {
+			infoC <- info
+		},
+	})
+
+	s := testDial(t, addr, "foo", mustKey(testHostPublic))
+	stderr, err := s.StderrPipe()
+	if err != nil {
+		t.Fatalf("failed to get stderr: %v", err)
+	}
+	if err := s.Shell(); err != nil {
+		t.Fatalf("failed to start shell: %v", err)
+	}
+
+	br := bufio.NewReader(stderr)
+	readLine := func() string {
+		t.Helper()
+
+		line, err := br.ReadString('\n')
+		if err != nil {
+			t.Fatalf("failed to read line: %v", err)
+		}
+		return line
+	}
+
+	want := []string{
+		"consrv> \"foo\" requires approval by a second identity, waiting up to 5m0s\n",
+		"consrv> approve with: ssh consrv@<host> approve foo 1\n",
+	}
+	if diff := cmp.Diff(want, []string{readLine(), readLine()}); diff != "" {
+		t.Fatalf("unexpected approval prompt (-want +got):\n%s", diff)
+	}
+
+	// The address and request time vary between runs.
+	var got []Approval
+	for _, a := range srv.Approvals() {
+		got = append(got, Approval{ID: a.ID, Device: a.Device, Identity: a.Identity})
+	}
+
+	if diff := cmp.Diff([]Approval{{ID: 1, Device: "foo", Identity: "test"}}, got); diff != "" {
+		t.Fatalf("unexpected approvals (-want +got):\n%s", diff)
+	}
+
+	tests := []struct {
+		name, cmd string
+		want      *regexp.Regexp
+	}{
+		{
+			name: "list",
+			cmd:  "approvals",
+			want: regexp.MustCompile(`^1 device="foo" identity="test" address=".+" requested=".+" reason=""\n$`),
+		},
+		{
+			name: "same identity",
+			cmd:  "approve foo 1",
+			want: regexp.MustCompile(`^consrv> approve: sessions must be approved by a second identity\n$`),
+		},
+		{
+			name: "unknown approval",
+			cmd:  "approve foo 2",
+			want: regexp.MustCompile(`^consrv> approve: no pending approval 2 for "foo"\n$`),
+		},
+		{
+			name: "bad approval",
+			cmd:  "approve foo one",
+			want: regexp.MustCompile(`^consrv> approve: invalid approval "one"\n$`),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, _ := testDial(t, addr, "consrv", mustKey(testHostPublic)).CombinedOutput(tt.cmd)
+			if !tt.want.Match(b) {
+				t.Fatalf("unexpected output for %q: %q", tt.cmd, b)
+			}
+		})
+	}
+
+	if err := srv.Approve("foo", 1, "nobody"); err == nil {
+		t.Fatal("expected an error approving as an unknown identity, but none occurred")
+	}
+	if err := srv.Approve("foo", 1, "other"); err != nil {
+		t.Fatalf("failed to approve: %v", err)
+	}
+
+	want = []string{
+		"consrv> approved by other\n",
+		"consrv> opened serial connection test\n",
+	}
+	if diff := cmp.Diff(want, []string{readLine(), readLine()}); diff != "" {
+		t.Fatalf("unexpected approved output (-want +got):\n%s", diff)
+	}
+
+	info := <-infoC
+	if diff := cmp.Diff("test", info.Identity); diff != "" {
+		t.Fatalf("unexpected attached identity (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(0, len(srv.Approvals())); diff != "" {
+		t.Fatalf("unexpected pending approvals (-want +got):\n%s", diff)
+	}
+}
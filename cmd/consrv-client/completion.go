@@ -0,0 +1,80 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// bashCompletion is a bash completion script for consrv-client.
+const bashCompletion = `_consrv_client() {
+	local cur=${COMP_WORDS[COMP_CWORD]}
+	if [[ $COMP_CWORD -eq 1 ]]; then
+		COMPREPLY=($(compgen -W "list connect logs completion" -- "$cur"))
+		return
+	fi
+
+	case ${COMP_WORDS[1]} in
+	connect|logs)
+		COMPREPLY=($(compgen -W "$(consrv-client list -q 2>/dev/null)" -- "$cur"))
+		;;
+	completion)
+		COMPREPLY=($(compgen -W "bash zsh" -- "$cur"))
+		;;
+	esac
+}
+complete -F _consrv_client consrv-client
+`
+
+// zshCompletion is a zsh completion script for consrv-client.
+const zshCompletion = `#compdef consrv-client
+_consrv_client() {
+	if (( CURRENT == 2 )); then
+		_values 'command' list connect logs completion
+		return
+	fi
+
+	case $words[2] in
+	connect|logs)
+		local -a devices
+		devices=(${(f)"$(consrv-client list -q 2>/dev/null)"})
+		compadd -a devices
+		;;
+	completion)
+		_values 'shell' bash zsh
+		;;
+	esac
+}
+compdef _consrv_client consrv-client
+`
+
+// completion writes the completion script for the shell named in args to w.
+func completion(w io.Writer, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: consrv-client completion <bash|zsh>")
+	}
+
+	switch args[0] {
+	case "bash":
+		_, err := io.WriteString(w, bashCompletion)
+		return err
+	case "zsh":
+		_, err := io.WriteString(w, zshCompletion)
+		return err
+	default:
+		return fmt.Errorf("unsupported shell %q", args[0])
+	}
+}
@@ -0,0 +1,171 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// A config is the consrv-client configuration.
+type config struct {
+	Servers []server `toml:"servers"`
+}
+
+// A server is a consrv server which the client may connect to.
+type server struct {
+	Name         string            `toml:"name"`
+	Address      string            `toml:"address"`
+	IdentityFile string            `toml:"identity_file"`
+	Devices      []string          `toml:"devices"`
+	EscapeChar   string            `toml:"escape_char"`
+	EscapeChars  map[string]string `toml:"escape_chars"`
+}
+
+// escapeNone disables the ssh escape character, so every byte is passed
+// through to the device.
+const escapeNone = "none"
+
+// validEscape reports whether s is a valid ssh escape character: a single
+// character, a control character written as "^" and a letter, or escapeNone.
+func validEscape(s string) bool {
+	switch {
+	case s == escapeNone:
+		return true
+	case len(s) == 1:
+		return s[0] > ' ' && s[0] <= '~'
+	case len(s) == 2 && s[0] == '^':
+		return s[1] >= '@' && s[1] <= '_' || s[1] >= 'a' && s[1] <= 'z'
+	default:
+		return false
+	}
+}
+
+// escape returns the ssh escape character configured for device, or empty if
+// the ssh default should be used.
+func (s *server) escape(device string) string {
+	if e, ok := s.EscapeChars[device]; ok {
+		return e
+	}
+
+	return s.EscapeChar
+}
+
+// defaultPort is the consrv SSH port used if an address does not specify one.
+const defaultPort = "2222"
+
+// defaultConfigPath returns the default path to the client configuration file.
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "client.toml"
+	}
+
+	return filepath.Join(dir, "consrv", "client.toml")
+}
+
+// parseConfig parses a TOML configuration file into a config.
+func parseConfig(r io.Reader) (*config, error) {
+	var c config
+	md, err := toml.NewDecoder(r).Decode(&c)
+	if err != nil {
+		return nil, err
+	}
+	if u := md.Undecoded(); len(u) > 0 {
+		return nil, fmt.Errorf("unrecognized configuration keys: %s", u)
+	}
+
+	if len(c.Servers) == 0 {
+		return nil, errors.New("no configured servers")
+	}
+
+	names := make(map[string]struct{})
+	for i, s := range c.Servers {
+		if s.Name == "" {
+			return nil, errors.New("server must have a name")
+		}
+		if _, ok := names[s.Name]; ok {
+			return nil, fmt.Errorf("duplicate server %q", s.Name)
+		}
+		names[s.Name] = struct{}{}
+
+		if s.Address == "" {
+			return nil, fmt.Errorf("server %q must have an address", s.Name)
+		}
+		if _, _, err := net.SplitHostPort(s.Address); err != nil {
+			// Assume the address is a bare host and use the default port.
+			c.Servers[i].Address = net.JoinHostPort(s.Address, defaultPort)
+		}
+
+		if s.EscapeChar != "" && !validEscape(s.EscapeChar) {
+			return nil, fmt.Errorf("server %q has invalid escape character %q", s.Name, s.EscapeChar)
+		}
+		for dev, e := range s.EscapeChars {
+			if !validEscape(e) {
+				return nil, fmt.Errorf("server %q device %q has invalid escape character %q", s.Name, dev, e)
+			}
+		}
+	}
+
+	return &c, nil
+}
+
+// find resolves a device argument, either "device" or "server/device", to the
+// server which hosts the device.
+func (c *config) find(arg string) (*server, string, error) {
+	if srv, dev, ok := strings.Cut(arg, "/"); ok {
+		for i := range c.Servers {
+			if c.Servers[i].Name == srv {
+				return &c.Servers[i], dev, nil
+			}
+		}
+
+		return nil, "", fmt.Errorf("unknown server %q", srv)
+	}
+
+	var found []*server
+	for i := range c.Servers {
+		for _, d := range c.Servers[i].Devices {
+			if d == arg {
+				found = append(found, &c.Servers[i])
+			}
+		}
+	}
+
+	switch {
+	case len(found) == 1:
+		return found[0], arg, nil
+	case len(found) > 1:
+		names := make([]string, 0, len(found))
+		for _, s := range found {
+			names = append(names, s.Name+"/"+arg)
+		}
+
+		return nil, "", fmt.Errorf("device %q exists on multiple servers, specify one of: %s",
+			arg, strings.Join(names, ", "))
+	case len(c.Servers) == 1:
+		// There is no ambiguity, so let the server decide whether the device
+		// exists.
+		return &c.Servers[0], arg, nil
+	default:
+		return nil, "", fmt.Errorf("unknown device %q, specify it as server/device", arg)
+	}
+}
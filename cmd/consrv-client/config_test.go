@@ -0,0 +1,245 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_parseConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		c    *config
+		ok   bool
+	}{
+		{
+			name: "bad TOML",
+			s:    "xxx",
+		},
+		{
+			name: "bad keys",
+			s: `
+			[bad]
+			`,
+		},
+		{
+			name: "no servers",
+		},
+		{
+			name: "bad server name",
+			s: `
+			[[servers]]
+			address = "monitnerr-1"
+			`,
+		},
+		{
+			name: "bad server address",
+			s: `
+			[[servers]]
+			name = "monitnerr-1"
+			`,
+		},
+		{
+			name: "duplicate server",
+			s: `
+			[[servers]]
+			name = "monitnerr-1"
+			address = "monitnerr-1"
+			[[servers]]
+			name = "monitnerr-1"
+			address = "monitnerr-2"
+			`,
+		},
+		{
+			name: "bad escape character",
+			s: `
+			[[servers]]
+			name = "monitnerr-1"
+			address = "monitnerr-1"
+			escape_char = "~~"
+			`,
+		},
+		{
+			name: "bad device escape character",
+			s: `
+			[[servers]]
+			name = "monitnerr-1"
+			address = "monitnerr-1"
+			escape_chars = { modem = "^1" }
+			`,
+		},
+		{
+			name: "OK",
+			s: `
+			[[servers]]
+			name = "monitnerr-1"
+			address = "monitnerr-1"
+			identity_file = "~/.ssh/id_ed25519"
+			devices = ["server", "desktop"]
+			escape_char = "^]"
+			escape_chars = { modem = "none" }
+			[[servers]]
+			name = "lab"
+			address = "[2001:db8::1]:22"
+			`,
+			c: &config{
+				Servers: []server{
+					{
+						Name:         "monitnerr-1",
+						Address:      "monitnerr-1:2222",
+						IdentityFile: "~/.ssh/id_ed25519",
+						Devices:      []string{"server", "desktop"},
+						EscapeChar:   "^]",
+						EscapeChars:  map[string]string{"modem": "none"},
+					},
+					{
+						Name:    "lab",
+						Address: "[2001:db8::1]:22",
+					},
+				},
+			},
+			ok: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := parseConfig(strings.NewReader(tt.s))
+			if tt.ok && err != nil {
+				t.Fatalf("failed to parse config: %v", err)
+			}
+			if !tt.ok && err == nil {
+				t.Fatal("expected an error, but none occurred")
+			}
+			if err != nil {
+				return
+			}
+
+			if diff := cmp.Diff(tt.c, c); diff != "" {
+				t.Fatalf("unexpected config (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_server_escape(t *testing.T) {
+	s := &server{
+		EscapeChar:  "%",
+		EscapeChars: map[string]string{"modem": escapeNone},
+	}
+
+	for _, tt := range []struct {
+		s      *server
+		device string
+		want   string
+	}{
+		{s: &server{}, device: "server", want: ""},
+		{s: s, device: "server", want: "%"},
+		{s: s, device: "modem", want: escapeNone},
+	} {
+		if diff := cmp.Diff(tt.want, tt.s.escape(tt.device)); diff != "" {
+			t.Fatalf("unexpected escape character for %q (-want +got):\n%s", tt.device, diff)
+		}
+	}
+}
+
+func Test_config_find(t *testing.T) {
+	cfg := &config{
+		Servers: []server{
+			{
+				Name:    "a",
+				Devices: []string{"foo", "bar"},
+			},
+			{
+				Name:    "b",
+				Devices: []string{"bar"},
+			},
+		},
+	}
+
+	tests := []struct {
+		name   string
+		cfg    *config
+		arg    string
+		server string
+		device string
+		ok     bool
+	}{
+		{
+			name: "unknown device",
+			cfg:  cfg,
+			arg:  "baz",
+		},
+		{
+			name: "ambiguous device",
+			cfg:  cfg,
+			arg:  "bar",
+		},
+		{
+			name: "unknown server",
+			cfg:  cfg,
+			arg:  "c/foo",
+		},
+		{
+			name:   "OK device",
+			cfg:    cfg,
+			arg:    "foo",
+			server: "a",
+			device: "foo",
+			ok:     true,
+		},
+		{
+			name:   "OK server and device",
+			cfg:    cfg,
+			arg:    "b/bar",
+			server: "b",
+			device: "bar",
+			ok:     true,
+		},
+		{
+			name:   "OK single server",
+			cfg:    &config{Servers: []server{{Name: "a"}}},
+			arg:    "baz",
+			server: "a",
+			device: "baz",
+			ok:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, dev, err := tt.cfg.find(tt.arg)
+			if tt.ok && err != nil {
+				t.Fatalf("failed to find device: %v", err)
+			}
+			if !tt.ok && err == nil {
+				t.Fatal("expected an error, but none occurred")
+			}
+			if err != nil {
+				return
+			}
+
+			if diff := cmp.Diff(tt.server, s.Name); diff != "" {
+				t.Fatalf("unexpected server (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tt.device, dev); diff != "" {
+				t.Fatalf("unexpected device (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,239 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command consrv-client is a companion client for consrv which wraps ssh to
+// list, connect to, and follow the output of serial console devices configured
+// in a local configuration file.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"text/tabwriter"
+	"time"
+)
+
+const usage = `usage: consrv-client [-c client.toml] <command> [arguments]
+
+commands:
+  list [-q] [-local]                  list the devices available on each server
+  connect [-e char] [-passthrough] <device>
+                                      open an interactive session with a device
+  logs [-follow] [-idle 2s] <device>  print output from a device without sending input
+  completion <bash|zsh>               print a shell completion script
+
+A device may be specified as "device" or "server/device".
+`
+
+func main() {
+	c := flag.String("c", defaultConfigPath(), "path to client.toml configuration file")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	ll := log.New(os.Stderr, "", 0)
+
+	args := flag.Args()
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if args[0] == "completion" {
+		// Completion does not require any configuration.
+		if err := completion(os.Stdout, args[1:]); err != nil {
+			ll.Fatal(err)
+		}
+		return
+	}
+
+	f, err := os.Open(*c)
+	if err != nil {
+		ll.Fatalf("failed to open config file: %v", err)
+	}
+	cfg, err := parseConfig(f)
+	_ = f.Close()
+	if err != nil {
+		ll.Fatalf("failed to parse config: %v", err)
+	}
+
+	switch args[0] {
+	case "list":
+		err = list(cfg, args[1:])
+	case "connect":
+		err = connect(cfg, args[1:])
+	case "logs":
+		err = logs(cfg, args[1:])
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var eerr *exec.ExitError
+	if errors.As(err, &eerr) {
+		// ssh already reported its own error.
+		os.Exit(eerr.ExitCode())
+	}
+	if err != nil {
+		ll.Fatal(err)
+	}
+}
+
+// A listing is the set of devices available on a server.
+type listing struct {
+	server  *server
+	devices []string
+}
+
+// list prints the devices available on each configured server.
+func list(cfg *config, args []string) error {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	quiet := fs.Bool("q", false, "only print device names, for use in shell completion")
+	local := fs.Bool("local", false, "only print devices from the configuration file rather than querying servers")
+	_ = fs.Parse(args)
+
+	ls := make([]listing, 0, len(cfg.Servers))
+	for i := range cfg.Servers {
+		s := &cfg.Servers[i]
+
+		devices := s.Devices
+		if !*local {
+			ds, err := queryDevices(s)
+			if err == nil {
+				devices = ds
+			} else if !*quiet {
+				fmt.Fprintf(os.Stderr, "failed to query server %q, using configured devices: %v\n", s.Name, err)
+			}
+		}
+
+		ls = append(ls, listing{server: s, devices: devices})
+	}
+
+	if *quiet {
+		for _, n := range deviceNames(ls) {
+			fmt.Println(n)
+		}
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SERVER\tDEVICE\tADDRESS")
+	for _, l := range ls {
+		for _, d := range l.devices {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", l.server.Name, d, l.server.Address)
+		}
+	}
+
+	return tw.Flush()
+}
+
+// deviceNames produces device arguments for each device in ls. Devices are
+// qualified with their server name when more than one server is configured.
+func deviceNames(ls []listing) []string {
+	var names []string
+	for _, l := range ls {
+		for _, d := range l.devices {
+			if len(ls) > 1 {
+				d = l.server.Name + "/" + d
+			}
+
+			names = append(names, d)
+		}
+	}
+
+	return names
+}
+
+// connect opens an interactive ssh session with a device.
+func connect(cfg *config, args []string) error {
+	const usage = "usage: consrv-client connect [-e char] [-passthrough] <device>"
+
+	fs := flag.NewFlagSet("connect", flag.ExitOnError)
+	escape := fs.String("e", "", `the ssh escape character for this session, or "none"`)
+	passthrough := fs.Bool("passthrough", false, "disable the ssh escape character so every byte is sent to the device, same as -e none")
+
+	// Permit flags both before and after the device argument.
+	_ = fs.Parse(args)
+	rest := fs.Args()
+	if len(rest) == 0 {
+		return errors.New(usage)
+	}
+	arg := rest[0]
+	_ = fs.Parse(rest[1:])
+	if fs.NArg() > 0 {
+		return errors.New(usage)
+	}
+
+	s, dev, err := cfg.find(arg)
+	if err != nil {
+		return err
+	}
+
+	// Session flags take precedence over the configuration.
+	e := s.escape(dev)
+	switch {
+	case *passthrough:
+		e = escapeNone
+	case *escape != "":
+		if !validEscape(*escape) {
+			return fmt.Errorf("invalid escape character %q", *escape)
+		}
+		e = *escape
+	}
+
+	var extra []string
+	if e != "" {
+		extra = []string{"-e", e}
+	}
+
+	return sshCommand(sshArgs(s, dev, extra...)).Run()
+}
+
+// logs prints the output of a device without sending it any input.
+func logs(cfg *config, args []string) error {
+	fs := flag.NewFlagSet("logs", flag.ExitOnError)
+	followF := fs.Bool("follow", false, "print output until interrupted")
+	idle := fs.Duration("idle", 2*time.Second, "without -follow, stop after no output is received for this duration")
+
+	// Permit flags both before and after the device argument.
+	_ = fs.Parse(args)
+	rest := fs.Args()
+	if len(rest) == 0 {
+		return errors.New("usage: consrv-client logs [-follow] [-idle 2s] <device>")
+	}
+	dev := rest[0]
+	_ = fs.Parse(rest[1:])
+	if fs.NArg() > 0 {
+		return errors.New("usage: consrv-client logs [-follow] [-idle 2s] <device>")
+	}
+
+	s, name, err := cfg.find(dev)
+	if err != nil {
+		return err
+	}
+
+	wait := *idle
+	if *followF {
+		wait = 0
+	}
+
+	// Don't allocate a terminal, so the device's output is passed through
+	// verbatim.
+	return follow(sshArgs(s, name, "-T"), os.Stdout, wait)
+}
@@ -0,0 +1,53 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_deviceNames(t *testing.T) {
+	a := &server{Name: "a"}
+	b := &server{Name: "b"}
+
+	tests := []struct {
+		name string
+		ls   []listing
+		want []string
+	}{
+		{
+			name: "single server",
+			ls:   []listing{{server: a, devices: []string{"foo", "bar"}}},
+			want: []string{"foo", "bar"},
+		},
+		{
+			name: "multiple servers",
+			ls: []listing{
+				{server: a, devices: []string{"foo"}},
+				{server: b, devices: []string{"foo", "bar"}},
+			},
+			want: []string{"a/foo", "b/foo", "b/bar"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if diff := cmp.Diff(tt.want, deviceNames(tt.ls)); diff != "" {
+				t.Fatalf("unexpected device names (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
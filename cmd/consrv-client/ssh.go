@@ -0,0 +1,149 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mdlayher/consrv"
+)
+
+// sshArgs produces the arguments used to invoke ssh for a session with device
+// on s. Any extra arguments are passed to ssh before the destination.
+func sshArgs(s *server, device string, extra ...string) []string {
+	host, port, err := net.SplitHostPort(s.Address)
+	if err != nil {
+		// Already validated in parseConfig.
+		panic("consrv-client: invalid server address: " + err.Error())
+	}
+
+	args := []string{"-p", port}
+	if s.IdentityFile != "" {
+		args = append(args, "-i", expandHome(s.IdentityFile))
+	}
+	args = append(args, extra...)
+
+	// consrv uses the SSH username to select a device.
+	return append(args, device+"@"+host)
+}
+
+// expandHome expands a leading ~/ in path to the user's home directory.
+func expandHome(path string) string {
+	rest, ok := strings.CutPrefix(path, "~/")
+	if !ok {
+		return path
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+
+	return filepath.Join(home, rest)
+}
+
+// sshCommand creates an ssh command attached to the client's stdio.
+func sshCommand(args []string) *exec.Cmd {
+	cmd := exec.Command("ssh", args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd
+}
+
+// queryDevices lists the devices available on s using the consrv list
+// subsystem.
+func queryDevices(s *server) ([]string, error) {
+	// Any user name which is not also a device name may request the subsystem.
+	args := append(sshArgs(s, "consrv", "-s"), consrv.ListSubsystem)
+
+	cmd := exec.Command("ssh", args...)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+
+	return strings.Fields(string(out)), nil
+}
+
+// follow runs ssh to only consume output from a device, writing the output to
+// w. If idle is non-zero, ssh is stopped once no output has been received for
+// that duration.
+func follow(args []string, w io.Writer, idle time.Duration) error {
+	cmd := exec.Command("ssh", args...)
+	cmd.Stderr = os.Stderr
+
+	// Keep stdin open but never write to it, since consrv ends a session when
+	// its input is closed. The pipe is closed by cmd.Wait.
+	if _, err := cmd.StdinPipe(); err != nil {
+		return err
+	}
+
+	if idle == 0 {
+		cmd.Stdout = w
+		return cmd.Run()
+	}
+
+	iw := &idleWriter{w: w, idle: idle}
+	cmd.Stdout = iw
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	var stopped atomic.Bool
+	iw.mu.Lock()
+	iw.t = time.AfterFunc(idle, func() {
+		stopped.Store(true)
+		_ = cmd.Process.Kill()
+	})
+	iw.mu.Unlock()
+
+	err := cmd.Wait()
+	if stopped.Load() {
+		// The device went idle, which is the expected way to finish.
+		return nil
+	}
+
+	return err
+}
+
+// An idleWriter is an io.Writer which resets a timer on each write.
+type idleWriter struct {
+	mu   sync.Mutex
+	w    io.Writer
+	t    *time.Timer
+	idle time.Duration
+}
+
+// Write implements io.Writer.
+func (iw *idleWriter) Write(b []byte) (int, error) {
+	iw.mu.Lock()
+	defer iw.mu.Unlock()
+
+	if iw.t != nil {
+		iw.t.Reset(iw.idle)
+	}
+
+	return iw.w.Write(b)
+}
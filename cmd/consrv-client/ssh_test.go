@@ -0,0 +1,60 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_sshArgs(t *testing.T) {
+	tests := []struct {
+		name   string
+		s      *server
+		device string
+		extra  []string
+		want   []string
+	}{
+		{
+			name:   "basic",
+			s:      &server{Address: "monitnerr-1:2222"},
+			device: "server",
+			want:   []string{"-p", "2222", "server@monitnerr-1"},
+		},
+		{
+			name: "identity and extra",
+			s: &server{
+				Address:      "[2001:db8::1]:22",
+				IdentityFile: "/home/matt/.ssh/id_ed25519",
+			},
+			device: "desktop",
+			extra:  []string{"-T"},
+			want: []string{
+				"-p", "22",
+				"-i", "/home/matt/.ssh/id_ed25519",
+				"-T",
+				"desktop@2001:db8::1",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if diff := cmp.Diff(tt.want, sshArgs(tt.s, tt.device, tt.extra...)); diff != "" {
+				t.Fatalf("unexpected arguments (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// adminIdentity identifies requests to the admin endpoints in notifications and
+// logs, as the bearer token does not identify a configured identity.
+const adminIdentity = "debug HTTP admin"
+
+// An adminAuth authenticates requests to the debug HTTP server's endpoints
+// which change consrv's state, such as:
+//
+//	PUT /loglevel
+//
+// Requests must present the token read from the debug admin_token_file as an
+// "Authorization: Bearer" header. The endpoints are not served at all unless
+// the token file is configured.
+type adminAuth struct {
+	token []byte
+}
+
+// newAdminAuth creates an adminAuth using the token read from file.
+func newAdminAuth(file string) (*adminAuth, error) {
+	token, err := readToken(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return &adminAuth{token: token}, nil
+}
+
+// wrap returns an http.Handler which only passes authenticated requests to h.
+func (a *adminAuth) wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(w, r, a.token) {
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// readToken reads a non-empty bearer token from file.
+func readToken(file string) ([]byte, error) {
+	token, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read token file: %v", err)
+	}
+	token = bytes.TrimSpace(token)
+	if len(token) == 0 {
+		return nil, fmt.Errorf("token file %q is empty", file)
+	}
+
+	return token, nil
+}
+
+// authorized reports whether r presents token as a bearer token, and replies
+// to unauthorized requests.
+func authorized(w http.ResponseWriter, r *http.Request, token []byte) bool {
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(got), token) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,145 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func Test_adminAuth(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(file, []byte("secret\n"), 0o600); err != nil {
+		t.Fatalf("failed to write token: %v", err)
+	}
+
+	a, err := newAdminAuth(file)
+	if err != nil {
+		t.Fatalf("failed to create admin auth: %v", err)
+	}
+
+	h := a.wrap(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		name string
+		auth string
+		code int
+	}{
+		{
+			name: "missing",
+			code: http.StatusUnauthorized,
+		},
+		{
+			name: "wrong scheme",
+			auth: "Basic secret",
+			code: http.StatusUnauthorized,
+		},
+		{
+			name: "wrong token",
+			auth: "Bearer hunter2",
+			code: http.StatusUnauthorized,
+		},
+		{
+			name: "OK",
+			auth: "Bearer secret",
+			code: http.StatusNoContent,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPut, "/loglevel", nil)
+			if tt.auth != "" {
+				r.Header.Set("Authorization", tt.auth)
+			}
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if diff := cmp.Diff(tt.code, w.Code); diff != "" {
+				t.Fatalf("unexpected status (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_newAdminAuthEmpty(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(file, []byte("\n"), 0o600); err != nil {
+		t.Fatalf("failed to write token: %v", err)
+	}
+
+	if _, err := newAdminAuth(file); err == nil {
+		t.Fatal("expected an error, but none occurred")
+	}
+}
+
+func Test_newDebugMuxAdmin(t *testing.T) {
+	// Each endpoint which changes consrv's state.
+	endpoints := []struct {
+		method, path string
+	}{
+		{method: http.MethodPut, path: "/loglevel"},
+		{method: http.MethodPut, path: "/park/server"},
+		{method: http.MethodDelete, path: "/park/server"},
+		{method: http.MethodPost, path: "/baud/server"},
+		{method: http.MethodPut, path: "/state"},
+		{method: http.MethodPost, path: "/quitquitquit"},
+		{method: http.MethodPost, path: "/groups/rack1/power-cycle"},
+		{method: http.MethodPut, path: "/debug/runtime/gogc"},
+	}
+
+	file := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(file, []byte("secret\n"), 0o600); err != nil {
+		t.Fatalf("failed to write token: %v", err)
+	}
+
+	admin, err := newAdminAuth(file)
+	if err != nil {
+		t.Fatalf("failed to create admin auth: %v", err)
+	}
+
+	mux := func(admin *adminAuth) *http.ServeMux {
+		return newDebugMux(debug{PProf: true}, admin, prometheus.NewRegistry(), nil, nil, nil, nil, nil, nil, nil, nil, nil,
+			http.NotFoundHandler(), log.New(io.Discard, "", 0))
+	}
+
+	for _, e := range endpoints {
+		t.Run(e.method+" "+e.path, func(t *testing.T) {
+			// Not served at all without an admin token file.
+			w := httptest.NewRecorder()
+			mux(nil).ServeHTTP(w, httptest.NewRequest(e.method, e.path, nil))
+			if w.Code != http.StatusNotFound && w.Code != http.StatusMethodNotAllowed {
+				t.Fatalf("unexpected status without admin token file: %d", w.Code)
+			}
+
+			// Unauthenticated requests are refused.
+			w = httptest.NewRecorder()
+			mux(admin).ServeHTTP(w, httptest.NewRequest(e.method, e.path, nil))
+			if diff := cmp.Diff(http.StatusUnauthorized, w.Code); diff != "" {
+				t.Fatalf("unexpected status (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/mdlayher/consrv"
+)
+
+// An approvalsHandler serves the sessions waiting for approval as JSON:
+//
+//	GET /approvals
+//
+// Sessions are only approved over SSH with the approve command, which
+// identifies the approving identity by its credentials.
+type approvalsHandler struct {
+	list func() []consrv.Approval
+}
+
+// A jsonApproval is the JSON representation of a consrv.Approval.
+type jsonApproval struct {
+	ID        int       `json:"id"`
+	Device    string    `json:"device"`
+	Identity  string    `json:"identity"`
+	Address   string    `json:"address"`
+	Reason    string    `json:"reason"`
+	Requested time.Time `json:"requested"`
+}
+
+// ServeHTTP implements http.Handler.
+func (ah *approvalsHandler) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	as := make([]jsonApproval, 0)
+	for _, a := range ah.list() {
+		as = append(as, jsonApproval(a))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(as)
+}
@@ -0,0 +1,80 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mdlayher/consrv"
+)
+
+func Test_approvalsHandler(t *testing.T) {
+	ah := &approvalsHandler{
+		list: func() []consrv.Approval {
+			return []consrv.Approval{{
+				ID:        1,
+				Device:    "server",
+				Identity:  "alice",
+				Address:   "192.0.2.1",
+				Reason:    "CHG-1234",
+				Requested: time.Date(2024, time.March, 5, 15, 0, 0, 0, time.UTC),
+			}}
+		},
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /approvals", ah)
+
+	tests := []struct {
+		name, method, target string
+		code                 int
+		body                 string
+	}{
+		{
+			name:   "list",
+			method: http.MethodGet,
+			target: "/approvals",
+			code:   http.StatusOK,
+			body: `[{"id":1,"device":"server","identity":"alice","address":"192.0.2.1",` +
+				`"reason":"CHG-1234","requested":"2024-03-05T15:00:00Z"}]` + "\n",
+		},
+		{
+			// Approvals can't be made over HTTP, where there is no way to
+			// authenticate the approving identity.
+			name:   "approve",
+			method: http.MethodPost,
+			target: "/approvals/server/1?identity=bob",
+			code:   http.StatusNotFound,
+			body:   "404 page not found\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest(tt.method, tt.target, nil))
+
+			if diff := cmp.Diff(tt.code, w.Code); diff != "" {
+				t.Fatalf("unexpected status code (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tt.body, w.Body.String()); diff != "" {
+				t.Fatalf("unexpected body (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/mdlayher/consrv"
+)
+
+// authExecTimeout bounds the run time of an authentication command, since the
+// client waits for it to complete.
+const authExecTimeout = 10 * time.Second
+
+// newAuthExec creates a consrv.ServerConfig.Authorize function which runs the
+// command args with "--" and the SSH user name, public key fingerprint, and
+// source IP address of each client appended as arguments, and the name of its
+// identity in the environment. The client is only authorized if the command
+// exits with status 0.
+func newAuthExec(args []string) func(ctx context.Context, req consrv.AuthRequest) error {
+	return func(ctx context.Context, req consrv.AuthRequest) error {
+		// The user name is chosen by the client, so never pass one which the
+		// command could mistake for an option.
+		if req.User == "" || strings.HasPrefix(req.User, "-") {
+			return fmt.Errorf("invalid SSH user name %q", req.User)
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, authExecTimeout)
+		defer cancel()
+
+		ip := req.Addr.String()
+		if host, _, err := net.SplitHostPort(ip); err == nil {
+			ip = host
+		}
+
+		cmd := exec.CommandContext(ctx, args[0], append(args[1:len(args):len(args)], "--", req.User, req.Fingerprint, ip)...)
+		cmd.Env = append(os.Environ(), "CONSRV_IDENTITY="+req.Identity)
+
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("authentication command %q: %v: %s", args[0], err, bytes.TrimSpace(out))
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"net"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/mdlayher/consrv"
+)
+
+func Test_newAuthExec(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skipf("skipping, no shell: %v", err)
+	}
+
+	// Only authorize the server device, and print the arguments otherwise.
+	authorize := newAuthExec([]string{
+		"sh", "-c", `[ "$1" = server ] || { echo "$CONSRV_IDENTITY $0 $1 $2 $3"; exit 1; }`,
+	})
+
+	req := consrv.AuthRequest{
+		User:        "server",
+		Identity:    "mdlayher",
+		Fingerprint: "SHA256:abc",
+		Addr:        &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 50022},
+	}
+
+	if err := authorize(context.Background(), req); err != nil {
+		t.Fatalf("failed to authorize: %v", err)
+	}
+
+	req.User = "desktop"
+	err := authorize(context.Background(), req)
+	if err == nil || !strings.HasSuffix(err.Error(), ": mdlayher -- desktop SHA256:abc 192.0.2.1") {
+		t.Fatalf("expected authorization failure with output, but got: %v", err)
+	}
+
+	// User names which are empty or could be mistaken for options are refused
+	// without running the command.
+	for _, user := range []string{"", "-server", "--help"} {
+		req.User = user
+		err := authorize(context.Background(), req)
+		if err == nil || !strings.HasPrefix(err.Error(), "invalid SSH user name") {
+			t.Fatalf("expected invalid user name %q to be refused, but got: %v", user, err)
+		}
+	}
+}
@@ -0,0 +1,252 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/tarm/serial"
+)
+
+// baudRates are the common baud rates tried by a baudDetector, in addition to
+// the configured baud rate of a device.
+var baudRates = []int{9600, 19200, 38400, 57600, 115200, 230400, 460800, 921600, 1500000}
+
+const (
+	// baudSample is the default and baudMaxSample the maximum duration for
+	// which output is sampled at each baud rate.
+	baudSample    = 2 * time.Second
+	baudMaxSample = 10 * time.Second
+
+	// baudMinBytes is the number of bytes which must be sampled at a baud rate
+	// for its score to be meaningful.
+	baudMinBytes = 16
+)
+
+// A baudResult is the output sampled at a single baud rate.
+type baudResult struct {
+	Baud  int
+	Bytes int
+	Score float64
+}
+
+// A baudDetector suggests the baud rate of a serial port by sampling its
+// output at several baud rates and scoring how much of it is printable.
+type baudDetector struct {
+	rates    []int
+	sample   time.Duration
+	probe    bool
+	openPort func(cfg *serial.Config) (io.ReadWriteCloser, error)
+}
+
+// newBaudDetector creates a baudDetector which also tries baud if it is not
+// one of the common rates.
+func newBaudDetector(baud int, openPort func(cfg *serial.Config) (io.ReadWriteCloser, error)) *baudDetector {
+	rates := slices.Clone(baudRates)
+	if baud > 0 && !slices.Contains(rates, baud) {
+		rates = append(rates, baud)
+		slices.Sort(rates)
+	}
+
+	return &baudDetector{
+		rates:    rates,
+		sample:   baudSample,
+		openPort: openPort,
+	}
+}
+
+// detect samples the output of the serial port at path at each baud rate. If
+// the baudDetector probes, a carriage return is sent at each rate to prompt
+// the device for output.
+func (bd *baudDetector) detect(path string) ([]baudResult, error) {
+	results := make([]baudResult, 0, len(bd.rates))
+	for _, baud := range bd.rates {
+		b, err := bd.read(path, baud)
+		if err != nil {
+			return nil, fmt.Errorf("failed to sample at %d baud: %v", baud, err)
+		}
+
+		results = append(results, baudResult{
+			Baud:  baud,
+			Bytes: len(b),
+			Score: printable(b),
+		})
+	}
+
+	return results, nil
+}
+
+// read returns the output read from the serial port at path at baud until the
+// sample duration elapses.
+func (bd *baudDetector) read(path string, baud int) ([]byte, error) {
+	rwc, err := bd.openPort(&serial.Config{
+		Name:        path,
+		Baud:        baud,
+		ReadTimeout: 100 * time.Millisecond,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer rwc.Close()
+
+	if bd.probe {
+		if _, err := io.WriteString(rwc, "\r"); err != nil {
+			return nil, err
+		}
+	}
+
+	var (
+		out      []byte
+		b        = make([]byte, 1024)
+		deadline = time.Now().Add(bd.sample)
+	)
+	for time.Now().Before(deadline) {
+		n, err := rwc.Read(b)
+		out = append(out, b[:n]...)
+		if err != nil && err != io.EOF {
+			// A read which times out may be reported as io.EOF by tarm/serial.
+			return nil, err
+		}
+	}
+
+	return out, nil
+}
+
+// suggest returns the baud rate with the most printable output, or false if
+// too little output was sampled at every rate to tell.
+func suggest(results []baudResult) (int, bool) {
+	var best *baudResult
+	for i, r := range results {
+		if r.Bytes < baudMinBytes {
+			continue
+		}
+		if best == nil || r.Score > best.Score || (r.Score == best.Score && r.Bytes > best.Bytes) {
+			best = &results[i]
+		}
+	}
+	if best == nil {
+		return 0, false
+	}
+
+	return best.Baud, true
+}
+
+// printable returns the fraction of b which is printable ASCII text, including
+// common whitespace. Output read at the wrong baud rate is mostly made up of
+// control characters and bytes with the 8th bit set.
+func printable(b []byte) float64 {
+	if len(b) == 0 {
+		return 0
+	}
+
+	var n int
+	for _, c := range b {
+		if (c >= ' ' && c <= '~') || c == '\t' || c == '\r' || c == '\n' {
+			n++
+		}
+	}
+
+	return float64(n) / float64(len(b))
+}
+
+// A baudHandler suggests the baud rate of a device over HTTP, for first
+// contact with hardware whose output is garbled at the configured rate:
+//
+//	POST /baud/{device}?sample=2s&probe=true
+//
+// The device is parked while its output is sampled at each rate, and attached
+// sessions are notified of the suggested rate. The configured baud rate is
+// not changed.
+type baudHandler struct {
+	devices  map[string]rawDevice
+	parks    map[string]*parkDevice
+	openPort func(cfg *serial.Config) (io.ReadWriteCloser, error)
+	notify   func(device, format string, v ...any)
+	ll       *log.Logger
+}
+
+// ServeHTTP implements http.Handler.
+func (bh *baudHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("device")
+	d, ok := bh.devices[name]
+	pd, pok := bh.parks[name]
+	if !ok || !pok {
+		http.NotFound(w, r)
+		return
+	}
+
+	bd := newBaudDetector(d.Baud, bh.openPort)
+	if s := r.URL.Query().Get("sample"); s != "" {
+		sample, err := time.ParseDuration(s)
+		if err != nil || sample <= 0 || sample > baudMaxSample {
+			http.Error(w, fmt.Sprintf("sample must be a duration between 0 and %s", baudMaxSample), http.StatusBadRequest)
+			return
+		}
+		bd.sample = sample
+	}
+	bd.probe = r.URL.Query().Get("probe") == "true"
+
+	if err := pd.park(); err != nil {
+		if errors.Is(err, errParked) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	bh.ll.Printf("%s: detecting baud rate of %q", name, d.Device)
+	bh.notify(name, "device %q is detecting its baud rate, output is paused and input is discarded", name)
+
+	results, derr := bd.detect(d.Device)
+	if err := pd.resume(); err != nil {
+		errorf(bh.ll, "%s: failed to resume device after baud rate detection: %v", name, err)
+		http.Error(w, fmt.Sprintf("failed to resume device: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if derr != nil {
+		bh.notify(name, "device %q is resumed, baud rate detection failed", name)
+		http.Error(w, derr.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintf(w, "baud rate detection for %q at %s:\n", name, d.Device)
+	for _, r := range results {
+		var configured string
+		if r.Baud == d.Baud {
+			configured = " (configured)"
+		}
+
+		fmt.Fprintf(w, "  %7d baud: %5d bytes, %3.0f%% printable%s\n", r.Baud, r.Bytes, r.Score*100, configured)
+	}
+
+	baud, ok := suggest(results)
+	if !ok {
+		bh.notify(name, "device %q is resumed, too little output was sampled to suggest a baud rate", name)
+		fmt.Fprintln(w, "too little output was sampled, try again while the device produces output or with probe=true")
+		return
+	}
+
+	bh.ll.Printf("%s: suggested baud rate %d (configured %d)", name, baud, d.Baud)
+	bh.notify(name, "device %q is resumed, suggested baud rate is %d (configured %d)", name, baud, d.Baud)
+	fmt.Fprintf(w, "suggested: %d baud\n", baud)
+}
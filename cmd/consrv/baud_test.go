@@ -0,0 +1,217 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mdlayher/consrv"
+	"github.com/tarm/serial"
+)
+
+func Test_suggest(t *testing.T) {
+	tests := []struct {
+		name    string
+		results []baudResult
+		baud    int
+		ok      bool
+	}{
+		{
+			name: "no output",
+			results: []baudResult{
+				{Baud: 9600},
+				{Baud: 115200},
+			},
+		},
+		{
+			name: "too little output",
+			results: []baudResult{
+				{Baud: 9600, Bytes: 4, Score: 1},
+				{Baud: 115200, Bytes: 8, Score: 1},
+			},
+		},
+		{
+			name: "most printable",
+			results: []baudResult{
+				{Baud: 9600, Bytes: 400, Score: 0.2},
+				{Baud: 115200, Bytes: 40, Score: 0.95},
+				{Baud: 230400, Bytes: 4, Score: 1},
+			},
+			baud: 115200,
+			ok:   true,
+		},
+		{
+			name: "most output",
+			results: []baudResult{
+				{Baud: 57600, Bytes: 20, Score: 1},
+				{Baud: 115200, Bytes: 40, Score: 1},
+			},
+			baud: 115200,
+			ok:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			baud, ok := suggest(tt.results)
+			if diff := cmp.Diff(tt.ok, ok); diff != "" {
+				t.Fatalf("unexpected ok (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tt.baud, baud); diff != "" {
+				t.Fatalf("unexpected baud (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_printable(t *testing.T) {
+	tests := []struct {
+		name  string
+		b     string
+		score float64
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name:  "text",
+			b:     "login:\r\n\tok",
+			score: 1,
+		},
+		{
+			name:  "garbage",
+			b:     "ab\x00\xff\x80\xfe",
+			score: 2.0 / 6.0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if diff := cmp.Diff(tt.score, printable([]byte(tt.b))); diff != "" {
+				t.Fatalf("unexpected score (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_baudHandler(t *testing.T) {
+	dev, _ := newPipeDevice()
+	pd := newParkDevice(rawDevice{Name: "server"}, dev, func() (consrv.Device, error) {
+		dev, _ := newPipeDevice()
+		return dev, nil
+	}, log.New(io.Discard, "", 0))
+
+	var (
+		probes   int
+		notified []string
+	)
+	bh := &baudHandler{
+		devices: map[string]rawDevice{
+			"server": {Name: "server", Device: "/dev/ttyUSB0", Baud: 9600},
+		},
+		parks: map[string]*parkDevice{"server": pd},
+		openPort: func(cfg *serial.Config) (io.ReadWriteCloser, error) {
+			// Only 115200 baud produces readable output.
+			out := strings.Repeat("\x80\xfe\x00", 10)
+			if cfg.Baud == 115200 {
+				out = "U-Boot 2024.01 login: "
+			}
+
+			return &probePort{Reader: strings.NewReader(out), probes: &probes}, nil
+		},
+		notify: func(device, format string, v ...any) {
+			notified = append(notified, device)
+		},
+		ll: log.New(io.Discard, "", 0),
+	}
+
+	defer func(rates []int) { baudRates = rates }(baudRates)
+	baudRates = []int{9600, 115200}
+
+	mux := http.NewServeMux()
+	mux.Handle("POST /baud/{device}", bh)
+
+	tests := []struct {
+		name, url string
+		code      int
+		body      string
+	}{
+		{
+			name: "unknown device",
+			url:  "/baud/desktop",
+			code: http.StatusNotFound,
+			body: "404 page not found\n",
+		},
+		{
+			name: "bad sample",
+			url:  "/baud/server?sample=1h",
+			code: http.StatusBadRequest,
+			body: "sample must be a duration between 0 and 10s\n",
+		},
+		{
+			name: "OK",
+			url:  "/baud/server?sample=10ms&probe=true",
+			code: http.StatusOK,
+			body: `baud rate detection for "server" at /dev/ttyUSB0:
+     9600 baud:    30 bytes,   0% printable (configured)
+   115200 baud:    22 bytes, 100% printable
+suggested: 115200 baud
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, tt.url, nil))
+
+			if diff := cmp.Diff(tt.code, w.Code); diff != "" {
+				t.Fatalf("unexpected status (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tt.body, w.Body.String()); diff != "" {
+				t.Fatalf("unexpected body (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	if diff := cmp.Diff(2, probes); diff != "" {
+		t.Fatalf("unexpected probes (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"server", "server"}, notified); diff != "" {
+		t.Fatalf("unexpected notifications (-want +got):\n%s", diff)
+	}
+	if pd.isParked() {
+		t.Fatal("device should be resumed after baud rate detection")
+	}
+}
+
+// A probePort is a serial port which produces fixed output and counts the
+// probes written to it.
+type probePort struct {
+	io.Reader
+	probes *int
+}
+
+func (p *probePort) Write(b []byte) (int, error) {
+	*p.probes++
+	return len(b), nil
+}
+
+func (*probePort) Close() error { return nil }
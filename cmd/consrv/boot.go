@@ -0,0 +1,167 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"regexp"
+	"time"
+
+	"github.com/mdlayher/consrv"
+)
+
+// bootConfig contains the configuration for a device's boot sequence tracker.
+type bootConfig struct {
+	Markers []bootMarker `toml:"markers"`
+}
+
+// A bootMarker is a named pattern which marks a stage of a device's boot.
+type bootMarker struct {
+	Name    string `toml:"name"`
+	Pattern string `toml:"pattern"`
+}
+
+// validate verifies the boot configuration for device.
+func (bc *bootConfig) validate(device string) error {
+	if len(bc.Markers) < 2 {
+		return fmt.Errorf("device %q boot tracker must have at least two markers", device)
+	}
+
+	seen := make(map[string]bool, len(bc.Markers))
+	for _, m := range bc.Markers {
+		if m.Name == "" {
+			return fmt.Errorf("device %q boot marker must have a name", device)
+		}
+		if seen[m.Name] {
+			return fmt.Errorf("device %q has duplicate boot marker %q", device, m.Name)
+		}
+		seen[m.Name] = true
+
+		if _, err := regexp.Compile(m.Pattern); err != nil {
+			return fmt.Errorf("device %q boot marker %q has invalid pattern: %v", device, m.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// A bootTracker measures the time between the boot markers of a device as
+// they appear in order in its output.
+type bootTracker struct {
+	name     string
+	names    []string
+	patterns []*regexp.Regexp
+	mm       *metrics
+	ll       *log.Logger
+	now      func() time.Time
+
+	// next is the index of the next expected marker, and start and last are
+	// the times the first and most recent markers were seen.
+	next        int
+	start, last time.Time
+	// skip is set when an incomplete line matched, so it is not matched
+	// again as it grows.
+	skip bool
+}
+
+// newBootTracker creates a bootTracker for device d from its configuration.
+func newBootTracker(d rawDevice, mm *metrics, ll *log.Logger) *bootTracker {
+	bt := &bootTracker{
+		name: d.Name,
+		mm:   mm,
+		ll:   ll,
+		now:  time.Now,
+	}
+
+	for _, m := range d.Boot.Markers {
+		bt.names = append(bt.names, m.Name)
+		// Validated when parsing the configuration.
+		bt.patterns = append(bt.patterns, regexp.MustCompile(m.Pattern))
+	}
+
+	return bt
+}
+
+// run tracks output from the mux until the process exits, restarting the
+// tracker if it stops.
+func (bt *bootTracker) run(mux *consrv.MuxDevice) {
+	for {
+		ctx, cancel := context.WithCancel(context.Background())
+		if err := bt.track(mux.Attach(ctx)); err != nil {
+			errorf(bt.ll, "boot tracker for %q: %v", bt.name, err)
+		}
+		cancel()
+
+		bt.ll.Printf("restarting boot tracker for %q", bt.name)
+		time.Sleep(1 * time.Second)
+	}
+}
+
+// track reads output from r until it returns an error.
+func (bt *bootTracker) track(r io.Reader) error {
+	var (
+		lb lineBuffer
+		b  = make([]byte, 4096)
+	)
+
+	for {
+		n, err := r.Read(b)
+		lb.write(b[:n], bt.line)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// line advances the boot sequence if line matches the first or next expected
+// marker.
+func (bt *bootTracker) line(line []byte, partial bool) {
+	if bt.skip {
+		// Wait for the rest of a line which already matched.
+		bt.skip = partial
+		return
+	}
+
+	var matched bool
+	switch {
+	case bt.patterns[0].Match(line):
+		// The first marker always begins a new boot, even if the previous
+		// boot did not complete.
+		bt.next = 1
+		bt.start = bt.now()
+		bt.last = bt.start
+		matched = true
+	case bt.next > 0 && bt.patterns[bt.next].Match(line):
+		now := bt.now()
+		bt.mm.deviceBootStages.observe(
+			now.Sub(bt.last).Seconds(),
+			bt.name, bt.names[bt.next-1], bt.names[bt.next],
+		)
+		bt.last = now
+		bt.next++
+		matched = true
+
+		if bt.next == len(bt.patterns) {
+			d := now.Sub(bt.start)
+			bt.mm.deviceBoots.observe(d.Seconds(), bt.name)
+			bt.ll.Printf("device %q booted in %s", bt.name, d.Round(time.Millisecond))
+			bt.next = 0
+		}
+	}
+
+	bt.skip = matched && partial
+}
@@ -0,0 +1,165 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mdlayher/metricslite"
+)
+
+func Test_bootTracker(t *testing.T) {
+	d := rawDevice{
+		Name: "server",
+		Boot: &bootConfig{
+			Markers: []bootMarker{
+				{Name: "uboot", Pattern: `^U-Boot \d`},
+				{Name: "kernel", Pattern: `Linux version`},
+				{Name: "login", Pattern: `login: $`},
+			},
+		},
+	}
+
+	tests := []struct {
+		name string
+		// Each chunk of output is read one second after the previous.
+		in   []string
+		want map[string]map[string]float64
+	}{
+		{
+			name: "incomplete",
+			in:   []string{"U-Boot 2024.01\n", "foo\n", "[ 0.0] Linux version 6.6\n"},
+			want: map[string]map[string]float64{
+				"consrv_device_boot_seconds_count": nil,
+				"consrv_device_boot_stage_seconds_sum": {
+					"name=server,from=uboot,to=kernel": 2,
+				},
+			},
+		},
+		{
+			name: "out of order",
+			in:   []string{"foo\n", "login: \n", "[ 0.0] Linux version 6.6\n"},
+			want: map[string]map[string]float64{
+				"consrv_device_boot_seconds_count":     nil,
+				"consrv_device_boot_stage_seconds_sum": nil,
+			},
+		},
+		{
+			name: "OK",
+			in: []string{
+				"U-Boot 2024.01\n[ 0.0] Linux ",
+				"version 6.6\nfoo\n",
+				"bar\n",
+				// A prompt which arrives in pieces without a newline only
+				// matches once.
+				"server log",
+				"in: ",
+				"\r\n",
+				"server login: \n",
+			},
+			want: map[string]map[string]float64{
+				"consrv_device_boot_seconds_bucket": {
+					"name=server,le=5":    1,
+					"name=server,le=10":   1,
+					"name=server,le=20":   1,
+					"name=server,le=30":   1,
+					"name=server,le=60":   1,
+					"name=server,le=120":  1,
+					"name=server,le=300":  1,
+					"name=server,le=600":  1,
+					"name=server,le=+Inf": 1,
+				},
+				"consrv_device_boot_seconds_count": {
+					"name=server": 1,
+				},
+				"consrv_device_boot_stage_seconds_sum": {
+					"name=server,from=uboot,to=kernel": 1,
+					"name=server,from=kernel,to=login": 3,
+				},
+			},
+		},
+		{
+			name: "restart",
+			in: []string{
+				"U-Boot 2024.01\n",
+				"[ 0.0] Linux version 6.6\n",
+				"U-Boot 2024.01\n",
+				"foo\n",
+				"[ 0.0] Linux version 6.6\n",
+				"login: ",
+			},
+			want: map[string]map[string]float64{
+				"consrv_device_boot_seconds_count": {
+					"name=server": 1,
+				},
+				"consrv_device_boot_stage_seconds_sum": {
+					"name=server,from=uboot,to=kernel": 3,
+					"name=server,from=kernel,to=login": 1,
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mem := metricslite.NewMemory()
+			bt := newBootTracker(d, newMetrics(mem), log.New(io.Discard, "", 0))
+
+			var now time.Time
+			bt.now = func() time.Time { return now }
+
+			r := &chunkReader{
+				chunks: tt.in,
+				read:   func() { now = now.Add(1 * time.Second) },
+			}
+			if err := bt.track(r); err != io.EOF {
+				t.Fatalf("failed to track: %v", err)
+			}
+
+			got := make(map[string]map[string]float64)
+			for name := range tt.want {
+				got[name] = nil
+				if s := mem.Series()[name]; len(s.Samples) > 0 {
+					got[name] = s.Samples
+				}
+			}
+
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Fatalf("unexpected boot metrics (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// A chunkReader returns each of its chunks from a call to Read, calling read
+// before each, and then io.EOF.
+type chunkReader struct {
+	chunks []string
+	read   func()
+}
+
+func (r *chunkReader) Read(b []byte) (int, error) {
+	if len(r.chunks) == 0 {
+		return 0, io.EOF
+	}
+
+	r.read()
+	n := copy(b, r.chunks[0])
+	r.chunks = r.chunks[1:]
+	return n, nil
+}
@@ -0,0 +1,52 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import "time"
+
+// brokerEnv is set in the environment of the unprivileged child process
+// started by a broker.
+const brokerEnv = "CONSRV_BROKER_CHILD"
+
+// File descriptors passed from a broker to its child process.
+const (
+	brokerFDSocket = 3
+	brokerFDSSH    = 4
+	brokerFDDebug  = 5
+)
+
+// brokerInit is the first message sent from a broker to its child process.
+type brokerInit struct {
+	Config            []byte            `json:"config"`
+	HostKey           []byte            `json:"host_key"`
+	HostKeyPassphrase []byte            `json:"host_key_passphrase,omitempty"`
+	Serials           map[string]string `json:"serials"`
+
+	USBPaths   map[string]string            `json:"usb_paths,omitempty"`
+	Duplicates map[string][]string          `json:"duplicates,omitempty"`
+	Metadata   map[string]map[string]string `json:"metadata,omitempty"`
+}
+
+// A brokerRequest is a request from the child process to open a device.
+type brokerRequest struct {
+	Device      string        `json:"device"`
+	Baud        int           `json:"baud"`
+	ReadTimeout time.Duration `json:"read_timeout,omitempty"`
+}
+
+// A brokerResponse is the broker's reply to a brokerRequest. On success, the
+// device's file descriptor accompanies the response.
+type brokerResponse struct {
+	Error string `json:"error,omitempty"`
+}
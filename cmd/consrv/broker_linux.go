@@ -0,0 +1,323 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/tarm/serial"
+	"golang.org/x/sys/unix"
+)
+
+// maxBrokerMessage is the maximum size of a message exchanged between a broker
+// and its child process.
+const maxBrokerMessage = 1 << 20
+
+// runBroker starts an unprivileged child process which serves SSH and HTTP on
+// the input listeners, and opens the configured devices on its behalf until
+// the child exits.
+func runBroker(cfg *config, rawCfg []byte, hk hostKey, sshl, httpl net.Listener, sysfs bool, ll *log.Logger) error {
+	fs, err := newFS(ll, sysfs, cfg.maskSerials())
+	if err != nil {
+		return fmt.Errorf("failed to open filesystem: %v", err)
+	}
+
+	// Only the devices named in the configuration may be opened by the child.
+	allowed := make(map[string]bool)
+	for _, d := range fs.expand(cfg.Devices, log.New(io.Discard, "", 0)) {
+		if err := fs.resolve(&d); err == nil && d.Device != "" {
+			allowed[d.Device] = true
+		}
+	}
+
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_SEQPACKET|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("failed to create socket pair: %w", err)
+	}
+
+	parent := os.NewFile(uintptr(fds[0]), "broker")
+	child := os.NewFile(uintptr(fds[1]), "broker-child")
+	defer parent.Close()
+
+	c, err := net.FileConn(parent)
+	if err != nil {
+		return fmt.Errorf("failed to create broker connection: %w", err)
+	}
+	defer c.Close()
+	uc := c.(*net.UnixConn)
+
+	// The child inherits the socket and listeners at well-known descriptors.
+	files := []*os.File{child}
+	for _, l := range []net.Listener{sshl, httpl} {
+		if l == nil {
+			continue
+		}
+
+		f, err := l.(*net.TCPListener).File()
+		if err != nil {
+			return fmt.Errorf("failed to get listener file: %w", err)
+		}
+		defer f.Close()
+
+		files = append(files, f)
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find executable: %w", err)
+	}
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), brokerEnv+"=1")
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = files
+	cmd.SysProcAttr = &syscall.SysProcAttr{Pdeathsig: syscall.SIGKILL}
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start child process: %w", err)
+	}
+	_ = child.Close()
+
+	ll.Printf("broker: started child process %d", cmd.Process.Pid)
+
+	// Forward requests to stop to the child, so that it can drain its sessions
+	// before the broker exits along with it.
+	sigC := make(chan os.Signal, 1)
+	signal.Notify(sigC, syscall.SIGTERM, os.Interrupt)
+	go func() {
+		for sig := range sigC {
+			_ = cmd.Process.Signal(sig)
+		}
+	}()
+
+	msg, err := json.Marshal(brokerInit{
+		Config:            rawCfg,
+		HostKey:           hk.PEM,
+		HostKeyPassphrase: hk.Passphrase,
+		Serials:           fs.serialToDevice,
+		USBPaths:          fs.usbPathToDevice,
+		Duplicates:        fs.duplicates,
+		Metadata:          fs.metadata,
+	})
+	if err != nil {
+		return err
+	}
+	if _, _, err := uc.WriteMsgUnix(msg, nil, nil); err != nil {
+		return fmt.Errorf("failed to initialize child process: %w", err)
+	}
+
+	serveBroker(uc, fs, allowed, ll)
+
+	// The child only exits successfully when it is stopped gracefully.
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("child process exited: %w", err)
+	}
+
+	return nil
+}
+
+// serveBroker opens devices in response to requests from a child process until
+// the child closes its end of the connection.
+func serveBroker(uc *net.UnixConn, fs *fs, allowed map[string]bool, ll *log.Logger) {
+	b := make([]byte, maxBrokerMessage)
+	for {
+		n, _, _, _, err := uc.ReadMsgUnix(b, nil)
+		if err != nil || n == 0 {
+			// The child has closed its end of the socket, so it's exiting.
+			return
+		}
+
+		var (
+			req brokerRequest
+			res brokerResponse
+			oob []byte
+		)
+
+		f, err := func() (*os.File, error) {
+			if err := json.Unmarshal(b[:n], &req); err != nil {
+				return nil, fmt.Errorf("failed to parse request: %w", err)
+			}
+
+			return brokerOpen(fs, allowed, req)
+		}()
+		if err != nil {
+			res.Error = err.Error()
+			errorf(ll, "broker: failed to open device %q: %v", req.Device, err)
+		} else {
+			oob = unix.UnixRights(int(f.Fd()))
+			ll.Printf("broker: opened device %q", req.Device)
+		}
+
+		rb, _ := json.Marshal(res)
+		_, _, err = uc.WriteMsgUnix(rb, oob, nil)
+		if f != nil {
+			// The child has its own copy of the descriptor now.
+			_ = f.Close()
+		}
+		if err != nil {
+			errorf(ll, "broker: failed to reply to child process: %v", err)
+			return
+		}
+	}
+}
+
+// brokerOpen opens and configures the device requested by req, if permitted.
+func brokerOpen(fs *fs, allowed map[string]bool, req brokerRequest) (*os.File, error) {
+	if !allowed[req.Device] {
+		return nil, fmt.Errorf("device %q is not configured", req.Device)
+	}
+
+	// The serial package doesn't expose its file descriptor, so use it to
+	// configure the device and then open a second descriptor which shares
+	// the terminal settings.
+	port, err := fs.openPort(&serial.Config{
+		Name:        req.Device,
+		Baud:        req.Baud,
+		ReadTimeout: req.ReadTimeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer port.Close()
+
+	return os.OpenFile(req.Device, os.O_RDWR|unix.O_NOCTTY, 0)
+}
+
+// runBrokerChild runs consrv as the unprivileged child of a broker.
+func runBrokerChild(lv *logLevel, ll *log.Logger) {
+	c, err := net.FileConn(os.NewFile(brokerFDSocket, "broker"))
+	if err != nil {
+		fatalf(ll, "failed to open broker connection: %v", err)
+	}
+	bc := &brokerClient{c: c.(*net.UnixConn)}
+
+	b := make([]byte, maxBrokerMessage)
+	n, _, _, _, err := bc.c.ReadMsgUnix(b, nil)
+	if err != nil {
+		fatalf(ll, "failed to read broker initialization: %v", err)
+	}
+
+	var msg brokerInit
+	if err := json.Unmarshal(b[:n], &msg); err != nil {
+		fatalf(ll, "failed to parse broker initialization: %v", err)
+	}
+
+	cfg, err := parseConfig(bytes.NewReader(msg.Config))
+	if err != nil {
+		fatalf(ll, "failed to parse config: %v", err)
+	}
+
+	sshl, err := net.FileListener(os.NewFile(brokerFDSSH, "ssh"))
+	if err != nil {
+		fatalf(ll, "failed to open SSH listener: %v", err)
+	}
+
+	var httpl net.Listener
+	if cfg.Debug.Address != "" {
+		httpl, err = net.FileListener(os.NewFile(brokerFDDebug, "debug"))
+		if err != nil {
+			fatalf(ll, "failed to open HTTP debug listener: %v", err)
+		}
+	}
+
+	// All devices are opened by the broker, so the child can drop privileges
+	// before doing anything else.
+	info, err := dropPrivileges()
+	if err != nil {
+		fatalf(ll, "failed to drop privileges: %v", err)
+	}
+
+	ll.Printf("broker child: dropped privileges: chroot: %q, UID: %d GID: %d, seccomp: %t",
+		info.Chroot, info.UID, info.GID, info.Seccomp)
+
+	fs := &fs{
+		serialToDevice:  msg.Serials,
+		usbPathToDevice: msg.USBPaths,
+		duplicates:      msg.Duplicates,
+		metadata:        msg.Metadata,
+		openPort:        bc.openPort,
+	}
+
+	// The child can't reload the host key because it has no access to the
+	// host key file.
+	hk := hostKey{
+		PEM:        msg.HostKey,
+		Passphrase: msg.HostKeyPassphrase,
+	}
+
+	run(cfg, hk, fs, sshl, httpl, nil, nil, lv, ll)
+}
+
+// A brokerClient requests devices from a broker.
+type brokerClient struct {
+	mu sync.Mutex
+	c  *net.UnixConn
+}
+
+// openPort requests that the broker open and configure a serial port.
+func (bc *brokerClient) openPort(cfg *serial.Config) (io.ReadWriteCloser, error) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	req, err := json.Marshal(brokerRequest{
+		Device:      cfg.Name,
+		Baud:        cfg.Baud,
+		ReadTimeout: cfg.ReadTimeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err := bc.c.WriteMsgUnix(req, nil, nil); err != nil {
+		return nil, fmt.Errorf("failed to send broker request: %w", err)
+	}
+
+	b := make([]byte, maxBrokerMessage)
+	oob := make([]byte, unix.CmsgSpace(4))
+	n, oobn, _, _, err := bc.c.ReadMsgUnix(b, oob)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read broker response: %w", err)
+	}
+
+	var res brokerResponse
+	if err := json.Unmarshal(b[:n], &res); err != nil {
+		return nil, fmt.Errorf("failed to parse broker response: %w", err)
+	}
+	if res.Error != "" {
+		return nil, fmt.Errorf("broker: %s", res.Error)
+	}
+
+	scms, err := unix.ParseSocketControlMessage(oob[:oobn])
+	if err != nil || len(scms) != 1 {
+		return nil, fmt.Errorf("broker response has no file descriptor: %v", err)
+	}
+	fds, err := unix.ParseUnixRights(&scms[0])
+	if err != nil || len(fds) != 1 {
+		return nil, fmt.Errorf("broker response has no file descriptor: %v", err)
+	}
+
+	return os.NewFile(uintptr(fds[0]), cfg.Name), nil
+}
@@ -0,0 +1,109 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package main
+
+import (
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/tarm/serial"
+	"golang.org/x/sys/unix"
+)
+
+func TestBroker(t *testing.T) {
+	// Use regular files as stand-ins for devices.
+	dir := t.TempDir()
+	var (
+		good = filepath.Join(dir, "good")
+		bad  = filepath.Join(dir, "bad")
+	)
+	for _, f := range []string{good, bad} {
+		if err := os.WriteFile(f, nil, 0o600); err != nil {
+			t.Fatalf("failed to create file: %v", err)
+		}
+	}
+
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_SEQPACKET|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		t.Fatalf("failed to create socket pair: %v", err)
+	}
+
+	broker, child := testUnixConn(t, fds[0]), testUnixConn(t, fds[1])
+
+	fs := &fs{
+		openPort: func(_ *serial.Config) (io.ReadWriteCloser, error) {
+			return os.Open(os.DevNull)
+		},
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		serveBroker(broker, fs, map[string]bool{good: true}, log.New(io.Discard, "", 0))
+	}()
+
+	bc := &brokerClient{c: child}
+
+	if _, err := bc.openPort(&serial.Config{Name: bad, Baud: 115200}); err == nil {
+		t.Fatal("expected an error opening unconfigured device, but none occurred")
+	}
+
+	rwc, err := bc.openPort(&serial.Config{Name: good, Baud: 115200})
+	if err != nil {
+		t.Fatalf("failed to open device: %v", err)
+	}
+
+	const msg = "hello world"
+	if _, err := io.WriteString(rwc, msg); err != nil {
+		t.Fatalf("failed to write device: %v", err)
+	}
+	_ = rwc.Close()
+
+	// Closing the child's end of the connection halts the broker.
+	_ = child.Close()
+	<-done
+
+	b, err := os.ReadFile(good)
+	if err != nil {
+		t.Fatalf("failed to read file: %v", err)
+	}
+
+	if diff := cmp.Diff(msg, string(b)); diff != "" {
+		t.Fatalf("unexpected device contents (-want +got):\n%s", diff)
+	}
+}
+
+func testUnixConn(t *testing.T, fd int) *net.UnixConn {
+	t.Helper()
+
+	f := os.NewFile(uintptr(fd), "test")
+	defer f.Close()
+
+	c, err := net.FileConn(f)
+	if err != nil {
+		t.Fatalf("failed to create connection: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = c.Close()
+	})
+
+	return c.(*net.UnixConn)
+}
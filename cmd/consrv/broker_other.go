@@ -0,0 +1,31 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"runtime"
+)
+
+func runBroker(_ *config, _ []byte, _ hostKey, _, _ net.Listener, _ bool, _ *log.Logger) error {
+	return fmt.Errorf("implemented only on Linux, not on %s/%s", runtime.GOOS, runtime.GOARCH)
+}
+
+func runBrokerChild(lv *logLevel, ll *log.Logger) {
+	fatalf(ll, "broker child process implemented only on Linux, not on %s/%s", runtime.GOOS, runtime.GOARCH)
+}
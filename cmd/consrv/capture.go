@@ -0,0 +1,180 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/mdlayher/consrv"
+)
+
+// defaultCaptureSize is the default number of bytes of output captured for
+// each device.
+const defaultCaptureSize = 1 << 20
+
+// A captureBuffer retains the most recent output of a device along with the
+// time it was read.
+type captureBuffer struct {
+	mu     sync.Mutex
+	size   int
+	n      int
+	chunks []captureChunk
+	lr     lineRedactor
+	now    func() time.Time
+}
+
+// A captureChunk is a chunk of output read at a point in time.
+type captureChunk struct {
+	t time.Time
+	b []byte
+}
+
+// newCaptureBuffer creates a captureBuffer which retains up to size bytes,
+// after applying the redactions in rd.
+func newCaptureBuffer(size int, rd redactor) *captureBuffer {
+	return &captureBuffer{
+		size: size,
+		lr:   lineRedactor{rd: rd},
+		now:  time.Now,
+	}
+}
+
+// run captures output from the mux until the process exits, restarting the
+// capture if it stops.
+func (cb *captureBuffer) run(name string, mux *consrv.MuxDevice, ll *log.Logger) {
+	for {
+		ctx, cancel := context.WithCancel(context.Background())
+		if _, err := io.Copy(cb, mux.Attach(ctx)); err != nil {
+			errorf(ll, "capturing output for %q: %v", name, err)
+		}
+		cancel()
+
+		ll.Printf("restarting capture for %q", name)
+		time.Sleep(1 * time.Second)
+	}
+}
+
+// Write implements io.Writer by capturing a redacted copy of b, discarding the
+// oldest output if necessary. If there are redaction rules, a partial line is
+// not captured until it is completed.
+func (cb *captureBuffer) Write(b []byte) (int, error) {
+	n := len(b)
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	b = cb.lr.redact(b)
+	if len(b) == 0 {
+		return n, nil
+	}
+	if len(b) > cb.size {
+		b = b[len(b)-cb.size:]
+	}
+
+	cb.chunks = append(cb.chunks, captureChunk{
+		t: cb.now(),
+		b: append([]byte(nil), b...),
+	})
+	cb.n += len(b)
+
+	var i int
+	for cb.n > cb.size {
+		cb.n -= len(cb.chunks[i].b)
+		cb.chunks[i] = captureChunk{}
+		i++
+	}
+	cb.chunks = cb.chunks[i:]
+
+	return n, nil
+}
+
+// between returns the output read at or after since and before until, and the
+// time the oldest retained output was read. If until is zero, all output after
+// since is returned.
+func (cb *captureBuffer) between(since, until time.Time) ([]byte, time.Time) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	var (
+		out    []byte
+		oldest time.Time
+	)
+	if len(cb.chunks) > 0 {
+		oldest = cb.chunks[0].t
+	}
+
+	for _, c := range cb.chunks {
+		if !c.t.Before(since) && (until.IsZero() || c.t.Before(until)) {
+			out = append(out, c.b...)
+		}
+	}
+
+	return out, oldest
+}
+
+// A captureHandler serves captured device output over HTTP:
+//
+//	GET /capture/{device}?since=2024-12-20T19:00:00Z&until=2024-12-20T19:30:00Z
+//
+// Both times are RFC 3339 and optional. By default, all captured output is
+// returned.
+type captureHandler map[string]*captureBuffer
+
+// ServeHTTP implements http.Handler.
+func (ch captureHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	cb, ok := ch[r.PathValue("device")]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	parse := func(key string, def time.Time) (time.Time, bool) {
+		s := r.URL.Query().Get(key)
+		if s == "" {
+			return def, true
+		}
+
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			http.Error(w, "invalid "+key+" time: "+err.Error(), http.StatusBadRequest)
+			return time.Time{}, false
+		}
+
+		return t, true
+	}
+
+	since, ok := parse("since", time.Time{})
+	if !ok {
+		return
+	}
+	until, ok := parse("until", time.Time{})
+	if !ok {
+		return
+	}
+
+	b, oldest := cb.between(since, until)
+
+	// Let the client know whether output in its window may have been
+	// discarded.
+	if !oldest.IsZero() {
+		w.Header().Set("X-Consrv-Capture-Oldest", oldest.UTC().Format(time.RFC3339Nano))
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	_, _ = w.Write(b)
+}
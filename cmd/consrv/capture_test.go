@@ -0,0 +1,122 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_captureHandler(t *testing.T) {
+	// Capture one chunk of output per second, retaining only the most recent
+	// 8 bytes, so the first two chunks are discarded.
+	start := time.Date(2024, time.December, 20, 19, 0, 0, 0, time.UTC)
+	cb := newCaptureBuffer(8, nil)
+
+	now := start
+	cb.now = func() time.Time { return now }
+	for _, s := range []string{"aaa", "bbb", "ccc", "ddd"} {
+		now = now.Add(1 * time.Second)
+		if _, err := io.WriteString(cb, s); err != nil {
+			t.Fatalf("failed to write: %v", err)
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /capture/{device}", captureHandler{"server": cb})
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	tests := []struct {
+		name   string
+		path   string
+		status int
+		body   string
+	}{
+		{
+			name:   "unknown device",
+			path:   "/capture/desktop",
+			status: http.StatusNotFound,
+			body:   "404 page not found\n",
+		},
+		{
+			name:   "bad time",
+			path:   "/capture/server?since=foo",
+			status: http.StatusBadRequest,
+			body:   "invalid since time: parsing time \"foo\" as \"2006-01-02T15:04:05.999999999Z07:00\": cannot parse \"foo\" as \"2006\"\n",
+		},
+		{
+			name:   "all",
+			path:   "/capture/server",
+			status: http.StatusOK,
+			body:   "cccddd",
+		},
+		{
+			name:   "window",
+			path:   "/capture/server?since=2024-12-20T19:00:03Z&until=2024-12-20T19:00:04Z",
+			status: http.StatusOK,
+			body:   "ccc",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := http.Get(srv.URL + tt.path)
+			if err != nil {
+				t.Fatalf("failed to get: %v", err)
+			}
+			defer res.Body.Close()
+
+			b, err := io.ReadAll(res.Body)
+			if err != nil {
+				t.Fatalf("failed to read body: %v", err)
+			}
+
+			if diff := cmp.Diff(tt.status, res.StatusCode); diff != "" {
+				t.Fatalf("unexpected status (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tt.body, string(b)); diff != "" {
+				t.Fatalf("unexpected body (-want +got):\n%s", diff)
+			}
+
+			if res.StatusCode == http.StatusOK {
+				const oldest = "2024-12-20T19:00:03Z"
+				if diff := cmp.Diff(oldest, res.Header.Get("X-Consrv-Capture-Oldest")); diff != "" {
+					t.Fatalf("unexpected oldest capture time (-want +got):\n%s", diff)
+				}
+			}
+		})
+	}
+}
+
+func Test_captureBufferRedactSplit(t *testing.T) {
+	cb := newCaptureBuffer(1024, newRedactor([]redactRule{{Pattern: `hunter2`}}))
+	for _, s := range []string{"Password: hun", "ter2\r\n", "ok"} {
+		if _, err := io.WriteString(cb, s); err != nil {
+			t.Fatalf("failed to write: %v", err)
+		}
+	}
+
+	// The final partial line is held back until it is completed.
+	got, _ := cb.between(time.Time{}, time.Time{})
+	if diff := cmp.Diff("Password: [redacted]\r\n", string(got)); diff != "" {
+		t.Fatalf("unexpected capture (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,276 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/mdlayher/consrv"
+)
+
+// A chaosFault is a device error simulated by a chaosDevice.
+type chaosFault int
+
+// Possible chaosFault values.
+const (
+	faultEIO chaosFault = iota
+	faultShortReads
+	faultDisappear
+)
+
+// String returns the description of a chaosFault used in logs.
+func (f chaosFault) String() string {
+	switch f {
+	case faultEIO:
+		return "I/O error"
+	case faultShortReads:
+		return "short reads"
+	case faultDisappear:
+		return "device disappearance"
+	default:
+		panic("unhandled chaos fault")
+	}
+}
+
+// chaosShortReads is the number of reads truncated by faultShortReads.
+const chaosShortReads = 16
+
+var _ consrv.Device = &chaosDevice{}
+
+// A chaosDevice is a consrv.Device which simulates device errors on a
+// schedule, so that operators can verify their monitoring and the reconnection
+// of devices before relying on them. Faults are injected even while the device
+// is idle, so reads from the underlying device occur in their own goroutine.
+type chaosDevice struct {
+	consrv.Device
+	name     string
+	downtime time.Duration
+	ll       *log.Logger
+
+	faultC   chan chaosFault
+	readC    chan chaosRead
+	resumeC  chan struct{}
+	done     chan struct{}
+	doneOnce sync.Once
+
+	// Only accessed by Read and reconnect, which the mux never calls
+	// concurrently.
+	pending   chaosRead
+	short     int
+	simulated bool
+
+	mu   sync.Mutex
+	down bool
+}
+
+// A chaosRead is the result of a read from the device underlying a
+// chaosDevice.
+type chaosRead struct {
+	b   []byte
+	err error
+}
+
+// newChaosDevice wraps d to simulate a random fault at intervals of roughly
+// every.
+func newChaosDevice(d consrv.Device, name string, every time.Duration, ll *log.Logger) *chaosDevice {
+	cd := &chaosDevice{
+		Device:   d,
+		name:     name,
+		downtime: min(every/2, 10*time.Second),
+		ll:       ll,
+		faultC:   make(chan chaosFault),
+		readC:    make(chan chaosRead),
+		resumeC:  make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	go cd.read()
+	go cd.schedule(every)
+	return cd
+}
+
+// Close implements io.ReadWriteCloser.
+func (d *chaosDevice) Close() error {
+	d.doneOnce.Do(func() { close(d.done) })
+	return d.Device.Close()
+}
+
+// Read implements io.ReadWriteCloser.
+func (d *chaosDevice) Read(b []byte) (int, error) {
+	for len(d.pending.b) == 0 && d.pending.err == nil {
+		select {
+		case <-d.done:
+			return 0, io.EOF
+		case r := <-d.readC:
+			d.pending = r
+		case f := <-d.faultC:
+			d.ll.Printf("%s: simulating %s", d.name, f)
+
+			switch f {
+			case faultEIO:
+				d.simulated = true
+				return 0, syscall.EIO
+			case faultShortReads:
+				d.short = chaosShortReads
+			case faultDisappear:
+				d.simulated = true
+				d.mu.Lock()
+				d.down = true
+				d.mu.Unlock()
+				return 0, io.EOF
+			}
+		}
+	}
+
+	if d.short > 0 {
+		d.short--
+		b = b[:min(len(b), 1+rand.IntN(8))]
+	}
+
+	// Return any read data, and any error once all of the data is consumed.
+	n := copy(b, d.pending.b)
+	d.pending.b = d.pending.b[n:]
+	if len(d.pending.b) > 0 {
+		return n, nil
+	}
+
+	err := d.pending.err
+	d.pending = chaosRead{}
+	return n, err
+}
+
+// reconnect implements reconnector. Simulated faults are recovered from after
+// the device's downtime, and otherwise the underlying device is reconnected if
+// it supports reconnection.
+func (d *chaosDevice) reconnect(err error) bool {
+	if d.simulated {
+		d.simulated = false
+
+		d.mu.Lock()
+		down := d.down
+		d.mu.Unlock()
+
+		if down {
+			select {
+			case <-d.done:
+				return false
+			case <-time.After(d.downtime):
+			}
+
+			d.mu.Lock()
+			d.down = false
+			d.mu.Unlock()
+		}
+
+		d.ll.Printf("%s: recovered from simulated fault", d.name)
+		return true
+	}
+
+	rc, ok := d.Device.(reconnector)
+	if !ok || !rc.reconnect(err) {
+		return false
+	}
+
+	// Resume reading from the reconnected device.
+	select {
+	case <-d.done:
+		return false
+	case d.resumeC <- struct{}{}:
+		return true
+	}
+}
+
+// connected implements connector.
+func (d *chaosDevice) connected() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.down {
+		return false
+	}
+
+	c, ok := d.Device.(connector)
+	return !ok || c.connected()
+}
+
+// read reads from the underlying device until the chaosDevice is closed. After
+// an error, reads resume once the underlying device is reconnected.
+func (d *chaosDevice) read() {
+	for {
+		b := make([]byte, 4096)
+		n, err := d.Device.Read(b)
+
+		select {
+		case <-d.done:
+			return
+		case d.readC <- chaosRead{b: b[:n], err: err}:
+		}
+
+		if err == nil {
+			continue
+		}
+
+		select {
+		case <-d.done:
+			return
+		case <-d.resumeC:
+		}
+	}
+}
+
+// schedule injects a random fault at jittered intervals around every until the
+// chaosDevice is closed.
+func (d *chaosDevice) schedule(every time.Duration) {
+	for {
+		jitter := time.Duration(rand.Int64N(int64(every)))
+		select {
+		case <-d.done:
+			return
+		case <-time.After(every/2 + jitter):
+		}
+
+		select {
+		case <-d.done:
+			return
+		case d.faultC <- chaosFault(rand.IntN(3)):
+		}
+	}
+}
+
+// chaosFlag is the name of the flag which enables chaosDevices. The flag is
+// intended for developers and operators verifying their monitoring, so it is
+// omitted from the usage message.
+const chaosFlag = "simulate-device-errors"
+
+// usage prints the usage message for the flags other than chaosFlag.
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s:\n", os.Args[0])
+
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	fs.SetOutput(flag.CommandLine.Output())
+	flag.VisitAll(func(f *flag.Flag) {
+		if f.Name != chaosFlag {
+			fs.Var(f.Value, f.Name, f.Usage)
+		}
+	})
+	fs.PrintDefaults()
+}
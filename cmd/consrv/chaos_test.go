@@ -0,0 +1,104 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_chaosDevice(t *testing.T) {
+	sd := &scriptDevice{readC: make(chan []byte)}
+	d := newChaosDevice(sd, "server", time.Hour, log.New(io.Discard, "", 0))
+	d.downtime = 0
+
+	// The underlying device is closed by the test, so only stop the
+	// chaosDevice's goroutines on exit.
+	defer d.doneOnce.Do(func() { close(d.done) })
+
+	b := make([]byte, 64)
+	read := func(fault *chaosFault, data string) (string, error) {
+		t.Helper()
+
+		go func() {
+			if fault != nil {
+				d.faultC <- *fault
+			}
+			if data != "" {
+				sd.readC <- []byte(data)
+			}
+		}()
+
+		n, err := d.Read(b)
+		return string(b[:n]), err
+	}
+
+	fault := func(f chaosFault) *chaosFault { return &f }
+
+	// Output passes through until a fault is simulated.
+	got, err := read(nil, "hello")
+	if err != nil {
+		t.Fatalf("failed to read: %v", err)
+	}
+	if diff := cmp.Diff("hello", got); diff != "" {
+		t.Fatalf("unexpected output (-want +got):\n%s", diff)
+	}
+
+	if _, err := read(fault(faultEIO), ""); !errors.Is(err, syscall.EIO) {
+		t.Fatalf("expected EIO, but got: %v", err)
+	}
+	if !d.reconnect(syscall.EIO) {
+		t.Fatal("device should recover from a simulated I/O error")
+	}
+
+	// Short reads return part of the output at a time.
+	got, err = read(fault(faultShortReads), strings.Repeat("x", 32))
+	if err != nil {
+		t.Fatalf("failed to read: %v", err)
+	}
+	if len(got) == 0 || len(got) > 8 {
+		t.Fatalf("expected a short read, but got %d bytes", len(got))
+	}
+	d.pending, d.short = chaosRead{}, 0
+
+	if _, err := read(fault(faultDisappear), ""); err != io.EOF {
+		t.Fatalf("expected EOF, but got: %v", err)
+	}
+	if d.connected() {
+		t.Fatal("device should be disconnected after disappearing")
+	}
+	if !d.reconnect(io.EOF) {
+		t.Fatal("device should recover from a simulated disappearance")
+	}
+	if !d.connected() {
+		t.Fatal("device should be connected after recovering")
+	}
+
+	// Errors from the underlying device are not simulated, so the device can
+	// only recover if the underlying device reconnects.
+	_ = sd.Close()
+	if _, err := d.Read(b); err != io.EOF {
+		t.Fatalf("expected EOF, but got: %v", err)
+	}
+	if d.reconnect(io.EOF) {
+		t.Fatal("device should not recover from a real error")
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/mdlayher/consrv"
+)
+
+// maxCoalesce is the number of buffered bytes at which a coalesceDevice
+// flushes without waiting for its interval.
+const maxCoalesce = 4096
+
+var _ consrv.Device = &coalesceDevice{}
+
+// A coalesceDevice is a consrv.Device which batches small writes, such as
+// individual keystrokes of pasted text, into fewer writes to the underlying
+// device. Buffered bytes are flushed once the interval passes after the first
+// buffered write, or immediately once maxCoalesce bytes are buffered.
+//
+// Because writes return before they are flushed, an error from a flush is
+// returned by the following write.
+type coalesceDevice struct {
+	consrv.Device
+	interval time.Duration
+
+	mu    sync.Mutex
+	buf   []byte
+	timer *time.Timer
+	err   error
+}
+
+// newCoalesceDevice wraps d to coalesce writes within interval.
+func newCoalesceDevice(d consrv.Device, interval time.Duration) *coalesceDevice {
+	return &coalesceDevice{
+		Device:   d,
+		interval: interval,
+	}
+}
+
+// Write implements io.ReadWriteCloser.
+func (d *coalesceDevice) Write(b []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.err; err != nil {
+		d.err = nil
+		return 0, err
+	}
+
+	d.buf = append(d.buf, b...)
+	switch {
+	case len(d.buf) >= maxCoalesce:
+		if d.timer != nil {
+			d.timer.Stop()
+			d.timer = nil
+		}
+
+		d.flushLocked()
+		if err := d.err; err != nil {
+			d.err = nil
+			return 0, err
+		}
+	case d.timer == nil:
+		d.timer = time.AfterFunc(d.interval, d.flush)
+	}
+
+	return len(b), nil
+}
+
+// flush writes any buffered bytes to the underlying device.
+func (d *coalesceDevice) flush() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.timer = nil
+	d.flushLocked()
+}
+
+// flushLocked writes any buffered bytes to the underlying device and records
+// any error for the next write. The caller must hold d.mu.
+func (d *coalesceDevice) flushLocked() {
+	if len(d.buf) == 0 {
+		return
+	}
+
+	_, err := d.Device.Write(d.buf)
+	d.buf = d.buf[:0]
+	if err != nil {
+		d.err = err
+	}
+}
+
+// Close implements io.ReadWriteCloser, flushing any buffered bytes first.
+func (d *coalesceDevice) Close() error {
+	d.mu.Lock()
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+	d.flushLocked()
+	d.mu.Unlock()
+
+	return d.Device.Close()
+}
+
+// connected implements connector for devices which reconnect, and otherwise
+// reports that the device is connected.
+func (d *coalesceDevice) connected() bool {
+	c, ok := d.Device.(connector)
+	return !ok || c.connected()
+}
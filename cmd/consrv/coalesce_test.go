@@ -0,0 +1,135 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_coalesceDevice(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval time.Duration
+		writes   []string
+		wait     bool
+		want     []string
+	}{
+		{
+			name:     "pending until interval",
+			interval: time.Hour,
+			writes:   []string{"h", "e", "l", "l", "o"},
+		},
+		{
+			name:     "flush after interval",
+			interval: 100 * time.Millisecond,
+			writes:   []string{"h", "e", "l", "l", "o"},
+			wait:     true,
+			want:     []string{"hello"},
+		},
+		{
+			name:     "flush when full",
+			interval: time.Hour,
+			writes:   []string{"x", strings.Repeat("y", maxCoalesce)},
+			want:     []string{"x" + strings.Repeat("y", maxCoalesce)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rd := &recordDevice{writeC: make(chan struct{}, 1)}
+			d := newCoalesceDevice(rd, tt.interval)
+
+			for _, w := range tt.writes {
+				if _, err := d.Write([]byte(w)); err != nil {
+					t.Fatalf("failed to write: %v", err)
+				}
+			}
+			if tt.wait {
+				<-rd.writeC
+			}
+
+			if diff := cmp.Diff(tt.want, rd.get()); diff != "" {
+				t.Fatalf("unexpected writes (-want +got):\n%s", diff)
+			}
+
+			// Close always flushes the remaining bytes.
+			if err := d.Close(); err != nil {
+				t.Fatalf("failed to close: %v", err)
+			}
+			if diff := cmp.Diff(strings.Join(tt.writes, ""), strings.Join(rd.get(), "")); diff != "" {
+				t.Fatalf("unexpected bytes after close (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_coalesceDeviceError(t *testing.T) {
+	errIO := errors.New("input/output error")
+	rd := &recordDevice{writeC: make(chan struct{}, 1), err: errIO}
+	d := newCoalesceDevice(rd, time.Millisecond)
+
+	if _, err := d.Write([]byte("a")); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+	<-rd.writeC
+
+	// The flush error is returned by the next write.
+	if _, err := d.Write([]byte("b")); !errors.Is(err, errIO) {
+		t.Fatalf("expected flush error, but got: %v", err)
+	}
+}
+
+// A recordDevice is a consrv.Device which records each write.
+type recordDevice struct {
+	writeC chan struct{}
+	err    error
+
+	mu     sync.Mutex
+	writes []string
+}
+
+func (d *recordDevice) Read([]byte) (int, error) { select {} }
+
+func (d *recordDevice) Write(b []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.writes = append(d.writes, string(b))
+	select {
+	case d.writeC <- struct{}{}:
+	default:
+	}
+
+	if d.err != nil {
+		return 0, d.err
+	}
+
+	return len(b), nil
+}
+
+func (d *recordDevice) Close() error   { return nil }
+func (d *recordDevice) String() string { return "record" }
+
+func (d *recordDevice) get() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return append([]string(nil), d.writes...)
+}
@@ -0,0 +1,96 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression formats for output sent to a tee command.
+const (
+	compressNone = ""
+	compressGzip = "gzip"
+	compressZstd = "zstd"
+)
+
+// A flushWriter is a compressing io.Writer which can flush its buffered data.
+type flushWriter interface {
+	io.Writer
+	Flush() error
+}
+
+// A batchWriter buffers output until it is flushed, optionally compressing it,
+// to reduce the number and size of writes over a slow link.
+type batchWriter struct {
+	bw *bufio.Writer
+	cw flushWriter
+	n  int
+}
+
+// newBatchWriter creates a batchWriter which writes batches of up to size
+// bytes to w using the specified compression format.
+func newBatchWriter(w io.Writer, compression string, size int) (*batchWriter, error) {
+	bw := bufio.NewWriterSize(w, size)
+
+	var cw flushWriter
+	switch compression {
+	case compressNone:
+	case compressGzip:
+		cw = gzip.NewWriter(bw)
+	case compressZstd:
+		zw, err := zstd.NewWriter(bw, zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		cw = zw
+	default:
+		return nil, fmt.Errorf("unknown compression %q", compression)
+	}
+
+	return &batchWriter{bw: bw, cw: cw}, nil
+}
+
+// Write implements io.Writer.
+func (bw *batchWriter) Write(b []byte) (int, error) {
+	var w io.Writer = bw.bw
+	if bw.cw != nil {
+		w = bw.cw
+	}
+
+	n, err := w.Write(b)
+	bw.n += n
+	return n, err
+}
+
+// Buffered returns the number of uncompressed bytes written since the last
+// flush.
+func (bw *batchWriter) Buffered() int { return bw.n }
+
+// Flush writes any buffered output, completing a block of compressed output
+// which can be decompressed by the receiver immediately.
+func (bw *batchWriter) Flush() error {
+	bw.n = 0
+	if bw.cw != nil {
+		if err := bw.cw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	return bw.bw.Flush()
+}
@@ -0,0 +1,152 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/klauspost/compress/zstd"
+)
+
+func Test_batchWriter(t *testing.T) {
+	tests := []struct {
+		name        string
+		compression string
+		decompress  func(t *testing.T, r io.Reader) io.Reader
+	}{
+		{
+			name:       "none",
+			decompress: func(_ *testing.T, r io.Reader) io.Reader { return r },
+		},
+		{
+			name:        "gzip",
+			compression: compressGzip,
+			decompress: func(t *testing.T, r io.Reader) io.Reader {
+				zr, err := gzip.NewReader(r)
+				if err != nil {
+					t.Fatalf("failed to create gzip reader: %v", err)
+				}
+				return zr
+			},
+		},
+		{
+			name:        "zstd",
+			compression: compressZstd,
+			decompress: func(t *testing.T, r io.Reader) io.Reader {
+				zr, err := zstd.NewReader(r)
+				if err != nil {
+					t.Fatalf("failed to create zstd reader: %v", err)
+				}
+				t.Cleanup(zr.Close)
+				return zr
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			bw, err := newBatchWriter(&buf, tt.compression, 4096)
+			if err != nil {
+				t.Fatalf("failed to create batch writer: %v", err)
+			}
+
+			for _, s := range []string{"hello ", "world"} {
+				if _, err := bw.Write([]byte(s)); err != nil {
+					t.Fatalf("failed to write: %v", err)
+				}
+			}
+
+			// Nothing reaches the underlying writer until a flush.
+			if diff := cmp.Diff(0, buf.Len()); diff != "" {
+				t.Fatalf("unexpected bytes before flush (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(11, bw.Buffered()); diff != "" {
+				t.Fatalf("unexpected buffered bytes (-want +got):\n%s", diff)
+			}
+
+			if err := bw.Flush(); err != nil {
+				t.Fatalf("failed to flush: %v", err)
+			}
+			if diff := cmp.Diff(0, bw.Buffered()); diff != "" {
+				t.Fatalf("unexpected buffered bytes after flush (-want +got):\n%s", diff)
+			}
+
+			// A flushed batch can be decompressed before the stream ends.
+			b := make([]byte, 11)
+			if _, err := io.ReadFull(tt.decompress(t, &buf), b); err != nil {
+				t.Fatalf("failed to read batch: %v", err)
+			}
+
+			if diff := cmp.Diff("hello world", string(b)); diff != "" {
+				t.Fatalf("unexpected output (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_teeConfigValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		tc   teeConfig
+		want teeConfig
+		ok   bool
+	}{
+		{
+			name: "unknown compression",
+			tc:   teeConfig{Command: []string{"cat"}, Compression: "lz4"},
+		},
+		{
+			name: "negative flush interval",
+			tc:   teeConfig{Command: []string{"cat"}, FlushInterval: duration{-1}},
+		},
+		{
+			name: "negative batch size",
+			tc:   teeConfig{Command: []string{"cat"}, BatchSize: -1},
+		},
+		{
+			name: "default batch size",
+			tc:   teeConfig{Command: []string{"cat"}, Compression: compressZstd},
+			want: teeConfig{
+				Command:     []string{"cat"},
+				Compression: compressZstd,
+				BatchSize:   teeBatchSize,
+			},
+			ok: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.tc.validate("server")
+			if tt.ok && err != nil {
+				t.Fatalf("failed to validate: %v", err)
+			}
+			if !tt.ok {
+				if err == nil {
+					t.Fatal("expected an error, but none occurred")
+				}
+				return
+			}
+
+			if diff := cmp.Diff(tt.want, tt.tc); diff != "" {
+				t.Fatalf("unexpected config (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
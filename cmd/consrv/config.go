@@ -14,79 +14,358 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"log"
+	"maps"
 	"net"
+	"path/filepath"
+	"slices"
+	"time"
 
 	"github.com/BurntSushi/toml"
 	"github.com/gliderlabs/ssh"
+	"github.com/mdlayher/consrv"
 )
 
 // A config is the consrv configuration.
 type config struct {
 	Server     server
 	Devices    []rawDevice
+	Remotes    []remoteConfig
+	Listeners  []listenerConfig
 	Identities []identity
 	Debug      debug
+	Stats      statsConfig
+	Log        logConfig
+	MDNS       mdnsConfig
+	Standby    *standbyConfig
+	OIDC       *oidcConfig
+	Vault      *vaultConfig
+	Memory     memoryConfig
+
+	Provisioning *provisioningConfig
+	Enumeration  *enumerationConfig
+
+	// UserCAKeys are the parsed server trusted_user_ca_keys.
+	UserCAKeys []ssh.PublicKey
+
+	// Hash is the hex SHA-256 hash of the configuration file.
+	Hash string
+
+	// Raw is set by main to the configuration file without any provisioning
+	// fragment, which provisioning changes are validated against.
+	Raw []byte
+
+	// SimulateDeviceErrors is set by the hidden -simulate-device-errors flag
+	// rather than the configuration file.
+	SimulateDeviceErrors time.Duration
 }
 
 // server contains consrv SSH server configuration.
 type server struct {
-	Address string `toml:"address"`
+	Address               string    `toml:"address"`
+	HostKeyPassphraseFile string    `toml:"host_key_passphrase_file"`
+	AuthLog               string    `toml:"auth_log"`
+	AuthLogFormat         string    `toml:"auth_log_format"`
+	MaxConnections        int       `toml:"max_connections"`
+	MaxConnectionsPerIP   int       `toml:"max_connections_per_ip"`
+	KeepAlive             duration  `toml:"keepalive"`
+	AuthExec              []string  `toml:"auth_exec"`
+	TrustedUserCAKeys     []string  `toml:"trusted_user_ca_keys"`
+	LogLevel              string    `toml:"log_level"`
+	StrictIdentities      bool      `toml:"strict_identities"`
+	SSH                   sshConfig `toml:"ssh"`
+
+	SessionWebhook *sessionWebhookConfig `toml:"session_webhook"`
+	Honeypot       *honeypotConfig       `toml:"honeypot"`
+}
+
+// sshConfig contains SSH protocol configuration.
+type sshConfig struct {
+	KeyExchanges []string `toml:"key_exchanges"`
+	Ciphers      []string `toml:"ciphers"`
+	MACs         []string `toml:"macs"`
+	Version      string   `toml:"version"`
 }
 
 // An identity is a processed identity configuration.
 type identity struct {
 	Name      string
 	PublicKey ssh.PublicKey
+	Namespace string
 }
 
 // file is the raw top-level configuration file representation.
 type file struct {
-	Server     server        `toml:"server"`
-	Devices    []rawDevice   `toml:"devices"`
-	Identities []rawIdentity `toml:"identities"`
-	Debug      debug         `toml:"debug"`
+	Server         server              `toml:"server"`
+	DeviceDefaults deviceDefaults      `toml:"device_defaults"`
+	RawDevices     []toml.Primitive    `toml:"devices"`
+	Remotes        []remoteConfig      `toml:"remotes"`
+	Listeners      []listenerConfig    `toml:"listeners"`
+	Identities     []rawIdentity       `toml:"identities"`
+	Groups         []groupConfig       `toml:"groups"`
+	Debug          debug               `toml:"debug"`
+	Stats          statsConfig         `toml:"stats"`
+	Log            logConfig           `toml:"log"`
+	MDNS           mdnsConfig          `toml:"mdns"`
+	Standby        *standbyConfig      `toml:"standby"`
+	OIDC           *oidcConfig         `toml:"oidc"`
+	Vault          *vaultConfig        `toml:"vault"`
+	Memory         memoryConfig        `toml:"memory"`
+	Provisioning   *provisioningConfig `toml:"provisioning"`
+	Enumeration    *enumerationConfig  `toml:"enumeration"`
+
+	// Devices are decoded from RawDevices on top of DeviceDefaults.
+	Devices []rawDevice `toml:"-"`
+}
+
+// deviceDefaults are the device settings inherited by each device unless the
+// device sets them itself.
+type deviceDefaults struct {
+	Baud        int          `toml:"baud"`
+	Identities  []string     `toml:"identities"`
+	LogToStdout bool         `toml:"logtostdout"`
+	LogColor    string       `toml:"log_color"`
+	Redact      []redactRule `toml:"redact"`
+}
+
+// device returns a rawDevice with the default settings applied.
+func (dd deviceDefaults) device() rawDevice {
+	return rawDevice{
+		Baud:        dd.Baud,
+		Identities:  slices.Clone(dd.Identities),
+		LogToStdout: dd.LogToStdout,
+		LogColor:    dd.LogColor,
+		Redact:      slices.Clone(dd.Redact),
+	}
 }
 
 // A rawDevice is a raw device configuration.
 type rawDevice struct {
-	Name        string   `toml:"name"`
-	Device      string   `toml:"device"`
-	Serial      string   `toml:"serial"`
-	Baud        int      `toml:"baud"`
-	Identities  []string `toml:"identities"`
-	LogToStdout bool     `toml:"logtostdout"`
+	Name        string       `toml:"name"`
+	Namespace   string       `toml:"namespace"`
+	Device      string       `toml:"device"`
+	Serial      string       `toml:"serial"`
+	USBPath     string       `toml:"usb_path"`
+	Serials     []string     `toml:"serials"`
+	USBPaths    []string     `toml:"usb_paths"`
+	Auto        bool         `toml:"auto"`
+	Address     string       `toml:"address"`
+	Baud        int          `toml:"baud"`
+	Identities  []string     `toml:"identities"`
+	LogToStdout bool         `toml:"logtostdout"`
+	LogColor    string       `toml:"log_color"`
+	Redact      []redactRule `toml:"redact"`
+
+	DeniedIdentities []string `toml:"denied_identities"`
+	WriteCoalesce    duration `toml:"write_coalesce"`
+	ReadSize         int      `toml:"read_size"`
+	ReadTimeout      duration `toml:"read_timeout"`
+	Share            bool     `toml:"share"`
+	Encoding         string   `toml:"encoding"`
+	RequireApproval  bool     `toml:"require_approval"`
+	RequireReason    bool     `toml:"require_reason"`
+	ReadOnly         bool     `toml:"read_only"`
+	Group            string   `toml:"group"`
+	PowerCycle       []string `toml:"power_cycle"`
+
+	Watchdog  *watchdogConfig  `toml:"watchdog"`
+	Boot      *bootConfig      `toml:"boot"`
+	Interrupt *interruptConfig `toml:"interrupt"`
+	Login     *loginConfig     `toml:"login"`
+	GDB       *gdbConfig       `toml:"gdb"`
+	PTY       *ptyConfig       `toml:"pty"`
+	ZModem    *zmodemConfig    `toml:"zmodem"`
+	FanOut    *fanOutConfig    `toml:"fanout"`
+	Wakeup    *wakeupConfig    `toml:"wakeup"`
+	Keymap    *keymapConfig    `toml:"keymap"`
+	Tee       *teeConfig       `toml:"tee"`
+	Hooks     *hooksConfig     `toml:"hooks"`
+	RateAlarm *rateAlarmConfig `toml:"rate_alarm"`
+}
+
+// A groupConfig is a named group of identities which may be referenced in
+// place of its members wherever identities are listed.
+type groupConfig struct {
+	Name       string   `toml:"name"`
+	Identities []string `toml:"identities"`
+}
+
+// groups maps group names to their member identities.
+type groups map[string][]string
+
+// expand replaces each group name in ids with the group's members, omitting
+// any identity which is listed more than once.
+func (g groups) expand(ids []string) []string {
+	if ids == nil {
+		return nil
+	}
+
+	out := make([]string, 0, len(ids))
+	for _, id := range ids {
+		members, ok := g[id]
+		if !ok {
+			members = []string{id}
+		}
+
+		for _, m := range members {
+			if !slices.Contains(out, m) {
+				out = append(out, m)
+			}
+		}
+	}
+
+	return out
 }
 
 // A rawIdentity is a raw identity configuration.
 type rawIdentity struct {
 	Name      string `toml:"name"`
 	PublicKey string `toml:"public_key"`
+	Namespace string `toml:"namespace"`
 }
 
 // debug contains consrv debug configuration.
 type debug struct {
-	Address    string `toml:"address"`
-	Prometheus bool   `toml:"prometheus"`
-	PProf      bool   `toml:"pprof"`
+	Address     string      `toml:"address"`
+	Prometheus  bool        `toml:"prometheus"`
+	PProf       bool        `toml:"pprof"`
+	Vars        bool        `toml:"vars"`
+	Push        *pushConfig `toml:"push"`
+	Capture     bool        `toml:"capture"`
+	CaptureSize int         `toml:"capture_size"`
+
+	// AdminTokenFile enables the endpoints which change consrv's state,
+	// authenticated with the token in this file.
+	AdminTokenFile string `toml:"admin_token_file"`
+}
+
+// statsConfig contains consrv persistent statistics configuration.
+type statsConfig struct {
+	Path string `toml:"path"`
+}
+
+// logConfig contains consrv device logging configuration.
+type logConfig struct {
+	Prefix    string           `toml:"prefix"`
+	Directory string           `toml:"directory"`
+	Mode      string           `toml:"mode"`
+	Retention *retentionConfig `toml:"retention"`
+	Disk      *diskConfig      `toml:"disk"`
+
+	ExcludeIdentities []string `toml:"exclude_identities"`
+
+	// MaskSerials masks adapter serial numbers in logs and metrics. If unset,
+	// serial numbers are masked when namespaces are configured.
+	MaskSerials *bool `toml:"mask_serials"`
+}
+
+// maskSerials reports whether adapter serial numbers should be masked in logs
+// and metrics.
+func (c *config) maskSerials() bool {
+	if c.Log.MaskSerials != nil {
+		return *c.Log.MaskSerials
+	}
+
+	// Mask by default in multi-tenant deployments.
+	return slices.ContainsFunc(c.Devices, func(d rawDevice) bool { return d.Namespace != "" })
+}
+
+// checkDropPrivileges returns an error for the first configured feature which
+// can't work once -experimental-drop-privileges has chrooted consrv to an empty
+// directory and installed the seccomp allowlist, because the feature creates
+// sockets or opens files after startup.
+func (c *config) checkDropPrivileges() error {
+	unsupported := func(feature string) error {
+		return fmt.Errorf("%s not supported with -experimental-drop-privileges", feature)
+	}
+
+	switch {
+	case c.Debug.Push != nil:
+		return unsupported("pushing metrics is")
+	case len(c.Remotes) > 0:
+		return unsupported("remotes are")
+	case c.Server.SessionWebhook != nil:
+		return unsupported("the session webhook is")
+	case c.Log.Directory != "":
+		// Log files are pruned and synced using system calls which aren't
+		// permitted, in a directory outside the chroot.
+		return unsupported("logging to a directory is")
+	case c.Log.Retention != nil:
+		return unsupported("log retention is")
+	case c.Log.Disk != nil:
+		return unsupported("the log disk watchdog is")
+	case c.Standby != nil:
+		return unsupported("hot-standby is")
+	case c.OIDC != nil:
+		return unsupported("OIDC certificate issuance is")
+	case c.Vault != nil:
+		return unsupported("Vault integration is")
+	}
+
+	for _, d := range c.Devices {
+		switch {
+		case d.Address != "":
+			return unsupported(fmt.Sprintf("device %q: network-attached devices are", d.Name))
+		case d.Share:
+			// Shared ports are reopened once another process releases them.
+			return unsupported(fmt.Sprintf("device %q: shared serial ports are", d.Name))
+		case d.Watchdog != nil && d.Watchdog.Webhook != "":
+			return unsupported(fmt.Sprintf("device %q: watchdog webhooks are", d.Name))
+		case d.Interrupt != nil && d.Interrupt.Webhook != "":
+			return unsupported(fmt.Sprintf("device %q: interrupt webhooks are", d.Name))
+		case d.ZModem != nil && d.ZModem.Spool != "":
+			return unsupported(fmt.Sprintf("device %q: ZMODEM spooling is", d.Name))
+		}
+	}
+
+	return nil
 }
 
 // defaultSSH is the SSH server address used if no server address is specified.
 const defaultSSH = ":2222"
 
+const (
+	// maxReadSize is the largest permitted device read buffer.
+	maxReadSize = 1 << 20
+
+	// maxReadTimeout is the longest serial read timeout, as the timeout is
+	// configured in tenths of a second using a single byte.
+	maxReadTimeout = 25500 * time.Millisecond
+)
+
 // parseConfig parses a TOML configuration file into a config.
 func parseConfig(r io.Reader) (*config, error) {
 	var f file
-	md, err := toml.NewDecoder(r).Decode(&f)
+	h := sha256.New()
+	md, err := toml.NewDecoder(io.TeeReader(r, h)).Decode(&f)
 	if err != nil {
 		return nil, err
 	}
+
+	// Only the keys which are set for a device override its defaults.
+	for _, p := range f.RawDevices {
+		d := f.DeviceDefaults.device()
+		if err := md.PrimitiveDecode(p, &d); err != nil {
+			return nil, err
+		}
+
+		f.Devices = append(f.Devices, d)
+	}
 	if u := md.Undecoded(); len(u) > 0 {
 		return nil, fmt.Errorf("unrecognized configuration keys: %s", u)
 	}
 
+	// Device templates stand in for multiple devices.
+	f.Devices, err = expandTemplates(f.Devices)
+	if err != nil {
+		return nil, err
+	}
+
 	// Must configure at least one device and identity.
 	if len(f.Devices) == 0 {
 		return nil, errors.New("no configured devices")
@@ -105,42 +384,206 @@ func parseConfig(r io.Reader) (*config, error) {
 		f.Server.Address = defaultSSH
 	}
 
+	if _, ok := levels[f.Server.LogLevel]; f.Server.LogLevel != "" && !ok {
+		return nil, fmt.Errorf("unknown log level %q", f.Server.LogLevel)
+	}
+
+	switch f.Server.AuthLogFormat {
+	case "", authLogConsrv, authLogOpenSSH:
+	default:
+		return nil, fmt.Errorf("unknown authentication log format %q", f.Server.AuthLogFormat)
+	}
+	if f.Server.AuthLogFormat != "" && f.Server.AuthLog == "" {
+		return nil, errors.New("authentication log format requires an authentication log")
+	}
+
+	if f.Server.MaxConnections < 0 || f.Server.MaxConnectionsPerIP < 0 {
+		return nil, errors.New("SSH connection limits must not be negative")
+	}
+	if f.Server.KeepAlive.Duration < 0 {
+		return nil, errors.New("SSH keepalive interval must not be negative")
+	}
+	if len(f.Server.AuthExec) > 0 && f.Server.AuthExec[0] == "" {
+		return nil, errors.New("authentication command must not be empty")
+	}
+
+	for _, algs := range [][]string{f.Server.SSH.KeyExchanges, f.Server.SSH.Ciphers, f.Server.SSH.MACs} {
+		if slices.Contains(algs, "") {
+			return nil, errors.New("SSH algorithms must not be empty")
+		}
+	}
+
+	// The version must be valid in an SSH identification string, per RFC 4253,
+	// section 4.2.
+	for _, r := range f.Server.SSH.Version {
+		if r <= ' ' || r > '~' || r == '-' {
+			return nil, fmt.Errorf("invalid SSH server version %q", f.Server.SSH.Version)
+		}
+	}
+
+	var cas []ssh.PublicKey
+	for _, s := range f.Server.TrustedUserCAKeys {
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(s))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse trusted user CA key %q: %v", s, err)
+		}
+
+		cas = append(cas, key)
+	}
+
+	if f.OIDC != nil {
+		if err := f.OIDC.validate(); err != nil {
+			return nil, err
+		}
+	}
+	if f.Vault != nil {
+		if err := f.Vault.validate(); err != nil {
+			return nil, err
+		}
+	}
+	if f.Provisioning != nil {
+		if err := f.Provisioning.validate(); err != nil {
+			return nil, err
+		}
+	}
+
 	// Track the identities found so they can be matched against devices which
 	// only allow access from a specific identity.
 	validIDs := make(map[string]struct{})
 	ids := make([]identity, 0, len(f.Identities))
 
-	// Identities must have each field set, and have a valid public key.
+	// Namespaces isolate their devices and identities from everything else, so
+	// once any namespace is configured, nothing may be left outside of one.
+	namespaced := slices.ContainsFunc(f.Devices, func(d rawDevice) bool { return d.Namespace != "" }) ||
+		slices.ContainsFunc(f.Identities, func(id rawIdentity) bool { return id.Namespace != "" })
+	namespaces := make(map[string]string, len(f.Identities))
+
+	// Identities must have each field set, and have a valid public key unless
+	// they authenticate with certificates from a trusted authority.
 	for _, id := range f.Identities {
 		if id.Name == "" {
 			return nil, errors.New("identity must have a name")
 		}
+		if _, ok := validIDs[id.Name]; ok {
+			return nil, fmt.Errorf("identity %q is configured more than once", id.Name)
+		}
 
-		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(id.PublicKey))
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse identity public key %q: %v", id.PublicKey, err)
+		var key ssh.PublicKey
+		switch {
+		case id.PublicKey != "":
+			k, _, _, _, err := ssh.ParseAuthorizedKey([]byte(id.PublicKey))
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse identity public key %q: %v", id.PublicKey, err)
+			}
+			key = k
+		case len(cas) == 0 && f.OIDC == nil && f.Vault == nil:
+			return nil, fmt.Errorf("identity %q must have a public key unless a certificate authority is configured", id.Name)
+		}
+
+		if namespaced && id.Namespace == "" {
+			return nil, fmt.Errorf("identity %q must have a namespace when namespaces are configured", id.Name)
 		}
 
 		validIDs[id.Name] = struct{}{}
+		namespaces[id.Name] = id.Namespace
 		ids = append(ids, identity{
 			Name:      id.Name,
 			PublicKey: key,
+			Namespace: id.Namespace,
 		})
 	}
 
+	// Groups share a namespace with identities and must only contain
+	// identities, so that an empty or unknown group can never allow access to
+	// all identities by omission.
+	gs := make(groups, len(f.Groups))
+	for _, g := range f.Groups {
+		if g.Name == "" {
+			return nil, errors.New("group must have a name")
+		}
+		if _, ok := validIDs[g.Name]; ok {
+			return nil, fmt.Errorf("group %q conflicts with an identity", g.Name)
+		}
+		if _, ok := gs[g.Name]; ok {
+			return nil, fmt.Errorf("group %q is configured more than once", g.Name)
+		}
+		if len(g.Identities) == 0 {
+			return nil, fmt.Errorf("group %q must have identities", g.Name)
+		}
+
+		for _, id := range g.Identities {
+			if _, ok := validIDs[id]; !ok {
+				return nil, fmt.Errorf("group %q is configured with unknown identity %q", g.Name, id)
+			}
+		}
+
+		gs[g.Name] = g.Identities
+	}
+
+	for i := range f.Devices {
+		d := &f.Devices[i]
+		d.Identities = gs.expand(d.Identities)
+		d.DeniedIdentities = gs.expand(d.DeniedIdentities)
+		if d.Login != nil {
+			d.Login.Identities = gs.expand(d.Login.Identities)
+		}
+	}
+	for i := range f.Remotes {
+		f.Remotes[i].Identities = gs.expand(f.Remotes[i].Identities)
+	}
+	f.Log.ExcludeIdentities = gs.expand(f.Log.ExcludeIdentities)
+
 	// Devices must have each field set.
+	seen := make(map[string]struct{}, len(f.Devices))
 	for _, d := range f.Devices {
 		if d.Name == "" {
 			return nil, errors.New("device must have a name")
 		}
+		if _, ok := seen[d.Name]; ok {
+			return nil, fmt.Errorf("device %q is configured more than once", d.Name)
+		}
+		seen[d.Name] = struct{}{}
 
-		if d.Baud == 0 {
-			return nil, fmt.Errorf("device %q must have a baud rate set", d.Name)
+		// Device templates in auto mode are given a device path at runtime.
+		name := d.Name
+		if d.Auto {
+			name = templateName(d.Name, 1)
 		}
 
-		// Must have at least one identifying field present.
-		if d.Device == "" && d.Serial == "" {
-			return nil, fmt.Errorf("device %q must have a device path or serial", d.Name)
+		// Network-attached devices are configured by their terminal server.
+		if d.Address != "" {
+			if d.Device != "" || d.Serial != "" || d.USBPath != "" {
+				return nil, fmt.Errorf("device %q must not have a device path, serial, or USB path with an address", d.Name)
+			}
+			if _, _, err := net.SplitHostPort(d.Address); err != nil {
+				return nil, fmt.Errorf("device %q must have a valid address: %v", d.Name, err)
+			}
+		} else {
+			if d.Baud == 0 {
+				return nil, fmt.Errorf("device %q must have a baud rate set", d.Name)
+			}
+
+			// Must have exactly one identifying field present.
+			var n int
+			for _, s := range []string{d.Device, d.Serial, d.USBPath} {
+				if s != "" {
+					n++
+				}
+			}
+			switch {
+			case n == 0 && d.Auto:
+			case n == 0:
+				return nil, fmt.Errorf("device %q must have a device path, serial, USB path, or address", d.Name)
+			case n == 1:
+			default:
+				return nil, fmt.Errorf("device %q must have only one of a device path, serial, or USB path", d.Name)
+			}
+		}
+
+		// In strict mode, a device must never be exposed to every identity by
+		// omission.
+		if f.Server.StrictIdentities && len(d.Identities) == 0 {
+			return nil, fmt.Errorf("device %q must have identities configured when strict_identities is set", d.Name)
 		}
 
 		// If the device has identities configured, those identities must exist.
@@ -149,6 +592,221 @@ func parseConfig(r io.Reader) (*config, error) {
 				return nil, fmt.Errorf("device %q is configured with unknown identity %q", d.Name, id)
 			}
 		}
+		for _, id := range d.DeniedIdentities {
+			if _, ok := validIDs[id]; !ok {
+				return nil, fmt.Errorf("device %q is configured with unknown denied identity %q", d.Name, id)
+			}
+		}
+
+		if namespaced {
+			if d.Namespace == "" {
+				return nil, fmt.Errorf("device %q must have a namespace when namespaces are configured", d.Name)
+			}
+			for _, id := range slices.Concat(d.Identities, d.DeniedIdentities) {
+				if ns := namespaces[id]; ns != d.Namespace {
+					return nil, fmt.Errorf("device %q in namespace %q is configured with identity %q from namespace %q", d.Name, d.Namespace, id, ns)
+				}
+			}
+		}
+
+		// A second identity must be able to approve each session.
+		if d.RequireApproval {
+			ids := d.Identities
+			if len(ids) == 0 {
+				ids = slices.Collect(maps.Keys(validIDs))
+			}
+			ids = slices.DeleteFunc(slices.Clone(ids), func(id string) bool {
+				return slices.Contains(d.DeniedIdentities, id) || namespaces[id] != d.Namespace
+			})
+			if len(ids) < 2 {
+				return nil, fmt.Errorf("device %q requires approval, but fewer than two identities may access it", d.Name)
+			}
+		}
+
+		// Device names are used as log file names.
+		if f.Log.Directory != "" && (filepath.Base(name) != name || name == "." || name == "..") {
+			return nil, fmt.Errorf("device %q cannot be used as a log file name", d.Name)
+		}
+
+		if d.WriteCoalesce.Duration < 0 {
+			return nil, fmt.Errorf("device %q must not have a negative write coalescing interval", d.Name)
+		}
+		if d.ReadSize < 0 || d.ReadSize > maxReadSize {
+			return nil, fmt.Errorf("device %q read size must be between 0 and %d bytes", d.Name, maxReadSize)
+		}
+		if d.ReadTimeout.Duration < 0 || d.ReadTimeout.Duration > maxReadTimeout {
+			return nil, fmt.Errorf("device %q read timeout must be between 0 and %s", d.Name, maxReadTimeout)
+		}
+		if d.ReadTimeout.Duration > 0 && d.Address != "" {
+			return nil, fmt.Errorf("device %q read timeout is only supported for serial devices", d.Name)
+		}
+		if _, ok := charsets[d.Encoding]; d.Encoding != "" && !ok {
+			return nil, fmt.Errorf("device %q has unknown encoding %q", d.Name, d.Encoding)
+		}
+		if d.Share && d.Address != "" {
+			return nil, fmt.Errorf("device %q port sharing is only supported for serial devices", d.Name)
+		}
+
+		if _, ok := logColors[d.LogColor]; d.LogColor != "" && !ok {
+			return nil, fmt.Errorf("device %q has unknown log color %q", d.Name, d.LogColor)
+		}
+
+		if len(d.PowerCycle) > 0 && d.PowerCycle[0] == "" {
+			return nil, fmt.Errorf("device %q must not have an empty power_cycle command", d.Name)
+		}
+
+		if err := validateRedact(d.Name, d.Redact); err != nil {
+			return nil, err
+		}
+		if d.Watchdog != nil {
+			if err := d.Watchdog.validate(d.Name); err != nil {
+				return nil, err
+			}
+		}
+		if d.Boot != nil {
+			if err := d.Boot.validate(d.Name); err != nil {
+				return nil, err
+			}
+		}
+		if d.Interrupt != nil {
+			if err := d.Interrupt.validate(d.Name); err != nil {
+				return nil, err
+			}
+		}
+		if d.Login != nil {
+			if err := d.Login.validate(d.Name, validIDs); err != nil {
+				return nil, err
+			}
+		}
+		if d.GDB != nil {
+			if err := d.GDB.validate(d.Name); err != nil {
+				return nil, err
+			}
+		}
+		if d.PTY != nil {
+			if err := d.PTY.validate(d.Name); err != nil {
+				return nil, err
+			}
+		}
+		if d.ZModem != nil {
+			if err := d.ZModem.validate(d.Name); err != nil {
+				return nil, err
+			}
+		}
+		if d.Wakeup != nil {
+			if err := d.Wakeup.validate(d.Name); err != nil {
+				return nil, err
+			}
+		}
+		if d.Keymap != nil {
+			if err := d.Keymap.validate(d.Name); err != nil {
+				return nil, err
+			}
+		}
+		if d.Tee != nil {
+			if err := d.Tee.validate(d.Name); err != nil {
+				return nil, err
+			}
+		}
+		if d.Hooks != nil {
+			if err := d.Hooks.validate(d.Name); err != nil {
+				return nil, err
+			}
+		}
+		if d.RateAlarm != nil {
+			if err := d.RateAlarm.validate(d.Name); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Remote device names share the namespace of local devices, so explicitly
+	// configured names must not conflict. Discovered names are checked at
+	// runtime.
+	names := make(map[string]struct{}, len(f.Devices))
+	for _, d := range f.Devices {
+		names[d.Name] = struct{}{}
+	}
+
+	fanOuts := make(map[string]*fanOutConfig, len(f.Devices))
+	for _, d := range f.Devices {
+		fanOuts[d.Name] = d.FanOut
+	}
+	for _, d := range f.Devices {
+		if d.FanOut == nil {
+			continue
+		}
+		if err := d.FanOut.validate(d.Name, fanOuts); err != nil {
+			return nil, err
+		}
+	}
+
+	// Remote devices are discovered at runtime, so they can't be placed in a
+	// namespace.
+	if namespaced && len(f.Remotes) > 0 {
+		return nil, errors.New("remotes are not supported when namespaces are configured")
+	}
+
+	remotes := make(map[string]struct{}, len(f.Remotes))
+	for _, rc := range f.Remotes {
+		if err := rc.validate(validIDs); err != nil {
+			return nil, err
+		}
+		if f.Server.StrictIdentities && len(rc.Identities) == 0 {
+			return nil, fmt.Errorf("remote %q must have identities configured when strict_identities is set", rc.Name)
+		}
+
+		if _, ok := remotes[rc.Name]; ok {
+			return nil, fmt.Errorf("remote %q is configured more than once", rc.Name)
+		}
+		remotes[rc.Name] = struct{}{}
+
+		for _, n := range rc.Devices {
+			if _, ok := names[n]; ok {
+				return nil, fmt.Errorf("remote %q device %q conflicts with another device", rc.Name, n)
+			}
+			names[n] = struct{}{}
+		}
+	}
+
+	nss := make(map[string]struct{})
+	for _, d := range f.Devices {
+		if d.Namespace != "" {
+			nss[d.Namespace] = struct{}{}
+		}
+	}
+	for _, lc := range f.Listeners {
+		if err := lc.validate(names, nss); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := parsePrefix(f.Log.Prefix); err != nil {
+		return nil, fmt.Errorf("failed to parse log prefix: %v", err)
+	}
+	if _, ok := logModes[f.Log.Mode]; !ok {
+		return nil, fmt.Errorf("unknown log mode %q", f.Log.Mode)
+	}
+	if f.Log.Retention != nil {
+		if f.Log.Directory == "" {
+			return nil, errors.New("log retention requires a log directory")
+		}
+		if err := f.Log.Retention.validate(); err != nil {
+			return nil, err
+		}
+	}
+	if f.Log.Disk != nil {
+		if f.Log.Directory == "" {
+			return nil, errors.New("log disk watchdog requires a log directory")
+		}
+		if err := f.Log.Disk.validate(); err != nil {
+			return nil, err
+		}
+	}
+	for _, id := range f.Log.ExcludeIdentities {
+		if _, ok := validIDs[id]; !ok {
+			return nil, fmt.Errorf("log is configured with unknown excluded identity %q", id)
+		}
 	}
 
 	// Validate debug configuration if set.
@@ -157,11 +815,131 @@ func parseConfig(r io.Reader) (*config, error) {
 			return nil, fmt.Errorf("failed to parse debug HTTP server address: %v", err)
 		}
 	}
+	if f.Debug.AdminTokenFile != "" && f.Debug.Address == "" {
+		return nil, errors.New("debug admin token file requires a debug HTTP server address")
+	}
+
+	if err := f.MDNS.validate(); err != nil {
+		return nil, err
+	}
+	if f.Standby != nil {
+		if err := f.Standby.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	if f.Enumeration != nil {
+		if err := f.Enumeration.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	if wc := f.Server.SessionWebhook; wc != nil {
+		if err := wc.validate(f.Debug.Capture); err != nil {
+			return nil, err
+		}
+	}
+
+	if f.Debug.Push != nil {
+		if err := f.Debug.Push.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := f.Memory.validate(); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case f.Debug.CaptureSize < 0:
+		return nil, errors.New("debug capture size must not be negative")
+	case f.Debug.CaptureSize == 0:
+		f.Debug.CaptureSize = defaultCaptureSize
+	}
 
 	return &config{
 		Server:     f.Server,
 		Devices:    f.Devices,
+		Remotes:    f.Remotes,
+		Listeners:  f.Listeners,
 		Identities: ids,
 		Debug:      f.Debug,
+		Stats:      f.Stats,
+		Log:        f.Log,
+		MDNS:       f.MDNS,
+		Standby:    f.Standby,
+		OIDC:       f.OIDC,
+		Vault:      f.Vault,
+		Memory:     f.Memory,
+
+		Provisioning: f.Provisioning,
+		Enumeration:  f.Enumeration,
+		UserCAKeys:   cas,
+		Hash:         hex.EncodeToString(h.Sum(nil)),
 	}, nil
 }
+
+// newIdentities creates consrv.Identities from configuration and the
+// identities permitted to access each remote device.
+func newIdentities(cfg *config, remote map[string][]string, ll *log.Logger) (*consrv.Identities, error) {
+	ids := make([]consrv.Identity, 0, len(cfg.Identities))
+	for _, id := range cfg.Identities {
+		ids = append(ids, consrv.Identity{Name: id.Name, PublicKey: id.PublicKey})
+	}
+
+	devices := make(map[string][]string, len(cfg.Devices))
+	for _, d := range cfg.Devices {
+		devices[d.Name] = d.Identities
+	}
+	for name, ids := range remote {
+		devices[name] = ids
+	}
+
+	out, err := consrv.NewIdentities(ids, devices, ll)
+	if err != nil {
+		return nil, err
+	}
+
+	// Denials take precedence over the identities allowed above.
+	for _, d := range cfg.Devices {
+		if len(d.DeniedIdentities) == 0 {
+			continue
+		}
+
+		if err := out.Deny(d.Name, d.DeniedIdentities); err != nil {
+			return nil, err
+		}
+		ll.Printf("identities %q denied for device %q", d.DeniedIdentities, d.Name)
+	}
+
+	// Namespaces isolate devices and identities regardless of the above.
+	type members struct{ devices, identities []string }
+	namespaces := make(map[string]*members)
+	member := func(ns string) *members {
+		if namespaces[ns] == nil {
+			namespaces[ns] = &members{}
+		}
+		return namespaces[ns]
+	}
+	for _, d := range cfg.Devices {
+		if d.Namespace != "" {
+			m := member(d.Namespace)
+			m.devices = append(m.devices, d.Name)
+		}
+	}
+	for _, id := range cfg.Identities {
+		if id.Namespace != "" {
+			m := member(id.Namespace)
+			m.identities = append(m.identities, id.Name)
+		}
+	}
+	for _, ns := range slices.Sorted(maps.Keys(namespaces)) {
+		m := namespaces[ns]
+		if err := out.Namespace(ns, m.devices, m.identities); err != nil {
+			return nil, err
+		}
+		ll.Printf("namespace %q configured with devices %q and identities %q", ns, m.devices, m.identities)
+	}
+
+	return out, nil
+}
@@ -14,12 +14,18 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
+	"log"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/gliderlabs/ssh"
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 )
 
 func Test_parseConfig(t *testing.T) {
@@ -157,6 +163,1285 @@ func Test_parseConfig(t *testing.T) {
 			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
 			`,
 		},
+		{
+			name: "bad device require approval",
+			s: `
+			[[devices]]
+			name = "server"
+			device = "/dev/ttyUSB0"
+			baud = 115200
+			require_approval = true
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+			`,
+		},
+		{
+			name: "bad device log color",
+			s: `
+			[[devices]]
+			name = "server"
+			device = "/dev/ttyUSB0"
+			baud = 115200
+			log_color = "chartreuse"
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+			`,
+		},
+		{
+			name: "bad device name for log file",
+			s: `
+			[[devices]]
+			name = "../server"
+			device = "/dev/ttyUSB0"
+			baud = 115200
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+
+			[log]
+			directory = "/perm/consrv/logs"
+			`,
+		},
+		{
+			name: "bad log retention no directory",
+			s: `
+			[[devices]]
+			name = "server"
+			device = "/dev/ttyUSB0"
+			baud = 115200
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+
+			[log.retention]
+			max_age = "720h"
+			`,
+		},
+		{
+			name: "bad log disk watchdog",
+			s: `
+			[[devices]]
+			name = "server"
+			device = "/dev/ttyUSB0"
+			baud = 115200
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+
+			[log]
+			directory = "/perm/consrv/logs"
+
+			[log.disk]
+			low_free = 1024
+			min_free = 4096
+			`,
+		},
+		{
+			name: "bad log excluded identity",
+			s: `
+			[[devices]]
+			name = "server"
+			device = "/dev/ttyUSB0"
+			baud = 115200
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+
+			[log]
+			directory = "/perm/consrv/logs"
+			exclude_identities = ["rsa"]
+			`,
+		},
+		{
+			name: "bad log mode",
+			s: `
+			[[devices]]
+			name = "server"
+			device = "/dev/ttyUSB0"
+			baud = 115200
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+
+			[log]
+			mode = "binary"
+			`,
+		},
+		{
+			name: "bad log prefix",
+			s: `
+			[[devices]]
+			name = "server"
+			device = "/dev/ttyUSB0"
+			baud = 115200
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+
+			[log]
+			prefix = "{{.Name"
+			`,
+		},
+		{
+			name: "bad watchdog",
+			s: `
+			[[devices]]
+			name = "server"
+			device = "/dev/ttyUSB0"
+			baud = 115200
+
+			[devices.watchdog]
+			idle = "10m"
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+			`,
+		},
+		{
+			name: "bad watchdog pattern",
+			s: `
+			[[devices]]
+			name = "server"
+			device = "/dev/ttyUSB0"
+			baud = 115200
+
+			[devices.watchdog]
+			idle = "10m"
+			after = ["("]
+			webhook = "https://example.com/hook"
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+			`,
+		},
+		{
+			name: "bad boot markers",
+			s: `
+			[[devices]]
+			name = "server"
+			device = "/dev/ttyUSB0"
+			baud = 115200
+
+			[devices.boot]
+			markers = [
+				{ name = "uboot", pattern = "U-Boot" },
+				{ name = "uboot", pattern = "login:" },
+			]
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+			`,
+		},
+		{
+			name: "bad interrupt resume",
+			s: `
+			[[devices]]
+			name = "server"
+			device = "/dev/ttyUSB0"
+			baud = 115200
+
+			[devices.interrupt]
+			pattern = "Hit any key to stop autoboot"
+			keys = " "
+			resume = "boot\r"
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+			`,
+		},
+		{
+			name: "bad login identity",
+			s: `
+			[[devices]]
+			name = "server"
+			device = "/dev/ttyUSB0"
+			baud = 115200
+
+			[devices.login]
+			user = "root"
+			password_file = "/perm/consrv/server.pass"
+			identities = ["foo"]
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+			`,
+		},
+		{
+			name: "remote device conflict",
+			s: `
+			[[devices]]
+			name = "server"
+			device = "/dev/ttyUSB0"
+			baud = 115200
+
+			[[remotes]]
+			name = "lab"
+			address = "lab.example.com:2222"
+			key_file = "/perm/consrv/frontend_key"
+			known_hosts = "/perm/consrv/known_hosts"
+			devices = ["server"]
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+			`,
+		},
+		{
+			name: "bad mDNS instance",
+			s: `
+			[[devices]]
+			name = "server"
+			device = "/dev/ttyUSB0"
+			baud = 115200
+
+			[mdns]
+			enabled = true
+			instance = "consrv.example"
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+			`,
+		},
+		{
+			name: "address and device",
+			s: `
+			[[devices]]
+			name = "server"
+			device = "/dev/ttyUSB0"
+			address = "ts1.example.com:7001"
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+			`,
+		},
+		{
+			name: "bad standby timeout",
+			s: `
+			[[devices]]
+			name = "server"
+			address = "ts1.example.com:7001"
+
+			[standby]
+			address = ":2223"
+			peer = "consrv-b:2223"
+			interval = "5s"
+			timeout = "1s"
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+			`,
+		},
+		{
+			name: "bad log level",
+			s: `
+			[server]
+			log_level = "verbose"
+
+			[[devices]]
+			name = "server"
+			device = "/dev/ttyUSB0"
+			baud = 115200
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+			`,
+		},
+		{
+			name: "bad tee command",
+			s: `
+			[[devices]]
+			name = "server"
+			device = "/dev/ttyUSB0"
+			baud = 115200
+
+			[devices.tee]
+			command = []
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+			`,
+		},
+		{
+			name: "bad tee compression",
+			s: `
+			[[devices]]
+			name = "server"
+			device = "/dev/ttyUSB0"
+			baud = 115200
+
+			[devices.tee]
+			command = ["cat"]
+			compression = "lz4"
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+			`,
+		},
+		{
+			name: "bad multiple device identifiers",
+			s: `
+			[[devices]]
+			name = "server"
+			serial = "A50285BI"
+			usb_path = "1-1.2"
+			baud = 115200
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+			`,
+		},
+		{
+			name: "bad duplicate device name",
+			s: `
+			[[devices]]
+			name = "node%d"
+			serials = ["A50285BI", "A64NMAJS"]
+			baud = 115200
+
+			[[devices]]
+			name = "node2"
+			device = "/dev/ttyUSB0"
+			baud = 115200
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+			`,
+		},
+		{
+			name: "bad device key",
+			s: `
+			[device_defaults]
+			baud = 115200
+
+			[[devices]]
+			name = "server"
+			device = "/dev/ttyUSB0"
+			parity = "even"
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+			`,
+		},
+		{
+			name: "bad strict identities",
+			s: `
+			[server]
+			strict_identities = true
+
+			[[devices]]
+			name = "server"
+			device = "/dev/ttyUSB0"
+			baud = 115200
+			identities = ["ed25519"]
+
+			[[devices]]
+			name = "desktop"
+			device = "/dev/ttyUSB1"
+			baud = 115200
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+			`,
+		},
+		{
+			name: "bad namespace device",
+			s: `
+			[[devices]]
+			name = "server"
+			device = "/dev/ttyUSB0"
+			baud = 115200
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+			namespace = "red"
+			`,
+		},
+		{
+			name: "bad namespace identity",
+			s: `
+			[[devices]]
+			name = "server"
+			namespace = "red"
+			device = "/dev/ttyUSB0"
+			baud = 115200
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+			`,
+		},
+		{
+			name: "bad namespace device identity",
+			s: `
+			[[devices]]
+			name = "server"
+			namespace = "red"
+			device = "/dev/ttyUSB0"
+			baud = 115200
+			identities = ["ed25519"]
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+			namespace = "blue"
+			`,
+		},
+		{
+			name: "bad namespace remotes",
+			s: `
+			[[devices]]
+			name = "server"
+			namespace = "red"
+			device = "/dev/ttyUSB0"
+			baud = 115200
+
+			[[remotes]]
+			name = "lab"
+			address = "lab:2222"
+			key_file = "/perm/consrv/remote_ed25519"
+			known_hosts = "/perm/consrv/known_hosts"
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+			namespace = "red"
+			`,
+		},
+		{
+			name: "bad listener address",
+			s: `
+			[[devices]]
+			name = "server"
+			device = "/dev/ttyUSB0"
+			baud = 115200
+
+			[[listeners]]
+			address = "foo"
+			devices = ["server"]
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+			`,
+		},
+		{
+			name: "bad listener no devices",
+			s: `
+			[[devices]]
+			name = "server"
+			device = "/dev/ttyUSB0"
+			baud = 115200
+
+			[[listeners]]
+			address = ":2223"
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+			`,
+		},
+		{
+			name: "bad listener unknown device",
+			s: `
+			[[devices]]
+			name = "server"
+			device = "/dev/ttyUSB0"
+			baud = 115200
+
+			[[listeners]]
+			address = ":2223"
+			devices = ["desktop"]
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+			`,
+		},
+		{
+			name: "bad listener unknown namespace",
+			s: `
+			[[devices]]
+			name = "server"
+			device = "/dev/ttyUSB0"
+			baud = 115200
+
+			[[listeners]]
+			address = ":2223"
+			namespaces = ["red"]
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+			`,
+		},
+		{
+			name: "bad group identity",
+			s: `
+			[[devices]]
+			name = "server"
+			device = "/dev/ttyUSB0"
+			baud = 115200
+			identities = ["sre"]
+
+			[[groups]]
+			name = "sre"
+			identities = ["ed25519", "unknown"]
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+			`,
+		},
+		{
+			name: "bad empty group",
+			s: `
+			[[devices]]
+			name = "server"
+			device = "/dev/ttyUSB0"
+			baud = 115200
+			identities = ["sre"]
+
+			[[groups]]
+			name = "sre"
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+			`,
+		},
+		{
+			name: "bad denied identity",
+			s: `
+			[[devices]]
+			name = "server"
+			device = "/dev/ttyUSB0"
+			baud = 115200
+			denied_identities = ["unknown"]
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+			`,
+		},
+		{
+			name: "bad auth log format",
+			s: `
+			[server]
+			auth_log = "/perm/consrv/auth.log"
+			auth_log_format = "syslog"
+
+			[[devices]]
+			name = "server"
+			device = "/dev/ttyUSB0"
+			baud = 115200
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+			`,
+		},
+		{
+			name: "bad debug push format",
+			s: `
+			[debug.push]
+			format = "graphite"
+			address = "localhost:2003"
+
+			[[devices]]
+			name = "server"
+			device = "/dev/ttyUSB0"
+			baud = 115200
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+			`,
+		},
+		{
+			name: "bad debug push address",
+			s: `
+			[debug.push]
+			format = "statsd"
+			address = "localhost"
+
+			[[devices]]
+			name = "server"
+			device = "/dev/ttyUSB0"
+			baud = 115200
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+			`,
+		},
+		{
+			name: "bad debug push interval",
+			s: `
+			[debug.push]
+			format = "influxdb"
+			address = "localhost:8089"
+			interval = "-1s"
+
+			[[devices]]
+			name = "server"
+			device = "/dev/ttyUSB0"
+			baud = 115200
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+			`,
+		},
+		{
+			name: "bad power cycle command",
+			s: `
+			[[devices]]
+			name = "server"
+			device = "/dev/ttyUSB0"
+			baud = 115200
+			group = "rack1"
+			power_cycle = [""]
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+			`,
+		},
+		{
+			name: "bad enumeration interval",
+			s: `
+			[enumeration]
+			interval = "-1s"
+
+			[[devices]]
+			name = "server"
+			device = "/dev/ttyUSB0"
+			baud = 115200
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+			`,
+		},
+		{
+			name: "bad session webhook URL",
+			s: `
+			[server.session_webhook]
+			url = "example.com"
+
+			[[devices]]
+			name = "server"
+			device = "/dev/ttyUSB0"
+			baud = 115200
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+			`,
+		},
+		{
+			name: "bad session webhook excerpt size",
+			s: `
+			[server.session_webhook]
+			url = "https://example.com"
+			excerpt_size = -1
+
+			[[devices]]
+			name = "server"
+			device = "/dev/ttyUSB0"
+			baud = 115200
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+			`,
+		},
+		{
+			name: "session webhook excerpt without capture",
+			s: `
+			[server.session_webhook]
+			url = "https://example.com"
+			excerpt_size = 4096
+
+			[[devices]]
+			name = "server"
+			device = "/dev/ttyUSB0"
+			baud = 115200
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+			`,
+		},
+		{
+			name: "bad hooks",
+			s: `
+			[[devices]]
+			name = "server"
+			device = "/dev/ttyUSB0"
+			baud = 115200
+
+			[devices.hooks]
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+			`,
+		},
+		{
+			name: "bad hooks empty command",
+			s: `
+			[[devices]]
+			name = "server"
+			device = "/dev/ttyUSB0"
+			baud = 115200
+
+			[devices.hooks]
+			close = [""]
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+			`,
+		},
+		{
+			name: "bad write coalescing interval",
+			s: `
+			[[devices]]
+			name = "server"
+			device = "/dev/ttyUSB0"
+			baud = 115200
+			write_coalesce = "-1ms"
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+			`,
+		},
+		{
+			name: "bad read size",
+			s: `
+			[[devices]]
+			name = "server"
+			device = "/dev/ttyUSB0"
+			baud = 115200
+			read_size = -1
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+			`,
+		},
+		{
+			name: "bad read timeout",
+			s: `
+			[[devices]]
+			name = "server"
+			device = "/dev/ttyUSB0"
+			baud = 115200
+			read_timeout = "30s"
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+			`,
+		},
+		{
+			name: "read timeout TCP device",
+			s: `
+			[[devices]]
+			name = "server"
+			address = "192.0.2.1:7001"
+			read_timeout = "100ms"
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+			`,
+		},
+		{
+			name: "bad rate alarm",
+			s: `
+			[[devices]]
+			name = "server"
+			device = "/dev/ttyUSB0"
+			baud = 115200
+
+			[devices.rate_alarm]
+			lines_per_minute = 0
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+			`,
+		},
+		{
+			name: "bad keymap",
+			s: `
+			[[devices]]
+			name = "server"
+			device = "/dev/ttyUSB0"
+			baud = 115200
+
+			[devices.keymap]
+			presets = ["vt52"]
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+			`,
+		},
+		{
+			name: "bad encoding",
+			s: `
+			[[devices]]
+			name = "server"
+			device = "/dev/ttyUSB0"
+			baud = 115200
+			encoding = "ebcdic"
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+			`,
+		},
+		{
+			name: "share TCP device",
+			s: `
+			[[devices]]
+			name = "server"
+			address = "192.0.2.1:7001"
+			share = true
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+			`,
+		},
+		{
+			name: "bad GDB address",
+			s: `
+			[[devices]]
+			name = "server"
+			device = "/dev/ttyUSB0"
+			baud = 115200
+
+			[devices.gdb]
+			address = "localhost"
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+			`,
+		},
+		{
+			name: "bad pty path",
+			s: `
+			[[devices]]
+			name = "server"
+			device = "/dev/ttyUSB0"
+			baud = 115200
+
+			[devices.pty]
+			path = "run/consrv/server"
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+			`,
+		},
+		{
+			name: "bad ZMODEM spool",
+			s: `
+			[[devices]]
+			name = "server"
+			device = "/dev/ttyUSB0"
+			baud = 115200
+
+			[devices.zmodem]
+			spool = "zmodem"
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+			`,
+		},
+		{
+			name: "bad fan-out unknown device",
+			s: `
+			[[devices]]
+			name = "server"
+			device = "/dev/ttyUSB0"
+			baud = 115200
+
+			[devices.fanout]
+			devices = ["logger"]
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+			`,
+		},
+		{
+			name: "bad fan-out itself",
+			s: `
+			[[devices]]
+			name = "server"
+			device = "/dev/ttyUSB0"
+			baud = 115200
+
+			[devices.fanout]
+			devices = ["server"]
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+			`,
+		},
+		{
+			name: "bad fan-out cycle",
+			s: `
+			[[devices]]
+			name = "server"
+			device = "/dev/ttyUSB0"
+			baud = 115200
+
+			[devices.fanout]
+			devices = ["logger"]
+
+			[[devices]]
+			name = "logger"
+			device = "/dev/ttyUSB1"
+			baud = 115200
+
+			[devices.fanout]
+			devices = ["server"]
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+			`,
+		},
+		{
+			name: "bad wakeup interval",
+			s: `
+			[[devices]]
+			name = "server"
+			device = "/dev/ttyUSB0"
+			baud = 115200
+
+			[devices.wakeup]
+			bytes = "\r"
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+			`,
+		},
+		{
+			name: "bad wakeup bytes",
+			s: `
+			[[devices]]
+			name = "server"
+			device = "/dev/ttyUSB0"
+			baud = 115200
+
+			[devices.wakeup]
+			interval = "5m"
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+			`,
+		},
+		{
+			name: "bad memory limit",
+			s: `
+			[[devices]]
+			name = "server"
+			device = "/dev/ttyUSB0"
+			baud = 115200
+
+			[memory]
+			limit = -1
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+			`,
+		},
+		{
+			name: "bad redaction",
+			s: `
+			[[devices]]
+			name = "server"
+			device = "/dev/ttyUSB0"
+			baud = 115200
+			redact = [{ pattern = "(" }]
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+			`,
+		},
+		{
+			name: "bad auth exec",
+			s: `
+			[server]
+			auth_exec = [""]
+
+			[[devices]]
+			name = "server"
+			device = "/dev/ttyUSB0"
+			baud = 115200
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+			`,
+		},
+		{
+			name: "bad trusted user CA key",
+			s: `
+			[server]
+			trusted_user_ca_keys = ["foo"]
+
+			[[devices]]
+			name = "server"
+			device = "/dev/ttyUSB0"
+			baud = 115200
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+			`,
+		},
+		{
+			name: "identity without public key or CA",
+			s: `
+			[[devices]]
+			name = "server"
+			device = "/dev/ttyUSB0"
+			baud = 115200
+
+			[[identities]]
+			name = "ed25519"
+			`,
+		},
+		{
+			name: "bad OIDC issuer",
+			s: `
+			[[devices]]
+			name = "server"
+			device = "/dev/ttyUSB0"
+			baud = 115200
+
+			[[identities]]
+			name = "ed25519"
+
+			[oidc]
+			address = ":8443"
+			tls_cert = "/perm/consrv/tls.crt"
+			tls_key = "/perm/consrv/tls.key"
+			issuer = "http://accounts.example.com"
+			client_id = "consrv"
+			ca_key = "/perm/consrv/ca_key"
+			`,
+		},
+		{
+			name: "bad OIDC validity",
+			s: `
+			[[devices]]
+			name = "server"
+			device = "/dev/ttyUSB0"
+			baud = 115200
+
+			[[identities]]
+			name = "ed25519"
+
+			[oidc]
+			address = ":8443"
+			tls_cert = "/perm/consrv/tls.crt"
+			tls_key = "/perm/consrv/tls.key"
+			issuer = "https://accounts.example.com"
+			client_id = "consrv"
+			ca_key = "/perm/consrv/ca_key"
+			validity = "48h"
+			`,
+		},
+		{
+			name: "bad vault address",
+			s: `
+			[[devices]]
+			name = "server"
+			device = "/dev/ttyUSB0"
+			baud = 115200
+
+			[[identities]]
+			name = "ed25519"
+
+			[vault]
+			address = "vault.example.com"
+			`,
+		},
+		{
+			name: "vault host role without token",
+			s: `
+			[[devices]]
+			name = "server"
+			device = "/dev/ttyUSB0"
+			baud = 115200
+
+			[[identities]]
+			name = "ed25519"
+
+			[vault]
+			address = "https://vault.example.com:8200"
+			host_role = "consrv"
+			`,
+		},
+		{
+			name: "duplicate identity",
+			s: `
+			[[devices]]
+			name = "server"
+			device = "/dev/ttyUSB0"
+			baud = 115200
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+			`,
+		},
+		{
+			name: "bad provisioning address",
+			s: `
+			[[devices]]
+			name = "server"
+			device = "/dev/ttyUSB0"
+			baud = 115200
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+
+			[provisioning]
+			address = "localhost"
+			token_file = "/etc/consrv/token"
+			fragment = "/etc/consrv/managed.toml"
+			`,
+		},
+		{
+			name: "provisioning without token",
+			s: `
+			[[devices]]
+			name = "server"
+			device = "/dev/ttyUSB0"
+			baud = 115200
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+
+			[provisioning]
+			address = "localhost:9289"
+			fragment = "/etc/consrv/managed.toml"
+			`,
+		},
+		{
+			name: "bad keepalive",
+			s: `
+			[server]
+			keepalive = "-1s"
+
+			[[devices]]
+			name = "server"
+			device = "/dev/ttyUSB0"
+			baud = 115200
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+			`,
+		},
+		{
+			name: "bad connection limit",
+			s: `
+			[server]
+			max_connections_per_ip = -1
+
+			[[devices]]
+			name = "server"
+			device = "/dev/ttyUSB0"
+			baud = 115200
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+			`,
+		},
+		{
+			name: "bad SSH cipher",
+			s: `
+			[server.ssh]
+			ciphers = [""]
+
+			[[devices]]
+			name = "server"
+			device = "/dev/ttyUSB0"
+			baud = 115200
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+			`,
+		},
+		{
+			name: "bad SSH version",
+			s: `
+			[server.ssh]
+			version = "consrv 1.3"
+
+			[[devices]]
+			name = "server"
+			device = "/dev/ttyUSB0"
+			baud = 115200
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+			`,
+		},
 		{
 			name: "bad debug address",
 			s: `
@@ -173,19 +1458,113 @@ func Test_parseConfig(t *testing.T) {
 			address = "foo"
 			`,
 		},
+		{
+			name: "debug admin token without address",
+			s: `
+			[[devices]]
+			name = "server"
+			device = "/dev/ttyUSB0"
+			baud = 115200
+
+			[[identities]]
+			name = "ed25519"
+			public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+
+			[debug]
+			admin_token_file = "/perm/consrv/admin.token"
+			`,
+		},
 		{
 			name: "OK",
 			s: `
+			[server]
+			host_key_passphrase_file = "/perm/consrv/host_key.pass"
+			auth_log = "/perm/consrv/auth.log"
+			max_connections = 32
+			max_connections_per_ip = 4
+			keepalive = "30s"
+			auth_exec = ["/perm/consrv/oncall.sh", "-v"]
+			trusted_user_ca_keys = ["ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"]
+
+			[server.ssh]
+			key_exchanges = ["curve25519-sha256"]
+			ciphers = ["aes256-gcm@openssh.com", "chacha20-poly1305@openssh.com"]
+			macs = ["hmac-sha2-256-etm@openssh.com"]
+			version = "consrv_1.3"
+
+			[server.honeypot]
+			log = "/perm/consrv/honeypot.log"
+
 			[[devices]]
 			name = "server"
 			device = "/dev/ttyUSB0"
 			baud = 115200
 			identities = ["ed25519"]
+			denied_identities = ["rsa"]
+			share = true
+
+			[devices.watchdog]
+			idle = "10m"
+			after = ["reboot: Restarting system"]
+			webhook = "https://example.com/hook"
+			command = ["/usr/local/bin/power-cycle", "server"]
+
+			[devices.interrupt]
+			pattern = "Hit any key to stop autoboot"
+			keys = " "
+			wait = "5m"
+			resume = "boot\r"
+
+			[devices.login]
+			user = "root"
+			password_file = "/perm/consrv/server.pass"
+			identities = ["ed25519"]
+
+			[devices.gdb]
+			address = "127.0.0.1:2345"
+
+			[devices.pty]
+
+			[devices.zmodem]
+			spool = "/perm/consrv/zmodem/server"
+
+			[devices.fanout]
+			devices = ["desktop"]
+
+			[devices.keymap]
+			presets = ["backspace", "vt100"]
+			rules = [{ from = "\u001b[3~", to = "\u007f" }]
+
+			[devices.rate_alarm]
+			lines_per_minute = 5000
 
 			[[devices]]
 			name = "desktop"
 			serial = "DEADBEEF"
 			baud = 115200
+			logtostdout = true
+			encoding = "cp437"
+			require_approval = true
+			require_reason = true
+			read_only = true
+			log_color = "cyan"
+			redact = [
+				{ pattern = "(password=)\\S+", replacement = "${1}***" },
+			]
+
+			[devices.boot]
+			markers = [
+				{ name = "uboot", pattern = "U-Boot \\d" },
+				{ name = "login", pattern = "login:" },
+			]
+
+			[devices.wakeup]
+			interval = "5m"
+			bytes = "\r"
+
+			[[listeners]]
+			address = ":2223"
+			devices = ["server"]
 
 			[[identities]]
 			name = "ed25519"
@@ -195,26 +1574,146 @@ func Test_parseConfig(t *testing.T) {
 			name = "rsa"
 			public_key = "ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAABgQDDkvg9+NTySctVaMkbZGwTRIUiQSo4crGWQPeFTi/XM3KhcUY+WduwHChJX1h03/DKJps8wtHUn3LmUKFR4BoJEgt8Od+L6ey5sev4lvPa2wDc5HJfervgCnVt9aomdFqeZUe6g4BDdPLUGbzT3T+A+08ocXy/eVv9Kke7Ka6GslJQQ5TBjW0AbPhxu6QmoZDb0tiWf9CwyVpiox5+vW7E+O6U1QOKT45Ellc2smHSAcI1gUDborS0GhFSso9SagMxcWNbZf8920DeaLs5tb8uwKfWKqHJfkY+VK3QuufpWZM3BJTPa0PePd75NRra2BOV4LDwGlLrZjOCULlYawDlDOIm6rpC3QV7juHTFWjS8ImvbsyEWZSE9N6klDMc23Zl9vhqJcG4U9LVAv2QMcr8aXBnmSo49rkd7/H6yHZgWqmrAijloZkiwsTbofT+lQx3JLEagk1rd8rmCp4F7WeUShvvmTq0tyPDutIhd1TXwLB0gyFObCDgb3CrXPtsACc= test RSA"
 
+			[[identities]]
+			name = "matt@example.com"
+
+			[oidc]
+			address = ":8443"
+			tls_cert = "/perm/consrv/tls.crt"
+			tls_key = "/perm/consrv/tls.key"
+			issuer = "https://accounts.example.com"
+			client_id = "consrv"
+			ca_key = "/perm/consrv/ca_key"
+
+			[vault]
+			address = "https://vault.example.com:8200"
+			token_file = "/perm/consrv/vault.token"
+			host_role = "consrv"
+
 			[debug]
 			address = "localhost:9288"
 			prometheus = true
 			pprof = true
+			vars = true
+			capture = true
+			capture_size = 65536
+			admin_token_file = "/perm/consrv/admin.token"
+
+			[debug.push]
+			format = "influxdb"
+			address = "localhost:8089"
+
+			[stats]
+			path = "/perm/consrv/stats.json"
+
+			[memory]
+			limit = 402653184
+			max_buffered = 16777216
+
+			[log]
+			prefix = "{{.Time.Format \"15:04:05\"}} {{.Name}}: "
+			directory = "/perm/consrv/logs"
+			mode = "escape"
+			exclude_identities = ["ed25519"]
+
+			[log.retention]
+			max_age = "720h"
+			max_size = 1073741824
+
+			[log.disk]
+			low_free = 536870912
+			min_free = 104857600
+			webhook = "https://example.com/disk"
 			`,
 			c: &config{
-				Server: server{Address: ":2222"},
+				Server: server{
+					Address:               ":2222",
+					HostKeyPassphraseFile: "/perm/consrv/host_key.pass",
+					AuthLog:               "/perm/consrv/auth.log",
+					MaxConnections:        32,
+					MaxConnectionsPerIP:   4,
+					KeepAlive:             duration{30 * time.Second},
+					AuthExec:              []string{"/perm/consrv/oncall.sh", "-v"},
+					TrustedUserCAKeys:     []string{"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"},
+					SSH: sshConfig{
+						KeyExchanges: []string{"curve25519-sha256"},
+						Ciphers:      []string{"aes256-gcm@openssh.com", "chacha20-poly1305@openssh.com"},
+						MACs:         []string{"hmac-sha2-256-etm@openssh.com"},
+						Version:      "consrv_1.3",
+					},
+					Honeypot: &honeypotConfig{
+						Log: "/perm/consrv/honeypot.log",
+					},
+				},
 				Devices: []rawDevice{
 					{
-						Name:       "server",
-						Device:     "/dev/ttyUSB0",
-						Baud:       115200,
-						Identities: []string{"ed25519"},
+						Name:             "server",
+						Device:           "/dev/ttyUSB0",
+						Baud:             115200,
+						Identities:       []string{"ed25519"},
+						DeniedIdentities: []string{"rsa"},
+						Share:            true,
+						Watchdog: &watchdogConfig{
+							Idle:    duration{10 * time.Minute},
+							After:   []string{"reboot: Restarting system"},
+							Webhook: "https://example.com/hook",
+							Command: []string{"/usr/local/bin/power-cycle", "server"},
+						},
+						Interrupt: &interruptConfig{
+							Pattern: "Hit any key to stop autoboot",
+							Keys:    " ",
+							Wait:    duration{5 * time.Minute},
+							Resume:  "boot\r",
+						},
+						Login: &loginConfig{
+							User:            "root",
+							PasswordFile:    "/perm/consrv/server.pass",
+							Identities:      []string{"ed25519"},
+							LoginPattern:    defaultLoginPattern,
+							PasswordPattern: defaultPasswordPattern,
+						},
+						GDB: &gdbConfig{Address: "127.0.0.1:2345"},
+						PTY: &ptyConfig{Path: "/run/consrv/server"},
+						ZModem: &zmodemConfig{
+							Spool: "/perm/consrv/zmodem/server",
+						},
+						FanOut: &fanOutConfig{Devices: []string{"desktop"}},
+						Keymap: &keymapConfig{
+							Presets: []string{"backspace", "vt100"},
+							Rules:   []keymapRule{{From: "\x1b[3~", To: "\x7f"}},
+						},
+						RateAlarm: &rateAlarmConfig{LinesPerMinute: 5000},
 					},
 					{
-						Name:   "desktop",
-						Serial: "DEADBEEF",
-						Baud:   115200,
+						Name:            "desktop",
+						Serial:          "DEADBEEF",
+						Baud:            115200,
+						LogToStdout:     true,
+						Encoding:        "cp437",
+						RequireApproval: true,
+						RequireReason:   true,
+						ReadOnly:        true,
+						LogColor:        "cyan",
+						Redact: []redactRule{{
+							Pattern:     `(password=)\S+`,
+							Replacement: "${1}***",
+						}},
+						Boot: &bootConfig{
+							Markers: []bootMarker{
+								{Name: "uboot", Pattern: `U-Boot \d`},
+								{Name: "login", Pattern: "login:"},
+							},
+						},
+						Wakeup: &wakeupConfig{
+							Interval: duration{5 * time.Minute},
+							Bytes:    "\r",
+						},
 					},
 				},
+				Listeners: []listenerConfig{{
+					Address: ":2223",
+					Devices: []string{"server"},
+				}},
 				Identities: []identity{
 					{
 						Name:      "ed25519",
@@ -224,11 +1723,61 @@ func Test_parseConfig(t *testing.T) {
 						Name:      "rsa",
 						PublicKey: mustKey("ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAABgQDDkvg9+NTySctVaMkbZGwTRIUiQSo4crGWQPeFTi/XM3KhcUY+WduwHChJX1h03/DKJps8wtHUn3LmUKFR4BoJEgt8Od+L6ey5sev4lvPa2wDc5HJfervgCnVt9aomdFqeZUe6g4BDdPLUGbzT3T+A+08ocXy/eVv9Kke7Ka6GslJQQ5TBjW0AbPhxu6QmoZDb0tiWf9CwyVpiox5+vW7E+O6U1QOKT45Ellc2smHSAcI1gUDborS0GhFSso9SagMxcWNbZf8920DeaLs5tb8uwKfWKqHJfkY+VK3QuufpWZM3BJTPa0PePd75NRra2BOV4LDwGlLrZjOCULlYawDlDOIm6rpC3QV7juHTFWjS8ImvbsyEWZSE9N6klDMc23Zl9vhqJcG4U9LVAv2QMcr8aXBnmSo49rkd7/H6yHZgWqmrAijloZkiwsTbofT+lQx3JLEagk1rd8rmCp4F7WeUShvvmTq0tyPDutIhd1TXwLB0gyFObCDgb3CrXPtsACc= test RSA"),
 					},
+					{Name: "matt@example.com"},
 				},
+				OIDC: &oidcConfig{
+					Address:  ":8443",
+					TLSCert:  "/perm/consrv/tls.crt",
+					TLSKey:   "/perm/consrv/tls.key",
+					Issuer:   "https://accounts.example.com",
+					ClientID: "consrv",
+					Claim:    defaultOIDCClaim,
+					CAKey:    "/perm/consrv/ca_key",
+					Validity: duration{defaultOIDCValidity},
+				},
+				Vault: &vaultConfig{
+					Address:   "https://vault.example.com:8200",
+					TokenFile: "/perm/consrv/vault.token",
+					Mount:     defaultVaultMount,
+					HostRole:  "consrv",
+				},
+				UserCAKeys: []ssh.PublicKey{mustKey("ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519")},
 				Debug: debug{
-					Address:    "localhost:9288",
-					Prometheus: true,
-					PProf:      true,
+					Address:        "localhost:9288",
+					Prometheus:     true,
+					PProf:          true,
+					Vars:           true,
+					Capture:        true,
+					CaptureSize:    65536,
+					AdminTokenFile: "/perm/consrv/admin.token",
+					Push: &pushConfig{
+						Format:   pushInfluxDB,
+						Address:  "localhost:8089",
+						Interval: duration{Duration: defaultPushInterval},
+					},
+				},
+				Stats: statsConfig{Path: "/perm/consrv/stats.json"},
+				Memory: memoryConfig{
+					Limit:       402653184,
+					MaxBuffered: 16777216,
+					Queue:       defaultQueue,
+				},
+				Log: logConfig{
+					Prefix:    `{{.Time.Format "15:04:05"}} {{.Name}}: `,
+					Directory: "/perm/consrv/logs",
+					Mode:      "escape",
+					Retention: &retentionConfig{
+						MaxAge:  duration{720 * time.Hour},
+						MaxSize: 1 << 30,
+					},
+					Disk: &diskConfig{
+						Interval: duration{defaultDiskInterval},
+						LowFree:  512 << 20,
+						MinFree:  100 << 20,
+						LowRate:  defaultLowRate,
+						Webhook:  "https://example.com/disk",
+					},
+					ExcludeIdentities: []string{"ed25519"},
 				},
 			},
 			ok: true,
@@ -249,14 +1798,366 @@ func Test_parseConfig(t *testing.T) {
 				return
 			}
 
-			if diff := cmp.Diff(tt.c, c, cmp.Comparer(keysEqual)); diff != "" {
+			if diff := cmp.Diff(tt.c, c, cmp.Comparer(keysEqual), cmpopts.IgnoreFields(config{}, "Hash")); diff != "" {
 				t.Fatalf("unexpected config (-want +got):\n%s", diff)
 			}
 		})
 	}
 }
 
-func keysEqual(x, y ssh.PublicKey) bool { return ssh.KeysEqual(x, y) }
+func Test_parseConfigHash(t *testing.T) {
+	const s = `
+	[[devices]]
+	name = "server"
+	device = "/dev/ttyUSB0"
+	baud = 115200
+
+	[[identities]]
+	name = "ed25519"
+	public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+	`
+
+	c, err := parseConfig(strings.NewReader(s))
+	if err != nil {
+		t.Fatalf("failed to parse config: %v", err)
+	}
+
+	want := sha256.Sum256([]byte(s))
+	if diff := cmp.Diff(hex.EncodeToString(want[:]), c.Hash); diff != "" {
+		t.Fatalf("unexpected config hash (-want +got):\n%s", diff)
+	}
+}
+
+func Test_parseConfigDeviceDefaults(t *testing.T) {
+	const s = `
+	[device_defaults]
+	baud = 115200
+	identities = ["ed25519"]
+	logtostdout = true
+	log_color = "green"
+
+	[[devices]]
+	name = "server"
+	device = "/dev/ttyUSB0"
+
+	[[devices]]
+	name = "desktop"
+	device = "/dev/ttyUSB1"
+	baud = 9600
+	identities = []
+	logtostdout = false
+
+	[[identities]]
+	name = "ed25519"
+	public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+	`
+
+	c, err := parseConfig(strings.NewReader(s))
+	if err != nil {
+		t.Fatalf("failed to parse config: %v", err)
+	}
+
+	want := []rawDevice{
+		{
+			Name:        "server",
+			Device:      "/dev/ttyUSB0",
+			Baud:        115200,
+			Identities:  []string{"ed25519"},
+			LogToStdout: true,
+			LogColor:    "green",
+		},
+		{
+			Name:       "desktop",
+			Device:     "/dev/ttyUSB1",
+			Baud:       9600,
+			Identities: []string{},
+			LogColor:   "green",
+		},
+	}
+
+	if diff := cmp.Diff(want, c.Devices); diff != "" {
+		t.Fatalf("unexpected devices (-want +got):\n%s", diff)
+	}
+}
+
+func Test_parseConfigGroups(t *testing.T) {
+	const s = `
+	[[groups]]
+	name = "sre"
+	identities = ["alice", "bob"]
+
+	[[devices]]
+	name = "server"
+	device = "/dev/ttyUSB0"
+	baud = 115200
+	identities = ["sre", "carol", "alice"]
+
+	[devices.login]
+	user = "root"
+	password_file = "/perm/consrv/server.pass"
+	identities = ["sre"]
+
+	[[identities]]
+	name = "alice"
+	public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+
+	[[identities]]
+	name = "bob"
+	public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+
+	[[identities]]
+	name = "carol"
+	public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+	`
+
+	c, err := parseConfig(strings.NewReader(s))
+	if err != nil {
+		t.Fatalf("failed to parse config: %v", err)
+	}
+
+	d := c.Devices[0]
+	if diff := cmp.Diff([]string{"alice", "bob", "carol"}, d.Identities); diff != "" {
+		t.Fatalf("unexpected device identities (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"alice", "bob"}, d.Login.Identities); diff != "" {
+		t.Fatalf("unexpected login identities (-want +got):\n%s", diff)
+	}
+}
+
+func Test_parseConfigNamespaces(t *testing.T) {
+	const (
+		ed25519 = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+		rsa     = "ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAABgQDDkvg9+NTySctVaMkbZGwTRIUiQSo4crGWQPeFTi/XM3KhcUY+WduwHChJX1h03/DKJps8wtHUn3LmUKFR4BoJEgt8Od+L6ey5sev4lvPa2wDc5HJfervgCnVt9aomdFqeZUe6g4BDdPLUGbzT3T+A+08ocXy/eVv9Kke7Ka6GslJQQ5TBjW0AbPhxu6QmoZDb0tiWf9CwyVpiox5+vW7E+O6U1QOKT45Ellc2smHSAcI1gUDborS0GhFSso9SagMxcWNbZf8920DeaLs5tb8uwKfWKqHJfkY+VK3QuufpWZM3BJTPa0PePd75NRra2BOV4LDwGlLrZjOCULlYawDlDOIm6rpC3QV7juHTFWjS8ImvbsyEWZSE9N6klDMc23Zl9vhqJcG4U9LVAv2QMcr8aXBnmSo49rkd7/H6yHZgWqmrAijloZkiwsTbofT+lQx3JLEagk1rd8rmCp4F7WeUShvvmTq0tyPDutIhd1TXwLB0gyFObCDgb3CrXPtsACc= test RSA"
+	)
+
+	const s = `
+	[[devices]]
+	name = "server"
+	namespace = "red"
+	device = "/dev/ttyUSB0"
+	baud = 115200
+
+	[[devices]]
+	name = "desktop"
+	namespace = "blue"
+	device = "/dev/ttyUSB1"
+	baud = 115200
+
+	[[identities]]
+	name = "alice"
+	public_key = "` + ed25519 + `"
+	namespace = "red"
+
+	[[identities]]
+	name = "bob"
+	public_key = "` + rsa + `"
+	namespace = "blue"
+	`
+
+	c, err := parseConfig(strings.NewReader(s))
+	if err != nil {
+		t.Fatalf("failed to parse config: %v", err)
+	}
+
+	ids, err := newIdentities(c, nil, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatalf("failed to create identities: %v", err)
+	}
+
+	// Each identity may only access the devices in its own namespace.
+	var got []string
+	for _, d := range []string{"server", "desktop"} {
+		for _, k := range []string{ed25519, rsa} {
+			if name, ok := ids.Authenticate(d, mustKey(k)); ok {
+				got = append(got, d+"="+name)
+			}
+		}
+	}
+
+	if diff := cmp.Diff([]string{"server=alice", "desktop=bob"}, got); diff != "" {
+		t.Fatalf("unexpected access (-want +got):\n%s", diff)
+	}
+}
+
+func Test_configMaskSerials(t *testing.T) {
+	const key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+
+	const (
+		device = `
+		[[devices]]
+		name = "server"
+		device = "/dev/ttyUSB0"
+		baud = 115200
+
+		[[identities]]
+		name = "alice"
+		public_key = "` + key + `"
+		`
+
+		namespace = `
+		[[devices]]
+		name = "server"
+		namespace = "red"
+		device = "/dev/ttyUSB0"
+		baud = 115200
+
+		[[identities]]
+		name = "alice"
+		public_key = "` + key + `"
+		namespace = "red"
+		`
+	)
+
+	tests := []struct {
+		name string
+		s    string
+		ok   bool
+	}{
+		{
+			name: "default",
+			s:    device,
+		},
+		{
+			name: "enabled",
+			s:    "[log]\nmask_serials = true\n" + device,
+			ok:   true,
+		},
+		{
+			name: "namespaces",
+			s:    namespace,
+			ok:   true,
+		},
+		{
+			name: "namespaces disabled",
+			s:    "[log]\nmask_serials = false\n" + namespace,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := parseConfig(strings.NewReader(tt.s))
+			if err != nil {
+				t.Fatalf("failed to parse config: %v", err)
+			}
+
+			if diff := cmp.Diff(tt.ok, c.maskSerials()); diff != "" {
+				t.Fatalf("unexpected mask serials (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_configCheckDropPrivileges(t *testing.T) {
+	serial := rawDevice{Name: "server", Device: "/dev/ttyUSB0"}
+	device := func(fn func(d *rawDevice)) []rawDevice {
+		d := serial
+		fn(&d)
+		return []rawDevice{d}
+	}
+
+	tests := []struct {
+		name string
+		c    config
+		ok   bool
+	}{
+		{
+			name: "OK",
+			c:    config{Devices: []rawDevice{serial}},
+			ok:   true,
+		},
+		{
+			name: "push",
+			c:    config{Debug: debug{Push: &pushConfig{}}},
+		},
+		{
+			name: "remotes",
+			c:    config{Remotes: []remoteConfig{{}}},
+		},
+		{
+			name: "session webhook",
+			c:    config{Server: server{SessionWebhook: &sessionWebhookConfig{}}},
+		},
+		{
+			name: "log retention",
+			c:    config{Log: logConfig{Retention: &retentionConfig{}}},
+		},
+		{
+			name: "log directory",
+			c:    config{Log: logConfig{Directory: "/perm/consrv/logs"}},
+		},
+		{
+			name: "log disk",
+			c:    config{Log: logConfig{Disk: &diskConfig{}}},
+		},
+		{
+			name: "standby",
+			c:    config{Standby: &standbyConfig{}},
+		},
+		{
+			name: "OIDC",
+			c:    config{OIDC: &oidcConfig{}},
+		},
+		{
+			name: "Vault",
+			c:    config{Vault: &vaultConfig{}},
+		},
+		{
+			name: "TCP device",
+			c: config{Devices: device(func(d *rawDevice) {
+				d.Device = ""
+				d.Address = "localhost:2000"
+			})},
+		},
+		{
+			name: "shared device",
+			c:    config{Devices: device(func(d *rawDevice) { d.Share = true })},
+		},
+		{
+			name: "watchdog webhook",
+			c: config{Devices: device(func(d *rawDevice) {
+				d.Watchdog = &watchdogConfig{Webhook: "http://localhost"}
+			})},
+		},
+		{
+			name: "interrupt webhook",
+			c: config{Devices: device(func(d *rawDevice) {
+				d.Interrupt = &interruptConfig{Webhook: "http://localhost"}
+			})},
+		},
+		{
+			name: "ZMODEM spool",
+			c: config{Devices: device(func(d *rawDevice) {
+				d.ZModem = &zmodemConfig{Spool: "/tmp"}
+			})},
+		},
+		{
+			name: "ZMODEM OK",
+			c:    config{Devices: device(func(d *rawDevice) { d.ZModem = &zmodemConfig{} })},
+			ok:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.c.checkDropPrivileges()
+			if tt.ok && err != nil {
+				t.Fatalf("failed to check config: %v", err)
+			}
+			if !tt.ok && err == nil {
+				t.Fatal("expected an error, but none occurred")
+			}
+		})
+	}
+}
+
+func keysEqual(x, y ssh.PublicKey) bool {
+	if x == nil || y == nil {
+		// Identities which authenticate with certificates have no key.
+		return x == nil && y == nil
+	}
+
+	return ssh.KeysEqual(x, y)
+}
 
 func mustKey(s string) ssh.PublicKey {
 	k, _, _, _, err := ssh.ParseAuthorizedKey([]byte(s))
@@ -0,0 +1,110 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// checkContainer verifies that the configured devices have been passed through
+// to a container with usable permissions. It reports whether sysfs is mounted
+// so that USB serial numbers can be enumerated.
+func checkContainer(devices []rawDevice, ll *log.Logger) (bool, error) {
+	sysfs := true
+	if _, err := os.Stat("/sys/class/tty"); err != nil {
+		ll.Printf("container: /sys is not mounted, skipping USB serial number enumeration")
+		sysfs = false
+	}
+
+	return sysfs, checkDevices(devices, sysfs)
+}
+
+// checkDevices checks the configured devices for common container
+// misconfigurations, and returns an error describing each problem found.
+func checkDevices(devices []rawDevice, sysfs bool) error {
+	var errs []error
+	for _, d := range devices {
+		if d.Address != "" {
+			// Network-attached devices don't need to be passed through.
+			continue
+		}
+		if d.Serial != "" || d.USBPath != "" || d.Auto {
+			if !sysfs && d.Serial != "" {
+				errs = append(errs, fmt.Errorf(
+					"device %q: serial %q cannot be looked up without /sys; mount it read-only with --volume=/sys:/sys:ro or configure a device path",
+					d.Name, d.Serial,
+				))
+			}
+			if !sysfs && d.USBPath != "" {
+				errs = append(errs, fmt.Errorf(
+					"device %q: USB path %q cannot be looked up without /sys; mount it read-only with --volume=/sys:/sys:ro or configure a device path",
+					d.Name, d.USBPath,
+				))
+			}
+			if !sysfs && d.Auto {
+				errs = append(errs, fmt.Errorf(
+					"device template %q: devices cannot be enumerated without /sys; mount it read-only with --volume=/sys:/sys:ro or configure device paths",
+					d.Name,
+				))
+			}
+
+			// The path isn't known until enumeration.
+			continue
+		}
+
+		fi, err := os.Stat(d.Device)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				errs = append(errs, fmt.Errorf(
+					"device %q: %s does not exist in the container; pass it through with --device=%s",
+					d.Name, d.Device, d.Device,
+				))
+				continue
+			}
+
+			errs = append(errs, fmt.Errorf("device %q: %v", d.Name, err))
+			continue
+		}
+
+		if fi.Mode()&os.ModeCharDevice == 0 {
+			errs = append(errs, fmt.Errorf(
+				"device %q: %s is not a character device (mode: %s); pass the host device through with --device=%s",
+				d.Name, d.Device, fi.Mode(), d.Device,
+			))
+			continue
+		}
+
+		if err := unix.Access(d.Device, unix.R_OK|unix.W_OK); err != nil {
+			gid := -1
+			if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+				gid = int(st.Gid)
+			}
+
+			errs = append(errs, fmt.Errorf(
+				"device %q: %s is not readable and writable by UID %d; add the device's group with --group-add=%d",
+				d.Name, d.Device, os.Getuid(), gid,
+			))
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,75 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_checkDevices(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "ttyUSB0")
+	if err := os.WriteFile(file, nil, 0o600); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		device rawDevice
+		sysfs  bool
+		ok     bool
+	}{
+		{
+			name:   "missing",
+			device: rawDevice{Name: "foo", Device: "/dev/consrv-does-not-exist"},
+		},
+		{
+			name:   "not character device",
+			device: rawDevice{Name: "foo", Device: file},
+		},
+		{
+			name:   "serial without sysfs",
+			device: rawDevice{Name: "foo", Serial: "DEADBEEF"},
+		},
+		{
+			name:   "OK serial",
+			device: rawDevice{Name: "foo", Serial: "DEADBEEF"},
+			sysfs:  true,
+			ok:     true,
+		},
+		{
+			name:   "OK character device",
+			device: rawDevice{Name: "foo", Device: os.DevNull},
+			ok:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkDevices([]rawDevice{tt.device}, tt.sysfs)
+			if tt.ok && err != nil {
+				t.Fatalf("failed to check devices: %v", err)
+			}
+			if !tt.ok && err == nil {
+				t.Fatal("expected an error, but none occurred")
+			}
+			if err != nil {
+				t.Logf("err: %v", err)
+			}
+		})
+	}
+}
@@ -14,30 +14,33 @@
 package main
 
 import (
+	"crypto/sha256"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 
+	"github.com/mdlayher/consrv"
 	"github.com/mdlayher/metricslite"
 	"github.com/tarm/serial"
 )
 
-// A device is a handle to a console device.
-type device interface {
-	io.ReadWriteCloser
-	fmt.Stringer
-}
-
-var _ device = &serialDevice{}
+var _ consrv.Device = &serialDevice{}
 
-// A serialDevice is a device implemented using a serial port.
+// A serialDevice is a consrv.Device implemented using a serial port.
 type serialDevice struct {
 	rwc                  io.ReadWriteCloser
 	name, device, serial string
 	baud                 int
+	timeout              bool
 	reads, writes        metricslite.Counter
 }
 
@@ -48,6 +51,12 @@ func (d *serialDevice) Close() error { return d.rwc.Close() }
 func (d *serialDevice) Read(b []byte) (int, error) {
 	n, err := d.rwc.Read(b)
 	d.reads(float64(n), d.name)
+	if d.timeout && n == 0 && err == io.EOF {
+		// An empty read is reported as EOF when the read timeout expires, but
+		// the device may still produce more data.
+		return 0, nil
+	}
+
 	return n, err
 }
 
@@ -64,53 +73,65 @@ func (d *serialDevice) String() string {
 		d.name, d.device, d.serial, d.baud)
 }
 
-// A muxDevice is a device with multiplexed reads.
-type muxDevice struct {
-	m *mux
-	device
-}
-
-// newMuxDevice wraps a device with a mux.
-func newMuxDevice(d device) *muxDevice {
-	return &muxDevice{
-		m:      newMux(d),
-		device: d,
-	}
-}
-
-// Close cleans up the device and mux.
-func (d *muxDevice) Close() error {
-	err1 := d.device.Close()
-	err2 := d.m.Close()
-
-	if err1 != nil {
-		return err1
-	}
-	if err2 != nil {
-		return err2
-	}
-
-	return nil
-}
-
 // An fs abstracts filesystem operations. Most callers should use newFS to
 // construct an fs that operates on the real filesystem.
 type fs struct {
-	serialToDevice map[string]string
-
-	glob     func(pattern string) ([]string, error)
-	readFile func(file string) ([]byte, error)
-	openPort func(cfg *serial.Config) (io.ReadWriteCloser, error)
+	// mu guards the lookup tables and adapters, which are replaced when
+	// devices are periodically enumerated again.
+	mu              sync.Mutex
+	serialToDevice  map[string]string
+	usbPathToDevice map[string]string
+	duplicates      map[string][]string
+	metadata        map[string]map[string]string
+	adapters        []enumeratedDevice
+
+	// maskSerials masks serial numbers in logs and metrics for deployments
+	// where they shouldn't be visible to every tenant.
+	maskSerials bool
+
+	glob      func(pattern string) ([]string, error)
+	readFile  func(file string) ([]byte, error)
+	readLink  func(file string) (string, error)
+	listPorts func() ([]enumeratedDevice, error)
+	openPort  func(cfg *serial.Config) (io.ReadWriteCloser, error)
+
+	// watch, if not nil, waits for a missing device path to appear so that
+	// the device may be opened later rather than failing immediately.
+	watch func(path string, done <-chan struct{}) error
+
+	// chrooted is set when privileges are dropped, after which device paths
+	// can't be opened again, so devices can't be parked and resumed.
+	chrooted bool
+
+	// lockPort, if not nil, takes an advisory lock on a shared serial port,
+	// returning a *busyError if another process holds it. portHolder, if not
+	// nil, identifies the process which holds a busy port.
+	lockPort   func(path string) (io.Closer, error)
+	portHolder func(path string) *busyError
+
+	ll *log.Logger
 }
 
-// newFS creates a fs that operates on the real filesystem.
-func newFS(ll *log.Logger) (*fs, error) {
+// newFS creates a fs that operates on the real filesystem. If sysfs is false,
+// devices are not enumerated from /sys. If mask is true, serial numbers are
+// masked in logs.
+func newFS(ll *log.Logger, sysfs, mask bool) (*fs, error) {
 	fs := &fs{
-		glob:     filepath.Glob,
-		readFile: os.ReadFile,
+		glob:      filepath.Glob,
+		readFile:  os.ReadFile,
+		readLink:  filepath.EvalSymlinks,
+		listPorts: osListPorts,
 		openPort: func(cfg *serial.Config) (io.ReadWriteCloser, error) {
 			return serial.OpenPort(cfg)
 		},
+		watch:       watchPath,
+		lockPort:    lockPort,
+		portHolder:  portHolder,
+		maskSerials: mask,
+		ll:          ll,
+	}
+	if !sysfs {
+		fs.glob = nil
 	}
 
 	return fs, fs.init(ll)
@@ -119,14 +140,157 @@ func newFS(ll *log.Logger) (*fs, error) {
 // init initializes a fs by enumerating the available devices and logging them
 // so the user may more easily configure them.
 func (fs *fs) init(ll *log.Logger) error {
-	fs.serialToDevice = make(map[string]string)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	fs.index(nil)
 	eds, err := fs.enumerate()
 	if err != nil {
 		return err
 	}
+	fs.index(eds)
 
 	for _, ed := range eds {
-		ll.Printf("found device: path: %q, serial: %q", ed.device, ed.serial)
+		md := fs.metadata[ed.device]
+
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "found device: path: %q, serial: %q", ed.device, fs.serial(ed.serial))
+		for _, k := range slices.Sorted(maps.Keys(md)) {
+			fmt.Fprintf(&sb, ", %s: %q", k, md[k])
+		}
+
+		ll.Print(sb.String())
+	}
+
+	fs.warnDuplicates(nil, ll)
+	return nil
+}
+
+// update replaces the enumerated devices of fs with eds, returning the
+// previously enumerated devices.
+func (fs *fs) update(eds []enumeratedDevice, ll *log.Logger) []enumeratedDevice {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	prev, duplicates := fs.adapters, fs.duplicates
+	fs.index(eds)
+
+	// Only warn about serials which weren't already duplicated.
+	fs.warnDuplicates(duplicates, ll)
+	return prev
+}
+
+// enumerated returns the devices most recently enumerated by fs.
+func (fs *fs) enumerated() []enumeratedDevice {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	return slices.Clone(fs.adapters)
+}
+
+// index builds the lookup tables of fs from eds. The caller must hold fs.mu.
+func (fs *fs) index(eds []enumeratedDevice) {
+	fs.adapters = eds
+	fs.serialToDevice = make(map[string]string)
+	fs.usbPathToDevice = make(map[string]string)
+	fs.duplicates = make(map[string][]string)
+	fs.metadata = make(map[string]map[string]string)
+
+	for _, ed := range eds {
+		if ed.serial != "" {
+			if dev, ok := fs.serialToDevice[ed.serial]; ok && dev != ed.device {
+				// Cloned adapters often report identical serial numbers, so
+				// the serial can't identify either of them.
+				if len(fs.duplicates[ed.serial]) == 0 {
+					fs.duplicates[ed.serial] = []string{dev}
+				}
+				fs.duplicates[ed.serial] = append(fs.duplicates[ed.serial], ed.device)
+			}
+			fs.serialToDevice[ed.serial] = ed.device
+		}
+		if ed.usbPath != "" {
+			fs.usbPathToDevice[ed.usbPath] = ed.device
+		}
+
+		fs.metadata[ed.device] = ed.metadata()
+	}
+
+	for serial := range fs.duplicates {
+		delete(fs.serialToDevice, serial)
+	}
+}
+
+// warnDuplicates logs a warning for each duplicated serial which is not in
+// prev. The caller must hold fs.mu.
+func (fs *fs) warnDuplicates(prev map[string][]string, ll *log.Logger) {
+	for _, serial := range slices.Sorted(maps.Keys(fs.duplicates)) {
+		if _, ok := prev[serial]; ok {
+			continue
+		}
+
+		ll.Printf("WARNING: devices %s report identical serial %q, possibly due to cloned adapters; configure these devices by usb_path or device path instead",
+			fs.describe(fs.duplicates[serial]), fs.serial(serial))
+	}
+}
+
+// serial returns serial number s for logs and metrics, masked by a short hash
+// if serial numbers are masked.
+func (fs *fs) serial(s string) string {
+	if !fs.maskSerials || s == "" {
+		return s
+	}
+
+	return maskSerial(s)
+}
+
+// maskSerial masks serial number s with a short hash, so that a device's logs
+// and metrics can still be correlated without revealing its serial number.
+func maskSerial(s string) string {
+	h := sha256.Sum256([]byte(s))
+	return fmt.Sprintf("sha256:%x", h[:4])
+}
+
+// describe lists devices along with their USB paths, if known.
+func (fs *fs) describe(devices []string) string {
+	ss := make([]string, 0, len(devices))
+	for _, dev := range devices {
+		if p := fs.metadata[dev]["usb_path"]; p != "" {
+			ss = append(ss, fmt.Sprintf("%q (usb_path: %q)", dev, p))
+			continue
+		}
+
+		ss = append(ss, strconv.Quote(dev))
+	}
+
+	return strings.Join(ss, ", ")
+}
+
+// resolve sets the device path of d when d is configured by serial number or
+// USB path.
+func (fs *fs) resolve(d *rawDevice) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	switch {
+	case d.Serial != "":
+		if devs, ok := fs.duplicates[d.Serial]; ok {
+			return fmt.Errorf("serial %q is reported by multiple devices %s; configure usb_path to choose one",
+				fs.serial(d.Serial), fs.describe(devs))
+		}
+
+		dev, ok := fs.serialToDevice[d.Serial]
+		if !ok {
+			return os.ErrNotExist
+		}
+
+		d.Device = dev
+	case d.USBPath != "":
+		dev, ok := fs.usbPathToDevice[d.USBPath]
+		if !ok {
+			return os.ErrNotExist
+		}
+
+		d.Device = dev
 	}
 
 	return nil
@@ -134,11 +298,40 @@ func (fs *fs) init(ll *log.Logger) error {
 
 // An enumerated device is a device found in the filesystem.
 type enumeratedDevice struct {
-	device, serial string
+	device, serial, description string
+
+	// Optional details about the adapter, read from sysfs on Linux.
+	vendor, product, usbID, usbPath, driver, path string
+}
+
+// metadata returns the non-empty adapter details of ed, keyed by name.
+func (ed enumeratedDevice) metadata() map[string]string {
+	md := make(map[string]string)
+	for k, v := range map[string]string{
+		"description": ed.description,
+		"vendor":      ed.vendor,
+		"product":     ed.product,
+		"usb_id":      ed.usbID,
+		"usb_path":    ed.usbPath,
+		"driver":      ed.driver,
+		"sysfs_path":  ed.path,
+	} {
+		if v != "" {
+			md[k] = v
+		}
+	}
+
+	return md
 }
 
 // enumerate enumerates all available serial devices from the filesystem.
 func (fs *fs) enumerate() ([]enumeratedDevice, error) {
+	if fs.listPorts != nil {
+		// The operating system provides its own means of listing ports rather
+		// than exposing them in the filesystem.
+		return fs.listPorts()
+	}
+
 	if fs.glob == nil {
 		// No glob function, can't enumerate devices.
 		return nil, nil
@@ -197,46 +390,141 @@ func (fs *fs) match(sm serialMatch) ([]enumeratedDevice, error) {
 		}
 
 		serial := strings.TrimSpace(string(b))
-		eds = append(eds, enumeratedDevice{
+		ed := enumeratedDevice{
 			device: m,
 			serial: serial,
-		})
-
-		fs.serialToDevice[serial] = m
+		}
+		fs.details(&ed, sm)
+		eds = append(eds, ed)
 	}
 
 	return eds, nil
 }
 
-// openSerial opens a serial port and instruments it with metrics.
-func (fs *fs) openSerial(d *rawDevice, reads, writes metricslite.Counter) (device, error) {
-	if d.Serial != "" {
-		// If the caller specified a serial number, use it to look up the
-		// device's path.
-		dev, ok := fs.serialToDevice[d.Serial]
-		if !ok {
-			return nil, os.ErrNotExist
+// details reads optional adapter details for ed from sysfs. The details are
+// informational only, so any which can't be read are left empty.
+func (fs *fs) details(ed *enumeratedDevice, sm serialMatch) {
+	var (
+		tty = filepath.Join("/sys/class/tty/", filepath.Base(ed.device))
+		// The USB device directory also contains the serial number file.
+		usb = tty + strings.TrimSuffix(sm.Suffix, "serial")
+	)
+
+	read := func(file string) string {
+		b, err := fs.readFile(usb + file)
+		if err != nil {
+			return ""
 		}
 
-		d.Device = dev
+		return strings.TrimSpace(string(b))
+	}
+
+	ed.vendor = read("manufacturer")
+	ed.product = read("product")
+	if vid, pid := read("idVendor"), read("idProduct"); vid != "" && pid != "" {
+		ed.usbID = vid + ":" + pid
+	}
+
+	if fs.readLink == nil {
+		return
+	}
+	if p, err := fs.readLink(usb); err == nil {
+		// The USB device directory is named for its bus and port path, which
+		// remains stable as long as the adapter is plugged into the same port.
+		ed.usbPath = filepath.Base(p)
+	}
+	if p, err := fs.readLink(tty + "/device/driver"); err == nil {
+		ed.driver = filepath.Base(p)
+	}
+	if p, err := fs.readLink(tty + "/device"); err == nil {
+		ed.path = p
+	}
+}
+
+// openSerial opens a serial port and instruments it with metrics.
+func (fs *fs) openSerial(d *rawDevice, reads, writes metricslite.Counter) (consrv.Device, error) {
+	// If the caller specified a serial number or USB path, use it to look up
+	// the device's path.
+	if err := fs.resolve(d); err != nil {
+		return nil, err
+	}
+
+	dev, err := fs.open(d, reads, writes)
+	if errors.Is(err, os.ErrNotExist) && d.Serial == "" && d.USBPath == "" && fs.watch != nil {
+		// The device path may appear later, such as when a device tree overlay
+		// is loaded, so wait for it rather than failing.
+		rd := *d
+		return newPendingDevice(rd, func() (consrv.Device, error) {
+			return fs.open(&rd, reads, writes)
+		}, fs.watch, fs.ll), nil
+	}
+
+	var busy *busyError
+	if d.Share && errors.As(err, &busy) {
+		// Another process holds the port, so keep retrying until it is
+		// released rather than failing.
+		rd := *d
+		return newSharedDevice(rd, busy, func() (consrv.Device, error) {
+			return fs.open(&rd, reads, writes)
+		}, fs.ll), nil
+	}
+
+	return dev, err
+}
+
+// open opens the serial port for d and instruments it with metrics.
+func (fs *fs) open(d *rawDevice, reads, writes metricslite.Counter) (consrv.Device, error) {
+	// Shared ports are locked first so that cooperating tools see that the
+	// port is in use, and so that a port in use by such a tool is not opened.
+	var lock io.Closer
+	if d.Share && fs.lockPort != nil {
+		l, err := fs.lockPort(d.Device)
+		if err != nil {
+			return nil, err
+		}
+		lock = l
 	}
 
 	// name is the friendly name, while device is the raw device/port path.
 	rwc, err := fs.openPort(&serial.Config{
-		Name: d.Device,
-		Baud: d.Baud,
+		Name:        d.Device,
+		Baud:        d.Baud,
+		ReadTimeout: d.ReadTimeout.Duration,
 	})
 	if err != nil {
+		if lock != nil {
+			_ = lock.Close()
+		}
+		if d.Share && errors.Is(err, syscall.EBUSY) {
+			// The port was opened exclusively by a tool which doesn't use
+			// advisory locks.
+			return nil, fs.holder(d.Device)
+		}
+
 		return nil, err
 	}
+	if lock != nil {
+		rwc = &lockedPort{ReadWriteCloser: rwc, lock: lock}
+	}
 
 	return &serialDevice{
-		rwc:    rwc,
-		name:   d.Name,
-		device: d.Device,
-		serial: d.Serial,
-		baud:   d.Baud,
-		reads:  reads,
-		writes: writes,
+		rwc:     rwc,
+		name:    d.Name,
+		device:  d.Device,
+		serial:  fs.serial(d.Serial),
+		baud:    d.Baud,
+		timeout: d.ReadTimeout.Duration > 0,
+		reads:   reads,
+		writes:  writes,
 	}, nil
 }
+
+// holder returns a *busyError which identifies the holder of the port at path,
+// if possible.
+func (fs *fs) holder(path string) *busyError {
+	if fs.portHolder == nil {
+		return &busyError{}
+	}
+
+	return fs.portHolder(path)
+}
@@ -19,9 +19,12 @@ import (
 	"io"
 	"log"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/mdlayher/consrv"
 	"github.com/tarm/serial"
 )
 
@@ -30,7 +33,7 @@ func Test_fs_openSerial(t *testing.T) {
 		name string
 		fs   *fs
 		raw  *rawDevice
-		want device
+		want consrv.Device
 		ok   bool
 	}{
 		{
@@ -42,6 +45,26 @@ func Test_fs_openSerial(t *testing.T) {
 			},
 			raw: &rawDevice{},
 		},
+		{
+			name: "OK pending device path",
+			fs: &fs{
+				openPort: func(_ *serial.Config) (io.ReadWriteCloser, error) {
+					return nil, os.ErrNotExist
+				},
+				watch: func(_ string, _ <-chan struct{}) error {
+					panic("should not be called")
+				},
+			},
+			raw: &rawDevice{
+				Name:   "foo",
+				Device: "/dev/ttyAMA1",
+				Baud:   115200,
+			},
+			want: &pendingDevice{
+				str: `"foo": path: "/dev/ttyAMA1", baud: 115200, pending: true`,
+			},
+			ok: true,
+		},
 		{
 			name: "no matching serial",
 			fs: &fs{
@@ -75,6 +98,31 @@ func Test_fs_openSerial(t *testing.T) {
 			},
 			ok: true,
 		},
+		{
+			name: "OK read timeout",
+			fs: &fs{
+				openPort: func(cfg *serial.Config) (io.ReadWriteCloser, error) {
+					if cfg.ReadTimeout != 100*time.Millisecond {
+						panicf("unexpected read timeout: %s", cfg.ReadTimeout)
+					}
+
+					return nil, nil
+				},
+			},
+			raw: &rawDevice{
+				Name:        "foo",
+				Device:      "/dev/ttyUSB0",
+				Baud:        115200,
+				ReadTimeout: duration{100 * time.Millisecond},
+			},
+			want: &serialDevice{
+				name:    "foo",
+				device:  "/dev/ttyUSB0",
+				baud:    115200,
+				timeout: true,
+			},
+			ok: true,
+		},
 		{
 			name: "OK devices USB serial",
 			fs:   testFS(),
@@ -107,6 +155,47 @@ func Test_fs_openSerial(t *testing.T) {
 			},
 			ok: true,
 		},
+		{
+			name: "OK devices USB path",
+			fs:   testFS(),
+			raw: &rawDevice{
+				Name:    "foo",
+				USBPath: "1-1",
+				Baud:    115200,
+			},
+			want: &serialDevice{
+				name:   "foo",
+				device: "/dev/ttyUSB0",
+				baud:   115200,
+			},
+			ok: true,
+		},
+		{
+			name: "OK listed ports serial",
+			fs: &fs{
+				listPorts: func() ([]enumeratedDevice, error) {
+					return []enumeratedDevice{
+						{device: "COM3", serial: "A64NMAJS", description: "USB Serial Port (COM3)"},
+						{device: "COM1"},
+					}, nil
+				},
+				openPort: func(_ *serial.Config) (io.ReadWriteCloser, error) {
+					return nil, nil
+				},
+			},
+			raw: &rawDevice{
+				Name:   "baz",
+				Serial: "A64NMAJS",
+				Baud:   115200,
+			},
+			want: &serialDevice{
+				name:   "baz",
+				device: "COM3",
+				serial: "A64NMAJS",
+				baud:   115200,
+			},
+			ok: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -130,7 +219,111 @@ func Test_fs_openSerial(t *testing.T) {
 	}
 }
 
-func devicesEqual(x, y device) bool {
+func Test_serialDeviceReadTimeout(t *testing.T) {
+	d := &serialDevice{
+		rwc: struct {
+			io.Reader
+			io.WriteCloser
+		}{Reader: strings.NewReader("")},
+		timeout: true,
+		reads:   func(_ float64, _ ...string) {},
+	}
+
+	// An expired read timeout produces an empty read rather than EOF.
+	n, err := d.Read(make([]byte, 8))
+	if n != 0 || err != nil {
+		t.Fatalf("unexpected read: %d, %v", n, err)
+	}
+}
+
+func Test_fs_duplicateSerials(t *testing.T) {
+	fs := &fs{
+		listPorts: func() ([]enumeratedDevice, error) {
+			return []enumeratedDevice{
+				{device: "COM3", serial: "A50285BI"},
+				{device: "COM4", serial: "A50285BI"},
+				{device: "COM5", serial: "A64NMAJS"},
+			}, nil
+		},
+		openPort: func(_ *serial.Config) (io.ReadWriteCloser, error) {
+			return nil, nil
+		},
+	}
+
+	var out lockedBuffer
+	if err := fs.init(log.New(&out, "", 0)); err != nil {
+		t.Fatalf("failed to init fs: %v", err)
+	}
+
+	if !strings.Contains(out.String(), `WARNING: devices "COM3", "COM4" report identical serial "A50285BI"`) {
+		t.Fatalf("expected a duplicate serial warning, but got:\n%s", out.String())
+	}
+
+	// The duplicated serial must never silently open either device, but
+	// unique serials still work.
+	_, err := fs.openSerial(&rawDevice{Name: "foo", Serial: "A50285BI", Baud: 115200}, nil, nil)
+	if err == nil || errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected a duplicate serial error, but got: %v", err)
+	}
+
+	if _, err := fs.openSerial(&rawDevice{Name: "bar", Serial: "A64NMAJS", Baud: 115200}, nil, nil); err != nil {
+		t.Fatalf("failed to open unique serial: %v", err)
+	}
+}
+
+func Test_fs_maskSerials(t *testing.T) {
+	fs := &fs{
+		listPorts: func() ([]enumeratedDevice, error) {
+			return []enumeratedDevice{
+				{device: "COM3", serial: "A50285BI"},
+				{device: "COM4", serial: "A50285BI"},
+			}, nil
+		},
+		maskSerials: true,
+	}
+
+	var out lockedBuffer
+	if err := fs.init(log.New(&out, "", 0)); err != nil {
+		t.Fatalf("failed to init fs: %v", err)
+	}
+
+	masked := maskSerial("A50285BI")
+	if strings.Contains(out.String(), "A50285BI") || !strings.Contains(out.String(), masked) {
+		t.Fatalf("expected only masked serial %q in logs, but got:\n%s", masked, out.String())
+	}
+
+	// The serial numbers remain available for the provisioning API.
+	if diff := cmp.Diff("A50285BI", fs.adapters[0].serial); diff != "" {
+		t.Fatalf("unexpected adapter serial (-want +got):\n%s", diff)
+	}
+}
+
+func Test_fs_initMetadata(t *testing.T) {
+	fs := testFS()
+	if err := fs.init(log.New(io.Discard, "", 0)); err != nil {
+		t.Fatalf("failed to init fs: %v", err)
+	}
+
+	want := map[string]map[string]string{
+		"/dev/ttyUSB0": {
+			"vendor":     "FTDI",
+			"product":    "FT232R USB UART",
+			"usb_id":     "0403:6001",
+			"usb_path":   "1-1",
+			"driver":     "ftdi_sio",
+			"sysfs_path": "/sys/devices/pci0000:00/0000:00:14.0/usb1/1-1/1-1:1.0/ttyUSB0",
+		},
+		"/dev/ttyACM0": {
+			"driver": "cdc_acm",
+		},
+	}
+
+	if diff := cmp.Diff(want, fs.metadata); diff != "" {
+		t.Fatalf("unexpected metadata (-want +got):\n%s", diff)
+	}
+}
+
+func devicesEqual(x, y consrv.Device) bool {
 	if x == nil || y == nil {
 		return false
 	}
@@ -154,6 +347,14 @@ func testFS() *fs {
 			switch file {
 			case "/sys/class/tty/ttyUSB0/device/../../serial":
 				return []byte("1111"), nil
+			case "/sys/class/tty/ttyUSB0/device/../../manufacturer":
+				return []byte("FTDI\n"), nil
+			case "/sys/class/tty/ttyUSB0/device/../../product":
+				return []byte("FT232R USB UART\n"), nil
+			case "/sys/class/tty/ttyUSB0/device/../../idVendor":
+				return []byte("0403\n"), nil
+			case "/sys/class/tty/ttyUSB0/device/../../idProduct":
+				return []byte("6001\n"), nil
 			case "/sys/class/tty/ttyUSB1/device/../../serial":
 				// Pretend this device doesn't have a serial number.
 				return nil, os.ErrNotExist
@@ -163,6 +364,20 @@ func testFS() *fs {
 				return nil, fmt.Errorf("readFile: unhandled file: %q", file)
 			}
 		},
+		readLink: func(file string) (string, error) {
+			switch file {
+			case "/sys/class/tty/ttyUSB0/device/../../":
+				return "/sys/devices/pci0000:00/0000:00:14.0/usb1/1-1", nil
+			case "/sys/class/tty/ttyUSB0/device":
+				return "/sys/devices/pci0000:00/0000:00:14.0/usb1/1-1/1-1:1.0/ttyUSB0", nil
+			case "/sys/class/tty/ttyUSB0/device/driver":
+				return "/sys/bus/usb-serial/drivers/ftdi_sio", nil
+			case "/sys/class/tty/ttyACM0/device/driver":
+				return "/sys/bus/usb/drivers/cdc_acm", nil
+			default:
+				return "", os.ErrNotExist
+			}
+		},
 		openPort: func(_ *serial.Config) (io.ReadWriteCloser, error) {
 			return nil, nil
 		},
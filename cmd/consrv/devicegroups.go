@@ -0,0 +1,151 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+
+	"github.com/mdlayher/consrv"
+)
+
+// deviceGroups maps the name of each device group to its devices, in
+// configuration order.
+func deviceGroups(devices []rawDevice) map[string][]string {
+	groups := make(map[string][]string)
+	for _, d := range devices {
+		if d.Group != "" {
+			groups[d.Group] = append(groups[d.Group], d.Name)
+		}
+	}
+
+	return groups
+}
+
+// newPowerCycle returns a function which runs the power_cycle command of a
+// device, or nil if no device has a power_cycle command.
+func newPowerCycle(devices []rawDevice) func(ctx context.Context, device string) error {
+	commands := make(map[string][]string)
+	for _, d := range devices {
+		if len(d.PowerCycle) > 0 {
+			commands[d.Name] = d.PowerCycle
+		}
+	}
+	if len(commands) == 0 {
+		return nil
+	}
+
+	return func(ctx context.Context, device string) error {
+		args, ok := commands[device]
+		if !ok {
+			return fmt.Errorf("device %q has no power_cycle command", device)
+		}
+
+		cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+		cmd.Env = append(os.Environ(), "CONSRV_DEVICE="+device)
+
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to run command %q: %v: %s", args, err, bytes.TrimSpace(out))
+		}
+
+		return nil
+	}
+}
+
+// A groupsHandler serves the status of a device group as JSON, and power
+// cycles the devices of a group as the admin identity once the request
+// confirms the group's name:
+//
+//	GET /groups/{group}
+//	POST /groups/{group}/power-cycle?confirm=<group>
+type groupsHandler struct {
+	status     func(group string) ([]consrv.DeviceStatus, error)
+	powerCycle func(ctx context.Context, group, identity string) ([]consrv.PowerCycleResult, error)
+}
+
+// A jsonDeviceStatus is the JSON representation of a consrv.DeviceStatus.
+type jsonDeviceStatus struct {
+	Device       string `json:"device"`
+	State        string `json:"state"`
+	Sessions     int    `json:"sessions"`
+	Holder       string `json:"holder,omitempty"`
+	HolderSource string `json:"holder_source,omitempty"`
+}
+
+// A jsonPowerCycleResult is the JSON representation of a
+// consrv.PowerCycleResult.
+type jsonPowerCycleResult struct {
+	Device string `json:"device"`
+	Error  string `json:"error,omitempty"`
+}
+
+// ServeHTTP implements http.Handler.
+func (gh *groupsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	group := r.PathValue("group")
+	if r.Method != http.MethodPost {
+		ss, err := gh.status(group)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		js := make([]jsonDeviceStatus, 0, len(ss))
+		for _, s := range ss {
+			js = append(js, jsonDeviceStatus{
+				Device:       s.Device,
+				State:        s.State,
+				Sessions:     s.Sessions,
+				Holder:       s.Holder.Identity,
+				HolderSource: s.Holder.Source,
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(js)
+		return
+	}
+
+	if r.URL.Query().Get("confirm") != group {
+		http.Error(w, "the group name must be confirmed", http.StatusBadRequest)
+		return
+	}
+
+	rs, err := gh.powerCycle(r.Context(), group, adminIdentity)
+	switch {
+	case errors.Is(err, consrv.ErrUnknownGroup):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	js := make([]jsonPowerCycleResult, 0, len(rs))
+	for _, res := range rs {
+		jr := jsonPowerCycleResult{Device: res.Device}
+		if res.Err != nil {
+			jr.Error = res.Err.Error()
+		}
+		js = append(js, jr)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(js)
+}
@@ -0,0 +1,187 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mdlayher/consrv"
+)
+
+func Test_deviceGroups(t *testing.T) {
+	got := deviceGroups([]rawDevice{
+		{Name: "node01", Group: "rack1"},
+		{Name: "server"},
+		{Name: "node02", Group: "rack1"},
+		{Name: "switch", Group: "rack2"},
+	})
+
+	want := map[string][]string{
+		"rack1": {"node01", "node02"},
+		"rack2": {"switch"},
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("unexpected groups (-want +got):\n%s", diff)
+	}
+}
+
+func Test_newPowerCycle(t *testing.T) {
+	if pc := newPowerCycle([]rawDevice{{Name: "server"}}); pc != nil {
+		t.Fatal("expected no power cycle function without power_cycle commands")
+	}
+
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skipf("skipping, no shell: %v", err)
+	}
+
+	out := filepath.Join(t.TempDir(), "out")
+	pc := newPowerCycle([]rawDevice{
+		{Name: "node01", PowerCycle: []string{"sh", "-c", `printf '%s' "$CONSRV_DEVICE" > "$0"`, out}},
+		{Name: "node02", PowerCycle: []string{"sh", "-c", "echo outlet offline; exit 1"}},
+		{Name: "server"},
+	})
+
+	if err := pc(context.Background(), "node01"); err != nil {
+		t.Fatalf("failed to power cycle: %v", err)
+	}
+
+	b, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("failed to read output: %v", err)
+	}
+	if diff := cmp.Diff("node01", string(b)); diff != "" {
+		t.Fatalf("unexpected output (-want +got):\n%s", diff)
+	}
+
+	if err := pc(context.Background(), "node02"); err == nil || !strings.HasSuffix(err.Error(), ": outlet offline") {
+		t.Fatalf("expected command failure with output, but got: %v", err)
+	}
+	if err := pc(context.Background(), "server"); err == nil {
+		t.Fatal("expected an error for a device without a power_cycle command")
+	}
+}
+
+func Test_groupsHandler(t *testing.T) {
+	var cycled string
+	gh := &groupsHandler{
+		status: func(group string) ([]consrv.DeviceStatus, error) {
+			if group != "rack1" {
+				return nil, fmt.Errorf("%w %q", consrv.ErrUnknownGroup, group)
+			}
+
+			return []consrv.DeviceStatus{
+				{
+					Device:   "node01",
+					State:    consrv.StateOK,
+					Sessions: 1,
+					Holder:   consrv.Holder{Identity: "alice", Source: consrv.HolderSession},
+					Held:     true,
+				},
+				{
+					Device: "node02",
+					State:  consrv.StateFailed,
+				},
+			}, nil
+		},
+		powerCycle: func(_ context.Context, group, identity string) ([]consrv.PowerCycleResult, error) {
+			if group != "rack1" {
+				return nil, fmt.Errorf("%w %q", consrv.ErrUnknownGroup, group)
+			}
+
+			cycled = group + "/" + identity
+			return []consrv.PowerCycleResult{
+				{Device: "node01"},
+				{Device: "node02", Err: errors.New("outlet offline")},
+			}, nil
+		},
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /groups/{group}", gh)
+	mux.Handle("POST /groups/{group}/power-cycle", gh)
+
+	tests := []struct {
+		name, method, target string
+		code                 int
+		body                 string
+	}{
+		{
+			name:   "status",
+			method: http.MethodGet,
+			target: "/groups/rack1",
+			code:   http.StatusOK,
+			body: `[{"device":"node01","state":"ok","sessions":1,"holder":"alice","holder_source":"session"},` +
+				`{"device":"node02","state":"failed","sessions":0}]` + "\n",
+		},
+		{
+			name:   "unknown group",
+			method: http.MethodGet,
+			target: "/groups/rack2",
+			code:   http.StatusNotFound,
+			body:   "unknown group \"rack2\"\n",
+		},
+		{
+			name:   "not confirmed",
+			method: http.MethodPost,
+			target: "/groups/rack1/power-cycle?confirm=rack2",
+			code:   http.StatusBadRequest,
+			body:   "the group name must be confirmed\n",
+		},
+		{
+			name:   "power cycle unknown group",
+			method: http.MethodPost,
+			target: "/groups/rack2/power-cycle?confirm=rack2",
+			code:   http.StatusNotFound,
+			body:   "unknown group \"rack2\"\n",
+		},
+		{
+			name:   "power cycle",
+			method: http.MethodPost,
+			target: "/groups/rack1/power-cycle?identity=alice&confirm=rack1",
+			code:   http.StatusOK,
+			body:   `[{"device":"node01"},{"device":"node02","error":"outlet offline"}]` + "\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest(tt.method, tt.target, nil))
+
+			if diff := cmp.Diff(tt.code, w.Code); diff != "" {
+				t.Fatalf("unexpected status code (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tt.body, w.Body.String()); diff != "" {
+				t.Fatalf("unexpected body (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	// The identity parameter is ignored, so requests can't impersonate a
+	// configured identity.
+	if diff := cmp.Diff("rack1/"+adminIdentity, cycled); diff != "" {
+		t.Fatalf("unexpected power cycle (-want +got):\n%s", diff)
+	}
+}
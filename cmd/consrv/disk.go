@@ -0,0 +1,184 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/mdlayher/metricslite"
+)
+
+// diskConfig contains the configuration for watching the free space of the
+// log directory's volume.
+type diskConfig struct {
+	Interval duration `toml:"interval"`
+	LowFree  int64    `toml:"low_free"`
+	MinFree  int64    `toml:"min_free"`
+	LowRate  int64    `toml:"low_rate"`
+	Webhook  string   `toml:"webhook"`
+}
+
+const (
+	// defaultDiskInterval is the default interval at which free space is
+	// checked.
+	defaultDiskInterval = 30 * time.Second
+
+	// defaultLowRate is the default number of bytes per second logged for
+	// each device while free space is low.
+	defaultLowRate = 1024
+)
+
+// validate verifies the disk configuration and sets defaults.
+func (dc *diskConfig) validate() error {
+	switch {
+	case dc.Interval.Duration < 0:
+		return errors.New("log disk watchdog must not have a negative interval")
+	case dc.LowFree < 0 || dc.MinFree < 0 || dc.LowRate < 0:
+		return errors.New("log disk watchdog must not have negative sizes")
+	case dc.LowFree == 0 && dc.MinFree == 0:
+		return errors.New("log disk watchdog must have low_free or min_free set")
+	case dc.LowFree > 0 && dc.LowFree <= dc.MinFree:
+		return errors.New("log disk watchdog low_free must be greater than min_free")
+	}
+
+	if dc.Interval.Duration == 0 {
+		dc.Interval.Duration = defaultDiskInterval
+	}
+	if dc.LowRate == 0 {
+		dc.LowRate = defaultLowRate
+	}
+
+	return nil
+}
+
+// States of a diskWatchdog.
+const (
+	diskOK   = "ok"
+	diskLow  = "low"
+	diskFull = "full"
+)
+
+// diskStates are all of the states of a diskWatchdog.
+var diskStates = []string{diskOK, diskLow, diskFull}
+
+// A diskWatchdog watches the free space of the log directory's volume, and
+// downsamples logging when space is low and pauses logging when the volume is
+// nearly full, rather than letting writes fail once it is full.
+type diskWatchdog struct {
+	dir   string
+	cfg   diskConfig
+	files []*logFile
+	free  func(dir string) (uint64, error)
+	post  func(ctx context.Context, url string, v any) error
+	ll    *log.Logger
+
+	freeBytes metricslite.Gauge
+	states    metricslite.Gauge
+
+	state string
+}
+
+// newDiskWatchdog creates a diskWatchdog for the log files in dir.
+func newDiskWatchdog(dir string, cfg diskConfig, files []*logFile, mm *metrics, ll *log.Logger) *diskWatchdog {
+	return &diskWatchdog{
+		dir:       dir,
+		cfg:       cfg,
+		files:     files,
+		free:      diskFree,
+		post:      postWebhook,
+		ll:        ll,
+		freeBytes: mm.logDiskFreeBytes,
+		states:    mm.logDiskState,
+		state:     diskOK,
+	}
+}
+
+// diskAlert is the JSON object posted to the webhook when the state of a
+// diskWatchdog changes.
+type diskAlert struct {
+	Directory string `json:"directory"`
+	State     string `json:"state"`
+	FreeBytes uint64 `json:"free_bytes"`
+}
+
+// run checks the free space at each interval until the process exits.
+func (dw *diskWatchdog) run() {
+	t := time.NewTicker(dw.cfg.Interval.Duration)
+	defer t.Stop()
+
+	for {
+		dw.check()
+		<-t.C
+	}
+}
+
+// check checks the free space and updates the log files if the state changes.
+func (dw *diskWatchdog) check() {
+	free, err := dw.free(dw.dir)
+	if err != nil {
+		errorf(dw.ll, "failed to check free space of log directory %q: %v", dw.dir, err)
+		return
+	}
+	dw.freeBytes(float64(free))
+
+	state := diskOK
+	switch {
+	case dw.cfg.MinFree > 0 && free < uint64(dw.cfg.MinFree):
+		state = diskFull
+	case dw.cfg.LowFree > 0 && free < uint64(dw.cfg.LowFree):
+		state = diskLow
+	}
+	for _, s := range diskStates {
+		var v float64
+		if s == state {
+			v = 1
+		}
+		dw.states(v, s)
+	}
+
+	if state == dw.state {
+		return
+	}
+	dw.state = state
+
+	switch state {
+	case diskOK:
+		dw.ll.Printf("log directory %q has %d bytes free, resuming logging", dw.dir, free)
+	case diskLow:
+		dw.ll.Printf("WARNING: log directory %q has %d bytes free, downsampling logging to %d bytes per second per device",
+			dw.dir, free, dw.cfg.LowRate)
+	case diskFull:
+		dw.ll.Printf("WARNING: log directory %q has %d bytes free, pausing logging", dw.dir, free)
+	}
+
+	for _, lf := range dw.files {
+		lf.setDisk(state, dw.cfg.LowRate)
+	}
+
+	if dw.cfg.Webhook != "" {
+		go func() {
+			err := dw.post(context.Background(), dw.cfg.Webhook, diskAlert{
+				Directory: dw.dir,
+				State:     state,
+				FreeBytes: free,
+			})
+			if err != nil {
+				errorf(dw.ll, "failed to post log disk alert: %v", err)
+			}
+		}()
+	}
+}
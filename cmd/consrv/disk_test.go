@@ -0,0 +1,174 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_diskConfigValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		dc   diskConfig
+		want diskConfig
+		ok   bool
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name: "negative interval",
+			dc:   diskConfig{Interval: duration{-time.Second}, MinFree: 1},
+		},
+		{
+			name: "negative size",
+			dc:   diskConfig{MinFree: -1},
+		},
+		{
+			name: "low below minimum",
+			dc:   diskConfig{LowFree: 1 << 20, MinFree: 1 << 30},
+		},
+		{
+			name: "OK defaults",
+			dc:   diskConfig{MinFree: 1 << 20},
+			want: diskConfig{
+				Interval: duration{defaultDiskInterval},
+				MinFree:  1 << 20,
+				LowRate:  defaultLowRate,
+			},
+			ok: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.dc.validate()
+			if tt.ok && err != nil {
+				t.Fatalf("failed to validate: %v", err)
+			}
+			if !tt.ok {
+				if err == nil {
+					t.Fatal("expected an error, but none occurred")
+				}
+				return
+			}
+
+			if diff := cmp.Diff(tt.want, tt.dc); diff != "" {
+				t.Fatalf("unexpected config (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_diskWatchdog(t *testing.T) {
+	dir := t.TempDir()
+	lf, now := testLogFile(t, dir, nil, new(float64))
+
+	var (
+		free   uint64
+		states []string
+		alerts []diskAlert
+	)
+	dw := &diskWatchdog{
+		dir: dir,
+		cfg: diskConfig{
+			LowFree: 1000,
+			MinFree: 100,
+			LowRate: 8,
+			Webhook: "http://127.0.0.1/alert",
+		},
+		files:     []*logFile{lf},
+		free:      func(string) (uint64, error) { return free, nil },
+		ll:        log.New(io.Discard, "", 0),
+		freeBytes: func(float64, ...string) {},
+		states: func(v float64, labels ...string) {
+			if v == 1 {
+				states = append(states, labels[0])
+			}
+		},
+		state: diskOK,
+	}
+
+	alertC := make(chan diskAlert)
+	dw.post = func(_ context.Context, _ string, v any) error {
+		alertC <- v.(diskAlert)
+		return nil
+	}
+
+	// check checks free space f, and waits for an alert if the state changes.
+	check := func(f uint64, changed bool) {
+		t.Helper()
+
+		free = f
+		dw.check()
+		if changed {
+			alerts = append(alerts, <-alertC)
+		}
+	}
+
+	check(10000, false)
+	write(t, lf, "one\n")
+
+	// While space is low, at most 8 bytes are logged per second.
+	check(500, true)
+	write(t, lf, "two\n")
+	write(t, lf, "three\n")
+	*now = now.Add(time.Second)
+	write(t, lf, "four\n")
+
+	// Nothing is logged once the volume is nearly full.
+	check(50, true)
+	write(t, lf, "five\n")
+
+	check(5000, true)
+	write(t, lf, "six\n")
+
+	b, err := os.ReadFile(filepath.Join(dir, "server.log"))
+	if err != nil {
+		t.Fatalf("failed to read log: %v", err)
+	}
+
+	want := `one
+consrv: logging downsampled, log volume is low on space
+two
+four
+consrv: logging paused, log volume is full
+consrv: logging resumed, log volume has free space
+six
+`
+	if diff := cmp.Diff(want, string(b)); diff != "" {
+		t.Fatalf("unexpected log (-want +got):\n%s", diff)
+	}
+
+	if diff := cmp.Diff([]string{"ok", "low", "full", "ok"}, states); diff != "" {
+		t.Fatalf("unexpected states (-want +got):\n%s", diff)
+	}
+
+	wantAlerts := []diskAlert{
+		{Directory: dir, State: "low", FreeBytes: 500},
+		{Directory: dir, State: "full", FreeBytes: 50},
+		{Directory: dir, State: "ok", FreeBytes: 5000},
+	}
+	if diff := cmp.Diff(wantAlerts, alerts); diff != "" {
+		t.Fatalf("unexpected alerts (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,191 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/mdlayher/consrv"
+)
+
+// A charset is a single byte character encoding whose lower half is ASCII.
+type charset struct {
+	upper    [128]rune
+	fromRune map[rune]byte
+}
+
+// newCharset creates a charset from the runes for bytes 0x80 through 0xff.
+func newCharset(upper string) *charset {
+	cs := &charset{fromRune: make(map[rune]byte, 128)}
+	var i int
+	for _, r := range upper {
+		cs.upper[i] = r
+		cs.fromRune[r] = byte(0x80 + i)
+		i++
+	}
+	if i != len(cs.upper) {
+		panic(fmt.Sprintf("consrv: charset has %d upper runes", i))
+	}
+
+	return cs
+}
+
+// charsets are the supported device encodings. Control characters are passed
+// through unchanged rather than mapped to their CP437 glyphs, since they are
+// interpreted by terminals.
+var charsets = map[string]*charset{
+	"cp437": newCharset("" +
+		"ÇüéâäàåçêëèïîìÄÅ" +
+		"ÉæÆôöòûùÿÖÜ¢£¥₧ƒ" +
+		"áíóúñÑªº¿⌐¬½¼¡«»" +
+		"░▒▓│┤╡╢╖╕╣║╗╝╜╛┐" +
+		"└┴┬├─┼╞╟╚╔╩╦╠═╬╧" +
+		"╨╤╥╙╘╒╓╫╪┘┌█▄▌▐▀" +
+		"αßΓπΣσµτΦΘΩδ∞φε∩" +
+		"≡±≥≤⌠⌡÷≈°∙·√ⁿ²■\u00a0"),
+	"latin1": func() *charset {
+		var s []rune
+		for r := rune(0x80); r <= 0xff; r++ {
+			s = append(s, r)
+		}
+		return newCharset(string(s))
+	}(),
+}
+
+// decode appends the UTF-8 encoding of b to dst.
+func (cs *charset) decode(dst, b []byte) []byte {
+	for _, c := range b {
+		if c < utf8.RuneSelf {
+			dst = append(dst, c)
+			continue
+		}
+
+		dst = utf8.AppendRune(dst, cs.upper[c-0x80])
+	}
+
+	return dst
+}
+
+// encode appends the encoding of the UTF-8 text b to dst, replacing runes
+// which cannot be encoded with '?'.
+func (cs *charset) encode(dst, b []byte) []byte {
+	for len(b) > 0 {
+		r, n := utf8.DecodeRune(b)
+		b = b[n:]
+
+		switch c, ok := cs.fromRune[r]; {
+		case r < utf8.RuneSelf:
+			dst = append(dst, byte(r))
+		case ok:
+			dst = append(dst, c)
+		default:
+			dst = append(dst, '?')
+		}
+	}
+
+	return dst
+}
+
+var _ consrv.Device = &encodingDevice{}
+
+// An encodingDevice is a consrv.Device which transcodes the output of a
+// device using a legacy character encoding, such as the box drawing
+// characters of a BIOS console, to UTF-8, and transcodes UTF-8 input back to
+// the device's encoding.
+type encodingDevice struct {
+	consrv.Device
+	cs *charset
+
+	// rmu guards the read state. out holds transcoded output which did not
+	// fit in the caller's buffer, and err is returned once out is consumed.
+	rmu     sync.Mutex
+	scratch []byte
+	out     []byte
+	err     error
+
+	// wmu guards partial, the start of a multi-byte UTF-8 sequence which was
+	// split across writes.
+	wmu     sync.Mutex
+	partial []byte
+}
+
+// newEncodingDevice wraps d to transcode its output from the named encoding.
+func newEncodingDevice(d consrv.Device, encoding string) *encodingDevice {
+	return &encodingDevice{
+		Device: d,
+		cs:     charsets[encoding],
+	}
+}
+
+// Read implements io.ReadWriteCloser.
+func (d *encodingDevice) Read(b []byte) (int, error) {
+	d.rmu.Lock()
+	defer d.rmu.Unlock()
+
+	if len(d.out) == 0 {
+		if err := d.err; err != nil {
+			d.err = nil
+			return 0, err
+		}
+
+		if cap(d.scratch) < len(b) {
+			d.scratch = make([]byte, len(b))
+		}
+
+		n, err := d.Device.Read(d.scratch[:len(b)])
+		d.out = d.cs.decode(d.out, d.scratch[:n])
+		if len(d.out) == 0 {
+			return 0, err
+		}
+		d.err = err
+	}
+
+	n := copy(b, d.out)
+	d.out = d.out[:copy(d.out, d.out[n:])]
+	return n, nil
+}
+
+// Write implements io.ReadWriteCloser.
+func (d *encodingDevice) Write(b []byte) (int, error) {
+	d.wmu.Lock()
+	defer d.wmu.Unlock()
+
+	in := append(d.partial, b...)
+	d.partial = nil
+
+	// Hold back an incomplete rune at the end of the input until the rest of
+	// it is written.
+	for i := max(0, len(in)-utf8.UTFMax+1); i < len(in); i++ {
+		if utf8.RuneStart(in[i]) && !utf8.FullRune(in[i:]) {
+			d.partial = append([]byte(nil), in[i:]...)
+			in = in[:i]
+			break
+		}
+	}
+
+	if _, err := d.Device.Write(d.cs.encode(nil, in)); err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
+
+// connected implements connector for devices which reconnect, and otherwise
+// reports that the device is connected.
+func (d *encodingDevice) connected() bool {
+	c, ok := d.Device.(connector)
+	return !ok || c.connected()
+}
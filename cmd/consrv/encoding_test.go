@@ -0,0 +1,122 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_charset(t *testing.T) {
+	tests := []struct {
+		name     string
+		encoding string
+		device   string
+		utf8     string
+	}{
+		{
+			name:     "cp437 box drawing",
+			encoding: "cp437",
+			device:   "\xc9\xcd\xbb\r\n\xba \xba\r\n\xc8\xcd\xbc",
+			utf8:     "╔═╗\r\n║ ║\r\n╚═╝",
+		},
+		{
+			name:     "cp437 text",
+			encoding: "cp437",
+			device:   "\x1b[1mna\xa4o 25\xf8C\xff",
+			utf8:     "\x1b[1mnaño 25°C\u00a0",
+		},
+		{
+			name:     "latin1",
+			encoding: "latin1",
+			device:   "Gr\xfc\xdfe \xa9",
+			utf8:     "Grüße ©",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cs := charsets[tt.encoding]
+
+			if diff := cmp.Diff(tt.utf8, string(cs.decode(nil, []byte(tt.device)))); diff != "" {
+				t.Fatalf("unexpected decoded output (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tt.device, string(cs.encode(nil, []byte(tt.utf8)))); diff != "" {
+				t.Fatalf("unexpected encoded input (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_charsetEncodeUnknown(t *testing.T) {
+	got := charsets["latin1"].encode(nil, []byte("╔ok☃\xff"))
+	if diff := cmp.Diff("?ok??", string(got)); diff != "" {
+		t.Fatalf("unexpected encoded input (-want +got):\n%s", diff)
+	}
+}
+
+func Test_encodingDevice(t *testing.T) {
+	var w bytes.Buffer
+	d := newEncodingDevice(&bufferDevice{
+		Reader: bytes.NewReader([]byte("\xc9\xcd\xbb")),
+		Writer: &w,
+	}, "cp437")
+
+	// Each byte of output expands to three bytes of UTF-8, so it must be read
+	// in pieces when the caller's buffer is small.
+	var out []byte
+	b := make([]byte, 2)
+	for {
+		n, err := d.Read(b)
+		out = append(out, b[:n]...)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("failed to read: %v", err)
+		}
+	}
+
+	if diff := cmp.Diff("╔═╗", string(out)); diff != "" {
+		t.Fatalf("unexpected output (-want +got):\n%s", diff)
+	}
+
+	// A rune split across writes is encoded once it is complete.
+	in := []byte("a═b")
+	for _, p := range [][]byte{in[:2], in[2:3], in[3:]} {
+		n, err := d.Write(p)
+		if err != nil {
+			t.Fatalf("failed to write: %v", err)
+		}
+		if diff := cmp.Diff(len(p), n); diff != "" {
+			t.Fatalf("unexpected write length (-want +got):\n%s", diff)
+		}
+	}
+
+	if diff := cmp.Diff("a\xcdb", w.String()); diff != "" {
+		t.Fatalf("unexpected input (-want +got):\n%s", diff)
+	}
+}
+
+// A bufferDevice is a consrv.Device which reads and writes in memory.
+type bufferDevice struct {
+	io.Reader
+	io.Writer
+}
+
+func (*bufferDevice) Close() error   { return nil }
+func (*bufferDevice) String() string { return "buffer" }
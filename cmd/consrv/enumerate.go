@@ -0,0 +1,156 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"cmp"
+	"errors"
+	"log"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/mdlayher/metricslite"
+)
+
+// enumerationConfig contains the configuration for periodically enumerating
+// serial adapters again to detect changes to the adapter mapping.
+type enumerationConfig struct {
+	Interval duration `toml:"interval"`
+}
+
+// defaultEnumerationInterval is the default interval at which serial adapters
+// are enumerated again.
+const defaultEnumerationInterval = time.Minute
+
+// validate verifies the enumeration configuration and sets defaults.
+func (ec *enumerationConfig) validate() error {
+	if ec.Interval.Duration < 0 {
+		return errors.New("enumeration must not have a negative interval")
+	}
+
+	if ec.Interval.Duration == 0 {
+		ec.Interval.Duration = defaultEnumerationInterval
+	}
+
+	return nil
+}
+
+// Kinds of adapterChange.
+const (
+	adapterAdded   = "added"
+	adapterRemoved = "removed"
+	adapterChanged = "changed"
+)
+
+// An adapterChange is a difference between two enumerations of a device path.
+type adapterChange struct {
+	Kind, Device, Serial, PrevSerial string
+}
+
+// diffAdapters returns the changes from the enumerated devices prev to next,
+// sorted by device path.
+func diffAdapters(prev, next []enumeratedDevice) []adapterChange {
+	serials := func(eds []enumeratedDevice) map[string]string {
+		m := make(map[string]string, len(eds))
+		for _, ed := range eds {
+			m[ed.device] = ed.serial
+		}
+		return m
+	}
+
+	ps, ns := serials(prev), serials(next)
+
+	var changes []adapterChange
+	for dev, serial := range ns {
+		switch prev, ok := ps[dev]; {
+		case !ok:
+			changes = append(changes, adapterChange{Kind: adapterAdded, Device: dev, Serial: serial})
+		case prev != serial:
+			changes = append(changes, adapterChange{Kind: adapterChanged, Device: dev, Serial: serial, PrevSerial: prev})
+		}
+	}
+	for _, dev := range slices.Sorted(maps.Keys(ps)) {
+		if _, ok := ns[dev]; !ok {
+			changes = append(changes, adapterChange{Kind: adapterRemoved, Device: dev, PrevSerial: ps[dev]})
+		}
+	}
+
+	slices.SortFunc(changes, func(a, b adapterChange) int { return cmp.Compare(a.Device, b.Device) })
+	return changes
+}
+
+// A driftDetector periodically enumerates serial adapters again, updating the
+// lookup tables of a fs and reporting changes so that operators notice when
+// the adapter mapping has changed before a session fails.
+type driftDetector struct {
+	fs       *fs
+	interval time.Duration
+	ll       *log.Logger
+
+	// initial is the enumeration at startup, against which drift is measured.
+	initial []enumeratedDevice
+
+	changes metricslite.Counter
+	drift   metricslite.Gauge
+}
+
+// newDriftDetector creates a driftDetector for fs.
+func newDriftDetector(fs *fs, ec enumerationConfig, mm *metrics, ll *log.Logger) *driftDetector {
+	return &driftDetector{
+		fs:       fs,
+		interval: ec.Interval.Duration,
+		ll:       ll,
+		initial:  fs.enumerated(),
+		changes:  mm.adapterChanges,
+		drift:    mm.adapterDrift,
+	}
+}
+
+// run enumerates adapters at each interval until the process exits.
+func (dd *driftDetector) run() {
+	t := time.NewTicker(dd.interval)
+	defer t.Stop()
+
+	dd.drift(0)
+	for range t.C {
+		dd.check()
+	}
+}
+
+// check enumerates adapters, logs the changes since the previous enumeration,
+// and updates the drift since startup.
+func (dd *driftDetector) check() {
+	eds, err := dd.fs.enumerate()
+	if err != nil {
+		errorf(dd.ll, "failed to enumerate devices: %v", err)
+		return
+	}
+
+	prev := dd.fs.update(eds, dd.ll)
+	for _, c := range diffAdapters(prev, eds) {
+		switch c.Kind {
+		case adapterAdded:
+			dd.ll.Printf("enumeration: device %q added with serial %q", c.Device, dd.fs.serial(c.Serial))
+		case adapterRemoved:
+			dd.ll.Printf("enumeration: device %q with serial %q removed", c.Device, dd.fs.serial(c.PrevSerial))
+		case adapterChanged:
+			dd.ll.Printf("enumeration: device %q serial changed from %q to %q",
+				c.Device, dd.fs.serial(c.PrevSerial), dd.fs.serial(c.Serial))
+		}
+		dd.changes(1.0, c.Kind)
+	}
+
+	dd.drift(float64(len(diffAdapters(dd.initial, eds))))
+}
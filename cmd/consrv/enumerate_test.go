@@ -0,0 +1,156 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_enumerationConfigValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		ec   enumerationConfig
+		want enumerationConfig
+		ok   bool
+	}{
+		{
+			name: "negative interval",
+			ec:   enumerationConfig{Interval: duration{-time.Second}},
+		},
+		{
+			name: "OK defaults",
+			want: enumerationConfig{Interval: duration{defaultEnumerationInterval}},
+			ok:   true,
+		},
+		{
+			name: "OK",
+			ec:   enumerationConfig{Interval: duration{10 * time.Second}},
+			want: enumerationConfig{Interval: duration{10 * time.Second}},
+			ok:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.ec.validate()
+			if tt.ok && err != nil {
+				t.Fatalf("failed to validate: %v", err)
+			}
+			if !tt.ok {
+				if err == nil {
+					t.Fatal("expected an error, but none occurred")
+				}
+				return
+			}
+
+			if diff := cmp.Diff(tt.want, tt.ec); diff != "" {
+				t.Fatalf("unexpected config (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_diffAdapters(t *testing.T) {
+	prev := []enumeratedDevice{
+		{device: "/dev/ttyUSB0", serial: "1111"},
+		{device: "/dev/ttyUSB1", serial: "2222"},
+		{device: "/dev/ttyUSB2", serial: "3333"},
+	}
+
+	next := []enumeratedDevice{
+		{device: "/dev/ttyUSB0", serial: "1111"},
+		{device: "/dev/ttyUSB1", serial: "3333"},
+		{device: "/dev/ttyUSB3", serial: "4444"},
+	}
+
+	want := []adapterChange{
+		{Kind: adapterChanged, Device: "/dev/ttyUSB1", Serial: "3333", PrevSerial: "2222"},
+		{Kind: adapterRemoved, Device: "/dev/ttyUSB2", PrevSerial: "3333"},
+		{Kind: adapterAdded, Device: "/dev/ttyUSB3", Serial: "4444"},
+	}
+
+	if diff := cmp.Diff(want, diffAdapters(prev, next)); diff != "" {
+		t.Fatalf("unexpected changes (-want +got):\n%s", diff)
+	}
+
+	if diff := cmp.Diff([]adapterChange(nil), diffAdapters(next, next)); diff != "" {
+		t.Fatalf("unexpected changes for identical enumerations (-want +got):\n%s", diff)
+	}
+}
+
+func Test_driftDetector(t *testing.T) {
+	eds := []enumeratedDevice{
+		{device: "/dev/ttyUSB0", serial: "1111"},
+		{device: "/dev/ttyUSB1", serial: "2222"},
+	}
+
+	fs := &fs{
+		listPorts: func() ([]enumeratedDevice, error) { return eds, nil },
+	}
+	if err := fs.init(log.New(io.Discard, "", 0)); err != nil {
+		t.Fatalf("failed to init fs: %v", err)
+	}
+
+	var (
+		changes []string
+		drift   float64
+		logs    lockedBuffer
+	)
+	dd := newDriftDetector(fs, enumerationConfig{}, newMetrics(nil), log.New(&logs, "", 0))
+	dd.changes = func(_ float64, labels ...string) { changes = append(changes, labels[0]) }
+	dd.drift = func(v float64, _ ...string) { drift = v }
+
+	// The adapters are swapped between ports, and one is unplugged.
+	eds = []enumeratedDevice{{device: "/dev/ttyUSB0", serial: "2222"}}
+	dd.check()
+
+	if diff := cmp.Diff([]string{adapterChanged, adapterRemoved}, changes); diff != "" {
+		t.Fatalf("unexpected changes (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(2.0, drift); diff != "" {
+		t.Fatalf("unexpected drift (-want +got):\n%s", diff)
+	}
+
+	want := `enumeration: device "/dev/ttyUSB0" serial changed from "1111" to "2222"
+enumeration: device "/dev/ttyUSB1" with serial "2222" removed
+`
+	if diff := cmp.Diff(want, logs.String()); diff != "" {
+		t.Fatalf("unexpected logs (-want +got):\n%s", diff)
+	}
+
+	// The serial now resolves to the adapter's new path.
+	d := rawDevice{Name: "server", Serial: "2222"}
+	if err := fs.resolve(&d); err != nil {
+		t.Fatalf("failed to resolve device: %v", err)
+	}
+	if diff := cmp.Diff("/dev/ttyUSB0", d.Device); diff != "" {
+		t.Fatalf("unexpected device path (-want +got):\n%s", diff)
+	}
+
+	// Restoring the original mapping clears the drift.
+	eds = []enumeratedDevice{
+		{device: "/dev/ttyUSB0", serial: "1111"},
+		{device: "/dev/ttyUSB1", serial: "2222"},
+	}
+	dd.check()
+
+	if diff := cmp.Diff(0.0, drift); diff != "" {
+		t.Fatalf("unexpected drift (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/mdlayher/consrv"
+	"github.com/mdlayher/metricslite"
+)
+
+var _ consrv.Device = &errorDevice{}
+
+// An errorDevice is a consrv.Device which tracks consecutive read and write
+// errors and the time of the last successful read, so that alerts can fire
+// when a device has failed for some time.
+type errorDevice struct {
+	consrv.Device
+	name string
+	now  func() time.Time
+
+	// Writes may occur concurrently from multiple sessions.
+	reads, writes atomic.Int64
+
+	readErrors, writeErrors, lastRead metricslite.Gauge
+}
+
+// newErrorDevice wraps d with error tracking using the metrics in mm.
+func newErrorDevice(d consrv.Device, name string, mm *metrics) *errorDevice {
+	ed := &errorDevice{
+		Device:      d,
+		name:        name,
+		now:         time.Now,
+		readErrors:  mm.deviceConsecutiveReadErrors,
+		writeErrors: mm.deviceConsecutiveWriteErrors,
+		lastRead:    mm.deviceLastReadTimestamp,
+	}
+
+	// Initialize each series so alerts can match them before any errors
+	// occur, treating startup as the last successful read.
+	ed.readErrors(0, name)
+	ed.writeErrors(0, name)
+	ed.lastRead(float64(ed.now().Unix()), name)
+
+	return ed
+}
+
+// Read implements io.ReadWriteCloser.
+func (d *errorDevice) Read(b []byte) (int, error) {
+	n, err := d.Device.Read(b)
+	switch {
+	case n > 0:
+		d.reads.Store(0)
+		d.readErrors(0, d.name)
+		d.lastRead(float64(d.now().Unix()), d.name)
+	case err != nil && !errors.Is(err, io.EOF):
+		d.readErrors(float64(d.reads.Add(1)), d.name)
+	}
+
+	return n, err
+}
+
+// Write implements io.ReadWriteCloser.
+func (d *errorDevice) Write(b []byte) (int, error) {
+	n, err := d.Device.Write(b)
+	if err != nil {
+		d.writeErrors(float64(d.writes.Add(1)), d.name)
+	} else if d.writes.Swap(0) != 0 {
+		d.writeErrors(0, d.name)
+	}
+
+	return n, err
+}
+
+// connected implements connector for devices which reconnect, and otherwise
+// reports that the device is connected.
+func (d *errorDevice) connected() bool {
+	c, ok := d.Device.(connector)
+	return !ok || c.connected()
+}
@@ -0,0 +1,101 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mdlayher/metricslite"
+)
+
+func Test_errorDevice(t *testing.T) {
+	mem := metricslite.NewMemory()
+	fd := &flakyDevice{}
+	d := newErrorDevice(fd, "server", newMetrics(mem))
+
+	now := time.Unix(100, 0)
+	d.now = func() time.Time { return now }
+
+	read := func(err error) {
+		fd.err = err
+		now = now.Add(1 * time.Minute)
+		_, _ = d.Read(make([]byte, 1))
+	}
+	write := func(err error) {
+		fd.err = err
+		_, _ = d.Write([]byte{0})
+	}
+
+	errIO := errors.New("input/output error")
+	read(nil)
+	read(errIO)
+	read(errIO)
+	write(errIO)
+	write(nil)
+	write(errIO)
+	write(errIO)
+	write(errIO)
+
+	got := make(map[string]float64)
+	for _, name := range []string{
+		"consrv_device_consecutive_read_errors",
+		"consrv_device_consecutive_write_errors",
+		"consrv_device_last_read_timestamp_seconds",
+	} {
+		got[name] = mem.Series()[name].Samples["name=server"]
+	}
+
+	// The last successful read was the first, one minute after startup.
+	want := map[string]float64{
+		"consrv_device_consecutive_read_errors":     2,
+		"consrv_device_consecutive_write_errors":    3,
+		"consrv_device_last_read_timestamp_seconds": 160,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("unexpected metrics (-want +got):\n%s", diff)
+	}
+
+	read(nil)
+	if got := mem.Series()["consrv_device_consecutive_read_errors"].Samples["name=server"]; got != 0 {
+		t.Fatalf("expected read errors to reset, but got: %v", got)
+	}
+}
+
+// A flakyDevice is a consrv.Device which reads and writes a byte unless err
+// is set.
+type flakyDevice struct {
+	err error
+}
+
+func (d *flakyDevice) Read(b []byte) (int, error) {
+	if d.err != nil {
+		return 0, d.err
+	}
+
+	return copy(b, "x"), nil
+}
+
+func (d *flakyDevice) Write(b []byte) (int, error) {
+	if d.err != nil {
+		return 0, d.err
+	}
+
+	return len(b), nil
+}
+
+func (d *flakyDevice) Close() error   { return nil }
+func (d *flakyDevice) String() string { return "flaky" }
@@ -0,0 +1,178 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/mdlayher/consrv"
+	"github.com/mdlayher/metricslite"
+)
+
+// fanOutConfig contains the configuration for mirroring a device's output to
+// the input of other devices.
+type fanOutConfig struct {
+	Devices []string `toml:"devices"`
+}
+
+// validate verifies the fan-out configuration for device, given the fan-out
+// configurations of all devices by name.
+func (fc *fanOutConfig) validate(device string, fanOuts map[string]*fanOutConfig) error {
+	if len(fc.Devices) == 0 {
+		return fmt.Errorf("device %q fan-out must have devices", device)
+	}
+
+	seen := make(map[string]struct{}, len(fc.Devices))
+	for _, d := range fc.Devices {
+		if _, ok := fanOuts[d]; !ok {
+			return fmt.Errorf("device %q fan-out device %q is not configured", device, d)
+		}
+		if d == device {
+			return fmt.Errorf("device %q fan-out must not include itself", device)
+		}
+		if _, ok := seen[d]; ok {
+			return fmt.Errorf("device %q fan-out device %q is configured more than once", device, d)
+		}
+		seen[d] = struct{}{}
+	}
+
+	// A device which echoes its input would otherwise feed output back to
+	// itself through a cycle of fan-outs.
+	var visit func(d string, path []string) error
+	visit = func(d string, path []string) error {
+		if d == device {
+			return fmt.Errorf("device %q fan-out forms a cycle: %s", device, strings.Join(append(path, d), " -> "))
+		}
+
+		fc := fanOuts[d]
+		if fc == nil {
+			return nil
+		}
+		for _, next := range fc.Devices {
+			if err := visit(next, append(path, d)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	for _, d := range fc.Devices {
+		if err := visit(d, []string{device}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// A fanOut mirrors a device's output as input to other devices, such as a
+// hardware logger. Output is dropped rather than blocking the device when a
+// target device does not keep up, or when the buffer budget is exhausted.
+type fanOut struct {
+	name    string
+	targets map[string]chan []byte
+	rd      redactor
+	budget  *bufferBudget
+	bytes   metricslite.Counter
+	drops   metricslite.Counter
+	ll      *log.Logger
+}
+
+// newFanOut creates a fanOut for device d from its configuration.
+func newFanOut(d rawDevice, qc queueConfig, bytes, drops metricslite.Counter, ll *log.Logger) *fanOut {
+	targets := make(map[string]chan []byte, len(d.FanOut.Devices))
+	for _, t := range d.FanOut.Devices {
+		targets[t] = make(chan []byte, qc.length)
+	}
+
+	return &fanOut{
+		name:    d.Name,
+		targets: targets,
+		rd:      newRedactor(d.Redact),
+		budget:  qc.budget,
+		bytes:   bytes,
+		drops:   drops,
+		ll:      ll,
+	}
+}
+
+// run copies output from the mux to the target devices until the process
+// exits, restarting the copy if it stops.
+func (fo *fanOut) run(mux *consrv.MuxDevice, devices map[string]*consrv.MuxDevice) {
+	for t, c := range fo.targets {
+		go fo.write(t, devices[t], c)
+	}
+
+	for {
+		ctx, cancel := context.WithCancel(context.Background())
+		if err := fo.copy(mux.Attach(ctx)); err != nil {
+			errorf(fo.ll, "fan-out for %q: %v", fo.name, err)
+		}
+		cancel()
+
+		fo.ll.Printf("restarting fan-out for %q", fo.name)
+		time.Sleep(1 * time.Second)
+	}
+}
+
+// copy buffers output from r for each target device until r returns an error.
+func (fo *fanOut) copy(r io.Reader) error {
+	b := make([]byte, 4096)
+	for {
+		n, err := r.Read(b)
+		if n > 0 {
+			// The read buffer is reused, so each chunk must be copied unless
+			// redaction already produced a new slice. Targets only read the
+			// chunk, so it may be shared between them.
+			chunk := fo.rd.redact(append([]byte(nil), b[:n]...))
+
+			for t, c := range fo.targets {
+				if !fo.budget.acquire(len(chunk)) {
+					fo.drops(float64(len(chunk)), fo.name, t)
+					continue
+				}
+
+				select {
+				case c <- chunk:
+				default:
+					// Never block the device's output.
+					fo.budget.release(len(chunk))
+					fo.drops(float64(len(chunk)), fo.name, t)
+				}
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// write writes buffered output to the target device w until the process
+// exits.
+func (fo *fanOut) write(target string, w io.Writer, c <-chan []byte) {
+	for chunk := range c {
+		fo.budget.release(len(chunk))
+		n, err := w.Write(chunk)
+		fo.bytes(float64(n), fo.name, target)
+		if err != nil {
+			errorf(fo.ll, "fan-out for %q: failed to write to %q: %v", fo.name, target, err)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_fanOutCopy(t *testing.T) {
+	dropped := make(map[string]int)
+	fo := &fanOut{
+		name: "server",
+		targets: map[string]chan []byte{
+			"logger": make(chan []byte, 1),
+			"backup": make(chan []byte, 3),
+		},
+		rd:    newRedactor([]redactRule{{Pattern: "hunter2"}}),
+		drops: func(v float64, labels ...string) { dropped[labels[1]] += int(v) },
+		ll:    log.New(io.Discard, "", 0),
+	}
+
+	r := &chunkReader{
+		chunks: []string{"password: hunter2\n", "dropped\n", "also dropped\n"},
+		read:   func() {},
+	}
+	if err := fo.copy(r); err != io.EOF {
+		t.Fatalf("failed to copy: %v", err)
+	}
+
+	got := make(map[string]string)
+	for name, c := range fo.targets {
+		close(c)
+		for chunk := range c {
+			got[name] += string(chunk)
+		}
+	}
+
+	want := map[string]string{
+		"logger": "password: [redacted]\n",
+		"backup": "password: [redacted]\ndropped\nalso dropped\n",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("unexpected output (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(map[string]int{"logger": len("dropped\nalso dropped\n")}, dropped); diff != "" {
+		t.Fatalf("unexpected dropped bytes (-want +got):\n%s", diff)
+	}
+}
+
+func Test_fanOutWrite(t *testing.T) {
+	var written int
+	fo := &fanOut{
+		name:  "server",
+		bytes: func(v float64, _ ...string) { written += int(v) },
+		ll:    log.New(io.Discard, "", 0),
+	}
+
+	c := make(chan []byte, 2)
+	c <- []byte("hello ")
+	c <- []byte("world")
+	close(c)
+
+	var buf bytes.Buffer
+	fo.write("logger", &buf, c)
+
+	if diff := cmp.Diff("hello world", buf.String()); diff != "" {
+		t.Fatalf("unexpected device input (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(len("hello world"), written); diff != "" {
+		t.Fatalf("unexpected written bytes (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+
+	"github.com/mdlayher/consrv"
+)
+
+// gdbConfig contains the configuration for a device's GDB remote serial
+// protocol passthrough mode.
+type gdbConfig struct {
+	Address string `toml:"address"`
+}
+
+// validate verifies the GDB configuration for device.
+func (gc *gdbConfig) validate(device string) error {
+	if _, _, err := net.SplitHostPort(gc.Address); err != nil {
+		return fmt.Errorf("device %q GDB passthrough must have a valid address: %v", device, err)
+	}
+
+	return nil
+}
+
+// A gdbServer exposes a device to a single GDB remote serial protocol client
+// over TCP, such as for kernel debugging with kgdboc. The device's console mux
+// is paused while a client is connected so that other sessions and consumers
+// cannot interfere with the protocol.
+type gdbServer struct {
+	name string
+	mux  *consrv.MuxDevice
+	ll   *log.Logger
+}
+
+// serve accepts GDB client connections on l until l is closed.
+func (g *gdbServer) serve(l net.Listener) error {
+	g.ll.Printf("%s: starting GDB passthrough on %q", g.name, l.Addr())
+
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+
+			return err
+		}
+
+		go g.handle(c)
+	}
+}
+
+// handle proxies between c and the device until c is closed.
+func (g *gdbServer) handle(c net.Conn) {
+	defer c.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rw, err := g.mux.Pause(ctx)
+	if err != nil {
+		g.ll.Printf("%s: rejecting GDB connection from %s: %v", g.name, c.RemoteAddr(), err)
+		return
+	}
+
+	g.ll.Printf("%s: GDB connected from %s, pausing console", g.name, c.RemoteAddr())
+
+	// The device reader returns io.EOF once the context is canceled, which
+	// happens as soon as the client disconnects.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _ = io.Copy(c, rw)
+	}()
+
+	_, _ = io.Copy(rw, c)
+	cancel()
+	<-done
+
+	g.ll.Printf("%s: GDB disconnected from %s, resuming console", g.name, c.RemoteAddr())
+}
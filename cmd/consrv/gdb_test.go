@@ -0,0 +1,79 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io"
+	"log"
+	"net"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mdlayher/consrv"
+)
+
+func TestGDBServer(t *testing.T) {
+	d := &scriptDevice{
+		replies: map[string]string{"$?#3f": "+$S05#b8"},
+		readC:   make(chan []byte, 8),
+	}
+	mux := consrv.NewMuxDevice(d)
+	defer mux.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer l.Close()
+
+	g := &gdbServer{name: "server", mux: mux, ll: log.New(io.Discard, "", 0)}
+	go func() { _ = g.serve(l) }()
+
+	c1, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer c1.Close()
+
+	if _, err := io.WriteString(c1, "$?#3f"); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+
+	b := make([]byte, 8)
+	if _, err := io.ReadFull(c1, b); err != nil {
+		t.Fatalf("failed to read: %v", err)
+	}
+	if diff := cmp.Diff("+$S05#b8", string(b)); diff != "" {
+		t.Fatalf("unexpected reply (-want +got):\n%s", diff)
+	}
+
+	// Console input is discarded while GDB is connected.
+	if _, err := io.WriteString(mux, "console"); err != nil {
+		t.Fatalf("failed to write console: %v", err)
+	}
+	if diff := cmp.Diff("$?#3f", d.written()); diff != "" {
+		t.Fatalf("unexpected device input (-want +got):\n%s", diff)
+	}
+
+	// Only one GDB client may be connected at a time.
+	c2, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer c2.Close()
+
+	if _, err := c2.Read(b); err != io.EOF {
+		t.Fatalf("expected EOF for second client, but got: %v", err)
+	}
+}
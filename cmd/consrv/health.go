@@ -0,0 +1,127 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+	"slices"
+	"sync/atomic"
+
+	"github.com/mdlayher/consrv"
+)
+
+// A health reports whether consrv's components are usable over HTTP:
+//
+//	GET /healthz
+//	GET /readyz
+//
+// /healthz reports that the process is serving HTTP, while /readyz reports
+// whether the SSH server is listening and each device is open, and responds
+// with 503 Service Unavailable if any of them are not. /readyz also reports
+// the identity using each device, if any.
+type health struct {
+	hash    string
+	sshAddr string
+	ssh     atomic.Bool
+	devices map[string]*consrv.MuxDevice
+	holder  func(device string) (consrv.Holder, bool)
+}
+
+// A connector is a consrv.Device which reconnects to a remote endpoint, and
+// reports whether it is currently connected.
+type connector interface {
+	connected() bool
+}
+
+// A readiness is the JSON representation of consrv's readiness.
+type readiness struct {
+	Ready        bool              `json:"ready"`
+	ConfigSHA256 string            `json:"config_sha256"`
+	SSH          sshReadiness      `json:"ssh"`
+	Devices      []deviceReadiness `json:"devices"`
+}
+
+// An sshReadiness is the readiness of the SSH server.
+type sshReadiness struct {
+	Address string `json:"address"`
+	Ready   bool   `json:"ready"`
+}
+
+// A deviceReadiness is the readiness of a device.
+type deviceReadiness struct {
+	Name   string        `json:"name"`
+	Ready  bool          `json:"ready"`
+	Error  string        `json:"error,omitempty"`
+	Holder *deviceHolder `json:"holder,omitempty"`
+}
+
+// A deviceHolder is the JSON representation of a consrv.Holder.
+type deviceHolder struct {
+	Identity string `json:"identity"`
+	Source   string `json:"source"`
+}
+
+// healthz implements the /healthz endpoint.
+func (h *health) healthz(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = w.Write([]byte("ok\n"))
+}
+
+// readyz implements the /readyz endpoint.
+func (h *health) readyz(w http.ResponseWriter, _ *http.Request) {
+	rd := h.readiness()
+
+	w.Header().Set("Content-Type", "application/json")
+	if !rd.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(rd)
+}
+
+// readiness reports the readiness of each component.
+func (h *health) readiness() readiness {
+	rd := readiness{
+		Ready:        h.ssh.Load(),
+		ConfigSHA256: h.hash,
+		SSH: sshReadiness{
+			Address: h.sshAddr,
+			Ready:   h.ssh.Load(),
+		},
+		Devices: make([]deviceReadiness, 0, len(h.devices)),
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(h.devices)) {
+		d := deviceReadiness{Name: name, Ready: true}
+
+		mux := h.devices[name]
+		if err := mux.Err(); err != nil {
+			d.Ready, d.Error = false, err.Error()
+		} else if c, ok := mux.Device.(connector); ok && !c.connected() {
+			d.Ready, d.Error = false, "not connected"
+		}
+
+		if h.holder != nil {
+			if dh, ok := h.holder(name); ok {
+				d.Holder = &deviceHolder{Identity: dh.Identity, Source: dh.Source}
+			}
+		}
+
+		rd.Ready = rd.Ready && d.Ready
+		rd.Devices = append(rd.Devices, d)
+	}
+
+	return rd
+}
@@ -0,0 +1,150 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mdlayher/consrv"
+	"github.com/mdlayher/metricslite"
+)
+
+func Test_healthReadyz(t *testing.T) {
+	ok := consrv.NewMuxDevice(&scriptDevice{readC: make(chan []byte)})
+	defer ok.Close()
+
+	broken := consrv.NewMuxDevice(&errDevice{err: errors.New("input/output error")})
+	_ = broken.Close()
+
+	c := metricslite.Discard().Counter("bytes", "")
+	remote := consrv.NewMuxDevice(newTCPDevice(rawDevice{Name: "switch", Address: "192.0.2.1:7001"},
+		// A lease which is never held keeps the device disconnected.
+		newLease(standbyConfig{}, newLogLevel(), log.New(io.Discard, "", 0)), c, c, log.New(io.Discard, "", 0)))
+	defer remote.Close()
+
+	tests := []struct {
+		name    string
+		ssh     bool
+		devices map[string]*consrv.MuxDevice
+		holder  func(string) (consrv.Holder, bool)
+		code    int
+		want    readiness
+	}{
+		{
+			name:    "ready",
+			ssh:     true,
+			devices: map[string]*consrv.MuxDevice{"server": ok},
+			code:    http.StatusOK,
+			want: readiness{
+				Ready:        true,
+				ConfigSHA256: "abcd",
+				SSH:          sshReadiness{Address: "[::]:2222", Ready: true},
+				Devices:      []deviceReadiness{{Name: "server", Ready: true}},
+			},
+		},
+		{
+			name:    "held",
+			ssh:     true,
+			devices: map[string]*consrv.MuxDevice{"server": ok},
+			holder: func(string) (consrv.Holder, bool) {
+				return consrv.Holder{Identity: "alice", Source: consrv.HolderReservation}, true
+			},
+			code: http.StatusOK,
+			want: readiness{
+				Ready:        true,
+				ConfigSHA256: "abcd",
+				SSH:          sshReadiness{Address: "[::]:2222", Ready: true},
+				Devices: []deviceReadiness{{
+					Name:   "server",
+					Ready:  true,
+					Holder: &deviceHolder{Identity: "alice", Source: "reservation"},
+				}},
+			},
+		},
+		{
+			name:    "SSH not listening",
+			devices: map[string]*consrv.MuxDevice{"server": ok},
+			code:    http.StatusServiceUnavailable,
+			want: readiness{
+				ConfigSHA256: "abcd",
+				SSH:          sshReadiness{Address: "[::]:2222"},
+				Devices:      []deviceReadiness{{Name: "server", Ready: true}},
+			},
+		},
+		{
+			name: "devices not ready",
+			ssh:  true,
+			devices: map[string]*consrv.MuxDevice{
+				"server":  ok,
+				"desktop": broken,
+				"switch":  remote,
+			},
+			code: http.StatusServiceUnavailable,
+			want: readiness{
+				ConfigSHA256: "abcd",
+				SSH:          sshReadiness{Address: "[::]:2222", Ready: true},
+				Devices: []deviceReadiness{
+					{Name: "desktop", Error: "input/output error"},
+					{Name: "server", Ready: true},
+					{Name: "switch", Error: "not connected"},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &health{
+				hash:    "abcd",
+				sshAddr: "[::]:2222",
+				devices: tt.devices,
+				holder:  tt.holder,
+			}
+			h.ssh.Store(tt.ssh)
+
+			w := httptest.NewRecorder()
+			h.readyz(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+			if diff := cmp.Diff(tt.code, w.Code); diff != "" {
+				t.Fatalf("unexpected status code (-want +got):\n%s", diff)
+			}
+
+			var got readiness
+			if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+				t.Fatalf("failed to decode readiness: %v", err)
+			}
+
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Fatalf("unexpected readiness (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// An errDevice is a consrv.Device which fails to read.
+type errDevice struct {
+	err error
+}
+
+func (d *errDevice) Read([]byte) (int, error)    { return 0, d.err }
+func (d *errDevice) Write(b []byte) (int, error) { return len(b), nil }
+func (d *errDevice) Close() error                { return nil }
+func (d *errDevice) String() string              { return "err" }
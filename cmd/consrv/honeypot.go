@@ -0,0 +1,66 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/mdlayher/consrv"
+)
+
+// honeypotConfig contains the configuration for the honeypot presented to
+// clients which attempt password authentication.
+type honeypotConfig struct {
+	Log string `toml:"log"`
+}
+
+// A honeypotRecord is a JSON line in the honeypot log.
+type honeypotRecord struct {
+	Time     time.Time `json:"time"`
+	Address  string    `json:"address"`
+	User     string    `json:"user"`
+	Input    string    `json:"input"`
+	Password bool      `json:"password,omitempty"`
+}
+
+// newHoneypot returns a function which records honeypot input as JSON lines to
+// w, or to ll if w is nil.
+func newHoneypot(w io.Writer, ll *log.Logger, now func() time.Time) func(consrv.HoneypotInput) {
+	if w == nil {
+		return func(in consrv.HoneypotInput) {
+			ll.Printf("%s: honeypot input for %q: %q (password: %t)", in.Addr, in.User, in.Input, in.Password)
+		}
+	}
+
+	var mu sync.Mutex
+	enc := json.NewEncoder(w)
+	return func(in consrv.HoneypotInput) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if err := enc.Encode(honeypotRecord{
+			Time:     now(),
+			Address:  in.Addr.String(),
+			User:     in.User,
+			Input:    in.Input,
+			Password: in.Password,
+		}); err != nil {
+			errorf(ll, "failed to write honeypot log: %v", err)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mdlayher/consrv"
+)
+
+func Test_newHoneypot(t *testing.T) {
+	addr := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 50022}
+
+	tests := []struct {
+		name string
+		file bool
+		in   consrv.HoneypotInput
+		out  string
+		logs string
+	}{
+		{
+			name: "file",
+			file: true,
+			in: consrv.HoneypotInput{
+				Addr:     addr,
+				User:     "root",
+				Input:    "hunter2",
+				Password: true,
+			},
+			out: `{"time":"1970-01-01T00:01:40Z","address":"192.0.2.1:50022","user":"root","input":"hunter2","password":true}` + "\n",
+		},
+		{
+			name: "file line",
+			file: true,
+			in: consrv.HoneypotInput{
+				Addr:  addr,
+				User:  "root",
+				Input: "admin",
+			},
+			out: `{"time":"1970-01-01T00:01:40Z","address":"192.0.2.1:50022","user":"root","input":"admin"}` + "\n",
+		},
+		{
+			name: "logger",
+			in: consrv.HoneypotInput{
+				Addr:     addr,
+				User:     "root",
+				Input:    "hunter2",
+				Password: true,
+			},
+			logs: `192.0.2.1:50022: honeypot input for "root": "hunter2" (password: true)` + "\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				out, logs bytes.Buffer
+				w         io.Writer
+			)
+			if tt.file {
+				w = &out
+			}
+
+			record := newHoneypot(w, log.New(&logs, "", 0), func() time.Time { return time.Unix(100, 0).UTC() })
+			record(tt.in)
+
+			if diff := cmp.Diff(tt.out, out.String()); diff != "" {
+				t.Fatalf("unexpected output (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tt.logs, logs.String()); diff != "" {
+				t.Fatalf("unexpected logs (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
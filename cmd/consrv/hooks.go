@@ -0,0 +1,119 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/mdlayher/consrv"
+)
+
+// hooksConfig contains the configuration for commands which run when an SSH
+// session opens or closes on a device.
+type hooksConfig struct {
+	Open  []string `toml:"open"`
+	Close []string `toml:"close"`
+}
+
+// validate verifies the hooks configuration for device.
+func (hc *hooksConfig) validate(device string) error {
+	if len(hc.Open) == 0 && len(hc.Close) == 0 {
+		return fmt.Errorf("device %q hooks must have an open or close command", device)
+	}
+
+	for _, args := range [][]string{hc.Open, hc.Close} {
+		if len(args) > 0 && args[0] == "" {
+			return fmt.Errorf("device %q hooks must not have an empty command", device)
+		}
+	}
+
+	return nil
+}
+
+// The events which run a session hook.
+const (
+	hookOpen  = "open"
+	hookClose = "close"
+)
+
+// hookTimeout bounds the run time of a session hook.
+const hookTimeout = 1 * time.Minute
+
+// sessionHooks run commands when SSH sessions open or close on a device.
+type sessionHooks struct {
+	hc  hooksConfig
+	run func(ctx context.Context, args, env []string) error
+	ll  *log.Logger
+}
+
+// newSessionHooks creates sessionHooks from the configuration of device d.
+func newSessionHooks(d rawDevice, ll *log.Logger) *sessionHooks {
+	return &sessionHooks{
+		hc:  *d.Hooks,
+		run: runHook,
+		ll:  ll,
+	}
+}
+
+// open runs the open hook, if any, for a session.
+func (sh *sessionHooks) open(info consrv.SessionInfo) { sh.fire(hookOpen, sh.hc.Open, info) }
+
+// close runs the close hook, if any, for a session.
+func (sh *sessionHooks) close(info consrv.SessionInfo) { sh.fire(hookClose, sh.hc.Close, info) }
+
+// fire runs the command args for event with the session's details in its
+// environment.
+func (sh *sessionHooks) fire(event string, args []string, info consrv.SessionInfo) {
+	if len(args) == 0 {
+		return
+	}
+
+	var addr string
+	if info.Addr != nil {
+		addr = info.Addr.String()
+	}
+
+	env := []string{
+		"CONSRV_HOOK=" + event,
+		"CONSRV_DEVICE=" + info.Device,
+		"CONSRV_IDENTITY=" + info.Identity,
+		"CONSRV_ADDRESS=" + addr,
+		"CONSRV_REASON=" + info.Reason,
+	}
+
+	if err := sh.run(context.Background(), args, env); err != nil {
+		errorf(sh.ll, "%s hook for %q: %v", event, info.Device, err)
+	}
+}
+
+// runHook runs a session hook command with env added to its environment.
+func runHook(ctx context.Context, args, env []string) error {
+	ctx, cancel := context.WithTimeout(ctx, hookTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Env = append(os.Environ(), env...)
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to run command %q: %v: %s", args, err, bytes.TrimSpace(out))
+	}
+
+	return nil
+}
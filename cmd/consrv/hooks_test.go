@@ -0,0 +1,136 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mdlayher/consrv"
+)
+
+func Test_sessionHooks(t *testing.T) {
+	info := consrv.SessionInfo{
+		Device:   "server",
+		Identity: "mdlayher",
+		Addr:     &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 22},
+		Reason:   "CHG-1234",
+	}
+
+	type call struct {
+		Args, Env []string
+	}
+
+	tests := []struct {
+		name string
+		hc   hooksConfig
+		want []call
+	}{
+		{
+			name: "open and close",
+			hc: hooksConfig{
+				Open:  []string{"/perm/kvm", "on"},
+				Close: []string{"/perm/kvm", "off"},
+			},
+			want: []call{
+				{
+					Args: []string{"/perm/kvm", "on"},
+					Env: []string{
+						"CONSRV_HOOK=open",
+						"CONSRV_DEVICE=server",
+						"CONSRV_IDENTITY=mdlayher",
+						"CONSRV_ADDRESS=192.0.2.1:22",
+						"CONSRV_REASON=CHG-1234",
+					},
+				},
+				{
+					Args: []string{"/perm/kvm", "off"},
+					Env: []string{
+						"CONSRV_HOOK=close",
+						"CONSRV_DEVICE=server",
+						"CONSRV_IDENTITY=mdlayher",
+						"CONSRV_ADDRESS=192.0.2.1:22",
+						"CONSRV_REASON=CHG-1234",
+					},
+				},
+			},
+		},
+		{
+			name: "open only",
+			hc:   hooksConfig{Open: []string{"/perm/camera"}},
+			want: []call{{
+				Args: []string{"/perm/camera"},
+				Env: []string{
+					"CONSRV_HOOK=open",
+					"CONSRV_DEVICE=server",
+					"CONSRV_IDENTITY=mdlayher",
+					"CONSRV_ADDRESS=192.0.2.1:22",
+					"CONSRV_REASON=CHG-1234",
+				},
+			}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []call
+			sh := newSessionHooks(rawDevice{Name: "server", Hooks: &tt.hc}, log.New(io.Discard, "", 0))
+			sh.run = func(_ context.Context, args, env []string) error {
+				got = append(got, call{Args: args, Env: env})
+				return nil
+			}
+
+			sh.open(info)
+			sh.close(info)
+
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Fatalf("unexpected hook calls (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_runHook(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skipf("skipping, no shell: %v", err)
+	}
+
+	out := filepath.Join(t.TempDir(), "out")
+	args := []string{"sh", "-c", `printf '%s %s' "$CONSRV_HOOK" "$CONSRV_IDENTITY" > "$0"`, out}
+	if err := runHook(context.Background(), args, []string{"CONSRV_HOOK=open", "CONSRV_IDENTITY=mdlayher"}); err != nil {
+		t.Fatalf("failed to run hook: %v", err)
+	}
+
+	b, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("failed to read output: %v", err)
+	}
+
+	if diff := cmp.Diff("open mdlayher", string(b)); diff != "" {
+		t.Fatalf("unexpected output (-want +got):\n%s", diff)
+	}
+
+	err = runHook(context.Background(), []string{"sh", "-c", "echo oops; exit 1"}, nil)
+	if err == nil || !strings.HasSuffix(err.Error(), ": oops") {
+		t.Fatalf("expected command failure with output, but got: %v", err)
+	}
+}
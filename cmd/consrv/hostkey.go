@@ -0,0 +1,138 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/mdlayher/consrv"
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+)
+
+// hostKeyEnv is the environment variable which may contain the passphrase for
+// an encrypted SSH host key.
+const hostKeyEnv = "CONSRV_HOST_KEY_PASSPHRASE"
+
+// A hostKey is an SSH host key and the information needed to reload it.
+type hostKey struct {
+	// The PEM-encoded private key and its passphrase, if encrypted.
+	PEM, Passphrase []byte
+
+	// The file the key was loaded from, or empty if it can't be reloaded.
+	File string
+}
+
+// A passphraseSource provides the sources which may supply the passphrase for
+// an encrypted host key.
+type passphraseSource struct {
+	file     string
+	readFile func(file string) ([]byte, error)
+	getenv   func(key string) string
+	prompt   func() ([]byte, error)
+}
+
+// newPassphraseSource creates a passphraseSource which uses the passphrase
+// file, the environment, and a terminal prompt on stdin, in that order.
+func newPassphraseSource(file string) passphraseSource {
+	return passphraseSource{
+		file:     file,
+		readFile: os.ReadFile,
+		getenv: func(key string) string {
+			// Don't leak the passphrase to any child processes.
+			v := os.Getenv(key)
+			_ = os.Unsetenv(key)
+			return v
+		},
+		prompt: func() ([]byte, error) {
+			fd := int(os.Stdin.Fd())
+			if !term.IsTerminal(fd) {
+				return nil, nil
+			}
+
+			fmt.Fprint(os.Stderr, "Enter passphrase for SSH host key: ")
+			defer fmt.Fprintln(os.Stderr)
+			return term.ReadPassword(fd)
+		},
+	}
+}
+
+// passphrase returns the passphrase for key if it is encrypted, and verifies
+// that the passphrase decrypts key. If key is not encrypted, it returns nil.
+func (ps passphraseSource) passphrase(key []byte) ([]byte, error) {
+	_, err := gossh.ParsePrivateKey(key)
+	var perr *gossh.PassphraseMissingError
+	if !errors.As(err, &perr) {
+		// Not encrypted, or invalid in a way a passphrase can't fix.
+		return nil, err
+	}
+
+	var (
+		pass []byte
+		env  = ps.getenv(hostKeyEnv)
+	)
+	switch {
+	case ps.file != "":
+		b, err := ps.readFile(ps.file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read host key passphrase file: %v", err)
+		}
+		pass = bytes.TrimRight(b, "\r\n")
+	case env != "":
+		pass = []byte(env)
+	default:
+		b, err := ps.prompt()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read host key passphrase: %v", err)
+		}
+		pass = b
+	}
+
+	if len(pass) == 0 {
+		return nil, fmt.Errorf("host key is encrypted, but no passphrase was provided with host_key_passphrase_file, $%s, or a terminal", hostKeyEnv)
+	}
+
+	if _, err := gossh.ParsePrivateKeyWithPassphrase(key, pass); err != nil {
+		return nil, fmt.Errorf("failed to decrypt host key: %v", err)
+	}
+
+	return pass, nil
+}
+
+// reloadHostKey reloads the SSH host key for srv from its file each time the
+// process receives SIGHUP, so the host key can be rotated without dropping
+// active sessions.
+func reloadHostKey(srv *consrv.Server, hk hostKey, ll *log.Logger) {
+	sigC := make(chan os.Signal, 1)
+	signal.Notify(sigC, syscall.SIGHUP)
+
+	for range sigC {
+		b, err := os.ReadFile(hk.File)
+		if err == nil {
+			err = srv.SetHostKey(b)
+		}
+		if err != nil {
+			errorf(ll, "failed to reload host key from %s: %v", hk.File, err)
+			continue
+		}
+
+		ll.Printf("reloaded host key from %s", hk.File)
+	}
+}
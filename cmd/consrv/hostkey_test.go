@@ -0,0 +1,151 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/pem"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func Test_passphraseSourcePassphrase(t *testing.T) {
+	var (
+		plain     = mustPEM(t, nil)
+		encrypted = mustPEM(t, []byte("hunter2"))
+	)
+
+	tests := []struct {
+		name   string
+		key    []byte
+		file   string
+		env    string
+		prompt string
+		want   []byte
+		ok     bool
+	}{
+		{
+			name: "bad key",
+			key:  []byte("foo"),
+		},
+		{
+			name: "unencrypted",
+			key:  plain,
+			env:  "hunter2",
+			ok:   true,
+		},
+		{
+			name: "missing",
+			key:  encrypted,
+		},
+		{
+			name: "bad file",
+			key:  encrypted,
+			file: "/nonexistent",
+		},
+		{
+			name: "wrong",
+			key:  encrypted,
+			env:  "password",
+		},
+		{
+			name: "OK file",
+			key:  encrypted,
+			file: "/perm/consrv/host_key.pass",
+			env:  "password",
+			want: []byte("hunter2"),
+			ok:   true,
+		},
+		{
+			name:   "OK env",
+			key:    encrypted,
+			env:    "hunter2",
+			prompt: "password",
+			want:   []byte("hunter2"),
+			ok:     true,
+		},
+		{
+			name:   "OK prompt",
+			key:    encrypted,
+			prompt: "hunter2",
+			want:   []byte("hunter2"),
+			ok:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ps := passphraseSource{
+				file: tt.file,
+				readFile: func(file string) ([]byte, error) {
+					if file != "/perm/consrv/host_key.pass" {
+						return nil, errors.New("not found")
+					}
+
+					return []byte("hunter2\n"), nil
+				},
+				getenv: func(key string) string {
+					if key != hostKeyEnv {
+						t.Fatalf("unexpected environment variable: %q", key)
+					}
+
+					return tt.env
+				},
+				prompt: func() ([]byte, error) {
+					return []byte(tt.prompt), nil
+				},
+			}
+
+			got, err := ps.passphrase(tt.key)
+			if tt.ok && err != nil {
+				t.Fatalf("failed to get passphrase: %v", err)
+			}
+			if !tt.ok && err == nil {
+				t.Fatal("expected an error, but none occurred")
+			}
+			if err != nil {
+				t.Logf("err: %v", err)
+				return
+			}
+
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Fatalf("unexpected passphrase (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func mustPEM(t *testing.T, passphrase []byte) []byte {
+	t.Helper()
+
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	var b *pem.Block
+	if passphrase == nil {
+		b, err = gossh.MarshalPrivateKey(priv, "")
+	} else {
+		b, err = gossh.MarshalPrivateKeyWithPassphrase(priv, "", passphrase)
+	}
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+
+	return pem.EncodeToMemory(b)
+}
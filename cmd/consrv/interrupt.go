@@ -0,0 +1,205 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"regexp"
+	"time"
+
+	"github.com/mdlayher/consrv"
+)
+
+// interruptConfig contains the configuration for a device's boot interrupt
+// helper.
+type interruptConfig struct {
+	Pattern string   `toml:"pattern"`
+	Keys    string   `toml:"keys"`
+	Wait    duration `toml:"wait"`
+	Resume  string   `toml:"resume"`
+	Webhook string   `toml:"webhook"`
+}
+
+// validate verifies the interrupt configuration for device.
+func (ic *interruptConfig) validate(device string) error {
+	if _, err := regexp.Compile(ic.Pattern); err != nil || ic.Pattern == "" {
+		return fmt.Errorf("device %q boot interrupt must have a valid pattern", device)
+	}
+	if ic.Keys == "" {
+		return fmt.Errorf("device %q boot interrupt must have keys to send", device)
+	}
+	if ic.Wait.Duration < 0 || (ic.Resume != "" && ic.Wait.Duration == 0) {
+		return fmt.Errorf("device %q boot interrupt must have a positive wait duration to resume", device)
+	}
+
+	if ic.Webhook != "" {
+		u, err := url.Parse(ic.Webhook)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("device %q boot interrupt has invalid webhook URL %q", device, ic.Webhook)
+		}
+	}
+
+	return nil
+}
+
+// An interrupter interrupts a bootloader's autoboot countdown by sending keys
+// when its prompt appears, and then waits for a session to attach.
+type interrupter struct {
+	name     string
+	pattern  *regexp.Regexp
+	keys     []byte
+	wait     time.Duration
+	resume   []byte
+	w        io.Writer
+	sessions func() int
+	notify   func(ctx context.Context) error
+	event    func(message string)
+	ll       *log.Logger
+
+	// poll is the interval at which sessions are checked while waiting.
+	poll time.Duration
+	// skip is set when an incomplete line matched, so it is not matched
+	// again as it grows.
+	skip bool
+}
+
+// newInterrupter creates an interrupter for device d from its configuration,
+// which writes to mux, uses sessions to check for attached sessions, and reports
+// each interrupt using event.
+func newInterrupter(d rawDevice, mux *consrv.MuxDevice, sessions func() int, event func(message string), ll *log.Logger) *interrupter {
+	ic := d.Interrupt
+
+	return &interrupter{
+		name: d.Name,
+		// Validated when parsing the configuration.
+		pattern:  regexp.MustCompile(ic.Pattern),
+		keys:     []byte(ic.Keys),
+		wait:     ic.Wait.Duration,
+		resume:   []byte(ic.Resume),
+		w:        mux,
+		sessions: sessions,
+		notify: func(ctx context.Context) error {
+			if ic.Webhook == "" {
+				return nil
+			}
+
+			return postWebhook(ctx, ic.Webhook, struct {
+				Device string `json:"device"`
+				Event  string `json:"event"`
+			}{
+				Device: d.Name,
+				Event:  "boot_interrupted",
+			})
+		},
+		event: event,
+		ll:    ll,
+		poll:  1 * time.Second,
+	}
+}
+
+// run watches output from the mux until the process exits, restarting the
+// watch if it stops.
+func (in *interrupter) run(mux *consrv.MuxDevice) {
+	for {
+		ctx, cancel := context.WithCancel(context.Background())
+		if err := in.watch(ctx, mux.Attach(ctx)); err != nil {
+			errorf(in.ll, "boot interrupt for %q: %v", in.name, err)
+		}
+		cancel()
+
+		in.ll.Printf("restarting boot interrupt for %q", in.name)
+		time.Sleep(1 * time.Second)
+	}
+}
+
+// watch reads output from r until it returns an error, and interrupts the
+// bootloader each time its prompt appears.
+func (in *interrupter) watch(ctx context.Context, r io.Reader) error {
+	var (
+		lb lineBuffer
+		b  = make([]byte, 4096)
+	)
+
+	for {
+		n, err := r.Read(b)
+		lb.write(b[:n], func(line []byte, partial bool) {
+			if in.skip {
+				in.skip = partial
+				return
+			}
+			if !in.pattern.Match(line) {
+				return
+			}
+
+			// Countdowns are typically redrawn on the same line, so only
+			// act once per line.
+			in.skip = partial
+			in.interrupt(ctx)
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// interrupt sends the interrupt keys and, if no session is attached, notifies
+// and waits for one.
+func (in *interrupter) interrupt(ctx context.Context) {
+	if _, err := in.w.Write(in.keys); err != nil {
+		errorf(in.ll, "boot interrupt for %q: failed to send keys: %v", in.name, err)
+		return
+	}
+	in.event("interrupted autoboot")
+	if in.sessions() > 0 {
+		in.ll.Printf("interrupted autoboot for %q", in.name)
+		return
+	}
+
+	in.ll.Printf("interrupted autoboot for %q, waiting for a session to attach", in.name)
+
+	// Don't block reading output from the mux while notifying and waiting.
+	go func() {
+		if err := in.notify(ctx); err != nil {
+			errorf(in.ll, "boot interrupt for %q: failed to notify: %v", in.name, err)
+		}
+		if len(in.resume) == 0 {
+			return
+		}
+
+		t := time.NewTicker(in.poll)
+		defer t.Stop()
+
+		deadline := time.Now().Add(in.wait)
+		for time.Now().Before(deadline) {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+
+			if in.sessions() > 0 {
+				return
+			}
+		}
+
+		in.ll.Printf("no session attached to %q within %s, resuming boot", in.name, in.wait)
+		if _, err := in.w.Write(in.resume); err != nil {
+			errorf(in.ll, "boot interrupt for %q: failed to resume: %v", in.name, err)
+		}
+	}()
+}
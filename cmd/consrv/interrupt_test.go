@@ -0,0 +1,118 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_interrupterWatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		in       []string
+		sessions int
+		resume   string
+		want     string
+	}{
+		{
+			name: "no prompt",
+			in:   []string{"U-Boot 2024.01\n", "Starting kernel ...\n"},
+		},
+		{
+			name: "attached",
+			in: []string{
+				"U-Boot 2024.01\n",
+				"Hit any key to stop autoboot:  3",
+				"\b\b\b 2",
+				"\b\b\b 1",
+			},
+			sessions: 1,
+			resume:   "boot\r",
+			want:     " ",
+		},
+		{
+			name: "no resume",
+			in:   []string{"Hit any key to stop autoboot:  3\r\n"},
+			want: " ",
+		},
+		{
+			name: "resume",
+			in: []string{
+				"Hit any key to stop autoboot:  3",
+				"\b\b\b 2\r\n",
+				"=> ",
+			},
+			resume: "boot\r",
+			want:   " boot\r",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var w lockedBuffer
+			in := &interrupter{
+				name:     "server",
+				pattern:  regexp.MustCompile(`Hit any key to stop autoboot`),
+				keys:     []byte(" "),
+				wait:     50 * time.Millisecond,
+				resume:   []byte(tt.resume),
+				w:        &w,
+				sessions: func() int { return tt.sessions },
+				notify:   func(context.Context) error { return nil },
+				event:    func(string) {},
+				ll:       log.New(io.Discard, "", 0),
+				poll:     10 * time.Millisecond,
+			}
+
+			r := &chunkReader{chunks: tt.in, read: func() {}}
+			if err := in.watch(context.Background(), r); err != io.EOF {
+				t.Fatalf("failed to watch: %v", err)
+			}
+
+			// Allow time to resume the boot, if needed.
+			time.Sleep(200 * time.Millisecond)
+
+			if diff := cmp.Diff(tt.want, w.String()); diff != "" {
+				t.Fatalf("unexpected device input (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// A lockedBuffer is a bytes.Buffer which is safe for concurrent use.
+type lockedBuffer struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.String()
+}
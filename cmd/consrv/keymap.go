@@ -0,0 +1,144 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/mdlayher/consrv"
+)
+
+// keymapConfig contains the configuration for translating the input sent to a
+// device.
+type keymapConfig struct {
+	Presets []string     `toml:"presets"`
+	Rules   []keymapRule `toml:"rules"`
+}
+
+// A keymapRule replaces an input sequence with another.
+type keymapRule struct {
+	From string `toml:"from"`
+	To   string `toml:"to"`
+}
+
+// keymapPresets are named sets of rules for common mismatches between modern
+// terminal emulators and embedded consoles.
+var keymapPresets = map[string][]keymapRule{
+	// Backspace sends DEL, but many consoles only erase a character on BS.
+	"backspace": {{From: "\x7f", To: "\b"}},
+	// The Delete key sends a VT220 sequence which consoles expecting a DEL
+	// character don't understand.
+	"delete": {{From: "\x1b[3~", To: "\x7f"}},
+	// Cursor keys in application mode, as sent by terminals after a program
+	// enables it, are translated to the VT100 sequences of normal mode.
+	"vt100": {
+		{From: "\x1bOA", To: "\x1b[A"},
+		{From: "\x1bOB", To: "\x1b[B"},
+		{From: "\x1bOC", To: "\x1b[C"},
+		{From: "\x1bOD", To: "\x1b[D"},
+	},
+	// Home, End, and F1 through F4 are translated from xterm to VT220
+	// sequences.
+	"vt220": {
+		{From: "\x1b[H", To: "\x1b[1~"},
+		{From: "\x1bOH", To: "\x1b[1~"},
+		{From: "\x1b[F", To: "\x1b[4~"},
+		{From: "\x1bOF", To: "\x1b[4~"},
+		{From: "\x1bOP", To: "\x1b[11~"},
+		{From: "\x1bOQ", To: "\x1b[12~"},
+		{From: "\x1bOR", To: "\x1b[13~"},
+		{From: "\x1bOS", To: "\x1b[14~"},
+	},
+}
+
+// validate verifies the keymap configuration for device.
+func (kc *keymapConfig) validate(device string) error {
+	if len(kc.Presets) == 0 && len(kc.Rules) == 0 {
+		return fmt.Errorf("device %q keymap must have presets or rules", device)
+	}
+
+	for _, p := range kc.Presets {
+		if _, ok := keymapPresets[p]; !ok {
+			return fmt.Errorf("device %q keymap has unknown preset %q, expected one of: %s",
+				device, p, strings.Join(slices.Sorted(maps.Keys(keymapPresets)), ", "))
+		}
+	}
+
+	seen := make(map[string]bool, len(kc.Rules))
+	for _, r := range kc.Rules {
+		if r.From == "" {
+			return fmt.Errorf("device %q keymap rule must have an input sequence", device)
+		}
+		if seen[r.From] {
+			return fmt.Errorf("device %q keymap has duplicate rule for %q", device, r.From)
+		}
+		seen[r.From] = true
+	}
+
+	return nil
+}
+
+// replacer returns a strings.Replacer which applies the keymap. Rules take
+// precedence over presets, and earlier presets over later ones.
+func (kc *keymapConfig) replacer() *strings.Replacer {
+	var oldnew []string
+	for _, r := range kc.Rules {
+		oldnew = append(oldnew, r.From, r.To)
+	}
+	for _, p := range kc.Presets {
+		for _, r := range keymapPresets[p] {
+			oldnew = append(oldnew, r.From, r.To)
+		}
+	}
+
+	return strings.NewReplacer(oldnew...)
+}
+
+var _ consrv.Device = &keymapDevice{}
+
+// A keymapDevice is a consrv.Device which translates its input, such as key
+// sequences sent by a modern terminal emulator which an embedded console does
+// not understand. Terminals send each key sequence in a single write, so
+// sequences are only matched within a write.
+type keymapDevice struct {
+	consrv.Device
+	r *strings.Replacer
+}
+
+// newKeymapDevice wraps d to translate its input using kc.
+func newKeymapDevice(d consrv.Device, kc *keymapConfig) *keymapDevice {
+	return &keymapDevice{
+		Device: d,
+		r:      kc.replacer(),
+	}
+}
+
+// Write implements io.ReadWriteCloser.
+func (d *keymapDevice) Write(b []byte) (int, error) {
+	if _, err := d.Device.Write([]byte(d.r.Replace(string(b)))); err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
+
+// connected implements connector for devices which reconnect, and otherwise
+// reports that the device is connected.
+func (d *keymapDevice) connected() bool {
+	c, ok := d.Device.(connector)
+	return !ok || c.connected()
+}
@@ -0,0 +1,123 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_keymapConfigValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		kc   keymapConfig
+		ok   bool
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name: "unknown preset",
+			kc:   keymapConfig{Presets: []string{"vt52"}},
+		},
+		{
+			name: "empty rule",
+			kc:   keymapConfig{Rules: []keymapRule{{To: "\b"}}},
+		},
+		{
+			name: "duplicate rule",
+			kc: keymapConfig{Rules: []keymapRule{
+				{From: "\x7f", To: "\b"},
+				{From: "\x7f", To: "\x15"},
+			}},
+		},
+		{
+			name: "OK",
+			kc: keymapConfig{
+				Presets: []string{"backspace", "vt100"},
+				Rules:   []keymapRule{{From: "\x1b[15~", To: "\x1b[[E"}},
+			},
+			ok: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.kc.validate("server")
+			if tt.ok && err != nil {
+				t.Fatalf("failed to validate: %v", err)
+			}
+			if !tt.ok && err == nil {
+				t.Fatal("expected an error, but none occurred")
+			}
+		})
+	}
+}
+
+func Test_keymapDevice(t *testing.T) {
+	tests := []struct {
+		name    string
+		kc      keymapConfig
+		in, out string
+	}{
+		{
+			name: "backspace",
+			kc:   keymapConfig{Presets: []string{"backspace"}},
+			in:   "lss\x7f\r",
+			out:  "lss\b\r",
+		},
+		{
+			name: "application cursor keys",
+			kc:   keymapConfig{Presets: []string{"vt100"}},
+			in:   "\x1bOA\x1bOD\x1b[B",
+			out:  "\x1b[A\x1b[D\x1b[B",
+		},
+		{
+			name: "function keys",
+			kc:   keymapConfig{Presets: []string{"vt220", "delete"}},
+			in:   "\x1bOP\x1b[H\x1b[3~",
+			out:  "\x1b[11~\x1b[1~\x7f",
+		},
+		{
+			name: "rules before presets",
+			kc: keymapConfig{
+				Presets: []string{"backspace"},
+				Rules:   []keymapRule{{From: "\x7f", To: "\x15"}},
+			},
+			in:  "a\x7f",
+			out: "a\x15",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var w bytes.Buffer
+			d := newKeymapDevice(&bufferDevice{Writer: &w}, &tt.kc)
+
+			n, err := d.Write([]byte(tt.in))
+			if err != nil {
+				t.Fatalf("failed to write: %v", err)
+			}
+
+			if diff := cmp.Diff(len(tt.in), n); diff != "" {
+				t.Fatalf("unexpected write length (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tt.out, w.String()); diff != "" {
+				t.Fatalf("unexpected input (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+)
+
+// A listenerConfig is an additional SSH listener which only serves a subset of
+// the devices, so that firewall rules for its address can segment access.
+type listenerConfig struct {
+	Address    string   `toml:"address"`
+	Devices    []string `toml:"devices"`
+	Namespaces []string `toml:"namespaces"`
+}
+
+// validate verifies the listener configuration against the names of the
+// configured devices and namespaces.
+func (lc *listenerConfig) validate(devices, namespaces map[string]struct{}) error {
+	if lc.Address == "" {
+		return errors.New("listener must have an address")
+	}
+	if _, err := net.ResolveTCPAddr("tcp", lc.Address); err != nil {
+		return fmt.Errorf("failed to parse listener address: %v", err)
+	}
+	if len(lc.Devices) == 0 && len(lc.Namespaces) == 0 {
+		return fmt.Errorf("listener %q must have devices or namespaces", lc.Address)
+	}
+
+	for _, d := range lc.Devices {
+		if _, ok := devices[d]; !ok {
+			return fmt.Errorf("listener %q is configured with unknown device %q", lc.Address, d)
+		}
+	}
+	for _, ns := range lc.Namespaces {
+		if _, ok := namespaces[ns]; !ok {
+			return fmt.Errorf("listener %q is configured with unknown namespace %q", lc.Address, ns)
+		}
+	}
+
+	return nil
+}
+
+// devices returns the names of the devices served by the listener, which are
+// its devices and the devices in its namespaces.
+func (lc *listenerConfig) devices(ds []rawDevice) []string {
+	out := slices.Clone(lc.Devices)
+	for _, d := range ds {
+		if slices.Contains(lc.Namespaces, d.Namespace) && !slices.Contains(out, d.Name) {
+			out = append(out, d.Name)
+		}
+	}
+
+	return out
+}
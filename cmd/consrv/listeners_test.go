@@ -0,0 +1,38 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_listenerConfigDevices(t *testing.T) {
+	lc := listenerConfig{
+		Devices:    []string{"plc", "hmi"},
+		Namespaces: []string{"ot"},
+	}
+
+	got := lc.devices([]rawDevice{
+		{Name: "plc", Namespace: "ot"},
+		{Name: "hmi"},
+		{Name: "rtu", Namespace: "ot"},
+		{Name: "server", Namespace: "it"},
+	})
+
+	if diff := cmp.Diff([]string{"plc", "hmi", "rtu"}, got); diff != "" {
+		t.Fatalf("unexpected devices (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,387 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"text/template"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mdlayher/consrv"
+)
+
+// logColors maps log_color names to ANSI SGR foreground color codes.
+var logColors = map[string]int{
+	"black":   30,
+	"red":     31,
+	"green":   32,
+	"yellow":  33,
+	"blue":    34,
+	"magenta": 35,
+	"cyan":    36,
+	"white":   37,
+}
+
+// defaultPrefix is the log prefix template used to disambiguate devices when
+// more than one device logs to stdout.
+const defaultPrefix = "{{.Name}}: "
+
+// prefixData is the data passed to the log prefix template for each line.
+type prefixData struct {
+	Name, Device, Serial string
+	Time                 time.Time
+}
+
+// parsePrefix parses a log prefix template.
+func parsePrefix(s string) (*template.Template, error) {
+	return template.New("prefix").Option("missingkey=error").Parse(s)
+}
+
+// openLogFile opens the log file for the named device in dir for appending,
+// creating dir if necessary.
+func openLogFile(dir, name string) (*os.File, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+
+	return os.OpenFile(
+		filepath.Join(dir, name+".log"),
+		os.O_WRONLY|os.O_APPEND|os.O_CREATE,
+		0o640,
+	)
+}
+
+// A lineLogger copies lines of device output to an io.Writer with a
+// configurable prefix.
+type lineLogger struct {
+	mu     sync.Mutex
+	w      io.Writer
+	prefix *template.Template
+	color  bool
+	raw    bool
+	filter func(b []byte) []byte
+	now    func() time.Time
+}
+
+// newStdoutLogger creates a lineLogger which writes to w, which is normally
+// stdout. If cfg does not configure a prefix, devices are only named in the
+// prefix when more than one device logs to stdout.
+func newStdoutLogger(w io.Writer, cfg *config) (*lineLogger, error) {
+	prefix := cfg.Log.Prefix
+	if prefix == "" {
+		var n int
+		for _, d := range cfg.Devices {
+			if d.LogToStdout {
+				n++
+			}
+		}
+
+		if n > 1 {
+			prefix = defaultPrefix
+		}
+	}
+
+	lc := cfg.Log
+	lc.Prefix = prefix
+	return newLineLogger(w, lc, true)
+}
+
+// newLineLogger creates a lineLogger which writes to w using the prefix and
+// mode in lc. If color is true, prefixes are colored using each device's log
+// color.
+func newLineLogger(w io.Writer, lc logConfig, color bool) (*lineLogger, error) {
+	tmpl, err := parsePrefix(lc.Prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	filter, ok := logModes[lc.Mode]
+	if !ok {
+		return nil, fmt.Errorf("unknown log mode %q", lc.Mode)
+	}
+
+	return &lineLogger{
+		w:      w,
+		prefix: tmpl,
+		color:  color,
+		raw:    lc.Mode == logRaw,
+		filter: filter,
+		now:    time.Now,
+	}, nil
+}
+
+// maxChunk is the maximum number of bytes of a line which are buffered before
+// being logged. Longer lines are logged in multiple chunks but only prefixed
+// once.
+const maxChunk = 64 * 1024
+
+// run copies output from the mux for device d until the process exits,
+// restarting the copy if it stops.
+func (sl *lineLogger) run(d rawDevice, mux *consrv.MuxDevice, ll *log.Logger) {
+	for {
+		// Detach from the mux when the copy stops so the mux does not block
+		// trying to pass output to a reader which no longer exists.
+		ctx, cancel := context.WithCancel(context.Background())
+		sl.copy(d, mux.Attach(ctx), ll)
+		cancel()
+
+		ll.Printf("restarting log copier for %q", d.Name)
+		time.Sleep(1 * time.Second)
+	}
+}
+
+// copy copies lines read from r for device d until r returns an error.
+func (sl *lineLogger) copy(d rawDevice, r io.Reader, ll *log.Logger) {
+	rd := newRedactor(d.Redact)
+
+	if sl.raw {
+		// Pass through the bytes exactly as they were read, except for
+		// redactions which are applied to complete lines so that a match
+		// which spans two reads is still redacted.
+		w := newRedactWriter(rd, &lockedWriter{mu: &sl.mu, w: sl.w})
+		if _, err := io.Copy(w, r); err != nil {
+			errorf(ll, "copying serial to log for %q: %v", d.Name, err)
+		}
+		if err := w.Flush(); err != nil {
+			errorf(ll, "copying serial to log for %q: %v", d.Name, err)
+		}
+		return
+	}
+
+	var start, end string
+	if c, ok := logColors[d.LogColor]; ok && sl.color {
+		// Only color the prefix so any color output from the device itself
+		// is left alone.
+		start, end = fmt.Sprintf("\x1b[%dm", c), "\x1b[0m"
+	}
+
+	var (
+		br = bufio.NewReaderSize(r, maxChunk)
+		// Whether the next chunk begins a new line.
+		newLine = true
+		buf     bytes.Buffer
+	)
+
+	for {
+		// Read up to the end of a line, or as much of a very long line as
+		// fits in the buffer.
+		b, err := br.ReadSlice('\n')
+		if len(b) > 0 {
+			buf.Reset()
+			if newLine {
+				buf.WriteString(start)
+				err := sl.prefix.Execute(&buf, prefixData{
+					Name:   d.Name,
+					Device: d.Device,
+					Serial: d.Serial,
+					Time:   sl.now(),
+				})
+				if err != nil {
+					// The template was validated when parsing the
+					// configuration, so this should only occur for an invalid
+					// method call or similar.
+					errorf(ll, "failed to execute log prefix template for %q: %v", d.Name, err)
+					return
+				}
+				buf.WriteString(end)
+			}
+
+			// Normalize line endings like bufio.ScanLines.
+			line, eol := bytes.CutSuffix(b, []byte("\n"))
+			if eol {
+				line = bytes.TrimSuffix(line, []byte("\r"))
+			}
+			// Redact before filtering so rules match the device's output.
+			line = rd.redact(line)
+			if sl.filter != nil {
+				line = sl.filter(line)
+			}
+			buf.Write(line)
+			if eol {
+				buf.WriteByte('\n')
+			}
+
+			sl.write(buf.Bytes())
+			newLine = eol
+		}
+
+		switch {
+		case err == nil, errors.Is(err, bufio.ErrBufferFull):
+			continue
+		case errors.Is(err, io.EOF):
+		default:
+			errorf(ll, "copying serial to log for %q: %v", d.Name, err)
+		}
+
+		if !newLine {
+			// Terminate a partial line.
+			sl.write([]byte("\n"))
+		}
+
+		return
+	}
+}
+
+// write writes b to the lineLogger's output.
+func (sl *lineLogger) write(b []byte) {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	_, _ = sl.w.Write(b)
+}
+
+// A lockedWriter is an io.Writer which serializes writes using a shared mutex.
+type lockedWriter struct {
+	mu *sync.Mutex
+	w  io.Writer
+}
+
+// Write implements io.Writer.
+func (lw *lockedWriter) Write(b []byte) (int, error) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	return lw.w.Write(b)
+}
+
+// Authentication log formats.
+const (
+	authLogConsrv  = "consrv"
+	authLogOpenSSH = "openssh"
+)
+
+// An sshdWriter is an io.Writer which prefixes each write with a syslog-style
+// timestamp, hostname, and "sshd" tag, so that lines in the OpenSSH format
+// match the lines written by sshd to its authentication log.
+type sshdWriter struct {
+	w    io.Writer
+	host string
+	pid  int
+	now  func() time.Time
+}
+
+// Write implements io.Writer.
+func (sw *sshdWriter) Write(b []byte) (int, error) {
+	prefix := fmt.Sprintf("%s %s sshd[%d]: ", sw.now().Format(time.Stamp), sw.host, sw.pid)
+	if _, err := io.WriteString(sw.w, prefix+string(b)); err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
+
+// Log modes which control how device output is transformed when logged.
+const (
+	logText   = "text"
+	logRaw    = "raw"
+	logStrip  = "strip"
+	logEscape = "escape"
+)
+
+// logModes maps each log mode to the filter applied to each line of output.
+// The raw mode does not split output into lines at all.
+var logModes = map[string]func(b []byte) []byte{
+	"":        nil,
+	logText:   nil,
+	logRaw:    nil,
+	logStrip:  stripControl,
+	logEscape: escapeControl,
+}
+
+// stripControl removes ANSI escape sequences and other control characters
+// except for tabs from b.
+func stripControl(b []byte) []byte {
+	out := make([]byte, 0, len(b))
+	for i := 0; i < len(b); i++ {
+		c := b[i]
+		switch {
+		case c == 0x1b:
+			i = skipEscape(b, i)
+		case c == '\t' || (c >= 0x20 && c != 0x7f):
+			out = append(out, c)
+		}
+	}
+
+	return out
+}
+
+// skipEscape returns the index of the final byte of the escape sequence which
+// begins at b[i].
+func skipEscape(b []byte, i int) int {
+	if i+1 >= len(b) {
+		return i
+	}
+
+	switch b[i+1] {
+	case '[':
+		// Control Sequence Introducer: parameter and intermediate bytes
+		// followed by a single final byte.
+		for j := i + 2; j < len(b); j++ {
+			if b[j] >= 0x40 && b[j] <= 0x7e {
+				return j
+			}
+		}
+
+		return len(b) - 1
+	case ']', 'P', '_', '^':
+		// Operating System Command and other strings terminated by BEL or
+		// ESC \.
+		for j := i + 2; j < len(b); j++ {
+			if b[j] == 0x07 {
+				return j
+			}
+			if b[j] == 0x1b && j+1 < len(b) && b[j+1] == '\\' {
+				return j + 1
+			}
+		}
+
+		return len(b) - 1
+	default:
+		// Two byte escape sequence.
+		return i + 1
+	}
+}
+
+// escapeControl escapes control characters, backslashes, and invalid UTF-8
+// in b so that binary output is preserved in a printable form.
+func escapeControl(b []byte) []byte {
+	out := make([]byte, 0, len(b))
+	for len(b) > 0 {
+		r, n := utf8.DecodeRune(b)
+		switch {
+		case r == utf8.RuneError && n == 1:
+			out = fmt.Appendf(out, "\\x%02x", b[0])
+		case r == '\\':
+			out = append(out, '\\', '\\')
+		case r == '\t':
+			out = append(out, '\t')
+		case r < 0x20 || r == 0x7f:
+			out = fmt.Appendf(out, "\\x%02x", r)
+		default:
+			out = append(out, b[:n]...)
+		}
+
+		b = b[n:]
+	}
+
+	return out
+}
@@ -0,0 +1,215 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mdlayher/consrv"
+)
+
+func Test_lineLogger(t *testing.T) {
+	var (
+		foo = rawDevice{Name: "foo", Device: "/dev/ttyUSB0", LogToStdout: true}
+		bar = rawDevice{Name: "bar", Serial: "DEADBEEF", LogToStdout: true, LogColor: "red"}
+	)
+
+	tests := []struct {
+		name string
+		cfg  *config
+		d    rawDevice
+		in   string
+		want string
+	}{
+		{
+			name: "single device",
+			cfg:  &config{Devices: []rawDevice{foo}},
+			d:    foo,
+			want: "hello\nworld\n",
+		},
+		{
+			name: "multiple devices",
+			cfg:  &config{Devices: []rawDevice{foo, bar}},
+			d:    foo,
+			want: "foo: hello\nfoo: world\n",
+		},
+		{
+			name: "color",
+			cfg:  &config{Devices: []rawDevice{foo, bar}},
+			d:    bar,
+			want: "\x1b[31mbar: \x1b[0mhello\n\x1b[31mbar: \x1b[0mworld\n",
+		},
+		{
+			name: "template",
+			cfg: &config{
+				Devices: []rawDevice{bar},
+				Log:     logConfig{Prefix: `{{.Time.Format "15:04:05"}} [{{.Serial}}] `},
+			},
+			d:    bar,
+			want: "\x1b[31m12:34:56 [DEADBEEF] \x1b[0mhello\n\x1b[31m12:34:56 [DEADBEEF] \x1b[0mworld\n",
+		},
+		{
+			name: "long line",
+			cfg:  &config{Devices: []rawDevice{foo, bar}},
+			d:    foo,
+			in:   strings.Repeat("a", 3*maxChunk) + "\nshort",
+			want: "foo: " + strings.Repeat("a", 3*maxChunk) + "\nfoo: short\n",
+		},
+		{
+			name: "CRLF",
+			cfg:  &config{Devices: []rawDevice{foo}},
+			d:    foo,
+			in:   "hello\r\nworld\r\n",
+			want: "hello\nworld\n",
+		},
+		{
+			name: "raw",
+			cfg: &config{
+				Devices: []rawDevice{foo, bar},
+				Log:     logConfig{Mode: logRaw},
+			},
+			d:    foo,
+			in:   "\x1b[1mhello\r\n\x00world",
+			want: "\x1b[1mhello\r\n\x00world",
+		},
+		{
+			name: "strip",
+			cfg: &config{
+				Devices: []rawDevice{foo},
+				Log:     logConfig{Mode: logStrip},
+			},
+			d:    foo,
+			in:   "\x1b[1mhello\x1b[0m\r\n\x1b]0;title\x07world\n",
+			want: "hello\nworld\n",
+		},
+		{
+			name: "escape",
+			cfg: &config{
+				Devices: []rawDevice{foo},
+				Log:     logConfig{Mode: logEscape},
+			},
+			d:    foo,
+			in:   "\x1b[1mhello\\\x00\n\xffwörld\n",
+			want: "\\x1b[1mhello\\\\\\x00\n\\xffwörld\n",
+		},
+		{
+			name: "redact",
+			cfg:  &config{Devices: []rawDevice{foo}},
+			d: rawDevice{
+				Name: "foo",
+				Redact: []redactRule{
+					{Pattern: `(password=)\S+`, Replacement: "${1}***"},
+					{Pattern: `hunter2`},
+				},
+			},
+			in:   "password=hunter2 ok\nPassword: hunter2\n",
+			want: "password=*** ok\nPassword: [redacted]\n",
+		},
+		{
+			name: "redact raw",
+			cfg: &config{
+				Devices: []rawDevice{foo},
+				Log:     logConfig{Mode: logRaw},
+			},
+			d: rawDevice{
+				Name:   "foo",
+				Redact: []redactRule{{Pattern: `hunter2`, Replacement: "*"}},
+			},
+			in:   "Password: hunter2\r\n",
+			want: "Password: *\r\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+			sl, err := newStdoutLogger(&out, tt.cfg)
+			if err != nil {
+				t.Fatalf("failed to create logger: %v", err)
+			}
+			sl.now = func() time.Time {
+				return time.Date(2024, time.January, 1, 12, 34, 56, 0, time.UTC)
+			}
+
+			in := tt.in
+			if in == "" {
+				in = "hello\nworld\n"
+			}
+
+			sl.copy(tt.d, strings.NewReader(in), log.New(io.Discard, "", 0))
+
+			if diff := cmp.Diff(tt.want, out.String()); diff != "" {
+				t.Fatalf("unexpected output (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_openLogFile(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "logs")
+	d := rawDevice{Name: "foo", LogColor: "red"}
+
+	// Simulate two runs of consrv which each append to the device's log file
+	// without color.
+	for _, s := range []string{"hello\n", "world\n"} {
+		f, err := openLogFile(dir, d.Name)
+		if err != nil {
+			t.Fatalf("failed to open log file: %v", err)
+		}
+
+		fl, err := newLineLogger(f, logConfig{}, false)
+		if err != nil {
+			t.Fatalf("failed to create logger: %v", err)
+		}
+
+		fl.copy(d, strings.NewReader(s), log.New(io.Discard, "", 0))
+		_ = f.Close()
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, "foo.log"))
+	if err != nil {
+		t.Fatalf("failed to read log file: %v", err)
+	}
+
+	if diff := cmp.Diff("hello\nworld\n", string(b)); diff != "" {
+		t.Fatalf("unexpected log file (-want +got):\n%s", diff)
+	}
+}
+
+func Test_sshdWriter(t *testing.T) {
+	var buf bytes.Buffer
+	ll := log.New(&sshdWriter{
+		w:    &buf,
+		host: "monitnerr-1",
+		pid:  1234,
+		now: func() time.Time {
+			return time.Date(2024, time.March, 5, 9, 8, 7, 0, time.UTC)
+		},
+	}, "", 0)
+
+	ll.Printf(consrv.OpenSSHAuthFormat, "Accepted", "server", "192.0.2.1", "50022", "ED25519", "SHA256:abc")
+
+	const want = "Mar  5 09:08:07 monitnerr-1 sshd[1234]: Accepted publickey for server from 192.0.2.1 port 50022 ssh2: ED25519 SHA256:abc\n"
+	if diff := cmp.Diff(want, buf.String()); diff != "" {
+		t.Fatalf("unexpected log line (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,219 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"runtime"
+	"slices"
+	"time"
+
+	"github.com/mdlayher/consrv"
+)
+
+// loginConfig contains the configuration for a device's automatic login.
+type loginConfig struct {
+	User            string   `toml:"user"`
+	PasswordFile    string   `toml:"password_file"`
+	Identities      []string `toml:"identities"`
+	LoginPattern    string   `toml:"login_pattern"`
+	PasswordPattern string   `toml:"password_pattern"`
+}
+
+// Default patterns for login and password prompts.
+const (
+	defaultLoginPattern    = `login: ?$`
+	defaultPasswordPattern = `[Pp]assword: ?$`
+)
+
+// validate verifies the login configuration for device, and sets defaults.
+func (lc *loginConfig) validate(device string, validIDs map[string]struct{}) error {
+	if lc.User == "" || lc.PasswordFile == "" {
+		return fmt.Errorf("device %q login must have a user and password file", device)
+	}
+
+	// Logging in must be granted to specific identities.
+	if len(lc.Identities) == 0 {
+		return fmt.Errorf("device %q login must have identities", device)
+	}
+	for _, id := range lc.Identities {
+		if _, ok := validIDs[id]; !ok {
+			return fmt.Errorf("device %q login is configured with unknown identity %q", device, id)
+		}
+	}
+
+	if lc.LoginPattern == "" {
+		lc.LoginPattern = defaultLoginPattern
+	}
+	if lc.PasswordPattern == "" {
+		lc.PasswordPattern = defaultPasswordPattern
+	}
+	for _, s := range []string{lc.LoginPattern, lc.PasswordPattern} {
+		if _, err := regexp.Compile(s); err != nil {
+			return fmt.Errorf("device %q login has invalid pattern: %v", device, err)
+		}
+	}
+
+	return nil
+}
+
+// readPassword reads a password from a file which must only be accessible by
+// its owner.
+func readPassword(file string) ([]byte, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if runtime.GOOS != "windows" && fi.Mode().Perm()&0o077 != 0 {
+		return nil, fmt.Errorf("password file %q must not be accessible by group or others, but has mode %s", file, fi.Mode().Perm())
+	}
+
+	b, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes.TrimRight(b, "\r\n"), nil
+}
+
+// loginTimeout bounds the time spent logging in.
+const loginTimeout = 10 * time.Second
+
+// A loginer logs in to a device's operating system when a permitted identity
+// attaches to it.
+type loginer struct {
+	name       string
+	user       []byte
+	password   []byte
+	identities []string
+	login      *regexp.Regexp
+	pass       *regexp.Regexp
+	w          io.Writer
+	attachFn   func(ctx context.Context) io.Reader
+	timeout    time.Duration
+	ll         *log.Logger
+}
+
+// newLoginer creates a loginer for device d from its configuration.
+func newLoginer(d rawDevice, mux *consrv.MuxDevice, ll *log.Logger) (*loginer, error) {
+	lc := d.Login
+
+	pass, err := readPassword(lc.PasswordFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &loginer{
+		name:       d.Name,
+		user:       []byte(lc.User),
+		password:   pass,
+		identities: lc.Identities,
+		// Validated when parsing the configuration.
+		login:    regexp.MustCompile(lc.LoginPattern),
+		pass:     regexp.MustCompile(lc.PasswordPattern),
+		w:        mux,
+		attachFn: mux.Attach,
+		timeout:  loginTimeout,
+		ll:       ll,
+	}, nil
+}
+
+// attach logs in when a permitted identity attaches to the device. If the
+// device does not present a login prompt, such as when a user is already
+// logged in, it does nothing.
+func (l *loginer) attach(ctx context.Context, info consrv.SessionInfo) {
+	if !slices.Contains(l.identities, info.Identity) {
+		return
+	}
+
+	// Attach before prompting so no output is missed. The reader returns
+	// io.EOF when the timeout expires.
+	ctx, cancel := context.WithTimeout(ctx, l.timeout)
+	defer cancel()
+
+	err := l.run(l.attachFn(ctx))
+	switch {
+	case err == nil:
+		l.ll.Printf("logged in to %q as %q for %q", l.name, l.user, info.Identity)
+	case errors.Is(err, io.EOF) && ctx.Err() != nil:
+		l.ll.Printf("not logging in to %q for %q: no login prompt", l.name, info.Identity)
+	default:
+		errorf(l.ll, "failed to log in to %q for %q: %v", l.name, info.Identity, err)
+	}
+}
+
+// run performs the login sequence using output read from r.
+func (l *loginer) run(r io.Reader) error {
+	// Request a fresh prompt, then answer the login and password prompts.
+	steps := []struct {
+		send   []byte
+		expect *regexp.Regexp
+	}{
+		{send: []byte("\r"), expect: l.login},
+		{send: slices.Concat(l.user, []byte("\r")), expect: l.pass},
+		{send: slices.Concat(l.password, []byte("\r"))},
+	}
+
+	for _, s := range steps {
+		if _, err := l.w.Write(s.send); err != nil {
+			return err
+		}
+		if s.expect == nil {
+			continue
+		}
+
+		if err := expect(r, s.expect); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// expect reads output from r until a line or prompt matches re, or r returns
+// an error.
+func expect(r io.Reader, re *regexp.Regexp) error {
+	var (
+		lb lineBuffer
+		b  = make([]byte, 4096)
+	)
+
+	for {
+		n, err := r.Read(b)
+
+		var matched bool
+		lb.write(b[:n], func(line []byte, _ bool) {
+			matched = matched || re.Match(line)
+		})
+		if matched {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
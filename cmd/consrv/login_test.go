@@ -0,0 +1,167 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mdlayher/consrv"
+)
+
+func Test_loginerAttach(t *testing.T) {
+	tests := []struct {
+		name     string
+		identity string
+		replies  map[string]string
+		want     string
+	}{
+		{
+			name:     "not permitted",
+			identity: "other",
+			replies:  map[string]string{"\r": "\r\nserver login: "},
+		},
+		{
+			name:     "logged in",
+			identity: "test",
+			replies:  map[string]string{"\r": "\r\n[root@server:~]# "},
+			want:     "\r",
+		},
+		{
+			name:     "OK",
+			identity: "test",
+			replies: map[string]string{
+				"\r":     "\r\nserver login: ",
+				"root\r": "root\r\nPassword: ",
+			},
+			want: "\rroot\rhunter2\r",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &scriptDevice{
+				replies: tt.replies,
+				readC:   make(chan []byte, 8),
+			}
+			mux := consrv.NewMuxDevice(d)
+			defer mux.Close()
+
+			var out bytes.Buffer
+			l := &loginer{
+				name:       "server",
+				user:       []byte("root"),
+				password:   []byte("hunter2"),
+				identities: []string{"test"},
+				login:      regexp.MustCompile(defaultLoginPattern),
+				pass:       regexp.MustCompile(defaultPasswordPattern),
+				w:          mux,
+				attachFn:   mux.Attach,
+				timeout:    100 * time.Millisecond,
+				ll:         log.New(&out, "", 0),
+			}
+
+			l.attach(context.Background(), consrv.SessionInfo{
+				Device:   "server",
+				Identity: tt.identity,
+				Addr:     &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1)},
+			})
+			t.Log(out.String())
+
+			if diff := cmp.Diff(tt.want, d.written()); diff != "" {
+				t.Fatalf("unexpected device input (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_readPassword(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("skipping, file permissions are not checked on Windows")
+	}
+
+	dir := t.TempDir()
+	for file, mode := range map[string]os.FileMode{"ok": 0o600, "open": 0o644} {
+		if err := os.WriteFile(filepath.Join(dir, file), []byte("hunter2\n"), mode); err != nil {
+			t.Fatalf("failed to write password: %v", err)
+		}
+	}
+
+	got, err := readPassword(filepath.Join(dir, "ok"))
+	if err != nil {
+		t.Fatalf("failed to read password: %v", err)
+	}
+	if diff := cmp.Diff("hunter2", string(got)); diff != "" {
+		t.Fatalf("unexpected password (-want +got):\n%s", diff)
+	}
+
+	if _, err := readPassword(filepath.Join(dir, "open")); err == nil {
+		t.Fatal("expected an error for a password file readable by others, but none occurred")
+	}
+}
+
+// A scriptDevice is a consrv.Device which replies to writes according to a
+// script.
+type scriptDevice struct {
+	replies map[string]string
+	readC   chan []byte
+
+	mu sync.Mutex
+	w  bytes.Buffer
+}
+
+func (d *scriptDevice) Read(b []byte) (int, error) {
+	r, ok := <-d.readC
+	if !ok {
+		return 0, io.EOF
+	}
+
+	return copy(b, r), nil
+}
+
+func (d *scriptDevice) Write(b []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.w.Write(b)
+	if r, ok := d.replies[string(b)]; ok {
+		d.readC <- []byte(r)
+	}
+
+	return len(b), nil
+}
+
+func (d *scriptDevice) Close() error {
+	close(d.readC)
+	return nil
+}
+
+func (d *scriptDevice) String() string { return "script" }
+
+func (d *scriptDevice) written() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.w.String()
+}
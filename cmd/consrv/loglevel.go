@@ -0,0 +1,210 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"github.com/mdlayher/consrv"
+)
+
+// A level is the severity of a log message.
+type level int32
+
+// Possible levels, from least to most verbose.
+const (
+	levelError level = iota
+	levelInfo
+	levelDebug
+	levelTrace
+)
+
+// levels maps the names of levels to their values.
+var levels = map[string]level{
+	"error": levelError,
+	"info":  levelInfo,
+	"debug": levelDebug,
+	"trace": levelTrace,
+}
+
+// String returns the name of a level.
+func (l level) String() string {
+	for k, v := range levels {
+		if v == l {
+			return k
+		}
+	}
+
+	return fmt.Sprintf("level(%d)", l)
+}
+
+// A logLevel is the most verbose level of messages which are logged, and may be
+// changed at runtime.
+type logLevel struct {
+	v atomic.Int32
+}
+
+// newLogLevel creates a logLevel at the info level.
+func newLogLevel() *logLevel {
+	var lv logLevel
+	lv.set(levelInfo)
+	return &lv
+}
+
+// get returns the current level.
+func (lv *logLevel) get() level { return level(lv.v.Load()) }
+
+// set sets the current level.
+func (lv *logLevel) set(l level) { lv.v.Store(int32(l)) }
+
+// enabled reports whether messages at l are logged.
+func (lv *logLevel) enabled(l level) bool { return l <= lv.get() }
+
+// debugf logs a message with ll if the debug level is enabled.
+func (lv *logLevel) debugf(ll *log.Logger, format string, v ...any) {
+	if lv.enabled(levelDebug) {
+		ll.Printf(format, v...)
+	}
+}
+
+// A levelWriter is the output of consrv's log.Logger. At the error level, it
+// discards all messages, and errors are instead logged with errorf or fatalf.
+type levelWriter struct {
+	w  io.Writer
+	lv *logLevel
+}
+
+// Write implements io.Writer.
+func (lw *levelWriter) Write(b []byte) (int, error) {
+	if !lw.lv.enabled(levelInfo) {
+		return len(b), nil
+	}
+
+	return lw.w.Write(b)
+}
+
+// errorLogger returns a log.Logger which writes to the same output as ll, but
+// is not subject to the log level.
+func errorLogger(ll *log.Logger) *log.Logger {
+	w := ll.Writer()
+	if lw, ok := w.(*levelWriter); ok {
+		w = lw.w
+	}
+
+	return log.New(w, ll.Prefix(), ll.Flags())
+}
+
+// errorf logs a message at the error level, which is always logged.
+func errorf(ll *log.Logger, format string, v ...any) {
+	_ = errorLogger(ll).Output(2, fmt.Sprintf(format, v...))
+}
+
+// fatalf logs a message at the error level and exits.
+func fatalf(ll *log.Logger, format string, v ...any) {
+	_ = errorLogger(ll).Output(2, fmt.Sprintf(format, v...))
+	os.Exit(1)
+}
+
+// ServeHTTP implements http.Handler to read or change the level at runtime:
+//
+//	GET /loglevel
+//	PUT /loglevel (body: error, info, debug, or trace)
+func (lv *logLevel) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+	if r.Method == http.MethodPut {
+		b, err := io.ReadAll(io.LimitReader(r.Body, 64))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		l, ok := levels[strings.TrimSpace(string(b))]
+		if !ok {
+			http.Error(w, fmt.Sprintf("unknown log level %q", bytes.TrimSpace(b)), http.StatusBadRequest)
+			return
+		}
+
+		lv.set(l)
+	}
+
+	_, _ = fmt.Fprintln(w, lv.get())
+}
+
+var _ consrv.Device = &traceDevice{}
+
+// A traceDevice is a consrv.Device which logs all of its input and output at
+// the trace level. Output is redacted like device logs, so it is only logged
+// once a line is complete if the device has redaction rules. Input is not
+// logged for devices with redaction rules or automatic login, because
+// keystrokes such as passwords can't be reliably redacted.
+type traceDevice struct {
+	consrv.Device
+	name       string
+	reads      lineRedactor
+	hideWrites bool
+	lv         *logLevel
+	ll         *log.Logger
+}
+
+// newTraceDevice creates a traceDevice for d which wraps dev.
+func newTraceDevice(d rawDevice, dev consrv.Device, lv *logLevel, ll *log.Logger) *traceDevice {
+	rd := newRedactor(d.Redact)
+	return &traceDevice{
+		Device:     dev,
+		name:       d.Name,
+		reads:      lineRedactor{rd: rd},
+		hideWrites: rd != nil || d.Login != nil,
+		lv:         lv,
+		ll:         ll,
+	}
+}
+
+// Read implements io.ReadWriteCloser.
+func (d *traceDevice) Read(b []byte) (int, error) {
+	n, err := d.Device.Read(b)
+	if d.lv.enabled(levelTrace) && (n > 0 || err != nil) {
+		d.ll.Printf("%s: trace: read %d bytes: %q, err: %v", d.name, n, d.reads.redact(b[:n]), err)
+	}
+
+	return n, err
+}
+
+// Write implements io.ReadWriteCloser.
+func (d *traceDevice) Write(b []byte) (int, error) {
+	n, err := d.Device.Write(b)
+	if d.lv.enabled(levelTrace) {
+		if d.hideWrites {
+			d.ll.Printf("%s: trace: wrote %d bytes, err: %v", d.name, n, err)
+		} else {
+			d.ll.Printf("%s: trace: wrote %d bytes: %q, err: %v", d.name, n, b[:n], err)
+		}
+	}
+
+	return n, err
+}
+
+// connected implements connector for devices which reconnect, and otherwise
+// reports that the device is connected.
+func (d *traceDevice) connected() bool {
+	c, ok := d.Device.(connector)
+	return !ok || c.connected()
+}
@@ -0,0 +1,173 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_levelWriter(t *testing.T) {
+	tests := []struct {
+		name string
+		l    level
+		want string
+	}{
+		{
+			name: "error",
+			l:    levelError,
+			want: "failed to open device\n",
+		},
+		{
+			name: "info",
+			l:    levelInfo,
+			want: "starting SSH server\nfailed to open device\nerror-free message\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lv := newLogLevel()
+			lv.set(tt.l)
+
+			var b bytes.Buffer
+			ll := log.New(&levelWriter{w: &b, lv: lv}, "", 0)
+			ll.Print("starting SSH server")
+			errorf(ll, "failed to open device")
+			// Messages are only logged at the error level by errorf, not by
+			// matching their text.
+			ll.Print("error-free message")
+
+			if diff := cmp.Diff(tt.want, b.String()); diff != "" {
+				t.Fatalf("unexpected output (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_logLevelServeHTTP(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		code   int
+		want   string
+		level  level
+	}{
+		{
+			name:   "get",
+			method: http.MethodGet,
+			code:   http.StatusOK,
+			want:   "info\n",
+			level:  levelInfo,
+		},
+		{
+			name:   "put",
+			method: http.MethodPut,
+			body:   "trace\n",
+			code:   http.StatusOK,
+			want:   "trace\n",
+			level:  levelTrace,
+		},
+		{
+			name:   "bad put",
+			method: http.MethodPut,
+			body:   "verbose",
+			code:   http.StatusBadRequest,
+			want:   "unknown log level \"verbose\"\n",
+			level:  levelInfo,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lv := newLogLevel()
+
+			w := httptest.NewRecorder()
+			lv.ServeHTTP(w, httptest.NewRequest(tt.method, "/loglevel", strings.NewReader(tt.body)))
+
+			if diff := cmp.Diff(tt.code, w.Code); diff != "" {
+				t.Fatalf("unexpected status code (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tt.want, w.Body.String()); diff != "" {
+				t.Fatalf("unexpected body (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tt.level, lv.get()); diff != "" {
+				t.Fatalf("unexpected level (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_traceDevice(t *testing.T) {
+	tests := []struct {
+		name string
+		d    rawDevice
+		want string
+	}{
+		{
+			name: "OK",
+			d:    rawDevice{Name: "server"},
+			want: "server: trace: wrote 4 bytes: \"ping\", err: <nil>\nserver: trace: read 5 bytes: \"pong\\n\", err: <nil>\n",
+		},
+		{
+			name: "redact",
+			d: rawDevice{
+				Name:   "server",
+				Redact: []redactRule{{Pattern: "pong"}},
+			},
+			want: "server: trace: wrote 4 bytes, err: <nil>\nserver: trace: read 5 bytes: \"[redacted]\\n\", err: <nil>\n",
+		},
+		{
+			name: "login",
+			d: rawDevice{
+				Name:  "server",
+				Login: &loginConfig{},
+			},
+			want: "server: trace: wrote 4 bytes, err: <nil>\nserver: trace: read 5 bytes: \"pong\\n\", err: <nil>\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lv := newLogLevel()
+
+			var b bytes.Buffer
+			dev := &scriptDevice{
+				replies: map[string]string{"ping": "pong\n"},
+				readC:   make(chan []byte, 1),
+			}
+			d := newTraceDevice(tt.d, dev, lv, log.New(&b, "", 0))
+
+			// Nothing is logged until the trace level is enabled.
+			_, _ = io.WriteString(d, "ping")
+			_, _ = d.Read(make([]byte, 8))
+
+			lv.set(levelTrace)
+			_, _ = io.WriteString(d, "ping")
+			_, _ = d.Read(make([]byte, 8))
+
+			if diff := cmp.Diff(tt.want, b.String()); diff != "" {
+				t.Fatalf("unexpected trace (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -16,19 +16,26 @@
 package main
 
 import (
-	"bufio"
+	"bytes"
+	"cmp"
 	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"maps"
 	"net"
 	"net/http"
 	"net/http/pprof"
 	"os"
+	"path/filepath"
+	rdebug "runtime/debug"
+	"slices"
 	"strconv"
-	"sync"
 	"time"
 
+	"github.com/mdlayher/consrv"
 	"github.com/mdlayher/metricslite"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/collectors"
@@ -46,8 +53,15 @@ func main() {
 		c            = flag.String("c", "consrv.toml", "path to consrv.toml configuration file")
 		k            = flag.String("k", "host_key", "path to OpenSSH format host key file")
 		mustPrivdrop = flag.Bool("experimental-drop-privileges", false, "[EXPERIMENTAL] run as an unprivileged process and chroot to an empty dir")
+		mustSandbox  = flag.Bool("experimental-landlock", false, "[EXPERIMENTAL] restrict filesystem access with Landlock and drop capabilities, without chroot or setuid")
+		mustBroker   = flag.Bool("experimental-broker", false, "[EXPERIMENTAL] open devices in a privileged broker and serve SSH from an unprivileged child process")
+		container    = flag.Bool("container", false, "verify devices are passed through to a container and tolerate an unmounted /sys")
+		chaos        = flag.Duration(chaosFlag, 0, "simulate device errors at roughly this interval, to verify monitoring and reconnection")
+		selftest     = flag.String("selftest", "", "verify a serial port or configured device with a loopback plug attached at several baud rates, and exit")
+		generateUdev = flag.Bool("generate-udev", false, "print udev rules which create /dev/consrv/<name> symlinks for devices configured by serial or USB path, and exit")
 	)
 
+	flag.Usage = usage
 	flag.Parse()
 
 	cfgFilePaths := []string{
@@ -63,45 +77,315 @@ func main() {
 		"host_key",
 	}
 
-	ll := log.New(os.Stderr, "", log.LstdFlags)
+	lv := newLogLevel()
+	ll := log.New(&levelWriter{w: os.Stderr, lv: lv}, "", log.LstdFlags)
 
-	var cfg *config
+	if os.Getenv(brokerEnv) != "" {
+		// This process is the unprivileged child of a broker, which provides
+		// all of the configuration and listeners.
+		runBrokerChild(lv, ll)
+		return
+	}
+
+	var n int
+	for _, b := range []bool{*mustPrivdrop, *mustSandbox, *mustBroker} {
+		if b {
+			n++
+		}
+	}
+	if n > 1 {
+		fatalf(ll, "-experimental-drop-privileges, -experimental-landlock, and -experimental-broker are mutually exclusive")
+	}
+
+	var (
+		cfg    *config
+		rawCfg []byte
+	)
 	for _, cfgFile := range cfgFilePaths {
-		f, err := os.Open(cfgFile)
+		b, err := os.ReadFile(cfgFile)
 		if os.IsNotExist(err) {
 			continue
 		}
 		if err != nil {
-			ll.Fatalf("failed to open config file: %v", err)
+			fatalf(ll, "failed to open config file: %v", err)
 		}
-		defer f.Close()
 		ll.Printf("loading configuration from %s", cfgFile)
 
-		cfg, err = parseConfig(f)
+		raw := b
+		b, err = loadFragment(b)
+		if err != nil {
+			fatalf(ll, "failed to load config: %v", err)
+		}
+
+		cfg, err = parseConfig(bytes.NewReader(b))
 		if err != nil {
-			ll.Fatalf("failed to parse config: %v", err)
+			fatalf(ll, "failed to parse config: %v", err)
 		}
-		_ = f.Close()
+		cfg.Raw = raw
+		rawCfg = b
 		break
 	}
+	if *selftest != "" {
+		// The device may be a path rather than a configured device, so no
+		// configuration file is required.
+		os.Exit(runSelfTest(*selftest, cfg, ll, os.Stdout))
+	}
 	if cfg == nil {
-		ll.Fatalf("no config file could be opened")
+		fatalf(ll, "no config file could be opened")
+	}
+	if *generateUdev {
+		if err := writeUdevRules(os.Stdout, cfg.Devices); err != nil {
+			fatalf(ll, "failed to write udev rules: %v", err)
+		}
+		return
 	}
 
-	var hostKey []byte
-	for _, keyFile := range keyFilePaths {
-		var err error
-		hostKey, err = os.ReadFile(keyFile)
+	// Bound the Go runtime's memory use before any devices are opened, unless
+	// the environment already does.
+	if cfg.Memory.Limit > 0 {
+		if os.Getenv("GOMEMLIMIT") != "" {
+			ll.Printf("ignoring memory limit, GOMEMLIMIT is set")
+		} else {
+			rdebug.SetMemoryLimit(cfg.Memory.Limit)
+			ll.Printf("set memory limit to %d bytes", cfg.Memory.Limit)
+		}
+	}
+
+	var hk hostKey
+	for _, f := range keyFilePaths {
+		b, err := os.ReadFile(f)
 		if os.IsNotExist(err) {
 			continue
 		}
 		if err != nil {
-			ll.Fatalf("failed to read SSH host key: %v", err)
+			fatalf(ll, "failed to read SSH host key: %v", err)
+		}
+		ll.Printf("loading host key from %s", f)
+
+		pass, err := newPassphraseSource(cfg.Server.HostKeyPassphraseFile).passphrase(b)
+		if err != nil {
+			fatalf(ll, "failed to load SSH host key: %v", err)
+		}
+
+		hk = hostKey{
+			PEM:        b,
+			Passphrase: pass,
+			File:       f,
 		}
-		ll.Printf("loading host key from %s", keyFile)
 		break
 	}
 
+	if *chaos > 0 {
+		if *mustBroker {
+			fatalf(ll, "-%s is not supported with -experimental-broker", chaosFlag)
+		}
+
+		ll.Printf("WARNING: -%s is set, devices will fail every %s", chaosFlag, *chaos)
+		cfg.SimulateDeviceErrors = *chaos
+	}
+
+	if *mustPrivdrop {
+		if err := cfg.checkDropPrivileges(); err != nil {
+			fatalf(ll, "%v", err)
+		}
+	}
+	if cfg.Stats.Path != "" && (*mustPrivdrop || *mustBroker) {
+		fatalf(ll, "statistics persistence is not supported when dropping privileges")
+	}
+	if cfg.Enumeration != nil && (*mustPrivdrop || *mustBroker) {
+		fatalf(ll, "periodic enumeration is not supported when dropping privileges")
+	}
+	if (cfg.Log.Directory != "" || cfg.Server.AuthLog != "" || (cfg.Server.Honeypot != nil && cfg.Server.Honeypot.Log != "")) && *mustBroker {
+		fatalf(ll, "logging to files is not supported with -experimental-broker")
+	}
+	if cfg.MDNS.Enabled && *mustBroker {
+		fatalf(ll, "mDNS advertisement is not supported with -experimental-broker")
+	}
+	if cfg.Debug.AdminTokenFile != "" && *mustBroker {
+		fatalf(ll, "the debug admin token file is not supported with -experimental-broker")
+	}
+	if cfg.Debug.Push != nil && *mustBroker {
+		fatalf(ll, "pushing metrics is not supported with -experimental-broker")
+	}
+	if cfg.Standby != nil && *mustBroker {
+		fatalf(ll, "hot-standby is not supported with -experimental-broker")
+	}
+	if len(cfg.Listeners) > 0 && *mustBroker {
+		fatalf(ll, "additional SSH listeners are not supported with -experimental-broker")
+	}
+	if len(cfg.Remotes) > 0 && *mustBroker {
+		fatalf(ll, "remotes are not supported with -experimental-broker")
+	}
+	for _, d := range cfg.Devices {
+		if d.Login != nil && *mustBroker {
+			fatalf(ll, "automatic login is not supported with -experimental-broker")
+		}
+		if d.Address != "" && *mustBroker {
+			fatalf(ll, "network-attached devices are not supported with -experimental-broker")
+		}
+		if d.GDB != nil && *mustBroker {
+			fatalf(ll, "GDB passthrough is not supported with -experimental-broker")
+		}
+		if d.PTY != nil && *mustBroker {
+			fatalf(ll, "local pseudo-terminals are not supported with -experimental-broker")
+		}
+		if d.ZModem != nil && d.ZModem.Spool != "" && *mustBroker {
+			fatalf(ll, "ZMODEM spooling is not supported with -experimental-broker")
+		}
+	}
+	for _, d := range cfg.Devices {
+		if d.Watchdog != nil && len(d.Watchdog.Command) > 0 && n > 0 {
+			fatalf(ll, "watchdog commands are not supported when dropping privileges or sandboxing")
+		}
+		if d.Tee != nil && n > 0 {
+			fatalf(ll, "tee commands are not supported when dropping privileges or sandboxing")
+		}
+		if d.Hooks != nil && n > 0 {
+			fatalf(ll, "session hooks are not supported when dropping privileges or sandboxing")
+		}
+	}
+	if len(cfg.Server.AuthExec) > 0 && n > 0 {
+		fatalf(ll, "authentication commands are not supported when dropping privileges or sandboxing")
+	}
+
+	sysfs := true
+	if *container {
+		// Produce actionable errors for devices which were not passed through
+		// to the container, rather than failing on the first device.
+		ok, err := checkContainer(cfg.Devices, ll)
+		if err != nil {
+			fatalf(ll, "failed to verify container devices:\n%v", err)
+		}
+		sysfs = ok
+	}
+
+	if cfg.Provisioning != nil && (*mustBroker || *mustPrivdrop) {
+		// The fragment can't be written from an empty chroot.
+		fatalf(ll, "provisioning is not supported with -experimental-broker or -experimental-drop-privileges")
+	}
+
+	// A graceful upgrade hands over the listeners and serial ports of the
+	// previous process.
+	us, err := loadUpgrade()
+	if err != nil {
+		fatalf(ll, "failed to upgrade: %v", err)
+	}
+
+	var sshl, httpl net.Listener
+	if us != nil {
+		sshl, httpl, err = us.listeners()
+		if err != nil {
+			fatalf(ll, "failed to upgrade: %v", err)
+		}
+		ll.Printf("upgraded: inherited listeners and %d serial ports", len(us.Devices))
+	} else {
+		sshl, httpl = listen(cfg, ll)
+	}
+
+	if *mustBroker {
+		// Experimental: keep this process privileged only to open devices, and
+		// serve everything else from an unprivileged child process.
+		if err := runBroker(cfg, rawCfg, hk, sshl, httpl, sysfs, ll); err != nil {
+			fatalf(ll, "failed to run broker: %v", err)
+		}
+		return
+	}
+
+	fs, err := newFS(ll, sysfs, cfg.maskSerials())
+	if err != nil {
+		fatalf(ll, "failed to open filesystem: %v", err)
+	}
+	if us != nil {
+		fs.openPort = us.openPort(fs.openPort)
+	}
+
+	if n > 0 {
+		// Missing device paths can't be opened later once privileges are
+		// dropped or filesystem access is restricted.
+		fs.watch = nil
+	}
+	fs.chrooted = *mustPrivdrop
+
+	restrict := func(paths []string) {
+		if us != nil {
+			// Every device which will be used has been opened.
+			us.closeUnused()
+		}
+
+		if *mustPrivdrop {
+			// Experimental: drop privileges now that we're done reading
+			// configuration and opening possibly privileged TCP listeners.
+			info, err := dropPrivileges()
+			if err != nil {
+				fatalf(ll, "failed to drop privileges: %v", err)
+			}
+
+			ll.Printf("dropped privileges: chroot: %q, UID: %d GID: %d, seccomp: %t",
+				info.Chroot, info.UID, info.GID, info.Seccomp)
+		}
+
+		if *mustSandbox {
+			// Experimental: restrict filesystem access to devices and
+			// statistics for environments such as containers where chroot is
+			// not possible.
+			info, err := sandbox(paths)
+			if err != nil {
+				fatalf(ll, "failed to sandbox: %v", err)
+			}
+
+			ll.Printf("sandboxed: Landlock ABI: %d, paths: %q", info.LandlockABI, info.Paths)
+		}
+	}
+
+	// The binary can't be executed again from an empty chroot.
+	var up *upgrader
+	if !*mustPrivdrop {
+		up = &upgrader{sshl: sshl, httpl: httpl, ll: ll}
+	}
+	run(cfg, hk, fs, sshl, httpl, restrict, up, lv, ll)
+}
+
+// listen opens the SSH server listener and optional HTTP debug server listener.
+func listen(cfg *config, ll *log.Logger) (sshl, httpl net.Listener) {
+	sshl, err := net.Listen("tcp", cfg.Server.Address)
+	if err != nil {
+		fatalf(ll, "failed to listen for SSH server: %v", err)
+	}
+
+	if cfg.Debug.Address != "" {
+		l, err := net.Listen("tcp", cfg.Debug.Address)
+		if err != nil {
+			fatalf(ll, "failed to listen for HTTP debug server: %v", err)
+		}
+		httpl = l
+	}
+
+	return sshl, httpl
+}
+
+// run opens the configured devices using fs and serves SSH and optional HTTP
+// debug connections on the input listeners until a fatal error occurs. If
+// the host key has a file, the host key is reloaded from it on SIGHUP. If
+// restrict is not nil, it is invoked with the paths consrv needs access to
+// after the devices are opened and before serving any connections. If up is
+// not nil, the process is upgraded by it on request.
+func run(
+	cfg *config,
+	hk hostKey,
+	fs *fs,
+	sshl, httpl net.Listener,
+	restrict func(paths []string),
+	up *upgrader,
+	lv *logLevel,
+	ll *log.Logger,
+) {
+	if cfg.Server.LogLevel != "" {
+		lv.set(levels[cfg.Server.LogLevel])
+	}
+
+	// Expand any device template in auto mode now that devices are enumerated.
+	cfg.Devices = fs.expand(cfg.Devices, ll)
+
 	// Set up Prometheus metrics for the server.
 	reg := prometheus.NewPedanticRegistry()
 	reg.MustRegister(
@@ -110,108 +394,775 @@ func main() {
 		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
 	)
 
-	mm := newMetrics(metricslite.NewPrometheus(reg))
+	var mi metricslite.Interface = metricslite.NewPrometheus(reg)
+
+	// Optionally load statistics persisted by previous runs so long-term usage
+	// counters survive restarts.
+	var st *stats
+	if cfg.Stats.Path != "" {
+		var err error
+		st, err = loadStats(cfg.Stats.Path)
+		if err != nil {
+			fatalf(ll, "failed to load statistics: %v", err)
+		}
+
+		names := make([]string, 0, len(cfg.Devices))
+		for _, d := range cfg.Devices {
+			names = append(names, d.Name)
+		}
+		mi = persist(mi, st, names)
+
+		ll.Printf("loaded statistics from %s [restarts: %d]", cfg.Stats.Path, st.restarts())
+	}
+
+	mm := newMetrics(mi)
+	if st != nil {
+		mm.restarts(float64(st.restarts()))
+	}
 
 	// Create device mappings from the configuration file and open the serial
 	// devices for the duration of the program's run.
-	devices := make(map[string]*muxDevice, len(cfg.Devices))
-	fs, err := newFS(ll)
+	devices := make(map[string]*consrv.MuxDevice, len(cfg.Devices))
+	metadata := make(map[string]map[string]string)
+	// Paths are the resolved path or address of each device.
+	paths := make(map[string]string)
+	approval := make(map[string]bool)
+	reason := make(map[string]bool)
+	readOnly := make(map[string]bool)
+
+	stdout, err := newStdoutLogger(os.Stdout, cfg)
 	if err != nil {
-		ll.Fatalf("failed to open filesystem: %v", err)
+		fatalf(ll, "failed to configure stdout logging: %v", err)
+	}
+
+	// Track the paths consrv needs access to after it has initialized, so they
+	// can be permitted when sandboxing.
+	var sandboxPaths []string
+	if cfg.Stats.Path != "" {
+		sandboxPaths = append(sandboxPaths, filepath.Dir(cfg.Stats.Path))
+	}
+	if cfg.Log.Directory != "" {
+		sandboxPaths = append(sandboxPaths, cfg.Log.Directory)
+	}
+	if hk.File != "" {
+		sandboxPaths = append(sandboxPaths, hk.File)
+	}
+	if cfg.Provisioning != nil {
+		sandboxPaths = append(sandboxPaths, filepath.Dir(cfg.Provisioning.Fragment))
+	}
+
+	// Output queued for slow consumers shares a single buffer budget across
+	// all devices.
+	qc := newQueueConfig(cfg.Memory)
+
+	captures := make(captureHandler)
+	parks := make(map[string]*parkDevice)
+	serials := make(map[string]rawDevice)
+	loginers := make(map[string]*loginer)
+	hooks := make(map[string]*sessionHooks)
+	logFiles := make(map[string]*logFile)
+	gdbs := make(map[*gdbServer]net.Listener)
+
+	// Network-attached devices are shared with a hot-standby peer, if any, and
+	// are only connected while this host holds the lease.
+	var (
+		ls  *lease
+		lsl net.Listener
+	)
+	if cfg.Standby != nil {
+		ls = newLease(*cfg.Standby, lv, ll)
+		lsl, err = net.Listen("tcp", cfg.Standby.Address)
+		if err != nil {
+			fatalf(ll, "failed to listen for hot-standby peer: %v", err)
+		}
 	}
 
-	numLogToStdout := 0
 	for _, d := range cfg.Devices {
+		var dev consrv.Device
+		if d.Address != "" {
+			dev = newTCPDevice(d, ls, mm.deviceReadBytes, mm.deviceWriteBytes, ll)
+		} else {
+			dev, err = fs.openSerial(&d, mm.deviceReadBytes, mm.deviceWriteBytes)
+			if err != nil {
+				fatalf(ll, "failed to add device %q: %v", d.Name, err)
+			}
+			sandboxPaths = append(sandboxPaths, d.Device)
+
+			// Serial ports may be parked for use by external tools, and are
+			// reopened when they resume.
+			if !fs.chrooted {
+				rd := d
+				pd := newParkDevice(d, dev, func() (consrv.Device, error) {
+					return fs.openSerial(&rd, mm.deviceReadBytes, mm.deviceWriteBytes)
+				}, ll)
+				parks[d.Name] = pd
+				serials[d.Name] = rd
+				dev = pd
+			}
+		}
+
+		if cfg.SimulateDeviceErrors > 0 {
+			dev = newChaosDevice(dev, d.Name, cfg.SimulateDeviceErrors, ll)
+		}
+
+		// Devices which can be reopened, such as a USB serial adapter which is
+		// unplugged, are reconnected when a read fails.
+		mc := consrv.MuxConfig{ReadSize: d.ReadSize}
+		if rc, ok := dev.(reconnector); ok {
+			mc.OnError = rc.reconnect
+		}
+
+		if d.Encoding != "" {
+			dev = newEncodingDevice(dev, d.Encoding)
+		}
+		if d.Keymap != nil {
+			dev = newKeymapDevice(dev, d.Keymap)
+		}
+		if d.WriteCoalesce.Duration > 0 {
+			dev = newCoalesceDevice(dev, d.WriteCoalesce.Duration)
+		}
+
+		ll.Printf("configured device %s [log: %t, file: %t]", dev, d.LogToStdout, cfg.Log.Directory != "")
+
+		mux := consrv.NewMuxDeviceConfig(
+			newTraceDevice(d, newErrorDevice(dev, d.Name, mm), lv, ll),
+			mc,
+		)
+		devices[d.Name] = mux
+		if d.RequireApproval {
+			approval[d.Name] = true
+		}
+		if d.RequireReason {
+			reason[d.Name] = true
+		}
+		if d.ReadOnly {
+			readOnly[d.Name] = true
+		}
+		md := fs.metadata[d.Device]
+		if len(md) > 0 {
+			metadata[d.Name] = md
+		}
+		paths[d.Name] = cmp.Or(d.Device, d.Address)
+		mm.deviceInfo(1.0, d.Name, d.Namespace, cmp.Or(d.Device, d.Address), fs.serial(d.Serial), strconv.Itoa(d.Baud),
+			md["vendor"], md["product"], md["usb_id"], md["driver"], md["sysfs_path"])
 		if d.LogToStdout {
-			numLogToStdout++
+			go stdout.run(d, mux, ll)
+		}
+		if d.Watchdog != nil {
+			go newWatchdog(d, mm.deviceWatchdogs, ll).run(mux)
+		}
+		if d.Boot != nil {
+			go newBootTracker(d, mm, ll).run(mux)
+		}
+		if d.Tee != nil {
+			go newTee(d, qc, mm.deviceTeeDroppedBytes, ll).run(mux)
+		}
+		if d.Login != nil {
+			l, err := newLoginer(d, mux, ll)
+			if err != nil {
+				fatalf(ll, "failed to configure login for device %q: %v", d.Name, err)
+			}
+			loginers[d.Name] = l
+		}
+		if d.Hooks != nil {
+			hooks[d.Name] = newSessionHooks(d, ll)
+		}
+		if d.GDB != nil {
+			// Listen before any privileges are dropped, but don't accept
+			// connections until the SSH server is ready too.
+			l, err := net.Listen("tcp", d.GDB.Address)
+			if err != nil {
+				fatalf(ll, "failed to listen for device %q GDB passthrough: %v", d.Name, err)
+			}
+
+			gdbs[&gdbServer{name: d.Name, mux: mux, ll: ll}] = l
+		}
+		if d.PTY != nil {
+			// Open the pseudo-terminal before any privileges are dropped.
+			pty, tty, err := openPTY(d.PTY.Path)
+			if err != nil {
+				fatalf(ll, "failed to open device %q pty: %v", d.Name, err)
+			}
+
+			ll.Printf("mirroring device %q to local pty %s", d.Name, d.PTY.Path)
+			go (&ptyMirror{name: d.Name, pty: pty, tty: tty, ll: ll}).run(mux)
 		}
+		if d.ZModem != nil && d.ZModem.Spool != "" {
+			if err := os.MkdirAll(d.ZModem.Spool, 0o750); err != nil {
+				fatalf(ll, "failed to create device %q ZMODEM spool: %v", d.Name, err)
+			}
+			sandboxPaths = append(sandboxPaths, d.ZModem.Spool)
+		}
+		if cfg.Debug.Capture {
+			cb := newCaptureBuffer(cfg.Debug.CaptureSize, newRedactor(d.Redact))
+			captures[d.Name] = cb
+			go cb.run(d.Name, mux, ll)
+		}
+		if cfg.Log.Directory != "" {
+			// Keep each device's log in its own file so it can be retrieved
+			// independently of the interleaved stdout log.
+			lf, err := newLogFile(cfg.Log.Directory, d.Name, cfg.Log.Retention,
+				mm.deviceLogStoredBytes, mm.deviceLogDroppedBytes, ll)
+			if err != nil {
+				fatalf(ll, "failed to open log file for device %q: %v", d.Name, err)
+			}
+			logFiles[d.Name] = lf
+			go lf.run(retentionInterval)
+
+			fl, err := newLineLogger(lf, cfg.Log, false)
+			if err != nil {
+				fatalf(ll, "failed to configure file logging: %v", err)
+			}
+
+			go fl.run(d, mux, ll)
+		}
+	}
+
+	if cfg.Log.Disk != nil {
+		if diskFree == nil {
+			fatalf(ll, "the log disk watchdog is not supported on this platform")
+		}
+
+		go newDiskWatchdog(cfg.Log.Directory, *cfg.Log.Disk, slices.Collect(maps.Values(logFiles)), mm, ll).run()
 	}
-	var stdoutMu sync.Mutex
 
+	// Periodically enumerate adapters again so that devices configured by
+	// serial or USB path are reopened at their new paths, and operators notice
+	// when the adapter mapping has changed.
+	if cfg.Enumeration != nil {
+		go newDriftDetector(fs, *cfg.Enumeration, mm, ll).run()
+	}
+
+	// Fan-outs write to other devices, so they are started once every device
+	// is configured.
 	for _, d := range cfg.Devices {
-		dev, err := fs.openSerial(&d, mm.deviceReadBytes, mm.deviceWriteBytes)
+		if d.FanOut != nil {
+			go newFanOut(d, qc, mm.deviceFanOutBytes, mm.deviceFanOutDroppedBytes, ll).run(devices[d.Name], devices)
+		}
+	}
+
+	// Proxy the devices of other consrv instances alongside the local devices,
+	// so all of them can be reached through this instance.
+	remoteIDs := make(map[string][]string)
+	for _, rc := range cfg.Remotes {
+		ccfg, err := newRemoteClientConfig(rc)
 		if err != nil {
-			ll.Fatalf("failed to add device %q: %v", d.Name, err)
+			fatalf(ll, "failed to configure remote %q: %v", rc.Name, err)
 		}
 
-		ll.Printf("configured device %s [log: %t]", dev, d.LogToStdout)
+		rds, err := discoverRemote(rc, ccfg)
+		if err != nil {
+			// Don't prevent serving the other devices when a remote is
+			// unavailable at startup.
+			errorf(ll, "failed to discover devices on remote %q: %v", rc.Name, err)
+			continue
+		}
 
-		mux := newMuxDevice(dev)
-		devices[d.Name] = mux
-		mm.deviceInfo(1.0, d.Name, d.Device, d.Serial, strconv.Itoa(d.Baud))
-		if d.LogToStdout {
-			var prefix string
-			if numLogToStdout > 1 {
-				// Disambiguate log messages when multiple devices are copied to
-				// stdout.
-				prefix = fmt.Sprintf("%s: ", d.Name)
-			}
-			rawReader := mux.m.Attach(context.Background())
-			go func() {
-				scanner := bufio.NewScanner(rawReader)
-				for scanner.Scan() {
-					stdoutMu.Lock()
-					fmt.Println(prefix + scanner.Text())
-					stdoutMu.Unlock()
-				}
-				if err := scanner.Err(); err != nil {
-					ll.Printf("copying serial to stdout: %v", err)
-				}
-			}()
+		for _, rd := range rds {
+			if _, ok := devices[rd.Name]; ok {
+				ll.Printf("skipping remote %q device %q at %q: conflicts with another device", rc.Name, rd.Name, rd.Address)
+				continue
+			}
+
+			dev := newSSHDevice(rc, rd, ccfg, mm.deviceReadBytes, mm.deviceWriteBytes, ll)
+			ll.Printf("configured remote device %s", dev)
+
+			devices[rd.Name] = consrv.NewMuxDevice(&traceDevice{Device: newErrorDevice(dev, rd.Name, mm), name: rd.Name, lv: lv, ll: ll})
+			remoteIDs[rd.Name] = rc.Identities
+			paths[rd.Name] = rd.Address
+			mm.deviceInfo(1.0, rd.Name, "", rd.Address, "", "", "", "", "", "", "")
 		}
 	}
 
-	ids := newIdentities(cfg, ll)
+	// Optionally log authentication failures to a dedicated file for tools
+	// such as fail2ban, or all authentication attempts in the format of
+	// OpenSSH for existing log parsers.
+	var al, ol *log.Logger
+	if cfg.Server.AuthLog != "" {
+		f, err := os.OpenFile(cfg.Server.AuthLog, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+		if err != nil {
+			fatalf(ll, "failed to open authentication log: %v", err)
+		}
 
-	// Start the SSH server and optional HTTP debug server.
-	sshl, err := net.Listen("tcp", cfg.Server.Address)
+		if cfg.Server.AuthLogFormat == authLogOpenSSH {
+			host, _ := os.Hostname()
+			ol = log.New(&sshdWriter{w: f, host: cmp.Or(host, "localhost"), pid: os.Getpid(), now: time.Now}, "", 0)
+		} else {
+			al = log.New(f, "", log.LstdFlags)
+		}
+	}
+
+	// Optionally present a honeypot to clients which attempt password
+	// authentication, recording their input to a dedicated file.
+	var honeypot func(consrv.HoneypotInput)
+	if hc := cfg.Server.Honeypot; hc != nil {
+		var w io.Writer
+		if hc.Log != "" {
+			f, err := os.OpenFile(hc.Log, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+			if err != nil {
+				fatalf(ll, "failed to open honeypot log: %v", err)
+			}
+			w = f
+		}
+
+		ll.Println("WARNING: password authentication is enabled for the honeypot")
+		honeypot = newHoneypot(w, ll, time.Now)
+	}
+
+	ids, err := newIdentities(cfg, remoteIDs, ll)
 	if err != nil {
-		ll.Fatalf("failed to listen for SSH server: %v", err)
+		fatalf(ll, "failed to configure identities: %v", err)
 	}
 
-	var httpl net.Listener
-	if cfg.Debug.Address != "" {
-		l, err := net.Listen("tcp", cfg.Debug.Address)
+	// Keep track of each trusted certificate authority so identities can be
+	// rebuilt by the provisioning API.
+	cas := slices.Clone(cfg.UserCAKeys)
+	for _, ca := range cfg.UserCAKeys {
+		ids.TrustAuthority(ca)
+	}
+
+	// Optionally trust user certificates from a Vault SSH secrets engine.
+	var vc *vaultClient
+	if cfg.Vault != nil {
+		vc, err = newVaultClient(*cfg.Vault)
 		if err != nil {
-			ll.Fatalf("failed to listen for HTTP debug server: %v", err)
+			fatalf(ll, "failed to configure vault: %v", err)
 		}
-		httpl = l
+
+		ca, err := vc.userCA(context.Background())
+		if err != nil {
+			fatalf(ll, "failed to fetch vault SSH CA: %v", err)
+		}
+		ids.TrustAuthority(ca)
+		cas = append(cas, ca)
+		ll.Printf("trusting user certificates from vault SSH CA at %q", cfg.Vault.Address)
 	}
 
-	if *mustPrivdrop {
-		// Experimental: drop privileges now that we're done reading
-		// configuration and opening possibly privileged TCP listeners.
-		info, err := dropPrivileges()
+	// Optionally issue short-lived certificates to users who authenticate with
+	// OIDC, which are then trusted for SSH authentication.
+	var (
+		ci    *certIssuer
+		oidcl net.Listener
+	)
+	if oc := cfg.OIDC; oc != nil {
+		ci, err = newCertIssuer(*oc, cfg.Identities, ll)
 		if err != nil {
-			ll.Fatalf("failed to drop privileges: %v", err)
+			fatalf(ll, "failed to configure OIDC certificate issuer: %v", err)
 		}
+		ids.TrustAuthority(ci.ca.PublicKey())
+		cas = append(cas, ci.ca.PublicKey())
 
-		ll.Printf("dropped privileges: chroot: %q, UID: %d GID: %d", info.Chroot, info.UID, info.GID)
+		oidcl, err = oc.listen()
+		if err != nil {
+			fatalf(ll, "failed to listen for OIDC certificate issuer: %v", err)
+		}
+	}
+
+	// Optionally serve debug HTTP endpoints which change consrv's state.
+	var admin *adminAuth
+	if cfg.Debug.AdminTokenFile != "" {
+		admin, err = newAdminAuth(cfg.Debug.AdminTokenFile)
+		if err != nil {
+			fatalf(ll, "failed to configure debug admin endpoints: %v", err)
+		}
+	}
+
+	// Optionally manage devices and identities in a configuration fragment
+	// through an HTTP API.
+	var (
+		pv  *provisioner
+		pvl net.Listener
+	)
+	if pc := cfg.Provisioning; pc != nil {
+		pv, err = newProvisioner(*pc, cfg.Raw, ll)
+		if err != nil {
+			fatalf(ll, "failed to configure provisioning: %v", err)
+		}
+
+		pvl, err = net.Listen("tcp", pc.Address)
+		if err != nil {
+			fatalf(ll, "failed to listen for provisioning: %v", err)
+		}
+	}
+
+	// Optionally advertise the SSH and HTTP debug servers on the local network.
+	var (
+		mdns   *mdnsResponder
+		mdnspc net.PacketConn
+	)
+	if cfg.MDNS.Enabled {
+		var httpPort int
+		if httpl != nil {
+			httpPort = httpl.Addr().(*net.TCPAddr).Port
+		}
+
+		mdns, err = newMDNSResponder(cfg, sshl.Addr().(*net.TCPAddr).Port, httpPort, lv, ll)
+		if err != nil {
+			fatalf(ll, "failed to configure mDNS: %v", err)
+		}
+
+		mdnspc, err = net.ListenMulticastUDP("udp4", nil, mdnsGroup)
+		if err != nil {
+			fatalf(ll, "failed to listen for mDNS: %v", err)
+		}
+	}
+
+	// Additional SSH listeners may use privileged ports, so they're opened
+	// before privileges are dropped. They aren't handed over by upgrades, and
+	// are opened again by the new process.
+	listeners := make([]net.Listener, 0, len(cfg.Listeners))
+	for _, lc := range cfg.Listeners {
+		l, err := net.Listen("tcp", lc.Address)
+		if err != nil {
+			fatalf(ll, "failed to listen for SSH server on %q: %v", lc.Address, err)
+		}
+		listeners = append(listeners, l)
+	}
+
+	if restrict != nil {
+		restrict(sandboxPaths)
 	}
 
 	var eg errgroup.Group
 
-	eg.Go(func() error {
-		defer sshl.Close()
+	if st != nil {
+		go st.run(statsInterval, ll)
+	}
+
+	// Optionally report each session to a change-management system when it
+	// closes.
+	var sw *sessionWebhook
+	if cfg.Server.SessionWebhook != nil {
+		sw = newSessionWebhook(cfg, captures, ll)
+	}
+
+	// Optionally consult an external command before accepting each client.
+	var authorize func(ctx context.Context, req consrv.AuthRequest) error
+	if len(cfg.Server.AuthExec) > 0 {
+		authorize = newAuthExec(cfg.Server.AuthExec)
+	}
+
+	srv, err := consrv.NewServer(consrv.ServerConfig{
+		HostKey:             hk.PEM,
+		HostKeyPassphrase:   hk.Passphrase,
+		KeyExchanges:        cfg.Server.SSH.KeyExchanges,
+		Ciphers:             cfg.Server.SSH.Ciphers,
+		MACs:                cfg.Server.SSH.MACs,
+		Version:             cfg.Server.SSH.Version,
+		MaxConnections:      cfg.Server.MaxConnections,
+		MaxConnectionsPerIP: cfg.Server.MaxConnectionsPerIP,
+		KeepAlive:           cfg.Server.KeepAlive.Duration,
+		Devices:             devices,
+		DeviceMetadata:      metadata,
+		RequireApproval:     approval,
+		RequireReason:       reason,
+		ReadOnly:            readOnly,
+		Identities:          ids,
+		Authorize:           authorize,
+		Logger:              ll,
+		ErrorLogger:         errorLogger(ll),
+		AuthLogger:          al,
+		OpenSSHAuthLogger:   ol,
+		Honeypot:            honeypot,
+		DeviceGroups:        deviceGroups(cfg.Devices),
+		PowerCycle:          newPowerCycle(cfg.Devices),
+		Metrics:             mi,
+		Scrollback: func(device string) ([]byte, bool) {
+			cb, ok := captures[device]
+			if !ok {
+				return nil, false
+			}
+
+			b, _ := cb.between(time.Time{}, time.Time{})
+			return b, true
+		},
+		OnAttach: func(ctx context.Context, info consrv.SessionInfo) {
+			if h, ok := hooks[info.Device]; ok {
+				// Don't delay automatic login while the hook runs.
+				go h.open(info)
+			}
+			if l, ok := loginers[info.Device]; ok {
+				l.attach(ctx, info)
+			}
+		},
+		// Recording pauses before an excluded identity's session can produce
+		// any output, and resumes once it has stopped.
+		BeforeAttach: func(info consrv.SessionInfo) {
+			if lf, ok := logFiles[info.Device]; ok && slices.Contains(cfg.Log.ExcludeIdentities, info.Identity) {
+				lf.pause()
+			}
+		},
+		AfterDetach: func(info consrv.SessionInfo) {
+			if lf, ok := logFiles[info.Device]; ok && slices.Contains(cfg.Log.ExcludeIdentities, info.Identity) {
+				lf.resume()
+			}
+		},
+		OnDetach: func(info consrv.SessionInfo, sum consrv.SessionSummary) {
+			if h, ok := hooks[info.Device]; ok {
+				h.close(info)
+			}
+			if sw != nil {
+				sw.detach(info, sum)
+			}
+		},
+	})
+	if err != nil {
+		fatalf(ll, "failed to create SSH server: %v", err)
+	}
+
+	// Boot interrupts, protocol guards, rate alarms, ZMODEM watchers, and
+	// wakeups interact with attached sessions, so they are started once the
+	// server exists.
+	for _, d := range cfg.Devices {
+		event := func(message string) {
+			srv.Publish(consrv.Event{Type: consrv.EventTrigger, Device: d.Name, Message: message})
+		}
+
+		notify := func(format string, v ...any) {
+			srv.Notify(d.Name, format, v...)
+			event(fmt.Sprintf(format, v...))
+		}
+		go newProtocolGuard(d, mm, notify, ll).run(devices[d.Name])
+		go newRateMonitor(d, mm, notify, ll).run(devices[d.Name])
+		if d.ZModem != nil {
+			go newZmodemWatcher(d, notify, ll).run(devices[d.Name])
+		}
+		if d.Wakeup != nil {
+			sessions := func() int { return srv.Sessions(d.Name) }
+			go newWakeup(d, sessions, ll).run(devices[d.Name])
+		}
+
+		if d.Interrupt != nil {
+			mux := devices[d.Name]
+			sessions := func() int { return srv.Sessions(d.Name) }
+			go newInterrupter(d, mux, sessions, event, ll).run(mux)
+		}
+	}
 
-		srv, err := newSSHServer(hostKey, devices, ids, ll, mm)
+	if hk.File != "" {
+		go reloadHostKey(srv, hk, ll)
+	}
+
+	if up != nil {
+		go up.run(func() {
+			for name := range devices {
+				srv.Notify(name, "consrv is upgrading, reconnect in a moment")
+			}
+			if st != nil {
+				if err := st.save(); err != nil {
+					errorf(ll, "failed to save statistics: %v", err)
+				}
+			}
+		}, func() []string {
+			// Parked ports are closed, and are opened normally after the
+			// upgrade when they resume.
+			var ports []string
+			for name, d := range serials {
+				if !parks[name].isParked() {
+					ports = append(ports, d.Device)
+				}
+			}
+
+			return ports
+		})
+	}
+
+	// Tell clients why their sessions are ending and give them a moment to
+	// detach before consrv stops, such as when gokrazy updates the system.
+	sd := newShutdown(func(ctx context.Context) {
+		for name := range devices {
+			srv.Notify(name, "consrv is restarting for an update, reconnect in a moment")
+		}
+		if err := srv.Shutdown(ctx); err != nil {
+			errorf(ll, "failed to drain SSH sessions: %v", err)
+		}
+
+		if st != nil {
+			if err := st.save(); err != nil {
+				errorf(ll, "failed to save statistics: %v", err)
+			}
+		}
+		for name, lf := range logFiles {
+			if err := lf.sync(); err != nil {
+				errorf(ll, "failed to flush log file for device %q: %v", name, err)
+			}
+		}
+	}, ll)
+	go sd.run()
+
+	// Optionally present a host certificate from Vault, renewing it before it
+	// expires.
+	if cfg.Vault != nil && cfg.Vault.HostRole != "" {
+		if len(hk.PEM) == 0 {
+			fatalf(ll, "vault host certificates require an SSH host key file")
+		}
+
+		hr, err := newHostCertRenewer(srv, vc, *cfg.Vault, hk, ll)
 		if err != nil {
-			return fmt.Errorf("failed to create SSH server: %w", err)
+			fatalf(ll, "failed to configure vault host certificate: %v", err)
 		}
 
+		next, err := hr.renew(context.Background())
+		if err != nil {
+			errorf(ll, "failed to fetch vault host certificate, retrying in %s: %v", vaultRetry, err)
+			next = time.Now().Add(vaultRetry)
+		}
+		go hr.run(next)
+	}
+
+	ph := &parkHandler{devices: parks, notify: srv.Notify, ll: ll}
+	if pv != nil {
+		pv.adapters = func() []adapter { return newAdapters(fs.enumerated()) }
+		pv.expand = func(devices []rawDevice) []rawDevice {
+			return fs.expand(devices, log.New(io.Discard, "", 0))
+		}
+
+		// Identities are rebuilt and replaced as they are provisioned.
+		pv.apply = func(cfg *config) error {
+			ids, err := newIdentities(cfg, remoteIDs, ll)
+			if err != nil {
+				return err
+			}
+			for _, ca := range cas {
+				ids.TrustAuthority(ca)
+			}
+
+			srv.SetIdentities(ids)
+			return nil
+		}
+	}
+
+	ah := &approvalsHandler{list: srv.Approvals}
+	gh := &groupsHandler{status: srv.GroupStatus, powerCycle: srv.PowerCycleGroup}
+
+	sh := &stateHandler{
+		hash:         cfg.Hash,
+		paths:        paths,
+		sessions:     srv.Sessions,
+		holder:       srv.Holder,
+		reservations: srv.Reservations,
+		restore:      srv.RestoreReservations,
+		g:            reg,
+		now:          time.Now,
+		ll:           ll,
+	}
+	bh := &baudHandler{devices: serials, parks: parks, openPort: fs.openPort, notify: srv.Notify, ll: ll}
+
+	h := &health{
+		hash:    cfg.Hash,
+		sshAddr: sshl.Addr().String(),
+		devices: devices,
+		holder:  srv.Holder,
+	}
+
+	eg.Go(func() error {
+		defer sshl.Close()
+
 		ll.Printf("starting SSH server on %q", sshl.Addr())
-		if err := srv.Serve(sshl); err != nil {
+		h.ssh.Store(true)
+		defer h.ssh.Store(false)
+		if err := srv.Serve(sshl); err != nil && !errors.Is(err, consrv.ErrServerClosed) {
 			return fmt.Errorf("failed to serve SSH: %v", err)
 		}
 
 		return nil
 	})
 
+	for i, lc := range cfg.Listeners {
+		l, names := listeners[i], lc.devices(cfg.Devices)
+		eg.Go(func() error {
+			defer l.Close()
+
+			ll.Printf("starting SSH server on %q for devices %q", l.Addr(), names)
+			if err := srv.ServeDevices(l, names); err != nil && !errors.Is(err, consrv.ErrServerClosed) {
+				return fmt.Errorf("failed to serve SSH on %q: %v", l.Addr(), err)
+			}
+
+			return nil
+		})
+	}
+
+	if pc := cfg.Debug.Push; pc != nil {
+		// UDP is connectionless, so dialing only fails on a bad address and
+		// the collector may start later.
+		c, err := net.Dial("udp", pc.Address)
+		if err != nil {
+			fatalf(ll, "failed to dial metrics push address: %v", err)
+		}
+		defer c.Close()
+
+		ll.Printf("pushing %s metrics to %q every %s", pc.Format, pc.Address, pc.Interval.Duration)
+		go newPusher(*pc, reg, c, ll).run()
+	}
+
+	if ls != nil {
+		go ls.run()
+		eg.Go(func() error {
+			defer lsl.Close()
+
+			if err := ls.serve(lsl); err != nil {
+				return fmt.Errorf("failed to serve hot-standby lease: %v", err)
+			}
+
+			return nil
+		})
+	}
+
+	if ci != nil {
+		eg.Go(func() error {
+			defer oidcl.Close()
+
+			ll.Printf("starting OIDC certificate issuer on %q", oidcl.Addr())
+			if err := ci.serve(oidcl); err != nil {
+				return fmt.Errorf("failed to serve OIDC certificate issuer: %v", err)
+			}
+
+			return nil
+		})
+	}
+
+	if pv != nil {
+		eg.Go(func() error {
+			defer pvl.Close()
+
+			ll.Printf("starting provisioning API on %q", pvl.Addr())
+			if err := pv.serve(pvl); err != nil {
+				return fmt.Errorf("failed to serve provisioning API: %v", err)
+			}
+
+			return nil
+		})
+	}
+
+	if mdns != nil {
+		eg.Go(func() error {
+			defer mdnspc.Close()
+
+			if err := mdns.serve(mdnspc); err != nil {
+				return fmt.Errorf("failed to serve mDNS: %v", err)
+			}
+
+			return nil
+		})
+	}
+
+	for g, l := range gdbs {
+		eg.Go(func() error {
+			defer l.Close()
+
+			if err := g.serve(l); err != nil {
+				return fmt.Errorf("failed to serve GDB passthrough: %v", err)
+			}
+
+			return nil
+		})
+	}
+
 	if httpl != nil {
 		eg.Go(func() error {
 			defer httpl.Close()
 
-			if err := serveDebug(cfg.Debug, reg, httpl, ll); err != nil {
+			if err := serveDebug(cfg.Debug, admin, reg, captures, ph, bh, h, lv, srv.Reservations, ah, gh, sh, sd, httpl, ll); err != nil {
 				return fmt.Errorf("failed to serve debug HTTP: %v", err)
 			}
 
@@ -220,7 +1171,7 @@ func main() {
 	}
 
 	if err := eg.Wait(); err != nil {
-		ll.Fatalf("failed to run: %v", err)
+		fatalf(ll, "failed to run: %v", err)
 	}
 }
 
@@ -228,32 +1179,82 @@ func main() {
 type privilegesInfo struct {
 	Chroot   string
 	UID, GID int
+	Seccomp  bool
+}
+
+// sandboxInfo contains information from sandboxing with Landlock.
+type sandboxInfo struct {
+	LandlockABI int
+	Paths       []string
 }
 
 // serveDebug starts the HTTP debug server with the input configuration.
-func serveDebug(d debug, reg *prometheus.Registry, listener net.Listener, ll *log.Logger) error {
+func serveDebug(d debug, admin *adminAuth, reg *prometheus.Registry, captures captureHandler, parks *parkHandler, bauds *baudHandler, h *health, lv *logLevel, reservations reservationsHandler, approvals *approvalsHandler, groups *groupsHandler, state *stateHandler, quit http.Handler, listener net.Listener, ll *log.Logger) error {
+	mux := newDebugMux(d, admin, reg, captures, parks, bauds, h, lv, reservations, approvals, groups, state, quit, ll)
+
+	ll.Printf("starting HTTP debug server on %q [prometheus: %t, vars: %t, pprof: %t, capture: %t, admin: %t]",
+		d.Address, d.Prometheus, d.Vars, d.PProf, d.Capture, admin != nil)
+
+	s := &http.Server{
+		Addr:        d.Address,
+		ReadTimeout: 1 * time.Second,
+		Handler:     mux,
+	}
+
+	return s.Serve(listener)
+}
+
+// newDebugMux creates the handler for the HTTP debug server.
+func newDebugMux(d debug, admin *adminAuth, reg *prometheus.Registry, captures captureHandler, parks *parkHandler, bauds *baudHandler, h *health, lv *logLevel, reservations reservationsHandler, approvals *approvalsHandler, groups *groupsHandler, state *stateHandler, quit http.Handler, ll *log.Logger) *http.ServeMux {
 	mux := http.NewServeMux()
 
+	mux.HandleFunc("GET /healthz", h.healthz)
+	mux.HandleFunc("GET /readyz", h.readyz)
+	mux.Handle("GET /loglevel", lv)
+	mux.Handle("GET /reservations", reservations)
+	mux.Handle("GET /approvals", approvals)
+	mux.Handle("GET /groups/{group}", groups)
+	mux.Handle("GET /state", state)
+	mux.Handle("GET /park/{device}", parks)
+
+	// Endpoints which change consrv's state require the admin token, and are
+	// only served if one is configured.
+	if admin != nil {
+		mux.Handle("PUT /loglevel", admin.wrap(lv))
+		mux.Handle("PUT /park/{device}", admin.wrap(parks))
+		mux.Handle("DELETE /park/{device}", admin.wrap(parks))
+		mux.Handle("POST /baud/{device}", admin.wrap(bauds))
+		mux.Handle("PUT /state", admin.wrap(state))
+		mux.Handle("POST /quitquitquit", admin.wrap(quit))
+		mux.Handle("POST /groups/{group}/power-cycle", admin.wrap(groups))
+	}
+
 	if d.Prometheus {
 		mux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
 	}
 
+	if d.Vars {
+		mux.Handle("GET /vars", varsHandler{g: reg})
+	}
+
 	if d.PProf {
 		mux.HandleFunc("/debug/pprof/", pprof.Index)
 		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
 		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
 		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
 		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
-	}
 
-	ll.Printf("starting HTTP debug server on %q [prometheus: %t, pprof: %t]",
-		d.Address, d.Prometheus, d.PProf)
+		rt := newRuntimeTuner(ll)
+		mux.Handle("GET /debug/runtime", rt)
+		mux.Handle("GET /debug/runtime/{setting}", rt)
+		if admin != nil {
+			mux.Handle("PUT /debug/runtime/{setting}", admin.wrap(rt))
+		}
+	}
 
-	s := &http.Server{
-		Addr:        d.Address,
-		ReadTimeout: 1 * time.Second,
-		Handler:     mux,
+	if d.Capture {
+		mux.Handle("GET /capture/{device}", captures)
 	}
 
-	return s.Serve(listener)
+	return mux
 }
@@ -0,0 +1,48 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import "bytes"
+
+// maxPending is the maximum number of bytes of an incomplete line which are
+// retained for matching patterns.
+const maxPending = 4096
+
+// A lineBuffer splits device output into lines for matching patterns.
+type lineBuffer struct {
+	b []byte
+}
+
+// write appends b to the buffer and calls fn for each complete line, followed
+// by the trailing incomplete line, if any, which may be a prompt. An
+// incomplete line is passed to fn again as it grows.
+func (lb *lineBuffer) write(b []byte, fn func(line []byte, partial bool)) {
+	lb.b = append(lb.b, b...)
+	for {
+		i := bytes.IndexByte(lb.b, '\n')
+		if i == -1 {
+			break
+		}
+
+		fn(bytes.TrimSuffix(lb.b[:i], []byte("\r")), false)
+		lb.b = lb.b[i+1:]
+	}
+
+	if len(lb.b) > 0 {
+		fn(lb.b, true)
+	}
+	if len(lb.b) > maxPending {
+		lb.b = lb.b[len(lb.b)-maxPending:]
+	}
+}
@@ -0,0 +1,384 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// mdnsConfig contains the configuration for DNS-SD advertisement of consrv's
+// endpoints over multicast DNS.
+type mdnsConfig struct {
+	Enabled  bool   `toml:"enabled"`
+	Instance string `toml:"instance"`
+	Hostname string `toml:"hostname"`
+	Devices  bool   `toml:"devices"`
+}
+
+// validate verifies the multicast DNS configuration.
+func (mc *mdnsConfig) validate() error {
+	if strings.Contains(mc.Instance, ".") || strings.Contains(mc.Hostname, ".") {
+		return errors.New("mDNS instance and hostname must not contain dots")
+	}
+
+	return nil
+}
+
+const (
+	// mdnsTTL is the TTL of each advertised record, in seconds.
+	mdnsTTL = 120
+
+	// The DNS-SD service types for the SSH server and HTTP debug server.
+	mdnsSSHService  = "_consrv._tcp.local."
+	mdnsHTTPService = "_http._tcp.local."
+
+	// mdnsServices is the DNS-SD service type enumeration name (RFC 6763,
+	// section 9).
+	mdnsServices = "_services._dns-sd._udp.local."
+)
+
+// mdnsGroup is the IPv4 multicast DNS group address.
+var mdnsGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// An mdnsService is a DNS-SD service advertised by an mdnsResponder.
+type mdnsService struct {
+	typ  string
+	port int
+	txt  []string
+}
+
+// An mdnsResponder answers multicast DNS queries for consrv's DNS-SD
+// services.
+type mdnsResponder struct {
+	instance, host string
+	services       []mdnsService
+	addrs          func() ([]netip.Addr, error)
+	lv             *logLevel
+	ll             *log.Logger
+}
+
+// newMDNSResponder creates an mdnsResponder from the configuration which
+// advertises the SSH server on sshPort and the HTTP debug server on httpPort,
+// if httpPort is not 0.
+func newMDNSResponder(cfg *config, sshPort, httpPort int, lv *logLevel, ll *log.Logger) (*mdnsResponder, error) {
+	mc := cfg.MDNS
+
+	host := mc.Hostname
+	if host == "" {
+		h, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get hostname: %v", err)
+		}
+
+		// Only the first label of a fully qualified hostname is used.
+		host, _, _ = strings.Cut(h, ".")
+	}
+
+	instance := mc.Instance
+	if instance == "" {
+		instance = "consrv on " + host
+	}
+
+	txt := []string{"consrv=1"}
+	if mc.Devices {
+		for _, d := range cfg.Devices {
+			txt = append(txt, "device="+d.Name)
+		}
+	}
+
+	services := []mdnsService{{typ: mdnsSSHService, port: sshPort, txt: txt}}
+	if httpPort != 0 {
+		services = append(services, mdnsService{
+			typ:  mdnsHTTPService,
+			port: httpPort,
+			txt:  []string{"path=/"},
+		})
+	}
+
+	return &mdnsResponder{
+		instance: instance,
+		host:     host + ".local.",
+		services: services,
+		addrs:    interfaceAddrs,
+		lv:       lv,
+		ll:       ll,
+	}, nil
+}
+
+// interfaceAddrs returns the non-loopback IP addresses of the system.
+func interfaceAddrs() ([]netip.Addr, error) {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+
+	var ips []netip.Addr
+	for _, a := range addrs {
+		ipn, ok := a.(*net.IPNet)
+		if !ok {
+			continue
+		}
+
+		ip, ok := netip.AddrFromSlice(ipn.IP)
+		if !ok || ip.IsLoopback() || ip.Is6() && ip.IsLinkLocalUnicast() {
+			continue
+		}
+
+		ips = append(ips, ip.Unmap())
+	}
+
+	return ips, nil
+}
+
+// serve answers queries received on pc until pc is closed, after announcing
+// the services.
+func (mr *mdnsResponder) serve(pc net.PacketConn) error {
+	mr.ll.Printf("advertising %q on mDNS as %s", mr.instance, mr.host)
+
+	// Announce the services twice at startup (RFC 6762, section 8.3).
+	go func() {
+		for i := 0; i < 2; i++ {
+			b, err := mr.announce()
+			if err != nil {
+				errorf(mr.ll, "failed to build mDNS announcement: %v", err)
+				return
+			}
+			if _, err := pc.WriteTo(b, mdnsGroup); err != nil {
+				errorf(mr.ll, "failed to send mDNS announcement: %v", err)
+				return
+			}
+
+			time.Sleep(1 * time.Second)
+		}
+	}()
+
+	b := make([]byte, 9000)
+	for {
+		n, addr, err := pc.ReadFrom(b)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+
+			return err
+		}
+
+		res, ok, err := mr.respond(b[:n])
+		if err != nil {
+			errorf(mr.ll, "failed to answer mDNS query: %v", err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+
+		mr.lv.debugf(mr.ll, "answering mDNS query from %s", addr)
+		if _, err := pc.WriteTo(res, mdnsGroup); err != nil {
+			errorf(mr.ll, "failed to send mDNS response: %v", err)
+		}
+	}
+}
+
+// announce builds an unsolicited response containing all of the records.
+func (mr *mdnsResponder) announce() ([]byte, error) {
+	var qs []dnsmessage.Question
+	for _, s := range mr.services {
+		qs = append(qs, dnsmessage.Question{
+			Name:  dnsmessage.MustNewName(s.typ),
+			Type:  dnsmessage.TypePTR,
+			Class: dnsmessage.ClassINET,
+		})
+	}
+
+	return mr.build(qs)
+}
+
+// respond builds a response to the query in b, if any of its questions are
+// for records owned by the responder.
+func (mr *mdnsResponder) respond(b []byte) ([]byte, bool, error) {
+	var p dnsmessage.Parser
+	h, err := p.Start(b)
+	if err != nil {
+		return nil, false, err
+	}
+	if h.Response {
+		return nil, false, nil
+	}
+
+	qs, err := p.AllQuestions()
+	if err != nil {
+		return nil, false, err
+	}
+
+	res, err := mr.build(qs)
+	if err != nil || res == nil {
+		return nil, false, err
+	}
+
+	return res, true, nil
+}
+
+// build builds a response answering qs, or returns nil if none of qs are for
+// records owned by the responder.
+func (mr *mdnsResponder) build(qs []dnsmessage.Question) ([]byte, error) {
+	var answers, additionals []dnsmessage.Resource
+	for _, q := range qs {
+		as, adds, err := mr.answer(q)
+		if err != nil {
+			return nil, err
+		}
+
+		answers = append(answers, as...)
+		additionals = append(additionals, adds...)
+	}
+	if len(answers) == 0 {
+		return nil, nil
+	}
+
+	msg := dnsmessage.Message{
+		Header:      dnsmessage.Header{Response: true, Authoritative: true},
+		Answers:     answers,
+		Additionals: additionals,
+	}
+
+	return msg.Pack()
+}
+
+// answer returns the answers and additional records for q.
+func (mr *mdnsResponder) answer(q dnsmessage.Question) ([]dnsmessage.Resource, []dnsmessage.Resource, error) {
+	// The top bit of the class is the unicast response bit (RFC 6762, section
+	// 5.4), but responses are always sent to the multicast group.
+	if q.Class&^(1<<15) != dnsmessage.ClassINET && q.Class != dnsmessage.ClassANY {
+		return nil, nil, nil
+	}
+
+	name := strings.ToLower(q.Name.String())
+	wants := func(t dnsmessage.Type) bool { return q.Type == t || q.Type == dnsmessage.TypeALL }
+
+	if name == strings.ToLower(mr.host) {
+		rs, err := mr.hostRecords(wants)
+		return rs, nil, err
+	}
+
+	var answers, additionals []dnsmessage.Resource
+	for _, s := range mr.services {
+		instance := mr.instanceName(s)
+		switch name {
+		case mdnsServices:
+			if wants(dnsmessage.TypePTR) {
+				answers = append(answers, mr.ptr(mdnsServices, s.typ))
+			}
+		case s.typ:
+			if !wants(dnsmessage.TypePTR) {
+				continue
+			}
+
+			answers = append(answers, mr.ptr(s.typ, instance))
+
+			// Save clients a round trip by including the records which
+			// describe the instance (RFC 6763, section 12.1).
+			hosts, err := mr.hostRecords(func(dnsmessage.Type) bool { return true })
+			if err != nil {
+				return nil, nil, err
+			}
+			additionals = append(additionals, mr.srv(s), mr.txt(s))
+			additionals = append(additionals, hosts...)
+		case strings.ToLower(instance):
+			if wants(dnsmessage.TypeSRV) {
+				answers = append(answers, mr.srv(s))
+			}
+			if wants(dnsmessage.TypeTXT) {
+				answers = append(answers, mr.txt(s))
+			}
+		}
+	}
+
+	return answers, additionals, nil
+}
+
+// instanceName returns the DNS-SD service instance name for s.
+func (mr *mdnsResponder) instanceName(s mdnsService) string {
+	return mr.instance + "." + s.typ
+}
+
+// header returns a resource header for name.
+func (mr *mdnsResponder) header(name string) dnsmessage.ResourceHeader {
+	return dnsmessage.ResourceHeader{
+		Name:  dnsmessage.MustNewName(name),
+		Class: dnsmessage.ClassINET,
+		TTL:   mdnsTTL,
+	}
+}
+
+// ptr returns a PTR record from name to target.
+func (mr *mdnsResponder) ptr(name, target string) dnsmessage.Resource {
+	return dnsmessage.Resource{
+		Header: mr.header(name),
+		Body:   &dnsmessage.PTRResource{PTR: dnsmessage.MustNewName(target)},
+	}
+}
+
+// srv returns the SRV record for s.
+func (mr *mdnsResponder) srv(s mdnsService) dnsmessage.Resource {
+	return dnsmessage.Resource{
+		Header: mr.header(mr.instanceName(s)),
+		Body: &dnsmessage.SRVResource{
+			Port:   uint16(s.port),
+			Target: dnsmessage.MustNewName(mr.host),
+		},
+	}
+}
+
+// txt returns the TXT record for s.
+func (mr *mdnsResponder) txt(s mdnsService) dnsmessage.Resource {
+	return dnsmessage.Resource{
+		Header: mr.header(mr.instanceName(s)),
+		Body:   &dnsmessage.TXTResource{TXT: s.txt},
+	}
+}
+
+// hostRecords returns the A and AAAA records for the host which are wanted.
+func (mr *mdnsResponder) hostRecords(wants func(t dnsmessage.Type) bool) ([]dnsmessage.Resource, error) {
+	ips, err := mr.addrs()
+	if err != nil {
+		return nil, err
+	}
+
+	var rs []dnsmessage.Resource
+	for _, ip := range ips {
+		switch {
+		case ip.Is4() && wants(dnsmessage.TypeA):
+			rs = append(rs, dnsmessage.Resource{
+				Header: mr.header(mr.host),
+				Body:   &dnsmessage.AResource{A: ip.As4()},
+			})
+		case ip.Is6() && wants(dnsmessage.TypeAAAA):
+			rs = append(rs, dnsmessage.Resource{
+				Header: mr.header(mr.host),
+				Body:   &dnsmessage.AAAAResource{AAAA: ip.As16()},
+			})
+		}
+	}
+
+	return rs, nil
+}
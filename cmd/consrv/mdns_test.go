@@ -0,0 +1,157 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/netip"
+	"strconv"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func Test_mdnsResponderRespond(t *testing.T) {
+	mr, err := newMDNSResponder(&config{
+		Devices: []rawDevice{{Name: "server"}, {Name: "desktop"}},
+		MDNS: mdnsConfig{
+			Enabled:  true,
+			Hostname: "consrv-pi",
+			Devices:  true,
+		},
+	}, 2222, 9288, newLogLevel(), log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatalf("failed to create responder: %v", err)
+	}
+	mr.addrs = func() ([]netip.Addr, error) {
+		return []netip.Addr{netip.MustParseAddr("192.0.2.1")}, nil
+	}
+
+	tests := []struct {
+		name        string
+		q           string
+		typ         dnsmessage.Type
+		answers     []string
+		additionals []string
+	}{
+		{
+			name: "other service",
+			q:    "_ssh._tcp.local.",
+			typ:  dnsmessage.TypePTR,
+		},
+		{
+			name: "services",
+			q:    "_services._dns-sd._udp.local.",
+			typ:  dnsmessage.TypePTR,
+			answers: []string{
+				"_services._dns-sd._udp.local. PTR _consrv._tcp.local.",
+				"_services._dns-sd._udp.local. PTR _http._tcp.local.",
+			},
+		},
+		{
+			name:    "SSH",
+			q:       "_consrv._tcp.local.",
+			typ:     dnsmessage.TypePTR,
+			answers: []string{"_consrv._tcp.local. PTR consrv on consrv-pi._consrv._tcp.local."},
+			additionals: []string{
+				"consrv on consrv-pi._consrv._tcp.local. SRV consrv-pi.local.:2222",
+				`consrv on consrv-pi._consrv._tcp.local. TXT ["consrv=1" "device=server" "device=desktop"]`,
+				"consrv-pi.local. A 192.0.2.1",
+			},
+		},
+		{
+			name:    "HTTP SRV",
+			q:       "consrv on consrv-pi._http._tcp.local.",
+			typ:     dnsmessage.TypeSRV,
+			answers: []string{"consrv on consrv-pi._http._tcp.local. SRV consrv-pi.local.:9288"},
+		},
+		{
+			name:    "host",
+			q:       "CONSRV-PI.local.",
+			typ:     dnsmessage.TypeA,
+			answers: []string{"consrv-pi.local. A 192.0.2.1"},
+		},
+		{
+			name: "host AAAA",
+			q:    "consrv-pi.local.",
+			typ:  dnsmessage.TypeAAAA,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := dnsmessage.Message{
+				Questions: []dnsmessage.Question{{
+					Name:  dnsmessage.MustNewName(tt.q),
+					Type:  tt.typ,
+					Class: dnsmessage.ClassINET,
+				}},
+			}
+			b, err := q.Pack()
+			if err != nil {
+				t.Fatalf("failed to pack query: %v", err)
+			}
+
+			res, ok, err := mr.respond(b)
+			if err != nil {
+				t.Fatalf("failed to respond: %v", err)
+			}
+			if !ok {
+				if tt.answers != nil {
+					t.Fatal("expected a response, but none was built")
+				}
+				return
+			}
+
+			var m dnsmessage.Message
+			if err := m.Unpack(res); err != nil {
+				t.Fatalf("failed to unpack response: %v", err)
+			}
+
+			if diff := cmp.Diff(tt.answers, records(m.Answers)); diff != "" {
+				t.Fatalf("unexpected answers (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tt.additionals, records(m.Additionals)); diff != "" {
+				t.Fatalf("unexpected additionals (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// records summarizes resource records for comparison.
+func records(rs []dnsmessage.Resource) []string {
+	var ss []string
+	for _, r := range rs {
+		s := r.Header.Name.String() + " "
+		switch b := r.Body.(type) {
+		case *dnsmessage.PTRResource:
+			s += "PTR " + b.PTR.String()
+		case *dnsmessage.SRVResource:
+			s += "SRV " + b.Target.String() + ":" + strconv.Itoa(int(b.Port))
+		case *dnsmessage.TXTResource:
+			s += "TXT " + fmt.Sprintf("%q", b.TXT)
+		case *dnsmessage.AResource:
+			s += "A " + netip.AddrFrom4(b.A).String()
+		default:
+			s += r.Header.Type.String()
+		}
+
+		ss = append(ss, s)
+	}
+
+	return ss
+}
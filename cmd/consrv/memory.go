@@ -0,0 +1,103 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// memoryConfig contains the configuration which bounds consrv's memory use,
+// such as on a single board computer with little memory.
+type memoryConfig struct {
+	Limit       int64 `toml:"limit"`
+	MaxBuffered int64 `toml:"max_buffered"`
+	Queue       int   `toml:"queue"`
+}
+
+// defaultQueue is the default number of reads of output buffered for each
+// consumer which may not keep up with a device.
+const defaultQueue = 256
+
+// validate verifies the memory configuration and applies defaults.
+func (mc *memoryConfig) validate() error {
+	switch {
+	case mc.Limit < 0:
+		return errors.New("memory limit must not be negative")
+	case mc.MaxBuffered < 0:
+		return errors.New("memory max_buffered must not be negative")
+	case mc.Queue < 0:
+		return errors.New("memory queue must not be negative")
+	case mc.Queue == 0:
+		mc.Queue = defaultQueue
+	}
+
+	return nil
+}
+
+// A queueConfig configures the queues of output buffered for consumers which
+// may not keep up with a device, such as tee commands and fan-out devices.
+type queueConfig struct {
+	length int
+	budget *bufferBudget
+}
+
+// newQueueConfig creates a queueConfig from mc which shares a single
+// bufferBudget between all queues.
+func newQueueConfig(mc memoryConfig) queueConfig {
+	return queueConfig{
+		length: mc.Queue,
+		budget: newBufferBudget(mc.MaxBuffered),
+	}
+}
+
+// A bufferBudget limits the total number of bytes queued across all devices.
+// A nil *bufferBudget is unlimited.
+type bufferBudget struct {
+	max int64
+	n   atomic.Int64
+}
+
+// newBufferBudget creates a bufferBudget of max bytes, or returns nil if max
+// is zero.
+func newBufferBudget(max int64) *bufferBudget {
+	if max == 0 {
+		return nil
+	}
+
+	return &bufferBudget{max: max}
+}
+
+// acquire reserves n bytes, and reports whether they fit within the budget.
+func (bb *bufferBudget) acquire(n int) bool {
+	if bb == nil {
+		return true
+	}
+
+	if bb.n.Add(int64(n)) > bb.max {
+		bb.n.Add(-int64(n))
+		return false
+	}
+
+	return true
+}
+
+// release returns n bytes reserved by acquire.
+func (bb *bufferBudget) release(n int) {
+	if bb == nil {
+		return
+	}
+
+	bb.n.Add(-int64(n))
+}
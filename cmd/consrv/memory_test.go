@@ -0,0 +1,87 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io"
+	"log"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_bufferBudget(t *testing.T) {
+	tests := []struct {
+		name string
+		bb   *bufferBudget
+		want []bool
+	}{
+		{
+			name: "unlimited",
+			want: []bool{true, true, true},
+		},
+		{
+			name: "limited",
+			bb:   newBufferBudget(10),
+			want: []bool{true, true, false},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Acquire 4 bytes at a time, so the third acquisition exceeds the
+			// limited budget.
+			var got []bool
+			for range 3 {
+				got = append(got, tt.bb.acquire(4))
+			}
+
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Fatalf("unexpected acquisitions (-want +got):\n%s", diff)
+			}
+
+			// Released bytes may be acquired again.
+			tt.bb.release(4)
+			if !tt.bb.acquire(4) {
+				t.Fatal("failed to acquire released bytes")
+			}
+		})
+	}
+}
+
+func Test_teeCopyBudget(t *testing.T) {
+	var dropped int
+	tt := &tee{
+		name:   "server",
+		budget: newBufferBudget(8),
+		drops:  func(v float64, _ ...string) { dropped += int(v) },
+		ll:     log.New(io.Discard, "", 0),
+		chunks: make(chan []byte, 4),
+	}
+
+	r := &chunkReader{
+		chunks: []string{"hello\n", "dropped\n"},
+		read:   func() {},
+	}
+	if err := tt.copy(r); err != io.EOF {
+		t.Fatalf("failed to copy: %v", err)
+	}
+
+	if diff := cmp.Diff("hello\n", string(<-tt.chunks)); diff != "" {
+		t.Fatalf("unexpected chunk (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(len("dropped\n"), dropped); diff != "" {
+		t.Fatalf("unexpected dropped bytes (-want +got):\n%s", diff)
+	}
+}
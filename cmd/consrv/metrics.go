@@ -14,22 +14,42 @@
 package main
 
 import (
-	"sync/atomic"
+	"strconv"
 
 	"github.com/mdlayher/metricslite"
 )
 
-// metrics contains metrics for a consrv server.
+// metrics contains metrics for the consrv command. Metrics for SSH sessions are
+// produced by the consrv.Server.
 type metrics struct {
-	// Atomics must come first.
-	sessions int32
-
-	deviceInfo            metricslite.Gauge
-	deviceAuthentications metricslite.Counter
-	deviceSessions        metricslite.Gauge
-	deviceUnknownSessions metricslite.Counter
-	deviceReadBytes       metricslite.Counter
-	deviceWriteBytes      metricslite.Counter
+	restarts         metricslite.Counter
+	deviceInfo       metricslite.Gauge
+	deviceReadBytes  metricslite.Counter
+	deviceWriteBytes metricslite.Counter
+	deviceWatchdogs  metricslite.Counter
+
+	deviceProtocolDetections metricslite.Counter
+	deviceTeeDroppedBytes    metricslite.Counter
+	deviceFanOutBytes        metricslite.Counter
+	deviceFanOutDroppedBytes metricslite.Counter
+	deviceLogStoredBytes     metricslite.Gauge
+	deviceLogDroppedBytes    metricslite.Counter
+	logDiskFreeBytes         metricslite.Gauge
+	logDiskState             metricslite.Gauge
+
+	deviceOutputLines          metricslite.Counter
+	deviceOutputLinesPerMinute metricslite.Gauge
+	deviceOutputRateAlarm      metricslite.Gauge
+
+	deviceConsecutiveReadErrors  metricslite.Gauge
+	deviceConsecutiveWriteErrors metricslite.Gauge
+	deviceLastReadTimestamp      metricslite.Gauge
+
+	adapterChanges metricslite.Counter
+	adapterDrift   metricslite.Gauge
+
+	deviceBoots      *histogram
+	deviceBootStages *histogram
 }
 
 func newMetrics(m metricslite.Interface) *metrics {
@@ -38,46 +58,223 @@ func newMetrics(m metricslite.Interface) *metrics {
 	}
 
 	return &metrics{
+		restarts: m.Counter(
+			"consrv_restarts_total",
+			"The total number of times consrv has started, if statistics persistence is enabled.",
+		),
+
 		deviceInfo: m.Gauge(
 			"consrv_device_info",
 			"Information metrics about each configured serial console device.",
-			"name", "device", "serial", "baud",
+			"name", "namespace", "device", "serial", "baud", "vendor", "product", "usb_id", "driver", "sysfs_path",
+		),
+
+		deviceReadBytes: m.Counter(
+			"consrv_device_read_bytes_total",
+			"The total number of bytes read from a serial device.",
+			"name",
 		),
 
-		deviceAuthentications: m.Counter(
-			"consrv_device_authentications_total",
-			"The total number of accepted and rejected SSH sessions for a serial console device.",
+		deviceWriteBytes: m.Counter(
+			"consrv_device_write_bytes_total",
+			"The total number of bytes written to a serial device.",
 			"name",
 		),
 
-		deviceSessions: m.Gauge(
-			"consrv_device_sessions",
-			"The number of active SSH sessions connected to a serial console device.",
+		deviceWatchdogs: m.Counter(
+			"consrv_device_watchdog_fired_total",
+			"The total number of times a serial device's idle output watchdog fired.",
 			"name",
 		),
 
-		deviceUnknownSessions: m.Counter(
-			"consrv_device_unknown_sessions_total",
-			"The total number of SSH sessions which attempted to open a non-existent device.",
+		deviceProtocolDetections: m.Counter(
+			"consrv_device_protocol_detections_total",
+			"The total number of times a binary protocol was detected in a serial device's output.",
+			"name", "protocol",
 		),
 
-		deviceReadBytes: m.Counter(
-			"consrv_device_read_bytes_total",
-			"The total number of bytes read from a serial device.",
+		deviceTeeDroppedBytes: m.Counter(
+			"consrv_device_tee_dropped_bytes_total",
+			"The total number of bytes of a serial device's output dropped because its tee command did not keep up.",
 			"name",
 		),
 
-		deviceWriteBytes: m.Counter(
-			"consrv_device_write_bytes_total",
-			"The total number of bytes written to a serial device.",
+		deviceFanOutBytes: m.Counter(
+			"consrv_device_fanout_bytes_total",
+			"The total number of bytes of a serial device's output written to another device by fan-out.",
+			"name", "target",
+		),
+
+		deviceFanOutDroppedBytes: m.Counter(
+			"consrv_device_fanout_dropped_bytes_total",
+			"The total number of bytes of a serial device's output dropped because a fan-out device did not keep up.",
+			"name", "target",
+		),
+
+		deviceLogStoredBytes: m.Gauge(
+			"consrv_device_log_stored_bytes",
+			"The number of bytes of a serial device's output stored in its current and rotated log files.",
+			"name",
+		),
+
+		deviceLogDroppedBytes: m.Counter(
+			"consrv_device_log_dropped_bytes_total",
+			"The total number of bytes of a serial device's output not logged because the log volume was low on space.",
+			"name",
+		),
+
+		logDiskFreeBytes: m.Gauge(
+			"consrv_log_disk_free_bytes",
+			"The number of bytes available on the volume of the log directory.",
+		),
+
+		logDiskState: m.Gauge(
+			"consrv_log_disk_state",
+			"Whether logging is in each state due to the free space of the log volume: ok, low (downsampled), or full (paused).",
+			"state",
+		),
+
+		deviceOutputLines: m.Counter(
+			"consrv_device_output_lines_total",
+			"The total number of lines of output read from a serial device.",
+			"name",
+		),
+
+		deviceOutputLinesPerMinute: m.Gauge(
+			"consrv_device_output_lines_per_minute",
+			"The number of lines of output read from a serial device over the last minute.",
+			"name",
+		),
+
+		deviceOutputRateAlarm: m.Gauge(
+			"consrv_device_output_rate_alarm",
+			"Whether a serial device's output rate exceeds its configured alarm threshold.",
+			"name",
+		),
+
+		deviceConsecutiveReadErrors: m.Gauge(
+			"consrv_device_consecutive_read_errors",
+			"The number of consecutive failed reads from a serial device, reset by a successful read.",
+			"name",
+		),
+
+		deviceConsecutiveWriteErrors: m.Gauge(
+			"consrv_device_consecutive_write_errors",
+			"The number of consecutive failed writes to a serial device, reset by a successful write.",
+			"name",
+		),
+
+		deviceLastReadTimestamp: m.Gauge(
+			"consrv_device_last_read_timestamp_seconds",
+			"The UNIX timestamp of the last successful read from a serial device, or of startup if none has occurred.",
+			"name",
+		),
+
+		adapterChanges: m.Counter(
+			"consrv_adapter_changes_total",
+			"The total number of serial adapters added, removed, or changed between periodic enumerations.",
+			"change",
+		),
+
+		adapterDrift: m.Gauge(
+			"consrv_adapter_drift",
+			"The number of serial adapters which were added, removed, or changed since startup.",
+		),
+
+		deviceBoots: newHistogram(m,
+			"consrv_device_boot_seconds",
+			"The time between the first and last boot markers of a serial device.",
+			bootBuckets,
 			"name",
 		),
+
+		deviceBootStages: newHistogram(m,
+			"consrv_device_boot_stage_seconds",
+			"The time between consecutive boot markers of a serial device.",
+			bootBuckets,
+			"name", "from", "to",
+		),
 	}
 }
 
-func (m *metrics) newSession(name string) func() {
-	m.deviceSessions(float64(atomic.AddInt32(&m.sessions, 1)), name)
-	return func() {
-		m.deviceSessions(float64(atomic.AddInt32(&m.sessions, -1)), name)
+// bootBuckets are the histogram buckets for boot timing, in seconds.
+var bootBuckets = []float64{1, 2, 5, 10, 20, 30, 60, 120, 300, 600}
+
+// A histogram is a Prometheus-style histogram built from counters, because
+// metricslite does not support histograms.
+type histogram struct {
+	buckets            []float64
+	bucket, sum, count metricslite.Counter
+}
+
+// newHistogram creates a histogram with the specified buckets which produces
+// the _bucket, _sum, and _count series for name.
+func newHistogram(m metricslite.Interface, name, help string, buckets []float64, labelNames ...string) *histogram {
+	return &histogram{
+		buckets: buckets,
+		bucket:  m.Counter(name+"_bucket", help, append(labelNames, "le")...),
+		sum:     m.Counter(name+"_sum", help, labelNames...),
+		count:   m.Counter(name+"_count", help, labelNames...),
+	}
+}
+
+// observe adds a single observation of v to the histogram.
+func (h *histogram) observe(v float64, labels ...string) {
+	for _, b := range h.buckets {
+		if v <= b {
+			h.bucket(1.0, append(labels, strconv.FormatFloat(b, 'g', -1, 64))...)
+		}
+	}
+
+	h.bucket(1.0, append(labels, "+Inf")...)
+	h.sum(v, labels...)
+	h.count(1.0, labels...)
+}
+
+var _ metricslite.Interface = &persistInterface{}
+
+// A persistInterface is a metricslite.Interface which persists the per-device
+// counters created by both the consrv command and consrv.Server.
+type persistInterface struct {
+	metricslite.Interface
+	st    *stats
+	names []string
+}
+
+// persist wraps m so that the persisted device counters are seeded with the
+// statistics previously stored in st for each named device, and all future
+// counter updates are recorded in st.
+func persist(m metricslite.Interface, st *stats, names []string) metricslite.Interface {
+	return &persistInterface{
+		Interface: m,
+		st:        st,
+		names:     names,
+	}
+}
+
+// Counter implements metricslite.Interface.
+func (pi *persistInterface) Counter(name, help string, labelNames ...string) metricslite.Counter {
+	var field func(ds *deviceStats) *uint64
+	switch name {
+	case "consrv_device_read_bytes_total":
+		field = func(ds *deviceStats) *uint64 { return &ds.ReadBytes }
+	case "consrv_device_write_bytes_total":
+		field = func(ds *deviceStats) *uint64 { return &ds.WriteBytes }
+	case "consrv_device_sessions_total":
+		field = func(ds *deviceStats) *uint64 { return &ds.Sessions }
+	default:
+		return pi.Interface.Counter(name, help, labelNames...)
+	}
+
+	c := pi.Interface.Counter(name, help, labelNames...)
+	for _, n := range pi.names {
+		ds := pi.st.device(n)
+		c(float64(*field(&ds)), n)
+	}
+
+	// Each of these counters uses the device name as its only label.
+	return func(v float64, labels ...string) {
+		c(v, labels...)
+		pi.st.update(labels[0], func(ds *deviceStats) { *field(ds) += uint64(v) })
 	}
 }
@@ -0,0 +1,515 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// oidcConfig contains the configuration for an HTTPS endpoint which issues
+// short-lived SSH user certificates to users who authenticate with an OpenID
+// Connect identity provider.
+type oidcConfig struct {
+	Address  string   `toml:"address"`
+	TLSCert  string   `toml:"tls_cert"`
+	TLSKey   string   `toml:"tls_key"`
+	Issuer   string   `toml:"issuer"`
+	ClientID string   `toml:"client_id"`
+	Claim    string   `toml:"claim"`
+	CAKey    string   `toml:"ca_key"`
+	Validity duration `toml:"validity"`
+}
+
+// Defaults and limits for OIDC certificate issuance.
+const (
+	defaultOIDCClaim    = "email"
+	defaultOIDCValidity = 8 * time.Hour
+	maxOIDCValidity     = 24 * time.Hour
+
+	// oidcTimeout bounds requests to the identity provider.
+	oidcTimeout = 10 * time.Second
+	// oidcRefresh limits how often the identity provider's keys are fetched
+	// when a token is signed by an unknown key.
+	oidcRefresh = 1 * time.Minute
+	// oidcSkew allows for clock skew when checking token timestamps.
+	oidcSkew = 1 * time.Minute
+)
+
+// validate verifies the OIDC configuration and applies defaults.
+func (oc *oidcConfig) validate() error {
+	if _, _, err := net.SplitHostPort(oc.Address); err != nil {
+		return fmt.Errorf("OIDC must have a valid address: %v", err)
+	}
+	if oc.TLSCert == "" || oc.TLSKey == "" {
+		return errors.New("OIDC must have a TLS certificate and key")
+	}
+
+	if !isHTTPS(oc.Issuer) {
+		return fmt.Errorf("OIDC issuer %q must be an HTTPS URL", oc.Issuer)
+	}
+
+	if oc.ClientID == "" {
+		return errors.New("OIDC must have a client ID")
+	}
+	if oc.CAKey == "" {
+		return errors.New("OIDC must have a CA key")
+	}
+	if oc.Claim == "" {
+		oc.Claim = defaultOIDCClaim
+	}
+
+	switch v := oc.Validity.Duration; {
+	case v == 0:
+		oc.Validity.Duration = defaultOIDCValidity
+	case v < 0 || v > maxOIDCValidity:
+		return fmt.Errorf("OIDC certificate validity must be between 0 and %s", maxOIDCValidity)
+	}
+
+	return nil
+}
+
+// A certIssuer is an http.Handler which signs the SSH public key in the body
+// of each request with a certificate authority key, if the request carries an
+// OIDC ID token for a configured identity.
+type certIssuer struct {
+	ca       gossh.Signer
+	validity time.Duration
+	claim    string
+	ids      map[string]bool
+	v        *oidcVerifier
+	now      func() time.Time
+	ll       *log.Logger
+}
+
+// newCertIssuer creates a certIssuer from oc which issues certificates for
+// ids.
+func newCertIssuer(oc oidcConfig, ids []identity, ll *log.Logger) (*certIssuer, error) {
+	b, err := os.ReadFile(oc.CAKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA key: %v", err)
+	}
+
+	ca, err := gossh.ParsePrivateKey(b)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CA key: %v", err)
+	}
+
+	names := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		names[id.Name] = true
+	}
+
+	return &certIssuer{
+		ca:       ca,
+		validity: oc.Validity.Duration,
+		claim:    oc.Claim,
+		ids:      names,
+		v:        newOIDCVerifier(oc.Issuer, oc.ClientID, http.DefaultClient),
+		now:      time.Now,
+		ll:       ll,
+	}, nil
+}
+
+// listen opens the HTTPS listener for the OIDC configuration.
+func (oc *oidcConfig) listen() (net.Listener, error) {
+	cert, err := tls.LoadX509KeyPair(oc.TLSCert, oc.TLSKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %v", err)
+	}
+
+	l, err := net.Listen("tcp", oc.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	return tls.NewListener(l, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}), nil
+}
+
+// serve serves certificate requests at "/certificate" on l.
+func (ci *certIssuer) serve(l net.Listener) error {
+	mux := http.NewServeMux()
+	mux.Handle("/certificate", ci)
+
+	s := &http.Server{
+		ReadTimeout: 10 * time.Second,
+		Handler:     mux,
+	}
+
+	return s.Serve(l)
+}
+
+// ServeHTTP implements http.Handler.
+func (ci *certIssuer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		http.Error(w, "missing bearer token", http.StatusUnauthorized)
+		return
+	}
+
+	claims, err := ci.v.verify(r.Context(), token)
+	if err != nil {
+		ci.ll.Printf("%s: rejected OIDC token: %v", r.RemoteAddr, err)
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	// Only configured identities may receive a certificate, so that access to
+	// each device is still controlled by the configuration file.
+	name, _ := claims[ci.claim].(string)
+	if verified, _ := claims["email_verified"].(bool); ci.claim == "email" && !verified {
+		// Otherwise anyone who can set an arbitrary email address with the
+		// identity provider could claim an identity.
+		ci.ll.Printf("%s: refused OIDC certificate for unverified email %q", r.RemoteAddr, name)
+		http.Error(w, "unverified email", http.StatusForbidden)
+		return
+	}
+	if !ci.ids[name] {
+		ci.ll.Printf("%s: refused OIDC certificate for unknown identity %q", r.RemoteAddr, name)
+		http.Error(w, "unknown identity", http.StatusForbidden)
+		return
+	}
+
+	b, err := io.ReadAll(io.LimitReader(r.Body, 16*1024))
+	if err != nil {
+		http.Error(w, "failed to read request", http.StatusBadRequest)
+		return
+	}
+
+	key, _, _, _, err := gossh.ParseAuthorizedKey(b)
+	if err != nil {
+		http.Error(w, "invalid SSH public key", http.StatusBadRequest)
+		return
+	}
+	if _, ok := key.(*gossh.Certificate); ok {
+		http.Error(w, "SSH certificates cannot be signed", http.StatusBadRequest)
+		return
+	}
+
+	cert, err := ci.sign(name, key)
+	if err != nil {
+		errorf(ci.ll, "%s: failed to sign certificate for %q: %v", r.RemoteAddr, name, err)
+		http.Error(w, "failed to sign certificate", http.StatusInternalServerError)
+		return
+	}
+
+	ci.ll.Printf("%s: issued certificate %d for %q until %s", r.RemoteAddr, cert.Serial, name,
+		time.Unix(int64(cert.ValidBefore), 0).Format(time.RFC3339))
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = w.Write(gossh.MarshalAuthorizedKey(cert))
+}
+
+// sign issues a user certificate for key which authenticates as identity name.
+func (ci *certIssuer) sign(name string, key gossh.PublicKey) (*gossh.Certificate, error) {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return nil, err
+	}
+
+	// Allow for clock skew between consrv and the client.
+	now := ci.now()
+	cert := &gossh.Certificate{
+		Key:             key,
+		Serial:          binary.BigEndian.Uint64(b[:]),
+		CertType:        gossh.UserCert,
+		KeyId:           name,
+		ValidPrincipals: []string{name},
+		ValidAfter:      uint64(now.Add(-oidcSkew).Unix()),
+		ValidBefore:     uint64(now.Add(ci.validity).Unix()),
+		Permissions: gossh.Permissions{
+			Extensions: map[string]string{"permit-pty": ""},
+		},
+	}
+
+	if err := cert.SignCert(rand.Reader, ci.ca); err != nil {
+		return nil, err
+	}
+
+	return cert, nil
+}
+
+// An oidcVerifier verifies OIDC ID tokens using the signing keys published by
+// an identity provider.
+type oidcVerifier struct {
+	issuer, clientID string
+	c                *http.Client
+	now              func() time.Time
+
+	mu      sync.Mutex
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+}
+
+// newOIDCVerifier creates an oidcVerifier for tokens from issuer with the
+// audience clientID, which fetches signing keys using c.
+func newOIDCVerifier(issuer, clientID string, c *http.Client) *oidcVerifier {
+	return &oidcVerifier{
+		issuer:   issuer,
+		clientID: clientID,
+		c:        c,
+		now:      time.Now,
+	}
+}
+
+// verify verifies the signature and standard claims of an ID token, and
+// returns all of its claims.
+func (v *oidcVerifier) verify(ctx context.Context, token string) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed token header: %v", err)
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token signature: %v", err)
+	}
+
+	key, err := v.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only asymmetric algorithms are supported, and the algorithm must match
+	// the key, so that a token can't choose how it is verified.
+	sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if header.Alg != "RS256" {
+			return nil, fmt.Errorf("unsupported algorithm %q for RSA key", header.Alg)
+		}
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], sig); err != nil {
+			return nil, errors.New("invalid token signature")
+		}
+	case *ecdsa.PublicKey:
+		if header.Alg != "ES256" {
+			return nil, fmt.Errorf("unsupported algorithm %q for ECDSA key", header.Alg)
+		}
+		if len(sig) != 64 {
+			return nil, errors.New("invalid token signature")
+		}
+		r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(key, sum[:], r, s) {
+			return nil, errors.New("invalid token signature")
+		}
+	}
+
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed token claims: %v", err)
+	}
+
+	if iss, _ := claims["iss"].(string); iss != v.issuer {
+		return nil, fmt.Errorf("unexpected issuer %q", iss)
+	}
+
+	var aud []any
+	switch a := claims["aud"].(type) {
+	case string:
+		aud = []any{a}
+	case []any:
+		aud = a
+	}
+	if !slices.Contains(aud, any(v.clientID)) {
+		return nil, fmt.Errorf("token audience does not include %q", v.clientID)
+	}
+
+	now := v.now()
+	exp, ok := claims["exp"].(float64)
+	if !ok || now.Add(-oidcSkew).After(time.Unix(int64(exp), 0)) {
+		return nil, errors.New("token has expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(oidcSkew).Before(time.Unix(int64(nbf), 0)) {
+		return nil, errors.New("token is not yet valid")
+	}
+
+	return claims, nil
+}
+
+// key returns the signing key with ID kid, fetching the identity provider's
+// keys if kid is unknown and they weren't fetched recently.
+func (v *oidcVerifier) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if key, ok := v.keys[kid]; ok {
+		return key, nil
+	}
+
+	if now := v.now(); now.Sub(v.fetched) >= oidcRefresh {
+		keys, err := v.fetch(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch OIDC keys: %v", err)
+		}
+
+		v.keys, v.fetched = keys, now
+	}
+
+	key, ok := v.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	return key, nil
+}
+
+// fetch fetches the identity provider's signing keys using OIDC discovery.
+func (v *oidcVerifier) fetch(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, oidcTimeout)
+	defer cancel()
+
+	// The keys authenticate every token, so they must not be fetched over
+	// plaintext HTTP, even if the identity provider advertises it.
+	if !isHTTPS(v.issuer) {
+		return nil, fmt.Errorf("OIDC issuer %q must be an HTTPS URL", v.issuer)
+	}
+
+	var discovery struct {
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := v.get(ctx, strings.TrimSuffix(v.issuer, "/")+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, err
+	}
+	if !isHTTPS(discovery.JWKSURI) {
+		return nil, fmt.Errorf("OIDC JWKS URI %q must be an HTTPS URL", discovery.JWKSURI)
+	}
+
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Crv string `json:"crv"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := v.get(ctx, discovery.JWKSURI, &jwks); err != nil {
+		return nil, err
+	}
+
+	// Skip keys of unsupported types, which may be used for other purposes.
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range jwks.Keys {
+		switch {
+		case k.Kty == "RSA":
+			n, err1 := base64.RawURLEncoding.DecodeString(k.N)
+			e, err2 := base64.RawURLEncoding.DecodeString(k.E)
+			if err := errors.Join(err1, err2); err != nil || len(e) > 4 {
+				return nil, fmt.Errorf("malformed RSA key %q", k.Kid)
+			}
+
+			keys[k.Kid] = &rsa.PublicKey{
+				N: new(big.Int).SetBytes(n),
+				E: int(new(big.Int).SetBytes(e).Int64()),
+			}
+		case k.Kty == "EC" && k.Crv == "P-256":
+			x, err1 := base64.RawURLEncoding.DecodeString(k.X)
+			y, err2 := base64.RawURLEncoding.DecodeString(k.Y)
+			if err := errors.Join(err1, err2); err != nil {
+				return nil, fmt.Errorf("malformed ECDSA key %q", k.Kid)
+			}
+
+			key := &ecdsa.PublicKey{
+				Curve: elliptic.P256(),
+				X:     new(big.Int).SetBytes(x),
+				Y:     new(big.Int).SetBytes(y),
+			}
+			if !key.Curve.IsOnCurve(key.X, key.Y) {
+				return nil, fmt.Errorf("malformed ECDSA key %q", k.Kid)
+			}
+
+			keys[k.Kid] = key
+		}
+	}
+
+	return keys, nil
+}
+
+// get fetches the JSON document at u into out.
+func (v *oidcVerifier) get(ctx context.Context, u string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := v.c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned HTTP %d", u, res.StatusCode)
+	}
+
+	return json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(out)
+}
+
+// isHTTPS reports whether s is an absolute HTTPS URL.
+func isHTTPS(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && u.Scheme == "https" && u.Host != ""
+}
+
+// decodeSegment decodes a base64url-encoded JSON token segment into v.
+func decodeSegment(s string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, v)
+}
@@ -0,0 +1,383 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mdlayher/consrv"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func Test_oidcVerifier(t *testing.T) {
+	v, sign := testOIDC(t)
+	now := time.Now()
+
+	tests := []struct {
+		name   string
+		kid    string
+		alg    string
+		claims func(c map[string]any)
+		ok     bool
+	}{
+		{
+			name: "OK ES256",
+			kid:  "ec",
+			alg:  "ES256",
+			ok:   true,
+		},
+		{
+			name: "OK RS256",
+			kid:  "rsa",
+			alg:  "RS256",
+			ok:   true,
+		},
+		{
+			name:   "OK audience list",
+			kid:    "ec",
+			alg:    "ES256",
+			claims: func(c map[string]any) { c["aud"] = []string{"other", "consrv"} },
+			ok:     true,
+		},
+		{
+			name: "algorithm mismatch",
+			kid:  "rsa",
+			alg:  "ES256",
+		},
+		{
+			name: "unsigned",
+			kid:  "ec",
+			alg:  "none",
+		},
+		{
+			name: "unknown key",
+			kid:  "unknown",
+			alg:  "ES256",
+		},
+		{
+			name:   "bad issuer",
+			kid:    "ec",
+			alg:    "ES256",
+			claims: func(c map[string]any) { c["iss"] = "https://evil.example.com" },
+		},
+		{
+			name:   "bad audience",
+			kid:    "ec",
+			alg:    "ES256",
+			claims: func(c map[string]any) { c["aud"] = "other" },
+		},
+		{
+			name:   "expired",
+			kid:    "ec",
+			alg:    "ES256",
+			claims: func(c map[string]any) { c["exp"] = now.Add(-time.Hour).Unix() },
+		},
+		{
+			name:   "not yet valid",
+			kid:    "ec",
+			alg:    "ES256",
+			claims: func(c map[string]any) { c["nbf"] = now.Add(time.Hour).Unix() },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := map[string]any{
+				"iss":   v.issuer,
+				"aud":   "consrv",
+				"exp":   now.Add(time.Hour).Unix(),
+				"email": "mdlayher",
+			}
+			if tt.claims != nil {
+				tt.claims(claims)
+			}
+
+			got, err := v.verify(context.Background(), sign(tt.kid, tt.alg, claims))
+			if tt.ok && err != nil {
+				t.Fatalf("failed to verify token: %v", err)
+			}
+			if !tt.ok {
+				if err == nil {
+					t.Fatal("expected an error, but none occurred")
+				}
+
+				return
+			}
+
+			if diff := cmp.Diff("mdlayher", got["email"]); diff != "" {
+				t.Fatalf("unexpected email claim (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	// Tampering with the claims invalidates the signature.
+	token := sign("ec", "ES256", map[string]any{
+		"iss": v.issuer,
+		"aud": "consrv",
+		"exp": now.Add(time.Hour).Unix(),
+	})
+	parts := strings.Split(token, ".")
+	parts[1] = base64.RawURLEncoding.EncodeToString([]byte(`{"iss":"` + v.issuer + `","aud":"consrv","exp":9999999999}`))
+	if _, err := v.verify(context.Background(), strings.Join(parts, ".")); err == nil {
+		t.Fatal("expected an error verifying a tampered token, but none occurred")
+	}
+}
+
+func Test_oidcVerifierHTTPS(t *testing.T) {
+	_, sign := testOIDC(t)
+
+	// Both servers advertise keys over plaintext HTTP.
+	discovery := func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{"jwks_uri": "http://" + r.Host + "/keys"})
+	}
+
+	plain := httptest.NewServer(http.HandlerFunc(discovery))
+	t.Cleanup(plain.Close)
+	secure := httptest.NewTLSServer(http.HandlerFunc(discovery))
+	t.Cleanup(secure.Close)
+
+	tests := []struct {
+		name string
+		srv  *httptest.Server
+		err  string
+	}{
+		{
+			name: "issuer",
+			srv:  plain,
+			err:  `OIDC issuer "` + plain.URL + `" must be an HTTPS URL`,
+		},
+		{
+			name: "JWKS URI",
+			srv:  secure,
+			err:  `OIDC JWKS URI "http://` + secure.Listener.Addr().String() + `/keys" must be an HTTPS URL`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := newOIDCVerifier(tt.srv.URL, "consrv", tt.srv.Client())
+			_, err := v.verify(context.Background(), sign("ec", "ES256", map[string]any{
+				"iss": tt.srv.URL,
+				"aud": "consrv",
+				"exp": time.Now().Add(time.Hour).Unix(),
+			}))
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("expected an error containing %q, but got: %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func Test_certIssuer(t *testing.T) {
+	v, sign := testOIDC(t)
+	ca, err := gossh.NewSignerFromKey(mustECDSA())
+	if err != nil {
+		t.Fatalf("failed to create CA signer: %v", err)
+	}
+
+	ci := &certIssuer{
+		ca:       ca,
+		validity: 8 * time.Hour,
+		claim:    "email",
+		ids:      map[string]bool{"mdlayher": true},
+		v:        v,
+		now:      time.Now,
+		ll:       log.New(io.Discard, "", 0),
+	}
+
+	user, err := gossh.NewPublicKey(&mustECDSA().PublicKey)
+	if err != nil {
+		t.Fatalf("failed to create user key: %v", err)
+	}
+	body := string(gossh.MarshalAuthorizedKey(user))
+
+	token := func(email string, verified bool) string {
+		return sign("ec", "ES256", map[string]any{
+			"iss":            v.issuer,
+			"aud":            "consrv",
+			"exp":            time.Now().Add(time.Hour).Unix(),
+			"email":          email,
+			"email_verified": verified,
+		})
+	}
+
+	tests := []struct {
+		name, method, token, body string
+		status                    int
+	}{
+		{
+			name:   "OK",
+			method: http.MethodPost,
+			token:  token("mdlayher", true),
+			body:   body,
+			status: http.StatusOK,
+		},
+		{
+			name:   "bad method",
+			method: http.MethodGet,
+			token:  token("mdlayher", true),
+			status: http.StatusMethodNotAllowed,
+		},
+		{
+			name:   "no token",
+			method: http.MethodPost,
+			body:   body,
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:   "unknown identity",
+			method: http.MethodPost,
+			token:  token("unknown", true),
+			body:   body,
+			status: http.StatusForbidden,
+		},
+		{
+			name:   "unverified email",
+			method: http.MethodPost,
+			token:  token("mdlayher", false),
+			body:   body,
+			status: http.StatusForbidden,
+		},
+		{
+			name:   "bad key",
+			method: http.MethodPost,
+			token:  token("mdlayher", true),
+			body:   "ssh-ed25519 foo",
+			status: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/certificate", strings.NewReader(tt.body))
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+
+			w := httptest.NewRecorder()
+			ci.ServeHTTP(w, r)
+
+			if diff := cmp.Diff(tt.status, w.Code); diff != "" {
+				t.Fatalf("unexpected HTTP status (-want +got):\n%s", diff)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			key, _, _, _, err := gossh.ParseAuthorizedKey(w.Body.Bytes())
+			if err != nil {
+				t.Fatalf("failed to parse certificate: %v", err)
+			}
+
+			// The certificate authenticates as the identity with a server
+			// which trusts the CA.
+			ids, err := consrv.NewIdentities([]consrv.Identity{{Name: "mdlayher"}}, nil, nil)
+			if err != nil {
+				t.Fatalf("failed to create identities: %v", err)
+			}
+			ids.TrustAuthority(ca.PublicKey())
+
+			id, ok := ids.Authenticate("server", key)
+			if !ok {
+				t.Fatal("failed to authenticate with certificate")
+			}
+			if diff := cmp.Diff("mdlayher", id); diff != "" {
+				t.Fatalf("unexpected identity (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// testOIDC starts an OIDC identity provider which publishes an ECDSA key "ec"
+// and an RSA key "rsa", and returns a verifier for it and a function which
+// signs tokens with the provider's keys.
+func testOIDC(t *testing.T) (*oidcVerifier, func(kid, alg string, claims map[string]any) string) {
+	t.Helper()
+
+	ec := mustECDSA()
+	rk, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate RSA key: %v", err)
+	}
+
+	b64 := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+
+	var srv *httptest.Server
+	srv = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			_ = json.NewEncoder(w).Encode(map[string]string{"jwks_uri": srv.URL + "/keys"})
+		case "/keys":
+			_ = json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{
+				{"kty": "EC", "kid": "ec", "crv": "P-256", "x": b64(ec.X.FillBytes(make([]byte, 32))), "y": b64(ec.Y.FillBytes(make([]byte, 32)))},
+				{"kty": "RSA", "kid": "rsa", "n": b64(rk.N.Bytes()), "e": b64(big.NewInt(int64(rk.E)).Bytes())},
+			}})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	sign := func(kid, alg string, claims map[string]any) string {
+		hb, _ := json.Marshal(map[string]string{"kid": kid, "alg": alg})
+		cb, _ := json.Marshal(claims)
+		input := b64(hb) + "." + b64(cb)
+		sum := sha256.Sum256([]byte(input))
+
+		var sig []byte
+		switch alg {
+		case "ES256":
+			r, s, err := ecdsa.Sign(rand.Reader, ec, sum[:])
+			if err != nil {
+				panicf("failed to sign: %v", err)
+			}
+			sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+		case "RS256":
+			sig, err = rsa.SignPKCS1v15(rand.Reader, rk, crypto.SHA256, sum[:])
+			if err != nil {
+				panicf("failed to sign: %v", err)
+			}
+		}
+
+		return input + "." + b64(sig)
+	}
+
+	return newOIDCVerifier(srv.URL, "consrv", srv.Client()), sign
+}
+
+func mustECDSA() *ecdsa.PrivateKey {
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panicf("failed to generate ECDSA key: %v", err)
+	}
+
+	return k
+}
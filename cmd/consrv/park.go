@@ -0,0 +1,278 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/mdlayher/consrv"
+)
+
+var _ consrv.Device = &parkDevice{}
+
+var (
+	errParked    = errors.New("device is already parked")
+	errNotParked = errors.New("device is not parked")
+)
+
+// A parkDevice is a consrv.Device which may be parked, closing the underlying
+// device so that external tools such as flashrom or openocd can use its serial
+// port, and later resumed by reopening it. While parked, reads block and
+// writes are discarded, so attached sessions remain open.
+type parkDevice struct {
+	name string
+	open func() (consrv.Device, error)
+	ll   *log.Logger
+
+	mu      sync.Mutex
+	dev     consrv.Device
+	str     string
+	closed  bool
+	resumed chan struct{}
+}
+
+// newParkDevice creates a parkDevice for d which wraps dev and uses open to
+// reopen the device when it is resumed.
+func newParkDevice(d rawDevice, dev consrv.Device, open func() (consrv.Device, error), ll *log.Logger) *parkDevice {
+	return &parkDevice{
+		name: d.Name,
+		open: open,
+		ll:   ll,
+		dev:  dev,
+	}
+}
+
+// Close implements io.ReadWriteCloser.
+func (d *parkDevice) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.closed {
+		return nil
+	}
+
+	d.closed = true
+	if d.dev == nil {
+		// Unblock any reads waiting for the device to resume.
+		close(d.resumed)
+		return nil
+	}
+
+	return d.dev.Close()
+}
+
+// Read implements io.ReadWriteCloser.
+func (d *parkDevice) Read(b []byte) (int, error) {
+	for {
+		d.mu.Lock()
+		dev, closed, resumed := d.dev, d.closed, d.resumed
+		d.mu.Unlock()
+
+		if closed && dev == nil {
+			return 0, io.EOF
+		}
+		if dev == nil {
+			<-resumed
+			continue
+		}
+
+		n, err := dev.Read(b)
+		if err != nil && d.parked(dev) {
+			// The read failed because the device was parked, which must not
+			// be reported to the mux as a device error.
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+
+		return n, err
+	}
+}
+
+// Write implements io.ReadWriteCloser.
+func (d *parkDevice) Write(b []byte) (int, error) {
+	d.mu.Lock()
+	dev := d.dev
+	d.mu.Unlock()
+
+	if dev == nil {
+		// Discard input so that sessions are not ended while parked.
+		return len(b), nil
+	}
+
+	return dev.Write(b)
+}
+
+// park closes the underlying device until resume is called.
+func (d *parkDevice) park() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	switch {
+	case d.closed:
+		return errClosed
+	case d.dev == nil:
+		return errParked
+	}
+
+	dev := d.dev
+	d.str = dev.String()
+	d.dev = nil
+	d.resumed = make(chan struct{})
+
+	return dev.Close()
+}
+
+// resume reopens the underlying device after park.
+func (d *parkDevice) resume() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	switch {
+	case d.closed:
+		return errClosed
+	case d.dev != nil:
+		return errNotParked
+	}
+
+	dev, err := d.open()
+	if err != nil {
+		return err
+	}
+
+	d.dev = dev
+	close(d.resumed)
+	return nil
+}
+
+// parked reports whether dev was replaced by parking the device.
+func (d *parkDevice) parked(dev consrv.Device) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return !d.closed && d.dev != dev
+}
+
+// isParked reports whether the device is currently parked.
+func (d *parkDevice) isParked() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return !d.closed && d.dev == nil
+}
+
+// reconnect implements reconnector for devices which reconnect.
+func (d *parkDevice) reconnect(err error) bool {
+	d.mu.Lock()
+	dev := d.dev
+	d.mu.Unlock()
+
+	rc, ok := dev.(reconnector)
+	if !ok {
+		return false
+	}
+
+	return rc.reconnect(err)
+}
+
+// connected implements connector, reporting false while the device is parked.
+func (d *parkDevice) connected() bool {
+	d.mu.Lock()
+	dev := d.dev
+	d.mu.Unlock()
+
+	if dev == nil {
+		return false
+	}
+
+	c, ok := dev.(connector)
+	if !ok {
+		return true
+	}
+
+	return c.connected()
+}
+
+// String returns the string representation of a parkDevice.
+func (d *parkDevice) String() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.dev == nil {
+		return d.str + ", parked: true"
+	}
+
+	return d.dev.String()
+}
+
+// A parkHandler parks and resumes devices over HTTP, so that external tools can
+// temporarily use their serial ports:
+//
+//	GET /park/{device}
+//	PUT /park/{device}
+//	DELETE /park/{device}
+//
+// Attached sessions are notified when a device is parked and resumed.
+type parkHandler struct {
+	devices map[string]*parkDevice
+	notify  func(device, format string, v ...any)
+	ll      *log.Logger
+}
+
+// ServeHTTP implements http.Handler.
+func (ph *parkHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("device")
+	d, ok := ph.devices[name]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+	var err error
+	switch r.Method {
+	case http.MethodPut:
+		if err = d.park(); err == nil {
+			ph.ll.Printf("%s: parked device for external tools", name)
+			ph.notify(name, "device %q is parked for external tools, output is paused and input is discarded", name)
+		}
+	case http.MethodDelete:
+		if err = d.resume(); err == nil {
+			ph.ll.Printf("%s: resumed parked device", name)
+			ph.notify(name, "device %q is resumed", name)
+		}
+	}
+	switch {
+	case errors.Is(err, errParked), errors.Is(err, errNotParked):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if !d.isParked() {
+		_, _ = fmt.Fprintln(w, "active")
+		return
+	}
+
+	_, _ = fmt.Fprintln(w, "parked")
+}
@@ -0,0 +1,177 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mdlayher/consrv"
+)
+
+func Test_parkDevice(t *testing.T) {
+	first, firstRemote := newPipeDevice()
+	second, secondRemote := newPipeDevice()
+
+	var opened int
+	pd := newParkDevice(rawDevice{Name: "server"}, first, func() (consrv.Device, error) {
+		opened++
+		return second, nil
+	}, log.New(io.Discard, "", 0))
+
+	type read struct {
+		s   string
+		err error
+	}
+	readC := make(chan read)
+	go func() {
+		b := make([]byte, 64)
+		for {
+			n, err := pd.Read(b)
+			readC <- read{s: string(b[:n]), err: err}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	next := func() string {
+		t.Helper()
+
+		r := <-readC
+		if r.err != nil {
+			t.Fatalf("failed to read: %v", r.err)
+		}
+
+		return r.s
+	}
+
+	write := func(c net.Conn, s string) {
+		t.Helper()
+
+		if _, err := io.WriteString(c, s); err != nil {
+			t.Fatalf("failed to write device output: %v", err)
+		}
+	}
+
+	write(firstRemote, "hello")
+	if diff := cmp.Diff("hello", next()); diff != "" {
+		t.Fatalf("unexpected read (-want +got):\n%s", diff)
+	}
+
+	// Parking closes the device without returning an error to the reader,
+	// and discards input.
+	if err := pd.park(); err != nil {
+		t.Fatalf("failed to park: %v", err)
+	}
+	if err := pd.park(); err != errParked {
+		t.Fatalf("expected already parked error, but got: %v", err)
+	}
+	if _, err := pd.Write([]byte("discarded")); err != nil {
+		t.Fatalf("failed to write while parked: %v", err)
+	}
+	if pd.connected() {
+		t.Fatal("parked device reports it is connected")
+	}
+
+	if err := pd.resume(); err != nil {
+		t.Fatalf("failed to resume: %v", err)
+	}
+	if err := pd.resume(); err != errNotParked {
+		t.Fatalf("expected not parked error, but got: %v", err)
+	}
+
+	write(secondRemote, "world")
+	if diff := cmp.Diff("world", next()); diff != "" {
+		t.Fatalf("unexpected read (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(1, opened); diff != "" {
+		t.Fatalf("unexpected number of opens (-want +got):\n%s", diff)
+	}
+
+	// Once closed, the reader observes the error.
+	if err := pd.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+	if got := <-readC; got.err == nil {
+		t.Fatal("expected an error after close, but none occurred")
+	}
+}
+
+func Test_parkHandler(t *testing.T) {
+	dev, _ := newPipeDevice()
+	pd := newParkDevice(rawDevice{Name: "server"}, dev, func() (consrv.Device, error) {
+		dev, _ := newPipeDevice()
+		return dev, nil
+	}, log.New(io.Discard, "", 0))
+
+	var notified []string
+	ph := &parkHandler{
+		devices: map[string]*parkDevice{"server": pd},
+		notify: func(device, format string, v ...any) {
+			notified = append(notified, device)
+		},
+		ll: log.New(io.Discard, "", 0),
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/park/{device}", ph)
+
+	tests := []struct {
+		method, device string
+		code           int
+		body           string
+	}{
+		{method: http.MethodGet, device: "server", code: http.StatusOK, body: "active\n"},
+		{method: http.MethodPut, device: "server", code: http.StatusOK, body: "parked\n"},
+		{method: http.MethodPut, device: "server", code: http.StatusConflict, body: "device is already parked\n"},
+		{method: http.MethodGet, device: "server", code: http.StatusOK, body: "parked\n"},
+		{method: http.MethodDelete, device: "server", code: http.StatusOK, body: "active\n"},
+		{method: http.MethodDelete, device: "server", code: http.StatusConflict, body: "device is not parked\n"},
+		{method: http.MethodPut, device: "desktop", code: http.StatusNotFound, body: "404 page not found\n"},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(tt.method, "/park/"+tt.device, nil))
+
+		if diff := cmp.Diff(tt.code, w.Code); diff != "" {
+			t.Fatalf("unexpected %s status (-want +got):\n%s", tt.method, diff)
+		}
+		if diff := cmp.Diff(tt.body, w.Body.String()); diff != "" {
+			t.Fatalf("unexpected %s body (-want +got):\n%s", tt.method, diff)
+		}
+	}
+
+	if diff := cmp.Diff([]string{"server", "server"}, notified); diff != "" {
+		t.Fatalf("unexpected notifications (-want +got):\n%s", diff)
+	}
+}
+
+// A pipeDevice is a consrv.Device backed by one side of a net.Pipe.
+type pipeDevice struct{ net.Conn }
+
+func (*pipeDevice) String() string { return "pipe" }
+
+// newPipeDevice creates a pipeDevice and returns it along with the far side of
+// the device.
+func newPipeDevice() (*pipeDevice, net.Conn) {
+	local, remote := net.Pipe()
+	return &pipeDevice{Conn: local}, remote
+}
@@ -0,0 +1,202 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/mdlayher/consrv"
+)
+
+var _ consrv.Device = &pendingDevice{}
+
+// A reconnector is a consrv.Device which can be reopened after a read fails.
+type reconnector interface {
+	// reconnect closes the device after err, and reports whether the device
+	// will be reopened by the following read.
+	reconnect(err error) bool
+}
+
+// A pendingDevice is a consrv.Device for a device path which does not exist
+// yet, such as a UART which appears after a device tree overlay is loaded.
+// Reads block until the path appears and the device is opened, after which
+// all operations are delegated to the opened device.
+type pendingDevice struct {
+	name, path string
+	open       func() (consrv.Device, error)
+	watch      func(path string, done <-chan struct{}) error
+	ll         *log.Logger
+
+	done chan struct{}
+
+	mu     sync.Mutex
+	closed bool
+	dev    consrv.Device
+	str    string
+}
+
+// newPendingDevice creates a pendingDevice for d which uses watch to wait for
+// d's path to appear and open to open it.
+func newPendingDevice(
+	d rawDevice,
+	open func() (consrv.Device, error),
+	watch func(path string, done <-chan struct{}) error,
+	ll *log.Logger,
+) *pendingDevice {
+	return &pendingDevice{
+		name:  d.Name,
+		path:  d.Device,
+		open:  open,
+		watch: watch,
+		ll:    ll,
+		done:  make(chan struct{}),
+		str:   fmt.Sprintf("%q: path: %q, baud: %d, pending: true", d.Name, d.Device, d.Baud),
+	}
+}
+
+// Close implements io.ReadWriteCloser.
+func (d *pendingDevice) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.closed {
+		return nil
+	}
+
+	d.closed = true
+	close(d.done)
+	if d.dev != nil {
+		return d.dev.Close()
+	}
+
+	return nil
+}
+
+// Read implements io.ReadWriteCloser.
+func (d *pendingDevice) Read(b []byte) (int, error) {
+	dev, err := d.wait()
+	if err != nil {
+		return 0, io.EOF
+	}
+
+	return dev.Read(b)
+}
+
+// Write implements io.ReadWriteCloser.
+func (d *pendingDevice) Write(b []byte) (int, error) {
+	d.mu.Lock()
+	dev := d.dev
+	d.mu.Unlock()
+
+	if dev == nil {
+		return 0, fmt.Errorf("device %q is not present", d.name)
+	}
+
+	return dev.Write(b)
+}
+
+// reconnect implements reconnector by closing the opened device, so that the
+// following read waits for the device's path to reappear and reopens it.
+func (d *pendingDevice) reconnect(err error) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.closed {
+		return false
+	}
+
+	if d.dev != nil {
+		errorf(d.ll, "%s: reopening device %q after read error: %v", d.name, d.path, err)
+		_ = d.dev.Close()
+		d.dev = nil
+	}
+
+	return true
+}
+
+// connected implements connector.
+func (d *pendingDevice) connected() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.dev != nil
+}
+
+// String returns the string representation of a pendingDevice.
+func (d *pendingDevice) String() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.dev != nil {
+		return d.dev.String()
+	}
+
+	return d.str
+}
+
+// wait returns the opened device, blocking until the device's path appears
+// and it can be opened, or the pendingDevice is closed.
+func (d *pendingDevice) wait() (consrv.Device, error) {
+	for {
+		d.mu.Lock()
+		dev, closed := d.dev, d.closed
+		d.mu.Unlock()
+
+		if closed {
+			return nil, errClosed
+		}
+		if dev != nil {
+			return dev, nil
+		}
+
+		if err := d.watch(d.path, d.done); err != nil {
+			if errors.Is(err, errClosed) {
+				return nil, err
+			}
+
+			errorf(d.ll, "%s: failed to watch for %q: %v", d.name, d.path, err)
+			time.Sleep(1 * time.Second)
+			continue
+		}
+
+		dev, err := d.open()
+		if err != nil {
+			// The device may have disappeared again, or may not be accessible
+			// until udev has finished setting its permissions.
+			if !errors.Is(err, os.ErrNotExist) {
+				errorf(d.ll, "%s: failed to open %q: %v", d.name, d.path, err)
+			}
+			time.Sleep(1 * time.Second)
+			continue
+		}
+
+		d.mu.Lock()
+		if d.closed {
+			d.mu.Unlock()
+			_ = dev.Close()
+			return nil, errClosed
+		}
+		d.dev = dev
+		d.mu.Unlock()
+
+		d.ll.Printf("%s: opened device %q", d.name, d.path)
+		return dev, nil
+	}
+}
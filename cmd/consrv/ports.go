@@ -0,0 +1,170 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// windowsSerial parses the USB serial number of a device from the components of
+// its Windows device instance path, such as:
+//
+//   - USB\VID_0403&PID_6001\A64NMAJS
+//   - FTDIBUS\VID_0403+PID_6001+A64NMAJSA\0000
+func windowsSerial(bus, hardwareID, instance string) (string, bool) {
+	switch strings.ToUpper(bus) {
+	case "USB":
+		// Windows generates instance IDs containing '&' for devices which don't
+		// report a serial number.
+		if instance == "" || strings.Contains(instance, "&") {
+			return "", false
+		}
+
+		return instance, true
+	case "FTDIBUS":
+		// The FTDI driver appends a letter identifying the port on multi-port
+		// adapters to the serial number.
+		ss := strings.Split(hardwareID, "+")
+		if len(ss) != 3 || len(ss[2]) < 2 {
+			return "", false
+		}
+
+		return ss[2][:len(ss[2])-1], true
+	default:
+		return "", false
+	}
+}
+
+// A portInfo contains information about a USB serial port reported by the
+// operating system.
+type portInfo struct {
+	serial, product string
+}
+
+// parseIORegistry parses the output of `ioreg -r -c IOUSBHostDevice -l -w 0`
+// and returns the USB serial number and product name of the device which owns
+// each serial port callout device (/dev/cu.*).
+func parseIORegistry(r io.Reader) (map[string]portInfo, error) {
+	// Each entry in the stack is a USB or driver node in the tree. Properties
+	// apply to the node most recently pushed onto the stack, and a serial port
+	// belongs to the nearest ancestor USB device which reported its serial.
+	type node struct {
+		depth int
+		port  portInfo
+	}
+
+	var (
+		stack []node
+		ports = make(map[string]portInfo)
+	)
+
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := s.Text()
+
+		if i := strings.Index(line, "+-o "); i != -1 {
+			for len(stack) > 0 && stack[len(stack)-1].depth >= i {
+				stack = stack[:len(stack)-1]
+			}
+			stack = append(stack, node{depth: i})
+			continue
+		}
+
+		if len(stack) == 0 {
+			continue
+		}
+
+		k, v, ok := strings.Cut(strings.TrimLeft(line, "| "), " = ")
+		if !ok {
+			continue
+		}
+		v = strings.Trim(v, `"`)
+
+		switch strings.Trim(k, `"`) {
+		case "USB Serial Number", "kUSBSerialNumberString":
+			stack[len(stack)-1].port.serial = v
+		case "USB Product Name", "kUSBProductString":
+			stack[len(stack)-1].port.product = v
+		case "IOCalloutDevice":
+			for i := len(stack) - 1; i >= 0; i-- {
+				if stack[i].port.serial != "" {
+					ports[v] = stack[i].port
+					break
+				}
+			}
+		}
+	}
+
+	return ports, s.Err()
+}
+
+// parseFreeBSDSysctl parses the output of `sysctl dev` and returns the USB
+// serial number and description of each ucom(4) device, keyed by its TTY name
+// such as "U0".
+func parseFreeBSDSysctl(r io.Reader) (map[string]portInfo, error) {
+	// Group the properties by device, such as "dev.uftdi.0".
+	type device struct {
+		ttyname string
+		port    portInfo
+	}
+	devices := make(map[string]*device)
+
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		k, v, ok := strings.Cut(s.Text(), ": ")
+		if !ok {
+			continue
+		}
+
+		i := strings.LastIndex(k, ".")
+		if i == -1 {
+			continue
+		}
+		name, prop := k[:i], k[i+1:]
+
+		d, ok := devices[name]
+		if !ok {
+			d = &device{}
+			devices[name] = d
+		}
+
+		switch prop {
+		case "ttyname":
+			d.ttyname = v
+		case "%desc":
+			// Trim the USB class and address information.
+			d.port.product, _, _ = strings.Cut(v, ",")
+		case "%pnpinfo":
+			for _, f := range strings.Fields(v) {
+				if sn, ok := strings.CutPrefix(f, "sernum="); ok {
+					d.port.serial = strings.Trim(sn, `"`)
+				}
+			}
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	ports := make(map[string]portInfo)
+	for _, d := range devices {
+		if d.ttyname != "" {
+			ports[d.ttyname] = d.port
+		}
+	}
+
+	return ports, nil
+}
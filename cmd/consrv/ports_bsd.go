@@ -0,0 +1,61 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build freebsd || openbsd
+
+package main
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// osListPorts enumerates ucom(4) USB serial ports using the filesystem.
+var osListPorts = bsdPorts
+
+// bsdPorts enumerates ucom(4) callout devices and annotates them with any USB
+// serial numbers and descriptions reported by bsdPortInfo.
+func bsdPorts() ([]enumeratedDevice, error) {
+	matches, err := filepath.Glob("/dev/cuaU*")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(matches)
+
+	info, err := bsdPortInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	var eds []enumeratedDevice
+	for _, m := range matches {
+		if strings.HasSuffix(m, ".init") || strings.HasSuffix(m, ".lock") {
+			// Terminal initial state and lock devices, not ports.
+			continue
+		}
+
+		// Multi-port adapters use names like cuaU0.1, but the properties
+		// apply to the adapter as a whole.
+		tty, _, _ := strings.Cut(strings.TrimPrefix(m, "/dev/cua"), ".")
+		p := info[tty]
+
+		eds = append(eds, enumeratedDevice{
+			device:      m,
+			serial:      p.serial,
+			description: p.product,
+		})
+	}
+
+	return eds, nil
+}
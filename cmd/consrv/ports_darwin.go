@@ -0,0 +1,63 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"os/exec"
+	"path/filepath"
+	"sort"
+)
+
+// osListPorts enumerates USB serial ports using the filesystem and I/O
+// Registry.
+var osListPorts = darwinPorts
+
+// darwinPorts enumerates USB serial callout devices and annotates them with USB
+// serial numbers and product names from the I/O Registry.
+func darwinPorts() ([]enumeratedDevice, error) {
+	var devices []string
+	for _, p := range []string{"/dev/cu.usbserial-*", "/dev/cu.usbmodem*"} {
+		matches, err := filepath.Glob(p)
+		if err != nil {
+			return nil, err
+		}
+
+		devices = append(devices, matches...)
+	}
+	sort.Strings(devices)
+
+	// If ioreg is unavailable for some reason, the devices can still be used
+	// by path rather than serial number.
+	var ports map[string]portInfo
+	out, err := exec.Command("ioreg", "-r", "-c", "IOUSBHostDevice", "-l", "-w", "0").Output()
+	if err == nil {
+		ports, err = parseIORegistry(bytes.NewReader(out))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	eds := make([]enumeratedDevice, 0, len(devices))
+	for _, d := range devices {
+		p := ports[d]
+		eds = append(eds, enumeratedDevice{
+			device:      d,
+			serial:      p.serial,
+			description: p.product,
+		})
+	}
+
+	return eds, nil
+}
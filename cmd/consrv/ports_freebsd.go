@@ -0,0 +1,30 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"os/exec"
+)
+
+// bsdPortInfo returns the USB properties of ucom(4) devices using sysctl.
+func bsdPortInfo() (map[string]portInfo, error) {
+	out, err := exec.Command("sysctl", "dev").Output()
+	if err != nil {
+		// The devices can still be used by path rather than serial number.
+		return nil, nil
+	}
+
+	return parseFreeBSDSysctl(bytes.NewReader(out))
+}
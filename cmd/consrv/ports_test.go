@@ -0,0 +1,168 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_windowsSerial(t *testing.T) {
+	tests := []struct {
+		name                  string
+		bus, hardwareID, inst string
+		serial                string
+		ok                    bool
+	}{
+		{
+			name:       "USB serial",
+			bus:        "USB",
+			hardwareID: "VID_0403&PID_6001",
+			inst:       "A64NMAJS",
+			serial:     "A64NMAJS",
+			ok:         true,
+		},
+		{
+			name:       "USB generated instance",
+			bus:        "USB",
+			hardwareID: "VID_2341&PID_0043&MI_00",
+			inst:       "6&2b2c1a0&0&0000",
+		},
+		{
+			name:       "FTDIBUS serial",
+			bus:        "FTDIBUS",
+			hardwareID: "VID_0403+PID_6001+A64NMAJSA",
+			inst:       "0000",
+			serial:     "A64NMAJS",
+			ok:         true,
+		},
+		{
+			name:       "FTDIBUS malformed",
+			bus:        "FTDIBUS",
+			hardwareID: "VID_0403+PID_6001",
+			inst:       "0000",
+		},
+		{
+			name:       "unknown bus",
+			bus:        "PCI",
+			hardwareID: "VEN_8086&DEV_1234",
+			inst:       "3&11583659&0&F8",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serial, ok := windowsSerial(tt.bus, tt.hardwareID, tt.inst)
+			if diff := cmp.Diff(tt.ok, ok); diff != "" {
+				t.Fatalf("unexpected OK (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tt.serial, serial); diff != "" {
+				t.Fatalf("unexpected serial (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_parseIORegistry(t *testing.T) {
+	// Abbreviated output of ioreg with an FTDI adapter and an Arduino, where the
+	// callout devices are nested beneath interface and driver nodes.
+	const out = `+-o FT232R USB UART@01100000  <class IOUSBHostDevice, id 0x100000a2b, registered, matched, active, busy 0 (12 ms), retain 23>
+  | {
+  |   "USB Product Name" = "FT232R USB UART"
+  |   "USB Serial Number" = "A64NMAJS"
+  | }
+  |
+  +-o IOUSBHostInterface@0  <class IOUSBHostInterface, id 0x100000a2d>
+    | {
+    |   "bInterfaceNumber" = 0
+    | }
+    |
+    +-o AppleUSBFTDI  <class AppleUSBFTDI, id 0x100000a31>
+      +-o IOSerialBSDClient  <class IOSerialBSDClient, id 0x100000a34>
+          {
+            "IOCalloutDevice" = "/dev/cu.usbserial-A64NMAJS"
+            "IODialinDevice" = "/dev/tty.usbserial-A64NMAJS"
+          }
++-o Arduino Uno@01200000  <class IOUSBHostDevice, id 0x100000b2b>
+  | {
+  |   "kUSBProductString" = "Arduino Uno"
+  |   "kUSBSerialNumberString" = "85734323231351F0A1B2"
+  | }
+  |
+  +-o IOSerialBSDClient  <class IOSerialBSDClient, id 0x100000b34>
+      {
+        "IOCalloutDevice" = "/dev/cu.usbmodem14201"
+      }
++-o Keyboard@01300000  <class IOUSBHostDevice, id 0x100000c2b>
+    {
+      "USB Product Name" = "Keyboard"
+    }
+`
+
+	got, err := parseIORegistry(strings.NewReader(out))
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+
+	want := map[string]portInfo{
+		"/dev/cu.usbserial-A64NMAJS": {
+			serial:  "A64NMAJS",
+			product: "FT232R USB UART",
+		},
+		"/dev/cu.usbmodem14201": {
+			serial:  "85734323231351F0A1B2",
+			product: "Arduino Uno",
+		},
+	}
+
+	if diff := cmp.Diff(want, got, cmp.AllowUnexported(portInfo{})); diff != "" {
+		t.Fatalf("unexpected ports (-want +got):\n%s", diff)
+	}
+}
+
+func Test_parseFreeBSDSysctl(t *testing.T) {
+	const out = `dev.uftdi.0.ttyports: 1
+dev.uftdi.0.ttyname: U0
+dev.uftdi.0.%parent: uhub0
+dev.uftdi.0.%pnpinfo: vendor=0x0403 product=0x6001 devclass=0x00 devsubclass=0x00 devproto=0x00 sernum="A64NMAJS" release=0x0600 mode=host intclass=0xff intsubclass=0xff intprotocol=0xff
+dev.uftdi.0.%location: bus=0 hubaddr=1 port=1 devaddr=2 interface=0 ugen=ugen0.2
+dev.uftdi.0.%driver: uftdi
+dev.uftdi.0.%desc: FTDI FT232R USB UART, class 0/0, rev 2.00/6.00, addr 2
+dev.umodem.0.ttyname: U1
+dev.umodem.0.%pnpinfo: vendor=0x2341 product=0x0043 devclass=0x02 sernum="" release=0x0001
+dev.umodem.0.%desc: Arduino Uno, class 2/0, rev 1.10/0.01, addr 3
+dev.uhub.0.%desc: Intel XHCI root HUB, class 9/0, rev 3.00/1.00, addr 1
+`
+
+	got, err := parseFreeBSDSysctl(strings.NewReader(out))
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+
+	want := map[string]portInfo{
+		"U0": {
+			serial:  "A64NMAJS",
+			product: "FTDI FT232R USB UART",
+		},
+		"U1": {
+			product: "Arduino Uno",
+		},
+	}
+
+	if diff := cmp.Diff(want, got, cmp.AllowUnexported(portInfo{})); diff != "" {
+		t.Fatalf("unexpected ports (-want +got):\n%s", diff)
+	}
+}
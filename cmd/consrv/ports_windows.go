@@ -0,0 +1,136 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"sort"
+
+	"golang.org/x/sys/windows/registry"
+)
+
+// osListPorts enumerates COM ports using the Windows registry.
+var osListPorts = registryPorts
+
+// registryPorts enumerates all COM ports and annotates them with friendly
+// names and USB serial numbers when available.
+func registryPorts() ([]enumeratedDevice, error) {
+	// SERIALCOMM lists every COM port currently present, regardless of driver.
+	k, err := registry.OpenKey(registry.LOCAL_MACHINE, `HARDWARE\DEVICEMAP\SERIALCOMM`, registry.QUERY_VALUE)
+	if err != nil {
+		if errors.Is(err, registry.ErrNotExist) {
+			// No serial ports.
+			return nil, nil
+		}
+
+		return nil, err
+	}
+	defer k.Close()
+
+	names, err := k.ReadValueNames(-1)
+	if err != nil {
+		return nil, err
+	}
+
+	ports := make(map[string]*enumeratedDevice, len(names))
+	for _, n := range names {
+		port, _, err := k.GetStringValue(n)
+		if err != nil {
+			continue
+		}
+
+		ports[port] = &enumeratedDevice{device: port}
+	}
+
+	// Walk the device instances of buses which commonly host USB to serial
+	// adapters to find the serial number and friendly name of each port.
+	for _, bus := range []string{"USB", "FTDIBUS"} {
+		walkInstances(bus, func(hardwareID, instance string, k registry.Key) {
+			pk, err := registry.OpenKey(k, "Device Parameters", registry.QUERY_VALUE)
+			if err != nil {
+				return
+			}
+			defer pk.Close()
+
+			port, _, err := pk.GetStringValue("PortName")
+			if err != nil {
+				return
+			}
+
+			ed, ok := ports[port]
+			if !ok {
+				// Not currently present.
+				return
+			}
+
+			if serial, ok := windowsSerial(bus, hardwareID, instance); ok {
+				ed.serial = serial
+			}
+			if name, _, err := k.GetStringValue("FriendlyName"); err == nil {
+				ed.description = name
+			}
+		})
+	}
+
+	eds := make([]enumeratedDevice, 0, len(ports))
+	for _, ed := range ports {
+		eds = append(eds, *ed)
+	}
+	sort.Slice(eds, func(i, j int) bool {
+		return eds[i].device < eds[j].device
+	})
+
+	return eds, nil
+}
+
+// walkInstances invokes fn for each device instance key on the specified bus.
+func walkInstances(bus string, fn func(hardwareID, instance string, k registry.Key)) {
+	root := `SYSTEM\CurrentControlSet\Enum\` + bus
+
+	rk, err := registry.OpenKey(registry.LOCAL_MACHINE, root, registry.ENUMERATE_SUB_KEYS)
+	if err != nil {
+		return
+	}
+	defer rk.Close()
+
+	hwIDs, err := rk.ReadSubKeyNames(-1)
+	if err != nil {
+		return
+	}
+
+	for _, hw := range hwIDs {
+		hk, err := registry.OpenKey(rk, hw, registry.ENUMERATE_SUB_KEYS)
+		if err != nil {
+			continue
+		}
+
+		instances, err := hk.ReadSubKeyNames(-1)
+		if err != nil {
+			_ = hk.Close()
+			continue
+		}
+
+		for _, inst := range instances {
+			ik, err := registry.OpenKey(hk, inst, registry.QUERY_VALUE)
+			if err != nil {
+				continue
+			}
+
+			fn(hw, inst, ik)
+			_ = ik.Close()
+		}
+
+		_ = hk.Close()
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2023 Berk D. Demir and Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	privdropUID = 65534 // conventionally: nobody
+	privdropGID = 65534 // conventionally: nogroup
+)
+
+func dropPrivileges() (*privilegesInfo, error) {
+	// Prefer a tmpfs for the empty chroot directory, but not every Linux
+	// system (or container) provides /dev/shm.
+	parent := "/dev/shm"
+	if _, err := os.Stat(parent); err != nil {
+		parent = os.TempDir()
+	}
+
+	dir, err := os.MkdirTemp(parent, "consrv-chroot-*")
+	if err != nil {
+		return nil, fmt.Errorf("create chroot directory: %w", err)
+	}
+
+	if err := syscall.Chroot(dir); err != nil {
+		return nil, fmt.Errorf("chroot %q: %w", dir, err)
+	}
+
+	// Don't leave the working directory pointing outside of the chroot.
+	if err := os.Chdir("/"); err != nil {
+		return nil, fmt.Errorf("chdir: %w", err)
+	}
+
+	// Drop any supplementary groups inherited from the invoking user, which
+	// gokrazy doesn't set but other Linux systems typically do.
+	if err := syscall.Setgroups(nil); err != nil {
+		return nil, fmt.Errorf("setgroups: %w", err)
+	}
+
+	if err := syscall.Setgid(privdropGID); err != nil {
+		return nil, fmt.Errorf("setgid: %w", err)
+	}
+
+	if err := syscall.Setuid(privdropUID); err != nil {
+		return nil, fmt.Errorf("setuid: %w", err)
+	}
+
+	seccomp, err := restrictSyscalls()
+	if err != nil {
+		return nil, err
+	}
+
+	return &privilegesInfo{
+		Chroot:  dir,
+		UID:     privdropUID,
+		GID:     privdropGID,
+		Seccomp: seccomp,
+	}, nil
+}
+
+// restrictSyscalls sets no_new_privs and, if supported on this architecture,
+// installs a seccomp filter for all threads which only permits the system
+// calls consrv needs after it has finished initializing. It reports whether
+// the seccomp filter was installed.
+func restrictSyscalls() (bool, error) {
+	// Both prctl and seccomp must be invoked on the same thread: the seccomp
+	// TSYNC flag then propagates no_new_privs and the filter to all other
+	// threads in the process.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return false, fmt.Errorf("prctl no_new_privs: %w", err)
+	}
+
+	prog, err := seccompFilter()
+	if err != nil {
+		if errors.Is(err, errors.ErrUnsupported) {
+			// No syscall allowlist for this architecture, but no_new_privs
+			// still applies.
+			return false, nil
+		}
+
+		return false, fmt.Errorf("assemble seccomp filter: %w", err)
+	}
+
+	_, _, errno := unix.RawSyscall(
+		unix.SYS_SECCOMP,
+		unix.SECCOMP_SET_MODE_FILTER,
+		unix.SECCOMP_FILTER_FLAG_TSYNC,
+		uintptr(unsafe.Pointer(prog)),
+	)
+	if errno != 0 {
+		return false, fmt.Errorf("seccomp: %w", errno)
+	}
+
+	return true, nil
+}
@@ -11,7 +11,7 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-//go:build !gokrazy
+//go:build !linux
 
 package main
 
@@ -21,5 +21,5 @@ import (
 )
 
 func dropPrivileges() (*privilegesInfo, error) {
-	return nil, fmt.Errorf("implemented only on gokrazy, not on %s/%s", runtime.GOOS, runtime.GOARCH)
+	return nil, fmt.Errorf("implemented only on Linux, not on %s/%s", runtime.GOOS, runtime.GOARCH)
 }
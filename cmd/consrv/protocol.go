@@ -0,0 +1,171 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"time"
+
+	"github.com/mdlayher/consrv"
+	"github.com/mdlayher/metricslite"
+)
+
+// protocolWarnInterval is the minimum interval between warnings for the same
+// protocol on a device.
+const protocolWarnInterval = 1 * time.Minute
+
+// A protocol is a binary protocol which a device may emit instead of console
+// output, such as when a serial port is repurposed for networking or file
+// transfer.
+type protocol struct {
+	name  string
+	match func(b []byte) bool
+}
+
+// protocols are the binary protocols detected by a protocolGuard. Each
+// signature is at most protocolLookback+1 bytes long.
+var protocols = []protocol{
+	{
+		// HDLC-like framing of an all-stations address and UI control field,
+		// with the control field optionally escaped (RFC 1662).
+		name: "ppp",
+		match: func(b []byte) bool {
+			return bytes.Contains(b, []byte{0x7e, 0xff, 0x03}) ||
+				bytes.Contains(b, []byte{0x7e, 0xff, 0x7d, 0x23})
+		},
+	},
+	{
+		// A frame END followed by the start of an IPv4 header with no options
+		// (RFC 1055).
+		name: "slip",
+		match: func(b []byte) bool {
+			return bytes.Contains(b, []byte{0xc0, 0x45})
+		},
+	},
+	{
+		// A Send-Init packet: MARK, LEN, SEQ 0, and TYPE S.
+		name: "kermit",
+		match: func(b []byte) bool {
+			for i := bytes.IndexByte(b, 0x01); i >= 0 && i+3 < len(b); {
+				if b[i+1] >= 0x20 && b[i+1] <= 0x7e && b[i+2] == ' ' && b[i+3] == 'S' {
+					return true
+				}
+
+				j := bytes.IndexByte(b[i+1:], 0x01)
+				if j < 0 {
+					break
+				}
+				i += j + 1
+			}
+
+			return false
+		},
+	},
+}
+
+// protocolLookback is the number of bytes retained between reads so that
+// signatures split across reads are detected.
+const protocolLookback = 3
+
+// A protocolGuard detects binary protocols in device output and warns attached
+// sessions, so a repurposed port is not mistaken for a broken console.
+type protocolGuard struct {
+	name       string
+	detections metricslite.Counter
+	notify     func(format string, v ...any)
+	ll         *log.Logger
+	now        func() time.Time
+
+	warned map[string]time.Time
+}
+
+// newProtocolGuard creates a protocolGuard for device d which warns sessions
+// using notify.
+func newProtocolGuard(d rawDevice, mm *metrics, notify func(format string, v ...any), ll *log.Logger) *protocolGuard {
+	return &protocolGuard{
+		name:       d.Name,
+		detections: mm.deviceProtocolDetections,
+		notify:     notify,
+		ll:         ll,
+		now:        time.Now,
+	}
+}
+
+// run watches output from the mux until the process exits, restarting the
+// watch if it stops.
+func (pg *protocolGuard) run(mux *consrv.MuxDevice) {
+	for {
+		ctx, cancel := context.WithCancel(context.Background())
+		if err := pg.watch(mux.Attach(ctx)); err != nil {
+			errorf(pg.ll, "protocol guard for %q: %v", pg.name, err)
+		}
+		cancel()
+
+		pg.ll.Printf("restarting protocol guard for %q", pg.name)
+		time.Sleep(1 * time.Second)
+	}
+}
+
+// watch reads output from r until it returns an error, and warns whenever a
+// binary protocol is detected.
+func (pg *protocolGuard) watch(r io.Reader) error {
+	var (
+		tail []byte
+		b    = make([]byte, 4096)
+	)
+
+	for {
+		n, err := r.Read(b)
+		if n > 0 {
+			buf := append(tail, b[:n]...)
+			tail = append([]byte(nil), buf[max(0, len(buf)-protocolLookback):]...)
+
+			for _, p := range protocols {
+				if p.match(buf) {
+					pg.detect(p.name)
+
+					// Don't detect the same signature again on the next read.
+					tail = nil
+				}
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// detect records a detection of protocol and warns at most once per
+// protocolWarnInterval.
+func (pg *protocolGuard) detect(protocol string) {
+	pg.detections(1.0, pg.name, protocol)
+
+	if pg.warned == nil {
+		pg.warned = make(map[string]time.Time)
+	}
+
+	now := pg.now()
+	if last, ok := pg.warned[protocol]; ok && now.Sub(last) < protocolWarnInterval {
+		return
+	}
+	pg.warned[protocol] = now
+
+	pg.ll.Printf("detected %s framing on %q", protocol, pg.name)
+
+	// Don't block reading output from the mux while warning sessions.
+	go pg.notify("device %q appears to be emitting %s framing rather than console output", pg.name, protocol)
+}
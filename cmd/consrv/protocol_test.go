@@ -0,0 +1,119 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io"
+	"log"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_protocolGuardWatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		in       []string
+		advance  time.Duration
+		want     map[string]int
+		warnings int
+	}{
+		{
+			name: "console",
+			in:   []string{"U-Boot 2024.01\r\n", "login: "},
+		},
+		{
+			name:     "ppp",
+			in:       []string{"\x7e\xff\x03\xc0\x21\x01\x01\x00\x14"},
+			want:     map[string]int{"ppp": 1},
+			warnings: 1,
+		},
+		{
+			name:     "ppp escaped split",
+			in:       []string{"\x7e\xff", "\x7d\x23\xc0\x21"},
+			want:     map[string]int{"ppp": 1},
+			warnings: 1,
+		},
+		{
+			name:     "slip",
+			in:       []string{"\xc0", "\x45\x00\x00\x54"},
+			want:     map[string]int{"slip": 1},
+			warnings: 1,
+		},
+		{
+			name:     "kermit",
+			in:       []string{"\x01\x01", "# S~! @-#Y3~^>J)0___M\r"},
+			want:     map[string]int{"kermit": 1},
+			warnings: 1,
+		},
+		{
+			name:     "rate limited",
+			in:       []string{"\xc0\x45", "\xc0\x45", "\xc0\x45"},
+			advance:  30 * time.Second,
+			want:     map[string]int{"slip": 3},
+			warnings: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				mu       sync.Mutex
+				got      map[string]int
+				warnings sync.WaitGroup
+				now      time.Time
+			)
+
+			warnings.Add(tt.warnings)
+
+			pg := &protocolGuard{
+				name: "server",
+				detections: func(v float64, labels ...string) {
+					mu.Lock()
+					defer mu.Unlock()
+
+					if got == nil {
+						got = make(map[string]int)
+					}
+					got[labels[1]] += int(v)
+				},
+				notify: func(string, ...any) { warnings.Done() },
+				ll:     log.New(io.Discard, "", 0),
+				now:    func() time.Time { return now },
+			}
+
+			r := &chunkReader{
+				chunks: tt.in,
+				read:   func() { now = now.Add(tt.advance) },
+			}
+
+			if err := pg.watch(r); err != io.EOF {
+				t.Fatalf("failed to watch: %v", err)
+			}
+
+			// Each warning is delivered asynchronously, and a negative
+			// WaitGroup counter panics if too many are delivered.
+			warnings.Wait()
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Fatalf("unexpected detections (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,501 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// provisioningConfig contains the configuration for an HTTP API which
+// manages devices and identities in a configuration fragment on behalf of
+// infrastructure-as-code tooling.
+type provisioningConfig struct {
+	Address   string `toml:"address"`
+	TokenFile string `toml:"token_file"`
+	Fragment  string `toml:"fragment"`
+}
+
+// validate verifies the provisioning configuration.
+func (pc *provisioningConfig) validate() error {
+	if _, _, err := net.SplitHostPort(pc.Address); err != nil {
+		return fmt.Errorf("provisioning must have a valid address: %v", err)
+	}
+	if pc.TokenFile == "" {
+		return errors.New("provisioning must have a token file")
+	}
+	if pc.Fragment == "" {
+		return errors.New("provisioning must have a fragment path")
+	}
+
+	return nil
+}
+
+// fragmentHeader is written at the top of each managed configuration
+// fragment.
+const fragmentHeader = "# This file is managed by the consrv provisioning API; manual changes\n# may be overwritten.\n\n"
+
+// A fragment is a configuration fragment containing the devices and
+// identities managed by the provisioning API. It is appended to the main
+// configuration file when consrv starts.
+type fragment struct {
+	Devices    []managedDevice   `toml:"devices,omitempty"`
+	Identities []managedIdentity `toml:"identities,omitempty"`
+}
+
+// A managedDevice is a device which may be managed by the provisioning API.
+// It is a subset of the fields of a rawDevice.
+type managedDevice struct {
+	Name             string   `toml:"name" json:"name"`
+	Namespace        string   `toml:"namespace,omitempty" json:"namespace,omitempty"`
+	Device           string   `toml:"device,omitempty" json:"device,omitempty"`
+	Serial           string   `toml:"serial,omitempty" json:"serial,omitempty"`
+	USBPath          string   `toml:"usb_path,omitempty" json:"usb_path,omitempty"`
+	Address          string   `toml:"address,omitempty" json:"address,omitempty"`
+	Baud             int      `toml:"baud,omitempty" json:"baud,omitempty"`
+	Identities       []string `toml:"identities,omitempty" json:"identities,omitempty"`
+	DeniedIdentities []string `toml:"denied_identities,omitempty" json:"denied_identities,omitempty"`
+	LogToStdout      bool     `toml:"logtostdout,omitempty" json:"logtostdout,omitempty"`
+	ReadOnly         bool     `toml:"read_only,omitempty" json:"read_only,omitempty"`
+	RequireReason    bool     `toml:"require_reason,omitempty" json:"require_reason,omitempty"`
+	RequireApproval  bool     `toml:"require_approval,omitempty" json:"require_approval,omitempty"`
+}
+
+// withoutAccess returns a copy of md without its access control fields, which are
+// the only device fields that can be applied without a restart.
+func (md managedDevice) withoutAccess() managedDevice {
+	md.Namespace = ""
+	md.Identities = nil
+	md.DeniedIdentities = nil
+	return md
+}
+
+// A managedIdentity is an identity which may be managed by the provisioning
+// API.
+type managedIdentity struct {
+	Name      string `toml:"name" json:"name"`
+	PublicKey string `toml:"public_key" json:"public_key"`
+	Namespace string `toml:"namespace,omitempty" json:"namespace,omitempty"`
+}
+
+// marshal encodes f as TOML.
+func (f fragment) marshal() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteString(fragmentHeader)
+	if err := toml.NewEncoder(&b).Encode(f); err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), nil
+}
+
+// joinFragment appends a configuration fragment to the main configuration.
+// A fragment only contains arrays of tables, so they are merged with any
+// devices and identities in the main configuration.
+func joinFragment(base, frag []byte) []byte {
+	b := make([]byte, 0, len(base)+len(frag)+1)
+	b = append(b, base...)
+	b = append(b, '\n')
+	return append(b, frag...)
+}
+
+// loadFragment appends the managed configuration fragment to the main
+// configuration b, if provisioning is configured and the fragment exists.
+func loadFragment(b []byte) ([]byte, error) {
+	var f struct {
+		Provisioning *provisioningConfig `toml:"provisioning"`
+	}
+	if _, err := toml.NewDecoder(bytes.NewReader(b)).Decode(&f); err != nil {
+		// Leave syntax errors for parseConfig to report.
+		return b, nil
+	}
+	if f.Provisioning == nil || f.Provisioning.Fragment == "" {
+		return b, nil
+	}
+
+	frag, err := os.ReadFile(f.Provisioning.Fragment)
+	if os.IsNotExist(err) {
+		return b, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read provisioning fragment: %v", err)
+	}
+
+	return joinFragment(b, frag), nil
+}
+
+// A provisioner is an http.Handler which creates, updates, and deletes the
+// devices and identities in a configuration fragment:
+//
+//	GET /v1/devices
+//	GET|PUT|DELETE /v1/devices/{name}
+//	GET /v1/identities
+//	GET|PUT|DELETE /v1/identities/{name}
+//	GET /v1/adapters
+//
+// The adapters endpoint lists the serial adapters found on the system with
+// their serial numbers, even if they are masked in logs and metrics.
+//
+// Each change is validated against the full configuration before it is
+// persisted, and identity changes are applied immediately. Adding, removing,
+// or reconfiguring a device's port requires a restart, which is reported in
+// the response.
+type provisioner struct {
+	token []byte
+	path  string
+	base  []byte
+	ll    *log.Logger
+	mux   *http.ServeMux
+
+	// apply applies a valid configuration containing the new fragment.
+	apply func(cfg *config) error
+
+	// expand, if not nil, expands device templates in auto mode as they were
+	// at startup, so the applied configuration has the same devices.
+	expand func(devices []rawDevice) []rawDevice
+
+	// adapters, if not nil, lists the serial adapters found on the system.
+	adapters func() []adapter
+
+	mu   sync.Mutex
+	frag fragment
+}
+
+// newProvisioner creates a provisioner from pc which validates changes
+// against the main configuration base. The apply function must be set before
+// serving.
+func newProvisioner(pc provisioningConfig, base []byte, ll *log.Logger) (*provisioner, error) {
+	token, err := readToken(pc.TokenFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var frag fragment
+	if _, err := toml.DecodeFile(pc.Fragment, &frag); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read fragment: %v", err)
+	}
+
+	p := &provisioner{
+		token: token,
+		path:  pc.Fragment,
+		base:  base,
+		ll:    ll,
+		frag:  frag,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/devices", p.listDevices)
+	mux.HandleFunc("GET /v1/devices/{name}", p.getDevice)
+	mux.HandleFunc("PUT /v1/devices/{name}", p.putDevice)
+	mux.HandleFunc("DELETE /v1/devices/{name}", p.deleteDevice)
+	mux.HandleFunc("GET /v1/identities", p.listIdentities)
+	mux.HandleFunc("GET /v1/identities/{name}", p.getIdentity)
+	mux.HandleFunc("PUT /v1/identities/{name}", p.putIdentity)
+	mux.HandleFunc("DELETE /v1/identities/{name}", p.deleteIdentity)
+	mux.HandleFunc("GET /v1/adapters", p.listAdapters)
+	p.mux = mux
+
+	return p, nil
+}
+
+// serve serves the provisioning API on l.
+func (p *provisioner) serve(l net.Listener) error {
+	s := &http.Server{
+		ReadTimeout: 10 * time.Second,
+		Handler:     p,
+	}
+
+	return s.Serve(l)
+}
+
+// ServeHTTP implements http.Handler.
+func (p *provisioner) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !authorized(w, r, p.token) {
+		return
+	}
+
+	p.mux.ServeHTTP(w, r)
+}
+
+// An adapter is the JSON representation of a serial adapter found on the
+// system.
+type adapter struct {
+	Device   string            `json:"device"`
+	Serial   string            `json:"serial,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// newAdapters converts enumerated devices to adapters.
+func newAdapters(eds []enumeratedDevice) []adapter {
+	as := make([]adapter, 0, len(eds))
+	for _, ed := range eds {
+		a := adapter{Device: ed.device, Serial: ed.serial}
+		if md := ed.metadata(); len(md) > 0 {
+			a.Metadata = md
+		}
+		as = append(as, a)
+	}
+
+	return as
+}
+
+// A provisionResult is the JSON response to a successful change.
+type provisionResult struct {
+	RestartRequired bool `json:"restart_required"`
+}
+
+func (p *provisioner) listDevices(w http.ResponseWriter, _ *http.Request) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, append([]managedDevice{}, p.frag.Devices...))
+}
+
+func (p *provisioner) getDevice(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	i := slices.IndexFunc(p.frag.Devices, func(d managedDevice) bool { return d.Name == r.PathValue("name") })
+	if i == -1 {
+		http.Error(w, fmt.Sprintf("unknown device %q", r.PathValue("name")), http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, p.frag.Devices[i])
+}
+
+func (p *provisioner) putDevice(w http.ResponseWriter, r *http.Request) {
+	var d managedDevice
+	if !decodeJSON(w, r, &d) {
+		return
+	}
+	if d.Name == "" {
+		d.Name = r.PathValue("name")
+	}
+	if d.Name != r.PathValue("name") {
+		http.Error(w, fmt.Sprintf("device name %q does not match %q", d.Name, r.PathValue("name")), http.StatusBadRequest)
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	next := p.frag
+	next.Devices = slices.Clone(p.frag.Devices)
+
+	status, restart := http.StatusCreated, true
+	if i := slices.IndexFunc(next.Devices, func(od managedDevice) bool { return od.Name == d.Name }); i != -1 {
+		// Only the access control fields of an existing device can be
+		// applied while running.
+		status = http.StatusOK
+		restart = !equalDevices(next.Devices[i].withoutAccess(), d.withoutAccess())
+		next.Devices[i] = d
+	} else {
+		next.Devices = append(next.Devices, d)
+	}
+
+	p.commit(w, next, status, restart, fmt.Sprintf("updated device %q", d.Name))
+}
+
+func (p *provisioner) deleteDevice(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	next := p.frag
+	next.Devices = slices.DeleteFunc(slices.Clone(p.frag.Devices), func(d managedDevice) bool {
+		return d.Name == r.PathValue("name")
+	})
+	if len(next.Devices) == len(p.frag.Devices) {
+		http.Error(w, fmt.Sprintf("unknown device %q", r.PathValue("name")), http.StatusNotFound)
+		return
+	}
+
+	p.commit(w, next, http.StatusOK, true, fmt.Sprintf("deleted device %q", r.PathValue("name")))
+}
+
+func (p *provisioner) listAdapters(w http.ResponseWriter, _ *http.Request) {
+	as := []adapter{}
+	if p.adapters != nil {
+		as = p.adapters()
+	}
+
+	writeJSON(w, http.StatusOK, as)
+}
+
+func (p *provisioner) listIdentities(w http.ResponseWriter, _ *http.Request) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, append([]managedIdentity{}, p.frag.Identities...))
+}
+
+func (p *provisioner) getIdentity(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	i := slices.IndexFunc(p.frag.Identities, func(id managedIdentity) bool { return id.Name == r.PathValue("name") })
+	if i == -1 {
+		http.Error(w, fmt.Sprintf("unknown identity %q", r.PathValue("name")), http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, p.frag.Identities[i])
+}
+
+func (p *provisioner) putIdentity(w http.ResponseWriter, r *http.Request) {
+	var id managedIdentity
+	if !decodeJSON(w, r, &id) {
+		return
+	}
+	if id.Name == "" {
+		id.Name = r.PathValue("name")
+	}
+	if id.Name != r.PathValue("name") {
+		http.Error(w, fmt.Sprintf("identity name %q does not match %q", id.Name, r.PathValue("name")), http.StatusBadRequest)
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	next := p.frag
+	next.Identities = slices.Clone(p.frag.Identities)
+
+	status := http.StatusCreated
+	if i := slices.IndexFunc(next.Identities, func(oid managedIdentity) bool { return oid.Name == id.Name }); i != -1 {
+		status = http.StatusOK
+		next.Identities[i] = id
+	} else {
+		next.Identities = append(next.Identities, id)
+	}
+
+	p.commit(w, next, status, false, fmt.Sprintf("updated identity %q", id.Name))
+}
+
+func (p *provisioner) deleteIdentity(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	next := p.frag
+	next.Identities = slices.DeleteFunc(slices.Clone(p.frag.Identities), func(id managedIdentity) bool {
+		return id.Name == r.PathValue("name")
+	})
+	if len(next.Identities) == len(p.frag.Identities) {
+		http.Error(w, fmt.Sprintf("unknown identity %q", r.PathValue("name")), http.StatusNotFound)
+		return
+	}
+
+	p.commit(w, next, http.StatusOK, false, fmt.Sprintf("deleted identity %q", r.PathValue("name")))
+}
+
+// commit validates the fragment next against the main configuration, then
+// persists and applies it. p.mu must be held.
+func (p *provisioner) commit(w http.ResponseWriter, next fragment, status int, restart bool, msg string) {
+	b, err := next.marshal()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to encode fragment: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	cfg, err := parseConfig(bytes.NewReader(joinFragment(p.base, b)))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if p.expand != nil {
+		cfg.Devices = p.expand(cfg.Devices)
+	}
+
+	if err := writeFileAtomic(p.path, b); err != nil {
+		http.Error(w, fmt.Sprintf("failed to write fragment: %v", err), http.StatusInternalServerError)
+		return
+	}
+	p.frag = next
+
+	if err := p.apply(cfg); err != nil {
+		http.Error(w, fmt.Sprintf("failed to apply configuration: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	if restart {
+		msg += ", restart required"
+	}
+	p.ll.Printf("provisioning: %s", msg)
+
+	writeJSON(w, status, provisionResult{RestartRequired: restart})
+}
+
+// equalDevices reports whether a and b have the same configuration.
+func equalDevices(a, b managedDevice) bool {
+	return a.Name == b.Name && a.Device == b.Device && a.Serial == b.Serial &&
+		a.USBPath == b.USBPath && a.Address == b.Address && a.Baud == b.Baud &&
+		a.LogToStdout == b.LogToStdout && a.ReadOnly == b.ReadOnly &&
+		a.RequireReason == b.RequireReason && a.RequireApproval == b.RequireApproval
+}
+
+// writeFileAtomic writes b to a temporary file in the same directory as path
+// and renames it over path, so a crash mid-write can't corrupt the original.
+func writeFileAtomic(path string, b []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), ".consrv-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(b); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
+
+// decodeJSON decodes the JSON request body into v, reporting whether it
+// succeeded. Unknown fields are rejected rather than silently ignored.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	d := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10))
+	d.DisallowUnknownFields()
+	if err := d.Decode(v); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request body: %v", err), http.StatusBadRequest)
+		return false
+	}
+
+	return true
+}
+
+// writeJSON writes v as a JSON response with the HTTP status code.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
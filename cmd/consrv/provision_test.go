@@ -0,0 +1,312 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mdlayher/consrv"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// Public keys used to configure identities in provisioning tests.
+const (
+	testProvisionKey = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+	testProvisionRSA = "ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAABgQDDkvg9+NTySctVaMkbZGwTRIUiQSo4crGWQPeFTi/XM3KhcUY+WduwHChJX1h03/DKJps8wtHUn3LmUKFR4BoJEgt8Od+L6ey5sev4lvPa2wDc5HJfervgCnVt9aomdFqeZUe6g4BDdPLUGbzT3T+A+08ocXy/eVv9Kke7Ka6GslJQQ5TBjW0AbPhxu6QmoZDb0tiWf9CwyVpiox5+vW7E+O6U1QOKT45Ellc2smHSAcI1gUDborS0GhFSso9SagMxcWNbZf8920DeaLs5tb8uwKfWKqHJfkY+VK3QuufpWZM3BJTPa0PePd75NRra2BOV4LDwGlLrZjOCULlYawDlDOIm6rpC3QV7juHTFWjS8ImvbsyEWZSE9N6klDMc23Zl9vhqJcG4U9LVAv2QMcr8aXBnmSo49rkd7/H6yHZgWqmrAijloZkiwsTbofT+lQx3JLEagk1rd8rmCp4F7WeUShvvmTq0tyPDutIhd1TXwLB0gyFObCDgb3CrXPtsACc= test RSA"
+)
+
+func Test_provisioner(t *testing.T) {
+	const base = `
+[[devices]]
+name = "server"
+device = "/dev/ttyUSB0"
+baud = 115200
+identities = ["ed25519"]
+
+[[identities]]
+name = "ed25519"
+public_key = "` + testProvisionKey + `"
+
+[provisioning]
+address = "localhost:9289"
+token_file = "token"
+fragment = "fragment.toml"
+`
+
+	dir := t.TempDir()
+	token := filepath.Join(dir, "token")
+	if err := os.WriteFile(token, []byte("secret\n"), 0o600); err != nil {
+		t.Fatalf("failed to write token: %v", err)
+	}
+
+	pc := provisioningConfig{
+		Address:   "localhost:9289",
+		TokenFile: token,
+		Fragment:  filepath.Join(dir, "fragment.toml"),
+	}
+
+	p, err := newProvisioner(pc, []byte(base), log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatalf("failed to create provisioner: %v", err)
+	}
+
+	p.adapters = func() []adapter {
+		return newAdapters([]enumeratedDevice{{device: "/dev/ttyUSB0", serial: "A50285BI", driver: "ftdi_sio"}})
+	}
+
+	var applied []string
+	p.apply = func(cfg *config) error {
+		applied = applied[:0]
+		for _, id := range cfg.Identities {
+			applied = append(applied, id.Name)
+		}
+		return nil
+	}
+
+	tests := []struct {
+		name, method, target, token, in string
+		code                            int
+		body                            string
+	}{
+		{
+			name:   "unauthorized",
+			method: http.MethodGet,
+			target: "/v1/devices",
+			token:  "wrong",
+			code:   http.StatusUnauthorized,
+			body:   "unauthorized\n",
+		},
+		{
+			name:   "create identity",
+			method: http.MethodPut,
+			target: "/v1/identities/alice",
+			in:     `{"public_key":"` + testProvisionRSA + `"}`,
+			code:   http.StatusCreated,
+			body:   `{"restart_required":false}` + "\n",
+		},
+		{
+			name:   "create device",
+			method: http.MethodPut,
+			target: "/v1/devices/switch",
+			in:     `{"name":"switch","device":"/dev/ttyUSB1","baud":9600,"identities":["ed25519"]}`,
+			code:   http.StatusCreated,
+			body:   `{"restart_required":true}` + "\n",
+		},
+		{
+			name:   "update device access",
+			method: http.MethodPut,
+			target: "/v1/devices/switch",
+			in:     `{"device":"/dev/ttyUSB1","baud":9600,"identities":["alice"]}`,
+			code:   http.StatusOK,
+			body:   `{"restart_required":false}` + "\n",
+		},
+		{
+			name:   "name mismatch",
+			method: http.MethodPut,
+			target: "/v1/devices/switch",
+			in:     `{"name":"router","device":"/dev/ttyUSB1"}`,
+			code:   http.StatusBadRequest,
+			body:   "device name \"router\" does not match \"switch\"\n",
+		},
+		{
+			name:   "unknown field",
+			method: http.MethodPut,
+			target: "/v1/devices/switch",
+			in:     `{"device":"/dev/ttyUSB1","speed":9600}`,
+			code:   http.StatusBadRequest,
+			body:   "invalid request body: json: unknown field \"speed\"\n",
+		},
+		{
+			name:   "conflicts with base",
+			method: http.MethodPut,
+			target: "/v1/devices/server",
+			in:     `{"device":"/dev/ttyUSB2","baud":115200}`,
+			code:   http.StatusBadRequest,
+			body:   "device \"server\" is configured more than once\n",
+		},
+		{
+			name:   "get device",
+			method: http.MethodGet,
+			target: "/v1/devices/switch",
+			code:   http.StatusOK,
+			body:   `{"name":"switch","device":"/dev/ttyUSB1","baud":9600,"identities":["alice"]}` + "\n",
+		},
+		{
+			name:   "get unknown device",
+			method: http.MethodGet,
+			target: "/v1/devices/router",
+			code:   http.StatusNotFound,
+			body:   "unknown device \"router\"\n",
+		},
+		{
+			name:   "list identities",
+			method: http.MethodGet,
+			target: "/v1/identities",
+			code:   http.StatusOK,
+			body:   `[{"name":"alice","public_key":"` + testProvisionRSA + `"}]` + "\n",
+		},
+		{
+			name:   "list adapters",
+			method: http.MethodGet,
+			target: "/v1/adapters",
+			code:   http.StatusOK,
+			body:   `[{"device":"/dev/ttyUSB0","serial":"A50285BI","metadata":{"driver":"ftdi_sio"}}]` + "\n",
+		},
+		{
+			name:   "delete unknown identity",
+			method: http.MethodDelete,
+			target: "/v1/identities/bob",
+			code:   http.StatusNotFound,
+			body:   "unknown identity \"bob\"\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.in))
+			token := "secret"
+			if tt.token != "" {
+				token = tt.token
+			}
+			r.Header.Set("Authorization", "Bearer "+token)
+
+			w := httptest.NewRecorder()
+			p.ServeHTTP(w, r)
+
+			if diff := cmp.Diff(tt.code, w.Code); diff != "" {
+				t.Fatalf("unexpected status code (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tt.body, w.Body.String()); diff != "" {
+				t.Fatalf("unexpected body (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	if diff := cmp.Diff([]string{"ed25519", "alice"}, applied); diff != "" {
+		t.Fatalf("unexpected applied identities (-want +got):\n%s", diff)
+	}
+
+	// The fragment is appended to the main configuration on startup.
+	b, err := loadFragment(bytes.ReplaceAll([]byte(base), []byte(`"fragment.toml"`), []byte(`"`+filepath.ToSlash(pc.Fragment)+`"`)))
+	if err != nil {
+		t.Fatalf("failed to load fragment: %v", err)
+	}
+
+	cfg, err := parseConfig(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("failed to parse config: %v", err)
+	}
+
+	want := []rawDevice{
+		{
+			Name:       "server",
+			Device:     "/dev/ttyUSB0",
+			Baud:       115200,
+			Identities: []string{"ed25519"},
+		},
+		{
+			Name:       "switch",
+			Device:     "/dev/ttyUSB1",
+			Baud:       9600,
+			Identities: []string{"alice"},
+		},
+	}
+
+	if diff := cmp.Diff(want, cfg.Devices); diff != "" {
+		t.Fatalf("unexpected devices (-want +got):\n%s", diff)
+	}
+}
+
+func Test_provisionerAutoTemplate(t *testing.T) {
+	const base = `
+[[devices]]
+name = "node%d"
+auto = true
+baud = 115200
+denied_identities = ["alice"]
+
+[[identities]]
+name = "ed25519"
+public_key = "` + testProvisionKey + `"
+`
+
+	dir := t.TempDir()
+	token := filepath.Join(dir, "token")
+	if err := os.WriteFile(token, []byte("secret\n"), 0o600); err != nil {
+		t.Fatalf("failed to write token: %v", err)
+	}
+
+	pc := provisioningConfig{
+		Address:   "localhost:9289",
+		TokenFile: token,
+		Fragment:  filepath.Join(dir, "fragment.toml"),
+	}
+
+	p, err := newProvisioner(pc, []byte(base), log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatalf("failed to create provisioner: %v", err)
+	}
+
+	fs := testFS()
+	if err := fs.init(log.New(io.Discard, "", 0)); err != nil {
+		t.Fatalf("failed to init fs: %v", err)
+	}
+	p.expand = func(devices []rawDevice) []rawDevice {
+		return fs.expand(devices, log.New(io.Discard, "", 0))
+	}
+
+	var ids *consrv.Identities
+	p.apply = func(cfg *config) error {
+		var err error
+		ids, err = newIdentities(cfg, nil, log.New(io.Discard, "", 0))
+		return err
+	}
+
+	r := httptest.NewRequest(http.MethodPut, "/v1/identities/alice", strings.NewReader(`{"public_key":"`+testProvisionRSA+`"}`))
+	r.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, r)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("failed to create identity: %d: %s", w.Code, w.Body.String())
+	}
+
+	// alice was denied on the template, and so on every device expanded from
+	// it, even though the identity was provisioned after startup.
+	alice, _, _, _, err := gossh.ParseAuthorizedKey([]byte(testProvisionRSA))
+	if err != nil {
+		t.Fatalf("failed to parse key: %v", err)
+	}
+	other, _, _, _, err := gossh.ParseAuthorizedKey([]byte(testProvisionKey))
+	if err != nil {
+		t.Fatalf("failed to parse key: %v", err)
+	}
+
+	for _, device := range []string{"node1", "node2"} {
+		if _, ok := ids.Authenticate(device, alice); ok {
+			t.Fatalf("expected alice to be denied on %q", device)
+		}
+		if _, ok := ids.Authenticate(device, other); !ok {
+			t.Fatalf("expected ed25519 to be allowed on %q", device)
+		}
+	}
+}
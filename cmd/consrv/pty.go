@@ -0,0 +1,84 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"time"
+
+	"github.com/mdlayher/consrv"
+)
+
+// defaultPTYDir is the directory containing the symlink to each device's
+// pseudo-terminal when no path is configured.
+const defaultPTYDir = "/run/consrv"
+
+// ptyConfig contains the configuration for mirroring a device's console to a
+// local pseudo-terminal, so programs on the host such as minicom or tests can
+// use the device without SSH.
+type ptyConfig struct {
+	Path string `toml:"path"`
+}
+
+// validate verifies the pseudo-terminal configuration for device and applies
+// defaults.
+func (pc *ptyConfig) validate(device string) error {
+	if pc.Path == "" {
+		pc.Path = filepath.Join(defaultPTYDir, device)
+	}
+	if !filepath.IsAbs(pc.Path) {
+		return fmt.Errorf("device %q pty path %q must be absolute", device, pc.Path)
+	}
+
+	return nil
+}
+
+// A ptyMirror proxies between a device's console mux and the controlling side
+// of a pseudo-terminal. The terminal side is held open so that the mirror
+// keeps working as local programs open and close the terminal.
+type ptyMirror struct {
+	name string
+	pty  io.ReadWriter
+	tty  io.Closer // only held open
+	ll   *log.Logger
+}
+
+// run mirrors the device's output to the pseudo-terminal and writes the input
+// of local programs to the device. It never returns.
+func (pm *ptyMirror) run(mux *consrv.MuxDevice) {
+	go func() {
+		for {
+			if _, err := io.Copy(mux, pm.pty); err != nil {
+				errorf(pm.ll, "reading pty input for %q: %v", pm.name, err)
+			}
+
+			time.Sleep(1 * time.Second)
+		}
+	}()
+
+	for {
+		ctx, cancel := context.WithCancel(context.Background())
+		if _, err := io.Copy(pm.pty, mux.Attach(ctx)); err != nil {
+			errorf(pm.ll, "mirroring output to pty for %q: %v", pm.name, err)
+		}
+		cancel()
+
+		pm.ll.Printf("restarting pty mirror for %q", pm.name)
+		time.Sleep(1 * time.Second)
+	}
+}
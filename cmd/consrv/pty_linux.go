@@ -0,0 +1,102 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/unix"
+	"golang.org/x/term"
+)
+
+// openPTY opens a pseudo-terminal in raw mode and points a symlink at path to
+// its terminal device. It returns the controlling side and the terminal side.
+func openPTY(path string) (pty, tty *os.File, err error) {
+	pty, err = os.OpenFile("/dev/ptmx", os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		if err != nil {
+			_ = pty.Close()
+		}
+	}()
+
+	fd := int(pty.Fd())
+	if err := unix.IoctlSetPointerInt(fd, unix.TIOCSPTLCK, 0); err != nil {
+		return nil, nil, fmt.Errorf("failed to unlock pty: %v", err)
+	}
+	n, err := unix.IoctlGetInt(fd, unix.TIOCGPTN)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get pty number: %v", err)
+	}
+
+	name := fmt.Sprintf("/dev/pts/%d", n)
+	tty, err = os.OpenFile(name, os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tty.Close()
+		}
+	}()
+
+	// Pass bytes through unmodified, like a serial port.
+	if _, err := term.MakeRaw(int(tty.Fd())); err != nil {
+		return nil, nil, fmt.Errorf("failed to set raw mode: %v", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, nil, err
+	}
+	if err := removePTYLink(path); err != nil {
+		return nil, nil, err
+	}
+	if err := os.Symlink(name, path); err != nil {
+		return nil, nil, err
+	}
+
+	return pty, tty, nil
+}
+
+// removePTYLink removes a symlink at path which was left behind by openPTY,
+// so that a mistyped path never removes anything else.
+func removePTYLink(path string) error {
+	fi, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if fi.Mode()&os.ModeSymlink == 0 {
+		return fmt.Errorf("pty path %q already exists and is not a symlink", path)
+	}
+	target, err := os.Readlink(path)
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(target, "/dev/pts/") {
+		return fmt.Errorf("pty path %q is a symlink to %q, not a pseudo-terminal", path, target)
+	}
+
+	return os.Remove(path)
+}
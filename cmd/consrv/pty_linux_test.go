@@ -0,0 +1,131 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mdlayher/consrv"
+	"golang.org/x/sys/unix"
+)
+
+func Test_ptyMirror(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "run", "server")
+
+	pty, tty, err := openPTY(path)
+	if err != nil {
+		t.Skipf("skipping, failed to open pty: %v", err)
+	}
+	defer pty.Close()
+	defer tty.Close()
+
+	d, remote := newPipeDevice()
+	mux := consrv.NewMuxDevice(d)
+	defer mux.Close()
+
+	go (&ptyMirror{name: "server", pty: pty, tty: tty, ll: log.New(io.Discard, "", 0)}).run(mux)
+
+	// Local programs open the terminal through the symlink.
+	f, err := os.OpenFile(path, os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		t.Fatalf("failed to open pty: %v", err)
+	}
+	defer f.Close()
+
+	if _, err := remote.Write([]byte("login: \r\n")); err != nil {
+		t.Fatalf("failed to write device output: %v", err)
+	}
+
+	// Raw mode passes output through unmodified.
+	got := make([]byte, len("login: \r\n"))
+	if _, err := io.ReadFull(f, got); err != nil {
+		t.Fatalf("failed to read pty output: %v", err)
+	}
+	if diff := cmp.Diff("login: \r\n", string(got)); diff != "" {
+		t.Fatalf("unexpected pty output (-want +got):\n%s", diff)
+	}
+
+	if _, err := f.Write([]byte("root\r")); err != nil {
+		t.Fatalf("failed to write pty input: %v", err)
+	}
+
+	got = make([]byte, len("root\r"))
+	if _, err := io.ReadFull(remote, got); err != nil {
+		t.Fatalf("failed to read device input: %v", err)
+	}
+	if diff := cmp.Diff("root\r", string(got)); diff != "" {
+		t.Fatalf("unexpected device input (-want +got):\n%s", diff)
+	}
+}
+
+func Test_removePTYLink(t *testing.T) {
+	tests := []struct {
+		name   string
+		create func(path string) error
+		ok     bool
+	}{
+		{
+			name:   "OK not exist",
+			create: func(string) error { return nil },
+			ok:     true,
+		},
+		{
+			name:   "OK pty symlink",
+			create: func(path string) error { return os.Symlink("/dev/pts/9", path) },
+			ok:     true,
+		},
+		{
+			name:   "file",
+			create: func(path string) error { return os.WriteFile(path, []byte("config"), 0o644) },
+		},
+		{
+			name:   "directory",
+			create: func(path string) error { return os.Mkdir(path, 0o755) },
+		},
+		{
+			name:   "other symlink",
+			create: func(path string) error { return os.Symlink("/etc/passwd", path) },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "server")
+			if err := tt.create(path); err != nil {
+				t.Fatalf("failed to create path: %v", err)
+			}
+
+			err := removePTYLink(path)
+			if tt.ok && err != nil {
+				t.Fatalf("failed to remove pty symlink: %v", err)
+			}
+			if !tt.ok && err == nil {
+				t.Fatal("expected an error, but none occurred")
+			}
+
+			// Only a pty symlink is removed.
+			_, err = os.Lstat(path)
+			if diff := cmp.Diff(tt.ok, os.IsNotExist(err)); diff != "" {
+				t.Fatalf("unexpected removal (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
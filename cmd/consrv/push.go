@@ -0,0 +1,242 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// pushConfig contains the configuration for pushing metrics to a statsd or
+// InfluxDB line protocol collector such as Telegraf.
+type pushConfig struct {
+	Format   string   `toml:"format"`
+	Address  string   `toml:"address"`
+	Interval duration `toml:"interval"`
+}
+
+// The supported metrics push formats.
+const (
+	pushStatsd   = "statsd"
+	pushInfluxDB = "influxdb"
+)
+
+// defaultPushInterval is the default interval between metrics pushes.
+const defaultPushInterval = 10 * time.Second
+
+// maxPushPacket is the maximum size of a single metrics datagram, chosen to
+// avoid IP fragmentation on typical networks.
+const maxPushPacket = 1432
+
+// validate verifies the metrics push configuration and applies defaults.
+func (pc *pushConfig) validate() error {
+	switch pc.Format {
+	case pushStatsd, pushInfluxDB:
+	default:
+		return fmt.Errorf("unknown debug push format %q", pc.Format)
+	}
+
+	if _, _, err := net.SplitHostPort(pc.Address); err != nil {
+		return fmt.Errorf("debug push must have a valid address: %v", err)
+	}
+
+	switch {
+	case pc.Interval.Duration < 0:
+		return errors.New("debug push interval must not be negative")
+	case pc.Interval.Duration == 0:
+		pc.Interval.Duration = defaultPushInterval
+	}
+
+	return nil
+}
+
+// A pusher periodically pushes the consrv counters and gauges to a statsd or
+// InfluxDB line protocol collector over UDP.
+type pusher struct {
+	g        prometheus.Gatherer
+	format   string
+	interval time.Duration
+	w        io.Writer
+	now      func() time.Time
+	ll       *log.Logger
+
+	// last tracks the previously pushed counter values, since statsd
+	// counters are deltas rather than totals.
+	last map[string]float64
+}
+
+// newPusher creates a pusher for g which writes datagrams to w.
+func newPusher(pc pushConfig, g prometheus.Gatherer, w io.Writer, ll *log.Logger) *pusher {
+	return &pusher{
+		g:        g,
+		format:   pc.Format,
+		interval: pc.Interval.Duration,
+		w:        w,
+		now:      time.Now,
+		ll:       ll,
+		last:     make(map[string]float64),
+	}
+}
+
+// run pushes metrics at each interval for the lifetime of the process.
+func (p *pusher) run() {
+	t := time.NewTicker(p.interval)
+	defer t.Stop()
+
+	for range t.C {
+		if err := p.push(); err != nil {
+			errorf(p.ll, "failed to push metrics: %v", err)
+		}
+	}
+}
+
+// push gathers and writes the current metrics.
+func (p *pusher) push() error {
+	mfs, err := p.g.Gather()
+	if err != nil {
+		return err
+	}
+
+	now := p.now()
+
+	var lines []string
+	for _, mf := range mfs {
+		// As with /vars, only push the metrics produced by consrv itself.
+		if !strings.HasPrefix(mf.GetName(), "consrv_") {
+			continue
+		}
+
+		typ := mf.GetType()
+		if typ != dto.MetricType_COUNTER && typ != dto.MetricType_GAUGE {
+			continue
+		}
+
+		for _, m := range mf.GetMetric() {
+			v := m.GetGauge().GetValue()
+			if typ == dto.MetricType_COUNTER {
+				v = m.GetCounter().GetValue()
+			}
+
+			switch p.format {
+			case pushStatsd:
+				lines = append(lines, p.statsd(mf.GetName(), typ, m.GetLabel(), v)...)
+			case pushInfluxDB:
+				lines = append(lines, influxLine(mf.GetName(), m.GetLabel(), v, now))
+			}
+		}
+	}
+
+	// Pack as many lines as possible into each datagram.
+	var b bytes.Buffer
+	for _, l := range lines {
+		if b.Len() > 0 && b.Len()+len(l)+1 > maxPushPacket {
+			if _, err := p.w.Write(b.Bytes()); err != nil {
+				return err
+			}
+			b.Reset()
+		}
+
+		b.WriteString(l)
+		b.WriteByte('\n')
+	}
+
+	if b.Len() == 0 {
+		return nil
+	}
+
+	_, err = p.w.Write(b.Bytes())
+	return err
+}
+
+// statsd formats a metric in the statsd protocol with Telegraf-style tags.
+// Counters are sent as the delta since the previous push and are omitted when
+// unchanged.
+func (p *pusher) statsd(name string, typ dto.MetricType, labels []*dto.LabelPair, v float64) []string {
+	var sb strings.Builder
+	sb.WriteString(statsdEscape(name))
+	for _, lp := range labels {
+		fmt.Fprintf(&sb, ",%s=%s", statsdEscape(lp.GetName()), statsdEscape(lp.GetValue()))
+	}
+	key := sb.String()
+
+	if typ == dto.MetricType_GAUGE {
+		if v < 0 {
+			// A signed gauge value is interpreted as a relative change, so
+			// reset the gauge to zero first.
+			return []string{key + ":0|g", key + ":" + formatFloat(v) + "|g"}
+		}
+
+		return []string{key + ":" + formatFloat(v) + "|g"}
+	}
+
+	delta := v - p.last[key]
+	if delta < 0 {
+		// The counter was reset.
+		delta = v
+	}
+	p.last[key] = v
+
+	if delta == 0 {
+		return nil
+	}
+
+	return []string{key + ":" + formatFloat(delta) + "|c"}
+}
+
+// influxLine formats a metric in the InfluxDB line protocol.
+func influxLine(name string, labels []*dto.LabelPair, v float64, now time.Time) string {
+	// Gathered labels are already sorted by name, as InfluxDB recommends.
+	var sb strings.Builder
+	sb.WriteString(influxEscape(name))
+	for _, lp := range labels {
+		fmt.Fprintf(&sb, ",%s=%s", influxEscape(lp.GetName()), influxEscape(lp.GetValue()))
+	}
+	fmt.Fprintf(&sb, " value=%s %d", formatFloat(v), now.UnixNano())
+
+	return sb.String()
+}
+
+// statsdEscape replaces characters which cannot be escaped in the statsd
+// protocol.
+var statsdEscape = strings.NewReplacer(
+	":", "_",
+	"|", "_",
+	",", "_",
+	"=", "_",
+	" ", "_",
+	"\n", "_",
+).Replace
+
+// influxEscape escapes special characters in InfluxDB measurements and tags.
+var influxEscape = strings.NewReplacer(
+	`,`, `\,`,
+	`=`, `\=`,
+	` `, `\ `,
+	"\n", `\n`,
+).Replace
+
+// formatFloat formats v with the minimum precision required.
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
@@ -0,0 +1,147 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mdlayher/metricslite"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+func Test_pusher(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		want   [][]string
+	}{
+		{
+			name:   "statsd",
+			format: pushStatsd,
+			want: [][]string{
+				{
+					"consrv_test_open:1|g",
+					"consrv_test_reads_total,device=bar:1|c",
+					"consrv_test_reads_total,device=foo_1:2|c",
+				},
+				// Unchanged counters are omitted and changed counters are
+				// sent as deltas.
+				{
+					"consrv_test_open:1|g",
+					"consrv_test_reads_total,device=foo_1:3|c",
+				},
+			},
+		},
+		{
+			name:   "InfluxDB",
+			format: pushInfluxDB,
+			want: [][]string{
+				{
+					"consrv_test_open value=1 1000000000",
+					"consrv_test_reads_total,device=bar value=1 1000000000",
+					`consrv_test_reads_total,device=foo\ 1 value=2 1000000000`,
+				},
+				{
+					"consrv_test_open value=1 1000000000",
+					"consrv_test_reads_total,device=bar value=1 1000000000",
+					`consrv_test_reads_total,device=foo\ 1 value=5 1000000000`,
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := prometheus.NewPedanticRegistry()
+			reg.MustRegister(collectors.NewGoCollector())
+
+			mm := metricslite.NewPrometheus(reg)
+			reads := mm.Counter("consrv_test_reads_total", "Test reads.", "device")
+			open := mm.Gauge("consrv_test_open", "Test open.")
+
+			reads(2, "foo 1")
+			reads(1, "bar")
+			open(1)
+
+			var w packetWriter
+			p := newPusher(pushConfig{Format: tt.format}, reg, &w, log.New(io.Discard, "", 0))
+			p.now = func() time.Time { return time.Unix(1, 0) }
+
+			if err := p.push(); err != nil {
+				t.Fatalf("failed to push: %v", err)
+			}
+
+			reads(3, "foo 1")
+			if err := p.push(); err != nil {
+				t.Fatalf("failed to push: %v", err)
+			}
+
+			if diff := cmp.Diff(tt.want, w.lines()); diff != "" {
+				t.Fatalf("unexpected pushes (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_pusherPackets(t *testing.T) {
+	reg := prometheus.NewPedanticRegistry()
+	reads := metricslite.NewPrometheus(reg).Counter("consrv_test_reads_total", "Test reads.", "device")
+	for i := 0; i < 100; i++ {
+		reads(1, strings.Repeat("x", i))
+	}
+
+	var w packetWriter
+	if err := newPusher(pushConfig{Format: pushInfluxDB}, reg, &w, log.New(io.Discard, "", 0)).push(); err != nil {
+		t.Fatalf("failed to push: %v", err)
+	}
+
+	var n int
+	for _, p := range w {
+		if len(p) > maxPushPacket {
+			t.Fatalf("packet length %d exceeds maximum", len(p))
+		}
+		n += strings.Count(string(p), "\n")
+	}
+
+	if len(w) < 2 {
+		t.Fatalf("expected metrics to be split across packets, but got %d", len(w))
+	}
+	if diff := cmp.Diff(100, n); diff != "" {
+		t.Fatalf("unexpected number of lines (-want +got):\n%s", diff)
+	}
+}
+
+// A packetWriter is an io.Writer which records each write as a packet.
+type packetWriter [][]byte
+
+func (pw *packetWriter) Write(b []byte) (int, error) {
+	*pw = append(*pw, append([]byte(nil), b...))
+	return len(b), nil
+}
+
+// lines returns the lines of each push, assuming each fits in one packet.
+func (pw packetWriter) lines() [][]string {
+	var out [][]string
+	for _, p := range pw {
+		out = append(out, strings.Split(strings.TrimSuffix(string(p), "\n"), "\n"))
+	}
+
+	return out
+}
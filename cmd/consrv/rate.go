@@ -0,0 +1,162 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/mdlayher/consrv"
+	"github.com/mdlayher/metricslite"
+)
+
+// rateAlarmConfig contains the configuration for a device's output line-rate
+// alarm.
+type rateAlarmConfig struct {
+	LinesPerMinute int `toml:"lines_per_minute"`
+}
+
+// validate verifies the rate alarm configuration for device.
+func (rc *rateAlarmConfig) validate(device string) error {
+	if rc.LinesPerMinute <= 0 {
+		return fmt.Errorf("device %q rate alarm lines per minute must be greater than 0", device)
+	}
+
+	return nil
+}
+
+const (
+	// The output rate is measured over a sliding minute made up of buckets.
+	rateBucket  = 10 * time.Second
+	rateBuckets = int(time.Minute / rateBucket)
+)
+
+// A rateMonitor measures the number of lines of output a device produces per
+// minute, and optionally raises an alarm when the rate exceeds a threshold,
+// because a console which suddenly floods with output often indicates a crash
+// loop.
+type rateMonitor struct {
+	name      string
+	threshold int
+	notify    func(format string, v ...any)
+	ll        *log.Logger
+
+	lines  metricslite.Counter
+	rate   metricslite.Gauge
+	alarms metricslite.Gauge
+
+	mu      sync.Mutex
+	pending int
+	buckets [rateBuckets]int
+	next    int
+	firing  bool
+}
+
+// newRateMonitor creates a rateMonitor for device d which raises alarms using
+// notify, if an alarm is configured.
+func newRateMonitor(d rawDevice, mm *metrics, notify func(format string, v ...any), ll *log.Logger) *rateMonitor {
+	rm := &rateMonitor{
+		name:   d.Name,
+		notify: notify,
+		ll:     ll,
+		lines:  mm.deviceOutputLines,
+		rate:   mm.deviceOutputLinesPerMinute,
+		alarms: mm.deviceOutputRateAlarm,
+	}
+	if d.RateAlarm != nil {
+		rm.threshold = d.RateAlarm.LinesPerMinute
+	}
+
+	rm.rate(0, rm.name)
+	rm.alarms(0, rm.name)
+	return rm
+}
+
+// run counts output from the mux until the process exits, restarting the count
+// if it stops.
+func (rm *rateMonitor) run(mux *consrv.MuxDevice) {
+	go func() {
+		for range time.Tick(rateBucket) {
+			rm.tick()
+		}
+	}()
+
+	for {
+		ctx, cancel := context.WithCancel(context.Background())
+		if err := rm.count(mux.Attach(ctx)); err != nil {
+			errorf(rm.ll, "rate monitor for %q: %v", rm.name, err)
+		}
+		cancel()
+
+		rm.ll.Printf("restarting rate monitor for %q", rm.name)
+		time.Sleep(1 * time.Second)
+	}
+}
+
+// count counts lines of output from r until it returns an error.
+func (rm *rateMonitor) count(r io.Reader) error {
+	b := make([]byte, 4096)
+	for {
+		n, err := r.Read(b)
+		if lines := bytes.Count(b[:n], []byte("\n")); lines > 0 {
+			rm.lines(float64(lines), rm.name)
+
+			rm.mu.Lock()
+			rm.pending += lines
+			rm.mu.Unlock()
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// tick ends the current bucket, updates the rate over the last minute, and
+// raises or clears the alarm if the rate crossed its threshold.
+func (rm *rateMonitor) tick() {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	rm.buckets[rm.next] = rm.pending
+	rm.next = (rm.next + 1) % len(rm.buckets)
+	rm.pending = 0
+
+	var rate int
+	for _, n := range rm.buckets {
+		rate += n
+	}
+	rm.rate(float64(rate), rm.name)
+
+	if rm.threshold == 0 {
+		return
+	}
+
+	switch {
+	case !rm.firing && rate > rm.threshold:
+		rm.firing = true
+		rm.alarms(1, rm.name)
+		rm.ll.Printf("%s: output rate alarm: %d lines per minute exceeds %d", rm.name, rate, rm.threshold)
+		rm.notify("output of %q is %d lines per minute, above the alarm threshold of %d", rm.name, rate, rm.threshold)
+	case rm.firing && rate <= rm.threshold:
+		rm.firing = false
+		rm.alarms(0, rm.name)
+		rm.ll.Printf("%s: output rate alarm cleared: %d lines per minute", rm.name, rate)
+		rm.notify("output of %q is back to %d lines per minute", rm.name, rate)
+	}
+}
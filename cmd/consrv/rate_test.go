@@ -0,0 +1,88 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_rateMonitor(t *testing.T) {
+	var (
+		notices []string
+		rates   []float64
+		alarms  []float64
+	)
+
+	rm := newRateMonitor(
+		rawDevice{Name: "server", RateAlarm: &rateAlarmConfig{LinesPerMinute: 10}},
+		newMetrics(nil),
+		func(format string, v ...any) { notices = append(notices, fmt.Sprintf(format, v...)) },
+		log.New(io.Discard, "", 0),
+	)
+	rm.rate = func(v float64, _ ...string) { rates = append(rates, v) }
+	rm.alarms = func(v float64, _ ...string) { alarms = append(alarms, v) }
+
+	// Each bucket receives some lines, and the rate is the sum of the last
+	// minute of buckets.
+	for _, lines := range []int{4, 4, 4, 0, 0, 0, 0, 0, 0} {
+		r := strings.NewReader(strings.Repeat("panic\r\n", lines) + "partial")
+		if err := rm.count(r); err != io.EOF {
+			t.Fatalf("failed to count: %v", err)
+		}
+
+		rm.tick()
+	}
+
+	if diff := cmp.Diff([]float64{4, 8, 12, 12, 12, 12, 8, 4, 0}, rates); diff != "" {
+		t.Fatalf("unexpected rates (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]float64{1, 0}, alarms); diff != "" {
+		t.Fatalf("unexpected alarms (-want +got):\n%s", diff)
+	}
+
+	want := []string{
+		`output of "server" is 12 lines per minute, above the alarm threshold of 10`,
+		`output of "server" is back to 8 lines per minute`,
+	}
+	if diff := cmp.Diff(want, notices); diff != "" {
+		t.Fatalf("unexpected notices (-want +got):\n%s", diff)
+	}
+}
+
+func Test_rateMonitorNoAlarm(t *testing.T) {
+	rm := newRateMonitor(
+		rawDevice{Name: "server"},
+		newMetrics(nil),
+		func(string, ...any) { panic("unexpected notice") },
+		log.New(io.Discard, "", 0),
+	)
+
+	var rate float64
+	rm.rate = func(v float64, _ ...string) { rate = v }
+
+	if err := rm.count(strings.NewReader(strings.Repeat("\n", 1000))); err != io.EOF {
+		t.Fatalf("failed to count: %v", err)
+	}
+	rm.tick()
+
+	if diff := cmp.Diff(1000.0, rate); diff != "" {
+		t.Fatalf("unexpected rate (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+)
+
+// A redactRule is a raw redaction rule configuration.
+type redactRule struct {
+	Pattern     string `toml:"pattern"`
+	Replacement string `toml:"replacement"`
+}
+
+// defaultReplacement replaces redacted output if a rule has no replacement.
+const defaultReplacement = "[redacted]"
+
+// validateRedact verifies the redaction rules for device.
+func validateRedact(device string, rules []redactRule) error {
+	for _, r := range rules {
+		if r.Pattern == "" {
+			return fmt.Errorf("device %q redaction rule must have a pattern", device)
+		}
+		if _, err := regexp.Compile(r.Pattern); err != nil {
+			return fmt.Errorf("device %q redaction rule has invalid pattern: %v", device, err)
+		}
+	}
+
+	return nil
+}
+
+// A redactor replaces sensitive output before it is logged or recorded.
+type redactor []redaction
+
+// A redaction is a compiled redactRule.
+type redaction struct {
+	re   *regexp.Regexp
+	repl []byte
+}
+
+// newRedactor creates a redactor from rules. It returns nil if there are no
+// rules.
+func newRedactor(rules []redactRule) redactor {
+	if len(rules) == 0 {
+		return nil
+	}
+
+	rd := make(redactor, 0, len(rules))
+	for _, r := range rules {
+		repl := r.Replacement
+		if repl == "" {
+			repl = defaultReplacement
+		}
+
+		rd = append(rd, redaction{
+			// Validated when parsing the configuration.
+			re:   regexp.MustCompile(r.Pattern),
+			repl: []byte(repl),
+		})
+	}
+
+	return rd
+}
+
+// redact applies each rule to b in order. Replacements may refer to submatches
+// as in regexp.Regexp.Expand.
+func (rd redactor) redact(b []byte) []byte {
+	for _, r := range rd {
+		b = r.re.ReplaceAll(b, r.repl)
+	}
+
+	return b
+}
+
+// A lineRedactor applies a redactor to complete lines, so that sensitive
+// output which is split across multiple device reads is still redacted. A
+// partial line is held back until it is completed, or until it is longer than
+// maxChunk.
+type lineRedactor struct {
+	rd  redactor
+	buf []byte
+}
+
+// redact appends b to any held back partial line, and returns the redacted
+// complete lines. If there are no redaction rules, b is returned immediately.
+func (lr *lineRedactor) redact(b []byte) []byte {
+	if lr.rd == nil {
+		return b
+	}
+
+	lr.buf = append(lr.buf, b...)
+
+	n := bytes.LastIndexByte(lr.buf, '\n') + 1
+	if n == 0 {
+		if len(lr.buf) < maxChunk {
+			return nil
+		}
+
+		// Don't buffer a very long line indefinitely.
+		n = len(lr.buf)
+	}
+
+	out := lr.rd.redact(append([]byte(nil), lr.buf[:n]...))
+	lr.buf = append(lr.buf[:0], lr.buf[n:]...)
+	return out
+}
+
+// flush returns any held back partial line after redacting it.
+func (lr *lineRedactor) flush() []byte {
+	if len(lr.buf) == 0 {
+		return nil
+	}
+
+	out := lr.rd.redact(lr.buf)
+	lr.buf = nil
+	return out
+}
+
+// A redactWriter is an io.Writer which redacts complete lines written to w.
+// Flush must be called to write a final partial line.
+type redactWriter struct {
+	lr lineRedactor
+	w  io.Writer
+}
+
+// newRedactWriter creates a redactWriter which applies rd to output written to
+// w.
+func newRedactWriter(rd redactor, w io.Writer) *redactWriter {
+	return &redactWriter{lr: lineRedactor{rd: rd}, w: w}
+}
+
+// Write implements io.Writer.
+func (rw *redactWriter) Write(b []byte) (int, error) {
+	if out := rw.lr.redact(b); len(out) > 0 {
+		if _, err := rw.w.Write(out); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(b), nil
+}
+
+// Flush writes any held back partial line to w.
+func (rw *redactWriter) Flush() error {
+	if out := rw.lr.flush(); len(out) > 0 {
+		if _, err := rw.w.Write(out); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,85 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_redactWriter(t *testing.T) {
+	rd := newRedactor([]redactRule{{Pattern: `hunter2`, Replacement: "*"}})
+
+	tests := []struct {
+		name   string
+		rd     redactor
+		writes []string
+		want   string
+	}{
+		{
+			name:   "no rules",
+			writes: []string{"Password: hun", "ter2"},
+			want:   "Password: hunter2",
+		},
+		{
+			name:   "one write",
+			rd:     rd,
+			writes: []string{"Password: hunter2\r\n"},
+			want:   "Password: *\r\n",
+		},
+		{
+			name:   "split across writes",
+			rd:     rd,
+			writes: []string{"Password: hun", "te", "r2\r\nok\r\n"},
+			want:   "Password: *\r\nok\r\n",
+		},
+		{
+			name:   "partial line flushed",
+			rd:     rd,
+			writes: []string{"ok\nhunt", "er2"},
+			want:   "ok\n*",
+		},
+		{
+			name:   "long line",
+			rd:     rd,
+			writes: []string{strings.Repeat("a", maxChunk), "hunter2\n"},
+			want:   strings.Repeat("a", maxChunk) + "*\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+			w := newRedactWriter(tt.rd, &out)
+			for _, s := range tt.writes {
+				n, err := w.Write([]byte(s))
+				if err != nil {
+					t.Fatalf("failed to write: %v", err)
+				}
+				if diff := cmp.Diff(len(s), n); diff != "" {
+					t.Fatalf("unexpected write length (-want +got):\n%s", diff)
+				}
+			}
+			if err := w.Flush(); err != nil {
+				t.Fatalf("failed to flush: %v", err)
+			}
+
+			if diff := cmp.Diff(tt.want, out.String()); diff != "" {
+				t.Fatalf("unexpected output (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,344 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mdlayher/consrv"
+	"github.com/mdlayher/metricslite"
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// remoteConfig contains the configuration for another consrv instance whose
+// devices are proxied by this one.
+type remoteConfig struct {
+	Name       string   `toml:"name"`
+	Address    string   `toml:"address"`
+	SRV        string   `toml:"srv"`
+	KeyFile    string   `toml:"key_file"`
+	KnownHosts string   `toml:"known_hosts"`
+	Devices    []string `toml:"devices"`
+	Identities []string `toml:"identities"`
+}
+
+// validate verifies the remote configuration.
+func (rc *remoteConfig) validate(validIDs map[string]struct{}) error {
+	if rc.Name == "" {
+		return errors.New("remote must have a name")
+	}
+	if (rc.Address == "") == (rc.SRV == "") {
+		return fmt.Errorf("remote %q must have exactly one of an address or SRV record", rc.Name)
+	}
+	if rc.Address != "" {
+		if _, _, err := net.SplitHostPort(rc.Address); err != nil {
+			return fmt.Errorf("remote %q must have a valid address: %v", rc.Name, err)
+		}
+	}
+	if rc.KeyFile == "" || rc.KnownHosts == "" {
+		return fmt.Errorf("remote %q must have a key file and known hosts file", rc.Name)
+	}
+
+	for _, id := range rc.Identities {
+		if _, ok := validIDs[id]; !ok {
+			return fmt.Errorf("remote %q is configured with unknown identity %q", rc.Name, id)
+		}
+	}
+
+	return nil
+}
+
+// remoteDialTimeout bounds the time spent connecting to a remote.
+const remoteDialTimeout = 10 * time.Second
+
+// A remoteDevice is a device found on a remote.
+type remoteDevice struct {
+	Name, Address string
+}
+
+// discoverRemote finds the devices of remote rc, using its configured device
+// names or otherwise listing the devices available to consrv on each remote
+// address.
+func discoverRemote(rc remoteConfig, cfg *gossh.ClientConfig) ([]remoteDevice, error) {
+	addrs := []string{rc.Address}
+	if rc.SRV != "" {
+		_, srvs, err := net.LookupSRV("", "", rc.SRV)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up SRV record: %v", err)
+		}
+
+		addrs = addrs[:0]
+		for _, srv := range srvs {
+			addrs = append(addrs, net.JoinHostPort(srv.Target, strconv.Itoa(int(srv.Port))))
+		}
+	}
+
+	var rds []remoteDevice
+	for _, addr := range addrs {
+		names := rc.Devices
+		if len(names) == 0 {
+			var err error
+			names, err = listRemote(addr, cfg)
+			if err != nil {
+				return nil, fmt.Errorf("failed to list devices on %q: %v", addr, err)
+			}
+		}
+
+		for _, n := range names {
+			rds = append(rds, remoteDevice{Name: n, Address: addr})
+		}
+	}
+
+	return rds, nil
+}
+
+// listRemote lists the devices available on the consrv instance at addr.
+func listRemote(addr string, cfg *gossh.ClientConfig) ([]string, error) {
+	c, err := gossh.Dial("tcp", addr, cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	s, err := c.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+
+	out, err := s.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.RequestSubsystem(consrv.ListSubsystem); err != nil {
+		return nil, err
+	}
+
+	var names []string
+	scanner := bufio.NewScanner(out)
+	for scanner.Scan() {
+		names = append(names, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return names, nil
+}
+
+// newRemoteClientConfig creates an SSH client configuration for remote rc.
+func newRemoteClientConfig(rc remoteConfig) (*gossh.ClientConfig, error) {
+	b, err := os.ReadFile(rc.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %v", err)
+	}
+
+	signer, err := gossh.ParsePrivateKey(b)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse key file: %v", err)
+	}
+
+	hostKeys, err := knownhosts.New(rc.KnownHosts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read known hosts: %v", err)
+	}
+
+	return &gossh.ClientConfig{
+		// The user selects the device, and is overridden for each device.
+		User:            "consrv",
+		Auth:            []gossh.AuthMethod{gossh.PublicKeys(signer)},
+		HostKeyCallback: hostKeys,
+		Timeout:         remoteDialTimeout,
+	}, nil
+}
+
+var _ consrv.Device = &sshDevice{}
+
+// An sshDevice is a consrv.Device which proxies a device on a remote consrv
+// instance over a single SSH session. The session is reestablished whenever it
+// breaks, so reads block rather than failing while the remote is unavailable.
+type sshDevice struct {
+	name, remote, addr string
+	cfg                *gossh.ClientConfig
+	reads, writes      metricslite.Counter
+	ll                 *log.Logger
+
+	mu     sync.Mutex
+	closed bool
+	c      *gossh.Client
+	stdin  io.Writer
+	stdout io.Reader
+}
+
+// newSSHDevice creates an sshDevice for device rd on remote rc.
+func newSSHDevice(rc remoteConfig, rd remoteDevice, cfg *gossh.ClientConfig, reads, writes metricslite.Counter, ll *log.Logger) *sshDevice {
+	// Log in as the device.
+	dcfg := *cfg
+	dcfg.User = rd.Name
+
+	return &sshDevice{
+		name:   rd.Name,
+		remote: rc.Name,
+		addr:   rd.Address,
+		cfg:    &dcfg,
+		reads:  reads,
+		writes: writes,
+		ll:     ll,
+	}
+}
+
+// errClosed indicates that an sshDevice was closed.
+var errClosed = errors.New("device closed")
+
+// Close implements io.ReadWriteCloser.
+func (d *sshDevice) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.closed = true
+	if d.c != nil {
+		return d.c.Close()
+	}
+
+	return nil
+}
+
+// Read implements io.ReadWriteCloser.
+func (d *sshDevice) Read(b []byte) (int, error) {
+	for {
+		r, err := d.connect()
+		if errors.Is(err, errClosed) {
+			return 0, io.EOF
+		}
+		if err != nil {
+			errorf(d.ll, "%s: failed to connect to remote %q at %q: %v", d.name, d.remote, d.addr, err)
+			time.Sleep(1 * time.Second)
+			continue
+		}
+
+		n, err := r.Read(b)
+		d.reads(float64(n), d.name)
+		if err == nil {
+			return n, nil
+		}
+
+		d.disconnect(err)
+		if n > 0 {
+			return n, nil
+		}
+
+		// Don't reconnect in a tight loop if the remote closes the session
+		// immediately.
+		time.Sleep(1 * time.Second)
+	}
+}
+
+// Write implements io.ReadWriteCloser.
+func (d *sshDevice) Write(b []byte) (int, error) {
+	d.mu.Lock()
+	w := d.stdin
+	d.mu.Unlock()
+
+	if w == nil {
+		return 0, fmt.Errorf("remote %q is not connected", d.remote)
+	}
+
+	n, err := w.Write(b)
+	d.writes(float64(n), d.name)
+	return n, err
+}
+
+// connected implements connector.
+func (d *sshDevice) connected() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.c != nil
+}
+
+// String returns the string representation of an sshDevice.
+func (d *sshDevice) String() string {
+	return fmt.Sprintf("%q: remote: %q, address: %q", d.name, d.remote, d.addr)
+}
+
+// connect returns the output of the current session, opening a new session
+// if necessary.
+func (d *sshDevice) connect() (io.Reader, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.closed {
+		return nil, errClosed
+	}
+	if d.stdout != nil {
+		return d.stdout, nil
+	}
+
+	c, err := gossh.Dial("tcp", d.addr, d.cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := c.NewSession()
+	if err != nil {
+		_ = c.Close()
+		return nil, err
+	}
+
+	stdin, err := s.StdinPipe()
+	if err != nil {
+		_ = c.Close()
+		return nil, err
+	}
+	stdout, err := s.StdoutPipe()
+	if err != nil {
+		_ = c.Close()
+		return nil, err
+	}
+	if err := s.Shell(); err != nil {
+		_ = c.Close()
+		return nil, err
+	}
+
+	d.ll.Printf("%s: connected to remote %q at %q", d.name, d.remote, d.addr)
+
+	d.c, d.stdin, d.stdout = c, stdin, stdout
+	return stdout, nil
+}
+
+// disconnect closes the current session after it fails with err.
+func (d *sshDevice) disconnect(err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.c == nil {
+		return
+	}
+	if !d.closed {
+		errorf(d.ll, "%s: disconnected from remote %q at %q: %v", d.name, d.remote, d.addr, err)
+	}
+
+	_ = d.c.Close()
+	d.c, d.stdin, d.stdout = nil, nil, nil
+}
@@ -0,0 +1,247 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/pem"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mdlayher/consrv"
+	"github.com/mdlayher/metricslite"
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func TestRemote(t *testing.T) {
+	d := &scriptDevice{
+		replies: map[string]string{"ping": "pong"},
+		readC:   make(chan []byte, 8),
+	}
+	addr, rc := testRemote(t, map[string]*consrv.MuxDevice{
+		"server": consrv.NewMuxDevice(d),
+		"router": consrv.NewMuxDevice(&scriptDevice{readC: make(chan []byte)}),
+	})
+
+	ccfg, err := newRemoteClientConfig(rc)
+	if err != nil {
+		t.Fatalf("failed to create client config: %v", err)
+	}
+
+	rds, err := discoverRemote(rc, ccfg)
+	if err != nil {
+		t.Fatalf("failed to discover devices: %v", err)
+	}
+
+	want := []remoteDevice{
+		{Name: "router", Address: addr},
+		{Name: "server", Address: addr},
+	}
+	if diff := cmp.Diff(want, rds); diff != "" {
+		t.Fatalf("unexpected remote devices (-want +got):\n%s", diff)
+	}
+
+	dev := newSSHDevice(rc, rds[1], ccfg, metricslite.Discard().Counter("reads", ""),
+		metricslite.Discard().Counter("writes", ""), log.New(io.Discard, "", 0))
+	defer dev.Close()
+
+	// Reading connects to the remote, which has no PTY, so its output is only
+	// that of the device.
+	errC := make(chan error, 1)
+	go func() {
+		var out bytes.Buffer
+		b := make([]byte, 128)
+		for !strings.Contains(out.String(), "pong") {
+			n, err := dev.Read(b)
+			if err != nil {
+				errC <- err
+				return
+			}
+			out.Write(b[:n])
+		}
+		errC <- nil
+	}()
+
+	// Input is refused until the remote session is established.
+	for {
+		_, err := io.WriteString(dev, "ping")
+		if err == nil {
+			break
+		}
+		if !strings.Contains(err.Error(), "not connected") {
+			t.Fatalf("failed to write: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := <-errC; err != nil {
+		t.Fatalf("failed to read: %v", err)
+	}
+
+	if err := dev.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+	if _, err := dev.Read(make([]byte, 8)); err != io.EOF {
+		t.Fatalf("expected EOF after close, but got: %v", err)
+	}
+}
+
+func Test_remoteConfigValidate(t *testing.T) {
+	validIDs := map[string]struct{}{"mdlayher": {}}
+
+	tests := []struct {
+		name string
+		rc   remoteConfig
+		ok   bool
+	}{
+		{
+			name: "no name",
+			rc:   remoteConfig{Address: "lab:2222", KeyFile: "key", KnownHosts: "known_hosts"},
+		},
+		{
+			name: "address and SRV",
+			rc: remoteConfig{
+				Name:       "lab",
+				Address:    "lab:2222",
+				SRV:        "_consrv._tcp.example.com",
+				KeyFile:    "key",
+				KnownHosts: "known_hosts",
+			},
+		},
+		{
+			name: "bad address",
+			rc:   remoteConfig{Name: "lab", Address: "lab", KeyFile: "key", KnownHosts: "known_hosts"},
+		},
+		{
+			name: "no key",
+			rc:   remoteConfig{Name: "lab", Address: "lab:2222", KnownHosts: "known_hosts"},
+		},
+		{
+			name: "unknown identity",
+			rc: remoteConfig{
+				Name:       "lab",
+				Address:    "lab:2222",
+				KeyFile:    "key",
+				KnownHosts: "known_hosts",
+				Identities: []string{"foo"},
+			},
+		},
+		{
+			name: "OK",
+			rc: remoteConfig{
+				Name:       "lab",
+				SRV:        "_consrv._tcp.example.com",
+				KeyFile:    "key",
+				KnownHosts: "known_hosts",
+				Identities: []string{"mdlayher"},
+			},
+			ok: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.rc.validate(validIDs)
+			if tt.ok && err != nil {
+				t.Fatalf("failed to validate: %v", err)
+			}
+			if !tt.ok && err == nil {
+				t.Fatal("expected an error, but none occurred")
+			}
+		})
+	}
+}
+
+// testRemote starts a consrv server for devices and returns its address and a
+// remoteConfig which may be used to connect to it.
+func testRemote(t *testing.T, devices map[string]*consrv.MuxDevice) (string, remoteConfig) {
+	t.Helper()
+
+	dir := t.TempDir()
+	writeKey := func(name string) gossh.Signer {
+		t.Helper()
+
+		_, priv, err := ed25519.GenerateKey(nil)
+		if err != nil {
+			t.Fatalf("failed to generate key: %v", err)
+		}
+		block, err := gossh.MarshalPrivateKey(priv, "")
+		if err != nil {
+			t.Fatalf("failed to marshal key: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), pem.EncodeToMemory(block), 0o600); err != nil {
+			t.Fatalf("failed to write key: %v", err)
+		}
+
+		s, err := gossh.NewSignerFromKey(priv)
+		if err != nil {
+			t.Fatalf("failed to create signer: %v", err)
+		}
+		return s
+	}
+
+	host, client := writeKey("host_key"), writeKey("key")
+
+	ids, err := consrv.NewIdentities([]consrv.Identity{{
+		Name:      "frontend",
+		PublicKey: client.PublicKey(),
+	}}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create identities: %v", err)
+	}
+
+	hostPEM, err := os.ReadFile(filepath.Join(dir, "host_key"))
+	if err != nil {
+		t.Fatalf("failed to read host key: %v", err)
+	}
+
+	srv, err := consrv.NewServer(consrv.ServerConfig{
+		HostKey:    hostPEM,
+		Devices:    devices,
+		Identities: ids,
+		Logger:     log.New(io.Discard, "", 0),
+	})
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+
+	go func() { _ = srv.Serve(l) }()
+
+	addr := l.Addr().String()
+	line := knownhosts.Line([]string{knownhosts.Normalize(addr)}, host.PublicKey())
+	if err := os.WriteFile(filepath.Join(dir, "known_hosts"), []byte(line+"\n"), 0o644); err != nil {
+		t.Fatalf("failed to write known hosts: %v", err)
+	}
+
+	return addr, remoteConfig{
+		Name:       "lab",
+		Address:    addr,
+		KeyFile:    filepath.Join(dir, "key"),
+		KnownHosts: filepath.Join(dir, "known_hosts"),
+	}
+}
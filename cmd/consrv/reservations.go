@@ -0,0 +1,56 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/mdlayher/consrv"
+)
+
+// A reservationsHandler serves the current device reservations as JSON:
+//
+//	GET /reservations
+type reservationsHandler func() []consrv.Reservation
+
+// A jsonReservation is the JSON representation of a consrv.Reservation.
+type jsonReservation struct {
+	Device   string    `json:"device"`
+	Identity string    `json:"identity"`
+	Until    time.Time `json:"until"`
+	Queue    []string  `json:"queue"`
+}
+
+// ServeHTTP implements http.Handler.
+func (fn reservationsHandler) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	rs := make([]jsonReservation, 0)
+	for _, r := range fn() {
+		q := r.Queue
+		if q == nil {
+			q = []string{}
+		}
+
+		rs = append(rs, jsonReservation{
+			Device:   r.Device,
+			Identity: r.Identity,
+			Until:    r.Until,
+			Queue:    q,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(rs)
+}
@@ -0,0 +1,68 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mdlayher/consrv"
+)
+
+func Test_reservationsHandler(t *testing.T) {
+	tests := []struct {
+		name string
+		rs   []consrv.Reservation
+		want string
+	}{
+		{
+			name: "none",
+			want: "[]\n",
+		},
+		{
+			name: "reserved",
+			rs: []consrv.Reservation{
+				{
+					Device:   "bar",
+					Identity: "alice",
+					Until:    time.Date(2024, time.March, 5, 15, 0, 0, 0, time.UTC),
+				},
+				{
+					Device:   "foo",
+					Identity: "bob",
+					Until:    time.Date(2024, time.March, 5, 16, 0, 0, 0, time.UTC),
+					Queue:    []string{"alice", "carol"},
+				},
+			},
+			want: `[{"device":"bar","identity":"alice","until":"2024-03-05T15:00:00Z","queue":[]},` +
+				`{"device":"foo","identity":"bob","until":"2024-03-05T16:00:00Z","queue":["alice","carol"]}]` + "\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := reservationsHandler(func() []consrv.Reservation { return tt.rs })
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/reservations", nil))
+
+			if diff := cmp.Diff(tt.want, w.Body.String()); diff != "" {
+				t.Fatalf("unexpected body (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,360 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mdlayher/metricslite"
+)
+
+// retentionConfig contains the retention policy for device log files.
+type retentionConfig struct {
+	MaxAge  duration `toml:"max_age"`
+	MaxSize int64    `toml:"max_size"`
+}
+
+// validate verifies the retention configuration.
+func (rc *retentionConfig) validate() error {
+	if rc.MaxAge.Duration < 0 || rc.MaxSize < 0 {
+		return errors.New("log retention must not have a negative maximum age or size")
+	}
+	if rc.MaxAge.Duration == 0 && rc.MaxSize == 0 {
+		return errors.New("log retention must have a maximum age or size")
+	}
+
+	return nil
+}
+
+const (
+	// retentionInterval is the interval at which log files are rotated by age
+	// and pruned.
+	retentionInterval = 1 * time.Minute
+
+	// rotatedLayout is the timestamp format in the names of rotated log files.
+	rotatedLayout = "20060102T150405.000000000Z"
+)
+
+// A logFile is a device's log file which may be rotated and pruned to retain
+// a bounded amount of output, and which may be paused while sessions whose
+// identities are excluded from recording are attached. Output may also be
+// downsampled or dropped by a diskWatchdog while free space is low.
+type logFile struct {
+	dir, name  string
+	maxAge     time.Duration
+	maxSize    int64
+	rotateSize int64
+	stored     metricslite.Gauge
+	dropped    metricslite.Counter
+	now        func() time.Time
+	ll         *log.Logger
+
+	mu      sync.Mutex
+	f       *os.File
+	size    int64
+	opened  time.Time
+	rotated int64
+	paused  int
+
+	// disk is the state of the diskWatchdog, if any. While it is diskLow, at
+	// most rate bytes are written in each second beginning at window.
+	disk    string
+	rate    int64
+	window  time.Time
+	written int64
+}
+
+// newLogFile opens the log file for the named device in dir with the optional
+// retention policy rc, reporting the bytes stored for the device to stored
+// and the bytes dropped while disk space is low to dropped.
+func newLogFile(
+	dir, name string,
+	rc *retentionConfig,
+	stored metricslite.Gauge,
+	dropped metricslite.Counter,
+	ll *log.Logger,
+) (*logFile, error) {
+	f, err := openLogFile(dir, name)
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	lf := &logFile{
+		dir:     dir,
+		name:    name,
+		stored:  stored,
+		dropped: dropped,
+		now:     time.Now,
+		ll:      ll,
+		f:       f,
+		size:    fi.Size(),
+		opened:  time.Now(),
+		disk:    diskOK,
+	}
+	if rc != nil {
+		lf.maxAge = rc.MaxAge.Duration
+		lf.maxSize = rc.MaxSize
+
+		// Rotate in several steps so that pruning the oldest log file doesn't
+		// discard most of the retained output at once.
+		if rc.MaxSize > 0 {
+			lf.rotateSize = max(rc.MaxSize/8, 1)
+		}
+	}
+
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+	lf.pruneLocked()
+
+	return lf, nil
+}
+
+// Write implements io.Writer.
+func (lf *logFile) Write(b []byte) (int, error) {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+
+	if lf.paused > 0 {
+		// Output is not recorded while an excluded identity is attached.
+		return len(b), nil
+	}
+
+	switch lf.disk {
+	case diskFull:
+		lf.dropped(float64(len(b)), lf.name)
+		return len(b), nil
+	case diskLow:
+		now := lf.now()
+		if now.Sub(lf.window) >= time.Second {
+			lf.window = now
+			lf.written = 0
+		}
+		if lf.written+int64(len(b)) > lf.rate {
+			lf.dropped(float64(len(b)), lf.name)
+			return len(b), nil
+		}
+		lf.written += int64(len(b))
+	}
+
+	return lf.writeLocked(b)
+}
+
+// setDisk sets the state of the diskWatchdog, noting the change in the log
+// file. While the state is diskLow, at most rate bytes are written per second.
+func (lf *logFile) setDisk(state string, rate int64) {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+
+	if state == lf.disk {
+		return
+	}
+
+	var note string
+	switch state {
+	case diskOK:
+		note = "consrv: logging resumed, log volume has free space\n"
+	case diskLow:
+		note = "consrv: logging downsampled, log volume is low on space\n"
+	case diskFull:
+		note = "consrv: logging paused, log volume is full\n"
+	}
+	_, _ = lf.writeLocked([]byte(note))
+
+	lf.disk = state
+	lf.rate = rate
+	lf.window = time.Time{}
+	lf.written = 0
+}
+
+// writeLocked writes b to the log file, rotating it first if b would exceed
+// the rotation size. The caller must hold lf.mu.
+func (lf *logFile) writeLocked(b []byte) (int, error) {
+	if lf.rotateSize > 0 && lf.size > 0 && lf.size+int64(len(b)) > lf.rotateSize {
+		lf.rotateLocked()
+	}
+
+	n, err := lf.f.Write(b)
+	lf.size += int64(n)
+	lf.stored(float64(lf.size+lf.rotated), lf.name)
+	return n, err
+}
+
+// sync flushes the log file to stable storage.
+func (lf *logFile) sync() error {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+
+	return lf.f.Sync()
+}
+
+// pause stops recording output until resume is called as many times as pause.
+func (lf *logFile) pause() {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+
+	if lf.paused == 0 {
+		_, _ = lf.writeLocked([]byte("consrv: recording paused for an excluded identity\n"))
+	}
+	lf.paused++
+}
+
+// resume resumes recording output after pause.
+func (lf *logFile) resume() {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+
+	lf.paused--
+	if lf.paused == 0 {
+		_, _ = lf.writeLocked([]byte("consrv: recording resumed\n"))
+	}
+}
+
+// run rotates and prunes the log file at each interval until the process
+// exits.
+func (lf *logFile) run(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for range t.C {
+		lf.expire()
+	}
+}
+
+// expire rotates the log file once its output may be older than the maximum
+// age, and prunes rotated log files.
+func (lf *logFile) expire() {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+
+	// Rotate at least daily so that old output can be pruned without losing
+	// recent output in the same file.
+	if lf.maxAge > 0 && lf.size > 0 && lf.now().Sub(lf.opened) >= min(lf.maxAge, 24*time.Hour) {
+		lf.rotateLocked()
+		return
+	}
+
+	lf.pruneLocked()
+}
+
+// rotateLocked renames the log file with a timestamp, opens a new log file,
+// and prunes rotated log files. The caller must hold lf.mu.
+func (lf *logFile) rotateLocked() {
+	now := lf.now()
+	cur := filepath.Join(lf.dir, lf.name+".log")
+	dst := filepath.Join(lf.dir, lf.name+"."+now.UTC().Format(rotatedLayout)+".log")
+	if err := os.Rename(cur, dst); err != nil {
+		errorf(lf.ll, "%s: failed to rotate log file: %v", lf.name, err)
+		return
+	}
+
+	f, err := openLogFile(lf.dir, lf.name)
+	if err != nil {
+		// Keep writing to the renamed file rather than losing output.
+		errorf(lf.ll, "%s: failed to open new log file: %v", lf.name, err)
+		return
+	}
+
+	_ = lf.f.Close()
+	lf.f = f
+	lf.size = 0
+	lf.opened = now
+	lf.pruneLocked()
+}
+
+// pruneLocked removes the rotated log files which are older than the maximum
+// age, and then the oldest rotated log files until the total size is within
+// the maximum size. The caller must hold lf.mu.
+func (lf *logFile) pruneLocked() {
+	entries, err := os.ReadDir(lf.dir)
+	if err != nil {
+		errorf(lf.ll, "%s: failed to list log files: %v", lf.name, err)
+		return
+	}
+
+	type rotated struct {
+		path string
+		t    time.Time
+		size int64
+	}
+
+	var (
+		files []rotated
+		total = lf.size
+		now   = lf.now()
+	)
+	for _, e := range entries {
+		// Only match this device's rotated log files, and not the log files
+		// of devices whose names begin with this device's name.
+		s, ok := strings.CutPrefix(e.Name(), lf.name+".")
+		if !ok {
+			continue
+		}
+		s, ok = strings.CutSuffix(s, ".log")
+		if !ok {
+			continue
+		}
+		t, err := time.Parse(rotatedLayout, s)
+		if err != nil {
+			continue
+		}
+
+		fi, err := e.Info()
+		if err != nil {
+			continue
+		}
+
+		path := filepath.Join(lf.dir, e.Name())
+		if lf.maxAge > 0 && now.Sub(fi.ModTime()) > lf.maxAge && lf.remove(path) {
+			continue
+		}
+
+		files = append(files, rotated{path: path, t: t, size: fi.Size()})
+		total += fi.Size()
+	}
+
+	slices.SortFunc(files, func(a, b rotated) int { return a.t.Compare(b.t) })
+	for lf.maxSize > 0 && total > lf.maxSize && len(files) > 0 {
+		if lf.remove(files[0].path) {
+			total -= files[0].size
+		}
+		files = files[1:]
+	}
+
+	lf.rotated = total - lf.size
+	lf.stored(float64(total), lf.name)
+}
+
+// remove removes the log file at path and reports whether it was removed.
+func (lf *logFile) remove(path string) bool {
+	if err := os.Remove(path); err != nil {
+		errorf(lf.ll, "%s: failed to remove expired log file: %v", lf.name, err)
+		return false
+	}
+
+	lf.ll.Printf("%s: removed expired log file %q", lf.name, path)
+	return true
+}
@@ -0,0 +1,211 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_retentionConfigValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		rc   retentionConfig
+		ok   bool
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name: "negative age",
+			rc:   retentionConfig{MaxAge: duration{-time.Hour}},
+		},
+		{
+			name: "negative size",
+			rc:   retentionConfig{MaxSize: -1},
+		},
+		{
+			name: "OK",
+			rc:   retentionConfig{MaxAge: duration{720 * time.Hour}, MaxSize: 1 << 20},
+			ok:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.rc.validate()
+			if tt.ok && err != nil {
+				t.Fatalf("failed to validate: %v", err)
+			}
+			if !tt.ok && err == nil {
+				t.Fatal("expected an error, but none occurred")
+			}
+		})
+	}
+}
+
+func Test_logFileRotateSize(t *testing.T) {
+	dir := t.TempDir()
+
+	// Another device whose name begins with this device's name must not have
+	// its log pruned.
+	other := filepath.Join(dir, "server.old.log")
+	if err := os.WriteFile(other, []byte("other\n"), 0o640); err != nil {
+		t.Fatalf("failed to write other log: %v", err)
+	}
+
+	var stored float64
+	lf, now := testLogFile(t, dir, &retentionConfig{MaxSize: 20}, &stored)
+	lf.rotateSize = 10
+
+	// The log file is rotated when a write would exceed the rotation size, and
+	// the oldest rotated files are pruned once the total exceeds the maximum
+	// size.
+	for _, s := range []string{"one\n", "two\n", "three\n", "four\n"} {
+		*now = now.Add(time.Second)
+		for range 10 / len(s) {
+			write(t, lf, s)
+		}
+	}
+
+	want := []string{
+		"server.20240101T000003.000000000Z.log",
+		"server.20240101T000004.000000000Z.log",
+		"server.log",
+		"server.old.log",
+	}
+	if diff := cmp.Diff(want, logFiles(t, dir)); diff != "" {
+		t.Fatalf("unexpected log files (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(float64(len("two\ntwo\nthree\nfour\nfour\n")), stored); diff != "" {
+		t.Fatalf("unexpected stored bytes (-want +got):\n%s", diff)
+	}
+}
+
+func Test_logFileExpire(t *testing.T) {
+	dir := t.TempDir()
+
+	var stored float64
+	lf, now := testLogFile(t, dir, &retentionConfig{MaxAge: duration{48 * time.Hour}}, &stored)
+
+	write(t, lf, "day one\n")
+
+	// The log file is rotated daily, and rotated log files are removed once
+	// they are older than the maximum age.
+	*now = now.Add(24 * time.Hour)
+	lf.expire()
+	write(t, lf, "day two\n")
+
+	rotated := filepath.Join(dir, "server.20240102T000000.000000000Z.log")
+	old := now.Add(-48 * time.Hour)
+	if err := os.Chtimes(rotated, old, old); err != nil {
+		t.Fatalf("failed to age log file: %v", err)
+	}
+
+	*now = now.Add(time.Hour)
+	lf.expire()
+
+	if diff := cmp.Diff([]string{"server.log"}, logFiles(t, dir)); diff != "" {
+		t.Fatalf("unexpected log files (-want +got):\n%s", diff)
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, "server.log"))
+	if err != nil {
+		t.Fatalf("failed to read log: %v", err)
+	}
+	if diff := cmp.Diff("day two\n", string(b)); diff != "" {
+		t.Fatalf("unexpected log (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(float64(len("day two\n")), stored); diff != "" {
+		t.Fatalf("unexpected stored bytes (-want +got):\n%s", diff)
+	}
+}
+
+func Test_logFilePause(t *testing.T) {
+	dir := t.TempDir()
+	lf, _ := testLogFile(t, dir, nil, new(float64))
+
+	write(t, lf, "before\n")
+	lf.pause()
+	lf.pause()
+	write(t, lf, "secret\n")
+	lf.resume()
+	write(t, lf, "secret\n")
+	lf.resume()
+	write(t, lf, "after\n")
+
+	b, err := os.ReadFile(filepath.Join(dir, "server.log"))
+	if err != nil {
+		t.Fatalf("failed to read log: %v", err)
+	}
+
+	want := "before\nconsrv: recording paused for an excluded identity\nconsrv: recording resumed\nafter\n"
+	if diff := cmp.Diff(want, string(b)); diff != "" {
+		t.Fatalf("unexpected log (-want +got):\n%s", diff)
+	}
+}
+
+// testLogFile creates a logFile for device "server" in dir whose clock starts
+// at midnight on January 1st, 2024.
+func testLogFile(t *testing.T, dir string, rc *retentionConfig, stored *float64) (*logFile, *time.Time) {
+	t.Helper()
+
+	lf, err := newLogFile(dir, "server", rc,
+		func(v float64, _ ...string) { *stored = v },
+		func(float64, ...string) {},
+		log.New(io.Discard, "", 0),
+	)
+	if err != nil {
+		t.Fatalf("failed to open log file: %v", err)
+	}
+	t.Cleanup(func() { _ = lf.f.Close() })
+
+	now := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	lf.now = func() time.Time { return now }
+	lf.opened = now
+
+	return lf, &now
+}
+
+func write(t *testing.T, lf *logFile, s string) {
+	t.Helper()
+
+	if _, err := io.WriteString(lf, s); err != nil {
+		t.Fatalf("failed to write log: %v", err)
+	}
+}
+
+func logFiles(t *testing.T, dir string) []string {
+	t.Helper()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("failed to list log files: %v", err)
+	}
+
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	slices.Sort(names)
+
+	return names
+}
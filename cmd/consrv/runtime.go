@@ -0,0 +1,167 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"runtime"
+	rdebug "runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Runtime settings which may be read or changed by a runtimeTuner.
+const (
+	settingMutexProfileFraction = "mutex_profile_fraction"
+	settingBlockProfileRate     = "block_profile_rate"
+	settingGOGC                 = "gogc"
+	settingGOMAXPROCS           = "gomaxprocs"
+)
+
+// A runtimeTuner is an http.Handler which reads and adjusts Go runtime
+// profiling and tuning parameters without a restart.
+type runtimeTuner struct {
+	ll *log.Logger
+
+	// The runtime does not expose the current block profile rate or GC
+	// percent without also setting them, so the last applied values are
+	// tracked here.
+	mu        sync.Mutex
+	blockRate int
+	gcPercent int
+}
+
+// newRuntimeTuner creates a runtimeTuner which logs changes to ll.
+func newRuntimeTuner(ll *log.Logger) *runtimeTuner {
+	// Read the GC percent, which may have been set by $GOGC.
+	gc := rdebug.SetGCPercent(100)
+	rdebug.SetGCPercent(gc)
+
+	return &runtimeTuner{
+		ll:        ll,
+		gcPercent: gc,
+	}
+}
+
+// get returns the current value of setting.
+func (rt *runtimeTuner) get(setting string) int {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	switch setting {
+	case settingMutexProfileFraction:
+		return runtime.SetMutexProfileFraction(-1)
+	case settingBlockProfileRate:
+		return rt.blockRate
+	case settingGOGC:
+		return rt.gcPercent
+	case settingGOMAXPROCS:
+		return runtime.GOMAXPROCS(0)
+	default:
+		panic("consrv: unhandled runtime setting: " + setting)
+	}
+}
+
+// set validates and applies v to setting.
+func (rt *runtimeTuner) set(setting string, v int) error {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	switch setting {
+	case settingMutexProfileFraction:
+		if v < 0 {
+			return fmt.Errorf("%s must be 0 or greater", setting)
+		}
+		runtime.SetMutexProfileFraction(v)
+	case settingBlockProfileRate:
+		if v < 0 {
+			return fmt.Errorf("%s must be 0 or greater", setting)
+		}
+		runtime.SetBlockProfileRate(v)
+		rt.blockRate = v
+	case settingGOGC:
+		// Negative values disable the garbage collector.
+		if v < -1 {
+			return fmt.Errorf("%s must be -1 or greater", setting)
+		}
+		rdebug.SetGCPercent(v)
+		rt.gcPercent = v
+	case settingGOMAXPROCS:
+		if v < 1 {
+			return fmt.Errorf("%s must be 1 or greater", setting)
+		}
+		runtime.GOMAXPROCS(v)
+	default:
+		panic("consrv: unhandled runtime setting: " + setting)
+	}
+
+	rt.ll.Printf("debug: set runtime %s to %d", setting, v)
+	return nil
+}
+
+// runtimeSettings is the sorted list of settings known to a runtimeTuner.
+var runtimeSettings = []string{
+	settingBlockProfileRate,
+	settingGOGC,
+	settingGOMAXPROCS,
+	settingMutexProfileFraction,
+}
+
+// ServeHTTP implements http.Handler to read or change runtime settings:
+//
+//	GET /debug/runtime
+//	GET /debug/runtime/{setting}
+//	PUT /debug/runtime/{setting} (body: integer value)
+func (rt *runtimeTuner) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+	setting := r.PathValue("setting")
+	if setting == "" {
+		for _, s := range runtimeSettings {
+			_, _ = fmt.Fprintf(w, "%s %d\n", s, rt.get(s))
+		}
+		return
+	}
+
+	if !slices.Contains(runtimeSettings, setting) {
+		http.Error(w, fmt.Sprintf("unknown runtime setting %q", setting), http.StatusNotFound)
+		return
+	}
+
+	if r.Method == http.MethodPut {
+		b, err := io.ReadAll(io.LimitReader(r.Body, 64))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		v, err := strconv.Atoi(strings.TrimSpace(string(b)))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid %s value %q", setting, strings.TrimSpace(string(b))), http.StatusBadRequest)
+			return
+		}
+
+		if err := rt.set(setting, v); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	_, _ = fmt.Fprintln(w, rt.get(setting))
+}
@@ -0,0 +1,146 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	rdebug "runtime/debug"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_runtimeTunerServeHTTP(t *testing.T) {
+	// Restore the global runtime settings modified by these tests.
+	procs := runtime.GOMAXPROCS(0)
+	gc := rdebug.SetGCPercent(100)
+	rdebug.SetGCPercent(gc)
+	t.Cleanup(func() {
+		runtime.GOMAXPROCS(procs)
+		rdebug.SetGCPercent(gc)
+		runtime.SetMutexProfileFraction(0)
+		runtime.SetBlockProfileRate(0)
+	})
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		code   int
+		want   string
+	}{
+		{
+			name:   "put mutex profile fraction",
+			method: http.MethodPut,
+			path:   "/debug/runtime/mutex_profile_fraction",
+			body:   "5\n",
+			code:   http.StatusOK,
+			want:   "5\n",
+		},
+		{
+			name:   "put block profile rate",
+			method: http.MethodPut,
+			path:   "/debug/runtime/block_profile_rate",
+			body:   "1000",
+			code:   http.StatusOK,
+			want:   "1000\n",
+		},
+		{
+			name:   "put GOGC",
+			method: http.MethodPut,
+			path:   "/debug/runtime/gogc",
+			body:   "50",
+			code:   http.StatusOK,
+			want:   "50\n",
+		},
+		{
+			name:   "put GOMAXPROCS",
+			method: http.MethodPut,
+			path:   "/debug/runtime/gomaxprocs",
+			body:   "1",
+			code:   http.StatusOK,
+			want:   "1\n",
+		},
+		{
+			name:   "get all",
+			method: http.MethodGet,
+			path:   "/debug/runtime",
+			code:   http.StatusOK,
+			want: strings.Join([]string{
+				"block_profile_rate 1000",
+				"gogc 50",
+				"gomaxprocs 1",
+				"mutex_profile_fraction 5",
+			}, "\n") + "\n",
+		},
+		{
+			name:   "get one",
+			method: http.MethodGet,
+			path:   "/debug/runtime/gogc",
+			code:   http.StatusOK,
+			want:   "50\n",
+		},
+		{
+			name:   "bad setting",
+			method: http.MethodGet,
+			path:   "/debug/runtime/gc_percent",
+			code:   http.StatusNotFound,
+			want:   "unknown runtime setting \"gc_percent\"\n",
+		},
+		{
+			name:   "bad value",
+			method: http.MethodPut,
+			path:   "/debug/runtime/gogc",
+			body:   "off",
+			code:   http.StatusBadRequest,
+			want:   "invalid gogc value \"off\"\n",
+		},
+		{
+			name:   "bad GOMAXPROCS",
+			method: http.MethodPut,
+			path:   "/debug/runtime/gomaxprocs",
+			body:   "0",
+			code:   http.StatusBadRequest,
+			want:   "gomaxprocs must be 1 or greater\n",
+		},
+	}
+
+	// The tests run in order against a single tuner, since the runtime
+	// settings are global.
+	rt := newRuntimeTuner(log.New(io.Discard, "", 0))
+	mux := http.NewServeMux()
+	mux.Handle("GET /debug/runtime", rt)
+	mux.Handle("GET /debug/runtime/{setting}", rt)
+	mux.Handle("PUT /debug/runtime/{setting}", rt)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+
+			if diff := cmp.Diff(tt.code, w.Code); diff != "" {
+				t.Fatalf("unexpected status code (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tt.want, w.Body.String()); diff != "" {
+				t.Fatalf("unexpected body (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package main
+
+import (
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func Test_landlockRights(t *testing.T) {
+	tests := []struct {
+		name      string
+		abi       int
+		has, lack uint64
+	}{
+		{
+			name: "v1",
+			abi:  1,
+			has:  unix.LANDLOCK_ACCESS_FS_READ_FILE | unix.LANDLOCK_ACCESS_FS_MAKE_SYM,
+			lack: unix.LANDLOCK_ACCESS_FS_REFER | unix.LANDLOCK_ACCESS_FS_TRUNCATE,
+		},
+		{
+			name: "v3",
+			abi:  3,
+			has:  unix.LANDLOCK_ACCESS_FS_REFER | unix.LANDLOCK_ACCESS_FS_TRUNCATE,
+			lack: unix.LANDLOCK_ACCESS_FS_IOCTL_DEV,
+		},
+		{
+			name: "v5",
+			abi:  5,
+			has:  unix.LANDLOCK_ACCESS_FS_TRUNCATE | unix.LANDLOCK_ACCESS_FS_IOCTL_DEV,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := landlockRights(tt.abi)
+			if got&tt.has != tt.has {
+				t.Fatalf("rights %#x missing expected rights %#x", got, tt.has)
+			}
+			if got&tt.lack != 0 {
+				t.Fatalf("rights %#x contain unsupported rights %#x", got, tt.lack)
+			}
+		})
+	}
+}
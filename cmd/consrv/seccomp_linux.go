@@ -0,0 +1,162 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux && (amd64 || arm64)
+
+package main
+
+import (
+	"fmt"
+
+	"golang.org/x/net/bpf"
+	"golang.org/x/sys/unix"
+)
+
+// seccompSyscalls are the system calls permitted on all architectures once
+// privileges have been dropped. These cover the Go runtime, network I/O for
+// the SSH and HTTP servers, and I/O on the already opened serial devices.
+// Sockets and files can't be created, so features which need them after
+// startup are rejected by config.checkDropPrivileges.
+var seccompSyscalls = []uintptr{
+	// Runtime: memory, threads, signals, and scheduling.
+	unix.SYS_BRK,
+	unix.SYS_CLONE,
+	unix.SYS_EXIT,
+	unix.SYS_EXIT_GROUP,
+	unix.SYS_FUTEX,
+	unix.SYS_GETPID,
+	unix.SYS_GETTID,
+	unix.SYS_MADVISE,
+	unix.SYS_MMAP,
+	unix.SYS_MPROTECT,
+	unix.SYS_MUNMAP,
+	unix.SYS_NANOSLEEP,
+	unix.SYS_CLOCK_GETTIME,
+	unix.SYS_CLOCK_NANOSLEEP,
+	unix.SYS_RESTART_SYSCALL,
+	unix.SYS_RT_SIGACTION,
+	unix.SYS_RT_SIGPROCMASK,
+	unix.SYS_RT_SIGRETURN,
+	unix.SYS_SCHED_GETAFFINITY,
+	unix.SYS_SCHED_YIELD,
+	unix.SYS_SIGALTSTACK,
+	unix.SYS_TGKILL,
+	unix.SYS_TKILL,
+	unix.SYS_SETITIMER,
+	unix.SYS_TIMER_CREATE,
+	unix.SYS_TIMER_DELETE,
+	unix.SYS_TIMER_SETTIME,
+	unix.SYS_GETRANDOM,
+
+	// Threads created by pthread_create in cgo-enabled builds.
+	unix.SYS_CLONE3,
+	unix.SYS_RSEQ,
+	unix.SYS_SET_ROBUST_LIST,
+
+	// Network poller.
+	unix.SYS_EPOLL_CREATE1,
+	unix.SYS_EPOLL_CTL,
+	unix.SYS_EPOLL_PWAIT,
+	unix.SYS_EVENTFD2,
+	unix.SYS_PIPE2,
+
+	// File descriptor and socket I/O.
+	unix.SYS_ACCEPT4,
+	unix.SYS_CLOSE,
+	unix.SYS_FCNTL,
+	unix.SYS_FSTAT,
+	unix.SYS_GETPEERNAME,
+	unix.SYS_GETSOCKNAME,
+	unix.SYS_GETSOCKOPT,
+	unix.SYS_IOCTL,
+	unix.SYS_READ,
+	unix.SYS_READV,
+	unix.SYS_RECVFROM,
+	unix.SYS_RECVMSG,
+	unix.SYS_SENDMSG,
+	unix.SYS_SENDTO,
+	unix.SYS_SETSOCKOPT,
+	unix.SYS_SHUTDOWN,
+	unix.SYS_WRITE,
+	unix.SYS_WRITEV,
+}
+
+// seccompFilter assembles the seccomp BPF program produced by
+// seccompInstructions.
+func seccompFilter() (*unix.SockFprog, error) {
+	insns, err := seccompInstructions()
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := bpf.Assemble(insns)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := make([]unix.SockFilter, 0, len(raw))
+	for _, r := range raw {
+		filter = append(filter, unix.SockFilter{
+			Code: r.Op,
+			Jt:   r.Jt,
+			Jf:   r.Jf,
+			K:    r.K,
+		})
+	}
+
+	return &unix.SockFprog{
+		Len:    uint16(len(filter)),
+		Filter: &filter[0],
+	}, nil
+}
+
+// seccompInstructions produces a seccomp BPF program which allows the system
+// calls in seccompSyscalls and seccompArchSyscalls, and denies all others with
+// EPERM.
+func seccompInstructions() ([]bpf.Instruction, error) {
+	nrs := append(append([]uintptr{}, seccompSyscalls...), seccompArchSyscalls...)
+	if len(nrs) > 255 {
+		// Jump offsets in classic BPF are limited to a uint8.
+		return nil, fmt.Errorf("too many system calls in allowlist: %d", len(nrs))
+	}
+
+	// Offsets within struct seccomp_data.
+	const (
+		offNR   = 0
+		offArch = 4
+	)
+
+	insns := []bpf.Instruction{
+		// Kill the process outright if a system call is made using another
+		// architecture's ABI, because the numbers below would be meaningless.
+		bpf.LoadAbsolute{Off: offArch, Size: 4},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: seccompArch, SkipTrue: 1},
+		bpf.RetConstant{Val: unix.SECCOMP_RET_KILL_PROCESS},
+		bpf.LoadAbsolute{Off: offNR, Size: 4},
+	}
+
+	// Each matching system call jumps over the remaining comparisons and the
+	// deny instruction to land on the final allow instruction.
+	for i, nr := range nrs {
+		insns = append(insns, bpf.JumpIf{
+			Cond:     bpf.JumpEqual,
+			Val:      uint32(nr),
+			SkipTrue: uint8(len(nrs) - i),
+		})
+	}
+
+	return append(insns,
+		bpf.RetConstant{Val: unix.SECCOMP_RET_ERRNO | uint32(unix.EPERM)},
+		bpf.RetConstant{Val: unix.SECCOMP_RET_ALLOW},
+	), nil
+}
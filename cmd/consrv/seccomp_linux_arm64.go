@@ -0,0 +1,22 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import "golang.org/x/sys/unix"
+
+// seccompArch is the audit architecture matched by the seccomp filter.
+const seccompArch = unix.AUDIT_ARCH_AARCH64
+
+// seccompArchSyscalls are additional system calls permitted on arm64.
+var seccompArchSyscalls []uintptr
@@ -0,0 +1,89 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux && (amd64 || arm64)
+
+package main
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/net/bpf"
+	"golang.org/x/sys/unix"
+)
+
+func Test_seccompInstructions(t *testing.T) {
+	insns, err := seccompInstructions()
+	if err != nil {
+		t.Fatalf("failed to create instructions: %v", err)
+	}
+
+	vm, err := bpf.NewVM(insns)
+	if err != nil {
+		t.Fatalf("failed to create BPF VM: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		arch uint32
+		nr   uintptr
+		want uint32
+	}{
+		{
+			name: "allow read",
+			arch: seccompArch,
+			nr:   unix.SYS_READ,
+			want: unix.SECCOMP_RET_ALLOW,
+		},
+		{
+			name: "allow last",
+			arch: seccompArch,
+			nr:   seccompSyscalls[len(seccompSyscalls)-1],
+			want: unix.SECCOMP_RET_ALLOW,
+		},
+		{
+			name: "deny openat",
+			arch: seccompArch,
+			nr:   unix.SYS_OPENAT,
+			want: unix.SECCOMP_RET_ERRNO | uint32(unix.EPERM),
+		},
+		{
+			name: "kill other arch",
+			arch: 0xffffffff,
+			nr:   unix.SYS_READ,
+			want: unix.SECCOMP_RET_KILL_PROCESS,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Only the nr and arch fields of struct seccomp_data are inspected
+			// by the filter. The kernel loads these in native endianness, but
+			// the BPF VM always loads big endian values.
+			b := make([]byte, 64)
+			binary.BigEndian.PutUint32(b[0:4], uint32(tt.nr))
+			binary.BigEndian.PutUint32(b[4:8], tt.arch)
+
+			got, err := vm.Run(b)
+			if err != nil {
+				t.Fatalf("failed to run VM: %v", err)
+			}
+
+			if diff := cmp.Diff(int(tt.want), got); diff != "" {
+				t.Fatalf("unexpected seccomp action (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
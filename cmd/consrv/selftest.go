@@ -0,0 +1,184 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/tarm/serial"
+)
+
+// selfTestRates are the baud rates tested by a selfTest, in addition to the
+// configured baud rate of a device.
+var selfTestRates = []int{9600, 19200, 38400, 57600, 115200}
+
+// selfTestTimeout is the read timeout after which a selfTest stops waiting
+// for a pattern to be looped back.
+const selfTestTimeout = 1 * time.Second
+
+// selfTestPattern returns the test pattern written by a selfTest: every byte
+// value, so that adapters which mangle control characters or the 8th bit are
+// caught, followed by printable text.
+func selfTestPattern() []byte {
+	b := make([]byte, 0, 256+64)
+	for i := range 256 {
+		b = append(b, byte(i))
+	}
+
+	return append(b, "The quick brown fox jumps over the lazy dog 0123456789\r\n"...)
+}
+
+// A selfTest verifies a serial port with a loopback plug attached by writing
+// and reading back a test pattern at several baud rates.
+type selfTest struct {
+	rates    []int
+	pattern  []byte
+	openPort func(cfg *serial.Config) (io.ReadWriteCloser, error)
+}
+
+// newSelfTest creates a selfTest which also tests baud if it is not one of the
+// default rates.
+func newSelfTest(baud int, openPort func(cfg *serial.Config) (io.ReadWriteCloser, error)) *selfTest {
+	rates := slices.Clone(selfTestRates)
+	if baud > 0 && !slices.Contains(rates, baud) {
+		rates = append(rates, baud)
+		slices.Sort(rates)
+	}
+
+	return &selfTest{
+		rates:    rates,
+		pattern:  selfTestPattern(),
+		openPort: openPort,
+	}
+}
+
+// run tests the serial port at path at each baud rate, reports the results to
+// w, and reports whether every rate passed.
+func (st *selfTest) run(w io.Writer, path string) bool {
+	fmt.Fprintf(w, "self-test of %s with %d byte pattern:\n", path, len(st.pattern))
+
+	ok := true
+	for _, baud := range st.rates {
+		if err := st.test(path, baud); err != nil {
+			fmt.Fprintf(w, "  %6d baud: FAIL: %v\n", baud, err)
+			ok = false
+			continue
+		}
+
+		fmt.Fprintf(w, "  %6d baud: ok\n", baud)
+	}
+
+	return ok
+}
+
+// test writes the pattern to the serial port at path and verifies that it is
+// read back at baud.
+func (st *selfTest) test(path string, baud int) error {
+	rwc, err := st.openPort(&serial.Config{
+		Name:        path,
+		Baud:        baud,
+		ReadTimeout: selfTestTimeout,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to open: %v", err)
+	}
+	defer rwc.Close()
+
+	if _, err := rwc.Write(st.pattern); err != nil {
+		return fmt.Errorf("failed to write: %v", err)
+	}
+
+	got := make([]byte, 0, len(st.pattern))
+	b := make([]byte, len(st.pattern))
+	for len(got) < len(st.pattern) {
+		n, err := rwc.Read(b[:len(st.pattern)-len(got)])
+		got = append(got, b[:n]...)
+		if n == 0 {
+			// The read timed out, which tarm/serial may report as io.EOF.
+			break
+		}
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("failed to read: %v", err)
+		}
+	}
+
+	switch {
+	case len(got) == 0:
+		return fmt.Errorf("no data received within %s, is a loopback plug attached?", selfTestTimeout)
+	case len(got) < len(st.pattern):
+		return fmt.Errorf("received %d of %d bytes", len(got), len(st.pattern))
+	}
+
+	if i := mismatch(st.pattern, got); i >= 0 {
+		return fmt.Errorf("mismatch at byte %d: sent %#02x, received %#02x", i, st.pattern[i], got[i])
+	}
+
+	return nil
+}
+
+// mismatch returns the index of the first byte which differs between a and b,
+// which are the same length, or -1 if they are equal.
+func mismatch(a, b []byte) int {
+	if bytes.Equal(a, b) {
+		return -1
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// runSelfTest runs a selfTest for device, which is the name of a device in cfg
+// or the path to a serial port, reports the results to w, and returns the
+// process exit code. cfg may be nil if no configuration file exists.
+func runSelfTest(device string, cfg *config, ll *log.Logger, w io.Writer) int {
+	d := rawDevice{Name: device, Device: device}
+	if cfg != nil {
+		for _, cd := range cfg.Devices {
+			if cd.Name == device {
+				d = cd
+				break
+			}
+		}
+	}
+	if d.Address != "" {
+		ll.Printf("device %q is a network-attached device, not a serial port", device)
+		return 1
+	}
+
+	fs, err := newFS(ll, true, false)
+	if err != nil {
+		errorf(ll, "failed to open filesystem: %v", err)
+		return 1
+	}
+	if err := fs.resolve(&d); err != nil {
+		errorf(ll, "failed to find device %q: %v", device, err)
+		return 1
+	}
+
+	if !newSelfTest(d.Baud, fs.openPort).run(w, d.Device) {
+		return 1
+	}
+
+	return 0
+}
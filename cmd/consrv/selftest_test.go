@@ -0,0 +1,170 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/tarm/serial"
+)
+
+func Test_selfTestRun(t *testing.T) {
+	tests := []struct {
+		name string
+		open func(cfg *serial.Config) (io.ReadWriteCloser, error)
+		ok   bool
+		want string
+	}{
+		{
+			name: "loopback",
+			open: func(*serial.Config) (io.ReadWriteCloser, error) {
+				return &loopback{}, nil
+			},
+			ok: true,
+			want: `
+    9600 baud: ok
+  115200 baud: ok
+`,
+		},
+		{
+			name: "unplugged",
+			open: func(*serial.Config) (io.ReadWriteCloser, error) {
+				return &loopback{drop: true}, nil
+			},
+			want: `
+    9600 baud: FAIL: no data received within 1s, is a loopback plug attached?
+  115200 baud: FAIL: no data received within 1s, is a loopback plug attached?
+`,
+		},
+		{
+			name: "7 bits",
+			open: func(*serial.Config) (io.ReadWriteCloser, error) {
+				return &loopback{mask: 0x7f}, nil
+			},
+			want: `
+    9600 baud: FAIL: mismatch at byte 128: sent 0x80, received 0x00
+  115200 baud: FAIL: mismatch at byte 128: sent 0x80, received 0x00
+`,
+		},
+		{
+			name: "baud",
+			open: func(cfg *serial.Config) (io.ReadWriteCloser, error) {
+				if cfg.Baud > 9600 {
+					return nil, errors.New("invalid argument")
+				}
+
+				return &loopback{}, nil
+			},
+			want: `
+    9600 baud: ok
+  115200 baud: FAIL: failed to open: invalid argument
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := &selfTest{
+				rates:    []int{9600, 115200},
+				pattern:  selfTestPattern(),
+				openPort: tt.open,
+			}
+
+			var buf bytes.Buffer
+			if diff := cmp.Diff(tt.ok, st.run(&buf, "/dev/ttyUSB0")); diff != "" {
+				t.Fatalf("unexpected result (-want +got):\n%s", diff)
+			}
+
+			want := "self-test of /dev/ttyUSB0 with 312 byte pattern:" + tt.want
+			if diff := cmp.Diff(strings.Split(want, "\n"), strings.Split(buf.String(), "\n")); diff != "" {
+				t.Fatalf("unexpected output (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_newSelfTestRates(t *testing.T) {
+	tests := []struct {
+		name string
+		baud int
+		want []int
+	}{
+		{
+			name: "default",
+			want: selfTestRates,
+		},
+		{
+			name: "included",
+			baud: 115200,
+			want: selfTestRates,
+		},
+		{
+			name: "additional",
+			baud: 230400,
+			want: []int{9600, 19200, 38400, 57600, 115200, 230400},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if diff := cmp.Diff(tt.want, newSelfTest(tt.baud, nil).rates); diff != "" {
+				t.Fatalf("unexpected rates (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// A loopback is an in-memory serial port with a loopback plug attached, which
+// optionally drops or masks the bytes written to it. Reads return io.EOF
+// when no data is available, as a timed out read would.
+type loopback struct {
+	drop bool
+	mask byte
+
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (l *loopback) Read(b []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.buf.Read(b)
+}
+
+func (l *loopback) Write(b []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.drop {
+		return len(b), nil
+	}
+
+	for _, c := range b {
+		if l.mask != 0 {
+			c &= l.mask
+		}
+		l.buf.WriteByte(c)
+	}
+
+	return len(b), nil
+}
+
+func (*loopback) Close() error { return nil }
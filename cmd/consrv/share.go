@@ -0,0 +1,244 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/mdlayher/consrv"
+)
+
+var _ consrv.Device = &sharedDevice{}
+
+const (
+	// sharedMinBackoff and sharedMaxBackoff bound the interval between
+	// attempts to open a shared serial port which is held by another process.
+	sharedMinBackoff = 1 * time.Second
+	sharedMaxBackoff = 30 * time.Second
+)
+
+// A busyError is returned when a shared serial port is held by another
+// process. PID and Program are set when the holder could be identified.
+type busyError struct {
+	PID     int
+	Program string
+}
+
+// Error implements error.
+func (e *busyError) Error() string {
+	switch {
+	case e.PID == 0:
+		return "port busy"
+	case e.Program == "":
+		return fmt.Sprintf("port busy (held by PID %d)", e.PID)
+	default:
+		return fmt.Sprintf("port busy (held by PID %d/%s)", e.PID, e.Program)
+	}
+}
+
+// A lockedPort is a serial port which holds an advisory lock until it is
+// closed.
+type lockedPort struct {
+	io.ReadWriteCloser
+	lock io.Closer
+}
+
+// Close implements io.ReadWriteCloser, releasing the lock after the port is
+// closed.
+func (p *lockedPort) Close() error {
+	err := p.ReadWriteCloser.Close()
+	if lerr := p.lock.Close(); err == nil {
+		err = lerr
+	}
+
+	return err
+}
+
+// A sharedDevice is a consrv.Device for a shared serial port which is held by
+// another process. Reads block while the port is retried with backoff until
+// it is released and opened, after which all operations are delegated to the
+// opened device.
+type sharedDevice struct {
+	name, path string
+	baud       int
+	open       func() (consrv.Device, error)
+	ll         *log.Logger
+
+	min, max time.Duration
+	done     chan struct{}
+
+	mu     sync.Mutex
+	closed bool
+	dev    consrv.Device
+	busy   *busyError
+}
+
+// newSharedDevice creates a sharedDevice for d, which is currently held as
+// described by busy, and uses open to retry opening it.
+func newSharedDevice(d rawDevice, busy *busyError, open func() (consrv.Device, error), ll *log.Logger) *sharedDevice {
+	return &sharedDevice{
+		name: d.Name,
+		path: d.Device,
+		baud: d.Baud,
+		open: open,
+		ll:   ll,
+		min:  sharedMinBackoff,
+		max:  sharedMaxBackoff,
+		done: make(chan struct{}),
+		busy: busy,
+	}
+}
+
+// Close implements io.ReadWriteCloser.
+func (d *sharedDevice) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.closed {
+		return nil
+	}
+
+	d.closed = true
+	close(d.done)
+	if d.dev != nil {
+		return d.dev.Close()
+	}
+
+	return nil
+}
+
+// Read implements io.ReadWriteCloser.
+func (d *sharedDevice) Read(b []byte) (int, error) {
+	dev, err := d.wait()
+	if err != nil {
+		return 0, io.EOF
+	}
+
+	return dev.Read(b)
+}
+
+// Write implements io.ReadWriteCloser.
+func (d *sharedDevice) Write(b []byte) (int, error) {
+	d.mu.Lock()
+	dev, busy := d.dev, d.busy
+	d.mu.Unlock()
+
+	if dev == nil {
+		return 0, fmt.Errorf("device %q: %v", d.name, busy)
+	}
+
+	return dev.Write(b)
+}
+
+// reconnect implements reconnector by closing the opened device, so that the
+// following read reopens it, waiting for any other holder to release it.
+func (d *sharedDevice) reconnect(err error) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.closed {
+		return false
+	}
+
+	if d.dev != nil {
+		errorf(d.ll, "%s: reopening device %q after read error: %v", d.name, d.path, err)
+		_ = d.dev.Close()
+		d.dev = nil
+	}
+
+	return true
+}
+
+// connected implements connector.
+func (d *sharedDevice) connected() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.dev != nil
+}
+
+// String returns the string representation of a sharedDevice, including the
+// holder of the port while it is busy.
+func (d *sharedDevice) String() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.dev != nil {
+		return d.dev.String()
+	}
+
+	return fmt.Sprintf("%q: path: %q, baud: %d, %v", d.name, d.path, d.baud, d.busy)
+}
+
+// wait returns the opened device, retrying with backoff while the port is
+// held by another process, until it is opened or the sharedDevice is closed.
+func (d *sharedDevice) wait() (consrv.Device, error) {
+	backoff := d.min
+	for {
+		d.mu.Lock()
+		dev, closed := d.dev, d.closed
+		d.mu.Unlock()
+
+		if closed {
+			return nil, errClosed
+		}
+		if dev != nil {
+			return dev, nil
+		}
+
+		dev, err := d.open()
+		if err == nil {
+			d.mu.Lock()
+			if d.closed {
+				d.mu.Unlock()
+				_ = dev.Close()
+				return nil, errClosed
+			}
+			d.dev = dev
+			d.mu.Unlock()
+
+			d.ll.Printf("%s: opened shared device %q", d.name, d.path)
+			return dev, nil
+		}
+
+		var busy *busyError
+		if errors.As(err, &busy) {
+			d.mu.Lock()
+			changed := d.busy == nil || *d.busy != *busy
+			d.busy = busy
+			d.mu.Unlock()
+
+			// Only log when the holder changes to avoid flooding the log while
+			// another tool has the port open.
+			if changed {
+				d.ll.Printf("%s: device %q: %v, retrying", d.name, d.path, busy)
+			}
+		} else {
+			errorf(d.ll, "%s: failed to open %q: %v", d.name, d.path, err)
+		}
+
+		select {
+		case <-d.done:
+			return nil, errClosed
+		case <-time.After(backoff):
+		}
+
+		backoff = min(backoff*2, d.max)
+	}
+}
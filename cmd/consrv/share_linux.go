@@ -0,0 +1,148 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// lockDirs are the directories searched for UUCP-style lock files, such as
+// those created by minicom.
+var lockDirs = []string{"/run/lock", "/var/lock"}
+
+// lockPort takes an flock advisory lock on a shared serial port, as used by
+// tools such as picocom.
+var lockPort = flockPort
+
+// portHolder identifies the holder of a busy serial port using lock files and
+// the open file descriptors in /proc.
+var portHolder = func(path string) *busyError { return findHolder("/proc", lockDirs, path) }
+
+// flockPort returns a lock on the serial port at path which is released when
+// it is closed, or a *busyError if a lock file or another process's lock on
+// the port indicates that it is in use.
+func flockPort(path string) (io.Closer, error) {
+	if b := lockFileHolder("/proc", lockDirs, path); b != nil {
+		return nil, b
+	}
+
+	f, err := os.OpenFile(path, unix.O_RDWR|unix.O_NOCTTY|unix.O_NONBLOCK, 0)
+	if err != nil {
+		if errors.Is(err, unix.EBUSY) {
+			return nil, portHolder(path)
+		}
+
+		return nil, err
+	}
+
+	if err := unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB); err != nil {
+		_ = f.Close()
+		if errors.Is(err, unix.EWOULDBLOCK) {
+			return nil, portHolder(path)
+		}
+
+		return nil, fmt.Errorf("failed to lock %q: %v", path, err)
+	}
+
+	return f, nil
+}
+
+// findHolder identifies the process which holds the port at path using lock
+// files in dirs and the open file descriptors under the proc filesystem.
+func findHolder(proc string, dirs []string, path string) *busyError {
+	if b := lockFileHolder(proc, dirs, path); b != nil {
+		return b
+	}
+
+	if real, err := filepath.EvalSymlinks(path); err == nil {
+		path = real
+	}
+
+	pids, err := os.ReadDir(proc)
+	if err != nil {
+		return &busyError{}
+	}
+
+	self := os.Getpid()
+	for _, p := range pids {
+		pid, err := strconv.Atoi(p.Name())
+		if err != nil || pid == self {
+			continue
+		}
+
+		fds, err := os.ReadDir(filepath.Join(proc, p.Name(), "fd"))
+		if err != nil {
+			// Most likely another user's process.
+			continue
+		}
+
+		for _, fd := range fds {
+			link, err := os.Readlink(filepath.Join(proc, p.Name(), "fd", fd.Name()))
+			if err == nil && link == path {
+				return &busyError{PID: pid, Program: program(proc, pid)}
+			}
+		}
+	}
+
+	return &busyError{}
+}
+
+// lockFileHolder returns a *busyError for the live process named by a
+// UUCP-style lock file for the port at path, or nil if there is none.
+func lockFileHolder(proc string, dirs []string, path string) *busyError {
+	if real, err := filepath.EvalSymlinks(path); err == nil {
+		path = real
+	}
+
+	name := "LCK.." + filepath.Base(path)
+	for _, dir := range dirs {
+		b, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			continue
+		}
+
+		// Lock files contain the holder's PID as ASCII text.
+		pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+		if err != nil || pid <= 0 || pid == os.Getpid() {
+			continue
+		}
+
+		// Ignore stale lock files left behind by a process which exited.
+		if _, err := os.Stat(filepath.Join(proc, strconv.Itoa(pid))); err != nil {
+			continue
+		}
+
+		return &busyError{PID: pid, Program: program(proc, pid)}
+	}
+
+	return nil
+}
+
+// program returns the name of the program running as pid, if known.
+func program(proc string, pid int) string {
+	b, err := os.ReadFile(filepath.Join(proc, strconv.Itoa(pid), "comm"))
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(b))
+}
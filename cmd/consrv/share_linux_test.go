@@ -0,0 +1,128 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/sys/unix"
+)
+
+func Test_findHolder(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(t *testing.T, proc, lock, port string)
+		want  *busyError
+	}{
+		{
+			name:  "unknown",
+			setup: func(_ *testing.T, _, _, _ string) {},
+			want:  &busyError{},
+		},
+		{
+			name: "lock file",
+			setup: func(t *testing.T, proc, lock, port string) {
+				writeFile(t, filepath.Join(lock, "LCK..ttyUSB0"), "       100\n")
+				writeFile(t, filepath.Join(proc, "100", "comm"), "minicom\n")
+			},
+			want: &busyError{PID: 100, Program: "minicom"},
+		},
+		{
+			name: "stale lock file",
+			setup: func(t *testing.T, proc, lock, port string) {
+				writeFile(t, filepath.Join(lock, "LCK..ttyUSB0"), "100\n")
+			},
+			want: &busyError{},
+		},
+		{
+			name: "open file descriptor",
+			setup: func(t *testing.T, proc, lock, port string) {
+				writeFile(t, filepath.Join(proc, "100", "comm"), "cat\n")
+				writeFile(t, filepath.Join(proc, "200", "comm"), "picocom\n")
+				if err := os.MkdirAll(filepath.Join(proc, "200", "fd"), 0o755); err != nil {
+					t.Fatalf("failed to create fd directory: %v", err)
+				}
+				if err := os.Symlink(port, filepath.Join(proc, "200", "fd", "3")); err != nil {
+					t.Fatalf("failed to create fd symlink: %v", err)
+				}
+			},
+			want: &busyError{PID: 200, Program: "picocom"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				dir  = t.TempDir()
+				proc = filepath.Join(dir, "proc")
+				lock = filepath.Join(dir, "lock")
+				port = filepath.Join(dir, "ttyUSB0")
+			)
+			writeFile(t, port, "")
+			if err := os.MkdirAll(proc, 0o755); err != nil {
+				t.Fatalf("failed to create proc: %v", err)
+			}
+
+			tt.setup(t, proc, lock, port)
+
+			got := findHolder(proc, []string{filepath.Join(dir, "missing"), lock}, port)
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Fatalf("unexpected holder (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_flockPort(t *testing.T) {
+	port := filepath.Join(t.TempDir(), "ttyUSB0")
+	writeFile(t, port, "")
+
+	f, err := os.Open(port)
+	if err != nil {
+		t.Fatalf("failed to open port: %v", err)
+	}
+	if err := unix.Flock(int(f.Fd()), unix.LOCK_EX); err != nil {
+		t.Fatalf("failed to lock port: %v", err)
+	}
+
+	// The holder is this process, which is never reported.
+	_, err = flockPort(port)
+	var busy *busyError
+	if !errors.As(err, &busy) {
+		t.Fatalf("expected a busy error, but got: %v", err)
+	}
+
+	_ = f.Close()
+
+	l, err := flockPort(port)
+	if err != nil {
+		t.Fatalf("failed to lock released port: %v", err)
+	}
+	_ = l.Close()
+}
+
+func writeFile(t *testing.T, file, s string) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(file, []byte(s), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+}
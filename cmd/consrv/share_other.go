@@ -0,0 +1,25 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux
+
+package main
+
+import "io"
+
+// Advisory locking and identifying the holder of a busy serial port are
+// implemented only on Linux, so shared ports are opened directly elsewhere.
+var (
+	lockPort   func(path string) (io.Closer, error)
+	portHolder func(path string) *busyError
+)
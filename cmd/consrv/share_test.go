@@ -0,0 +1,159 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mdlayher/consrv"
+	"github.com/tarm/serial"
+)
+
+func Test_busyError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  *busyError
+		s    string
+	}{
+		{
+			name: "unknown",
+			err:  &busyError{},
+			s:    "port busy",
+		},
+		{
+			name: "PID",
+			err:  &busyError{PID: 42},
+			s:    "port busy (held by PID 42)",
+		},
+		{
+			name: "program",
+			err:  &busyError{PID: 42, Program: "minicom"},
+			s:    "port busy (held by PID 42/minicom)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if diff := cmp.Diff(tt.s, tt.err.Error()); diff != "" {
+				t.Fatalf("unexpected error (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_fs_openSerialShared(t *testing.T) {
+	busy := &busyError{PID: 42, Program: "minicom"}
+
+	var unlocked int
+	fs := &fs{
+		openPort: func(_ *serial.Config) (io.ReadWriteCloser, error) {
+			return nil, syscall.EBUSY
+		},
+		lockPort: func(_ string) (io.Closer, error) {
+			return closerFunc(func() error {
+				unlocked++
+				return nil
+			}), nil
+		},
+		portHolder: func(_ string) *busyError { return busy },
+		ll:         log.New(io.Discard, "", 0),
+	}
+	if err := fs.init(fs.ll); err != nil {
+		t.Fatalf("failed to init fs: %v", err)
+	}
+
+	d := rawDevice{Name: "server", Device: "/dev/ttyUSB0", Baud: 115200}
+
+	// Without sharing, a busy port is an error.
+	if _, err := fs.openSerial(&d, nil, nil); !errors.Is(err, syscall.EBUSY) {
+		t.Fatalf("expected EBUSY, but got: %v", err)
+	}
+
+	// With sharing, the holder is identified and the lock is released.
+	d.Share = true
+	dev, err := fs.openSerial(&d, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to open shared device: %v", err)
+	}
+	defer dev.Close()
+
+	if diff := cmp.Diff(1, unlocked); diff != "" {
+		t.Fatalf("unexpected unlocks (-want +got):\n%s", diff)
+	}
+
+	want := `"server": path: "/dev/ttyUSB0", baud: 115200, port busy (held by PID 42/minicom)`
+	if diff := cmp.Diff(want, dev.String()); diff != "" {
+		t.Fatalf("unexpected device (-want +got):\n%s", diff)
+	}
+}
+
+func Test_sharedDevice(t *testing.T) {
+	dev, remote := newPipeDevice()
+
+	busy := []*busyError{
+		{PID: 42, Program: "minicom"},
+		{PID: 42, Program: "minicom"},
+		{PID: 43, Program: "picocom"},
+	}
+
+	var opened int
+	sd := newSharedDevice(rawDevice{Name: "server"}, busy[0], func() (consrv.Device, error) {
+		opened++
+		if len(busy) > 0 {
+			b := busy[0]
+			busy = busy[1:]
+			return nil, b
+		}
+
+		return dev, nil
+	}, log.New(io.Discard, "", 0))
+	sd.min, sd.max = time.Millisecond, 2*time.Millisecond
+	defer sd.Close()
+
+	// Input can't be delivered while another process holds the port.
+	if _, err := sd.Write([]byte("hello")); err == nil {
+		t.Fatal("expected an error writing to a busy port")
+	}
+
+	go func() {
+		_, _ = io.WriteString(remote, "hello")
+	}()
+
+	b := make([]byte, 64)
+	n, err := sd.Read(b)
+	if err != nil {
+		t.Fatalf("failed to read: %v", err)
+	}
+
+	if diff := cmp.Diff("hello", string(b[:n])); diff != "" {
+		t.Fatalf("unexpected read (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(4, opened); diff != "" {
+		t.Fatalf("unexpected open attempts (-want +got):\n%s", diff)
+	}
+	if !sd.connected() {
+		t.Fatal("shared device should be connected")
+	}
+}
+
+// A closerFunc adapts a function into an io.Closer.
+type closerFunc func() error
+
+func (fn closerFunc) Close() error { return fn() }
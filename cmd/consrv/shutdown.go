@@ -0,0 +1,87 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// drainTimeout is how long clients have to end their sessions after consrv is
+// asked to stop, before their connections are closed.
+const drainTimeout = 5 * time.Second
+
+// A shutdown stops consrv gracefully when asked to, such as when gokrazy stops
+// the process with SIGTERM before an update, or when a client requests
+// /quitquitquit as with other gokrazy daemons.
+type shutdown struct {
+	prepare func(ctx context.Context)
+	exit    func(code int)
+	timeout time.Duration
+	ll      *log.Logger
+	once    sync.Once
+}
+
+// newShutdown creates a shutdown which calls prepare with a context canceled
+// after drainTimeout before the process exits.
+func newShutdown(prepare func(ctx context.Context), ll *log.Logger) *shutdown {
+	return &shutdown{
+		prepare: prepare,
+		exit:    os.Exit,
+		timeout: drainTimeout,
+		ll:      ll,
+	}
+}
+
+// run stops consrv when the process receives SIGTERM or SIGINT.
+func (s *shutdown) run() {
+	sigC := make(chan os.Signal, 1)
+	signal.Notify(sigC, syscall.SIGTERM, os.Interrupt)
+
+	s.stop(fmt.Sprintf("received %s", <-sigC))
+}
+
+// stop prepares for and then exits the process. Concurrent calls block until
+// the process exits.
+func (s *shutdown) stop(reason string) {
+	s.once.Do(func() {
+		s.ll.Printf("stopping: %s, draining sessions for up to %s", reason, s.timeout)
+
+		ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+		defer cancel()
+		s.prepare(ctx)
+
+		s.ll.Println("stopped")
+		s.exit(0)
+	})
+}
+
+// ServeHTTP implements the /quitquitquit endpoint.
+func (s *shutdown) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = w.Write([]byte("ok\n"))
+
+	// Respond before the debug HTTP server is stopped along with the process.
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+	go s.stop("received /quitquitquit")
+}
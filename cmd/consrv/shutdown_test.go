@@ -0,0 +1,71 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_shutdownServeHTTP(t *testing.T) {
+	var (
+		prepared int
+		deadline bool
+		exitC    = make(chan int, 1)
+	)
+
+	sd := newShutdown(func(ctx context.Context) {
+		prepared++
+		_, deadline = ctx.Deadline()
+	}, log.New(io.Discard, "", 0))
+	sd.exit = func(code int) { exitC <- code }
+
+	srv := httptest.NewServer(sd)
+	defer srv.Close()
+
+	res, err := http.Post(srv.URL, "", nil)
+	if err != nil {
+		t.Fatalf("failed to request quit: %v", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status: %d", res.StatusCode)
+	}
+
+	select {
+	case code := <-exitC:
+		if diff := cmp.Diff(0, code); diff != "" {
+			t.Fatalf("unexpected exit code (-want +got):\n%s", diff)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for exit")
+	}
+
+	// Later requests to stop have no effect.
+	sd.stop("test")
+	if diff := cmp.Diff(1, prepared); diff != "" {
+		t.Fatalf("unexpected prepare calls (-want +got):\n%s", diff)
+	}
+	if !deadline {
+		t.Fatal("expected prepare's context to have a deadline")
+	}
+}
@@ -0,0 +1,243 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// standbyConfig contains the configuration for a hot-standby pair of consrv
+// hosts which share network-attached devices.
+type standbyConfig struct {
+	Address  string   `toml:"address"`
+	Peer     string   `toml:"peer"`
+	Primary  bool     `toml:"primary"`
+	Interval duration `toml:"interval"`
+	Timeout  duration `toml:"timeout"`
+}
+
+// Default lease timing for a hot-standby pair.
+const (
+	defaultStandbyInterval = 1 * time.Second
+	defaultStandbyTimeout  = 5 * time.Second
+)
+
+// validate verifies the hot-standby configuration and applies defaults.
+func (sc *standbyConfig) validate() error {
+	for _, addr := range []string{sc.Address, sc.Peer} {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return fmt.Errorf("standby must have a valid address and peer: %v", err)
+		}
+	}
+
+	if sc.Interval.Duration == 0 {
+		sc.Interval.Duration = defaultStandbyInterval
+	}
+	if sc.Timeout.Duration == 0 {
+		sc.Timeout.Duration = defaultStandbyTimeout
+	}
+	if sc.Interval.Duration < 0 || sc.Timeout.Duration <= sc.Interval.Duration {
+		return errors.New("standby timeout must be greater than its interval")
+	}
+
+	return nil
+}
+
+// The lease states reported to a peer.
+const (
+	leaseActive  = "active"
+	leaseStandby = "standby"
+)
+
+// A lease coordinates which host of a hot-standby pair attaches to the shared
+// network-attached devices. Each host reports whether it holds the lease to
+// its peer, and a host acquires the lease when its peer does not hold it or
+// stops responding. If both hosts hold the lease, such as after a network
+// partition heals, the primary keeps it.
+type lease struct {
+	primary           bool
+	peer              string
+	interval, timeout time.Duration
+	lv                *logLevel
+	ll                *log.Logger
+
+	mu        sync.Mutex
+	active    bool
+	contact   time.Time
+	changed   chan struct{}
+	onRelease []func()
+}
+
+// newLease creates a lease from the hot-standby configuration.
+func newLease(sc standbyConfig, lv *logLevel, ll *log.Logger) *lease {
+	return &lease{
+		primary:  sc.Primary,
+		peer:     sc.Peer,
+		interval: sc.Interval.Duration,
+		timeout:  sc.Timeout.Duration,
+		lv:       lv,
+		ll:       ll,
+		// Give the peer a chance to respond before acquiring the lease.
+		contact: time.Now(),
+		changed: make(chan struct{}),
+	}
+}
+
+// serve reports the state of the lease to each peer connection accepted on ln
+// until ln is closed.
+func (l *lease) serve(ln net.Listener) error {
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+
+			return err
+		}
+
+		state := leaseStandby
+		if l.held() {
+			state = leaseActive
+		}
+
+		_ = c.SetWriteDeadline(time.Now().Add(l.interval))
+		_, _ = io.WriteString(c, state+"\n")
+		_ = c.Close()
+	}
+}
+
+// run polls the peer until the process exits, updating the lease each time.
+func (l *lease) run() {
+	l.ll.Printf("starting hot-standby lease with peer %q [primary: %t]", l.peer, l.primary)
+
+	t := time.NewTicker(l.interval)
+	defer t.Stop()
+
+	for range t.C {
+		active, err := l.poll()
+		if err != nil {
+			errorf(l.ll, "failed to contact hot-standby peer %q: %v", l.peer, err)
+		} else {
+			l.lv.debugf(l.ll, "hot-standby peer %q is active: %t", l.peer, active)
+		}
+
+		l.step(time.Now(), err == nil, active)
+	}
+}
+
+// poll reports whether the peer holds the lease.
+func (l *lease) poll() (bool, error) {
+	c, err := net.DialTimeout("tcp", l.peer, l.interval)
+	if err != nil {
+		return false, err
+	}
+	defer c.Close()
+
+	_ = c.SetReadDeadline(time.Now().Add(l.interval))
+	s, err := bufio.NewReader(c).ReadString('\n')
+	if err != nil {
+		return false, err
+	}
+
+	switch s = strings.TrimSpace(s); s {
+	case leaseActive:
+		return true, nil
+	case leaseStandby:
+		return false, nil
+	default:
+		return false, fmt.Errorf("unexpected lease state %q", s)
+	}
+}
+
+// step updates the lease after polling the peer at now, where ok reports
+// whether the peer responded and peerActive whether it holds the lease.
+func (l *lease) step(now time.Time, ok, peerActive bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if ok {
+		l.contact = now
+	}
+
+	switch {
+	case !ok && !l.active && now.Sub(l.contact) >= l.timeout:
+		l.set(true, "peer is unreachable")
+	case ok && !peerActive && !l.active && l.primary:
+		l.set(true, "peer is on standby")
+	case ok && peerActive && l.active && !l.primary:
+		l.set(false, "peer is active")
+	}
+}
+
+// set updates the state of the lease for reason. l.mu must be held.
+func (l *lease) set(active bool, reason string) {
+	l.active = active
+	close(l.changed)
+	l.changed = make(chan struct{})
+
+	if active {
+		l.ll.Printf("acquired hot-standby lease: %s", reason)
+		return
+	}
+
+	l.ll.Printf("released hot-standby lease: %s", reason)
+	for _, fn := range l.onRelease {
+		go fn()
+	}
+}
+
+// held reports whether the lease is held.
+func (l *lease) held() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.active
+}
+
+// wait blocks until the lease is held, and reports whether it is held or done
+// was closed first.
+func (l *lease) wait(done <-chan struct{}) bool {
+	for {
+		l.mu.Lock()
+		active, changed := l.active, l.changed
+		l.mu.Unlock()
+
+		if active {
+			return true
+		}
+
+		select {
+		case <-changed:
+		case <-done:
+			return false
+		}
+	}
+}
+
+// release registers fn to be called each time the lease is released.
+func (l *lease) release(fn func()) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.onRelease = append(l.onRelease, fn)
+}
@@ -0,0 +1,220 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io"
+	"log"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mdlayher/metricslite"
+)
+
+func Test_leaseStep(t *testing.T) {
+	type poll struct {
+		after          time.Duration
+		ok, peerActive bool
+	}
+
+	tests := []struct {
+		name    string
+		primary bool
+		polls   []poll
+		want    []bool
+	}{
+		{
+			name:    "primary peer standby",
+			primary: true,
+			polls:   []poll{{after: time.Second, ok: true}},
+			want:    []bool{true},
+		},
+		{
+			name:  "standby peer standby",
+			polls: []poll{{after: time.Second, ok: true}},
+			want:  []bool{false},
+		},
+		{
+			name: "standby peer active",
+			polls: []poll{
+				{after: time.Second, ok: true, peerActive: true},
+				{after: time.Second, ok: true, peerActive: true},
+			},
+			want: []bool{false, false},
+		},
+		{
+			name: "standby failover",
+			polls: []poll{
+				{after: time.Second, ok: true, peerActive: true},
+				{after: time.Second},
+				{after: 3 * time.Second},
+				{after: time.Second},
+			},
+			want: []bool{false, false, false, true},
+		},
+		{
+			name:    "primary unreachable peer",
+			primary: true,
+			polls: []poll{
+				{after: time.Second},
+				{after: 4 * time.Second},
+			},
+			want: []bool{false, true},
+		},
+		{
+			name:    "primary doesn't preempt",
+			primary: true,
+			polls: []poll{
+				{after: time.Second, ok: true, peerActive: true},
+			},
+			want: []bool{false},
+		},
+		{
+			name: "both active",
+			polls: []poll{
+				{after: 5 * time.Second},
+				{after: time.Second, ok: true, peerActive: true},
+			},
+			want: []bool{true, false},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Unix(0, 0)
+			l := newLease(standbyConfig{
+				Primary:  tt.primary,
+				Interval: duration{time.Second},
+				Timeout:  duration{5 * time.Second},
+			}, newLogLevel(), log.New(io.Discard, "", 0))
+			l.contact = now
+
+			var got []bool
+			for _, p := range tt.polls {
+				now = now.Add(p.after)
+				l.step(now, p.ok, p.peerActive)
+				got = append(got, l.held())
+			}
+
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Fatalf("unexpected lease states (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestLeasePoll(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer l.Close()
+
+	cfg := standbyConfig{
+		Peer:     l.Addr().String(),
+		Primary:  true,
+		Interval: duration{time.Second},
+		Timeout:  duration{5 * time.Second},
+	}
+
+	a := newLease(cfg, newLogLevel(), log.New(io.Discard, "", 0))
+	b := newLease(cfg, newLogLevel(), log.New(io.Discard, "", 0))
+	go func() { _ = a.serve(l) }()
+
+	var got []bool
+	for i := 0; i < 2; i++ {
+		active, err := b.poll()
+		if err != nil {
+			t.Fatalf("failed to poll: %v", err)
+		}
+		got = append(got, active)
+
+		a.step(time.Now(), true, false)
+	}
+
+	if diff := cmp.Diff([]bool{false, true}, got); diff != "" {
+		t.Fatalf("unexpected peer states (-want +got):\n%s", diff)
+	}
+}
+
+func TestTCPDeviceLease(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer l.Close()
+
+	// The terminal server writes a message on each connection.
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			_, _ = io.WriteString(c, "login: ")
+			defer c.Close()
+		}
+	}()
+
+	ls := newLease(standbyConfig{
+		Primary:  true,
+		Interval: duration{time.Second},
+		Timeout:  duration{5 * time.Second},
+	}, newLogLevel(), log.New(io.Discard, "", 0))
+
+	c := metricslite.Discard().Counter("bytes", "")
+	d := newTCPDevice(rawDevice{Name: "server", Address: l.Addr().String()}, ls, c, c, log.New(io.Discard, "", 0))
+	defer d.Close()
+
+	if _, err := d.Write([]byte("root\n")); err == nil {
+		t.Fatal("expected an error writing without the lease, but none occurred")
+	}
+
+	readC := make(chan string)
+	go func() {
+		b := make([]byte, 64)
+		n, _ := d.Read(b)
+		readC <- string(b[:n])
+	}()
+
+	// The device doesn't connect until the lease is held.
+	select {
+	case s := <-readC:
+		t.Fatalf("read %q without the lease", s)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	ls.step(time.Now(), true, false)
+
+	if diff := cmp.Diff("login: ", <-readC); diff != "" {
+		t.Fatalf("unexpected output (-want +got):\n%s", diff)
+	}
+
+	// Closing the device unblocks a read waiting for the lease.
+	ls.mu.Lock()
+	ls.set(false, "test")
+	ls.mu.Unlock()
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		_ = d.Close()
+	}()
+
+	if _, err := d.Read(make([]byte, 64)); err != io.EOF {
+		t.Fatalf("expected EOF after close, but got: %v", err)
+	}
+}
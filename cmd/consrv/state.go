@@ -0,0 +1,169 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/mdlayher/consrv"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// A stateHandler serves a snapshot of consrv's runtime state as JSON, and
+// restores the reservations in a snapshot taken before a restart, such as
+// during an upgrade:
+//
+//	GET /state
+//	PUT /state
+type stateHandler struct {
+	hash         string
+	paths        map[string]string
+	sessions     func(device string) int
+	holder       func(device string) (consrv.Holder, bool)
+	reservations func() []consrv.Reservation
+	restore      func(rs []consrv.Reservation) int
+	g            prometheus.Gatherer
+	now          func() time.Time
+	ll           *log.Logger
+}
+
+// A snapshot is the JSON representation of consrv's runtime state.
+type snapshot struct {
+	Time         time.Time             `json:"time"`
+	ConfigSHA256 string                `json:"config_sha256"`
+	Devices      []snapshotDevice      `json:"devices"`
+	Reservations []snapshotReservation `json:"reservations"`
+	Counters     map[string]jsonVar    `json:"counters"`
+}
+
+// A snapshotDevice is the state of a device in a snapshot.
+type snapshotDevice struct {
+	Name     string        `json:"name"`
+	Path     string        `json:"path"`
+	Sessions int           `json:"sessions"`
+	Holder   *deviceHolder `json:"holder,omitempty"`
+}
+
+// A snapshotReservation is a consrv.Reservation in a snapshot, including the
+// duration requested by each queued identity.
+type snapshotReservation struct {
+	Device   string           `json:"device"`
+	Identity string           `json:"identity"`
+	Until    time.Time        `json:"until"`
+	Queue    []snapshotQueued `json:"queue"`
+}
+
+// A snapshotQueued is an identity waiting to reserve a device.
+type snapshotQueued struct {
+	Identity string   `json:"identity"`
+	Duration duration `json:"duration"`
+}
+
+// ServeHTTP implements http.Handler.
+func (sh *stateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		s, err := sh.snapshot()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(s)
+		return
+	}
+
+	var s snapshot
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&s); err != nil {
+		http.Error(w, fmt.Sprintf("invalid snapshot: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	rs := make([]consrv.Reservation, 0, len(s.Reservations))
+	for _, sr := range s.Reservations {
+		cr := consrv.Reservation{
+			Device:   sr.Device,
+			Identity: sr.Identity,
+			Until:    sr.Until,
+		}
+		for _, q := range sr.Queue {
+			cr.Queue = append(cr.Queue, q.Identity)
+			cr.Durations = append(cr.Durations, q.Duration.Duration)
+		}
+
+		rs = append(rs, cr)
+	}
+
+	n := sh.restore(rs)
+	sh.ll.Printf("restored %d of %d reservations from snapshot taken at %s", n, len(rs), s.Time.Format(time.RFC3339))
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(struct {
+		Restored int `json:"restored"`
+	}{Restored: n})
+}
+
+// snapshot captures the current runtime state.
+func (sh *stateHandler) snapshot() (snapshot, error) {
+	counters, err := gatherVars(sh.g)
+	if err != nil {
+		return snapshot{}, err
+	}
+
+	s := snapshot{
+		Time:         sh.now(),
+		ConfigSHA256: sh.hash,
+		Devices:      make([]snapshotDevice, 0, len(sh.paths)),
+		Reservations: make([]snapshotReservation, 0),
+		Counters:     counters,
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(sh.paths)) {
+		d := snapshotDevice{
+			Name:     name,
+			Path:     sh.paths[name],
+			Sessions: sh.sessions(name),
+		}
+		if h, ok := sh.holder(name); ok {
+			d.Holder = &deviceHolder{Identity: h.Identity, Source: h.Source}
+		}
+
+		s.Devices = append(s.Devices, d)
+	}
+
+	for _, r := range sh.reservations() {
+		sr := snapshotReservation{
+			Device:   r.Device,
+			Identity: r.Identity,
+			Until:    r.Until,
+			Queue:    make([]snapshotQueued, 0, len(r.Queue)),
+		}
+		for i, id := range r.Queue {
+			sr.Queue = append(sr.Queue, snapshotQueued{
+				Identity: id,
+				Duration: duration{r.Durations[i]},
+			})
+		}
+
+		s.Reservations = append(s.Reservations, sr)
+	}
+
+	return s, nil
+}
@@ -0,0 +1,106 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mdlayher/consrv"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func Test_stateHandler(t *testing.T) {
+	now := time.Date(2024, time.March, 5, 14, 0, 0, 0, time.UTC)
+
+	reg := prometheus.NewPedanticRegistry()
+	c := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "consrv_test_total",
+		Help: "A test counter.",
+	})
+	reg.MustRegister(c)
+	c.Add(2)
+
+	var restored []consrv.Reservation
+	sh := &stateHandler{
+		hash:  "abc123",
+		paths: map[string]string{"server": "/dev/ttyUSB0", "switch": "192.0.2.1:2000"},
+		sessions: func(device string) int {
+			if device == "server" {
+				return 1
+			}
+			return 0
+		},
+		holder: func(device string) (consrv.Holder, bool) {
+			if device == "server" {
+				return consrv.Holder{Identity: "alice", Source: consrv.HolderReservation}, true
+			}
+			return consrv.Holder{}, false
+		},
+		reservations: func() []consrv.Reservation {
+			return []consrv.Reservation{{
+				Device:    "server",
+				Identity:  "alice",
+				Until:     now.Add(1 * time.Hour),
+				Queue:     []string{"bob"},
+				Durations: []time.Duration{30 * time.Minute},
+			}}
+		},
+		restore: func(rs []consrv.Reservation) int {
+			restored = rs
+			return len(rs)
+		},
+		g:   reg,
+		now: func() time.Time { return now },
+		ll:  log.New(io.Discard, "", 0),
+	}
+
+	w := httptest.NewRecorder()
+	sh.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/state", nil))
+
+	const snap = `{"time":"2024-03-05T14:00:00Z","config_sha256":"abc123","devices":[` +
+		`{"name":"server","path":"/dev/ttyUSB0","sessions":1,"holder":{"identity":"alice","source":"reservation"}},` +
+		`{"name":"switch","path":"192.0.2.1:2000","sessions":0}],` +
+		`"reservations":[{"device":"server","identity":"alice","until":"2024-03-05T15:00:00Z",` +
+		`"queue":[{"identity":"bob","duration":"30m0s"}]}],` +
+		`"counters":{"consrv_test_total":{"type":"counter","help":"A test counter.","values":[{"value":2}]}}}` + "\n"
+
+	if diff := cmp.Diff(snap, w.Body.String()); diff != "" {
+		t.Fatalf("unexpected snapshot (-want +got):\n%s", diff)
+	}
+
+	// Restoring the snapshot restores its reservations.
+	w = httptest.NewRecorder()
+	sh.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/state", strings.NewReader(snap)))
+
+	if diff := cmp.Diff(`{"restored":1}`+"\n", w.Body.String()); diff != "" {
+		t.Fatalf("unexpected restore response (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(sh.reservations(), restored); diff != "" {
+		t.Fatalf("unexpected restored reservations (-want +got):\n%s", diff)
+	}
+
+	w = httptest.NewRecorder()
+	sh.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/state", strings.NewReader("{")))
+
+	if diff := cmp.Diff(http.StatusBadRequest, w.Code); diff != "" {
+		t.Fatalf("unexpected status code (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// statsInterval is the interval at which persistent statistics are saved.
+const statsInterval = 1 * time.Minute
+
+// stats contains per-device usage statistics which are persisted to disk so
+// they survive consrv restarts.
+type stats struct {
+	path string
+
+	mu sync.Mutex
+	f  statsFile
+}
+
+// statsFile is the on-disk JSON representation of stats.
+type statsFile struct {
+	Restarts uint64                  `json:"restarts"`
+	Devices  map[string]*deviceStats `json:"devices"`
+}
+
+// deviceStats contains the usage statistics for a single device.
+type deviceStats struct {
+	ReadBytes  uint64 `json:"read_bytes"`
+	WriteBytes uint64 `json:"write_bytes"`
+	Sessions   uint64 `json:"sessions"`
+}
+
+// loadStats loads statistics from path, or creates empty statistics if path
+// does not exist. Each call to loadStats is counted as a restart, and the
+// updated restart count is saved immediately so a crash before the first
+// periodic save still records it.
+func loadStats(path string) (*stats, error) {
+	s := &stats{
+		path: path,
+		f:    statsFile{Devices: make(map[string]*deviceStats)},
+	}
+
+	b, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		// First run, nothing to load.
+	case err != nil:
+		return nil, err
+	default:
+		if err := json.Unmarshal(b, &s.f); err != nil {
+			return nil, fmt.Errorf("failed to parse statistics file: %v", err)
+		}
+		if s.f.Devices == nil {
+			s.f.Devices = make(map[string]*deviceStats)
+		}
+	}
+
+	s.f.Restarts++
+	if err := s.save(); err != nil {
+		return nil, fmt.Errorf("failed to save statistics: %v", err)
+	}
+
+	return s, nil
+}
+
+// device returns a copy of the statistics for the named device.
+func (s *stats) device(name string) deviceStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if ds, ok := s.f.Devices[name]; ok {
+		return *ds
+	}
+
+	return deviceStats{}
+}
+
+// restarts returns the number of times consrv has been started.
+func (s *stats) restarts() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.f.Restarts
+}
+
+// update applies fn to the statistics for the named device.
+func (s *stats) update(name string, fn func(ds *deviceStats)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ds, ok := s.f.Devices[name]
+	if !ok {
+		ds = &deviceStats{}
+		s.f.Devices[name] = ds
+	}
+
+	fn(ds)
+}
+
+// save atomically writes the current statistics to disk.
+func (s *stats) save() error {
+	s.mu.Lock()
+	b, err := json.MarshalIndent(s.f, "", "\t")
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(s.path, b)
+}
+
+// run saves statistics to disk every interval. It never returns.
+func (s *stats) run(interval time.Duration, ll *log.Logger) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for range t.C {
+		if err := s.save(); err != nil {
+			errorf(ll, "failed to save statistics: %v", err)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mdlayher/metricslite"
+)
+
+func Test_stats(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stats.json")
+
+	// Simulate two runs of consrv which each record some device activity and
+	// save their statistics.
+	for i := 0; i < 2; i++ {
+		st, err := loadStats(path)
+		if err != nil {
+			t.Fatalf("failed to load stats: %v", err)
+		}
+
+		mi := persist(metricslite.NewMemory(), st, []string{"foo"})
+		mm := newMetrics(mi)
+
+		// The sessions counter is normally created by consrv.Server.
+		sessions := mi.Counter("consrv_device_sessions_total", "sessions", "name")
+
+		mm.deviceReadBytes(10, "foo")
+		mm.deviceWriteBytes(2, "foo")
+		sessions(1, "foo")
+
+		if err := st.save(); err != nil {
+			t.Fatalf("failed to save stats: %v", err)
+		}
+	}
+
+	st, err := loadStats(path)
+	if err != nil {
+		t.Fatalf("failed to load stats: %v", err)
+	}
+
+	want := deviceStats{
+		ReadBytes:  20,
+		WriteBytes: 4,
+		Sessions:   2,
+	}
+
+	if diff := cmp.Diff(want, st.device("foo")); diff != "" {
+		t.Fatalf("unexpected device stats (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(uint64(3), st.restarts()); diff != "" {
+		t.Fatalf("unexpected restarts (-want +got):\n%s", diff)
+	}
+}
+
+func Test_statsRestartsSavedOnLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stats.json")
+
+	// Never call save explicitly: each load must persist its own restart.
+	for i := 0; i < 3; i++ {
+		if _, err := loadStats(path); err != nil {
+			t.Fatalf("failed to load stats: %v", err)
+		}
+	}
+
+	st, err := loadStats(path)
+	if err != nil {
+		t.Fatalf("failed to load stats: %v", err)
+	}
+
+	if diff := cmp.Diff(uint64(4), st.restarts()); diff != "" {
+		t.Fatalf("unexpected restarts (-want +got):\n%s", diff)
+	}
+}
+
+func Test_persist(t *testing.T) {
+	st, err := loadStats(filepath.Join(t.TempDir(), "stats.json"))
+	if err != nil {
+		t.Fatalf("failed to load stats: %v", err)
+	}
+	st.update("foo", func(ds *deviceStats) {
+		ds.ReadBytes = 100
+		ds.WriteBytes = 10
+		ds.Sessions = 1
+	})
+
+	// The persisted statistics must be used as the starting point for each
+	// device counter.
+	mem := metricslite.NewMemory()
+	mi := persist(mem, st, []string{"foo"})
+	_ = mi.Counter("consrv_device_sessions_total", "sessions", "name")
+
+	mm := newMetrics(mi)
+	mm.deviceReadBytes(1, "foo")
+
+	want := map[string]float64{
+		"consrv_device_read_bytes_total":  101,
+		"consrv_device_write_bytes_total": 10,
+		"consrv_device_sessions_total":    1,
+	}
+
+	series := mem.Series()
+	for name, v := range want {
+		var got float64
+		for _, s := range series[name].Samples {
+			got += s
+		}
+
+		if diff := cmp.Diff(v, got); diff != "" {
+			t.Fatalf("unexpected value for %q (-want +got):\n%s", name, diff)
+		}
+	}
+}
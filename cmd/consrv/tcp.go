@@ -0,0 +1,185 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/mdlayher/consrv"
+	"github.com/mdlayher/metricslite"
+)
+
+var _ consrv.Device = &tcpDevice{}
+
+// A tcpDevice is a consrv.Device for a serial port exposed as a raw TCP port by
+// a terminal server. The connection is reestablished whenever it breaks, so
+// reads block rather than failing while the terminal server is unavailable.
+// If the device has a lease, it is only connected while the lease is held.
+type tcpDevice struct {
+	name, addr    string
+	lease         *lease
+	reads, writes metricslite.Counter
+	ll            *log.Logger
+
+	done chan struct{}
+
+	mu     sync.Mutex
+	closed bool
+	c      net.Conn
+}
+
+// newTCPDevice creates a tcpDevice for device d. If l is not nil, the device
+// is only connected while l is held.
+func newTCPDevice(d rawDevice, l *lease, reads, writes metricslite.Counter, ll *log.Logger) *tcpDevice {
+	td := &tcpDevice{
+		name:   d.Name,
+		addr:   d.Address,
+		lease:  l,
+		reads:  reads,
+		writes: writes,
+		ll:     ll,
+		done:   make(chan struct{}),
+	}
+
+	if l != nil {
+		l.release(func() { td.disconnect(errors.New("released lease")) })
+	}
+
+	return td
+}
+
+// Close implements io.ReadWriteCloser.
+func (d *tcpDevice) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.closed {
+		return nil
+	}
+
+	d.closed = true
+	close(d.done)
+	if d.c != nil {
+		return d.c.Close()
+	}
+
+	return nil
+}
+
+// Read implements io.ReadWriteCloser.
+func (d *tcpDevice) Read(b []byte) (int, error) {
+	for {
+		if d.lease != nil && !d.lease.wait(d.done) {
+			return 0, io.EOF
+		}
+
+		c, err := d.connect()
+		if errors.Is(err, errClosed) {
+			return 0, io.EOF
+		}
+		if err != nil {
+			errorf(d.ll, "%s: failed to connect to %q: %v", d.name, d.addr, err)
+			time.Sleep(1 * time.Second)
+			continue
+		}
+
+		n, err := c.Read(b)
+		d.reads(float64(n), d.name)
+		if err == nil {
+			return n, nil
+		}
+
+		d.disconnect(err)
+		if n > 0 {
+			return n, nil
+		}
+
+		// Don't reconnect in a tight loop if the terminal server closes the
+		// connection immediately.
+		time.Sleep(1 * time.Second)
+	}
+}
+
+// Write implements io.ReadWriteCloser.
+func (d *tcpDevice) Write(b []byte) (int, error) {
+	d.mu.Lock()
+	c := d.c
+	d.mu.Unlock()
+
+	if c == nil {
+		return 0, fmt.Errorf("device %q is not connected", d.name)
+	}
+
+	n, err := c.Write(b)
+	d.writes(float64(n), d.name)
+	return n, err
+}
+
+// connected implements connector.
+func (d *tcpDevice) connected() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.c != nil
+}
+
+// String returns the string representation of a tcpDevice.
+func (d *tcpDevice) String() string {
+	return fmt.Sprintf("%q: address: %q, standby: %t", d.name, d.addr, d.lease != nil)
+}
+
+// connect returns the current connection, dialing a new connection if
+// necessary.
+func (d *tcpDevice) connect() (net.Conn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.closed {
+		return nil, errClosed
+	}
+	if d.c != nil {
+		return d.c, nil
+	}
+
+	c, err := net.DialTimeout("tcp", d.addr, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	d.ll.Printf("%s: connected to %q", d.name, d.addr)
+	d.c = c
+	return c, nil
+}
+
+// disconnect closes the current connection because of err.
+func (d *tcpDevice) disconnect(err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.c == nil {
+		return
+	}
+	if !d.closed {
+		errorf(d.ll, "%s: disconnected from %q: %v", d.name, d.addr, err)
+	}
+
+	_ = d.c.Close()
+	d.c = nil
+}
@@ -0,0 +1,249 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/mdlayher/consrv"
+	"github.com/mdlayher/metricslite"
+)
+
+// teeConfig contains the configuration for a device's command output sink.
+type teeConfig struct {
+	Command       []string `toml:"command"`
+	Compression   string   `toml:"compression"`
+	FlushInterval duration `toml:"flush_interval"`
+	BatchSize     int      `toml:"batch_size"`
+}
+
+// teeBatchSize is the default number of bytes batched before output is
+// flushed to a tee command when a flush interval is set.
+const teeBatchSize = 64 * 1024
+
+// validate verifies the tee configuration for device.
+func (tc *teeConfig) validate(device string) error {
+	if len(tc.Command) == 0 || tc.Command[0] == "" {
+		return fmt.Errorf("device %q tee must have a command", device)
+	}
+
+	switch tc.Compression {
+	case compressNone, compressGzip, compressZstd:
+	default:
+		return fmt.Errorf("device %q tee has unknown compression %q", device, tc.Compression)
+	}
+
+	if tc.FlushInterval.Duration < 0 {
+		return fmt.Errorf("device %q tee flush interval must not be negative", device)
+	}
+	if tc.BatchSize < 0 {
+		return fmt.Errorf("device %q tee batch size must not be negative", device)
+	}
+	if tc.BatchSize == 0 {
+		tc.BatchSize = teeBatchSize
+	}
+
+	return nil
+}
+
+const (
+	// The minimum and maximum delays between restarts of a tee command. The
+	// delay doubles each time the command exits before the maximum delay
+	// elapses, and is reset otherwise.
+	teeMinRestart = 1 * time.Second
+	teeMaxRestart = 1 * time.Minute
+)
+
+// A tee copies a device's output to the stdin of a command, which is restarted
+// whenever it exits. Output is dropped rather than blocking the device when
+// the command does not keep up or is not running, or when the buffer budget is
+// exhausted.
+type tee struct {
+	name   string
+	args   []string
+	rd     redactor
+	budget *bufferBudget
+	drops  metricslite.Counter
+	ll     *log.Logger
+
+	chunks chan []byte
+
+	// Output is compressed and batched for up to flush before it is written
+	// to the command, or written immediately when flush is zero.
+	compression string
+	flush       time.Duration
+	batch       int
+
+	// The range of delays between restarts.
+	minRestart, maxRestart time.Duration
+}
+
+// newTee creates a tee for device d from its configuration.
+func newTee(d rawDevice, qc queueConfig, drops metricslite.Counter, ll *log.Logger) *tee {
+	return &tee{
+		name:        d.Name,
+		args:        d.Tee.Command,
+		rd:          newRedactor(d.Redact),
+		budget:      qc.budget,
+		drops:       drops,
+		ll:          ll,
+		chunks:      make(chan []byte, qc.length),
+		compression: d.Tee.Compression,
+		flush:       d.Tee.FlushInterval.Duration,
+		batch:       d.Tee.BatchSize,
+		minRestart:  teeMinRestart,
+		maxRestart:  teeMaxRestart,
+	}
+}
+
+// run copies output from the mux to the command until the process exits,
+// restarting the copy if it stops.
+func (t *tee) run(mux *consrv.MuxDevice) {
+	go t.supervise()
+
+	for {
+		ctx, cancel := context.WithCancel(context.Background())
+		if err := t.copy(mux.Attach(ctx)); err != nil {
+			errorf(t.ll, "tee for %q: %v", t.name, err)
+		}
+		cancel()
+
+		t.ll.Printf("restarting tee for %q", t.name)
+		time.Sleep(1 * time.Second)
+	}
+}
+
+// copy buffers output from r for the command until r returns an error.
+func (t *tee) copy(r io.Reader) error {
+	b := make([]byte, 4096)
+	for {
+		n, err := r.Read(b)
+		if n > 0 {
+			// The read buffer is reused, so each chunk must be copied unless
+			// redaction already produced a new slice.
+			chunk := t.rd.redact(append([]byte(nil), b[:n]...))
+
+			if !t.budget.acquire(len(chunk)) {
+				t.drops(float64(len(chunk)), t.name)
+				continue
+			}
+
+			select {
+			case t.chunks <- chunk:
+			default:
+				// Never block the device's output.
+				t.budget.release(len(chunk))
+				t.drops(float64(len(chunk)), t.name)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// supervise runs the command until the process exits, restarting it with a
+// growing delay each time it exits quickly.
+func (t *tee) supervise() {
+	delay := t.minRestart
+	for {
+		start := time.Now()
+		if err := t.exec(); err != nil {
+			errorf(t.ll, "tee for %q: command failed: %v", t.name, err)
+		} else {
+			t.ll.Printf("tee for %q: command exited", t.name)
+		}
+
+		if time.Since(start) >= t.maxRestart {
+			delay = t.minRestart
+		}
+
+		t.ll.Printf("restarting tee command for %q in %s", t.name, delay)
+		time.Sleep(delay)
+		delay = min(delay*2, t.maxRestart)
+	}
+}
+
+// exec runs the command and writes buffered output to its stdin until it
+// exits.
+func (t *tee) exec() error {
+	cmd := exec.Command(t.args[0], t.args[1:]...)
+	cmd.Env = append(os.Environ(), "CONSRV_DEVICE="+t.name)
+	cmd.Stdout = &prefixWriter{prefix: t.name + ": tee: ", ll: t.ll}
+	cmd.Stderr = cmd.Stdout
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+
+	// Each run of the command receives a new compressed stream.
+	bw, err := newBatchWriter(stdin, t.compression, max(t.batch, 4096))
+	if err != nil {
+		return err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	// With no flush interval, each chunk is flushed as soon as it is written.
+	var tick <-chan time.Time
+	if t.flush > 0 {
+		ticker := time.NewTicker(t.flush)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		var err error
+		select {
+		case err := <-done:
+			return err
+		case <-tick:
+			err = bw.Flush()
+		case chunk := <-t.chunks:
+			t.budget.release(len(chunk))
+			if _, err = bw.Write(chunk); err == nil && (tick == nil || bw.Buffered() >= t.batch) {
+				err = bw.Flush()
+			}
+		}
+		if err != nil {
+			// The command is exiting, so report its exit status instead.
+			_ = stdin.Close()
+			return <-done
+		}
+	}
+}
+
+// A prefixWriter logs each write with a prefix.
+type prefixWriter struct {
+	prefix string
+	ll     *log.Logger
+}
+
+// Write implements io.Writer.
+func (pw *prefixWriter) Write(b []byte) (int, error) {
+	pw.ll.Printf("%s%s", pw.prefix, b)
+	return len(b), nil
+}
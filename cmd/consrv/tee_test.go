@@ -0,0 +1,82 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_teeCopy(t *testing.T) {
+	var dropped int
+	tt := &tee{
+		name:   "server",
+		rd:     newRedactor([]redactRule{{Pattern: "hunter2"}}),
+		drops:  func(v float64, _ ...string) { dropped += int(v) },
+		ll:     log.New(io.Discard, "", 0),
+		chunks: make(chan []byte, 1),
+	}
+
+	r := &chunkReader{
+		chunks: []string{"password: hunter2\n", "dropped\n", "also dropped\n"},
+		read:   func() {},
+	}
+	if err := tt.copy(r); err != io.EOF {
+		t.Fatalf("failed to copy: %v", err)
+	}
+
+	if diff := cmp.Diff("password: [redacted]\n", string(<-tt.chunks)); diff != "" {
+		t.Fatalf("unexpected chunk (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(len("dropped\nalso dropped\n"), dropped); diff != "" {
+		t.Fatalf("unexpected dropped bytes (-want +got):\n%s", diff)
+	}
+}
+
+func Test_teeExec(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skipf("skipping, no shell: %v", err)
+	}
+
+	out := filepath.Join(t.TempDir(), "out")
+	tt := &tee{
+		name: "server",
+		// The command exits once it has consumed its input.
+		args:   []string{"sh", "-c", `printf '%s: ' "$CONSRV_DEVICE" > "$0"; head -c 11 >> "$0"`, out},
+		ll:     log.New(io.Discard, "", 0),
+		chunks: make(chan []byte, 2),
+	}
+
+	tt.chunks <- []byte("hello ")
+	tt.chunks <- []byte("world")
+
+	if err := tt.exec(); err != nil {
+		t.Fatalf("failed to exec: %v", err)
+	}
+
+	b, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("failed to read output: %v", err)
+	}
+
+	if diff := cmp.Diff("server: hello world", string(b)); diff != "" {
+		t.Fatalf("unexpected command output (-want +got):\n%s", diff)
+	}
+}
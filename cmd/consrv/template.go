@@ -0,0 +1,183 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"maps"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// isTemplate reports whether d is a device template which expands into
+// multiple devices.
+func (d rawDevice) isTemplate() bool {
+	return len(d.Serials) > 0 || len(d.USBPaths) > 0 || d.Auto
+}
+
+// templateName formats a device name from template name for the device at
+// 1-based index i.
+func templateName(name string, i int) string { return fmt.Sprintf(name, i) }
+
+// expandTemplates expands each device template with a list of serial numbers
+// or USB paths into one device per entry, named by formatting the template's
+// name with the 1-based index of the entry. Templates in auto mode are left
+// for fs.expand since the available devices are not known yet.
+func expandTemplates(devices []rawDevice) ([]rawDevice, error) {
+	var (
+		out  []rawDevice
+		auto bool
+	)
+
+	for _, d := range devices {
+		if !d.isTemplate() {
+			out = append(out, d)
+			continue
+		}
+
+		if d.Device != "" || d.Serial != "" || d.USBPath != "" || d.Address != "" {
+			return nil, fmt.Errorf("device template %q must not have a device path, serial, USB path, or address", d.Name)
+		}
+		if n := templateName(d.Name, 1); !strings.Contains(d.Name, "%") || strings.Contains(n, "%!") {
+			return nil, fmt.Errorf("device template %q must have a name with a single integer verb such as %%02d", d.Name)
+		}
+
+		var n int
+		for _, b := range []bool{len(d.Serials) > 0, len(d.USBPaths) > 0, d.Auto} {
+			if b {
+				n++
+			}
+		}
+		if n > 1 {
+			return nil, fmt.Errorf("device template %q must have only one of serials, USB paths, or auto", d.Name)
+		}
+
+		if d.Auto {
+			if auto {
+				return nil, fmt.Errorf("device template %q: only one device template may use auto", d.Name)
+			}
+
+			auto = true
+			out = append(out, d)
+			continue
+		}
+
+		var usbPaths []string
+		for _, p := range d.USBPaths {
+			ps, err := expandRange(p)
+			if err != nil {
+				return nil, fmt.Errorf("device template %q has invalid USB path %q: %v", d.Name, p, err)
+			}
+
+			usbPaths = append(usbPaths, ps...)
+		}
+
+		for i, s := range d.Serials {
+			td := d
+			td.Name, td.Serial, td.Serials = templateName(d.Name, i+1), s, nil
+			out = append(out, td)
+		}
+		for i, p := range usbPaths {
+			td := d
+			td.Name, td.USBPath, td.USBPaths = templateName(d.Name, i+1), p, nil
+			out = append(out, td)
+		}
+	}
+
+	return out, nil
+}
+
+// rangeRE matches a numeric range such as {1..8} or {01..32}.
+var rangeRE = regexp.MustCompile(`\{(\d+)\.\.(\d+)\}`)
+
+// expandRange expands the numeric range in s, if any, into one string for
+// each number in the range. Leading zeros in the start of the range set the
+// minimum width of each number.
+func expandRange(s string) ([]string, error) {
+	m := rangeRE.FindStringSubmatchIndex(s)
+	if m == nil {
+		return []string{s}, nil
+	}
+
+	var (
+		first, _ = strconv.Atoi(s[m[2]:m[3]])
+		last, _  = strconv.Atoi(s[m[4]:m[5]])
+		width    = len(s[m[2]:m[3]])
+	)
+	if last < first {
+		return nil, fmt.Errorf("range end %d is less than start %d", last, first)
+	}
+	if rangeRE.MatchString(s[m[1]:]) {
+		return nil, fmt.Errorf("only one range is allowed")
+	}
+
+	ss := make([]string, 0, last-first+1)
+	for i := first; i <= last; i++ {
+		ss = append(ss, fmt.Sprintf("%s%0*d%s", s[:m[0]], width, i, s[m[1]:]))
+	}
+
+	return ss, nil
+}
+
+// expand expands a device template in auto mode into one device for each
+// enumerated device which is not otherwise configured, in order of device
+// path.
+func (fs *fs) expand(devices []rawDevice, ll *log.Logger) []rawDevice {
+	var (
+		out   []rawDevice
+		names = make(map[string]bool)
+		used  = make(map[string]bool)
+		auto  *rawDevice
+	)
+
+	for _, d := range devices {
+		if d.Auto {
+			auto = &d
+			continue
+		}
+
+		names[d.Name] = true
+		out = append(out, d)
+		if err := fs.resolve(&d); err == nil && d.Device != "" {
+			used[d.Device] = true
+		}
+	}
+	if auto == nil {
+		return devices
+	}
+
+	// Every enumerated device has an entry in the metadata map.
+	var i int
+	for _, path := range slices.Sorted(maps.Keys(fs.metadata)) {
+		if used[path] {
+			continue
+		}
+
+		i++
+		d := *auto
+		d.Name, d.Device, d.Auto = templateName(auto.Name, i), path, false
+		if names[d.Name] {
+			ll.Printf("skipping automatic device %q for %q: name is already configured", d.Name, path)
+			continue
+		}
+
+		ll.Printf("automatically configured device %q for %q", d.Name, path)
+		out = append(out, d)
+	}
+
+	return out
+}
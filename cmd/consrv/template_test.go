@@ -0,0 +1,173 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io"
+	"log"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_expandTemplates(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []rawDevice
+		want []rawDevice
+		ok   bool
+	}{
+		{
+			name: "bad name",
+			in: []rawDevice{{
+				Name:    "node",
+				Serials: []string{"A", "B"},
+			}},
+		},
+		{
+			name: "bad name verb",
+			in: []rawDevice{{
+				Name:    "node%s",
+				Serials: []string{"A", "B"},
+			}},
+		},
+		{
+			name: "bad serial and template",
+			in: []rawDevice{{
+				Name:    "node%d",
+				Serial:  "A",
+				Serials: []string{"A", "B"},
+			}},
+		},
+		{
+			name: "bad serials and USB paths",
+			in: []rawDevice{{
+				Name:     "node%d",
+				Serials:  []string{"A"},
+				USBPaths: []string{"1-1"},
+			}},
+		},
+		{
+			name: "bad multiple auto",
+			in: []rawDevice{
+				{Name: "a%d", Auto: true},
+				{Name: "b%d", Auto: true},
+			},
+		},
+		{
+			name: "bad range",
+			in: []rawDevice{{
+				Name:     "node%d",
+				USBPaths: []string{"1-1.{4..1}"},
+			}},
+		},
+		{
+			name: "OK",
+			in: []rawDevice{
+				{
+					Name:   "server",
+					Device: "/dev/ttyUSB0",
+					Baud:   115200,
+				},
+				{
+					Name:    "node%02d",
+					Serials: []string{"A", "B"},
+					Baud:    115200,
+				},
+				{
+					Name:     "rack%d",
+					USBPaths: []string{"1-1.{1..3}"},
+					Baud:     9600,
+				},
+				{
+					Name: "port%d",
+					Auto: true,
+					Baud: 115200,
+				},
+			},
+			want: []rawDevice{
+				{Name: "server", Device: "/dev/ttyUSB0", Baud: 115200},
+				{Name: "node01", Serial: "A", Baud: 115200},
+				{Name: "node02", Serial: "B", Baud: 115200},
+				{Name: "rack1", USBPath: "1-1.1", Baud: 9600},
+				{Name: "rack2", USBPath: "1-1.2", Baud: 9600},
+				{Name: "rack3", USBPath: "1-1.3", Baud: 9600},
+				{Name: "port%d", Auto: true, Baud: 115200},
+			},
+			ok: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := expandTemplates(tt.in)
+			if tt.ok && err != nil {
+				t.Fatalf("failed to expand templates: %v", err)
+			}
+			if !tt.ok && err == nil {
+				t.Fatal("expected an error, but none occurred")
+			}
+
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Fatalf("unexpected devices (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_expandRange(t *testing.T) {
+	tests := []struct {
+		s    string
+		want []string
+	}{
+		{s: "1-1.2", want: []string{"1-1.2"}},
+		{s: "1-1.{1..3}", want: []string{"1-1.1", "1-1.2", "1-1.3"}},
+		{s: "{08..10}-1", want: []string{"08-1", "09-1", "10-1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			got, err := expandRange(tt.s)
+			if err != nil {
+				t.Fatalf("failed to expand range: %v", err)
+			}
+
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Fatalf("unexpected strings (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_fsExpand(t *testing.T) {
+	fs := testFS()
+	if err := fs.init(log.New(io.Discard, "", 0)); err != nil {
+		t.Fatalf("failed to init fs: %v", err)
+	}
+
+	// ttyACM0 is already configured by serial, so only ttyUSB0 remains.
+	got := fs.expand([]rawDevice{
+		{Name: "other", Serial: "3333", Baud: 115200},
+		{Name: "port%d", Auto: true, Baud: 9600},
+	}, log.New(io.Discard, "", 0))
+
+	want := []rawDevice{
+		{Name: "other", Serial: "3333", Baud: 115200},
+		{Name: "port1", Device: "/dev/ttyUSB0", Baud: 9600},
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("unexpected devices (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,51 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// writeUdevRules writes udev rules to w which create stable /dev/consrv/<name>
+// symlinks for each device configured by serial number or USB path. Other
+// devices are noted in comments, since they have no stable identifier.
+func writeUdevRules(w io.Writer, devices []rawDevice) error {
+	_, err := fmt.Fprintln(w, "# Generated by consrv -generate-udev, such as for /etc/udev/rules.d/99-consrv.rules.")
+	if err != nil {
+		return err
+	}
+
+	for _, d := range devices {
+		var line string
+		switch {
+		case d.Serial != "":
+			line = fmt.Sprintf(`SUBSYSTEM=="tty", ATTRS{serial}==%q, SYMLINK+="consrv/%s"`, d.Serial, d.Name)
+		case d.USBPath != "":
+			line = fmt.Sprintf(`SUBSYSTEM=="tty", KERNELS==%q, SYMLINK+="consrv/%s"`, d.USBPath, d.Name)
+		case d.Auto:
+			line = fmt.Sprintf("# skipped device template %q: auto devices are only known at startup", d.Name)
+		case d.Address != "":
+			line = fmt.Sprintf("# skipped device %q: network-attached device", d.Name)
+		default:
+			line = fmt.Sprintf("# skipped device %q: configured by device path %q", d.Name, d.Device)
+		}
+
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
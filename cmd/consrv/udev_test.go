@@ -0,0 +1,76 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_writeUdevRules(t *testing.T) {
+	const s = `
+	[[devices]]
+	name = "server"
+	device = "/dev/ttyUSB0"
+	baud = 115200
+
+	[[devices]]
+	name = "desktop"
+	serial = "A50285BI"
+	baud = 115200
+
+	[[devices]]
+	name = "rack%d"
+	usb_paths = ["1-1.{1..2}"]
+	baud = 115200
+
+	[[devices]]
+	name = "port%d"
+	auto = true
+	baud = 115200
+
+	[[devices]]
+	name = "switch"
+	address = "ts1.example.com:7001"
+
+	[[identities]]
+	name = "ed25519"
+	public_key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ6PAHCvJTosPqBppE6lmjjRt9Qlcisqx+DXt7jIbLba test ed25519"
+	`
+
+	c, err := parseConfig(strings.NewReader(s))
+	if err != nil {
+		t.Fatalf("failed to parse config: %v", err)
+	}
+
+	var sb strings.Builder
+	if err := writeUdevRules(&sb, c.Devices); err != nil {
+		t.Fatalf("failed to write udev rules: %v", err)
+	}
+
+	want := `# Generated by consrv -generate-udev, such as for /etc/udev/rules.d/99-consrv.rules.
+# skipped device "server": configured by device path "/dev/ttyUSB0"
+SUBSYSTEM=="tty", ATTRS{serial}=="A50285BI", SYMLINK+="consrv/desktop"
+SUBSYSTEM=="tty", KERNELS=="1-1.1", SYMLINK+="consrv/rack1"
+SUBSYSTEM=="tty", KERNELS=="1-1.2", SYMLINK+="consrv/rack2"
+# skipped device template "port%d": auto devices are only known at startup
+# skipped device "switch": network-attached device
+`
+
+	if diff := cmp.Diff(want, sb.String()); diff != "" {
+		t.Fatalf("unexpected udev rules (-want +got):\n%s", diff)
+	}
+}
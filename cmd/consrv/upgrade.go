@@ -0,0 +1,128 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/tarm/serial"
+)
+
+// upgradeEnv is set in the environment of a consrv process which replaced a
+// previous process in a graceful upgrade, and contains its JSON upgradeState.
+const upgradeEnv = "CONSRV_UPGRADE"
+
+// upgradeNotifyDelay is how long attached sessions are given to receive the
+// upgrade notification before the process is replaced.
+const upgradeNotifyDelay = 500 * time.Millisecond
+
+// An upgradeState describes the file descriptors inherited from the previous
+// process in a graceful upgrade.
+type upgradeState struct {
+	SSH   int `json:"ssh"`
+	Debug int `json:"debug,omitempty"`
+
+	// Devices maps the path of each open serial port to its descriptor.
+	Devices map[string]int `json:"devices,omitempty"`
+
+	mu sync.Mutex
+}
+
+// An upgrader replaces the consrv process with a new binary on request,
+// handing over the listeners and open serial ports so that clients can
+// reconnect immediately and devices aren't reset by closing their ports.
+type upgrader struct {
+	sshl, httpl net.Listener
+	ll          *log.Logger
+}
+
+// loadUpgrade returns the state inherited from a previous process, or nil if
+// this process was not started by a graceful upgrade.
+func loadUpgrade() (*upgradeState, error) {
+	s, ok := os.LookupEnv(upgradeEnv)
+	if !ok {
+		return nil, nil
+	}
+	// Don't pass the state on to any process started by this one.
+	_ = os.Unsetenv(upgradeEnv)
+
+	var us upgradeState
+	if err := json.Unmarshal([]byte(s), &us); err != nil {
+		return nil, fmt.Errorf("failed to parse upgrade state: %v", err)
+	}
+
+	return &us, nil
+}
+
+// listeners returns the inherited SSH and optional HTTP debug listeners.
+func (us *upgradeState) listeners() (sshl, httpl net.Listener, err error) {
+	sshl, err = fileListener(us.SSH, "ssh")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to inherit SSH listener: %v", err)
+	}
+
+	if us.Debug != 0 {
+		httpl, err = fileListener(us.Debug, "debug")
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to inherit HTTP debug listener: %v", err)
+		}
+	}
+
+	return sshl, httpl, nil
+}
+
+// fileListener creates a net.Listener from the inherited descriptor fd.
+func fileListener(fd int, name string) (net.Listener, error) {
+	f := os.NewFile(uintptr(fd), name)
+	defer f.Close()
+
+	return net.FileListener(f)
+}
+
+// openPort wraps next so that each inherited serial port is used the first
+// time it is opened, rather than reopening and resetting the device. The
+// terminal settings of the port are kept from the previous process.
+func (us *upgradeState) openPort(next func(cfg *serial.Config) (io.ReadWriteCloser, error)) func(cfg *serial.Config) (io.ReadWriteCloser, error) {
+	return func(cfg *serial.Config) (io.ReadWriteCloser, error) {
+		us.mu.Lock()
+		fd, ok := us.Devices[cfg.Name]
+		delete(us.Devices, cfg.Name)
+		us.mu.Unlock()
+
+		if !ok {
+			return next(cfg)
+		}
+
+		return os.NewFile(uintptr(fd), cfg.Name), nil
+	}
+}
+
+// closeUnused closes the inherited serial ports which were not opened, such
+// as those of devices removed from the configuration.
+func (us *upgradeState) closeUnused() {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+
+	for name, fd := range us.Devices {
+		_ = os.NewFile(uintptr(fd), name).Close()
+	}
+	clear(us.Devices)
+}
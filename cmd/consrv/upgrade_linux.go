@@ -0,0 +1,122 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// run replaces the process with the consrv binary on disk each time the
+// process receives SIGUSR2. prepare is invoked before the process is
+// replaced, and ports returns the paths of the serial ports to hand over.
+func (u *upgrader) run(prepare func(), ports func() []string) {
+	sigC := make(chan os.Signal, 1)
+	signal.Notify(sigC, syscall.SIGUSR2)
+
+	for range sigC {
+		u.ll.Println("upgrading: received SIGUSR2")
+		if err := u.upgrade(prepare, ports()); err != nil {
+			errorf(u.ll, "failed to upgrade: %v", err)
+		}
+	}
+}
+
+// upgrade re-executes the consrv binary in place with the listeners and
+// serial ports. The process ID is unchanged, so process supervisors are
+// unaware of the upgrade. It only returns if the upgrade fails.
+func (u *upgrader) upgrade(prepare func(), ports []string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find executable: %v", err)
+	}
+
+	// Every file handed over must survive exec, and is closed if the upgrade
+	// fails.
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			_ = f.Close()
+		}
+	}()
+	inherit := func(f *os.File) (int, error) {
+		files = append(files, f)
+		if _, err := unix.FcntlInt(f.Fd(), unix.F_SETFD, 0); err != nil {
+			return 0, err
+		}
+
+		return int(f.Fd()), nil
+	}
+
+	var us upgradeState
+	for _, l := range []net.Listener{u.sshl, u.httpl} {
+		if l == nil {
+			continue
+		}
+
+		f, err := l.(*net.TCPListener).File()
+		if err != nil {
+			return fmt.Errorf("failed to get listener file: %v", err)
+		}
+		fd, err := inherit(f)
+		if err != nil {
+			return fmt.Errorf("failed to hand over listener: %v", err)
+		}
+
+		if l == u.sshl {
+			us.SSH = fd
+		} else {
+			us.Debug = fd
+		}
+	}
+
+	// The serial package doesn't expose its file descriptor, so open a second
+	// descriptor which shares the terminal settings and keeps the port open
+	// while the original is closed by exec.
+	for _, p := range ports {
+		f, err := os.OpenFile(p, os.O_RDWR|unix.O_NOCTTY, 0)
+		if err != nil {
+			return fmt.Errorf("failed to open serial port %q: %v", p, err)
+		}
+		fd, err := inherit(f)
+		if err != nil {
+			return fmt.Errorf("failed to hand over serial port %q: %v", p, err)
+		}
+
+		if us.Devices == nil {
+			us.Devices = make(map[string]int)
+		}
+		us.Devices[p] = fd
+	}
+
+	b, err := json.Marshal(&us)
+	if err != nil {
+		return err
+	}
+
+	prepare()
+	time.Sleep(upgradeNotifyDelay)
+
+	u.ll.Printf("upgrading: executing %s with %d serial ports", exe, len(us.Devices))
+	return syscall.Exec(exe, os.Args, append(os.Environ(), upgradeEnv+"="+string(b)))
+}
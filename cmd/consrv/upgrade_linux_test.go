@@ -0,0 +1,134 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/tarm/serial"
+	"golang.org/x/sys/unix"
+)
+
+func Test_upgradeState(t *testing.T) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer l.Close()
+
+	lf, err := l.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatalf("failed to get listener file: %v", err)
+	}
+	defer lf.Close()
+
+	// Stand in for serial ports with pipes.
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("failed to create pipe: %v", err)
+	}
+	defer r.Close()
+	defer w.Close()
+
+	unused, unusedW, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("failed to create pipe: %v", err)
+	}
+	defer unused.Close()
+	defer unusedW.Close()
+
+	// The upgraded process owns its inherited descriptors.
+	dup := func(f *os.File) int {
+		fd, err := unix.Dup(int(f.Fd()))
+		if err != nil {
+			t.Fatalf("failed to duplicate descriptor: %v", err)
+		}
+		return fd
+	}
+	unusedFD := dup(unused)
+
+	b, err := json.Marshal(map[string]any{
+		"ssh": dup(lf),
+		"devices": map[string]int{
+			"/dev/ttyUSB0": dup(r),
+			"/dev/ttyUSB1": unusedFD,
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to marshal state: %v", err)
+	}
+	t.Setenv(upgradeEnv, string(b))
+
+	us, err := loadUpgrade()
+	if err != nil {
+		t.Fatalf("failed to load upgrade state: %v", err)
+	}
+	if _, ok := os.LookupEnv(upgradeEnv); ok {
+		t.Fatal("upgrade state was not removed from the environment")
+	}
+
+	sshl, httpl, err := us.listeners()
+	if err != nil {
+		t.Fatalf("failed to inherit listeners: %v", err)
+	}
+	defer sshl.Close()
+
+	if diff := cmp.Diff(l.Addr().String(), sshl.Addr().String()); diff != "" {
+		t.Fatalf("unexpected SSH listener address (-want +got):\n%s", diff)
+	}
+	if httpl != nil {
+		t.Fatal("expected no HTTP debug listener")
+	}
+
+	errOpen := errors.New("opened")
+	openPort := us.openPort(func(_ *serial.Config) (io.ReadWriteCloser, error) {
+		return nil, errOpen
+	})
+
+	// The inherited port is used once, and then ports are opened normally.
+	rwc, err := openPort(&serial.Config{Name: "/dev/ttyUSB0"})
+	if err != nil {
+		t.Fatalf("failed to open inherited port: %v", err)
+	}
+	defer rwc.Close()
+
+	if _, err := w.Write([]byte("hello")); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+	got := make([]byte, 5)
+	if _, err := io.ReadFull(rwc, got); err != nil {
+		t.Fatalf("failed to read: %v", err)
+	}
+	if diff := cmp.Diff("hello", string(got)); diff != "" {
+		t.Fatalf("unexpected data (-want +got):\n%s", diff)
+	}
+
+	if _, err := openPort(&serial.Config{Name: "/dev/ttyUSB0"}); !errors.Is(err, errOpen) {
+		t.Fatalf("expected a normal open, but got: %v", err)
+	}
+
+	// Unused ports are closed.
+	us.closeUnused()
+	if _, err := unix.FcntlInt(uintptr(unusedFD), unix.F_GETFD, 0); !errors.Is(err, unix.EBADF) {
+		t.Fatalf("expected unused port to be closed, but got: %v", err)
+	}
+}
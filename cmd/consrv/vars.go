@@ -0,0 +1,102 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// A varsHandler is an http.Handler which serves the consrv counters and gauges
+// as JSON, for environments which do not run Prometheus.
+type varsHandler struct {
+	g prometheus.Gatherer
+}
+
+// A jsonVar is a JSON representation of a single counter or gauge metric.
+type jsonVar struct {
+	Type   string       `json:"type"`
+	Help   string       `json:"help"`
+	Values []jsonSample `json:"values"`
+}
+
+// A jsonSample is a JSON representation of a single labeled metric value.
+type jsonSample struct {
+	Labels map[string]string `json:"labels,omitempty"`
+	Value  float64           `json:"value"`
+}
+
+// ServeHTTP implements http.Handler.
+func (vh varsHandler) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	vars, err := gatherVars(vh.g)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(vars)
+}
+
+// gatherVars gathers the consrv counters and gauges from g, keyed by metric
+// name.
+func gatherVars(g prometheus.Gatherer) (map[string]jsonVar, error) {
+	mfs, err := g.Gather()
+	if err != nil {
+		return nil, err
+	}
+
+	vars := make(map[string]jsonVar)
+	for _, mf := range mfs {
+		// Only mirror the metrics produced by consrv itself, rather than the
+		// Go runtime and process collectors.
+		if !strings.HasPrefix(mf.GetName(), "consrv_") {
+			continue
+		}
+
+		v := jsonVar{Help: mf.GetHelp()}
+		switch mf.GetType() {
+		case dto.MetricType_COUNTER:
+			v.Type = "counter"
+		case dto.MetricType_GAUGE:
+			v.Type = "gauge"
+		default:
+			continue
+		}
+
+		for _, m := range mf.GetMetric() {
+			s := jsonSample{Value: m.GetCounter().GetValue()}
+			if v.Type == "gauge" {
+				s.Value = m.GetGauge().GetValue()
+			}
+
+			for _, lp := range m.GetLabel() {
+				if s.Labels == nil {
+					s.Labels = make(map[string]string)
+				}
+				s.Labels[lp.GetName()] = lp.GetValue()
+			}
+
+			v.Values = append(v.Values, s)
+		}
+
+		vars[mf.GetName()] = v
+	}
+
+	return vars, nil
+}
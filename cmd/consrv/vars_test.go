@@ -0,0 +1,72 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mdlayher/metricslite"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+func Test_varsHandler(t *testing.T) {
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(collectors.NewGoCollector())
+
+	mm := metricslite.NewPrometheus(reg)
+	reads := mm.Counter("consrv_test_reads_total", "Test reads.", "device")
+	open := mm.Gauge("consrv_test_open", "Test open.")
+
+	reads(2, "foo")
+	reads(1, "bar")
+	open(1)
+
+	w := httptest.NewRecorder()
+	varsHandler{g: reg}.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/vars", nil))
+
+	if diff := cmp.Diff(http.StatusOK, w.Code); diff != "" {
+		t.Fatalf("unexpected status code (-want +got):\n%s", diff)
+	}
+
+	var got map[string]jsonVar
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatalf("failed to unmarshal vars: %v", err)
+	}
+
+	// Go runtime metrics are omitted and labeled values are sorted.
+	want := map[string]jsonVar{
+		"consrv_test_reads_total": {
+			Type: "counter",
+			Help: "Test reads.",
+			Values: []jsonSample{
+				{Labels: map[string]string{"device": "bar"}, Value: 1},
+				{Labels: map[string]string{"device": "foo"}, Value: 2},
+			},
+		},
+		"consrv_test_open": {
+			Type:   "gauge",
+			Help:   "Test open.",
+			Values: []jsonSample{{Value: 1}},
+		},
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("unexpected vars (-want +got):\n%s", diff)
+	}
+}
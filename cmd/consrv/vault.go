@@ -0,0 +1,284 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mdlayher/consrv"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// vaultConfig contains the configuration for a HashiCorp Vault SSH secrets
+// engine, whose certificate authority is trusted for user certificates and
+// which optionally signs the SSH host key.
+type vaultConfig struct {
+	Address    string   `toml:"address"`
+	TokenFile  string   `toml:"token_file"`
+	Mount      string   `toml:"mount"`
+	HostRole   string   `toml:"host_role"`
+	Principals []string `toml:"principals"`
+}
+
+// Defaults and timing for Vault.
+const (
+	defaultVaultMount = "ssh"
+
+	// vaultTimeout bounds each request to Vault.
+	vaultTimeout = 10 * time.Second
+	// vaultRetry is the interval at which a failed host certificate renewal
+	// is retried.
+	vaultRetry = 1 * time.Minute
+)
+
+// validate verifies the Vault configuration and applies defaults.
+func (vc *vaultConfig) validate() error {
+	u, err := url.Parse(vc.Address)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("vault has invalid address %q", vc.Address)
+	}
+
+	if vc.Mount == "" {
+		vc.Mount = defaultVaultMount
+	}
+	if vc.HostRole != "" && vc.TokenFile == "" {
+		return errors.New("vault host role requires a token file")
+	}
+
+	return nil
+}
+
+// A vaultClient uses a Vault SSH secrets engine.
+type vaultClient struct {
+	addr, mount, token string
+	c                  *http.Client
+}
+
+// newVaultClient creates a vaultClient from vc, reading its token file if
+// configured.
+func newVaultClient(vc vaultConfig) (*vaultClient, error) {
+	var token string
+	if vc.TokenFile != "" {
+		b, err := os.ReadFile(vc.TokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read vault token: %v", err)
+		}
+		token = strings.TrimSpace(string(b))
+	}
+
+	return &vaultClient{
+		addr:  strings.TrimSuffix(vc.Address, "/"),
+		mount: strings.Trim(vc.Mount, "/"),
+		token: token,
+		c:     http.DefaultClient,
+	}, nil
+}
+
+// userCA fetches the public key of the secrets engine's certificate authority.
+func (vc *vaultClient) userCA(ctx context.Context) (gossh.PublicKey, error) {
+	b, err := vc.do(ctx, http.MethodGet, "public_key", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	key, _, _, _, err := gossh.ParseAuthorizedKey(b)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse vault CA key: %v", err)
+	}
+
+	return key, nil
+}
+
+// signHost signs the host key with role for principals, and returns the host
+// certificate in authorized_keys format.
+func (vc *vaultClient) signHost(ctx context.Context, role string, key gossh.PublicKey, principals []string) ([]byte, error) {
+	body, err := json.Marshal(map[string]string{
+		"public_key":       string(gossh.MarshalAuthorizedKey(key)),
+		"cert_type":        "host",
+		"valid_principals": strings.Join(principals, ","),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := vc.do(ctx, http.MethodPost, "sign/"+url.PathEscape(role), body)
+	if err != nil {
+		return nil, err
+	}
+
+	var res struct {
+		Data struct {
+			SignedKey string `json:"signed_key"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(b, &res); err != nil {
+		return nil, fmt.Errorf("failed to decode vault response: %v", err)
+	}
+
+	return []byte(res.Data.SignedKey), nil
+}
+
+// do performs a request to path under the secrets engine mount and returns
+// the response body.
+func (vc *vaultClient) do(ctx context.Context, method, path string, body []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, vaultTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, method, fmt.Sprintf("%s/v1/%s/%s", vc.addr, vc.mount, path), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if vc.token != "" {
+		req.Header.Set("X-Vault-Token", vc.token)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := vc.c.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query vault: %v", err)
+	}
+	defer res.Body.Close()
+
+	b, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read vault response: %v", err)
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("vault returned HTTP %d: %s", res.StatusCode, bytes.TrimSpace(b))
+	}
+
+	return b, nil
+}
+
+// A hostCertRenewer keeps a host certificate from Vault presented by a server,
+// renewing it before it expires.
+type hostCertRenewer struct {
+	vc         *vaultClient
+	role       string
+	key        func() (gossh.PublicKey, error)
+	principals []string
+	set        func(cert []byte) error
+	now        func() time.Time
+	ll         *log.Logger
+}
+
+// newHostCertRenewer creates a hostCertRenewer which signs the public key of
+// the host key hk for srv. If hk has a file, the key is read from the file
+// for each renewal so that certificates follow a reloaded host key.
+func newHostCertRenewer(srv *consrv.Server, vc *vaultClient, cfg vaultConfig, hk hostKey, ll *log.Logger) (*hostCertRenewer, error) {
+	key := func() (gossh.PublicKey, error) {
+		b := hk.PEM
+		if hk.File != "" {
+			fb, err := os.ReadFile(hk.File)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read host key: %v", err)
+			}
+			b = fb
+		}
+
+		var (
+			signer gossh.Signer
+			err    error
+		)
+		if len(hk.Passphrase) > 0 {
+			signer, err = gossh.ParsePrivateKeyWithPassphrase(b, hk.Passphrase)
+		} else {
+			signer, err = gossh.ParsePrivateKey(b)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse host key: %v", err)
+		}
+
+		return signer.PublicKey(), nil
+	}
+
+	principals := cfg.Principals
+	if len(principals) == 0 {
+		host, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get hostname for host certificate: %v", err)
+		}
+		principals = []string{host}
+	}
+
+	return &hostCertRenewer{
+		vc:         vc,
+		role:       cfg.HostRole,
+		key:        key,
+		principals: principals,
+		set:        srv.SetHostCertificate,
+		now:        time.Now,
+		ll:         ll,
+	}, nil
+}
+
+// renew signs and presents a new host certificate, and returns the time at
+// which it should next be renewed, or the zero time if it never expires.
+func (hr *hostCertRenewer) renew(ctx context.Context) (time.Time, error) {
+	key, err := hr.key()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	b, err := hr.vc.signHost(ctx, hr.role, key, hr.principals)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if err := hr.set(b); err != nil {
+		return time.Time{}, err
+	}
+
+	// Validated by set.
+	pub, _, _, _, _ := gossh.ParseAuthorizedKey(b)
+	cert := pub.(*gossh.Certificate)
+	if cert.ValidBefore == gossh.CertTimeInfinity {
+		hr.ll.Printf("presenting vault host certificate %d for %q", cert.Serial, hr.principals)
+		return time.Time{}, nil
+	}
+
+	// Renew two thirds of the way through the validity period, which leaves
+	// time to retry if Vault is unavailable.
+	after, before := time.Unix(int64(cert.ValidAfter), 0), time.Unix(int64(cert.ValidBefore), 0)
+	hr.ll.Printf("presenting vault host certificate %d for %q until %s", cert.Serial, hr.principals, before.Format(time.RFC3339))
+
+	return after.Add(before.Sub(after) * 2 / 3), nil
+}
+
+// run renews the host certificate until the process exits.
+func (hr *hostCertRenewer) run(next time.Time) {
+	for !next.IsZero() {
+		time.Sleep(next.Sub(hr.now()))
+
+		n, err := hr.renew(context.Background())
+		if err != nil {
+			errorf(hr.ll, "failed to renew vault host certificate, retrying in %s: %v", vaultRetry, err)
+			next = hr.now().Add(vaultRetry)
+			continue
+		}
+		next = n
+	}
+}
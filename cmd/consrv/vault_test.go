@@ -0,0 +1,146 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func Test_vaultClient(t *testing.T) {
+	ca, err := gossh.NewSignerFromKey(mustECDSA())
+	if err != nil {
+		t.Fatalf("failed to create CA signer: %v", err)
+	}
+
+	// Certificates are valid for an hour from a fixed time.
+	start := time.Date(2024, time.June, 1, 12, 0, 0, 0, time.UTC)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/v1/ssh-host/public_key":
+			_, _ = w.Write(gossh.MarshalAuthorizedKey(ca.PublicKey()))
+		case r.Method == http.MethodPost && r.URL.Path == "/v1/ssh-host/sign/consrv":
+			if r.Header.Get("X-Vault-Token") != "secret" {
+				http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+				return
+			}
+
+			var req struct {
+				PublicKey       string `json:"public_key"`
+				CertType        string `json:"cert_type"`
+				ValidPrincipals string `json:"valid_principals"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.CertType != "host" {
+				http.Error(w, "bad request", http.StatusBadRequest)
+				return
+			}
+
+			key, _, _, _, err := gossh.ParseAuthorizedKey([]byte(req.PublicKey))
+			if err != nil {
+				http.Error(w, "bad key", http.StatusBadRequest)
+				return
+			}
+
+			cert := &gossh.Certificate{
+				Key:             key,
+				Serial:          1,
+				CertType:        gossh.HostCert,
+				ValidPrincipals: strings.Split(req.ValidPrincipals, ","),
+				ValidAfter:      uint64(start.Unix()),
+				ValidBefore:     uint64(start.Add(time.Hour).Unix()),
+			}
+			if err := cert.SignCert(rand.Reader, ca); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"data": map[string]string{"signed_key": string(gossh.MarshalAuthorizedKey(cert))},
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	vc := &vaultClient{addr: srv.URL, mount: "ssh-host", token: "secret", c: srv.Client()}
+
+	got, err := vc.userCA(context.Background())
+	if err != nil {
+		t.Fatalf("failed to fetch CA: %v", err)
+	}
+	if !bytes.Equal(ca.PublicKey().Marshal(), got.Marshal()) {
+		t.Fatal("unexpected CA public key")
+	}
+
+	host, err := gossh.NewSignerFromKey(mustECDSA())
+	if err != nil {
+		t.Fatalf("failed to create host signer: %v", err)
+	}
+
+	var certs [][]byte
+	hr := &hostCertRenewer{
+		vc:         vc,
+		role:       "consrv",
+		key:        func() (gossh.PublicKey, error) { return host.PublicKey(), nil },
+		principals: []string{"monitnerr-1", "monitnerr-1.example.com"},
+		set: func(cert []byte) error {
+			certs = append(certs, cert)
+			return nil
+		},
+		now: time.Now,
+		ll:  log.New(io.Discard, "", 0),
+	}
+
+	next, err := hr.renew(context.Background())
+	if err != nil {
+		t.Fatalf("failed to renew host certificate: %v", err)
+	}
+
+	// Renewal occurs two thirds of the way through the validity period.
+	if diff := cmp.Diff(start.Add(40*time.Minute), next.UTC()); diff != "" {
+		t.Fatalf("unexpected renewal time (-want +got):\n%s", diff)
+	}
+	if len(certs) != 1 {
+		t.Fatalf("expected 1 certificate, but got %d", len(certs))
+	}
+
+	key, _, _, _, err := gossh.ParseAuthorizedKey(certs[0])
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+	cert := key.(*gossh.Certificate)
+	if diff := cmp.Diff(hr.principals, cert.ValidPrincipals); diff != "" {
+		t.Fatalf("unexpected principals (-want +got):\n%s", diff)
+	}
+
+	// Requests which Vault refuses fail.
+	vc.token = "wrong"
+	if _, err := hr.renew(context.Background()); err == nil || !strings.Contains(err.Error(), "HTTP 403") {
+		t.Fatalf("expected an HTTP 403 error, but got: %v", err)
+	}
+}
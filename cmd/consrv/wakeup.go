@@ -0,0 +1,85 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"time"
+)
+
+// wakeupConfig contains the configuration for periodically sending bytes to an
+// idle device.
+type wakeupConfig struct {
+	Interval duration `toml:"interval"`
+	Bytes    string   `toml:"bytes"`
+}
+
+// validate verifies the wakeup configuration for device.
+func (wc *wakeupConfig) validate(device string) error {
+	if wc.Interval.Duration <= 0 {
+		return fmt.Errorf("device %q wakeup must have a positive interval", device)
+	}
+	if wc.Bytes == "" {
+		return fmt.Errorf("device %q wakeup must have bytes to send", device)
+	}
+
+	return nil
+}
+
+// A wakeup periodically sends bytes to a device while no SSH session is
+// attached, for consoles which drop the line when idle, such as some network
+// equipment and BMC serial-over-LAN.
+type wakeup struct {
+	name     string
+	interval time.Duration
+	b        []byte
+	sessions func() int
+	ll       *log.Logger
+}
+
+// newWakeup creates a wakeup for device d which checks for attached sessions
+// using sessions.
+func newWakeup(d rawDevice, sessions func() int, ll *log.Logger) *wakeup {
+	return &wakeup{
+		name:     d.Name,
+		interval: d.Wakeup.Interval.Duration,
+		b:        []byte(d.Wakeup.Bytes),
+		sessions: sessions,
+		ll:       ll,
+	}
+}
+
+// run sends bytes to w at each interval until the process exits.
+func (wu *wakeup) run(w io.Writer) {
+	t := time.NewTicker(wu.interval)
+	defer t.Stop()
+
+	for range t.C {
+		wu.send(w)
+	}
+}
+
+// send writes the wakeup bytes to w unless a session is attached, since the
+// session keeps the console alive and shouldn't receive unexpected input.
+func (wu *wakeup) send(w io.Writer) {
+	if wu.sessions() > 0 {
+		return
+	}
+
+	if _, err := w.Write(wu.b); err != nil {
+		errorf(wu.ll, "wakeup for %q: failed to write: %v", wu.name, err)
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_wakeupSend(t *testing.T) {
+	tests := []struct {
+		name     string
+		sessions int
+		want     string
+	}{
+		{
+			name: "idle",
+			want: "\r",
+		},
+		{
+			name:     "attached",
+			sessions: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wu := &wakeup{
+				name:     "switch",
+				b:        []byte("\r"),
+				sessions: func() int { return tt.sessions },
+				ll:       log.New(io.Discard, "", 0),
+			}
+
+			var buf bytes.Buffer
+			wu.send(&buf)
+
+			if diff := cmp.Diff(tt.want, buf.String()); diff != "" {
+				t.Fatalf("unexpected device input (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// watchPath uses inotify to wait for a device path to appear.
+var watchPath = inotifyWatch
+
+// inotifyWatch blocks until path exists or done is closed, in which case it
+// returns errClosed. Any missing parent directories of path, such as
+// /dev/serial/by-id, are waited for as well.
+func inotifyWatch(path string, done <-chan struct{}) error {
+	for {
+		ok, err := inotifyWait(path, done)
+		if err != nil || ok {
+			return err
+		}
+	}
+}
+
+// inotifyWait watches the closest existing parent directory of path and
+// reports whether path exists after the directory changes or a timeout
+// elapses.
+func inotifyWait(path string, done <-chan struct{}) (bool, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return false, fmt.Errorf("failed to initialize inotify: %v", err)
+	}
+	defer unix.Close(fd)
+
+	dir := filepath.Dir(path)
+	for {
+		_, err := unix.InotifyAddWatch(fd, dir, unix.IN_CREATE|unix.IN_MOVED_TO|unix.IN_ATTRIB)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, unix.ENOENT) || dir == filepath.Dir(dir) {
+			return false, fmt.Errorf("failed to watch %q: %v", dir, err)
+		}
+
+		dir = filepath.Dir(dir)
+	}
+
+	// Check only after the watch is in place so a device which appears in the
+	// meantime is not missed.
+	if _, err := os.Stat(path); err == nil {
+		return true, nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return false, err
+	}
+
+	select {
+	case <-done:
+		return false, errClosed
+	default:
+	}
+
+	// Wake up periodically to check whether done was closed.
+	fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}
+	if _, err := unix.Poll(fds, 1000); err != nil && !errors.Is(err, unix.EINTR) {
+		return false, fmt.Errorf("failed to poll inotify: %v", err)
+	}
+
+	_, err = os.Stat(path)
+	return err == nil, nil
+}
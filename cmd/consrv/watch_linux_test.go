@@ -0,0 +1,121 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mdlayher/consrv"
+)
+
+func Test_pendingDeviceInotify(t *testing.T) {
+	t.Parallel()
+
+	// The parent directory doesn't exist yet either, as is the case for
+	// /dev/serial/by-id before any USB serial adapters are attached.
+	var (
+		dir  = t.TempDir()
+		path = filepath.Join(dir, "serial", "ttyAMA1")
+	)
+
+	noop := func(_ float64, _ ...string) {}
+	d := newPendingDevice(rawDevice{Name: "foo", Device: path}, func() (consrv.Device, error) {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+
+		return &serialDevice{rwc: f, name: "foo", device: path, reads: noop, writes: noop}, nil
+	}, inotifyWatch, log.New(io.Discard, "", 0))
+	defer d.Close()
+
+	if d.connected() {
+		t.Fatal("device should not be connected before it exists")
+	}
+	if _, err := d.Write([]byte("hello")); err == nil {
+		t.Fatal("expected an error writing to a missing device")
+	}
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		if err := os.Mkdir(filepath.Dir(path), 0o755); err != nil {
+			panic(err)
+		}
+		if err := os.WriteFile(path, []byte("hello"), 0o644); err != nil {
+			panic(err)
+		}
+	}()
+
+	b := make([]byte, 16)
+	n, err := d.Read(b)
+	if err != nil {
+		t.Fatalf("failed to read: %v", err)
+	}
+
+	if diff := cmp.Diff("hello", string(b[:n])); diff != "" {
+		t.Fatalf("unexpected output (-want +got):\n%s", diff)
+	}
+	if !d.connected() {
+		t.Fatal("device should be connected after it is opened")
+	}
+
+	// Once the device fails, it is closed and then reopened by the following
+	// read.
+	if _, err := d.Read(b); err != io.EOF {
+		t.Fatalf("expected EOF, but got: %v", err)
+	}
+	if !d.reconnect(io.EOF) {
+		t.Fatal("device should reconnect after a read error")
+	}
+	if d.connected() {
+		t.Fatal("device should not be connected after a read error")
+	}
+
+	n, err = d.Read(b)
+	if err != nil {
+		t.Fatalf("failed to read after reconnecting: %v", err)
+	}
+	if diff := cmp.Diff("hello", string(b[:n])); diff != "" {
+		t.Fatalf("unexpected output after reconnecting (-want +got):\n%s", diff)
+	}
+
+	_ = d.Close()
+	if d.reconnect(io.EOF) {
+		t.Fatal("device should not reconnect after it is closed")
+	}
+}
+
+func Test_pendingDeviceClose(t *testing.T) {
+	t.Parallel()
+
+	d := newPendingDevice(rawDevice{Name: "foo", Device: filepath.Join(t.TempDir(), "ttyAMA1")},
+		func() (consrv.Device, error) {
+			panic("should not be called")
+		}, inotifyWatch, log.New(io.Discard, "", 0))
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		_ = d.Close()
+	}()
+
+	if _, err := d.Read(make([]byte, 16)); err != io.EOF {
+		t.Fatalf("expected EOF after close, but got: %v", err)
+	}
+}
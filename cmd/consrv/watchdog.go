@@ -0,0 +1,283 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"regexp"
+	"time"
+
+	"github.com/mdlayher/consrv"
+	"github.com/mdlayher/metricslite"
+)
+
+// watchdogConfig contains the configuration for a device's idle output
+// watchdog.
+type watchdogConfig struct {
+	Idle    duration `toml:"idle"`
+	After   []string `toml:"after"`
+	Webhook string   `toml:"webhook"`
+	Command []string `toml:"command"`
+}
+
+// A duration is a time.Duration which is parsed from a TOML string.
+type duration struct{ time.Duration }
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (d *duration) UnmarshalText(b []byte) error {
+	v, err := time.ParseDuration(string(b))
+	if err != nil {
+		return err
+	}
+
+	d.Duration = v
+	return nil
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (d duration) MarshalText() ([]byte, error) { return []byte(d.String()), nil }
+
+// validate verifies the watchdog configuration for device.
+func (wc *watchdogConfig) validate(device string) error {
+	if wc.Idle.Duration <= 0 {
+		return fmt.Errorf("device %q watchdog must have a positive idle duration", device)
+	}
+	if wc.Webhook == "" && len(wc.Command) == 0 {
+		return fmt.Errorf("device %q watchdog must have a webhook or command", device)
+	}
+
+	if wc.Webhook != "" {
+		u, err := url.Parse(wc.Webhook)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("device %q watchdog has invalid webhook URL %q", device, wc.Webhook)
+		}
+	}
+
+	for _, s := range wc.After {
+		if _, err := regexp.Compile(s); err != nil {
+			return fmt.Errorf("device %q watchdog has invalid pattern: %v", device, err)
+		}
+	}
+
+	return nil
+}
+
+// A watchdog performs an action when a device produces no output for an idle
+// period while it is armed.
+type watchdog struct {
+	name  string
+	idle  time.Duration
+	after []*regexp.Regexp
+	fire  func(ctx context.Context) error
+	fired metricslite.Counter
+	ll    *log.Logger
+}
+
+// newWatchdog creates a watchdog for device d from its configuration.
+func newWatchdog(d rawDevice, fired metricslite.Counter, ll *log.Logger) *watchdog {
+	wc := d.Watchdog
+
+	after := make([]*regexp.Regexp, 0, len(wc.After))
+	for _, s := range wc.After {
+		// Validated when parsing the configuration.
+		after = append(after, regexp.MustCompile(s))
+	}
+
+	return &watchdog{
+		name:  d.Name,
+		idle:  wc.Idle.Duration,
+		after: after,
+		fire: func(ctx context.Context) error {
+			var errs []error
+			if wc.Webhook != "" {
+				errs = append(errs, postWebhook(ctx, wc.Webhook, struct {
+					Device string `json:"device"`
+					Idle   string `json:"idle"`
+				}{
+					Device: d.Name,
+					Idle:   wc.Idle.String(),
+				}))
+			}
+			if len(wc.Command) > 0 {
+				errs = append(errs, runCommand(ctx, wc.Command, d.Name, wc.Idle.Duration))
+			}
+
+			return errors.Join(errs...)
+		},
+		fired: fired,
+		ll:    ll,
+	}
+}
+
+// run watches output from the mux until the process exits, restarting the
+// watch if it stops.
+func (w *watchdog) run(mux *consrv.MuxDevice) {
+	for {
+		ctx, cancel := context.WithCancel(context.Background())
+		if err := w.watch(ctx, mux.Attach(ctx)); err != nil {
+			errorf(w.ll, "watchdog for %q: %v", w.name, err)
+		}
+		cancel()
+
+		w.ll.Printf("restarting watchdog for %q", w.name)
+		time.Sleep(1 * time.Second)
+	}
+}
+
+// watch reads output from r until it returns an error, and fires the watchdog
+// each time output stops for the idle period while the watchdog is armed. The
+// watchdog is always armed if it has no patterns. Otherwise, it is armed by
+// output matching a pattern and disarmed after it fires.
+func (w *watchdog) watch(ctx context.Context, r io.Reader) error {
+	type read struct {
+		b   []byte
+		err error
+	}
+
+	reads := make(chan read)
+	go func() {
+		for {
+			b := make([]byte, 4096)
+			n, err := r.Read(b)
+			select {
+			case reads <- read{b: b[:n], err: err}:
+			case <-ctx.Done():
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	armed := len(w.after) == 0
+
+	t := time.NewTimer(w.idle)
+	defer t.Stop()
+	if !armed {
+		t.Stop()
+	}
+
+	var lb lineBuffer
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case rd := <-reads:
+			if rd.err != nil {
+				return rd.err
+			}
+			if len(rd.b) == 0 {
+				continue
+			}
+
+			lb.write(rd.b, func(line []byte, _ bool) {
+				armed = armed || w.match(line)
+			})
+
+			if armed {
+				t.Reset(w.idle)
+			}
+		case <-t.C:
+			w.ll.Printf("watchdog for %q: no output for %s, firing", w.name, w.idle)
+			w.fired(1.0, w.name)
+
+			// Keep reading output while the action runs so the mux is not
+			// blocked.
+			go func() {
+				if err := w.fire(ctx); err != nil {
+					errorf(w.ll, "watchdog for %q: failed to fire: %v", w.name, err)
+				}
+			}()
+
+			// Only fire again once output resumes and, if configured, a
+			// pattern matches again.
+			armed = len(w.after) == 0
+		}
+	}
+}
+
+// match reports whether b matches any of the watchdog's patterns.
+func (w *watchdog) match(b []byte) bool {
+	for _, re := range w.after {
+		if re.Match(b) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// watchdogTimeout bounds the time spent performing a watchdog or other
+// notification action.
+const watchdogTimeout = 1 * time.Minute
+
+// postWebhook posts v to a webhook as JSON.
+func postWebhook(ctx context.Context, url string, v any) error {
+	ctx, cancel := context.WithTimeout(ctx, watchdogTimeout)
+	defer cancel()
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post webhook: %v", err)
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, res.Body)
+
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook returned HTTP %d", res.StatusCode)
+	}
+
+	return nil
+}
+
+// runCommand runs a command, such as a power cycle script, when device has been
+// idle.
+func runCommand(ctx context.Context, args []string, device string, idle time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, watchdogTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Env = append(os.Environ(),
+		"CONSRV_DEVICE="+device,
+		"CONSRV_IDLE="+idle.String(),
+	)
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to run command %q: %v: %s", args, err, bytes.TrimSpace(out))
+	}
+
+	return nil
+}
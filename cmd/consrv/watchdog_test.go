@@ -0,0 +1,124 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mdlayher/metricslite"
+)
+
+func Test_watchdogWatch(t *testing.T) {
+	const idle = 50 * time.Millisecond
+
+	tests := []struct {
+		name  string
+		after []string
+		in    []string
+		fire  bool
+	}{
+		{
+			name: "always armed",
+			in:   []string{"login: "},
+			fire: true,
+		},
+		{
+			name:  "not armed",
+			after: []string{`reboot: Restarting system`},
+			in:    []string{"foo\n", "bar\n"},
+		},
+		{
+			name:  "armed by line",
+			after: []string{`reboot: Restarting system`},
+			in:    []string{"foo\n", "[ 1.0] reboot: Restart", "ing system\n"},
+			fire:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var after []*regexp.Regexp
+			for _, s := range tt.after {
+				after = append(after, regexp.MustCompile(s))
+			}
+
+			fired := make(chan struct{}, 1)
+			w := &watchdog{
+				name:  "test",
+				idle:  idle,
+				after: after,
+				fire: func(_ context.Context) error {
+					fired <- struct{}{}
+					return nil
+				},
+				fired: metricslite.Discard().Counter("fired", "fired", "name"),
+				ll:    log.New(io.Discard, "", 0),
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			pr, pw := io.Pipe()
+			defer pw.Close()
+			go func() { _ = w.watch(ctx, pr) }()
+
+			for _, s := range tt.in {
+				if _, err := io.WriteString(pw, s); err != nil {
+					t.Fatalf("failed to write: %v", err)
+				}
+			}
+
+			var got bool
+			select {
+			case <-fired:
+				got = true
+			case <-time.After(5 * idle):
+			}
+
+			if diff := cmp.Diff(tt.fire, got); diff != "" {
+				t.Fatalf("unexpected watchdog firing (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_postWebhook(t *testing.T) {
+	var got string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		got = r.Method + " " + r.Header.Get("Content-Type") + " " + string(b)
+	}))
+	defer srv.Close()
+
+	v := struct {
+		Device string `json:"device"`
+	}{Device: "server"}
+
+	if err := postWebhook(context.Background(), srv.URL, v); err != nil {
+		t.Fatalf("failed to post webhook: %v", err)
+	}
+
+	const want = `POST application/json {"device":"server"}`
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("unexpected webhook request (-want +got):\n%s", diff)
+	}
+}
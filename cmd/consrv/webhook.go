@@ -0,0 +1,142 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"path/filepath"
+	"time"
+
+	"github.com/mdlayher/consrv"
+)
+
+// sessionWebhookConfig contains the configuration for posting a summary of
+// each SSH session to a webhook when the session closes.
+type sessionWebhookConfig struct {
+	URL         string `toml:"url"`
+	ExcerptSize int    `toml:"excerpt_size"`
+}
+
+// validate verifies the session webhook configuration. An excerpt requires the
+// debug capture buffer, from which the excerpt is taken.
+func (wc *sessionWebhookConfig) validate(capture bool) error {
+	u, err := url.Parse(wc.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return fmt.Errorf("session webhook has invalid URL %q", wc.URL)
+	}
+
+	switch {
+	case wc.ExcerptSize < 0:
+		return errors.New("session webhook excerpt size must not be negative")
+	case wc.ExcerptSize > 0 && !capture:
+		return errors.New("session webhook excerpt requires debug capture")
+	}
+
+	return nil
+}
+
+// A sessionSummary is the JSON summary of an SSH session posted to a session
+// webhook.
+type sessionSummary struct {
+	Device     string    `json:"device"`
+	Identity   string    `json:"identity"`
+	Address    string    `json:"address"`
+	Reason     string    `json:"reason,omitempty"`
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	Duration   string    `json:"duration"`
+	BytesIn    int64     `json:"bytes_in"`
+	BytesOut   int64     `json:"bytes_out"`
+	Transcript string    `json:"transcript,omitempty"`
+	Excerpt    string    `json:"excerpt,omitempty"`
+}
+
+// A sessionWebhook posts a sessionSummary to a webhook when each SSH session
+// detaches from a device.
+type sessionWebhook struct {
+	cfg      sessionWebhookConfig
+	logDir   string
+	local    map[string]bool
+	captures captureHandler
+	post     func(ctx context.Context, url string, v any) error
+	ll       *log.Logger
+}
+
+// newSessionWebhook creates a sessionWebhook for the local devices in cfg,
+// which take transcripts from captures and the device log files.
+func newSessionWebhook(cfg *config, captures captureHandler, ll *log.Logger) *sessionWebhook {
+	local := make(map[string]bool, len(cfg.Devices))
+	for _, d := range cfg.Devices {
+		local[d.Name] = true
+	}
+
+	return &sessionWebhook{
+		cfg:      *cfg.Server.SessionWebhook,
+		logDir:   cfg.Log.Directory,
+		local:    local,
+		captures: captures,
+		post:     postWebhook,
+		ll:       ll,
+	}
+}
+
+// detach implements consrv.ServerConfig.OnDetach.
+func (sw *sessionWebhook) detach(info consrv.SessionInfo, sum consrv.SessionSummary) {
+	if err := sw.post(context.Background(), sw.cfg.URL, sw.summary(info, sum)); err != nil {
+		errorf(sw.ll, "failed to post session webhook for %q: %v", info.Device, err)
+	}
+}
+
+// summary builds the sessionSummary for a session.
+func (sw *sessionWebhook) summary(info consrv.SessionInfo, sum consrv.SessionSummary) sessionSummary {
+	ss := sessionSummary{
+		Device:   info.Device,
+		Identity: info.Identity,
+		Reason:   info.Reason,
+		Start:    sum.Start,
+		End:      sum.End,
+		Duration: sum.End.Sub(sum.Start).Round(time.Second).String(),
+		BytesIn:  sum.BytesIn,
+		BytesOut: sum.BytesOut,
+	}
+	if info.Addr != nil {
+		ss.Address = info.Addr.String()
+	}
+
+	// Transcripts are only kept for local devices, since remote devices log
+	// on their own consrv instance.
+	if !sw.local[info.Device] {
+		return ss
+	}
+
+	if sw.logDir != "" {
+		ss.Transcript = filepath.Join(sw.logDir, info.Device+".log")
+	}
+
+	if cb := sw.captures[info.Device]; cb != nil && sw.cfg.ExcerptSize > 0 {
+		// Keep the end of the session's output, which is most likely to show
+		// the outcome of any changes.
+		b, _ := cb.between(sum.Start, sum.End)
+		if len(b) > sw.cfg.ExcerptSize {
+			b = b[len(b)-sw.cfg.ExcerptSize:]
+		}
+		ss.Excerpt = string(b)
+	}
+
+	return ss
+}
@@ -0,0 +1,124 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mdlayher/consrv"
+)
+
+func Test_sessionWebhookSummary(t *testing.T) {
+	var (
+		start = time.Unix(100, 0).UTC()
+		end   = start.Add(90 * time.Second)
+	)
+
+	// Capture output before, during, and after the session.
+	cb := newCaptureBuffer(1024, newRedactor(nil))
+	for i, s := range []string{"before\n", "$ reboot\n", "rebooting...\n", "after\n"} {
+		cb.now = func() time.Time { return start.Add(time.Duration(i*30) * time.Second) }
+		_, _ = cb.Write([]byte(s))
+	}
+
+	cfg := &config{
+		Server: server{
+			SessionWebhook: &sessionWebhookConfig{
+				URL:         "https://example.com/sessions",
+				ExcerptSize: 10,
+			},
+		},
+		Devices: []rawDevice{{Name: "server"}},
+		Log:     logConfig{Directory: "/perm/consrv/log"},
+	}
+
+	sum := consrv.SessionSummary{
+		Start:    start.Add(1 * time.Second),
+		End:      end,
+		BytesIn:  7,
+		BytesOut: 22,
+	}
+
+	tests := []struct {
+		name string
+		info consrv.SessionInfo
+		want sessionSummary
+	}{
+		{
+			name: "local",
+			info: consrv.SessionInfo{
+				Device:   "server",
+				Identity: "mdlayher",
+				Addr:     &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 22},
+				Reason:   "CHG-1234",
+			},
+			want: sessionSummary{
+				Device:     "server",
+				Identity:   "mdlayher",
+				Address:    "192.0.2.1:22",
+				Reason:     "CHG-1234",
+				Start:      sum.Start,
+				End:        sum.End,
+				Duration:   "1m29s",
+				BytesIn:    7,
+				BytesOut:   22,
+				Transcript: "/perm/consrv/log/server.log",
+				Excerpt:    "ooting...\n",
+			},
+		},
+		{
+			name: "remote",
+			info: consrv.SessionInfo{
+				Device:   "remote",
+				Identity: "mdlayher",
+			},
+			want: sessionSummary{
+				Device:   "remote",
+				Identity: "mdlayher",
+				Start:    sum.Start,
+				End:      sum.End,
+				Duration: "1m29s",
+				BytesIn:  7,
+				BytesOut: 22,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got any
+			sw := newSessionWebhook(cfg, captureHandler{"server": cb}, log.New(io.Discard, "", 0))
+			sw.post = func(_ context.Context, url string, v any) error {
+				if diff := cmp.Diff(cfg.Server.SessionWebhook.URL, url); diff != "" {
+					t.Fatalf("unexpected URL (-want +got):\n%s", diff)
+				}
+
+				got = v
+				return nil
+			}
+
+			sw.detach(tt.info, sum)
+
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Fatalf("unexpected summary (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
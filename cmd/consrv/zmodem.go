@@ -0,0 +1,676 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mdlayher/consrv"
+)
+
+// zmodemConfig contains the configuration for detecting ZMODEM transfers
+// started by a device.
+type zmodemConfig struct {
+	Spool string `toml:"spool"`
+}
+
+// validate verifies the ZMODEM configuration for device.
+func (zc *zmodemConfig) validate(device string) error {
+	if zc.Spool != "" && !filepath.IsAbs(zc.Spool) {
+		return fmt.Errorf("device %q ZMODEM spool must be an absolute path", device)
+	}
+
+	return nil
+}
+
+// zmodemIdle is the duration after which a spooled transfer is abandoned if
+// the device stops sending.
+const zmodemIdle = 30 * time.Second
+
+var (
+	// zmodemSend is the ZRQINIT hex header sent by a device which is
+	// starting a transfer, such as with sz.
+	zmodemSend = []byte("**\x18B00")
+
+	// zmodemRecv is the ZRINIT hex header sent by a device which is waiting
+	// to receive a transfer, such as with rz.
+	zmodemRecv = []byte("**\x18B01")
+
+	// zmodemCancel aborts a transfer in progress.
+	zmodemCancel = []byte("\x18\x18\x18\x18\x18\x18\x18\x18\b\b\b\b\b\b\b\b\b\b")
+)
+
+// A zmodemWatcher detects ZMODEM transfers in device output. By default the
+// transfer is bridged to attached SSH clients, which may run a ZMODEM capable
+// terminal. If a spool directory is configured, files sent by the device are
+// instead received by the server.
+type zmodemWatcher struct {
+	name   string
+	spool  string
+	notify func(format string, v ...any)
+	ll     *log.Logger
+}
+
+// newZmodemWatcher creates a zmodemWatcher for device d which informs sessions
+// using notify.
+func newZmodemWatcher(d rawDevice, notify func(format string, v ...any), ll *log.Logger) *zmodemWatcher {
+	return &zmodemWatcher{
+		name:   d.Name,
+		spool:  d.ZModem.Spool,
+		notify: notify,
+		ll:     ll,
+	}
+}
+
+// run watches output from the mux until the process exits, restarting the
+// watch if it stops.
+func (zw *zmodemWatcher) run(mux *consrv.MuxDevice) {
+	for {
+		ctx, cancel := context.WithCancel(context.Background())
+		spool, err := zw.watch(mux.Attach(ctx))
+		cancel()
+
+		if spool {
+			zw.receive(mux)
+			continue
+		}
+		if err != nil {
+			errorf(zw.ll, "ZMODEM watcher for %q: %v", zw.name, err)
+		}
+
+		zw.ll.Printf("restarting ZMODEM watcher for %q", zw.name)
+		time.Sleep(1 * time.Second)
+	}
+}
+
+// watch reads output from r until it returns an error or a transfer which
+// should be spooled is detected.
+func (zw *zmodemWatcher) watch(r io.Reader) (bool, error) {
+	var (
+		tail []byte
+		b    = make([]byte, 4096)
+	)
+
+	for {
+		n, err := r.Read(b)
+		if n > 0 {
+			buf := append(tail, b[:n]...)
+			tail = append([]byte(nil), buf[max(0, len(buf)-(len(zmodemSend)-1)):]...)
+
+			switch {
+			case bytes.Contains(buf, zmodemSend):
+				if zw.spool != "" {
+					return true, nil
+				}
+
+				zw.detect("download")
+				tail = nil
+			case bytes.Contains(buf, zmodemRecv):
+				zw.detect("upload")
+				tail = nil
+			}
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+}
+
+// detect reports a ZMODEM transfer which is bridged to SSH clients.
+func (zw *zmodemWatcher) detect(direction string) {
+	zw.ll.Printf("detected ZMODEM %s on %q, bridging to SSH sessions", direction, zw.name)
+
+	// Don't block reading output from the mux while informing sessions.
+	go zw.notify("device %q started a ZMODEM %s", zw.name, direction)
+}
+
+// receive pauses the mux and receives files from the device into the spool
+// directory.
+func (zw *zmodemWatcher) receive(mux *consrv.MuxDevice) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rw, err := mux.Pause(ctx)
+	if err != nil {
+		errorf(zw.ll, "%s: cannot receive ZMODEM transfer: %v", zw.name, err)
+		return
+	}
+
+	zw.ll.Printf("%s: receiving ZMODEM transfer into %q", zw.name, zw.spool)
+	zw.notify("device %q started a ZMODEM download, receiving into %s", zw.name, zw.spool)
+
+	// Abandon the transfer if the device stops sending.
+	t := time.AfterFunc(zmodemIdle, cancel)
+	defer t.Stop()
+
+	files, err := newZmodemReceiver(&idleReader{r: rw, t: t, d: zmodemIdle}, rw, zw.spool).receive()
+	if err != nil {
+		_, _ = rw.Write(zmodemCancel)
+		errorf(zw.ll, "%s: ZMODEM transfer failed after %d file(s): %v", zw.name, len(files), err)
+		zw.notify("device %q ZMODEM download failed after %d file(s): %v", zw.name, len(files), err)
+		return
+	}
+
+	zw.ll.Printf("%s: received %d file(s) by ZMODEM: %s", zw.name, len(files), strings.Join(files, ", "))
+	zw.notify("device %q ZMODEM download complete: %s", zw.name, strings.Join(files, ", "))
+}
+
+// An idleReader resets a timer whenever data is read.
+type idleReader struct {
+	r io.Reader
+	t *time.Timer
+	d time.Duration
+}
+
+func (ir *idleReader) Read(b []byte) (int, error) {
+	n, err := ir.r.Read(b)
+	if n > 0 {
+		ir.t.Reset(ir.d)
+	}
+	return n, err
+}
+
+// ZMODEM protocol constants.
+const (
+	zPad   = '*'
+	zDLE   = 0x18
+	zHex   = 'B'
+	zBin   = 'A'
+	zBin32 = 'C'
+
+	zRQInit = 0
+	zRInit  = 1
+	zSInit  = 2
+	zAck    = 3
+	zFile   = 4
+	zSkip   = 5
+	zNak    = 6
+	zAbort  = 7
+	zFin    = 8
+	zRPos   = 9
+	zData   = 10
+	zEOF    = 11
+	zFErr   = 12
+
+	zCRCE = 'h'
+	zCRCG = 'i'
+	zCRCQ = 'j'
+	zCRCW = 'k'
+	zRub0 = 'l'
+	zRub1 = 'm'
+
+	zCanFDX  = 0x01
+	zCanOVIO = 0x02
+	zCanFC32 = 0x20
+
+	// zMaxSubpacket bounds the size of a data subpacket, which is at most
+	// 8KiB for common implementations.
+	zMaxSubpacket = 64 << 10
+)
+
+var (
+	errZmodemCanceled = errors.New("transfer canceled by sender")
+	errZmodemCRC      = errors.New("bad CRC")
+)
+
+// A zmodemReceiver receives files using the ZMODEM protocol.
+type zmodemReceiver struct {
+	r   *bufio.Reader
+	w   io.Writer
+	dir string
+
+	// crc32 reports whether the last binary header used 32-bit CRCs, which
+	// also applies to the data subpackets which follow it.
+	crc32 bool
+}
+
+// newZmodemReceiver creates a zmodemReceiver which reads from r, writes to w,
+// and stores files in dir.
+func newZmodemReceiver(r io.Reader, w io.Writer, dir string) *zmodemReceiver {
+	return &zmodemReceiver{
+		r:   bufio.NewReader(r),
+		w:   w,
+		dir: dir,
+	}
+}
+
+// receive receives files until the sender finishes the session, returning the
+// names of the files which were received.
+func (zr *zmodemReceiver) receive() ([]string, error) {
+	var (
+		files []string
+		f     *os.File
+		name  string
+		off   uint32
+	)
+	defer func() {
+		// Discard any partially received file.
+		if f != nil {
+			_ = f.Close()
+			_ = os.Remove(f.Name())
+		}
+	}()
+
+	if err := zr.sendRInit(); err != nil {
+		return nil, err
+	}
+
+	for {
+		typ, hdr, err := zr.readHeader()
+		if err != nil {
+			return files, err
+		}
+
+		switch typ {
+		case zRQInit:
+			err = zr.sendRInit()
+		case zSInit:
+			if _, _, err = zr.readSubpacket(); err != nil {
+				if !errors.Is(err, errZmodemCRC) {
+					return files, err
+				}
+				err = zr.sendHeader(zNak, [4]byte{})
+				break
+			}
+			err = zr.sendHeader(zAck, [4]byte{})
+		case zFile:
+			var b []byte
+			b, _, err = zr.readSubpacket()
+			if err != nil {
+				if !errors.Is(err, errZmodemCRC) {
+					return files, err
+				}
+				err = zr.sendHeader(zNak, [4]byte{})
+				break
+			}
+
+			if f != nil {
+				_ = f.Close()
+				_ = os.Remove(f.Name())
+				f = nil
+			}
+
+			name, err = zr.name(b)
+			if err != nil {
+				err = zr.sendHeader(zSkip, [4]byte{})
+				break
+			}
+
+			f, err = os.OpenFile(filepath.Join(zr.dir, name+".part"), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o640)
+			if err != nil {
+				return files, err
+			}
+
+			off = 0
+			err = zr.sendHeader(zRPos, zmodemPos(off))
+		case zData:
+			if f == nil {
+				return files, errors.New("received data without a file")
+			}
+			if binary.LittleEndian.Uint32(hdr[:]) != off {
+				// Out of sequence, ask the sender to resume where we left off.
+				err = zr.sendHeader(zRPos, zmodemPos(off))
+				break
+			}
+
+			off, err = zr.readData(f, off)
+		case zEOF:
+			if f == nil || binary.LittleEndian.Uint32(hdr[:]) != off {
+				// Stale or early EOF, the sender will retry.
+				break
+			}
+
+			if err := f.Close(); err != nil {
+				return files, err
+			}
+			if err := os.Rename(f.Name(), filepath.Join(zr.dir, name)); err != nil {
+				return files, err
+			}
+			f = nil
+
+			files = append(files, name)
+			err = zr.sendRInit()
+		case zFin:
+			return files, zr.sendHeader(zFin, [4]byte{})
+		case zAbort, zFErr:
+			return files, errZmodemCanceled
+		}
+		if err != nil {
+			return files, err
+		}
+	}
+}
+
+// readData writes data subpackets following a ZDATA header to f, starting at
+// offset off, and returns the offset after the last valid subpacket.
+func (zr *zmodemReceiver) readData(f *os.File, off uint32) (uint32, error) {
+	for {
+		b, end, err := zr.readSubpacket()
+		if err != nil {
+			if !errors.Is(err, errZmodemCRC) {
+				return off, err
+			}
+
+			// Resynchronize at the last good offset.
+			return off, zr.sendHeader(zRPos, zmodemPos(off))
+		}
+
+		if _, err := f.Write(b); err != nil {
+			return off, err
+		}
+		off += uint32(len(b))
+
+		switch end {
+		case zCRCW:
+			return off, zr.sendHeader(zAck, zmodemPos(off))
+		case zCRCQ:
+			if err := zr.sendHeader(zAck, zmodemPos(off)); err != nil {
+				return off, err
+			}
+		case zCRCE:
+			return off, nil
+		}
+	}
+}
+
+// name returns a unique file name in the spool directory from the file
+// information b in a ZFILE subpacket.
+func (zr *zmodemReceiver) name(b []byte) (string, error) {
+	b, _, _ = bytes.Cut(b, []byte{0})
+
+	// Only use the final path element, so the sender cannot write outside of
+	// the spool directory.
+	base := filepath.Base(filepath.Clean("/" + strings.ReplaceAll(string(b), `\`, "/")))
+	if base == "/" || base == "." || base == ".." {
+		return "", fmt.Errorf("invalid file name %q", b)
+	}
+
+	name := base
+	for i := 1; ; i++ {
+		_, err := os.Stat(filepath.Join(zr.dir, name))
+		if errors.Is(err, os.ErrNotExist) {
+			return name, nil
+		}
+		if err != nil {
+			return "", err
+		}
+
+		name = base + "." + strconv.Itoa(i)
+	}
+}
+
+// sendRInit sends a ZRINIT header advertising the receiver's capabilities.
+func (zr *zmodemReceiver) sendRInit() error {
+	return zr.sendHeader(zRInit, [4]byte{3: zCanFDX | zCanOVIO | zCanFC32})
+}
+
+// sendHeader sends a hex header of type typ.
+func (zr *zmodemReceiver) sendHeader(typ byte, hdr [4]byte) error {
+	b := append([]byte{typ}, hdr[:]...)
+	b = binary.BigEndian.AppendUint16(b, crc16(b))
+
+	out := fmt.Appendf(nil, "**\x18B%x\r\x8a", b)
+	if typ != zFin && typ != zAck {
+		out = append(out, 0x11)
+	}
+
+	_, err := zr.w.Write(out)
+	return err
+}
+
+// readHeader skips input until a header is found, and returns its type and
+// data. Headers with a bad CRC are skipped.
+func (zr *zmodemReceiver) readHeader() (byte, [4]byte, error) {
+	var cans int
+	for {
+		b, err := zr.r.ReadByte()
+		if err != nil {
+			return 0, [4]byte{}, err
+		}
+		if b == zDLE {
+			// Five consecutive CAN bytes cancel the transfer.
+			if cans++; cans == 5 {
+				return 0, [4]byte{}, errZmodemCanceled
+			}
+			continue
+		}
+		cans = 0
+		if b != zPad {
+			continue
+		}
+
+		for b == zPad {
+			if b, err = zr.r.ReadByte(); err != nil {
+				return 0, [4]byte{}, err
+			}
+		}
+		if b != zDLE {
+			continue
+		}
+
+		enc, err := zr.r.ReadByte()
+		if err != nil {
+			return 0, [4]byte{}, err
+		}
+
+		var hdr []byte
+		switch enc {
+		case zHex:
+			hdr, err = zr.readHexHeader()
+		case zBin:
+			zr.crc32 = false
+			hdr, err = zr.readBinaryHeader()
+		case zBin32:
+			zr.crc32 = true
+			hdr, err = zr.readBinaryHeader()
+		default:
+			continue
+		}
+		if errors.Is(err, errZmodemCRC) {
+			continue
+		}
+		if err != nil {
+			return 0, [4]byte{}, err
+		}
+
+		return hdr[0], [4]byte(hdr[1:5]), nil
+	}
+}
+
+// readHexHeader reads the type, data, and CRC of a hex header.
+func (zr *zmodemReceiver) readHexHeader() ([]byte, error) {
+	hex := make([]byte, 14)
+	if _, err := io.ReadFull(zr.r, hex); err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, 7)
+	for i := range b {
+		v, err := strconv.ParseUint(string(hex[i*2:i*2+2]), 16, 8)
+		if err != nil {
+			return nil, errZmodemCRC
+		}
+		b[i] = byte(v)
+	}
+
+	if crc16(b[:5]) != binary.BigEndian.Uint16(b[5:]) {
+		return nil, errZmodemCRC
+	}
+
+	zr.crc32 = false
+	return b[:5], nil
+}
+
+// readBinaryHeader reads the type, data, and CRC of a binary header.
+func (zr *zmodemReceiver) readBinaryHeader() ([]byte, error) {
+	n := 2
+	if zr.crc32 {
+		n = 4
+	}
+
+	b := make([]byte, 5+n)
+	for i := range b {
+		c, err := zr.readEscaped()
+		if err != nil {
+			return nil, err
+		}
+		if c > 0xff {
+			return nil, errZmodemCRC
+		}
+		b[i] = byte(c)
+	}
+
+	if !zr.checkCRC(b[:5], b[5:]) {
+		return nil, errZmodemCRC
+	}
+
+	return b[:5], nil
+}
+
+// readSubpacket reads a data subpacket and returns its data and the frame end
+// which terminated it.
+func (zr *zmodemReceiver) readSubpacket() ([]byte, byte, error) {
+	var b []byte
+	for {
+		c, err := zr.readEscaped()
+		if err != nil {
+			return nil, 0, err
+		}
+		if c <= 0xff {
+			if len(b) == zMaxSubpacket {
+				return nil, 0, errors.New("data subpacket is too large")
+			}
+
+			b = append(b, byte(c))
+			continue
+		}
+
+		end := byte(c)
+		sum := make([]byte, 2)
+		if zr.crc32 {
+			sum = make([]byte, 4)
+		}
+		for i := range sum {
+			c, err := zr.readEscaped()
+			if err != nil {
+				return nil, 0, err
+			}
+			if c > 0xff {
+				return nil, 0, errZmodemCRC
+			}
+			sum[i] = byte(c)
+		}
+
+		// The frame end is covered by the CRC.
+		if !zr.checkCRC(append(b, end), sum) {
+			return nil, 0, errZmodemCRC
+		}
+
+		return b, end, nil
+	}
+}
+
+// checkCRC verifies the 16-bit or 32-bit CRC sum of b.
+func (zr *zmodemReceiver) checkCRC(b, sum []byte) bool {
+	if zr.crc32 {
+		return crc32.ChecksumIEEE(b) == binary.LittleEndian.Uint32(sum)
+	}
+
+	return crc16(b) == binary.BigEndian.Uint16(sum)
+}
+
+// readEscaped reads a single byte, decoding ZDLE escapes. Frame ends are
+// returned with the 0x100 bit set.
+func (zr *zmodemReceiver) readEscaped() (int, error) {
+	for {
+		b, err := zr.r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+
+		switch b {
+		case 0x11, 0x13, 0x91, 0x93:
+			// Unescaped XON and XOFF are flow control, not data.
+			continue
+		case zDLE:
+		default:
+			return int(b), nil
+		}
+
+		// Five consecutive CAN bytes cancel the transfer.
+		for cans := 1; ; {
+			c, err := zr.r.ReadByte()
+			if err != nil {
+				return 0, err
+			}
+
+			switch {
+			case c == zDLE:
+				if cans++; cans == 5 {
+					return 0, errZmodemCanceled
+				}
+				continue
+			case c == 0x11, c == 0x13, c == 0x91, c == 0x93:
+				continue
+			case c >= zCRCE && c <= zCRCW:
+				return 0x100 | int(c), nil
+			case c == zRub0:
+				return 0x7f, nil
+			case c == zRub1:
+				return 0xff, nil
+			case c&0x60 == 0x40:
+				return int(c ^ 0x40), nil
+			default:
+				return 0, errZmodemCRC
+			}
+		}
+	}
+}
+
+// zmodemPos encodes a file offset as header data.
+func zmodemPos(off uint32) [4]byte {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], off)
+	return b
+}
+
+// crc16 computes the CRC-16/XMODEM sum of b.
+func crc16(b []byte) uint16 {
+	var crc uint16
+	for _, c := range b {
+		crc ^= uint16(c) << 8
+		for range 8 {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+
+	return crc
+}
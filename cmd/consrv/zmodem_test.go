@@ -0,0 +1,326 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_zmodemWatcherWatch(t *testing.T) {
+	tests := []struct {
+		name   string
+		spool  string
+		in     []string
+		detect []string
+		ok     bool
+	}{
+		{
+			name: "console",
+			in:   []string{"login: ", "rz\r\n"},
+		},
+		{
+			name:   "download bridged",
+			in:     []string{"sz foo\r\n**", "\x18B00000000000000\r\x8a\x11"},
+			detect: []string{"device \"test\" started a ZMODEM download"},
+		},
+		{
+			name:   "upload bridged",
+			in:     []string{"rz\r\n**\x18B0100000023be50\r\x8a\x11"},
+			detect: []string{"device \"test\" started a ZMODEM upload"},
+		},
+		{
+			name:  "download spooled",
+			spool: "/spool",
+			in:    []string{"sz foo\r\n**\x18B00000000000000\r\x8a\x11"},
+			ok:    true,
+		},
+		{
+			name:   "upload spooled",
+			spool:  "/spool",
+			in:     []string{"**\x18B0100000023be50\r\x8a\x11"},
+			detect: []string{"device \"test\" started a ZMODEM upload"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			detect := make(chan string, len(tt.detect))
+			zw := &zmodemWatcher{
+				name:  "test",
+				spool: tt.spool,
+				notify: func(format string, v ...any) {
+					detect <- fmt.Sprintf(format, v...)
+				},
+				ll: log.New(io.Discard, "", 0),
+			}
+
+			ok, err := zw.watch(&chunkReader{chunks: tt.in, read: func() {}})
+			if err != io.EOF && err != nil {
+				t.Fatalf("failed to watch: %v", err)
+			}
+			if diff := cmp.Diff(tt.ok, ok); diff != "" {
+				t.Fatalf("unexpected spool (-want +got):\n%s", diff)
+			}
+
+			var got []string
+			for range tt.detect {
+				got = append(got, <-detect)
+			}
+			if diff := cmp.Diff(tt.detect, got); diff != "" {
+				t.Fatalf("unexpected notifications (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_zmodemReceiver(t *testing.T) {
+	// Include bytes which must be escaped in the file data.
+	data := "hello\x18world\x11\x7f\xff\x8d\r\n"
+
+	tests := []struct {
+		name  string
+		file  string
+		crc32 bool
+		bad   bool
+		want  string
+	}{
+		{
+			name: "CRC-16",
+			file: "hello.txt",
+			want: "hello.txt",
+		},
+		{
+			name:  "CRC-32",
+			file:  "hello.txt",
+			crc32: true,
+			want:  "hello.txt",
+		},
+		{
+			name: "retry bad CRC",
+			file: "hello.txt",
+			bad:  true,
+			want: "hello.txt",
+		},
+		{
+			name: "path traversal",
+			file: "../../etc/passwd",
+			want: "passwd",
+		},
+		{
+			name: "existing",
+			file: "existing.txt",
+			want: "existing.txt.1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, "existing.txt"), nil, 0o640); err != nil {
+				t.Fatalf("failed to create file: %v", err)
+			}
+
+			// The sender writes to the receiver over one pipe and reads its
+			// responses from another.
+			sr, sw := io.Pipe()
+			rr, rw := io.Pipe()
+
+			type result struct {
+				files []string
+				err   error
+			}
+			resC := make(chan result, 1)
+			go func() {
+				defer rw.Close()
+				files, err := newZmodemReceiver(sr, rw, dir).receive()
+				resC <- result{files: files, err: err}
+			}()
+
+			s := &zmodemSender{
+				t:     t,
+				w:     sw,
+				r:     newZmodemReceiver(rr, io.Discard, ""),
+				crc32: tt.crc32,
+			}
+
+			// The receiver starts by sending ZRINIT, and again when the
+			// sender requests it.
+			s.expect(zRInit)
+			s.hex(zRQInit, [4]byte{})
+			s.expect(zRInit)
+
+			s.header(zFile, [4]byte{})
+			s.subpacket(tt.file+"\x00"+"14 0 0\x00", zCRCW, false)
+			if got := s.expect(zRPos); got != 0 {
+				t.Fatalf("unexpected file position: %d", got)
+			}
+
+			s.header(zData, zmodemPos(0))
+			if tt.bad {
+				// A corrupted subpacket must be resent from the last good
+				// position.
+				s.subpacket(data[:5], zCRCG, false)
+				s.subpacket(data[5:], zCRCE, true)
+				if got := s.expect(zRPos); got != 5 {
+					t.Fatalf("unexpected resume position: %d", got)
+				}
+
+				s.header(zData, zmodemPos(5))
+				s.subpacket(data[5:], zCRCE, false)
+			} else {
+				s.subpacket(data[:5], zCRCQ, false)
+				if got := s.expect(zAck); got != 5 {
+					t.Fatalf("unexpected acknowledged position: %d", got)
+				}
+				s.subpacket(data[5:], zCRCE, false)
+			}
+
+			s.header(zEOF, zmodemPos(uint32(len(data))))
+			s.expect(zRInit)
+
+			s.hex(zFin, [4]byte{})
+			s.expect(zFin)
+
+			res := <-resC
+			if res.err != nil {
+				t.Fatalf("failed to receive: %v", res.err)
+			}
+			if diff := cmp.Diff([]string{tt.want}, res.files); diff != "" {
+				t.Fatalf("unexpected files (-want +got):\n%s", diff)
+			}
+
+			b, err := os.ReadFile(filepath.Join(dir, tt.want))
+			if err != nil {
+				t.Fatalf("failed to read file: %v", err)
+			}
+			if diff := cmp.Diff(data, string(b)); diff != "" {
+				t.Fatalf("unexpected file data (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_zmodemReceiverCancel(t *testing.T) {
+	r := strings.NewReader("**\x18B00000000000000\r\x8a\x11" + string(zmodemCancel))
+
+	_, err := newZmodemReceiver(r, io.Discard, t.TempDir()).receive()
+	if err != errZmodemCanceled {
+		t.Fatalf("expected canceled transfer, but got: %v", err)
+	}
+}
+
+// A zmodemSender is a minimal ZMODEM sender for tests.
+type zmodemSender struct {
+	t     *testing.T
+	w     io.Writer
+	r     *zmodemReceiver
+	crc32 bool
+}
+
+func (s *zmodemSender) write(b []byte) {
+	s.t.Helper()
+
+	if _, err := s.w.Write(b); err != nil {
+		s.t.Fatalf("failed to write: %v", err)
+	}
+}
+
+// hex sends a hex header using the receiver's implementation.
+func (s *zmodemSender) hex(typ byte, hdr [4]byte) {
+	s.t.Helper()
+
+	zr := &zmodemReceiver{w: s.w}
+	if err := zr.sendHeader(typ, hdr); err != nil {
+		s.t.Fatalf("failed to send header: %v", err)
+	}
+}
+
+// header sends a binary header.
+func (s *zmodemSender) header(typ byte, hdr [4]byte) {
+	s.t.Helper()
+
+	b := append([]byte{typ}, hdr[:]...)
+	enc := byte(zBin)
+	if s.crc32 {
+		enc = zBin32
+	}
+
+	s.write(append([]byte{zPad, zDLE, enc}, escape(append(b, s.sum(b)...))...))
+}
+
+// subpacket sends a data subpacket, optionally with a corrupted CRC.
+func (s *zmodemSender) subpacket(data string, end byte, corrupt bool) {
+	s.t.Helper()
+
+	sum := s.sum(append([]byte(data), end))
+	if corrupt {
+		sum[0] ^= 0xff
+	}
+
+	b := escape([]byte(data))
+	b = append(b, zDLE, end)
+	s.write(append(b, escape(sum)...))
+}
+
+func (s *zmodemSender) sum(b []byte) []byte {
+	if s.crc32 {
+		return binary.LittleEndian.AppendUint32(nil, crc32.ChecksumIEEE(b))
+	}
+
+	return binary.BigEndian.AppendUint16(nil, crc16(b))
+}
+
+// expect reads a header of type typ and returns its position.
+func (s *zmodemSender) expect(typ byte) uint32 {
+	s.t.Helper()
+
+	got, hdr, err := s.r.readHeader()
+	if err != nil {
+		s.t.Fatalf("failed to read header: %v", err)
+	}
+	if got != typ {
+		s.t.Fatalf("expected header type %d, but got: %d", typ, got)
+	}
+
+	return binary.LittleEndian.Uint32(hdr[:])
+}
+
+// escape applies ZDLE escaping to b.
+func escape(b []byte) []byte {
+	var out []byte
+	for _, c := range b {
+		switch c {
+		case zDLE, 0x10, 0x90, 0x11, 0x91, 0x13, 0x93, 0x0d, 0x8d:
+			out = append(out, zDLE, c^0x40)
+		case 0x7f:
+			out = append(out, zDLE, zRub0)
+		case 0xff:
+			out = append(out, zDLE, zRub1)
+		default:
+			out = append(out, c)
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,203 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consrv
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gliderlabs/ssh"
+)
+
+// command handles an SSH exec request, which may be sent with any SSH user
+// name:
+//
+//	$ ssh -p 2222 consrv@monitnerr-1 reserve server 1h
+//
+// The supported commands manage device reservations, approve sessions as
+// described by ServerConfig.RequireApproval, request write access as described
+// by ServerConfig.ReadOnly, watch the output of several devices as described
+// by WatchUser, search a device's recent output as described by
+// ServerConfig.Scrollback, operate on a group of devices as described by
+// ServerConfig.DeviceGroups, or attach to a device regardless of the SSH user
+// name. Because each SSH session on a connection may run its own command, a
+// client may attach to many devices over a single connection:
+//
+//	reserve <device> <duration>
+//	release <device>
+//	reservations
+//	approve <device> <id>
+//	approvals
+//	write <device> <duration>
+//	watch [device...]
+//	search <device> <regexp> [page]
+//	group [status|watch|power-cycle <group>]
+//	attach <device>
+func (s *Server) command(session ssh.Session) {
+	f, _ := session.Context().Value(fingerprintKey{}).(string)
+	id := s.identities().toName[f]
+
+	args := session.Command()
+	if err := s.runCommand(session, id, f, args); err != nil {
+		s.logf(session, "%s: %v", args[0], err)
+		_ = session.Exit(1)
+		return
+	}
+
+	_ = session.Exit(0)
+}
+
+// runCommand runs the command args for identity id with public key
+// fingerprint f.
+func (s *Server) runCommand(session ssh.Session, id, f string, args []string) error {
+	// checkDevice verifies that the device argument exists and that the
+	// identity may access it.
+	checkDevice := func(n int) (string, error) {
+		if len(args) != n {
+			return "", fmt.Errorf("expected %d arguments, but got %d", n-1, len(args)-1)
+		}
+
+		device := args[1]
+		if _, ok := s.devices[device]; !ok || !s.allowed(session.Context(), device) {
+			return "", fmt.Errorf("unknown device %q", device)
+		}
+
+		return device, nil
+	}
+
+	addr := addrString(session.RemoteAddr())
+	now := s.reserved.now()
+	switch args[0] {
+	case "reserve":
+		device, err := checkDevice(3)
+		if err != nil {
+			return err
+		}
+
+		d, err := time.ParseDuration(args[2])
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid duration %q", args[2])
+		}
+
+		r, n := s.reserved.reserve(device, id, d)
+		if n > 0 {
+			s.ll.Printf("%s: %s queued for device %q at position %d", addr, id, device, n)
+			fmt.Fprintf(session, "consrv> %q is reserved by %s until %s, queued at position %d\n",
+				device, r.Identity, formatUntil(r.Until, now), n)
+			return nil
+		}
+
+		s.ll.Printf("%s: %s reserved device %q until %s", addr, id, device, r.Until.Format(time.RFC3339))
+		fmt.Fprintf(session, "consrv> reserved %q until %s\n", device, formatUntil(r.Until, now))
+		s.Notify(device, "%s reserved console %q until %s", id, device, formatUntil(r.Until, now))
+		return nil
+	case "release":
+		device, err := checkDevice(2)
+		if err != nil {
+			return err
+		}
+
+		if !s.reserved.release(device, id) {
+			return fmt.Errorf("%q is not reserved or queued by %s", device, id)
+		}
+
+		s.ll.Printf("%s: %s released device %q", addr, id, device)
+		fmt.Fprintf(session, "consrv> released %q\n", device)
+		return nil
+	case "reservations":
+		if len(args) != 1 {
+			return fmt.Errorf("expected 0 arguments, but got %d", len(args)-1)
+		}
+
+		for _, r := range s.reserved.list() {
+			if !s.allowed(session.Context(), r.Device) {
+				continue
+			}
+
+			fmt.Fprintf(session, "%s identity=%q until=%q queue=%q\n",
+				r.Device, r.Identity, r.Until.Format(time.RFC3339), strings.Join(r.Queue, ","))
+		}
+		return nil
+	case "approve":
+		device, err := checkDevice(3)
+		if err != nil {
+			return err
+		}
+
+		n, err := strconv.Atoi(args[2])
+		if err != nil {
+			return fmt.Errorf("invalid approval %q", args[2])
+		}
+
+		if err := s.Approve(device, n, id); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(session, "consrv> approved session %d on %q\n", n, device)
+		return nil
+	case "write":
+		device, err := checkDevice(3)
+		if err != nil {
+			return err
+		}
+
+		d, err := time.ParseDuration(args[2])
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", args[2])
+		}
+
+		return s.requestWrite(session, device, id, d)
+	case "approvals":
+		if len(args) != 1 {
+			return fmt.Errorf("expected 0 arguments, but got %d", len(args)-1)
+		}
+
+		s.listApprovals(session)
+		return nil
+	case "watch":
+		return s.watch(session, f, args[1:])
+	case "search":
+		if len(args) != 3 && len(args) != 4 {
+			return fmt.Errorf("expected 2 or 3 arguments, but got %d", len(args)-1)
+		}
+
+		return s.search(session, f, args[1:])
+	case "group":
+		return s.groupCommand(session, id, f, args[1:])
+	case "attach":
+		device, err := checkDevice(2)
+		if err != nil {
+			return err
+		}
+
+		// attach reports its own errors and exits the session.
+		s.attach(session, device, s.devices[device])
+		return nil
+	default:
+		return errors.New("unknown command")
+	}
+}
+
+// formatUntil formats the expiry time of a reservation for humans, omitting
+// the date for reservations which expire within a day of now.
+func formatUntil(t, now time.Time) string {
+	if t.Sub(now) < 24*time.Hour {
+		return t.Format("15:04 MST")
+	}
+
+	return t.Format("Jan 2 15:04 MST")
+}
@@ -0,0 +1,99 @@
+// Copyright 2020-2022 Matt Layher and Michael Stapelberg
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consrv
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// A Device is a handle to a console device, such as a serial port.
+type Device interface {
+	io.ReadWriteCloser
+	fmt.Stringer
+}
+
+// A MuxDevice is a Device with multiplexed reads.
+type MuxDevice struct {
+	m *Mux
+	Device
+}
+
+// NewMuxDevice wraps d with a Mux so that any number of clients may attach to
+// it and receive its output.
+func NewMuxDevice(d Device) *MuxDevice { return NewMuxDeviceConfig(d, MuxConfig{}) }
+
+// NewMuxDeviceConfig is like NewMuxDevice, but configures the device's Mux
+// using cfg.
+func NewMuxDeviceConfig(d Device, cfg MuxConfig) *MuxDevice {
+	return &MuxDevice{
+		m:      NewMuxConfig(d, cfg),
+		Device: d,
+	}
+}
+
+// Attach attaches a client to the device's Mux. See Mux.Attach for details.
+func (d *MuxDevice) Attach(ctx context.Context) io.Reader { return d.m.Attach(ctx) }
+
+// Detach detaches the client which reads from r. See Mux.Detach for details.
+func (d *MuxDevice) Detach(r io.Reader) { d.m.Detach(r) }
+
+// Err returns the error which stopped the device's Mux from reading the
+// device. See Mux.Err for details.
+func (d *MuxDevice) Err() error { return d.m.Err() }
+
+// Write writes b to the device unless the device's Mux is paused, in which
+// case b is discarded so that it cannot interfere with the client which paused
+// the Mux.
+func (d *MuxDevice) Write(b []byte) (int, error) {
+	if d.m.Paused() {
+		return len(b), nil
+	}
+
+	return d.Device.Write(b)
+}
+
+// Pause pauses the device's Mux and produces an io.ReadWriter which exclusively
+// reads from and writes to the device until ctx is canceled. See Mux.Pause for
+// details.
+func (d *MuxDevice) Pause(ctx context.Context) (io.ReadWriter, error) {
+	r, err := d.m.Pause(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return struct {
+		io.Reader
+		io.Writer
+	}{
+		Reader: r,
+		Writer: d.Device,
+	}, nil
+}
+
+// Close cleans up the device and mux.
+func (d *MuxDevice) Close() error {
+	err1 := d.Device.Close()
+	err2 := d.m.Close()
+
+	if err1 != nil {
+		return err1
+	}
+	if err2 != nil {
+		return err2
+	}
+
+	return nil
+}
@@ -0,0 +1,27 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package consrv implements an SSH to serial console bridge server which may be
+// embedded in other Go programs.
+//
+// A Server serves SSH sessions for a set of named MuxDevices. Each SSH user name
+// selects the device of the same name, and any number of sessions may attach to
+// a single device at once: reads are multiplexed to every session by a Mux,
+// while writes from all sessions are passed directly to the Device.
+//
+// Authentication is performed using SSH public keys configured as Identities,
+// which may be permitted to access all devices or only specific devices.
+//
+// The consrv command in cmd/consrv is a complete server built on this package
+// which adds configuration files, serial port discovery, and metrics.
+package consrv
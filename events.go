@@ -0,0 +1,143 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consrv
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/gliderlabs/ssh"
+)
+
+// EventsSubsystem is the name of the SSH subsystem which streams server events
+// as JSON lines until the client disconnects. Only events for devices the
+// authenticated identity may access are streamed:
+//
+//	$ ssh -p 2222 -s consrv@monitnerr-1 consrv-events
+//	{"time":"2024-06-01T12:00:00Z","type":"session_open","device":"server","identity":"matt","address":"192.0.2.1"}
+const EventsSubsystem = "consrv-events"
+
+// Types of Events.
+const (
+	// EventSessionOpen and EventSessionClose occur when an SSH session attaches
+	// to and detaches from a device. The Message of EventSessionOpen is the
+	// reason entered for the session, if any.
+	EventSessionOpen  = "session_open"
+	EventSessionClose = "session_close"
+
+	// EventDeviceState occurs when reading from a device fails, with a State
+	// of "failed", and then when the device is "reconnected" or "stopped".
+	EventDeviceState = "device_state"
+
+	// EventTrigger occurs when device output matches a configured trigger,
+	// such as a pattern which interrupts a bootloader.
+	EventTrigger = "trigger"
+
+	// EventApprovalRequest occurs when a session to a device configured by
+	// ServerConfig.RequireApproval waits for approval, with a Message which
+	// describes how to approve it.
+	EventApprovalRequest = "approval_request"
+)
+
+// An Event is a server event streamed by the EventsSubsystem.
+type Event struct {
+	Time     time.Time `json:"time"`
+	Type     string    `json:"type"`
+	Device   string    `json:"device"`
+	Identity string    `json:"identity,omitempty"`
+	Address  string    `json:"address,omitempty"`
+	State    string    `json:"state,omitempty"`
+	Message  string    `json:"message,omitempty"`
+}
+
+// eventBuffer is the number of events buffered for each subscriber, after
+// which events are dropped rather than blocking the server.
+const eventBuffer = 64
+
+// events distributes Events to subscribers.
+type events struct {
+	mu   sync.Mutex
+	subs map[chan Event]struct{}
+}
+
+// subscribe returns a channel which receives Events until the returned
+// function is called.
+func (es *events) subscribe() (<-chan Event, func()) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+
+	if es.subs == nil {
+		es.subs = make(map[chan Event]struct{})
+	}
+
+	c := make(chan Event, eventBuffer)
+	es.subs[c] = struct{}{}
+
+	return c, func() {
+		es.mu.Lock()
+		defer es.mu.Unlock()
+
+		delete(es.subs, c)
+	}
+}
+
+// publish sends e to each subscriber which isn't too far behind.
+func (es *events) publish(e Event) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+
+	for c := range es.subs {
+		select {
+		case c <- e:
+		default:
+		}
+	}
+}
+
+// Publish streams e to clients of the EventsSubsystem which may access e's
+// device. If e's Time is zero, the current time is used. It is safe for
+// concurrent use with Serve.
+func (s *Server) Publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	s.events.publish(e)
+}
+
+// streamEvents handles the EventsSubsystem by writing each event the session's
+// identity may access as a JSON line.
+func (s *Server) streamEvents(session ssh.Session) {
+	s.sessionInfo(session)
+
+	c, done := s.events.subscribe()
+	defer done()
+
+	enc := json.NewEncoder(session)
+	for {
+		select {
+		case <-session.Context().Done():
+			return
+		case e := <-c:
+			if !s.allowed(session.Context(), e.Device) {
+				continue
+			}
+
+			if err := enc.Encode(e); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consrv
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestSSHEventsSubsystem(t *testing.T) {
+	foo := &testDevice{errC: make(chan error)}
+	devices := map[string]*MuxDevice{
+		"foo": NewMuxDevice(foo),
+		"bar": NewMuxDevice(&testDevice{}),
+	}
+
+	// The client's identity may access foo, but bar is reserved for another
+	// identity so its events are not streamed.
+	srv, addr := testServer(t, devices, map[string][]string{
+		"foo": nil,
+		"bar": {"other"},
+	})
+
+	s := testDial(t, addr, "consrv", mustKey(testHostPublic))
+	r, err := s.StdoutPipe()
+	if err != nil {
+		t.Fatalf("failed to get stdout: %v", err)
+	}
+
+	if err := s.RequestSubsystem(EventsSubsystem); err != nil {
+		t.Fatalf("failed to request subsystem: %v", err)
+	}
+
+	// Events are only streamed once the subsystem subscribes.
+	for {
+		srv.events.mu.Lock()
+		n := len(srv.events.subs)
+		srv.events.mu.Unlock()
+		if n > 0 {
+			break
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	dec := json.NewDecoder(r)
+	next := func() Event {
+		t.Helper()
+
+		var e Event
+		if err := dec.Decode(&e); err != nil {
+			t.Fatalf("failed to decode event: %v", err)
+		}
+
+		return e
+	}
+
+	srv.Publish(Event{Type: EventTrigger, Device: "bar", Message: "hidden"})
+	srv.Publish(Event{Type: EventTrigger, Device: "foo", Message: "interrupted autoboot"})
+
+	// Attach to foo and detach once the session has opened.
+	fs := testDial(t, addr, "foo", mustKey(testHostPublic))
+	out, err := fs.StderrPipe()
+	if err != nil {
+		t.Fatalf("failed to get stderr: %v", err)
+	}
+	if err := fs.Shell(); err != nil {
+		t.Fatalf("failed to start shell: %v", err)
+	}
+	if _, err := bufio.NewReader(out).ReadString('\n'); err != nil {
+		t.Fatalf("failed to read banner: %v", err)
+	}
+	_ = fs.Close()
+
+	var got []Event
+	for i := 0; i < 3; i++ {
+		got = append(got, next())
+	}
+
+	// Fail foo only once its session has closed, so the order of events is
+	// fixed.
+	foo.errC <- errors.New("input/output error")
+	for i := 0; i < 2; i++ {
+		got = append(got, next())
+	}
+
+	want := []Event{
+		{Type: EventTrigger, Device: "foo", Message: "interrupted autoboot"},
+		{Type: EventSessionOpen, Device: "foo", Identity: "test"},
+		{Type: EventSessionClose, Device: "foo", Identity: "test"},
+		{Type: EventDeviceState, Device: "foo", State: "failed", Message: "input/output error"},
+		{Type: EventDeviceState, Device: "foo", State: "stopped", Message: "input/output error"},
+	}
+
+	if diff := cmp.Diff(want, got, cmpopts.IgnoreFields(Event{}, "Time", "Address")); diff != "" {
+		t.Fatalf("unexpected events (-want +got):\n%s", diff)
+	}
+
+	for _, e := range got {
+		if e.Time.IsZero() {
+			t.Fatalf("event has no timestamp: %+v", e)
+		}
+	}
+}
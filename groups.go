@@ -0,0 +1,279 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consrv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gliderlabs/ssh"
+)
+
+// Device states reported by DeviceStatus.
+const (
+	StateOK      = "ok"
+	StateFailed  = "failed"
+	StateStopped = "stopped"
+)
+
+// A DeviceStatus is the status of a device in a group configured by
+// ServerConfig.DeviceGroups.
+type DeviceStatus struct {
+	// Device is the name of the device.
+	Device string
+
+	// State is StateOK, or StateFailed or StateStopped if the device's most
+	// recent read or write failed.
+	State string
+
+	// Sessions is the number of SSH sessions attached to the device.
+	Sessions int
+
+	// Holder is the identity using the device, if Held is set.
+	Holder Holder
+	Held   bool
+}
+
+// A PowerCycleResult is the result of power cycling a device of a group.
+type PowerCycleResult struct {
+	Device string
+	Err    error
+}
+
+// ErrUnknownGroup is returned when a device group is not configured.
+var ErrUnknownGroup = errors.New("unknown group")
+
+// powerCycleTimeout bounds the time ServerConfig.PowerCycle may take for each
+// device.
+const powerCycleTimeout = time.Minute
+
+// deviceStates tracks the most recent state of each device's Mux.
+type deviceStates struct {
+	mu sync.Mutex
+	m  map[string]string
+}
+
+// set sets the state of device.
+func (ds *deviceStates) set(device, state string) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	if ds.m == nil {
+		ds.m = make(map[string]string)
+	}
+	ds.m[device] = state
+}
+
+// get returns the state of device.
+func (ds *deviceStates) get(device string) string {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	if state, ok := ds.m[device]; ok {
+		return state
+	}
+
+	return StateOK
+}
+
+// group returns the devices in group which the session with ctx may access,
+// or all devices in group if ctx is nil.
+func (s *Server) group(ctx ssh.Context, group string) ([]string, error) {
+	var devices []string
+	for _, d := range s.groups[group] {
+		if _, ok := s.devices[d]; !ok || (ctx != nil && !s.allowed(ctx, d)) {
+			continue
+		}
+
+		devices = append(devices, d)
+	}
+	if len(devices) == 0 {
+		return nil, fmt.Errorf("%w %q", ErrUnknownGroup, group)
+	}
+
+	return devices, nil
+}
+
+// GroupStatus returns the status of each device in group, sorted by device
+// name. It returns ErrUnknownGroup if group has no devices. It is safe for
+// concurrent use with Serve.
+func (s *Server) GroupStatus(group string) ([]DeviceStatus, error) {
+	devices, err := s.group(nil, group)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.groupStatus(devices), nil
+}
+
+// groupStatus returns the status of each device in devices.
+func (s *Server) groupStatus(devices []string) []DeviceStatus {
+	ss := make([]DeviceStatus, 0, len(devices))
+	for _, d := range devices {
+		h, ok := s.Holder(d)
+		ss = append(ss, DeviceStatus{
+			Device:   d,
+			State:    s.states.get(d),
+			Sessions: s.Sessions(d),
+			Holder:   h,
+			Held:     ok,
+		})
+	}
+
+	return ss
+}
+
+// PowerCycleGroup power cycles each device in group with
+// ServerConfig.PowerCycle on behalf of identity, notifying the sessions
+// attached to each device first. It returns ErrUnknownGroup if group has no
+// devices, and an error if power cycling is not configured. It is safe for
+// concurrent use with Serve.
+func (s *Server) PowerCycleGroup(ctx context.Context, group, identity string) ([]PowerCycleResult, error) {
+	devices, err := s.group(nil, group)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.powerCycle(ctx, group, identity, devices)
+}
+
+// powerCycle power cycles devices in group on behalf of identity.
+func (s *Server) powerCycle(ctx context.Context, group, identity string, devices []string) ([]PowerCycleResult, error) {
+	if s.powerCycleFn == nil {
+		return nil, errors.New("power cycling is not configured")
+	}
+
+	rs := make([]PowerCycleResult, 0, len(devices))
+	for _, d := range devices {
+		s.Notify(d, "%s is power cycling %q with group %q", identity, d, group)
+
+		ctx, cancel := context.WithTimeout(ctx, powerCycleTimeout)
+		err := s.powerCycleFn(ctx, d)
+		cancel()
+
+		if err != nil {
+			s.el.Printf("%s: failed to power cycle device %q in group %q: %v", identity, d, group, err)
+		} else {
+			s.ll.Printf("%s: power cycled device %q in group %q", identity, d, group)
+		}
+		rs = append(rs, PowerCycleResult{Device: d, Err: err})
+	}
+
+	return rs, nil
+}
+
+// groupCommand runs the group command args for identity id with public key
+// fingerprint f:
+//
+//	group status <group>
+//	group watch <group>
+//	group power-cycle <group>
+func (s *Server) groupCommand(session ssh.Session, id, f string, args []string) error {
+	if len(args) == 0 {
+		// List the groups with devices the session may access.
+		for _, g := range slices.Sorted(maps.Keys(s.groups)) {
+			if devices, err := s.group(session.Context(), g); err == nil {
+				fmt.Fprintf(session, "%s devices=%q\n", g, strings.Join(devices, ","))
+			}
+		}
+		return nil
+	}
+	if len(args) != 2 {
+		return fmt.Errorf("expected 0 or 2 arguments, but got %d", len(args))
+	}
+
+	group := args[1]
+	devices, err := s.group(session.Context(), group)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "status":
+		for _, ds := range s.groupStatus(devices) {
+			var holder string
+			if ds.Held {
+				holder = fmt.Sprintf("%s (%s)", ds.Holder.Identity, ds.Holder.Source)
+			}
+
+			fmt.Fprintf(session, "%s state=%q sessions=%d holder=%q\n", ds.Device, ds.State, ds.Sessions, holder)
+		}
+		return nil
+	case "watch":
+		return s.watch(session, f, devices)
+	case "power-cycle":
+		return s.powerCycleCommand(session, id, group, devices)
+	default:
+		return fmt.Errorf("unknown group command %q", args[0])
+	}
+}
+
+// powerCycleCommand power cycles devices in group for identity id after the
+// client confirms by entering the group's name. Every device in the group must
+// be accessible, not reserved by another identity, and writable by id.
+func (s *Server) powerCycleCommand(session ssh.Session, id, group string, devices []string) error {
+	if s.powerCycleFn == nil {
+		return errors.New("power cycling is not configured")
+	}
+	if all, _ := s.group(nil, group); len(all) != len(devices) {
+		return fmt.Errorf("group %q has devices which %s may not access", group, id)
+	}
+
+	for _, d := range devices {
+		if r, ok := s.reserved.get(d); ok && r.Identity != id {
+			return fmt.Errorf("%q is reserved by %s until %s", d, r.Identity, formatUntil(r.Until, s.reserved.now()))
+		}
+		if s.readOnly[d] && !s.writes.allowed(d, id) {
+			return fmt.Errorf("write access to %q is required", d)
+		}
+	}
+
+	fmt.Fprintf(session, "consrv> power cycle %d devices in group %q (%s)? Enter the group name to confirm: ",
+		len(devices), group, strings.Join(devices, ", "))
+	_, _, echo := session.Pty()
+	in, err := readReason(session, echo)
+	if err != nil {
+		return err
+	}
+	if in != group {
+		return errors.New("power cycle not confirmed")
+	}
+
+	rs, err := s.powerCycle(session.Context(), group, id, devices)
+	if err != nil {
+		return err
+	}
+
+	var failed int
+	for _, r := range rs {
+		if r.Err != nil {
+			failed++
+			fmt.Fprintf(session, "consrv> failed to power cycle %q: %v\n", r.Device, r.Err)
+			continue
+		}
+
+		fmt.Fprintf(session, "consrv> power cycled %q\n", r.Device)
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to power cycle %d of %d devices", failed, len(rs))
+	}
+
+	return nil
+}
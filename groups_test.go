@@ -0,0 +1,157 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consrv
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSSHGroups(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		cycled  []string
+		devices = map[string]*MuxDevice{
+			"foo":    NewMuxDevice(&testDevice{}),
+			"bar":    NewMuxDevice(&testDevice{}),
+			"baz":    NewMuxDevice(&testDevice{}),
+			"secret": NewMuxDevice(&testDevice{}),
+		}
+	)
+
+	srv, addr := testServe(t, ServerConfig{
+		HostKey: []byte(strings.TrimSpace(testHostPrivate)),
+		Devices: devices,
+		Identities: mustIdentities([]Identity{
+			{Name: "test", PublicKey: mustKey(testClientPublic)},
+			{Name: "other", PublicKey: mustKey(testPublicA)},
+		}, map[string][]string{"secret": {"other"}}),
+		DeviceGroups: map[string][]string{
+			"rack1": {"foo", "bar"},
+			"rack2": {"baz", "secret"},
+		},
+		PowerCycle: func(_ context.Context, device string) error {
+			mu.Lock()
+			defer mu.Unlock()
+
+			cycled = append(cycled, device)
+			if device == "bar" {
+				return errors.New("PDU outlet offline")
+			}
+			return nil
+		},
+	})
+
+	tests := []struct {
+		name, cmd, in, out string
+	}{
+		{
+			name: "list",
+			cmd:  "group",
+			out:  "rack1 devices=\"foo,bar\"\nrack2 devices=\"baz\"\n",
+		},
+		{
+			name: "status",
+			cmd:  "group status rack1",
+			out:  "foo state=\"ok\" sessions=0 holder=\"\"\nbar state=\"ok\" sessions=0 holder=\"\"\n",
+		},
+		{
+			name: "status inaccessible",
+			cmd:  "group status rack2",
+			out:  "baz state=\"ok\" sessions=0 holder=\"\"\n",
+		},
+		{
+			name: "unknown group",
+			cmd:  "group status rack3",
+			out:  "consrv> group: unknown group \"rack3\"\n",
+		},
+		{
+			name: "unknown command",
+			cmd:  "group reboot rack1",
+			out:  "consrv> group: unknown group command \"reboot\"\n",
+		},
+		{
+			name: "power cycle inaccessible",
+			cmd:  "group power-cycle rack2",
+			out:  "consrv> group: group \"rack2\" has devices which test may not access\n",
+		},
+		{
+			name: "power cycle not confirmed",
+			cmd:  "group power-cycle rack1",
+			in:   "rack2\n",
+			out: "consrv> power cycle 2 devices in group \"rack1\" (foo, bar)? Enter the group name to confirm: " +
+				"consrv> group: power cycle not confirmed\n",
+		},
+		{
+			name: "power cycle",
+			cmd:  "group power-cycle rack1",
+			in:   "rack1\n",
+			out: "consrv> power cycle 2 devices in group \"rack1\" (foo, bar)? Enter the group name to confirm: " +
+				"consrv> power cycled \"foo\"\n" +
+				"consrv> failed to power cycle \"bar\": PDU outlet offline\n" +
+				"consrv> group: failed to power cycle 1 of 2 devices\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := testDial(t, addr, "consrv", mustKey(testHostPublic))
+			s.Stdin = strings.NewReader(tt.in)
+
+			b, _ := s.CombinedOutput(tt.cmd)
+			if diff := cmp.Diff(tt.out, string(b)); diff != "" {
+				t.Fatalf("unexpected output (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	if diff := cmp.Diff([]string{"foo", "bar"}, cycled); diff != "" {
+		t.Fatalf("unexpected power cycled devices (-want +got):\n%s", diff)
+	}
+
+	// The API reports every device in a group, and reservations hold devices.
+	if _, err := testDial(t, addr, "consrv", mustKey(testHostPublic)).CombinedOutput("reserve baz 1h"); err != nil {
+		t.Fatalf("failed to reserve: %v", err)
+	}
+
+	got, err := srv.GroupStatus("rack2")
+	if err != nil {
+		t.Fatalf("failed to get group status: %v", err)
+	}
+
+	want := []DeviceStatus{
+		{
+			Device: "baz",
+			State:  StateOK,
+			Holder: Holder{Identity: "test", Source: HolderReservation},
+			Held:   true,
+		},
+		{
+			Device: "secret",
+			State:  StateOK,
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("unexpected group status (-want +got):\n%s", diff)
+	}
+
+	if _, err := srv.GroupStatus("rack3"); !errors.Is(err, ErrUnknownGroup) {
+		t.Fatalf("expected unknown group, but got: %v", err)
+	}
+}
@@ -0,0 +1,169 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consrv
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gliderlabs/ssh"
+)
+
+// A HoneypotInput is input recorded from a client of the honeypot configured by
+// ServerConfig.Honeypot.
+type HoneypotInput struct {
+	// Addr and User are the client's address and SSH user name.
+	Addr net.Addr
+	User string
+
+	// Input is a line of input, which is a password if Password is set.
+	Input    string
+	Password bool
+}
+
+const (
+	// honeypotTimeout is the maximum duration of a honeypot session.
+	honeypotTimeout = 2 * time.Minute
+
+	// honeypotDelay slows down each failed login in a honeypot session.
+	honeypotDelay = 3 * time.Second
+
+	// maxHoneypotLogins is the number of logins a honeypot session may attempt
+	// before it is closed.
+	maxHoneypotLogins = 3
+)
+
+// A honeypot presents a fake console to SSH clients which authenticate with a
+// password, since passwords never authenticate an identity.
+type honeypot struct {
+	record         func(in HoneypotInput)
+	timeout, delay time.Duration
+
+	mu     sync.Mutex
+	active set[string]
+}
+
+// A honeypotKey is the ssh.Context key which marks a honeypot connection.
+type honeypotKey struct{}
+
+// acquire reserves the only honeypot session for the client at addr, reporting
+// whether it was successful.
+func (hp *honeypot) acquire(addr string) bool {
+	hp.mu.Lock()
+	defer hp.mu.Unlock()
+
+	if hp.active.has(addr) {
+		return false
+	}
+
+	hp.active.add(addr)
+	return true
+}
+
+// release releases the honeypot session for the client at addr.
+func (hp *honeypot) release(addr string) {
+	hp.mu.Lock()
+	defer hp.mu.Unlock()
+
+	delete(hp.active, addr)
+}
+
+// passwordAuth accepts any password, so that the client only reaches the
+// honeypot.
+func (s *Server) passwordAuth(ctx ssh.Context, password string) bool {
+	ctx.SetValue(honeypotKey{}, true)
+
+	s.ll.Printf("%s: accepted password authentication for honeypot user %q", addrString(ctx.RemoteAddr()), ctx.User())
+	s.recordHoneypot(ctx.RemoteAddr(), ctx.User(), password, true)
+	return true
+}
+
+// guard wraps an SSH session handler so that honeypot sessions are handled by
+// the honeypot instead, and never reach a device or command.
+func (s *Server) guard(h ssh.Handler) func(ssh.Session) {
+	return func(session ssh.Session) {
+		if ok, _ := session.Context().Value(honeypotKey{}).(bool); ok {
+			s.honeypotSession(session)
+			return
+		}
+
+		h(session)
+	}
+}
+
+// honeypotSession presents a fake login prompt to session, recording each
+// attempt, until the client gives up or reaches the honeypot's limits.
+func (s *Server) honeypotSession(session ssh.Session) {
+	defer func() { _ = session.Exit(1) }()
+
+	addr := addrString(session.RemoteAddr())
+	if !s.hp.acquire(addr) {
+		s.mm.honeypotSessions(1.0, "limited")
+		s.ll.Printf("%s: closing honeypot session, another session is active", addr)
+		return
+	}
+	defer s.hp.release(addr)
+
+	s.mm.honeypotSessions(1.0, "opened")
+	s.ll.Printf("%s: opened honeypot session for %q", addr, session.User())
+	defer s.ll.Printf("%s: closed honeypot session for %q", addr, session.User())
+
+	ctx, cancel := context.WithTimeout(session.Context(), s.hp.timeout)
+	defer cancel()
+	stop := context.AfterFunc(ctx, func() { _ = session.Close() })
+	defer stop()
+
+	_, _, echo := session.Pty()
+	for i := 0; i < maxHoneypotLogins; i++ {
+		fmt.Fprintf(session, "\r\n%s login: ", session.User())
+		user, err := readReason(session, echo)
+		if err != nil {
+			return
+		}
+		s.recordHoneypot(session.RemoteAddr(), session.User(), user, false)
+
+		fmt.Fprint(session, "Password: ")
+		password, err := readReason(session, false)
+		if err != nil {
+			return
+		}
+		s.recordHoneypot(session.RemoteAddr(), session.User(), password, true)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(s.hp.delay):
+		}
+		fmt.Fprint(session, "\r\nLogin incorrect\r\n")
+	}
+}
+
+// recordHoneypot records input from a honeypot client.
+func (s *Server) recordHoneypot(addr net.Addr, user, input string, password bool) {
+	kind := "line"
+	if password {
+		kind = "password"
+	}
+	s.mm.honeypotInputs(1.0, kind)
+
+	s.hp.record(HoneypotInput{
+		Addr:     addr,
+		User:     user,
+		Input:    input,
+		Password: password,
+	})
+}
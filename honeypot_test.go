@@ -0,0 +1,160 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consrv
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestServerHoneypot(t *testing.T) {
+	inputC := make(chan HoneypotInput, 8)
+	srv, addr := testServe(t, ServerConfig{
+		HostKey: []byte(strings.TrimSpace(testHostPrivate)),
+		Devices: map[string]*MuxDevice{"foo": NewMuxDevice(&testDevice{})},
+		Identities: mustIdentities([]Identity{{
+			Name:      "test",
+			PublicKey: mustKey(testClientPublic),
+		}}, nil),
+		Honeypot: func(in HoneypotInput) {
+			in.Addr = nil
+			inputC <- in
+		},
+	})
+	srv.hp.delay = 0
+
+	// Passwords are accepted for any user, including a device.
+	c, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            "foo",
+		Auth:            []ssh.AuthMethod{ssh.Password("hunter2")},
+		HostKeyCallback: ssh.FixedHostKey(mustKey(testHostPublic)),
+	})
+	if err != nil {
+		t.Fatalf("failed to dial SSH: %v", err)
+	}
+	defer c.Close()
+
+	s, err := c.NewSession()
+	if err != nil {
+		t.Fatalf("failed to create SSH session: %v", err)
+	}
+	defer s.Close()
+
+	// Only one honeypot session is permitted for each client.
+	s2, err := c.NewSession()
+	if err != nil {
+		t.Fatalf("failed to create SSH session: %v", err)
+	}
+	defer s2.Close()
+
+	// Commands are never run for honeypot sessions.
+	stdin, err := s.StdinPipe()
+	if err != nil {
+		t.Fatalf("failed to get stdin: %v", err)
+	}
+	stdout, err := s.StdoutPipe()
+	if err != nil {
+		t.Fatalf("failed to get stdout: %v", err)
+	}
+	if err := s.Start("attach foo"); err != nil {
+		t.Fatalf("failed to start command: %v", err)
+	}
+	if _, err := io.WriteString(stdin, "root\nroot\n"); err != nil {
+		t.Fatalf("failed to write input: %v", err)
+	}
+
+	want := "\r\nfoo login: Password: \r\nLogin incorrect\r\n\r\nfoo login: "
+	b := make([]byte, len(want))
+	if _, err := io.ReadFull(stdout, b); err != nil {
+		t.Fatalf("failed to read output: %v", err)
+	}
+	if diff := cmp.Diff(want, string(b)); diff != "" {
+		t.Fatalf("unexpected output (-want +got):\n%s", diff)
+	}
+
+	if b, err := s2.CombinedOutput(""); err == nil || len(b) > 0 {
+		t.Fatalf("expected a second session to be closed, but got: %v: %q", err, b)
+	}
+
+	var got []HoneypotInput
+	for range 3 {
+		got = append(got, <-inputC)
+	}
+
+	wantIn := []HoneypotInput{
+		{User: "foo", Input: "hunter2", Password: true},
+		{User: "foo", Input: "root"},
+		{User: "foo", Input: "root", Password: true},
+	}
+	if diff := cmp.Diff(wantIn, got); diff != "" {
+		t.Fatalf("unexpected inputs (-want +got):\n%s", diff)
+	}
+}
+
+func TestServerHoneypotUnknownDevice(t *testing.T) {
+	inputC := make(chan HoneypotInput, 8)
+	srv, addr := testServe(t, ServerConfig{
+		HostKey: []byte(strings.TrimSpace(testHostPrivate)),
+		Devices: map[string]*MuxDevice{"foo": NewMuxDevice(&testDevice{})},
+		Identities: mustIdentities([]Identity{{
+			Name:      "test",
+			PublicKey: mustKey(testClientPublic),
+		}}, nil),
+		Honeypot: func(in HoneypotInput) {
+			in.Addr = nil
+			inputC <- in
+		},
+	})
+	srv.hp.delay = 0
+
+	// An identity which requests an unknown device sees the same fake console
+	// as a password client, rather than an immediate exit.
+	s := testDial(t, addr, "bar", mustKey(testHostPublic))
+	stdin, err := s.StdinPipe()
+	if err != nil {
+		t.Fatalf("failed to get stdin: %v", err)
+	}
+	stdout, err := s.StdoutPipe()
+	if err != nil {
+		t.Fatalf("failed to get stdout: %v", err)
+	}
+	if err := s.Shell(); err != nil {
+		t.Fatalf("failed to start shell: %v", err)
+	}
+	if _, err := io.WriteString(stdin, "root\nroot\n"); err != nil {
+		t.Fatalf("failed to write input: %v", err)
+	}
+
+	want := "\r\nbar login: Password: \r\nLogin incorrect\r\n\r\nbar login: "
+	b := make([]byte, len(want))
+	if _, err := io.ReadFull(stdout, b); err != nil {
+		t.Fatalf("failed to read output: %v", err)
+	}
+	if diff := cmp.Diff(want, string(b)); diff != "" {
+		t.Fatalf("unexpected output (-want +got):\n%s", diff)
+	}
+
+	got := []HoneypotInput{<-inputC, <-inputC}
+	wantIn := []HoneypotInput{
+		{User: "bar", Input: "root"},
+		{User: "bar", Input: "root", Password: true},
+	}
+	if diff := cmp.Diff(wantIn, got); diff != "" {
+		t.Fatalf("unexpected inputs (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,341 @@
+// Copyright 2020-2022 Matt Layher and Michael Stapelberg
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consrv
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/gliderlabs/ssh"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// An Identity is a named SSH public key which may authenticate against a
+// Server. If PublicKey is nil, the identity may only authenticate with an SSH
+// certificate from an authority trusted with TrustAuthority.
+type Identity struct {
+	Name      string
+	PublicKey ssh.PublicKey
+}
+
+// Identities configures a set of identities which may be used for either
+// per-device or global authentication.
+type Identities struct {
+	perDevice map[string]set[string]
+	denied    map[string]set[string]
+	global    set[string]
+
+	// Fingerprints of the certificate authorities whose user certificates
+	// authenticate the identities named by their principals.
+	authorities set[string]
+
+	// The namespaces of devices by name and identities by fingerprint.
+	deviceNamespaces   map[string]string
+	identityNamespaces map[string]string
+
+	// Maps fingerprint back to friendly name for logs, and friendly name to
+	// fingerprint for denials.
+	toName        map[string]string
+	toFingerprint map[string]string
+}
+
+// A set is a unique set of T.
+type set[T comparable] map[T]struct{}
+
+// add adds t to the set.
+func (s set[T]) add(t T) { s[t] = struct{}{} }
+
+// has returns if t is in the set.
+func (s set[T]) has(t T) bool {
+	_, ok := s[t]
+	return ok
+}
+
+// NewIdentities creates Identities from a list of global identities and a map
+// of device names to the names of the identities which may access them. Every
+// identity may access a device which is not present in devices or which has
+// no identities configured. If ll is not nil, the configured identities are
+// logged.
+func NewIdentities(ids []Identity, devices map[string][]string, ll *log.Logger) (*Identities, error) {
+	if ll == nil {
+		ll = log.New(io.Discard, "", 0)
+	}
+
+	// Set up relationships between devices and the identities which are
+	// authorized to access them.
+	out := Identities{
+		perDevice: make(map[string]set[string]),
+		denied:    make(map[string]set[string]),
+		global:    make(set[string]),
+
+		authorities: make(set[string]),
+
+		deviceNamespaces:   make(map[string]string),
+		identityNamespaces: make(map[string]string),
+
+		toName:        make(map[string]string),
+		toFingerprint: make(map[string]string),
+	}
+
+	// Configure global identities which can access all devices unless
+	// device-specific identities are configured.
+	known := make(map[string]string)
+	for _, id := range ids {
+		var f string
+		if id.PublicKey != nil {
+			f = gossh.FingerprintSHA256(id.PublicKey)
+			ll.Printf("added identity %q: %s", id.Name, f)
+		} else {
+			// There is no key to fingerprint, but the name is unique and
+			// can't collide with a fingerprint.
+			f = certificatePrefix + id.Name
+			ll.Printf("added certificate identity %q", id.Name)
+		}
+
+		known[id.Name] = f
+		out.global.add(f)
+		out.toName[f] = id.Name
+		out.toFingerprint[id.Name] = f
+	}
+
+	// Iterate in a stable order so log output is predictable.
+	for _, name := range slices.Sorted(maps.Keys(devices)) {
+		if len(devices[name]) == 0 {
+			// Let the user know that any configured identity will be able to
+			// access this device.
+			ll.Printf("warning: all identities allowed for device %q", name)
+			continue
+		}
+
+		if out.perDevice[name] == nil {
+			out.perDevice[name] = make(set[string])
+		}
+
+		for _, id := range devices[name] {
+			f, ok := known[id]
+			if !ok {
+				return nil, fmt.Errorf("device %q is configured with unknown identity %q", name, id)
+			}
+
+			// This device will only accept authentication for a specific set
+			// of identities.
+			ll.Printf("identity %q configured for device %q", id, name)
+			out.perDevice[name].add(f)
+		}
+	}
+
+	return &out, nil
+}
+
+// Deny prevents the named identities from accessing device, even if they are
+// otherwise allowed to access it. Deny must not be called once ids is in use
+// by a Server.
+func (ids *Identities) Deny(device string, names []string) error {
+	for _, name := range names {
+		f, ok := ids.toFingerprint[name]
+		if !ok {
+			return fmt.Errorf("device %q is configured with unknown denied identity %q", device, name)
+		}
+
+		if ids.denied[device] == nil {
+			ids.denied[device] = make(set[string])
+		}
+		ids.denied[device].add(f)
+	}
+
+	return nil
+}
+
+// Namespace places devices and the named identities in namespace. Identities in
+// a namespace may only access the devices in the same namespace, and devices in
+// a namespace may only be accessed by the identities in it, so that namespaces
+// are invisible to one another. Likewise, identities and devices in no
+// namespace may only access each other. Identities in any namespace may use
+// the Server's commands. Namespace must not be called once ids is in use by a
+// Server.
+func (ids *Identities) Namespace(namespace string, devices, names []string) error {
+	if namespace == "" {
+		return errors.New("namespace must not be empty")
+	}
+
+	for _, name := range names {
+		f, ok := ids.toFingerprint[name]
+		if !ok {
+			return fmt.Errorf("namespace %q is configured with unknown identity %q", namespace, name)
+		}
+		if ns, ok := ids.identityNamespaces[f]; ok && ns != namespace {
+			return fmt.Errorf("identity %q is already in namespace %q", name, ns)
+		}
+
+		ids.identityNamespaces[f] = namespace
+	}
+
+	for _, d := range devices {
+		if ns, ok := ids.deviceNamespaces[d]; ok && ns != namespace {
+			return fmt.Errorf("device %q is already in namespace %q", d, ns)
+		}
+
+		ids.deviceNamespaces[d] = namespace
+	}
+
+	return nil
+}
+
+// certificatePrefix prefixes the names of identities which have no public key
+// in place of a fingerprint.
+const certificatePrefix = "certificate:"
+
+// TrustAuthority allows SSH user certificates signed by the certificate
+// authority key ca to authenticate as the identities named by their valid
+// principals. TrustAuthority must not be called once ids is in use by a
+// Server.
+func (ids *Identities) TrustAuthority(ca ssh.PublicKey) {
+	ids.authorities.add(gossh.FingerprintSHA256(ca))
+}
+
+// Authenticate determines if the specified user and public key combination are
+// able to authenticate against a device's configuration. If so, the friendly
+// name of the identity is also returned for logging.
+//
+// The client's address is unknown, so certificates restricted to source
+// addresses are rejected.
+func (ids *Identities) Authenticate(user string, key ssh.PublicKey) (string, bool) {
+	return ids.authenticate(user, key, true, nil)
+}
+
+// authenticate implements Authenticate. If device is false, user names the
+// Server's commands rather than a device, and namespaces do not apply. addr is
+// the client's address, if known.
+func (ids *Identities) authenticate(user string, key ssh.PublicKey, device bool, addr net.Addr) (string, bool) {
+	f := gossh.FingerprintSHA256(key)
+	if cert, ok := key.(*gossh.Certificate); ok {
+		name, ok := ids.certificate(cert, addr)
+		if !ok {
+			return "", false
+		}
+
+		f = ids.toFingerprint[name]
+	}
+
+	allowed := ids.allowed
+	if !device {
+		allowed = ids.permitted
+	}
+	if !allowed(user, f) {
+		return "", false
+	}
+
+	return ids.toName[f], true
+}
+
+// certificate returns the name of the first identity named by a principal of
+// cert, if cert is a currently valid user certificate from a trusted authority
+// which may be used from addr.
+func (ids *Identities) certificate(cert *gossh.Certificate, addr net.Addr) (string, bool) {
+	if cert.CertType != gossh.UserCert || !ids.authorities.has(gossh.FingerprintSHA256(cert.SignatureKey)) {
+		return "", false
+	}
+
+	// CheckCert leaves source-address to the caller.
+	if sa, ok := cert.CriticalOptions[sourceAddressOption]; ok && !sourceAddressAllowed(sa, addr) {
+		return "", false
+	}
+
+	var cc gossh.CertChecker
+	for _, p := range cert.ValidPrincipals {
+		if _, ok := ids.toFingerprint[p]; !ok {
+			continue
+		}
+
+		// Verifies the signature, validity period, and critical options.
+		if err := cc.CheckCert(p, cert); err == nil {
+			return p, true
+		}
+	}
+
+	return "", false
+}
+
+// sourceAddressOption is the certificate critical option which restricts the
+// addresses a certificate may be used from.
+const sourceAddressOption = "source-address"
+
+// sourceAddressAllowed reports whether addr matches the comma-separated list
+// of addresses and CIDR prefixes in the source-address critical option sa.
+func sourceAddressAllowed(sa string, addr net.Addr) bool {
+	if addr == nil {
+		return false
+	}
+
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return false
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	ip = ip.Unmap()
+
+	for _, s := range strings.Split(sa, ",") {
+		if p, err := netip.ParsePrefix(s); err == nil {
+			if p.Contains(ip) {
+				return true
+			}
+			continue
+		}
+
+		if a, err := netip.ParseAddr(s); err == nil && a.Unmap() == ip {
+			return true
+		}
+	}
+
+	return false
+}
+
+// allowed determines if the identity with public key fingerprint f may access
+// device.
+func (ids *Identities) allowed(device, f string) bool {
+	if ids.identityNamespaces[f] != ids.deviceNamespaces[device] {
+		// Namespaces are isolated from one another, regardless of any other
+		// configuration.
+		return false
+	}
+
+	return ids.permitted(device, f)
+}
+
+// permitted determines if the identity with public key fingerprint f may
+// access device, or the Server's commands, without regard to namespaces.
+func (ids *Identities) permitted(device, f string) bool {
+	if ids.denied[device].has(f) {
+		// Denials take precedence over any other configuration.
+		return false
+	}
+
+	if pd, ok := ids.perDevice[device]; ok {
+		// This device only allows specific identities.
+		return pd.has(f)
+	}
+
+	// All identities are permitted.
+	return ids.global.has(f)
+}
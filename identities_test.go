@@ -0,0 +1,466 @@
+// Copyright 2020-2022 Matt Layher and Michael Stapelberg
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consrv
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	testPublicA = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAII1jZURuUdJ7EwKgTDxKzGSvtEeNeraLS9KeZZMoD0V/ test A"
+	testPublicB = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIKjKqdP/zqOKQiCUoG95vfW0wR+gZUEACqp3DIAKE6Xj test B"
+	testPublicC = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOd5NRST/MRc1BRG5avpdx9O5y7UadHsL4pD8fBTKqoG test C"
+)
+
+type idPair struct {
+	User string
+	Key  ssh.PublicKey
+}
+
+func Test_identities(t *testing.T) {
+	tests := []struct {
+		name        string
+		ids         *Identities
+		allow, deny []idPair
+	}{
+		{
+			name: "empty",
+			ids:  mustIdentities(nil, nil),
+			deny: []idPair{
+				{
+					User: "foo",
+					Key:  mustKey(testPublicA),
+				},
+				{
+					User: "bar",
+					Key:  mustKey(testPublicB),
+				},
+				{
+					User: "baz",
+					Key:  mustKey(testPublicC),
+				},
+			},
+		},
+		{
+			name: "global",
+			ids: mustIdentities(
+				[]Identity{{
+					Name:      "test A",
+					PublicKey: mustKey(testPublicA),
+				}},
+				map[string][]string{
+					"foo": nil,
+					"bar": nil,
+				},
+			),
+			allow: []idPair{
+				{
+					User: "foo",
+					Key:  mustKey(testPublicA),
+				},
+				{
+					User: "bar",
+					Key:  mustKey(testPublicA),
+				},
+			},
+			deny: []idPair{
+				{
+					User: "foo",
+					Key:  mustKey(testPublicB),
+				},
+				{
+					User: "bar",
+					Key:  mustKey(testPublicB),
+				},
+			},
+		},
+		{
+			name: "per-device",
+			ids: mustIdentities(
+				[]Identity{
+					{
+						Name:      "a",
+						PublicKey: mustKey(testPublicA),
+					},
+					{
+						Name:      "b",
+						PublicKey: mustKey(testPublicB),
+					},
+				},
+				map[string][]string{
+					"foo": {"a"},
+					"bar": {"b"},
+					"baz": {"a", "b"},
+				},
+			),
+			allow: []idPair{
+				{
+					User: "foo",
+					Key:  mustKey(testPublicA),
+				},
+				{
+					User: "bar",
+					Key:  mustKey(testPublicB),
+				},
+				{
+					User: "baz",
+					Key:  mustKey(testPublicA),
+				},
+				{
+					User: "baz",
+					Key:  mustKey(testPublicB),
+				},
+			},
+			deny: []idPair{
+				{
+					User: "foo",
+					Key:  mustKey(testPublicB),
+				},
+				{
+					User: "bar",
+					Key:  mustKey(testPublicA),
+				},
+				{
+					User: "baz",
+					Key:  mustKey(testPublicC),
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, id := range tt.allow {
+				if _, ok := tt.ids.Authenticate(id.User, id.Key); !ok {
+					t.Fatalf("expected user %q to successfully authenticate", id.User)
+				}
+			}
+
+			for _, id := range tt.deny {
+				if _, ok := tt.ids.Authenticate(id.User, id.Key); ok {
+					t.Fatalf("expected user %q to fail to authenticate", id.User)
+				}
+			}
+		})
+	}
+}
+
+func TestNewIdentitiesUnknownIdentity(t *testing.T) {
+	_, err := NewIdentities(
+		[]Identity{{
+			Name:      "a",
+			PublicKey: mustKey(testPublicA),
+		}},
+		map[string][]string{"foo": {"b"}},
+		nil,
+	)
+	if err == nil {
+		t.Fatal("expected an error, but none occurred")
+	}
+}
+
+func TestIdentitiesDeny(t *testing.T) {
+	var (
+		a = mustKey(testPublicA)
+		b = mustKey(testPublicB)
+	)
+
+	ids := mustIdentities([]Identity{
+		{Name: "a", PublicKey: a},
+		{Name: "b", PublicKey: b},
+	}, map[string][]string{"bar": {"a", "b"}})
+
+	// a is denied on foo, which otherwise allows all identities, and on bar,
+	// which explicitly allows a.
+	for _, device := range []string{"foo", "bar"} {
+		if err := ids.Deny(device, []string{"a"}); err != nil {
+			t.Fatalf("failed to deny identity: %v", err)
+		}
+
+		if _, ok := ids.Authenticate(device, a); ok {
+			t.Fatalf("expected a to be denied on %q", device)
+		}
+		if _, ok := ids.Authenticate(device, b); !ok {
+			t.Fatalf("expected b to be allowed on %q", device)
+		}
+	}
+
+	if _, ok := ids.Authenticate("baz", a); !ok {
+		t.Fatal("expected a to be allowed on baz")
+	}
+
+	if err := ids.Deny("foo", []string{"c"}); err == nil {
+		t.Fatal("expected an error denying an unknown identity, but none occurred")
+	}
+}
+
+func TestIdentitiesNamespace(t *testing.T) {
+	var (
+		a = mustKey(testPublicA)
+		b = mustKey(testPublicB)
+		c = mustKey(testPublicC)
+	)
+
+	ids := mustIdentities([]Identity{
+		{Name: "a", PublicKey: a},
+		{Name: "b", PublicKey: b},
+		{Name: "c", PublicKey: c},
+	}, map[string][]string{"bar": {"a", "b"}})
+
+	if err := ids.Namespace("red", []string{"foo"}, []string{"a"}); err != nil {
+		t.Fatalf("failed to configure namespace: %v", err)
+	}
+	if err := ids.Namespace("blue", []string{"bar"}, []string{"b"}); err != nil {
+		t.Fatalf("failed to configure namespace: %v", err)
+	}
+
+	tests := []struct {
+		device string
+		key    ssh.PublicKey
+		ok     bool
+	}{
+		{device: "foo", key: a, ok: true},
+		{device: "foo", key: b},
+		// bar explicitly allows a, but a is in another namespace.
+		{device: "bar", key: a},
+		{device: "bar", key: b, ok: true},
+		// baz is in no namespace, so only identities in no namespace may
+		// access it.
+		{device: "baz", key: a},
+		{device: "baz", key: b},
+		{device: "baz", key: c, ok: true},
+		{device: "foo", key: c},
+	}
+
+	for _, tt := range tests {
+		if _, ok := ids.Authenticate(tt.device, tt.key); ok != tt.ok {
+			t.Fatalf("unexpected authentication result for %q: %v", tt.device, ok)
+		}
+	}
+
+	// Commands are not a device, so identities in any namespace may use them.
+	for _, key := range []ssh.PublicKey{a, b, c} {
+		if _, ok := ids.authenticate("consrv", key, false, nil); !ok {
+			t.Fatal("identity was not permitted to use commands")
+		}
+	}
+
+	for _, fn := range []func() error{
+		func() error { return ids.Namespace("", nil, nil) },
+		func() error { return ids.Namespace("red", nil, []string{"d"}) },
+		func() error { return ids.Namespace("blue", nil, []string{"a"}) },
+		func() error { return ids.Namespace("blue", []string{"foo"}, nil) },
+	} {
+		if err := fn(); err == nil {
+			t.Fatal("expected an error configuring namespace, but none occurred")
+		}
+	}
+}
+
+func TestIdentitiesCertificate(t *testing.T) {
+	var (
+		ca    = mustSigner()
+		other = mustSigner()
+		a     = mustKey(testPublicA)
+	)
+
+	// c may only authenticate with a certificate, and only on bar.
+	ids := mustIdentities([]Identity{
+		{Name: "a", PublicKey: a},
+		{Name: "c"},
+	}, map[string][]string{"bar": {"c"}})
+	ids.TrustAuthority(ca.PublicKey())
+
+	now := time.Now()
+	tests := []struct {
+		name   string
+		user   string
+		signer ssh.Signer
+		cert   ssh.Certificate
+		addr   net.Addr
+		id     string
+		ok     bool
+	}{
+		{
+			name:   "OK first known principal",
+			user:   "foo",
+			signer: ca,
+			cert: ssh.Certificate{
+				CertType:        ssh.UserCert,
+				ValidPrincipals: []string{"unknown", "a"},
+			},
+			id: "a",
+			ok: true,
+		},
+		{
+			name:   "OK certificate identity",
+			user:   "bar",
+			signer: ca,
+			cert: ssh.Certificate{
+				CertType:        ssh.UserCert,
+				ValidPrincipals: []string{"c"},
+			},
+			id: "c",
+			ok: true,
+		},
+		{
+			name:   "device denies identity",
+			user:   "bar",
+			signer: ca,
+			cert: ssh.Certificate{
+				CertType:        ssh.UserCert,
+				ValidPrincipals: []string{"a"},
+			},
+		},
+		{
+			name:   "untrusted authority",
+			user:   "foo",
+			signer: other,
+			cert: ssh.Certificate{
+				CertType:        ssh.UserCert,
+				ValidPrincipals: []string{"a"},
+			},
+		},
+		{
+			name:   "host certificate",
+			user:   "foo",
+			signer: ca,
+			cert: ssh.Certificate{
+				CertType:        ssh.HostCert,
+				ValidPrincipals: []string{"a"},
+			},
+		},
+		{
+			name:   "no known principals",
+			user:   "foo",
+			signer: ca,
+			cert: ssh.Certificate{
+				CertType:        ssh.UserCert,
+				ValidPrincipals: []string{"unknown"},
+			},
+		},
+		{
+			name:   "expired",
+			user:   "foo",
+			signer: ca,
+			cert: ssh.Certificate{
+				CertType:        ssh.UserCert,
+				ValidPrincipals: []string{"a"},
+				ValidAfter:      uint64(now.Add(-2 * time.Hour).Unix()),
+				ValidBefore:     uint64(now.Add(-1 * time.Hour).Unix()),
+			},
+		},
+		{
+			name:   "OK source address",
+			user:   "foo",
+			signer: ca,
+			cert: ssh.Certificate{
+				CertType:        ssh.UserCert,
+				ValidPrincipals: []string{"a"},
+				Permissions: ssh.Permissions{
+					CriticalOptions: map[string]string{"source-address": "2001:db8::1,192.0.2.0/24"},
+				},
+			},
+			addr: &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 22},
+			id:   "a",
+			ok:   true,
+		},
+		{
+			name:   "wrong source address",
+			user:   "foo",
+			signer: ca,
+			cert: ssh.Certificate{
+				CertType:        ssh.UserCert,
+				ValidPrincipals: []string{"a"},
+				Permissions: ssh.Permissions{
+					CriticalOptions: map[string]string{"source-address": "2001:db8::1,192.0.2.0/24"},
+				},
+			},
+			addr: &net.TCPAddr{IP: net.IPv4(198, 51, 100, 1), Port: 22},
+		},
+		{
+			name:   "unknown source address",
+			user:   "foo",
+			signer: ca,
+			cert: ssh.Certificate{
+				CertType:        ssh.UserCert,
+				ValidPrincipals: []string{"a"},
+				Permissions: ssh.Permissions{
+					CriticalOptions: map[string]string{"source-address": "192.0.2.0/24"},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cert := tt.cert
+			cert.Key = a
+			if cert.ValidBefore == 0 {
+				cert.ValidBefore = ssh.CertTimeInfinity
+			}
+			if err := cert.SignCert(rand.Reader, tt.signer); err != nil {
+				t.Fatalf("failed to sign certificate: %v", err)
+			}
+
+			id, ok := ids.authenticate(tt.user, &cert, true, tt.addr)
+			if ok != tt.ok || id != tt.id {
+				t.Fatalf("unexpected authentication: got (%q, %t), want (%q, %t)", id, ok, tt.id, tt.ok)
+			}
+		})
+	}
+}
+
+func mustIdentities(ids []Identity, devices map[string][]string) *Identities {
+	out, err := NewIdentities(ids, devices, nil)
+	if err != nil {
+		panicf("failed to create identities: %v", err)
+	}
+
+	return out
+}
+
+func mustKey(s string) ssh.PublicKey {
+	k, _, _, _, err := ssh.ParseAuthorizedKey([]byte(s))
+	if err != nil {
+		panicf("failed to parse identity public key %q: %v", s, err)
+	}
+
+	return k
+}
+
+func panicf(format string, a ...any) {
+	panic(fmt.Sprintf(format, a...))
+}
+
+func mustSigner() ssh.Signer {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panicf("failed to generate key: %v", err)
+	}
+
+	s, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		panicf("failed to create signer: %v", err)
+	}
+
+	return s
+}
@@ -0,0 +1,78 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consrv
+
+import (
+	"net"
+	"sync"
+)
+
+// A connLimiter enforces limits on concurrent connections, globally and per
+// source IP address. A limit of 0 is unlimited.
+type connLimiter struct {
+	max, maxPerIP int
+
+	mu    sync.Mutex
+	n     int
+	perIP map[string]int
+}
+
+// acquire reserves a connection for ip. If a limit is reached, it returns the
+// name of the limit and false. Otherwise, the returned function must be called
+// once to release the connection.
+func (l *connLimiter) acquire(ip string) (func(), string, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.max > 0 && l.n >= l.max {
+		return nil, "global", false
+	}
+	if l.maxPerIP > 0 && l.perIP[ip] >= l.maxPerIP {
+		return nil, "per_ip", false
+	}
+
+	if l.perIP == nil {
+		l.perIP = make(map[string]int)
+	}
+
+	l.n++
+	l.perIP[ip]++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+
+			l.n--
+			l.perIP[ip]--
+			if l.perIP[ip] == 0 {
+				delete(l.perIP, ip)
+			}
+		})
+	}, "", true
+}
+
+// A limitedConn is a net.Conn which releases its reservation in a connLimiter
+// when closed.
+type limitedConn struct {
+	net.Conn
+	release func()
+}
+
+// Close implements net.Conn.
+func (c *limitedConn) Close() error {
+	c.release()
+	return c.Conn.Close()
+}
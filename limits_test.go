@@ -0,0 +1,103 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consrv
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_connLimiter(t *testing.T) {
+	type step struct {
+		ip      string
+		release int
+		limit   string
+	}
+
+	tests := []struct {
+		name          string
+		max, maxPerIP int
+		steps         []step
+	}{
+		{
+			name: "unlimited",
+			steps: []step{
+				{ip: "192.0.2.1"},
+				{ip: "192.0.2.1"},
+				{ip: "192.0.2.2"},
+			},
+		},
+		{
+			name: "global",
+			max:  2,
+			steps: []step{
+				{ip: "192.0.2.1"},
+				{ip: "192.0.2.2"},
+				{ip: "192.0.2.3", limit: "global"},
+				// Release the first connection to make room.
+				{ip: "192.0.2.3", release: 1},
+				{ip: "192.0.2.4", limit: "global"},
+			},
+		},
+		{
+			name:     "per IP",
+			max:      3,
+			maxPerIP: 1,
+			steps: []step{
+				{ip: "192.0.2.1"},
+				{ip: "192.0.2.1", limit: "per_ip"},
+				{ip: "192.0.2.2"},
+				{ip: "192.0.2.1", release: 1},
+				{ip: "192.0.2.1", limit: "per_ip"},
+			},
+		},
+		{
+			name:     "release twice",
+			maxPerIP: 1,
+			steps: []step{
+				{ip: "192.0.2.1"},
+				{ip: "192.0.2.2"},
+				// Releasing the first connection twice must not make room
+				// for a second connection from 192.0.2.1.
+				{ip: "192.0.2.1", release: 1},
+				{ip: "192.0.2.3", release: 1},
+				{ip: "192.0.2.1", limit: "per_ip"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &connLimiter{max: tt.max, maxPerIP: tt.maxPerIP}
+
+			var releases []func()
+			for i, s := range tt.steps {
+				if s.release > 0 {
+					releases[s.release-1]()
+				}
+
+				release, limit, ok := l.acquire(s.ip)
+				if diff := cmp.Diff(s.limit, limit); diff != "" {
+					t.Fatalf("unexpected limit for step %d (-want +got):\n%s", i, diff)
+				}
+				if ok != (s.limit == "") {
+					t.Fatalf("unexpected acquire result for step %d: %t", i, ok)
+				}
+
+				releases = append(releases, release)
+			}
+		})
+	}
+}
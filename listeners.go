@@ -0,0 +1,74 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consrv
+
+import (
+	"net"
+
+	"github.com/gliderlabs/ssh"
+)
+
+// ServeDevices is like Serve, but connections accepted on l may only access the
+// named devices, so that access to groups of devices can be segmented by
+// firewall rules for each listener. Access is otherwise determined by the
+// Server's Identities.
+func (s *Server) ServeDevices(l net.Listener, devices []string) error {
+	ds := make(set[string], len(devices))
+	for _, d := range devices {
+		ds.add(d)
+	}
+
+	return s.s.Serve(&deviceListener{Listener: l, devices: ds})
+}
+
+// A deviceListener is a net.Listener whose connections may only access a set
+// of devices.
+type deviceListener struct {
+	net.Listener
+	devices set[string]
+}
+
+// Accept implements net.Listener.
+func (l *deviceListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	return &deviceConn{Conn: c, devices: l.devices}, nil
+}
+
+// A deviceConn is a net.Conn accepted by a deviceListener.
+type deviceConn struct {
+	net.Conn
+	devices set[string]
+}
+
+// A devicesKey is the ssh.Context key for the devices which a connection
+// accepted by ServeDevices may access.
+type devicesKey struct{}
+
+// reachable determines if the connection for ctx may access device, according
+// to the listener which accepted it.
+func reachable(ctx ssh.Context, device string) bool {
+	ds, ok := ctx.Value(devicesKey{}).(set[string])
+	return !ok || ds.has(device)
+}
+
+// allowed determines if the session for ctx may access device, according to
+// both its identity and the listener which accepted its connection.
+func (s *Server) allowed(ctx ssh.Context, device string) bool {
+	f, _ := ctx.Value(fingerprintKey{}).(string)
+	return reachable(ctx, device) && s.identities().allowed(device, f)
+}
@@ -0,0 +1,82 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consrv
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/net/nettest"
+)
+
+func TestServerServeDevices(t *testing.T) {
+	srv, addr := testServer(t, map[string]*MuxDevice{
+		"foo": NewMuxDevice(&testDevice{}),
+		"bar": NewMuxDevice(&testDevice{}),
+	}, nil)
+
+	// Serve only foo on a second listener.
+	l, err := nettest.NewLocalListener("tcp")
+	if err != nil {
+		t.Fatalf("failed to create local listener: %v", err)
+	}
+	defer l.Close()
+
+	go func() { _ = srv.ServeDevices(l, []string{"foo"}) }()
+
+	list := func(addr string) []string {
+		t.Helper()
+
+		s := testDial(t, addr, "consrv", mustKey(testHostPublic))
+		r, err := s.StdoutPipe()
+		if err != nil {
+			t.Fatalf("failed to get stdout: %v", err)
+		}
+		if err := s.RequestSubsystem(ListSubsystem); err != nil {
+			t.Fatalf("failed to request subsystem: %v", err)
+		}
+
+		b, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("failed to read device list: %v", err)
+		}
+
+		return strings.Fields(string(b))
+	}
+
+	if diff := cmp.Diff([]string{"bar", "foo"}, list(addr)); diff != "" {
+		t.Fatalf("unexpected devices (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"foo"}, list(l.Addr().String())); diff != "" {
+		t.Fatalf("unexpected restricted devices (-want +got):\n%s", diff)
+	}
+
+	// Devices which aren't served by the listener reject authentication.
+	c, err := ssh.Dial("tcp", l.Addr().String(), testClientConfig(t, "bar", mustKey(testHostPublic)))
+	if err == nil {
+		_ = c.Close()
+		t.Fatal("expected an error dialing an unserved device, but none occurred")
+	}
+
+	out, err := testDial(t, l.Addr().String(), "consrv", mustKey(testHostPublic)).CombinedOutput("attach bar")
+	if err == nil {
+		t.Fatal("expected an error attaching to an unserved device, but none occurred")
+	}
+	if diff := cmp.Diff("consrv> attach: unknown device \"bar\"\n", string(out)); diff != "" {
+		t.Fatalf("unexpected output (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright 2020-2022 Matt Layher and Michael Stapelberg
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consrv
+
+import (
+	"io"
+	"sync/atomic"
+
+	"github.com/mdlayher/metricslite"
+)
+
+// metrics contains metrics for a Server.
+type metrics struct {
+	// Atomics must come first.
+	sessions int32
+
+	deviceAuthentications metricslite.Counter
+	deviceSessions        metricslite.Gauge
+	deviceSessionsTotal   metricslite.Counter
+	deviceUnknownSessions metricslite.Counter
+
+	honeypotInputs   metricslite.Counter
+	honeypotSessions metricslite.Counter
+
+	sessionInputBytes  metricslite.Counter
+	sessionOutputBytes metricslite.Counter
+
+	sshConnections        metricslite.Counter
+	sshConnectionsLimited metricslite.Counter
+	sshHandshakeFailures  metricslite.Counter
+	sshSessions           metricslite.Counter
+}
+
+// deviceHolderInfo is the name of the const metric which reports the holder of
+// each device.
+const deviceHolderInfo = "consrv_device_holder_info"
+
+// newMetrics creates metrics from m. The holders of each device are reported
+// by holders when m is gathered, since their labels change over time.
+func newMetrics(m metricslite.Interface, holders func() map[string]Holder) *metrics {
+	if m == nil {
+		m = metricslite.Discard()
+	}
+
+	m.ConstGauge(
+		deviceHolderInfo,
+		"Information about the identity using a serial console device, either by reservation or the longest attached SSH session.",
+		"name", "identity", "source",
+	)
+	m.OnConstScrape(func(metrics map[string]func(float64, ...string)) error {
+		for name, h := range holders() {
+			metrics[deviceHolderInfo](1.0, name, h.Identity, h.Source)
+		}
+
+		return nil
+	})
+
+	return &metrics{
+		deviceAuthentications: m.Counter(
+			"consrv_device_authentications_total",
+			"The total number of accepted and rejected SSH sessions for a serial console device.",
+			"name",
+		),
+
+		deviceSessions: m.Gauge(
+			"consrv_device_sessions",
+			"The number of active SSH sessions connected to a serial console device.",
+			"name",
+		),
+
+		deviceSessionsTotal: m.Counter(
+			"consrv_device_sessions_total",
+			"The total number of SSH sessions opened for a serial console device.",
+			"name",
+		),
+
+		deviceUnknownSessions: m.Counter(
+			"consrv_device_unknown_sessions_total",
+			"The total number of SSH sessions which attempted to open a non-existent device.",
+		),
+
+		honeypotInputs: m.Counter(
+			"consrv_honeypot_inputs_total",
+			"The total number of lines of input and passwords recorded from honeypot clients.",
+			"kind",
+		),
+
+		honeypotSessions: m.Counter(
+			"consrv_honeypot_sessions_total",
+			"The total number of honeypot sessions which were opened or closed by the honeypot's limits.",
+			"action",
+		),
+
+		sessionInputBytes: m.Counter(
+			"consrv_session_input_bytes_total",
+			"The total number of bytes written to a serial console device by SSH sessions, by identity.",
+			"name", "identity",
+		),
+
+		sessionOutputBytes: m.Counter(
+			"consrv_session_output_bytes_total",
+			"The total number of bytes read from a serial console device by SSH sessions, by identity.",
+			"name", "identity",
+		),
+
+		sshConnections: m.Counter(
+			"consrv_ssh_connections_total",
+			"The total number of TCP connections accepted by the SSH server.",
+		),
+
+		sshConnectionsLimited: m.Counter(
+			"consrv_ssh_connections_limited_total",
+			"The total number of TCP connections closed because they exceeded a connection limit.",
+			"limit",
+		),
+
+		sshHandshakeFailures: m.Counter(
+			"consrv_ssh_handshake_failures_total",
+			"The total number of SSH connections which failed to complete the handshake and authentication.",
+		),
+
+		sshSessions: m.Counter(
+			"consrv_ssh_sessions_total",
+			"The total number of SSH sessions opened, by authenticated public key algorithm and client version.",
+			"key_algorithm", "client_version",
+		),
+	}
+}
+
+func (m *metrics) newSession(name string) func() {
+	m.deviceSessionsTotal(1.0, name)
+	m.deviceSessions(float64(atomic.AddInt32(&m.sessions, 1)), name)
+	return func() {
+		m.deviceSessions(float64(atomic.AddInt32(&m.sessions, -1)), name)
+	}
+}
+
+// A countWriter is an io.Writer which adds the number of bytes written to a
+// counter.
+type countWriter struct {
+	w      io.Writer
+	c      metricslite.Counter
+	labels []string
+}
+
+// Write implements io.Writer.
+func (cw *countWriter) Write(b []byte) (int, error) {
+	n, err := cw.w.Write(b)
+	if n > 0 {
+		cw.c(float64(n), cw.labels...)
+	}
+
+	return n, err
+}
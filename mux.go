@@ -0,0 +1,461 @@
+// Copyright 2020-2022 Matt Layher and Michael Stapelberg
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consrv
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// A Mux is a multiplexer over an input io.Reader which provides identical
+// output to any attached clients.
+type Mux struct {
+	mu      sync.Mutex
+	id      int
+	clients map[int]client
+	paused  *client
+	err     error
+	onState func(state string, err error)
+
+	pool sync.Pool
+	eg   errgroup.Group
+}
+
+// MuxConfig configures a Mux.
+type MuxConfig struct {
+	// ReadSize is the size of the buffer used for each read from the Mux's
+	// io.Reader. If zero, a default of 8192 bytes is used. Slow devices may
+	// use a smaller buffer to save memory, while fast devices may use a
+	// larger buffer to reduce the number of reads.
+	ReadSize int
+
+	// OnError, if set, is called when a read from the Mux's io.Reader fails,
+	// after the error is returned to each attached client as a *DeviceError.
+	// If OnError returns true, the Mux continues reading, such as after
+	// OnError reopens a device. Otherwise, the Mux stops reading. OnError may
+	// block, and no reads occur until it returns.
+	OnError func(err error) bool
+}
+
+// ErrPaused is returned by Pause when the Mux is already paused.
+var ErrPaused = errors.New("consrv: mux is already paused")
+
+// A DeviceError is returned by the io.Readers produced by a Mux when reading
+// from the Mux's io.Reader fails, including when the io.Reader returns
+// io.EOF because its device was closed or disconnected.
+type DeviceError struct {
+	Err error
+}
+
+// Error implements error.
+func (e *DeviceError) Error() string { return "consrv: device read failed: " + e.Err.Error() }
+
+// Unwrap implements errors unwrapping.
+func (e *DeviceError) Unwrap() error { return e.Err }
+
+// NewMux creates a Mux over the input io.Reader. The Mux reads from r until r
+// returns an error, so callers must close r before calling Close.
+func NewMux(r io.Reader) *Mux { return NewMuxConfig(r, MuxConfig{}) }
+
+// NewMuxConfig creates a Mux over the input io.Reader with the configuration
+// specified by cfg. See NewMux for details.
+func NewMuxConfig(r io.Reader, cfg MuxConfig) *Mux {
+	size := cfg.ReadSize
+	if size <= 0 {
+		size = muxReadSize
+	}
+
+	m := &Mux{clients: make(map[int]client)}
+	m.pool.New = func() any { return &readBuf{b: make([]byte, size), pool: &m.pool} }
+
+	m.eg.Go(func() error {
+		// Read continuously from the device and pass any data and/or errors to
+		// each of the attached clients. Each read uses a pooled buffer which
+		// is shared with the clients, rather than a copy.
+		for {
+			rb := m.pool.Get().(*readBuf)
+			rb.refs.Store(1)
+
+			n, err := r.Read(rb.b)
+			if n == 0 && err == nil {
+				// Nothing was read, such as when a device's read timeout
+				// expires, so there is no need to wake any clients.
+				rb.release()
+				continue
+			}
+
+			if err == nil {
+				m.doRead(rb, n, nil)
+				continue
+			}
+
+			m.doRead(rb, n, &DeviceError{Err: err})
+			m.state("failed", err)
+			if cfg.OnError != nil && cfg.OnError(err) {
+				m.state("reconnected", err)
+				continue
+			}
+
+			m.mu.Lock()
+			m.err = err
+			m.mu.Unlock()
+			m.state("stopped", err)
+
+			// Further reads won't make any progress, so don't block Close when
+			// it's invoked.
+			return err
+		}
+	})
+
+	return m
+}
+
+// Err returns the error which stopped the Mux from reading its io.Reader, or
+// nil if the Mux is still reading.
+func (m *Mux) Err() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.err
+}
+
+// setOnState sets a function which is called with the state of the Mux when
+// reading its io.Reader fails, and when the Mux then continues or stops
+// reading.
+func (m *Mux) setOnState(fn func(state string, err error)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.onState = fn
+}
+
+// state reports a state change to the function set by setOnState, if any.
+func (m *Mux) state(state string, err error) {
+	m.mu.Lock()
+	fn := m.onState
+	m.mu.Unlock()
+
+	if fn != nil {
+		fn(state, err)
+	}
+}
+
+// Close waits for the Mux to stop reading from its io.Reader, and then detaches
+// any remaining clients so that their pending Reads return immediately.
+func (m *Mux) Close() error {
+	err := m.eg.Wait()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, c := range m.clients {
+		close(c.readC)
+		delete(m.clients, id)
+	}
+	if m.paused != nil {
+		close(m.paused.readC)
+		m.paused = nil
+	}
+
+	return err
+}
+
+// A client is a client handle attached to the mux.
+type client struct {
+	readC chan<- read
+	ctx   context.Context
+	done  <-chan struct{}
+}
+
+// A read is the result of a read operation. The buffer is shared among multiple
+// clients, so clients _must_ only read from the buffer to avoid data races, and
+// must release the read once they are done with it.
+type read struct {
+	b   []byte
+	rb  *readBuf
+	err error
+}
+
+// release releases the client's reference to the read's buffer.
+func (r read) release() { r.rb.release() }
+
+// muxReadSize is the default size of each buffer read by a Mux.
+const muxReadSize = 8192
+
+// A readBuf is a read buffer from a Mux's pool, which is returned to the pool
+// once each client which received it releases its reference. Pooling avoids
+// allocating a buffer for each read from a fast device.
+type readBuf struct {
+	b    []byte
+	refs atomic.Int32
+	pool *sync.Pool
+}
+
+// release releases a reference to rb, and returns rb to its pool when no
+// references remain.
+func (rb *readBuf) release() {
+	if rb.refs.Add(-1) == 0 {
+		rb.pool.Put(rb)
+	}
+}
+
+// doRead consumes the results of a Read operation and dispatches them to each
+// of the clients attached to the mux.
+func (m *Mux) doRead(rb *readBuf, n int, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// The reader's reference is released once the read has been dispatched,
+	// and each client which receives the read holds its own reference.
+	defer rb.release()
+
+	// send sends the read to c, and reports whether c received it.
+	send := func(c *client) bool {
+		rb.refs.Add(1)
+		select {
+		case <-c.ctx.Done():
+			rb.refs.Add(-1)
+			return false
+		case <-c.done:
+			rb.refs.Add(-1)
+			return false
+		case c.readC <- read{b: rb.b[:n], rb: rb, err: err}:
+			return true
+		}
+	}
+
+	// remove detaches a given client when its context is canceled.
+	// Note that it is legal to modify a map during iteration in Go.
+	remove := func(id int) {
+		close(m.clients[id].readC)
+		delete(m.clients, id)
+	}
+
+	if m.paused != nil {
+		// Only the client which paused the mux receives reads until it is
+		// done, and then output to the other clients resumes. Errors are
+		// returned to every client.
+		if send(m.paused) && err == nil {
+			return
+		}
+
+		close(m.paused.readC)
+		m.paused = nil
+	}
+
+	for id, c := range m.clients {
+		if c.gone() {
+			// Client no longer listening.
+			remove(id)
+			continue
+		}
+
+		// Client is either ready for reading or its context is already
+		// canceled.
+		//
+		// TODO: deal with slow clients by possibly dropping reads.
+		if !send(&c) || err != nil {
+			// Client no longer listening, or it has received an error which
+			// it returns for all further reads.
+			remove(id)
+		}
+	}
+}
+
+// Attach attaches a client to the Mux and produces an io.Reader which will
+// receive any data read by the Mux until the client's context is canceled. If
+// the Mux has stopped reading, the io.Reader returns the *DeviceError which
+// stopped the Mux.
+func (m *Mux) Attach(ctx context.Context) io.Reader {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.err != nil {
+		return &muxReader{ctx: ctx, err: &DeviceError{Err: m.err}}
+	}
+
+	// Attach the client and give it an auto-incremented unique ID.
+	var (
+		readC = make(chan read)
+		done  = make(chan struct{})
+	)
+
+	m.clients[m.id] = client{
+		readC: readC,
+		ctx:   ctx,
+		done:  done,
+	}
+
+	mr := &muxReader{
+		m:     m,
+		id:    m.id,
+		ctx:   ctx,
+		readC: readC,
+		done:  done,
+	}
+
+	m.id++
+	return mr
+}
+
+// Pause stops dispatching reads to all attached clients and produces an
+// io.Reader which exclusively receives any data read by the Mux until ctx is
+// canceled, at which point the other clients resume receiving data. Only one
+// client may pause the Mux at a time, so ErrPaused is returned if the Mux is
+// already paused.
+func (m *Mux) Pause(ctx context.Context) (io.Reader, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.paused != nil && !m.paused.gone() {
+		return nil, ErrPaused
+	}
+	if m.err != nil {
+		return &muxReader{ctx: ctx, err: &DeviceError{Err: m.err}}, nil
+	}
+
+	var (
+		readC = make(chan read)
+		done  = make(chan struct{})
+	)
+
+	m.paused = &client{
+		readC: readC,
+		ctx:   ctx,
+		done:  done,
+	}
+
+	return &muxReader{
+		m:      m,
+		paused: true,
+		ctx:    ctx,
+		readC:  readC,
+		done:   done,
+	}, nil
+}
+
+// Detach immediately detaches the client which reads from r, an io.Reader
+// produced by Attach or Pause, rather than waiting for the client's context to
+// be canceled. Any pending or further Reads from r return io.EOF, and if r
+// paused the Mux, the other clients resume receiving data. Detach has no
+// effect if r was not produced by the Mux or is already detached.
+func (m *Mux) Detach(r io.Reader) {
+	mr, ok := r.(*muxReader)
+	if !ok || mr.m != m {
+		return
+	}
+
+	// Unblock the client and any read being dispatched to it before acquiring
+	// the lock, as a dispatch holds the lock until the client receives it.
+	mr.once.Do(func() { close(mr.done) })
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if mr.paused {
+		if m.paused != nil && m.paused.done == mr.done {
+			close(m.paused.readC)
+			m.paused = nil
+		}
+		return
+	}
+
+	if c, ok := m.clients[mr.id]; ok && c.done == mr.done {
+		close(c.readC)
+		delete(m.clients, mr.id)
+	}
+}
+
+// Paused reports whether a client has paused the Mux.
+func (m *Mux) Paused() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.paused != nil && !m.paused.gone()
+}
+
+// gone reports whether c's context is canceled or c is detached.
+func (c *client) gone() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return c.ctx.Err() != nil
+	}
+}
+
+var _ io.Reader = &muxReader{}
+
+// A muxReader is an io.Reader produced by the mux which consumes data from
+// a channel.
+type muxReader struct {
+	m      *Mux
+	id     int
+	paused bool
+
+	ctx   context.Context
+	readC <-chan read
+	done  chan struct{}
+	once  sync.Once
+
+	// pending is a read which did not fit in the caller's buffer, and which
+	// is returned by the following calls to Read.
+	pending *read
+
+	// err is a device error which is returned by all further calls to Read.
+	err error
+}
+
+// Read implements io.Reader.
+func (mr *muxReader) Read(b []byte) (int, error) {
+	if mr.err != nil {
+		return 0, mr.err
+	}
+
+	if mr.pending == nil {
+		select {
+		case <-mr.ctx.Done():
+			// Nothing to do, EOF.
+			return 0, io.EOF
+		case <-mr.done:
+			// Detached, EOF.
+			return 0, io.EOF
+		case r, ok := <-mr.readC:
+			if !ok {
+				// Removed from the mux, EOF.
+				return 0, io.EOF
+			}
+
+			mr.pending = &r
+		}
+	}
+
+	// Return any read data, and any error once all of the data is consumed.
+	r := mr.pending
+	n := copy(b, r.b)
+	r.b = r.b[n:]
+	if len(r.b) > 0 {
+		return n, nil
+	}
+
+	mr.pending = nil
+	mr.err = r.err
+	r.release()
+	return n, r.err
+}
@@ -0,0 +1,437 @@
+// Copyright 2020-2022 Matt Layher and Michael Stapelberg
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consrv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestMux(t *testing.T) {
+	m, w := tempMux(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Make a fixed number of workers each consume a certain number of messages.
+	const (
+		nWorkers  = 4
+		nMessages = 4
+	)
+
+	rs := make([]io.Reader, 0, nWorkers)
+	for i := 0; i < nWorkers; i++ {
+		rs = append(rs, m.Attach(ctx))
+	}
+
+	timer := time.AfterFunc(10*time.Second, func() {
+		panic("test took too long")
+	})
+	defer timer.Stop()
+
+	// Workers will fill in their own column in the matrix, so there is no need
+	// for locking.
+	//
+	// Thanks @emrecanbati from Twitch for the suggestion: "we can create an
+	// array and send index from writer then on reader side mark each index as
+	// read."
+	var (
+		got [nWorkers][nMessages]string
+		eg  errgroup.Group
+	)
+
+	for i, r := range rs {
+		// Copy for goroutines.
+		i, r := i, r
+
+		eg.Go(func() error {
+			// Consume messages and populate the correct matrix element per loop.
+			b := make([]byte, 64)
+			for j := 0; j < nMessages; j++ {
+				n, err := r.Read(b)
+				if err != nil {
+					return fmt.Errorf("failed to read: %v", err)
+				}
+
+				got[i][j] = string(b[:n])
+			}
+
+			return nil
+		})
+	}
+
+	for i := 0; i < nMessages; i++ {
+		_, _ = io.WriteString(w, fmt.Sprintf("write %d", i))
+
+		// TODO: this is a hack, we really should find a good way to allow the
+		// reader group to acknowledge each write as a unit.
+		time.Sleep(250 * time.Millisecond)
+	}
+
+	// All writes complete, wait for the goroutines to return.
+	if err := eg.Wait(); err != nil {
+		t.Fatalf("failed to wait: %v", err)
+	}
+
+	// Produce a matrix identical to the ones the workers would for comparison.
+	var want [nWorkers][nMessages]string
+	for i := 0; i < len(want); i++ {
+		for j := 0; j < len(want[i]); j++ {
+			want[i][j] = fmt.Sprintf("write %d", j)
+		}
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("unexpected matrix (-want +got):\n%s", diff)
+	}
+}
+
+func TestMuxPause(t *testing.T) {
+	m, w := tempMux(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	timer := time.AfterFunc(10*time.Second, func() {
+		panic("test took too long")
+	})
+	defer timer.Stop()
+
+	r := m.Attach(ctx)
+
+	pctx, pcancel := context.WithCancel(ctx)
+	pr, err := m.Pause(pctx)
+	if err != nil {
+		t.Fatalf("failed to pause: %v", err)
+	}
+	if !m.Paused() {
+		t.Fatal("mux should be paused")
+	}
+	if _, err := m.Pause(ctx); err != ErrPaused {
+		t.Fatalf("expected ErrPaused, but got: %v", err)
+	}
+
+	read := func(r io.Reader) string {
+		b := make([]byte, 64)
+		n, err := r.Read(b)
+		if err != nil {
+			t.Errorf("failed to read: %v", err)
+		}
+		return string(b[:n])
+	}
+
+	// Only the pausing client receives output while the mux is paused, and the
+	// other clients resume once it is done.
+	go func() { _, _ = io.WriteString(w, "paused") }()
+	if diff := cmp.Diff("paused", read(pr)); diff != "" {
+		t.Fatalf("unexpected paused read (-want +got):\n%s", diff)
+	}
+
+	pcancel()
+	if m.Paused() {
+		t.Fatal("mux should not be paused")
+	}
+
+	go func() { _, _ = io.WriteString(w, "resumed") }()
+	if diff := cmp.Diff("resumed", read(r)); diff != "" {
+		t.Fatalf("unexpected resumed read (-want +got):\n%s", diff)
+	}
+}
+
+func TestMuxDetach(t *testing.T) {
+	m, w := tempMux(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var (
+		detached = m.Attach(ctx)
+		attached = m.Attach(ctx)
+	)
+
+	paused, err := m.Pause(ctx)
+	if err != nil {
+		t.Fatalf("failed to pause: %v", err)
+	}
+
+	// A pending read returns as soon as its client is detached.
+	errC := make(chan error, 2)
+	for _, r := range []io.Reader{detached, paused} {
+		go func() {
+			_, err := r.Read(make([]byte, 1))
+			errC <- err
+		}()
+	}
+
+	m.Detach(detached)
+	m.Detach(paused)
+	for i := 0; i < 2; i++ {
+		if err := <-errC; err != io.EOF {
+			t.Fatalf("expected EOF after detach, but got: %v", err)
+		}
+	}
+
+	if m.Paused() {
+		t.Fatal("mux should not be paused after detaching the paused client")
+	}
+
+	// Detaching again, or detaching a reader from elsewhere, has no effect.
+	m.Detach(detached)
+	m.Detach(strings.NewReader("foo"))
+
+	m.mu.Lock()
+	n := len(m.clients)
+	m.mu.Unlock()
+	if diff := cmp.Diff(1, n); diff != "" {
+		t.Fatalf("unexpected number of clients (-want +got):\n%s", diff)
+	}
+
+	// The remaining client continues to receive output.
+	go func() { _, _ = io.WriteString(w, "hello") }()
+
+	b := make([]byte, 5)
+	if _, err := io.ReadFull(attached, b); err != nil {
+		t.Fatalf("failed to read: %v", err)
+	}
+	if diff := cmp.Diff("hello", string(b)); diff != "" {
+		t.Fatalf("unexpected data (-want +got):\n%s", diff)
+	}
+}
+
+func TestMuxErr(t *testing.T) {
+	r, w := io.Pipe()
+	m := NewMux(r)
+
+	if err := m.Err(); err != nil {
+		t.Fatalf("expected no error while reading, but got: %v", err)
+	}
+
+	_ = w.Close()
+	_ = m.Close()
+
+	if diff := cmp.Diff(io.EOF, m.Err(), cmpopts.EquateErrors()); diff != "" {
+		t.Fatalf("unexpected error (-want +got):\n%s", diff)
+	}
+}
+
+func TestMuxShortRead(t *testing.T) {
+	m, w := tempMux(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	r := m.Attach(ctx)
+
+	// A single device read must be returned in full even when the client's
+	// buffer is smaller than the read.
+	const want = "hello, world"
+	go func() { _, _ = io.WriteString(w, want) }()
+
+	var (
+		got []byte
+		b   = make([]byte, 5)
+	)
+
+	for len(got) < len(want) {
+		n, err := r.Read(b)
+		if err != nil {
+			t.Fatalf("failed to read: %v", err)
+		}
+		got = append(got, b[:n]...)
+	}
+
+	if diff := cmp.Diff(want, string(got)); diff != "" {
+		t.Fatalf("unexpected data (-want +got):\n%s", diff)
+	}
+}
+
+func TestMuxConfig(t *testing.T) {
+	// The reader first times out without any data, which must not produce a
+	// read for the client.
+	r := &sizeReader{
+		startC: make(chan struct{}),
+		reads:  []string{"", "hello"},
+	}
+	m := NewMuxConfig(r, MuxConfig{ReadSize: 16})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mr := m.Attach(ctx)
+	close(r.startC)
+
+	// The device's EOF is reported to the client as a device error.
+	b, err := io.ReadAll(mr)
+	var derr *DeviceError
+	if !errors.As(err, &derr) || !errors.Is(err, io.EOF) {
+		t.Fatalf("expected device EOF error, but got: %v", err)
+	}
+	_ = m.Close()
+
+	if diff := cmp.Diff("hello", string(b)); diff != "" {
+		t.Fatalf("unexpected data (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]int{16, 16, 16}, r.sizes); diff != "" {
+		t.Fatalf("unexpected read sizes (-want +got):\n%s", diff)
+	}
+}
+
+func TestMuxDeviceError(t *testing.T) {
+	errIO := errors.New("input/output error")
+
+	// The first error is handled by reconnecting, but the second stops the
+	// mux.
+	r := &sizeReader{
+		startC: make(chan struct{}),
+		reads:  []string{"foo", "", "bar"},
+		errs:   []error{nil, errIO, nil},
+	}
+
+	var errs []error
+	m := NewMuxConfig(r, MuxConfig{
+		OnError: func(err error) bool {
+			errs = append(errs, err)
+			return len(errs) == 1
+		},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	before := m.Attach(ctx)
+	close(r.startC)
+
+	b, err := io.ReadAll(before)
+	if !errors.Is(err, errIO) {
+		t.Fatalf("expected I/O error, but got: %v", err)
+	}
+	if diff := cmp.Diff("foo", string(b)); diff != "" {
+		t.Fatalf("unexpected data before error (-want +got):\n%s", diff)
+	}
+
+	// The error is returned by each further read.
+	if _, err := before.Read(make([]byte, 1)); !errors.Is(err, errIO) {
+		t.Fatalf("expected repeated I/O error, but got: %v", err)
+	}
+
+	if err := m.Close(); !errors.Is(err, io.EOF) {
+		t.Fatalf("expected mux EOF error, but got: %v", err)
+	}
+
+	// Clients which attach after the mux stops receive the error which stopped
+	// the mux.
+	_, err = m.Attach(ctx).Read(make([]byte, 1))
+	var derr *DeviceError
+	if !errors.As(err, &derr) || !errors.Is(derr.Err, io.EOF) {
+		t.Fatalf("expected device EOF error, but got: %v", err)
+	}
+
+	if diff := cmp.Diff([]error{errIO, io.EOF}, errs, cmpopts.EquateErrors()); diff != "" {
+		t.Fatalf("unexpected errors (-want +got):\n%s", diff)
+	}
+}
+
+// A sizeReader waits for startC to be closed, and then returns each of its
+// reads and errors in order and then io.EOF, recording the size of each buffer
+// passed to Read.
+type sizeReader struct {
+	startC chan struct{}
+	reads  []string
+	errs   []error
+	sizes  []int
+}
+
+func (r *sizeReader) Read(b []byte) (int, error) {
+	<-r.startC
+	r.sizes = append(r.sizes, len(b))
+	if len(r.reads) == 0 {
+		return 0, io.EOF
+	}
+
+	n := copy(b, r.reads[0])
+	r.reads = r.reads[1:]
+
+	var err error
+	if len(r.errs) > 0 {
+		err, r.errs = r.errs[0], r.errs[1:]
+	}
+
+	return n, err
+}
+
+func BenchmarkMux(b *testing.B) {
+	r, w := io.Pipe()
+	m := NewMux(r)
+	defer func() {
+		_ = w.Close()
+		_ = m.Close()
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const nClients = 4
+	var eg errgroup.Group
+	for i := 0; i < nClients; i++ {
+		mr := m.Attach(ctx)
+		eg.Go(func() error {
+			_, err := io.Copy(io.Discard, mr)
+			return err
+		})
+	}
+
+	buf := make([]byte, muxReadSize)
+	b.SetBytes(int64(len(buf)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := w.Write(buf); err != nil {
+			b.Fatalf("failed to write: %v", err)
+		}
+	}
+
+	b.StopTimer()
+	cancel()
+	_ = w.Close()
+	if err := eg.Wait(); err != nil {
+		b.Fatalf("failed to wait for clients: %v", err)
+	}
+}
+
+func tempMux(t *testing.T) (*Mux, io.Writer) {
+	t.Helper()
+
+	r, w := io.Pipe()
+	m := NewMux(r)
+
+	t.Cleanup(func() {
+		// The order here is important: closing the writer allows closing the
+		// reader, and the mux would block until the reader can be closed.
+		_ = w.Close()
+		_ = r.Close()
+		_ = m.Close()
+	})
+
+	return m, w
+}
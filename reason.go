@@ -0,0 +1,89 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consrv
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"unicode/utf8"
+
+	"github.com/gliderlabs/ssh"
+)
+
+// maxReason is the maximum length in bytes of a session's reason.
+const maxReason = 200
+
+// promptReason prompts the session for a one-line reason for using its device,
+// such as a change ticket, until a non-empty reason is entered.
+func (s *Server) promptReason(session ssh.Session) (string, error) {
+	// Sessions with a PTY put the client's terminal in raw mode, so input must
+	// be echoed. Otherwise the client's terminal echoes complete lines.
+	_, _, echo := session.Pty()
+
+	for {
+		fmt.Fprint(session, "consrv> reason for this session (e.g. a ticket): ")
+		reason, err := readReason(session, echo)
+		if err != nil {
+			return "", err
+		}
+		if reason != "" {
+			return reason, nil
+		}
+	}
+}
+
+// readReason reads a line of input from rw one byte at a time, so that no
+// input which follows the line is consumed, optionally echoing it.
+func readReason(rw io.ReadWriter, echo bool) (string, error) {
+	var (
+		line []byte
+		b    = make([]byte, 1)
+	)
+
+	for {
+		if _, err := rw.Read(b); err != nil {
+			return "", err
+		}
+
+		switch c := b[0]; {
+		case c == '\r' || c == '\n':
+			if echo {
+				_, _ = io.WriteString(rw, "\r\n")
+			}
+			return string(line), nil
+		case c == 0x03:
+			// Ctrl-C.
+			return "", errors.New("canceled")
+		case c == 0x08 || c == 0x7f:
+			// Backspace or DEL removes the last complete rune.
+			if len(line) == 0 {
+				continue
+			}
+
+			_, n := utf8.DecodeLastRune(line)
+			line = line[:len(line)-n]
+			if echo {
+				_, _ = io.WriteString(rw, "\b \b")
+			}
+		case c < 0x20:
+			// Ignore any other control characters.
+		case len(line) < maxReason:
+			line = append(line, c)
+			if echo {
+				_, _ = rw.Write(b)
+			}
+		}
+	}
+}
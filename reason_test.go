@@ -0,0 +1,160 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consrv
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSSHRequireReason(t *testing.T) {
+	d := &testDevice{writeC: make(chan struct{})}
+	infoC := make(chan SessionInfo, 1)
+	_, addr := testServe(t, ServerConfig{
+		HostKey: []byte(strings.TrimSpace(testHostPrivate)),
+		Devices: map[string]*MuxDevice{
+			"foo": NewMuxDevice(d),
+		},
+		RequireReason: map[string]bool{"foo": true},
+		Identities: mustIdentities([]Identity{{
+			Name:      "test",
+			PublicKey: mustKey(testClientPublic),
+		}}, nil),
+		OnAttach: func(_ context.Context, info SessionInfo) {
+			infoC <- info
+		},
+	})
+
+	s := testDial(t, addr, "foo", mustKey(testHostPublic))
+
+	// An empty reason prompts again, and input after the reason is written to
+	// the device.
+	s.Stdin = strings.NewReader("\nCHG-1234\rhello")
+	var buf bytes.Buffer
+	s.Stderr = &buf
+
+	if err := s.Start(""); err != nil {
+		t.Fatalf("failed to start command: %v", err)
+	}
+
+	info := <-infoC
+	<-d.writeC
+	_ = s.Close()
+	_ = s.Wait()
+
+	if diff := cmp.Diff("CHG-1234", info.Reason); diff != "" {
+		t.Fatalf("unexpected reason (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff("hello", string(d.write)); diff != "" {
+		t.Fatalf("unexpected device write data (-want +got):\n%s", diff)
+	}
+
+	const prompt = "consrv> reason for this session (e.g. a ticket): "
+	want := prompt + prompt + "consrv> opened serial connection test\n"
+	if diff := cmp.Diff(want, buf.String()); diff != "" {
+		t.Fatalf("unexpected output (-want +got):\n%s", diff)
+	}
+}
+
+func Test_readReason(t *testing.T) {
+	tests := []struct {
+		name     string
+		in       string
+		echo     bool
+		ok       bool
+		reason   string
+		out, buf string
+	}{
+		{
+			name:   "line",
+			in:     "CHG-1234\nrest",
+			ok:     true,
+			reason: "CHG-1234",
+			buf:    "rest",
+		},
+		{
+			name:   "echo",
+			in:     "INC\x7f\x7fNC-1\r",
+			echo:   true,
+			ok:     true,
+			reason: "INC-1",
+			out:    "INC\b \b\b \bNC-1\r\n",
+		},
+		{
+			name:   "multibyte backspace",
+			in:     "héé\x08\n",
+			ok:     true,
+			reason: "hé",
+		},
+		{
+			name:   "control characters",
+			in:     "\x1b[A\tok\n",
+			ok:     true,
+			reason: "[Aok",
+		},
+		{
+			name:   "truncated",
+			in:     strings.Repeat("x", maxReason+10) + "\n",
+			ok:     true,
+			reason: strings.Repeat("x", maxReason),
+		},
+		{
+			name: "canceled",
+			in:   "CHG\x03",
+		},
+		{
+			name: "EOF",
+			in:   "CHG",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				r   = strings.NewReader(tt.in)
+				out bytes.Buffer
+			)
+
+			reason, err := readReason(struct {
+				io.Reader
+				io.Writer
+			}{r, &out}, tt.echo)
+			if tt.ok && err != nil {
+				t.Fatalf("failed to read reason: %v", err)
+			}
+			if !tt.ok {
+				if err == nil {
+					t.Fatal("expected an error, but none occurred")
+				}
+				return
+			}
+
+			rest, _ := io.ReadAll(r)
+			if diff := cmp.Diff(tt.reason, reason); diff != "" {
+				t.Fatalf("unexpected reason (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tt.out, out.String()); diff != "" {
+				t.Fatalf("unexpected echo (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tt.buf, string(rest)); diff != "" {
+				t.Fatalf("unexpected remaining input (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,240 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consrv
+
+import (
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+// A Reservation is an identity's exclusive reservation of a device. While a
+// device is reserved, only the identity holding the reservation may attach.
+type Reservation struct {
+	// Device is the name of the reserved device.
+	Device string
+
+	// Identity is the name of the identity holding the reservation.
+	Identity string
+
+	// Until is the time the reservation expires.
+	Until time.Time
+
+	// Queue lists the identities waiting to reserve the device, in order.
+	// Each is granted the reservation in turn when the previous reservation
+	// is released or expires.
+	Queue []string
+
+	// Durations are the reservation durations requested by each identity in
+	// Queue, in the same order.
+	Durations []time.Duration
+}
+
+// Sources of a Holder.
+const (
+	// HolderReservation indicates that a device is reserved by the holder.
+	HolderReservation = "reservation"
+
+	// HolderSession indicates that the holder's SSH session has been attached
+	// to a device for the longest time.
+	HolderSession = "session"
+)
+
+// A Holder is the identity currently using a device.
+type Holder struct {
+	// Identity is the name of the identity using the device.
+	Identity string
+
+	// Source is HolderReservation or HolderSession.
+	Source string
+}
+
+// reservations tracks the reservation and queue of each device.
+type reservations struct {
+	mu  sync.Mutex
+	now func() time.Time
+	m   map[string]*reservation
+}
+
+// A reservation is the state of a reserved device.
+type reservation struct {
+	id    string
+	until time.Time
+	queue []queued
+}
+
+// A queued is an identity waiting to reserve a device for a duration.
+type queued struct {
+	id string
+	d  time.Duration
+}
+
+// reserve reserves device for id for duration d, or extends id's existing
+// reservation to end d from now. If another identity holds the reservation, id
+// is queued, and its 1-based position in the queue is returned along with the
+// current reservation.
+func (rs *reservations) reserve(device, id string, d time.Duration) (Reservation, int) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	now := rs.now()
+	r := rs.advance(device, now)
+	switch {
+	case r == nil:
+		if rs.m == nil {
+			rs.m = make(map[string]*reservation)
+		}
+
+		r = &reservation{id: id, until: now.Add(d)}
+		rs.m[device] = r
+		return r.export(device), 0
+	case r.id == id:
+		r.until = now.Add(d)
+		return r.export(device), 0
+	}
+
+	// Update the duration of a queued identity in place.
+	i := slices.IndexFunc(r.queue, func(q queued) bool { return q.id == id })
+	if i == -1 {
+		r.queue = append(r.queue, queued{id: id, d: d})
+		i = len(r.queue) - 1
+	}
+	r.queue[i].d = d
+
+	return r.export(device), i + 1
+}
+
+// release releases id's reservation of device or removes id from its queue,
+// and reports whether id held or was queued for the reservation.
+func (rs *reservations) release(device, id string) bool {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	now := rs.now()
+	r := rs.advance(device, now)
+	if r == nil {
+		return false
+	}
+
+	if r.id == id {
+		// Expire the reservation now so the next queued identity takes over.
+		r.until = now
+		rs.advance(device, now)
+		return true
+	}
+
+	n := len(r.queue)
+	r.queue = slices.DeleteFunc(r.queue, func(q queued) bool { return q.id == id })
+	return len(r.queue) != n
+}
+
+// restore restores the reservation r exported by a previous list, and reports
+// whether it was restored. Expired reservations and devices which are already
+// reserved are skipped.
+func (rs *reservations) restore(r Reservation) bool {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	now := rs.now()
+	if !now.Before(r.Until) || rs.advance(r.Device, now) != nil {
+		return false
+	}
+
+	res := &reservation{id: r.Identity, until: r.Until}
+	for i, id := range r.Queue {
+		if i >= len(r.Durations) || r.Durations[i] <= 0 {
+			continue
+		}
+
+		res.queue = append(res.queue, queued{id: id, d: r.Durations[i]})
+	}
+
+	if rs.m == nil {
+		rs.m = make(map[string]*reservation)
+	}
+	rs.m[r.Device] = res
+
+	return true
+}
+
+// get returns the current reservation of device, if any.
+func (rs *reservations) get(device string) (Reservation, bool) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	r := rs.advance(device, rs.now())
+	if r == nil {
+		return Reservation{}, false
+	}
+
+	return r.export(device), true
+}
+
+// list returns the current reservations of all devices, sorted by device.
+func (rs *reservations) list() []Reservation {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	now := rs.now()
+
+	var out []Reservation
+	for _, device := range slices.Sorted(maps.Keys(rs.m)) {
+		if r := rs.advance(device, now); r != nil {
+			out = append(out, r.export(device))
+		}
+	}
+
+	return out
+}
+
+// advance hands expired reservations of device to each queued identity in
+// turn, and returns the current reservation or nil if device is not reserved.
+// The caller must hold rs.mu.
+func (rs *reservations) advance(device string, now time.Time) *reservation {
+	r, ok := rs.m[device]
+	if !ok {
+		return nil
+	}
+
+	for !now.Before(r.until) {
+		if len(r.queue) == 0 {
+			delete(rs.m, device)
+			return nil
+		}
+
+		// Each queued reservation begins when the previous one ended.
+		q := r.queue[0]
+		r.queue = r.queue[1:]
+		r.id = q.id
+		r.until = r.until.Add(q.d)
+	}
+
+	return r
+}
+
+// export returns the exported form of r.
+func (r *reservation) export(device string) Reservation {
+	out := Reservation{
+		Device:   device,
+		Identity: r.id,
+		Until:    r.until,
+	}
+	for _, q := range r.queue {
+		out.Queue = append(out.Queue, q.id)
+		out.Durations = append(out.Durations, q.d)
+	}
+
+	return out
+}
@@ -0,0 +1,226 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consrv
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_reservations(t *testing.T) {
+	start := time.Unix(0, 0).UTC()
+	now := start
+	rs := &reservations{now: func() time.Time { return now }}
+
+	type step struct {
+		name string
+		at   time.Duration
+		do   func() int
+		pos  int
+		want []Reservation
+	}
+
+	reserve := func(id string, d time.Duration) func() int {
+		return func() int {
+			_, n := rs.reserve("server", id, d)
+			return n
+		}
+	}
+	release := func(id string, ok bool) func() int {
+		return func() int {
+			if got := rs.release("server", id); got != ok {
+				t.Fatalf("unexpected release result for %q: %t", id, got)
+			}
+			return 0
+		}
+	}
+
+	steps := []step{
+		{
+			name: "reserve",
+			do:   reserve("alice", 1*time.Hour),
+			want: []Reservation{{
+				Device:   "server",
+				Identity: "alice",
+				Until:    start.Add(1 * time.Hour),
+			}},
+		},
+		{
+			name: "queue",
+			at:   10 * time.Minute,
+			do:   reserve("bob", 30*time.Minute),
+			pos:  1,
+			want: []Reservation{{
+				Device:    "server",
+				Identity:  "alice",
+				Until:     start.Add(1 * time.Hour),
+				Queue:     []string{"bob"},
+				Durations: []time.Duration{30 * time.Minute},
+			}},
+		},
+		{
+			name: "queue second",
+			at:   15 * time.Minute,
+			do:   reserve("carol", 1*time.Hour),
+			pos:  2,
+			want: []Reservation{{
+				Device:    "server",
+				Identity:  "alice",
+				Until:     start.Add(1 * time.Hour),
+				Queue:     []string{"bob", "carol"},
+				Durations: []time.Duration{30 * time.Minute, 1 * time.Hour},
+			}},
+		},
+		{
+			name: "extend",
+			at:   20 * time.Minute,
+			do:   reserve("alice", 2*time.Hour),
+			want: []Reservation{{
+				Device:    "server",
+				Identity:  "alice",
+				Until:     start.Add(2*time.Hour + 20*time.Minute),
+				Queue:     []string{"bob", "carol"},
+				Durations: []time.Duration{30 * time.Minute, 1 * time.Hour},
+			}},
+		},
+		{
+			name: "release hands over",
+			at:   30 * time.Minute,
+			do:   release("alice", true),
+			want: []Reservation{{
+				Device:    "server",
+				Identity:  "bob",
+				Until:     start.Add(1 * time.Hour),
+				Queue:     []string{"carol"},
+				Durations: []time.Duration{1 * time.Hour},
+			}},
+		},
+		{
+			name: "release not held",
+			at:   30 * time.Minute,
+			do:   release("alice", false),
+			want: []Reservation{{
+				Device:    "server",
+				Identity:  "bob",
+				Until:     start.Add(1 * time.Hour),
+				Queue:     []string{"carol"},
+				Durations: []time.Duration{1 * time.Hour},
+			}},
+		},
+		{
+			name: "expiry hands over",
+			at:   90 * time.Minute,
+			want: []Reservation{{
+				Device:   "server",
+				Identity: "carol",
+				Until:    start.Add(2 * time.Hour),
+			}},
+		},
+		{
+			name: "expired",
+			at:   2 * time.Hour,
+		},
+	}
+
+	for _, s := range steps {
+		now = start.Add(s.at)
+
+		var pos int
+		if s.do != nil {
+			pos = s.do()
+		}
+
+		if diff := cmp.Diff(s.pos, pos); diff != "" {
+			t.Fatalf("%s: unexpected queue position (-want +got):\n%s", s.name, diff)
+		}
+		if diff := cmp.Diff(s.want, rs.list()); diff != "" {
+			t.Fatalf("%s: unexpected reservations (-want +got):\n%s", s.name, diff)
+		}
+	}
+}
+
+func Test_reservationsLeaveQueue(t *testing.T) {
+	now := time.Unix(0, 0).UTC()
+	rs := &reservations{now: func() time.Time { return now }}
+
+	rs.reserve("server", "alice", 1*time.Hour)
+	rs.reserve("server", "bob", 1*time.Hour)
+
+	if !rs.release("server", "bob") {
+		t.Fatal("expected bob to leave the queue")
+	}
+
+	// After alice's reservation expires, nobody holds the device.
+	now = now.Add(1 * time.Hour)
+	if r, ok := rs.get("server"); ok {
+		t.Fatalf("expected no reservation, but got: %+v", r)
+	}
+}
+
+func Test_reservationsRestore(t *testing.T) {
+	now := time.Unix(0, 0).UTC()
+	rs := &reservations{now: func() time.Time { return now }}
+	rs.reserve("desktop", "carol", 1*time.Hour)
+
+	in := []Reservation{
+		{
+			Device:    "server",
+			Identity:  "alice",
+			Until:     now.Add(30 * time.Minute),
+			Queue:     []string{"bob"},
+			Durations: []time.Duration{1 * time.Hour},
+		},
+		{
+			// Already reserved.
+			Device:   "desktop",
+			Identity: "alice",
+			Until:    now.Add(1 * time.Hour),
+		},
+		{
+			// Expired.
+			Device:   "router",
+			Identity: "alice",
+			Until:    now,
+		},
+	}
+
+	var restored []string
+	for _, r := range in {
+		if rs.restore(r) {
+			restored = append(restored, r.Device)
+		}
+	}
+
+	if diff := cmp.Diff([]string{"server"}, restored); diff != "" {
+		t.Fatalf("unexpected restored devices (-want +got):\n%s", diff)
+	}
+
+	// The queued identity takes over when the restored reservation expires.
+	now = now.Add(45 * time.Minute)
+	want := Reservation{
+		Device:   "server",
+		Identity: "bob",
+		Until:    time.Unix(0, 0).UTC().Add(90 * time.Minute),
+	}
+
+	got, ok := rs.get("server")
+	if !ok {
+		t.Fatal("expected a reservation")
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("unexpected reservation (-want +got):\n%s", diff)
+	}
+}
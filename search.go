@@ -0,0 +1,112 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consrv
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/gliderlabs/ssh"
+)
+
+// searchPage is the number of matches printed for each page of search results.
+const searchPage = 20
+
+// search prints the lines of a device's recent output which match a regular
+// expression, newest first, so that the last kernel panic or error can be
+// found without downloading full logs. Results are split into pages, and the
+// optional final argument selects the page.
+func (s *Server) search(session ssh.Session, f string, args []string) error {
+	device := args[0]
+	if _, ok := s.devices[device]; !ok || !s.allowed(session.Context(), device) {
+		return fmt.Errorf("unknown device %q", device)
+	}
+
+	id := s.identities().toName[f]
+	if r, ok := s.reserved.get(device); ok && r.Identity != id {
+		return fmt.Errorf("%q is reserved by %s until %s", device, r.Identity, formatUntil(r.Until, s.reserved.now()))
+	}
+
+	re, err := regexp.Compile(args[1])
+	if err != nil {
+		return fmt.Errorf("invalid regexp: %v", err)
+	}
+
+	page := 1
+	if len(args) == 3 {
+		page, err = strconv.Atoi(args[2])
+		if err != nil || page < 1 {
+			return fmt.Errorf("invalid page %q", args[2])
+		}
+	}
+
+	if s.scrollback == nil {
+		return errors.New("scrollback is not available")
+	}
+	b, ok := s.scrollback(device)
+	if !ok {
+		return fmt.Errorf("scrollback is not available for %q", device)
+	}
+
+	matches := searchLines(b, re)
+	if len(matches) == 0 {
+		fmt.Fprintf(session, "consrv> no matches for %q in %q\n", args[1], device)
+		return nil
+	}
+
+	start := (page - 1) * searchPage
+	if start >= len(matches) {
+		return fmt.Errorf("page %d is out of range, there are %d matches", page, len(matches))
+	}
+	end := min(start+searchPage, len(matches))
+
+	for _, m := range matches[start:end] {
+		fmt.Fprintf(session, "%6d: %s\n", m.line, m.text)
+	}
+
+	fmt.Fprintf(session, "consrv> matches %d-%d of %d, newest first", start+1, end, len(matches))
+	if end < len(matches) {
+		fmt.Fprintf(session, ", next: search %s %q %d", device, args[1], page+1)
+	}
+	fmt.Fprintln(session)
+
+	return nil
+}
+
+// A searchMatch is a line of output which matched a search.
+type searchMatch struct {
+	line int
+	text []byte
+}
+
+// searchLines returns the lines of b which match re, newest first. Lines are
+// numbered from the oldest retained output.
+func searchLines(b []byte, re *regexp.Regexp) []searchMatch {
+	lines := bytes.Split(b, []byte("\n"))
+
+	var matches []searchMatch
+	for i := len(lines) - 1; i >= 0; i-- {
+		line := bytes.TrimRight(lines[i], "\r")
+		if len(line) == 0 || !re.Match(line) {
+			continue
+		}
+
+		matches = append(matches, searchMatch{line: i + 1, text: line})
+	}
+
+	return matches
+}
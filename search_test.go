@@ -0,0 +1,142 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consrv
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSSHSearchCommand(t *testing.T) {
+	devices := map[string]*MuxDevice{
+		"foo":    NewMuxDevice(&testDevice{}),
+		"bar":    NewMuxDevice(&testDevice{}),
+		"secret": NewMuxDevice(&testDevice{}),
+	}
+
+	// foo has more matches than fit on a single page, and bar's output is not
+	// retained.
+	var scrollback strings.Builder
+	for i := range searchPage + 2 {
+		fmt.Fprintf(&scrollback, "boot %d\r\npanic: %d\r\n", i, i)
+	}
+
+	_, addr := testServe(t, ServerConfig{
+		HostKey: []byte(strings.TrimSpace(testHostPrivate)),
+		Devices: devices,
+		Identities: mustIdentities([]Identity{
+			{
+				Name:      "test",
+				PublicKey: mustKey(testClientPublic),
+			},
+			{
+				Name:      "other",
+				PublicKey: mustKey(testPublicA),
+			},
+		}, map[string][]string{"secret": {"other"}}),
+		Scrollback: func(device string) ([]byte, bool) {
+			if device != "foo" {
+				return nil, false
+			}
+
+			return []byte(scrollback.String()), true
+		},
+		Logger: log.New(os.Stderr, "", 0),
+	})
+
+	tests := []struct {
+		name, cmd string
+		want      *regexp.Regexp
+	}{
+		{
+			name: "unknown device",
+			cmd:  "search baz panic",
+			want: regexp.MustCompile(`^consrv> search: unknown device "baz"\n$`),
+		},
+		{
+			name: "forbidden device",
+			cmd:  "search secret panic",
+			want: regexp.MustCompile(`^consrv> search: unknown device "secret"\n$`),
+		},
+		{
+			name: "bad regexp",
+			cmd:  "search foo '('",
+			want: regexp.MustCompile(`^consrv> search: invalid regexp: `),
+		},
+		{
+			name: "bad page",
+			cmd:  "search foo panic 0",
+			want: regexp.MustCompile(`^consrv> search: invalid page "0"\n$`),
+		},
+		{
+			name: "page out of range",
+			cmd:  "search foo panic 3",
+			want: regexp.MustCompile(`^consrv> search: page 3 is out of range, there are 22 matches\n$`),
+		},
+		{
+			name: "not retained",
+			cmd:  "search bar panic",
+			want: regexp.MustCompile(`^consrv> search: scrollback is not available for "bar"\n$`),
+		},
+		{
+			name: "no matches",
+			cmd:  "search foo oops",
+			want: regexp.MustCompile(`^consrv> no matches for "oops" in "foo"\n$`),
+		},
+		{
+			name: "first page",
+			cmd:  "search foo 'panic: \\d+'",
+			want: regexp.MustCompile(`^    44: panic: 21\n    42: panic: 20\n(?s:.*)     6: panic: 2\nconsrv> matches 1-20 of 22, newest first, next: search foo "panic: \\\\d\+" 2\n$`),
+		},
+		{
+			name: "last page",
+			cmd:  "search foo panic 2",
+			want: regexp.MustCompile(`^     4: panic: 1\n     2: panic: 0\nconsrv> matches 21-22 of 22, newest first\n$`),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, _ := testDial(t, addr, "consrv", mustKey(testHostPublic)).CombinedOutput(tt.cmd)
+			if !tt.want.Match(b) {
+				t.Fatalf("unexpected output for %q: %q", tt.cmd, b)
+			}
+		})
+	}
+}
+
+func Test_searchLines(t *testing.T) {
+	b := []byte("ok\r\nkernel panic\r\n\r\nok\npanic again\npartial pan")
+
+	var got []string
+	for _, m := range searchLines(b, regexp.MustCompile(`pan`)) {
+		got = append(got, fmt.Sprintf("%d: %s", m.line, m.text))
+	}
+
+	want := []string{
+		"6: partial pan",
+		"5: panic again",
+		"2: kernel panic",
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("unexpected matches (-want +got):\n%s", diff)
+	}
+}
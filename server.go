@@ -0,0 +1,988 @@
+// Copyright 2020-2022 Matt Layher and Michael Stapelberg
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consrv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dolmen-go/contextio"
+	"github.com/gliderlabs/ssh"
+	"github.com/mdlayher/metricslite"
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/sync/errgroup"
+)
+
+// ListSubsystem is the name of the SSH subsystem which lists the names of the
+// devices the authenticated identity may access, one per line. Any SSH user
+// name which is not also a device name may be used to request it:
+//
+//	$ ssh -p 2222 -s consrv@monitnerr-1 consrv-list
+const ListSubsystem = "consrv-list"
+
+// ListDetailsSubsystem is like ListSubsystem, but follows each device name with
+// its sorted metadata from ServerConfig.DeviceMetadata as space-separated
+// key="value" pairs, to help identify which physical adapter is which:
+//
+//	server driver="ftdi_sio" product="FT232R USB UART" vendor="FTDI"
+const ListDetailsSubsystem = "consrv-list-details"
+
+// AuthFailureFormat is the stable format of authentication failure logs, so
+// they can be matched by tools such as fail2ban. The fields are the SSH user
+// name, the client's IP address and port, and the type and SHA256 fingerprint
+// of the rejected public key:
+//
+//	authentication failure: user="root" addr=192.0.2.1 port=50022 key="ssh-ed25519 SHA256:..."
+//
+// A matching fail2ban failregex is:
+//
+//	authentication failure: user=".*" addr=<HOST> port=\d+
+const AuthFailureFormat = `authentication failure: user=%q addr=%s port=%s key="%s %s"`
+
+// OpenSSHAuthFormat is the format of authentication logs compatible with those
+// of OpenSSH's sshd, so they can be ingested by existing log parsers. The fields
+// are "Accepted" or "Failed", the SSH user name, the client's IP address and
+// port, and the OpenSSH name and SHA256 fingerprint of the public key:
+//
+//	Accepted publickey for root from 192.0.2.1 port 50022 ssh2: ED25519 SHA256:...
+const OpenSSHAuthFormat = "%s publickey for %s from %s port %s ssh2: %s %s"
+
+// A fingerprintKey is the ssh.Context key for the public key fingerprint of
+// an authenticated identity.
+type fingerprintKey struct{}
+
+// A Server is an SSH server which proxies SSH sessions to console devices.
+type Server struct {
+	s          *ssh.Server
+	devices    map[string]*MuxDevice
+	metadata   map[string]map[string]string
+	ids        atomic.Pointer[Identities]
+	sessions   sessions
+	reserved   reservations
+	approvals  approvals
+	writes     writeGrants
+	events     events
+	passphrase []byte
+	hostMu     sync.Mutex
+	hostKeys   map[string]gossh.Signer
+	limits     connLimiter
+	keepAlive  time.Duration
+	authorize  func(ctx context.Context, req AuthRequest) error
+	onAttach   func(ctx context.Context, info SessionInfo)
+	onDetach   func(info SessionInfo, sum SessionSummary)
+	before     func(info SessionInfo)
+	after      func(info SessionInfo)
+	scrollback func(device string) ([]byte, bool)
+	approval   map[string]bool
+	reason     map[string]bool
+	readOnly   map[string]bool
+	hp         *honeypot
+
+	groups       map[string][]string
+	powerCycleFn func(ctx context.Context, device string) error
+	states       deviceStates
+
+	ll *log.Logger
+	el *log.Logger
+	al *log.Logger
+	ol *log.Logger
+	mm *metrics
+}
+
+// A ServerConfig configures a Server.
+type ServerConfig struct {
+	// HostKey is the PEM-encoded SSH host private key. If empty, an ephemeral
+	// host key is generated.
+	HostKey []byte
+
+	// HostKeyPassphrase decrypts HostKey and any keys passed to SetHostKey
+	// if they are encrypted.
+	HostKeyPassphrase []byte
+
+	// KeyExchanges, Ciphers, and MACs restrict the SSH algorithms offered to
+	// clients, in order of preference. If empty, the golang.org/x/crypto/ssh
+	// defaults are used. Unsupported algorithms are ignored.
+	KeyExchanges, Ciphers, MACs []string
+
+	// Version is the software version advertised to clients in the SSH
+	// identification string, following "SSH-2.0-". If empty, "Go" is used.
+	Version string
+
+	// MaxConnections and MaxConnectionsPerIP limit the number of concurrent
+	// connections in total and from each source IP address. Connections over
+	// a limit are closed before the SSH handshake. If 0, connections are not
+	// limited.
+	MaxConnections, MaxConnectionsPerIP int
+
+	// Devices maps SSH user names to the devices opened by their sessions.
+	Devices map[string]*MuxDevice
+
+	// DeviceMetadata optionally maps device names to descriptive key/value
+	// pairs, such as details about the hardware adapter, which are printed by
+	// ListDetailsSubsystem.
+	DeviceMetadata map[string]map[string]string
+
+	// KeepAlive, if greater than 0, sets the idle time and probe interval of
+	// TCP keepalives on accepted connections, so that a client which vanishes
+	// without closing its connection is detected after several missed probes
+	// and its sessions end. If 0, the operating system defaults are used.
+	KeepAlive time.Duration
+
+	// RequireApproval optionally lists devices which are dangerous to use
+	// unsupervised. Each session to such a device waits until a second
+	// identity which may access the device approves it with the approve
+	// command or Approve, or exits after 5 minutes. For devices which are
+	// also ReadOnly, each grant of write access must be approved instead.
+	RequireApproval map[string]bool
+
+	// ReadOnly optionally lists devices whose sessions attach read-only, and
+	// discard input until their identity is granted write access for up to
+	// an hour with the write command. Write access automatically reverts to
+	// read-only when it expires.
+	ReadOnly map[string]bool
+
+	// RequireReason optionally lists devices for which each session must
+	// enter a one-line reason, such as a change ticket, before it attaches.
+	// The reason is logged and passed in SessionInfo, the EventSessionOpen
+	// event, and any Approval.
+	RequireReason map[string]bool
+
+	// Identities authenticates SSH public keys. If nil, all authentication
+	// attempts are rejected.
+	Identities *Identities
+
+	// Authorize, if not nil, is called each time Identities accepts a public
+	// key. If it returns an error, authentication fails. This allows an
+	// external authorization service to further restrict access, such as to
+	// the identities which are currently on call.
+	Authorize func(ctx context.Context, req AuthRequest) error
+
+	// OnAttach, if not nil, is called in its own goroutine each time an SSH
+	// session attaches to a device. The context is canceled when the session
+	// detaches.
+	OnAttach func(ctx context.Context, info SessionInfo)
+
+	// OnDetach, if not nil, is called in its own goroutine each time an SSH
+	// session detaches from a device, with a summary of the session.
+	OnDetach func(info SessionInfo, sum SessionSummary)
+
+	// BeforeAttach, if not nil, is called before an SSH session attaches to
+	// a device, and AfterDetach after it detaches. Unlike OnAttach and
+	// OnDetach, they are called synchronously, so they are ordered with the
+	// session's input and output and with each other, and must not block.
+	BeforeAttach, AfterDetach func(info SessionInfo)
+
+	// Scrollback, if not nil, returns the recent output of a device, which is
+	// searched by the search command. It returns false if the device's output
+	// is not retained.
+	Scrollback func(device string) ([]byte, bool)
+
+	// DeviceGroups optionally assigns devices to named groups, such as the
+	// devices in a rack, for the group command and Server.GroupStatus. A
+	// device may be in more than one group.
+	DeviceGroups map[string][]string
+
+	// PowerCycle, if not nil, power cycles device for the "group power-cycle"
+	// command and Server.PowerCycleGroup, such as by controlling a PDU. Its
+	// context is canceled after one minute.
+	PowerCycle func(ctx context.Context, device string) error
+
+	// Honeypot, if not nil, accepts password authentication from any client
+	// and presents a fake console to its sessions, rather than rejecting it,
+	// and to any session for an unknown device. Passwords never authenticate
+	// an identity, so these clients never reach a device or command. Honeypot is called with each password and line of
+	// input. Each client address may have one honeypot session at a time,
+	// which is slowed down and closed after a few login attempts.
+	Honeypot func(in HoneypotInput)
+
+	// Logger receives server logs. If nil, logs are discarded.
+	Logger *log.Logger
+
+	// ErrorLogger, if not nil, receives server errors instead of Logger, such
+	// as when Logger discards less severe messages. If nil, errors are sent to
+	// Logger.
+	ErrorLogger *log.Logger
+
+	// AuthLogger additionally receives authentication failures in the format
+	// described by AuthFailureFormat. If nil, authentication failures are only
+	// sent to Logger.
+	AuthLogger *log.Logger
+
+	// OpenSSHAuthLogger, if not nil, receives authentication successes and
+	// failures in the format described by OpenSSHAuthFormat.
+	OpenSSHAuthLogger *log.Logger
+
+	// Metrics receives server metrics. If nil, metrics are discarded. Each
+	// Server must use its own Metrics to avoid duplicate registrations, and
+	// the Server calls its OnConstScrape method to report const metrics.
+	Metrics metricslite.Interface
+}
+
+// An AuthRequest describes a public key accepted by Identities, which is
+// passed to ServerConfig.Authorize.
+type AuthRequest struct {
+	// User is the SSH user name, which is usually a device name.
+	User string
+
+	// Identity is the name of the authenticated identity.
+	Identity string
+
+	// Fingerprint is the SHA256 fingerprint of the public key.
+	Fingerprint string
+
+	// Addr is the remote address of the SSH client.
+	Addr net.Addr
+}
+
+// SessionInfo describes an SSH session attached to a device.
+type SessionInfo struct {
+	// Device is the name of the device.
+	Device string
+
+	// Identity is the name of the authenticated identity.
+	Identity string
+
+	// Addr is the remote address of the SSH client.
+	Addr net.Addr
+
+	// Reason is the reason entered for the session, if its device is
+	// configured by ServerConfig.RequireReason.
+	Reason string
+}
+
+// SessionSummary describes an SSH session which has detached from a device.
+type SessionSummary struct {
+	// Start and End are the times the session attached and detached.
+	Start, End time.Time
+
+	// BytesIn and BytesOut are the number of bytes the session wrote to and
+	// read from the device.
+	BytesIn, BytesOut int64
+}
+
+// NewServer creates a Server configured to open connections to the devices in
+// cfg.
+func NewServer(cfg ServerConfig) (*Server, error) {
+	srv := &ssh.Server{}
+
+	ids := cfg.Identities
+	if ids == nil {
+		ids, _ = NewIdentities(nil, nil, nil)
+	}
+
+	ll := cfg.Logger
+	if ll == nil {
+		ll = log.New(io.Discard, "", 0)
+	}
+	el := cfg.ErrorLogger
+	if el == nil {
+		el = ll
+	}
+
+	s := &Server{
+		s:        srv,
+		devices:  cfg.Devices,
+		metadata: cfg.DeviceMetadata,
+
+		reserved:   reservations{now: time.Now},
+		writes:     writeGrants{now: time.Now},
+		passphrase: cfg.HostKeyPassphrase,
+		onAttach:   cfg.OnAttach,
+		onDetach:   cfg.OnDetach,
+		before:     cfg.BeforeAttach,
+		after:      cfg.AfterDetach,
+		scrollback: cfg.Scrollback,
+		approval:   cfg.RequireApproval,
+		reason:     cfg.RequireReason,
+		readOnly:   cfg.ReadOnly,
+		groups:     cfg.DeviceGroups,
+		keepAlive:  cfg.KeepAlive,
+		authorize:  cfg.Authorize,
+
+		powerCycleFn: cfg.PowerCycle,
+		limits: connLimiter{
+			max:      cfg.MaxConnections,
+			maxPerIP: cfg.MaxConnectionsPerIP,
+		},
+
+		ll: ll,
+		el: el,
+		al: cfg.AuthLogger,
+		ol: cfg.OpenSSHAuthLogger,
+	}
+	s.ids.Store(ids)
+	s.mm = newMetrics(cfg.Metrics, s.holders)
+
+	if len(cfg.HostKey) > 0 {
+		if err := s.SetHostKey(cfg.HostKey); err != nil {
+			return nil, err
+		}
+	}
+
+	srv.Version = cfg.Version
+	srv.ServerConfigCallback = func(ssh.Context) *gossh.ServerConfig {
+		return &gossh.ServerConfig{
+			Config: gossh.Config{
+				KeyExchanges: cfg.KeyExchanges,
+				Ciphers:      cfg.Ciphers,
+				MACs:         cfg.MACs,
+			},
+		}
+	}
+	srv.ConnCallback = s.connect
+	srv.ConnectionFailedCallback = s.connectFailed
+	srv.PublicKeyHandler = s.pubkeyAuth
+	srv.Handler = s.guard(s.handle)
+	srv.SubsystemHandlers = map[string]ssh.SubsystemHandler{
+		ListSubsystem:        s.guard(func(session ssh.Session) { s.list(session, false) }),
+		ListDetailsSubsystem: s.guard(func(session ssh.Session) { s.list(session, true) }),
+		EventsSubsystem:      s.guard(s.streamEvents),
+	}
+	if cfg.Honeypot != nil {
+		s.hp = &honeypot{
+			record:  cfg.Honeypot,
+			timeout: honeypotTimeout,
+			delay:   honeypotDelay,
+			active:  make(set[string]),
+		}
+		srv.PasswordHandler = s.passwordAuth
+	}
+
+	// Publish changes to the state of each device's mux.
+	for name, d := range s.devices {
+		d.m.setOnState(func(state string, err error) {
+			switch state {
+			case "reconnected":
+				s.states.set(name, StateOK)
+			default:
+				s.states.set(name, state)
+			}
+
+			s.Publish(Event{
+				Type:    EventDeviceState,
+				Device:  name,
+				State:   state,
+				Message: err.Error(),
+			})
+		})
+	}
+
+	return s, nil
+}
+
+// SetIdentities replaces the Identities which authenticate SSH public keys and
+// authorize access to devices, such as after the configuration changes. Each
+// authentication and access check uses the Identities current at the time,
+// but sessions which are already attached to a device remain attached. It is
+// safe for concurrent use with Serve.
+func (s *Server) SetIdentities(ids *Identities) {
+	if ids == nil {
+		ids, _ = NewIdentities(nil, nil, nil)
+	}
+
+	s.ids.Store(ids)
+}
+
+// identities returns the current Identities.
+func (s *Server) identities() *Identities { return s.ids.Load() }
+
+// ErrServerClosed is returned by Server.Serve after a call to Server.Shutdown.
+var ErrServerClosed = ssh.ErrServerClosed
+
+// Serve begins serving SSH connections on l.
+func (s *Server) Serve(l net.Listener) error { return s.s.Serve(l) }
+
+// Shutdown stops accepting SSH connections and waits for the existing
+// connections to close. Once ctx is done, any remaining connections are closed.
+func (s *Server) Shutdown(ctx context.Context) error {
+	err := s.s.Shutdown(ctx)
+	if ctx.Err() != nil {
+		return s.s.Close()
+	}
+
+	return err
+}
+
+// Sessions returns the number of SSH sessions attached to device. It is safe
+// for concurrent use with Serve.
+func (s *Server) Sessions(device string) int { return s.sessions.count(device) }
+
+// Reservations returns the current device reservations made with the
+// "reserve" SSH command, sorted by device name. It is safe for concurrent use
+// with Serve.
+func (s *Server) Reservations() []Reservation { return s.reserved.list() }
+
+// RestoreReservations restores reservations previously returned by
+// Reservations, such as those saved before a restart, and returns the number
+// restored. Reservations of unknown devices, expired reservations, and devices
+// which have since been reserved are skipped. It is safe for concurrent use
+// with Serve.
+func (s *Server) RestoreReservations(rs []Reservation) int {
+	var n int
+	for _, r := range rs {
+		if _, ok := s.devices[r.Device]; !ok {
+			continue
+		}
+		if s.reserved.restore(r) {
+			n++
+		}
+	}
+
+	return n
+}
+
+// Holder returns the identity using device: the identity which reserved it,
+// or otherwise the identity of its longest attached SSH session. It returns
+// false if device is neither reserved nor attached. It is safe for concurrent
+// use with Serve.
+func (s *Server) Holder(device string) (Holder, bool) {
+	if r, ok := s.reserved.get(device); ok {
+		return Holder{Identity: r.Identity, Source: HolderReservation}, true
+	}
+
+	if as := s.sessions.list(device); len(as) > 0 {
+		return Holder{Identity: as[0].id, Source: HolderSession}, true
+	}
+
+	return Holder{}, false
+}
+
+// holders returns the Holder of each device in use.
+func (s *Server) holders() map[string]Holder {
+	hs := make(map[string]Holder)
+	for name := range s.devices {
+		if h, ok := s.Holder(name); ok {
+			hs[name] = h
+		}
+	}
+
+	return hs
+}
+
+// Notify writes a notification on its own line to each SSH session attached
+// to device. It is safe for concurrent use with Serve.
+func (s *Server) Notify(device, format string, v ...any) {
+	notify(s.sessions.list(device), nil, format, v...)
+}
+
+// SetHostKey parses a PEM-encoded SSH host private key and presents it to new
+// connections, which enables host key rotation without interrupting existing
+// connections. The key replaces any previous host key of the same type, while
+// host keys of other types continue to be presented alongside it. SetHostKey
+// is safe for concurrent use with Serve.
+func (s *Server) SetHostKey(pem []byte) error {
+	signer, err := gossh.ParsePrivateKey(pem)
+	var perr *gossh.PassphraseMissingError
+	if errors.As(err, &perr) && len(s.passphrase) > 0 {
+		signer, err = gossh.ParsePrivateKeyWithPassphrase(pem, s.passphrase)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse host key: %v", err)
+	}
+
+	s.hostMu.Lock()
+	defer s.hostMu.Unlock()
+
+	if s.hostKeys == nil {
+		s.hostKeys = make(map[string]gossh.Signer)
+	}
+	s.hostKeys[signer.PublicKey().Type()] = signer
+
+	s.s.AddHostKey(signer)
+	return nil
+}
+
+// SetHostCertificate parses an SSH host certificate in authorized_keys format
+// and presents it to new connections alongside the host key it certifies,
+// which must have been set with SetHostKey or ServerConfig.HostKey. The
+// certificate replaces any previous certificate for a host key of the same
+// type. SetHostCertificate is safe for concurrent use with Serve.
+func (s *Server) SetHostCertificate(b []byte) error {
+	key, _, _, _, err := gossh.ParseAuthorizedKey(b)
+	if err != nil {
+		return fmt.Errorf("failed to parse host certificate: %v", err)
+	}
+
+	cert, ok := key.(*gossh.Certificate)
+	if !ok || cert.CertType != gossh.HostCert {
+		return errors.New("host certificate is not an SSH host certificate")
+	}
+
+	s.hostMu.Lock()
+	defer s.hostMu.Unlock()
+
+	signer, ok := s.hostKeys[cert.Key.Type()]
+	if !ok || !ssh.KeysEqual(signer.PublicKey(), cert.Key) {
+		return errors.New("host certificate does not match a host key")
+	}
+
+	cs, err := gossh.NewCertSigner(cert, signer)
+	if err != nil {
+		return fmt.Errorf("failed to use host certificate: %v", err)
+	}
+
+	s.s.AddHostKey(cs)
+	return nil
+}
+
+// connect counts each accepted connection and closes it if it exceeds the
+// connection limits.
+func (s *Server) connect(ctx ssh.Context, c net.Conn) net.Conn {
+	s.mm.sshConnections(1.0)
+
+	if dc, ok := c.(*deviceConn); ok {
+		ctx.SetValue(devicesKey{}, dc.devices)
+		c = dc.Conn
+	}
+
+	release, limit, ok := s.limits.acquire(addrString(c.RemoteAddr()))
+	if !ok {
+		s.mm.sshConnectionsLimited(1.0, limit)
+		s.ll.Printf("%s: closing connection, exceeded %s connection limit", addrString(c.RemoteAddr()), limit)
+		return nil
+	}
+
+	if tc, ok := c.(*net.TCPConn); ok && s.keepAlive > 0 {
+		if err := tc.SetKeepAliveConfig(net.KeepAliveConfig{
+			Enable:   true,
+			Idle:     s.keepAlive,
+			Interval: s.keepAlive,
+			Count:    keepAliveProbes,
+		}); err != nil {
+			s.el.Printf("%s: failed to set TCP keepalive: %v", addrString(c.RemoteAddr()), err)
+		}
+	}
+
+	return &limitedConn{Conn: c, release: release}
+}
+
+// keepAliveProbes is the number of unacknowledged TCP keepalive probes after
+// which a connection is considered dead.
+const keepAliveProbes = 3
+
+// connectFailed reports connections which failed the SSH handshake or
+// authentication.
+func (s *Server) connectFailed(c net.Conn, err error) {
+	s.mm.sshHandshakeFailures(1.0)
+	s.el.Printf("%s: SSH handshake failed: %v", addrString(c.RemoteAddr()), err)
+}
+
+// pubkeyAuth authenticates users via SSH public key.
+func (s *Server) pubkeyAuth(ctx ssh.Context, key ssh.PublicKey) bool {
+	_, device := s.devices[ctx.User()]
+	name, ok := s.identities().authenticate(ctx.User(), key, device, ctx.RemoteAddr())
+	if device && !reachable(ctx, ctx.User()) {
+		// Devices which aren't served by this connection's listener can't be
+		// accessed by any identity.
+		ok = false
+	}
+	if ok && s.authorize != nil {
+		err := s.authorize(ctx, AuthRequest{
+			User:        ctx.User(),
+			Identity:    name,
+			Fingerprint: gossh.FingerprintSHA256(key),
+			Addr:        ctx.RemoteAddr(),
+		})
+		if err != nil {
+			s.ll.Printf("%s: denied authorization for %q: %v", addrString(ctx.RemoteAddr()), name, err)
+			ok = false
+		}
+	}
+
+	var id, action string
+	if ok {
+		// Success, log the friendly name of the public key identity.
+		id = name
+		action = "accepted"
+		// Certificates are tracked by the identity they authenticate.
+		ctx.SetValue(fingerprintKey{}, s.identities().toFingerprint[name])
+	} else {
+		// Failure, log the fingerprint of the unknown public key identity.
+		id = gossh.FingerprintSHA256(key)
+		action = "rejected"
+	}
+
+	s.mm.deviceAuthentications(1.0, action)
+
+	// We can't use the logf helper because we don't want to print this
+	// information to the SSH session.
+	s.ll.Printf("%s: %s public key authentication for %q", addrString(ctx.RemoteAddr()), action, id)
+	if !ok {
+		s.authFailure(ctx.RemoteAddr(), ctx.User(), key)
+	}
+	s.openSSHAuth(ctx.RemoteAddr(), ctx.User(), key, ok)
+
+	return ok
+}
+
+// openSSHAuth logs an authentication attempt in OpenSSHAuthFormat.
+func (s *Server) openSSHAuth(addr net.Addr, user string, key ssh.PublicKey, ok bool) {
+	if s.ol == nil {
+		return
+	}
+
+	host, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		host, port = addr.String(), "0"
+	}
+
+	result := "Failed"
+	if ok {
+		result = "Accepted"
+	}
+
+	s.ol.Printf(OpenSSHAuthFormat, result, user, host, port, openSSHKeyType(key.Type()), gossh.FingerprintSHA256(key))
+}
+
+// openSSHKeyType returns the short name OpenSSH uses to log a public key of
+// type typ, such as "ED25519" for "ssh-ed25519".
+func openSSHKeyType(typ string) string {
+	var cert bool
+	if t, ok := strings.CutSuffix(typ, "-cert-v01@openssh.com"); ok {
+		typ, cert = t, true
+	}
+
+	var name string
+	switch typ {
+	case gossh.KeyAlgoED25519:
+		name = "ED25519"
+	case gossh.KeyAlgoSKED25519:
+		name = "ED25519-SK"
+	case gossh.KeyAlgoRSA:
+		name = "RSA"
+	case gossh.KeyAlgoECDSA256, gossh.KeyAlgoECDSA384, gossh.KeyAlgoECDSA521:
+		name = "ECDSA"
+	case gossh.KeyAlgoSKECDSA256:
+		name = "ECDSA-SK"
+	case gossh.KeyAlgoDSA:
+		name = "DSA"
+	default:
+		return strings.ToUpper(typ)
+	}
+
+	if cert {
+		name += "-CERT"
+	}
+
+	return name
+}
+
+// authFailure logs an authentication failure in AuthFailureFormat.
+func (s *Server) authFailure(addr net.Addr, user string, key ssh.PublicKey) {
+	host, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		host, port = addr.String(), "0"
+	}
+
+	msg := fmt.Sprintf(AuthFailureFormat, user, host, port, key.Type(), gossh.FingerprintSHA256(key))
+	s.ll.Print(msg)
+	if s.al != nil {
+		s.al.Print(msg)
+	}
+}
+
+// handle handles an opened SSH to serial console session.
+func (s *Server) handle(session ssh.Session) {
+	s.sessionInfo(session)
+
+	if len(session.Command()) > 0 {
+		s.command(session)
+		return
+	}
+
+	// Use usernames to map to valid device multiplexers.
+	mux, ok := s.devices[session.User()]
+	if !ok && session.User() == WatchUser {
+		f, _ := session.Context().Value(fingerprintKey{}).(string)
+		if err := s.watch(session, f, nil); err != nil {
+			s.logf(session, "exiting, %v", err)
+			_ = session.Exit(1)
+			return
+		}
+
+		_ = session.Exit(0)
+		return
+	}
+	if !ok {
+		// No such connection.
+		s.mm.deviceUnknownSessions(1.0)
+		if s.hp != nil {
+			// Present the same fake console as to password clients, so that
+			// unknown connections can't be told apart from devices.
+			s.honeypotSession(session)
+			return
+		}
+
+		s.logf(session, "exiting, unknown connection %q", session.User())
+		_ = session.Exit(1)
+		return
+	}
+
+	s.attach(session, session.User(), mux)
+}
+
+// attach proxies session to device's mux until either is closed, once the
+// session's identity is permitted to use the device.
+func (s *Server) attach(session ssh.Session, device string, mux *MuxDevice) {
+	// consrv's own messages are written to msgs. For a non-interactive client
+	// such as `ssh foo@consrv | tee foo.log`, those messages are sent to stderr
+	// so that stdout carries nothing but the device's output.
+	var msgs ssh.Session = session
+	if !interactive(session) {
+		msgs = stderrSession{session}
+	}
+
+	// Only the identity holding a device's reservation may attach to it.
+	f, _ := session.Context().Value(fingerprintKey{}).(string)
+	id := s.identities().toName[f]
+	if r, ok := s.reserved.get(device); ok && r.Identity != id {
+		s.logf(msgs, "exiting, %q is reserved by %s until %s", device, r.Identity, formatUntil(r.Until, s.reserved.now()))
+		_ = session.Exit(1)
+		return
+	}
+
+	var reason string
+	if s.reason[device] {
+		var err error
+		reason, err = s.promptReason(msgs)
+		if err != nil {
+			s.logf(msgs, "exiting, %v", err)
+			_ = session.Exit(1)
+			return
+		}
+
+		s.ll.Printf("%s: %s gave reason %q for device %q", addrString(session.RemoteAddr()), id, reason, device)
+	}
+
+	// Read-only devices require approval for write access instead.
+	if s.approval[device] && !s.readOnly[device] {
+		if err := s.awaitApproval(msgs, device, id, reason); err != nil {
+			s.logf(msgs, "exiting, %v", err)
+			_ = session.Exit(1)
+			return
+		}
+	}
+
+	done := s.mm.newSession(device)
+	defer done()
+
+	// Begin proxying between SSH and serial console mux until the SSH
+	// connection closes or is broken.
+	s.logf(msgs, "opened serial connection %s", mux.String())
+
+	ctx, cancel := context.WithCancel(session.Context())
+	defer cancel()
+
+	// Let everyone know when a console is shared, since simultaneous use of
+	// a console is otherwise confusing.
+	a := &attached{
+		id:   id,
+		addr: addrString(session.RemoteAddr()),
+		w:    msgs,
+	}
+
+	s.Publish(Event{Type: EventSessionOpen, Device: device, Identity: id, Address: a.addr, Message: reason})
+	defer s.Publish(Event{Type: EventSessionClose, Device: device, Identity: id, Address: a.addr})
+
+	all := s.sessions.attach(device, a)
+	if len(all) > 1 {
+		s.logf(msgs, "%d sessions attached: %s", len(all), describe(all))
+		notify(all, a, "%s joined console %q [sessions: %d]", a, device, len(all))
+	}
+	defer func() {
+		rest := s.sessions.detach(device, a)
+		notify(rest, nil, "%s left console %q [sessions: %d]", a, device, len(rest))
+	}()
+
+	info := SessionInfo{
+		Device:   device,
+		Identity: a.id,
+		Addr:     session.RemoteAddr(),
+		Reason:   reason,
+	}
+	if s.onAttach != nil {
+		go s.onAttach(ctx, info)
+	}
+
+	sum := SessionSummary{Start: time.Now()}
+	if s.onDetach != nil {
+		defer func() {
+			sum.End = time.Now()
+			go s.onDetach(info, sum)
+		}()
+	}
+
+	// Synchronous hooks run before input and output flow, and once they stop.
+	if s.before != nil {
+		s.before(info)
+	}
+	if s.after != nil {
+		defer s.after(info)
+	}
+
+	// Create a new io.Reader handle from the mux for this client, so it will
+	// receive the same output as other clients for the duration of its session.
+	//
+	// We can't use the logf helper beyond this point because we don't want to
+	// print any further information to the SSH session, other than the reason
+	// the device failed.
+	//
+	// The client is detached as soon as the session ends, rather than when the
+	// mux next dispatches a read, which may never happen for an idle device.
+	r := mux.Attach(ctx)
+	defer mux.Detach(r)
+
+	// eofCopy is a context-aware io.Copy that consumes io.EOF errors and is
+	// specialized for errgroup use.
+	//
+	// The bytes copied are counted as they are written, so that a session which
+	// floods a device is visible before it closes.
+	eofCopy := func(ctx context.Context, w io.Writer, r io.Reader, n *int64, c metricslite.Counter) func() error {
+		return func() error {
+			var err error
+			*n, err = io.Copy(
+				&countWriter{w: contextio.NewWriter(ctx, w), c: c, labels: []string{device, id}},
+				contextio.NewReader(ctx, r),
+			)
+
+			var derr *DeviceError
+			if errors.As(err, &derr) {
+				// Tell the client why its session is about to end.
+				s.errorf(msgs, "device %s failed: %v", mux, derr.Err)
+			}
+
+			// End the SSH session and detach from the mux to make the other
+			// eofCopy goroutine return, even if it is blocked reading from a
+			// silent device or an SSH channel which will never close.
+			_ = session.Exit(1)
+			cancel()
+			return err
+		}
+	}
+
+	var w io.Writer = mux
+	if s.readOnly[device] {
+		w = &readOnlyWriter{
+			w:       mux,
+			allowed: func() bool { return s.writes.allowed(device, id) },
+			denied: func() {
+				notify([]*attached{a}, nil, "%q is read-only, request write access with: ssh consrv@<host> write %s <duration>", device, device)
+			},
+		}
+	}
+
+	eg, ctx := errgroup.WithContext(ctx)
+	eg.Go(eofCopy(ctx, w, session, &sum.BytesIn, s.mm.sessionInputBytes))
+	eg.Go(eofCopy(ctx, session, r, &sum.BytesOut, s.mm.sessionOutputBytes))
+
+	// Device errors are reported by eofCopy.
+	if err := eg.Wait(); err != nil && !errors.As(err, new(*DeviceError)) {
+		s.el.Printf("%s: error proxying SSH/serial: %v", addrString(session.RemoteAddr()), err)
+	}
+
+	_ = session.Exit(0)
+	s.ll.Printf("%s: closed serial connection %s [identity: %s, in: %d bytes, out: %d bytes]",
+		addrString(session.RemoteAddr()), mux, id, sum.BytesIn, sum.BytesOut)
+}
+
+// list handles the ListSubsystem by printing the devices which the session's
+// identity may access. If details is set, each device's metadata is printed
+// as well for the ListDetailsSubsystem.
+func (s *Server) list(session ssh.Session, details bool) {
+	s.sessionInfo(session)
+
+	var names []string
+	for _, name := range slices.Sorted(maps.Keys(s.devices)) {
+		if s.allowed(session.Context(), name) {
+			names = append(names, name)
+		}
+	}
+
+	for _, name := range names {
+		if !details {
+			fmt.Fprintln(session, name)
+			continue
+		}
+
+		var sb strings.Builder
+		sb.WriteString(name)
+		md := s.metadata[name]
+		for _, k := range slices.Sorted(maps.Keys(md)) {
+			fmt.Fprintf(&sb, " %s=%q", k, md[k])
+		}
+		fmt.Fprintln(session, sb.String())
+	}
+
+	s.ll.Printf("%s: listed %d devices", addrString(session.RemoteAddr()), len(names))
+	_ = session.Exit(0)
+}
+
+// sessionInfo records the public key algorithm and client version used to open
+// session, to spot outdated clients.
+func (s *Server) sessionInfo(session ssh.Session) {
+	var alg string
+	if k := session.PublicKey(); k != nil {
+		alg = k.Type()
+	}
+
+	v := strings.TrimPrefix(session.Context().ClientVersion(), "SSH-2.0-")
+	s.mm.sshSessions(1.0, alg, v)
+}
+
+// interactive reports whether session is used by a person at a terminal
+// rather than by a program, such as a pipeline or a client with TERM=dumb.
+func interactive(session ssh.Session) bool {
+	pty, _, ok := session.Pty()
+	return ok && pty.Term != "dumb"
+}
+
+// A stderrSession is an ssh.Session which writes to the session's stderr.
+type stderrSession struct{ ssh.Session }
+
+func (s stderrSession) Write(b []byte) (int, error) { return s.Session.Stderr().Write(b) }
+
+// logf outputs a formatted log message to both stderr and an SSH client.
+func (s *Server) logf(session ssh.Session, format string, v ...any) {
+	msg := fmt.Sprintf(format, v...)
+	s.ll.Printf("%s: %s", addrString(session.RemoteAddr()), msg)
+	fmt.Fprintf(session, "consrv> %s\n", msg)
+}
+
+// errorf is like logf, but logs to the error logger.
+func (s *Server) errorf(session ssh.Session, format string, v ...any) {
+	msg := fmt.Sprintf(format, v...)
+	s.el.Printf("%s: %s", addrString(session.RemoteAddr()), msg)
+	fmt.Fprintf(session, "consrv> %s\n", msg)
+}
+
+// addrString prints a friendly string for a net.Addr.
+func addrString(addr net.Addr) string {
+	// For TCP connections just show the IP address in logs. Otherwise print the
+	// entire remote address.
+	if ta, ok := addr.(*net.TCPAddr); ok {
+		return ta.IP.String()
+	}
+
+	return addr.String()
+}
@@ -0,0 +1,106 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consrv
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// sessions tracks the SSH sessions attached to each device.
+type sessions struct {
+	mu sync.Mutex
+	m  map[string][]*attached
+}
+
+// An attached is an SSH session attached to a device.
+type attached struct {
+	id, addr string
+	w        io.Writer
+}
+
+// String returns the identity name and address of an attached session.
+func (a *attached) String() string { return fmt.Sprintf("%s (%s)", a.id, a.addr) }
+
+// attach attaches a to device and returns all of the sessions attached to
+// device, including a.
+func (ss *sessions) attach(device string, a *attached) []*attached {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if ss.m == nil {
+		ss.m = make(map[string][]*attached)
+	}
+
+	ss.m[device] = append(ss.m[device], a)
+	return slices.Clone(ss.m[device])
+}
+
+// detach detaches a from device and returns the sessions which remain attached
+// to device.
+func (ss *sessions) detach(device string, a *attached) []*attached {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	ss.m[device] = slices.DeleteFunc(ss.m[device], func(b *attached) bool { return a == b })
+	if len(ss.m[device]) == 0 {
+		delete(ss.m, device)
+		return nil
+	}
+
+	return slices.Clone(ss.m[device])
+}
+
+// count returns the number of sessions attached to device.
+func (ss *sessions) count(device string) int {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	return len(ss.m[device])
+}
+
+// list returns the sessions attached to device.
+func (ss *sessions) list(device string) []*attached {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	return slices.Clone(ss.m[device])
+}
+
+// notify writes a notification to each session in as, except for skip. The
+// notification is written on its own line because the sessions may be in the
+// middle of printing console output.
+func notify(as []*attached, skip *attached, format string, v ...any) {
+	msg := fmt.Sprintf("\r\nconsrv> "+format+"\r\n", v...)
+	for _, a := range as {
+		if a == skip {
+			continue
+		}
+
+		_, _ = io.WriteString(a.w, msg)
+	}
+}
+
+// describe produces a comma-separated list of attached sessions.
+func describe(as []*attached) string {
+	ss := make([]string, 0, len(as))
+	for _, a := range as {
+		ss = append(ss, a.String())
+	}
+
+	return strings.Join(ss, ", ")
+}
@@ -0,0 +1,81 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consrv
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_sessions(t *testing.T) {
+	var (
+		ss sessions
+
+		bufA, bufB, bufC strings.Builder
+
+		a = &attached{id: "alice", addr: "192.0.2.1", w: &bufA}
+		b = &attached{id: "bob", addr: "192.0.2.2", w: &bufB}
+		c = &attached{id: "carol", addr: "192.0.2.3", w: &bufC}
+	)
+
+	if diff := cmp.Diff(1, len(ss.attach("foo", a))); diff != "" {
+		t.Fatalf("unexpected foo sessions (-want +got):\n%s", diff)
+	}
+
+	// Sessions on other devices are tracked independently.
+	if diff := cmp.Diff(1, len(ss.attach("bar", c))); diff != "" {
+		t.Fatalf("unexpected bar sessions (-want +got):\n%s", diff)
+	}
+
+	all := ss.attach("foo", b)
+	if diff := cmp.Diff("alice (192.0.2.1), bob (192.0.2.2)", describe(all)); diff != "" {
+		t.Fatalf("unexpected foo sessions (-want +got):\n%s", diff)
+	}
+
+	if diff := cmp.Diff(2, ss.count("foo")); diff != "" {
+		t.Fatalf("unexpected foo session count (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(describe(all), describe(ss.list("foo"))); diff != "" {
+		t.Fatalf("unexpected foo session list (-want +got):\n%s", diff)
+	}
+
+	notify(all, b, "%s joined", b)
+
+	rest := ss.detach("foo", a)
+	if diff := cmp.Diff("bob (192.0.2.2)", describe(rest)); diff != "" {
+		t.Fatalf("unexpected remaining sessions (-want +got):\n%s", diff)
+	}
+
+	notify(rest, nil, "%s left", a)
+
+	if diff := cmp.Diff("\r\nconsrv> bob (192.0.2.2) joined\r\n", bufA.String()); diff != "" {
+		t.Fatalf("unexpected alice notifications (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff("\r\nconsrv> alice (192.0.2.1) left\r\n", bufB.String()); diff != "" {
+		t.Fatalf("unexpected bob notifications (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff("", bufC.String()); diff != "" {
+		t.Fatalf("unexpected carol notifications (-want +got):\n%s", diff)
+	}
+
+	if got := ss.detach("foo", b); len(got) != 0 {
+		t.Fatalf("expected no remaining sessions, but got: %s", describe(got))
+	}
+
+	if diff := cmp.Diff(0, ss.count("foo")); diff != "" {
+		t.Fatalf("unexpected foo session count (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,359 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consrv
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// soak runs TestMuxSoak for longer than its default duration, such as:
+//
+//	go test -race -run TestMuxSoak -consrv.soak 10m
+var soak = flag.Duration("consrv.soak", 0, "duration of the mux soak test")
+
+func TestMuxSoak(t *testing.T) {
+	d := *soak
+	if d == 0 {
+		d = 500 * time.Millisecond
+		if testing.Short() {
+			t.Skip("skipping soak test in short mode")
+		}
+	}
+
+	vd := newVirtualDevice()
+	mux := NewMuxDeviceConfig(vd, MuxConfig{
+		ReadSize: 512,
+		OnError:  vd.reconnect,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	spawn := func(fn func()) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fn()
+		}()
+	}
+
+	// The far side of the device continuously writes a pattern of output
+	// which clients verify, in writes ranging from single bytes to 1MiB.
+	spawn(func() { vd.serve(ctx) })
+
+	// The device disconnects and reconnects at random intervals.
+	spawn(func() {
+		for ctx.Err() == nil {
+			time.Sleep(time.Duration(rand.IntN(50)) * time.Millisecond)
+			vd.flap()
+		}
+	})
+
+	// Clients attach and detach concurrently, and some read slowly with small
+	// buffers.
+	for i := 0; i < 8; i++ {
+		spawn(func() {
+			for ctx.Err() == nil {
+				if err := soakClient(ctx, mux); err != nil {
+					panic(err)
+				}
+			}
+		})
+	}
+
+	// Sessions write to the device concurrently, including giant writes.
+	for i := 0; i < 2; i++ {
+		spawn(func() {
+			b := bytes.Repeat([]byte{'x'}, 256*1024)
+			for ctx.Err() == nil {
+				_, _ = mux.Write(b[:1+rand.IntN(len(b))])
+			}
+		})
+	}
+
+	<-ctx.Done()
+
+	// Everything must stop promptly once the device is closed.
+	timer := time.AfterFunc(10*time.Second, func() {
+		panic("soak test did not stop")
+	})
+	defer timer.Stop()
+
+	if err := mux.Close(); !errors.Is(err, io.EOF) {
+		t.Fatalf("expected EOF after close, but got: %v", err)
+	}
+	wg.Wait()
+}
+
+// soakClient attaches to mux for a random duration and verifies that the
+// output it reads follows the pattern written by a virtualDevice.
+func soakClient(ctx context.Context, mux *MuxDevice) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(1+rand.IntN(50))*time.Millisecond)
+	defer cancel()
+
+	r := mux.Attach(ctx)
+	if rand.IntN(2) == 0 {
+		// Detach explicitly rather than waiting for the context.
+		defer mux.Detach(r)
+	}
+
+	var (
+		b    = make([]byte, 1+rand.IntN(4096))
+		slow = rand.IntN(4) == 0
+		last = -1
+	)
+
+	for {
+		n, err := r.Read(b)
+		for _, c := range b[:n] {
+			if last != -1 && int(c) != (last+1)%patternSize {
+				return fmt.Errorf("unexpected output byte %d after %d", c, last)
+			}
+			last = int(c)
+		}
+
+		var derr *DeviceError
+		switch {
+		case err == nil:
+		case errors.Is(err, io.EOF) && !errors.As(err, &derr):
+			// Detached.
+			return nil
+		case errors.As(err, &derr):
+			// The device disconnected, and output resumes from another
+			// point in the pattern after it reconnects.
+			return nil
+		default:
+			return fmt.Errorf("unexpected read error: %v", err)
+		}
+
+		if slow {
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
+
+// patternSize is the length of the repeating output pattern written by a
+// virtualDevice, which is prime so that it doesn't align with buffer sizes.
+const patternSize = 251
+
+var _ Device = &virtualDevice{}
+
+// A virtualDevice is an in-memory Device with a far side which produces
+// output and consumes input, as a serial console would. The device may be
+// disconnected and reconnected to simulate a flapping USB serial adapter.
+type virtualDevice struct {
+	remoteC chan net.Conn
+
+	mu     sync.Mutex
+	local  net.Conn
+	remote net.Conn
+	closed bool
+}
+
+// newVirtualDevice creates a connected virtualDevice.
+func newVirtualDevice() *virtualDevice {
+	vd := &virtualDevice{remoteC: make(chan net.Conn, 1)}
+	vd.reconnect(nil)
+	return vd
+}
+
+// Read implements Device.
+func (vd *virtualDevice) Read(b []byte) (int, error) {
+	vd.mu.Lock()
+	local, closed := vd.local, vd.closed
+	vd.mu.Unlock()
+
+	if closed {
+		return 0, io.EOF
+	}
+
+	n, err := local.Read(b)
+	if err != nil {
+		vd.mu.Lock()
+		defer vd.mu.Unlock()
+
+		if vd.closed {
+			// Report the closed device consistently.
+			return n, io.EOF
+		}
+	}
+
+	return n, err
+}
+
+// Write implements Device.
+func (vd *virtualDevice) Write(b []byte) (int, error) {
+	vd.mu.Lock()
+	local := vd.local
+	vd.mu.Unlock()
+
+	return local.Write(b)
+}
+
+// Close implements Device.
+func (vd *virtualDevice) Close() error {
+	vd.mu.Lock()
+	defer vd.mu.Unlock()
+
+	vd.closed = true
+	_ = vd.remote.Close()
+	return vd.local.Close()
+}
+
+// String implements Device.
+func (*virtualDevice) String() string { return "virtual" }
+
+// flap disconnects the far side of the device, causing reads to fail until the
+// device is reconnected.
+func (vd *virtualDevice) flap() {
+	vd.mu.Lock()
+	defer vd.mu.Unlock()
+
+	_ = vd.remote.Close()
+}
+
+// reconnect implements MuxConfig.OnError by connecting a new far side, unless
+// the device is closed.
+func (vd *virtualDevice) reconnect(_ error) bool {
+	vd.mu.Lock()
+	defer vd.mu.Unlock()
+
+	if vd.closed {
+		return false
+	}
+
+	if vd.local != nil {
+		_ = vd.local.Close()
+	}
+
+	vd.local, vd.remote = net.Pipe()
+
+	// Replace any far side which the server hasn't picked up yet, so that
+	// reconnecting never blocks.
+	select {
+	case <-vd.remoteC:
+	default:
+	}
+	vd.remoteC <- vd.remote
+	return true
+}
+
+// serve runs the far side of the device until ctx is canceled, writing the
+// output pattern and discarding input.
+func (vd *virtualDevice) serve(ctx context.Context) {
+	pattern := make([]byte, 1024*1024+patternSize)
+	for i := range pattern {
+		pattern[i] = byte(i % patternSize)
+	}
+
+	var (
+		wg  sync.WaitGroup
+		off int
+	)
+	defer wg.Wait()
+
+	for {
+		var remote net.Conn
+		select {
+		case <-ctx.Done():
+			return
+		case remote = <-vd.remoteC:
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _ = io.Copy(io.Discard, remote)
+		}()
+
+		for ctx.Err() == nil {
+			size := 1 + rand.IntN(64*1024)
+			if rand.IntN(100) == 0 {
+				size = 1024 * 1024
+			}
+
+			// Continue the pattern from wherever the last write stopped, even
+			// if the device disconnected during the write.
+			n, err := remote.Write(pattern[off : off+size])
+			off = (off + n) % patternSize
+			if err != nil {
+				break
+			}
+		}
+	}
+}
+
+func FuzzMux(f *testing.F) {
+	f.Add([]byte("hello, world"), uint16(1), uint16(1))
+	f.Add(bytes.Repeat([]byte("consrv"), 4096), uint16(8192), uint16(7))
+	f.Add([]byte{0x00, 0xff, 0x1b, '\r', '\n'}, uint16(3), uint16(4096))
+
+	f.Fuzz(func(t *testing.T, data []byte, readSize, bufSize uint16) {
+		r, w := io.Pipe()
+		m := NewMuxConfig(r, MuxConfig{ReadSize: int(readSize % 16384)})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		// Two clients read with different buffer sizes, and each must receive
+		// all of the output in order.
+		var (
+			clients = []io.Reader{m.Attach(ctx), m.Attach(ctx)}
+			sizes   = []int{1 + int(bufSize%4096), 1 + int(bufSize%13)}
+			got     = make([][]byte, len(clients))
+			wg      sync.WaitGroup
+		)
+
+		for i, c := range clients {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				b := make([]byte, sizes[i])
+				for {
+					n, err := c.Read(b)
+					got[i] = append(got[i], b[:n]...)
+					if err != nil {
+						return
+					}
+				}
+			}()
+		}
+
+		go func() {
+			_, _ = w.Write(data)
+			_ = w.Close()
+		}()
+
+		wg.Wait()
+		_ = m.Close()
+
+		for i := range got {
+			if !bytes.Equal(data, got[i]) {
+				t.Fatalf("client %d received %d bytes, but %d were written", i, len(got[i]), len(data))
+			}
+		}
+	})
+}
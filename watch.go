@@ -0,0 +1,190 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consrv
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/dolmen-go/contextio"
+	"github.com/gliderlabs/ssh"
+)
+
+// WatchUser is the SSH user name which watches the output of every device the
+// authenticated identity may access in a single read-only session, with each
+// line prefixed by its device name. Specific devices may be watched with the
+// watch command instead:
+//
+//	$ ssh -p 2222 watch@monitnerr-1
+//	$ ssh -p 2222 consrv@monitnerr-1 watch server desktop
+//
+// A device named WatchUser is attached to as usual.
+const WatchUser = "watch"
+
+// watch streams the output of the named devices to session until the session
+// ends or the client presses Ctrl-C. If names is empty, every device the
+// session of the identity with public key fingerprint f may access is watched,
+// except for devices reserved by another identity.
+func (s *Server) watch(session ssh.Session, f string, names []string) error {
+	id := s.identities().toName[f]
+	reserved := func(device string) (Reservation, bool) {
+		r, ok := s.reserved.get(device)
+		return r, ok && r.Identity != id
+	}
+
+	if len(names) == 0 {
+		for _, name := range slices.Sorted(maps.Keys(s.devices)) {
+			if _, ok := reserved(name); ok || !s.allowed(session.Context(), name) {
+				continue
+			}
+
+			names = append(names, name)
+		}
+		if len(names) == 0 {
+			return errors.New("no devices to watch")
+		}
+	}
+
+	var width int
+	for _, name := range names {
+		if _, ok := s.devices[name]; !ok || !s.allowed(session.Context(), name) {
+			return fmt.Errorf("unknown device %q", name)
+		}
+		if r, ok := reserved(name); ok {
+			return fmt.Errorf("%q is reserved by %s until %s", name, r.Identity, formatUntil(r.Until, s.reserved.now()))
+		}
+
+		width = max(width, len(name))
+	}
+
+	ctx, cancel := context.WithCancel(session.Context())
+	defer cancel()
+
+	// Attach to every device before announcing the watch, so no output which
+	// follows the announcement is missed.
+	rs := make([]io.Reader, 0, len(names))
+	for _, name := range names {
+		rs = append(rs, s.devices[name].Attach(ctx))
+	}
+
+	s.logf(session, "watching %s, press Ctrl-C to exit", strings.Join(names, ", "))
+
+	ww := &watchWriter{w: session, width: width}
+	var wg sync.WaitGroup
+	for i, name := range names {
+		mux, r := s.devices[name], rs[i]
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer mux.Detach(r)
+
+			w := &watchDevice{ww: ww, name: name}
+			_, err := io.Copy(w, contextio.NewReader(ctx, r))
+
+			var derr *DeviceError
+			if errors.As(err, &derr) {
+				fmt.Fprintf(w, "consrv> device %s failed: %v\n", mux, derr.Err)
+			}
+		}()
+	}
+
+	// The session is read-only, so input is discarded other than Ctrl-C, which
+	// ends the session.
+	go func() {
+		b := make([]byte, 256)
+		for {
+			n, err := session.Read(b)
+			if bytes.IndexByte(b[:n], 0x03) >= 0 {
+				cancel()
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	wg.Wait()
+	s.ll.Printf("%s: stopped watching %s", addrString(session.RemoteAddr()), strings.Join(names, ", "))
+	return nil
+}
+
+// A watchWriter interleaves lines of output from several devices, prefixing
+// each line with the name of its device.
+type watchWriter struct {
+	mu    sync.Mutex
+	w     io.Writer
+	width int
+
+	// The device which last wrote, and whether it did not end its line.
+	last    string
+	midLine bool
+}
+
+// write writes b from device to the underlying io.Writer.
+func (ww *watchWriter) write(device string, b []byte) error {
+	ww.mu.Lock()
+	defer ww.mu.Unlock()
+
+	var out []byte
+	if ww.midLine && ww.last != device {
+		// Finish the other device's line so this output is prefixed.
+		out = append(out, "\r\n"...)
+		ww.midLine = false
+	}
+	ww.last = device
+
+	for len(b) > 0 {
+		if !ww.midLine {
+			out = fmt.Appendf(out, "%-*s | ", ww.width, device)
+		}
+
+		i := bytes.IndexByte(b, '\n')
+		if i == -1 {
+			out = append(out, b...)
+			ww.midLine = true
+			break
+		}
+
+		out = append(out, b[:i+1]...)
+		b = b[i+1:]
+		ww.midLine = false
+	}
+
+	_, err := ww.w.Write(out)
+	return err
+}
+
+// A watchDevice is an io.Writer for a single device's output to a
+// watchWriter.
+type watchDevice struct {
+	ww   *watchWriter
+	name string
+}
+
+func (wd *watchDevice) Write(b []byte) (int, error) {
+	if err := wd.ww.write(wd.name, b); err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
@@ -0,0 +1,204 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consrv
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSSHWatch(t *testing.T) {
+	foo, bar := newVirtualDevice(), newVirtualDevice()
+	devices := map[string]*MuxDevice{
+		"foo":    NewMuxDevice(foo),
+		"bar":    NewMuxDevice(bar),
+		"secret": NewMuxDevice(&testDevice{}),
+		"held":   NewMuxDevice(&testDevice{}),
+	}
+
+	// Devices which the identity can't access or are reserved by another
+	// identity are not watched.
+	srv, addr := testServer(t, devices, map[string][]string{
+		"foo":    nil,
+		"bar":    nil,
+		"held":   nil,
+		"secret": {"other"},
+	})
+	srv.reserved.reserve("held", "other", 1*time.Hour)
+
+	s := testDial(t, addr, WatchUser, mustKey(testHostPublic))
+	stdin, err := s.StdinPipe()
+	if err != nil {
+		t.Fatalf("failed to get stdin: %v", err)
+	}
+	stdout, err := s.StdoutPipe()
+	if err != nil {
+		t.Fatalf("failed to get stdout: %v", err)
+	}
+	if err := s.Shell(); err != nil {
+		t.Fatalf("failed to start shell: %v", err)
+	}
+
+	br := bufio.NewReader(stdout)
+	read := func(n int) string {
+		t.Helper()
+
+		b := make([]byte, n)
+		if _, err := io.ReadFull(br, b); err != nil {
+			t.Fatalf("failed to read output: %v", err)
+		}
+
+		return string(b)
+	}
+
+	const banner = "consrv> watching bar, foo, press Ctrl-C to exit\n"
+	if diff := cmp.Diff(banner, read(len(banner))); diff != "" {
+		t.Fatalf("unexpected banner (-want +got):\n%s", diff)
+	}
+
+	fooR, barR := <-foo.remoteC, <-bar.remoteC
+	write := func(c io.Writer, s string) {
+		t.Helper()
+
+		if _, err := io.WriteString(c, s); err != nil {
+			t.Fatalf("failed to write device output: %v", err)
+		}
+	}
+
+	// Complete lines are prefixed, and a partial line is ended when another
+	// device produces output.
+	write(fooR, "login: \r\n")
+	want := "foo | login: \r\n"
+	if diff := cmp.Diff(want, read(len(want))); diff != "" {
+		t.Fatalf("unexpected foo output (-want +got):\n%s", diff)
+	}
+
+	write(barR, "Booting")
+	want = "bar | Booting"
+	if diff := cmp.Diff(want, read(len(want))); diff != "" {
+		t.Fatalf("unexpected bar output (-want +got):\n%s", diff)
+	}
+
+	write(fooR, "foo\r\n")
+	want = "\r\nfoo | foo\r\n"
+	if diff := cmp.Diff(want, read(len(want))); diff != "" {
+		t.Fatalf("unexpected interleaved output (-want +got):\n%s", diff)
+	}
+
+	// Input doesn't reach the devices, but Ctrl-C ends the session.
+	if _, err := stdin.Write([]byte("reboot\r\x03")); err != nil {
+		t.Fatalf("failed to write input: %v", err)
+	}
+	if err := s.Wait(); err != nil {
+		t.Fatalf("failed to wait for session: %v", err)
+	}
+}
+
+func TestSSHWatchCommand(t *testing.T) {
+	devices := map[string]*MuxDevice{
+		"foo":    NewMuxDevice(&testDevice{}),
+		"secret": NewMuxDevice(&testDevice{}),
+	}
+
+	_, addr := testServer(t, devices, map[string][]string{
+		"foo":    nil,
+		"secret": {"other"},
+	})
+
+	tests := []struct {
+		name, cmd string
+		want      *regexp.Regexp
+	}{
+		{
+			name: "unknown device",
+			cmd:  "watch foo bar",
+			want: regexp.MustCompile(`^consrv> watch: unknown device "bar"\n$`),
+		},
+		{
+			name: "forbidden device",
+			cmd:  "watch secret",
+			want: regexp.MustCompile(`^consrv> watch: unknown device "secret"\n$`),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, _ := testDial(t, addr, "consrv", mustKey(testHostPublic)).CombinedOutput(tt.cmd)
+			if !tt.want.Match(b) {
+				t.Fatalf("unexpected output for %q: %q", tt.cmd, b)
+			}
+		})
+	}
+}
+
+func Test_watchWriter(t *testing.T) {
+	type write struct {
+		device, s string
+	}
+
+	tests := []struct {
+		name   string
+		writes []write
+		want   string
+	}{
+		{
+			name: "lines",
+			writes: []write{
+				{"a", "one\r\ntwo\r\n"},
+				{"long", "three\n"},
+			},
+			want: "a    | one\r\na    | two\r\nlong | three\n",
+		},
+		{
+			name: "partial line continued",
+			writes: []write{
+				{"a", "on"},
+				{"a", "e\r\ntw"},
+				{"a", "o\r\n"},
+			},
+			want: "a    | one\r\na    | two\r\n",
+		},
+		{
+			name: "partial line interrupted",
+			writes: []write{
+				{"a", "one"},
+				{"long", "two\r\n"},
+				{"a", "three"},
+			},
+			want: "a    | one\r\nlong | two\r\na    | three",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			ww := &watchWriter{w: &buf, width: len("long")}
+			for _, w := range tt.writes {
+				if err := ww.write(w.device, []byte(w.s)); err != nil {
+					t.Fatalf("failed to write: %v", err)
+				}
+			}
+
+			if diff := cmp.Diff(tt.want, buf.String()); diff != "" {
+				t.Fatalf("unexpected output (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
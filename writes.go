@@ -0,0 +1,159 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consrv
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/gliderlabs/ssh"
+)
+
+// maxWriteGrant is the longest write access which may be granted to a
+// read-only device at once.
+const maxWriteGrant = 1 * time.Hour
+
+// writeGrants tracks the temporary write access of identities to devices
+// configured by ServerConfig.ReadOnly.
+type writeGrants struct {
+	mu  sync.Mutex
+	now func() time.Time
+	m   map[grantKey]*writeGrant
+}
+
+// A grantKey identifies an identity's write access to a device.
+type grantKey struct{ device, id string }
+
+// A writeGrant is write access which expires at until.
+type writeGrant struct {
+	until time.Time
+	timer *time.Timer
+}
+
+// grant grants identity id write access to device for d, replacing any
+// existing grant, and calls expired once it expires.
+func (wg *writeGrants) grant(device, id string, d time.Duration, expired func()) time.Time {
+	wg.mu.Lock()
+	defer wg.mu.Unlock()
+
+	if wg.m == nil {
+		wg.m = make(map[grantKey]*writeGrant)
+	}
+
+	k := grantKey{device: device, id: id}
+	if g, ok := wg.m[k]; ok {
+		g.timer.Stop()
+	}
+
+	g := &writeGrant{until: wg.now().Add(d)}
+	g.timer = time.AfterFunc(d, func() {
+		wg.mu.Lock()
+		// Only the current grant may be removed.
+		current := wg.m[k] == g
+		if current {
+			delete(wg.m, k)
+		}
+		wg.mu.Unlock()
+
+		if current {
+			expired()
+		}
+	})
+	wg.m[k] = g
+
+	return g.until
+}
+
+// allowed reports whether identity id currently has write access to device.
+func (wg *writeGrants) allowed(device, id string) bool {
+	wg.mu.Lock()
+	defer wg.mu.Unlock()
+
+	g, ok := wg.m[grantKey{device: device, id: id}]
+	return ok && wg.now().Before(g.until)
+}
+
+// grantWrite grants identity id write access to the read-only device for d,
+// and notifies the identity's sessions attached to device when the access is
+// granted and when it expires.
+func (s *Server) grantWrite(device, id string, d time.Duration) time.Time {
+	notifyID := func(format string, v ...any) {
+		var as []*attached
+		for _, a := range s.sessions.list(device) {
+			if a.id == id {
+				as = append(as, a)
+			}
+		}
+		notify(as, nil, format, v...)
+	}
+
+	until := s.writes.grant(device, id, d, func() {
+		s.ll.Printf("%s's write access to device %q expired", id, device)
+		notifyID("write access to %q expired, session is read-only", device)
+	})
+
+	s.ll.Printf("%s was granted write access to device %q until %s", id, device, until.Format(time.RFC3339))
+	notifyID("write access to %q granted until %s", device, formatUntil(until, s.writes.now()))
+	return until
+}
+
+// requestWrite handles the write command, which grants identity id write
+// access to a read-only device for a duration, after a second identity
+// approves it if the device is configured by ServerConfig.RequireApproval.
+func (s *Server) requestWrite(session ssh.Session, device, id string, d time.Duration) error {
+	if !s.readOnly[device] {
+		return fmt.Errorf("%q is not read-only", device)
+	}
+	if d <= 0 || d > maxWriteGrant {
+		return fmt.Errorf("write access must be granted for between 0 and %s", maxWriteGrant)
+	}
+
+	if s.approval[device] {
+		reason := fmt.Sprintf("write access for %s", d)
+		if err := s.awaitApproval(session, device, id, reason); err != nil {
+			return err
+		}
+	}
+
+	until := s.grantWrite(device, id, d)
+	fmt.Fprintf(session, "consrv> write access to %q granted until %s\n", device, formatUntil(until, s.writes.now()))
+	return nil
+}
+
+// A readOnlyWriter discards writes to a read-only device unless its session's
+// identity has write access, and calls denied on the first write discarded
+// since the session last had write access.
+type readOnlyWriter struct {
+	w       io.Writer
+	allowed func() bool
+	denied  func()
+	warned  bool
+}
+
+// Write implements io.Writer.
+func (w *readOnlyWriter) Write(b []byte) (int, error) {
+	if w.allowed() {
+		w.warned = false
+		return w.w.Write(b)
+	}
+
+	if !w.warned {
+		w.warned = true
+		w.denied()
+	}
+
+	return len(b), nil
+}
@@ -0,0 +1,194 @@
+// Copyright 2024 Matt Layher
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consrv
+
+import (
+	"bufio"
+	"io"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSSHReadOnly(t *testing.T) {
+	d := &testDevice{writeC: make(chan struct{})}
+	_, addr := testServe(t, ServerConfig{
+		HostKey: []byte(strings.TrimSpace(testHostPrivate)),
+		Devices: map[string]*MuxDevice{
+			"foo": NewMuxDevice(d),
+			"bar": NewMuxDevice(&testDevice{}),
+		},
+		ReadOnly: map[string]bool{"foo": true},
+		Identities: mustIdentities([]Identity{{
+			Name:      "test",
+			PublicKey: mustKey(testClientPublic),
+		}}, nil),
+	})
+
+	s := testDial(t, addr, "foo", mustKey(testHostPublic))
+	stdin, err := s.StdinPipe()
+	if err != nil {
+		t.Fatalf("failed to get stdin: %v", err)
+	}
+	stderr, err := s.StderrPipe()
+	if err != nil {
+		t.Fatalf("failed to get stderr: %v", err)
+	}
+	if err := s.Shell(); err != nil {
+		t.Fatalf("failed to start shell: %v", err)
+	}
+
+	// Notices are written on their own lines, so skip the blank lines around
+	// them and the banner.
+	br := bufio.NewReader(stderr)
+	readNotice := func() string {
+		t.Helper()
+
+		for {
+			line, err := br.ReadString('\n')
+			if err != nil {
+				t.Fatalf("failed to read line: %v", err)
+			}
+
+			line = strings.TrimSpace(line)
+			if line != "" && !strings.HasPrefix(line, "consrv> opened") {
+				return line
+			}
+		}
+	}
+
+	// Input is discarded until write access is granted.
+	if _, err := io.WriteString(stdin, "rm -rf /\r"); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+
+	const denied = `consrv> "foo" is read-only, request write access with: ssh consrv@<host> write foo <duration>`
+	if diff := cmp.Diff(denied, readNotice()); diff != "" {
+		t.Fatalf("unexpected notice (-want +got):\n%s", diff)
+	}
+
+	tests := []struct {
+		name, cmd string
+		want      *regexp.Regexp
+	}{
+		{
+			name: "not read-only",
+			cmd:  "write bar 10m",
+			want: regexp.MustCompile(`^consrv> write: "bar" is not read-only\n$`),
+		},
+		{
+			name: "too long",
+			cmd:  "write foo 2h",
+			want: regexp.MustCompile(`^consrv> write: write access must be granted for between 0 and 1h0m0s\n$`),
+		},
+		{
+			name: "bad duration",
+			cmd:  "write foo forever",
+			want: regexp.MustCompile(`^consrv> write: invalid duration "forever"\n$`),
+		},
+		{
+			name: "granted",
+			cmd:  "write foo 10m",
+			want: regexp.MustCompile(`^consrv> write access to "foo" granted until \d\d:\d\d \w+\n$`),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, _ := testDial(t, addr, "consrv", mustKey(testHostPublic)).CombinedOutput(tt.cmd)
+			if !tt.want.Match(b) {
+				t.Fatalf("unexpected output for %q: %q", tt.cmd, b)
+			}
+		})
+	}
+
+	if line := readNotice(); !regexp.MustCompile(`^consrv> write access to "foo" granted until `).MatchString(line) {
+		t.Fatalf("unexpected grant notice: %q", line)
+	}
+
+	if _, err := io.WriteString(stdin, "uptime\r"); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+	<-d.writeC
+
+	if diff := cmp.Diff("uptime\r", string(d.write)); diff != "" {
+		t.Fatalf("unexpected device write data (-want +got):\n%s", diff)
+	}
+}
+
+func Test_writeGrants(t *testing.T) {
+	wg := writeGrants{now: time.Now}
+
+	// A replaced grant never expires.
+	wg.grant("foo", "test", 1*time.Millisecond, func() { panic("replaced grant expired") })
+
+	expired := make(chan struct{})
+	wg.grant("foo", "test", 50*time.Millisecond, func() { close(expired) })
+
+	if !wg.allowed("foo", "test") {
+		t.Fatal("expected write access to foo")
+	}
+	if wg.allowed("foo", "other") || wg.allowed("bar", "test") {
+		t.Fatal("unexpected write access for another device or identity")
+	}
+
+	<-expired
+	if wg.allowed("foo", "test") {
+		t.Fatal("write access did not expire")
+	}
+}
+
+func Test_readOnlyWriter(t *testing.T) {
+	var (
+		out     strings.Builder
+		allowed bool
+		denied  int
+	)
+
+	w := &readOnlyWriter{
+		w:       &out,
+		allowed: func() bool { return allowed },
+		denied:  func() { denied++ },
+	}
+
+	write := func(s string) {
+		t.Helper()
+
+		n, err := w.Write([]byte(s))
+		if err != nil {
+			t.Fatalf("failed to write: %v", err)
+		}
+		if diff := cmp.Diff(len(s), n); diff != "" {
+			t.Fatalf("unexpected write length (-want +got):\n%s", diff)
+		}
+	}
+
+	// Each downgrade warns once.
+	write("a")
+	write("b")
+	allowed = true
+	write("c")
+	allowed = false
+	write("d")
+
+	if diff := cmp.Diff("c", out.String()); diff != "" {
+		t.Fatalf("unexpected output (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(2, denied); diff != "" {
+		t.Fatalf("unexpected denials (-want +got):\n%s", diff)
+	}
+}